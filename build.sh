@@ -1,4 +1,4 @@
 #!/bin/bash
 go build \
-    -ldflags "-X github.com/XevoInc/chisel/share.BuildVersion=$(git describe --abbrev=0 --tags)" \
+    -ldflags "-X github.com/XevoInc/chisel/share.BuildVersion=$(git describe --abbrev=0 --tags) -X github.com/XevoInc/chisel/share.BuildCommit=$(git rev-parse HEAD)" \
     -o chisel
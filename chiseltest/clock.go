@@ -0,0 +1,172 @@
+// Package chiseltest provides a deterministic clock and fault injection for testing chisel
+// clients and servers. Pass a FakeClock as the Clock, and Faults as the Faults, of a client's
+// chshare.Config and a server's chshare.ProxyServerConfig, then advance the clock and inject
+// faults to drive reconnection, timeout and resumption logic step by step:
+//
+//	clock := chiseltest.NewFakeClock(time.Now())
+//	faults := chiseltest.NewFaults()
+//	client, _ := chshare.NewClient(&chshare.Config{..., Clock: clock, Faults: faults})
+//	...
+//	faults.ResetTransports(chshare.FaultSideClient) // the websocket is reset
+//	clock.BlockUntil(1)                             // the client waits to reconnect
+//	clock.Advance(time.Second)                      // and reconnects
+package chiseltest
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	chshare "github.com/XevoInc/chisel/share"
+)
+
+// FakeClock is a chshare.Clock whose time only moves when Advance is called. Timers fire, in
+// order of their deadlines, as Advance passes them.
+type FakeClock struct {
+	// lock protects now, timers and waiters
+	lock    sync.Mutex
+	now     time.Time
+	timers  []*fakeTimer
+	waiters []*blockWaiter
+}
+
+// fakeTimer is a timer of a FakeClock, which either sends to ch or calls f when it fires
+type fakeTimer struct {
+	clock    *FakeClock
+	deadline time.Time
+	ch       chan time.Time
+	f        func()
+}
+
+// blockWaiter is a call to BlockUntil waiting for timers to be started
+type blockWaiter struct {
+	n    int
+	done chan struct{}
+}
+
+// NewFakeClock creates a new FakeClock set to start
+func NewFakeClock(start time.Time) *FakeClock {
+	return &FakeClock{now: start}
+}
+
+// Now returns the clock's current time
+func (c *FakeClock) Now() time.Time {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.now
+}
+
+// After returns a chan that receives the clock's time once it has been advanced by d
+func (c *FakeClock) After(d time.Duration) <-chan time.Time {
+	t := &fakeTimer{ch: make(chan time.Time, 1)}
+	c.start(t, d)
+	return t.ch
+}
+
+// AfterFunc calls f in its own goroutine once the clock has been advanced by d, unless the
+// returned timer is stopped first
+func (c *FakeClock) AfterFunc(d time.Duration, f func()) chshare.ClockTimer {
+	t := &fakeTimer{f: f}
+	c.start(t, d)
+	return t
+}
+
+// start adds a timer that fires after d, or straight away if d is not positive
+func (c *FakeClock) start(t *fakeTimer, d time.Duration) {
+	c.lock.Lock()
+	t.clock = c
+	t.deadline = c.now.Add(d)
+	if d <= 0 {
+		now := c.now
+		c.lock.Unlock()
+		t.fire(now)
+		return
+	}
+	c.timers = append(c.timers, t)
+	c.notifyWaitersLocked()
+	c.lock.Unlock()
+}
+
+// Stop prevents the timer from firing, and returns false if it has already fired or been
+// stopped
+func (t *fakeTimer) Stop() bool {
+	c := t.clock
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	for i, other := range c.timers {
+		if other == t {
+			c.timers = append(c.timers[:i], c.timers[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+func (t *fakeTimer) fire(now time.Time) {
+	if t.f != nil {
+		go t.f()
+	} else {
+		t.ch <- now
+	}
+}
+
+// Advance moves the clock forward by d, firing the timers whose deadlines it passes, in
+// order. The clock reads each timer's deadline as it fires.
+func (c *FakeClock) Advance(d time.Duration) {
+	c.lock.Lock()
+	end := c.now.Add(d)
+	for {
+		sort.SliceStable(c.timers, func(i, j int) bool {
+			return c.timers[i].deadline.Before(c.timers[j].deadline)
+		})
+		if len(c.timers) == 0 || c.timers[0].deadline.After(end) {
+			break
+		}
+		t := c.timers[0]
+		c.timers = c.timers[1:]
+		if t.deadline.After(c.now) {
+			c.now = t.deadline
+		}
+		now := c.now
+		c.lock.Unlock()
+		t.fire(now)
+		c.lock.Lock()
+	}
+	c.now = end
+	c.lock.Unlock()
+}
+
+// Pending returns the number of timers waiting to fire
+func (c *FakeClock) Pending() int {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return len(c.timers)
+}
+
+// BlockUntil waits until at least n timers are waiting to fire, e.g. until the goroutines
+// under test have started waiting on the clock, so that the next Advance is seen by them
+func (c *FakeClock) BlockUntil(n int) {
+	c.lock.Lock()
+	if len(c.timers) >= n {
+		c.lock.Unlock()
+		return
+	}
+	w := &blockWaiter{n: n, done: make(chan struct{})}
+	c.waiters = append(c.waiters, w)
+	c.lock.Unlock()
+	<-w.done
+}
+
+// notifyWaitersLocked releases the BlockUntil calls that have enough timers. The clock's lock
+// must be held.
+func (c *FakeClock) notifyWaitersLocked() {
+	waiters := c.waiters[:0]
+	for _, w := range c.waiters {
+		if len(c.timers) >= w.n {
+			close(w.done)
+		} else {
+			waiters = append(waiters, w)
+		}
+	}
+	c.waiters = waiters
+}
@@ -0,0 +1,136 @@
+package chiseltest
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	chshare "github.com/XevoInc/chisel/share"
+)
+
+// ErrInjectedDialFailure is the default error of dials failed with FailDials
+var ErrInjectedDialFailure = errors.New("Injected dial failure")
+
+// Faults is a chshare.FaultInjector whose faults are scripted by the test: dials to the
+// server can be made to fail, SSH handshakes delayed, and live transports reset, as if a
+// websocket were dropped by the network.
+type Faults struct {
+	// lock protects the fields below
+	lock sync.Mutex
+
+	// dialFailures is the number of dials still to fail, with dialErr
+	dialFailures int
+	dialErr      error
+	dials        int
+
+	handshakeDelays map[chshare.FaultSide]time.Duration
+
+	// transports are the live transports on each side
+	transports map[chshare.FaultSide]map[*resettableConn]struct{}
+}
+
+// NewFaults creates a new Faults that injects no faults until told to
+func NewFaults() *Faults {
+	return &Faults{
+		handshakeDelays: make(map[chshare.FaultSide]time.Duration),
+		transports:      make(map[chshare.FaultSide]map[*resettableConn]struct{}),
+	}
+}
+
+// FailDials makes the next n dials to the server fail with err, or with
+// ErrInjectedDialFailure if err is nil
+func (f *Faults) FailDials(n int, err error) {
+	if err == nil {
+		err = ErrInjectedDialFailure
+	}
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.dialFailures = n
+	f.dialErr = err
+}
+
+// Dials returns the number of dials to the server attempted so far, including failed ones
+func (f *Faults) Dials() int {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return f.dials
+}
+
+// DelayHandshakes delays the SSH handshake of new connections on side by d, as measured by
+// the client's or server's Clock. A delay of 0 removes the delay.
+func (f *Faults) DelayHandshakes(side chshare.FaultSide, d time.Duration) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.handshakeDelays[side] = d
+}
+
+// ResetTransports closes every live transport on side, as if the network had dropped the
+// connections, and returns the number closed
+func (f *Faults) ResetTransports(side chshare.FaultSide) int {
+	f.lock.Lock()
+	var conns []*resettableConn
+	for conn := range f.transports[side] {
+		conns = append(conns, conn)
+	}
+	f.lock.Unlock()
+	for _, conn := range conns {
+		conn.Close()
+	}
+	return len(conns)
+}
+
+// Transports returns the number of live transports on side
+func (f *Faults) Transports(side chshare.FaultSide) int {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return len(f.transports[side])
+}
+
+// DialFault fails the dial if FailDials has dials left to fail. Part of the
+// chshare.FaultInjector interface.
+func (f *Faults) DialFault(server string) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.dials++
+	if f.dialFailures <= 0 {
+		return nil
+	}
+	f.dialFailures--
+	return f.dialErr
+}
+
+// HandshakeDelay returns the delay set with DelayHandshakes. Part of the
+// chshare.FaultInjector interface.
+func (f *Faults) HandshakeDelay(side chshare.FaultSide) time.Duration {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return f.handshakeDelays[side]
+}
+
+// WrapTransport tracks transport until it is closed, so that ResetTransports can close it.
+// Part of the chshare.FaultInjector interface.
+func (f *Faults) WrapTransport(side chshare.FaultSide, transport net.Conn) net.Conn {
+	conn := &resettableConn{Conn: transport, faults: f, side: side}
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if f.transports[side] == nil {
+		f.transports[side] = make(map[*resettableConn]struct{})
+	}
+	f.transports[side][conn] = struct{}{}
+	return conn
+}
+
+// resettableConn is a transport tracked by Faults
+type resettableConn struct {
+	net.Conn
+	faults *Faults
+	side   chshare.FaultSide
+}
+
+func (c *resettableConn) Close() error {
+	c.faults.lock.Lock()
+	delete(c.faults.transports[c.side], c)
+	c.faults.lock.Unlock()
+	return c.Conn.Close()
+}
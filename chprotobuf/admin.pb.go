@@ -0,0 +1,2355 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// source: admin.proto
+
+package chprotobuf
+
+import (
+	context "context"
+	fmt "fmt"
+	proto "github.com/golang/protobuf/proto"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+	math "math"
+)
+
+// Reference imports to suppress errors if they are not otherwise used.
+var _ = proto.Marshal
+var _ = fmt.Errorf
+var _ = math.Inf
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the proto package it is being compiled against.
+// A compilation error at this line likely means your copy of the
+// proto package needs to be updated.
+const _ = proto.ProtoPackageIsVersion3 // please upgrade the proto package
+
+type PbAdminSession struct {
+	Id                   int32    `protobuf:"varint,1,opt,name=Id,json=id,proto3" json:"Id,omitempty"`
+	User                 string   `protobuf:"bytes,2,opt,name=User,json=user,proto3" json:"User,omitempty"`
+	RemoteAddr           string   `protobuf:"bytes,3,opt,name=RemoteAddr,json=remoteAddr,proto3" json:"RemoteAddr,omitempty"`
+	StartTimeUnix        int64    `protobuf:"varint,4,opt,name=StartTimeUnix,json=startTimeUnix,proto3" json:"StartTimeUnix,omitempty"`
+	ClientVersion        string   `protobuf:"bytes,5,opt,name=ClientVersion,json=clientVersion,proto3" json:"ClientVersion,omitempty"`
+	ChannelDescriptors   []string `protobuf:"bytes,6,rep,name=ChannelDescriptors,json=channelDescriptors,proto3" json:"ChannelDescriptors,omitempty"`
+	SessionName          string   `protobuf:"bytes,7,opt,name=SessionName,json=sessionName,proto3" json:"SessionName,omitempty"`
+	DeviceFingerprint    string   `protobuf:"bytes,8,opt,name=DeviceFingerprint,json=deviceFingerprint,proto3" json:"DeviceFingerprint,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *PbAdminSession) Reset()         { *m = PbAdminSession{} }
+func (m *PbAdminSession) String() string { return proto.CompactTextString(m) }
+func (*PbAdminSession) ProtoMessage()    {}
+func (*PbAdminSession) Descriptor() ([]byte, []int) {
+	return fileDescriptor_73a7fc70dcc2027c, []int{0}
+}
+
+func (m *PbAdminSession) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_PbAdminSession.Unmarshal(m, b)
+}
+func (m *PbAdminSession) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_PbAdminSession.Marshal(b, m, deterministic)
+}
+func (m *PbAdminSession) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_PbAdminSession.Merge(m, src)
+}
+func (m *PbAdminSession) XXX_Size() int {
+	return xxx_messageInfo_PbAdminSession.Size(m)
+}
+func (m *PbAdminSession) XXX_DiscardUnknown() {
+	xxx_messageInfo_PbAdminSession.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_PbAdminSession proto.InternalMessageInfo
+
+func (m *PbAdminSession) GetId() int32 {
+	if m != nil {
+		return m.Id
+	}
+	return 0
+}
+
+func (m *PbAdminSession) GetUser() string {
+	if m != nil {
+		return m.User
+	}
+	return ""
+}
+
+func (m *PbAdminSession) GetRemoteAddr() string {
+	if m != nil {
+		return m.RemoteAddr
+	}
+	return ""
+}
+
+func (m *PbAdminSession) GetStartTimeUnix() int64 {
+	if m != nil {
+		return m.StartTimeUnix
+	}
+	return 0
+}
+
+func (m *PbAdminSession) GetClientVersion() string {
+	if m != nil {
+		return m.ClientVersion
+	}
+	return ""
+}
+
+func (m *PbAdminSession) GetChannelDescriptors() []string {
+	if m != nil {
+		return m.ChannelDescriptors
+	}
+	return nil
+}
+
+func (m *PbAdminSession) GetSessionName() string {
+	if m != nil {
+		return m.SessionName
+	}
+	return ""
+}
+
+func (m *PbAdminSession) GetDeviceFingerprint() string {
+	if m != nil {
+		return m.DeviceFingerprint
+	}
+	return ""
+}
+
+type PbListSessionsRequest struct {
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *PbListSessionsRequest) Reset()         { *m = PbListSessionsRequest{} }
+func (m *PbListSessionsRequest) String() string { return proto.CompactTextString(m) }
+func (*PbListSessionsRequest) ProtoMessage()    {}
+func (*PbListSessionsRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_73a7fc70dcc2027c, []int{1}
+}
+
+func (m *PbListSessionsRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_PbListSessionsRequest.Unmarshal(m, b)
+}
+func (m *PbListSessionsRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_PbListSessionsRequest.Marshal(b, m, deterministic)
+}
+func (m *PbListSessionsRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_PbListSessionsRequest.Merge(m, src)
+}
+func (m *PbListSessionsRequest) XXX_Size() int {
+	return xxx_messageInfo_PbListSessionsRequest.Size(m)
+}
+func (m *PbListSessionsRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_PbListSessionsRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_PbListSessionsRequest proto.InternalMessageInfo
+
+type PbListSessionsResponse struct {
+	Sessions             []*PbAdminSession `protobuf:"bytes,1,rep,name=Sessions,json=sessions,proto3" json:"Sessions,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}          `json:"-"`
+	XXX_unrecognized     []byte            `json:"-"`
+	XXX_sizecache        int32             `json:"-"`
+}
+
+func (m *PbListSessionsResponse) Reset()         { *m = PbListSessionsResponse{} }
+func (m *PbListSessionsResponse) String() string { return proto.CompactTextString(m) }
+func (*PbListSessionsResponse) ProtoMessage()    {}
+func (*PbListSessionsResponse) Descriptor() ([]byte, []int) {
+	return fileDescriptor_73a7fc70dcc2027c, []int{2}
+}
+
+func (m *PbListSessionsResponse) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_PbListSessionsResponse.Unmarshal(m, b)
+}
+func (m *PbListSessionsResponse) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_PbListSessionsResponse.Marshal(b, m, deterministic)
+}
+func (m *PbListSessionsResponse) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_PbListSessionsResponse.Merge(m, src)
+}
+func (m *PbListSessionsResponse) XXX_Size() int {
+	return xxx_messageInfo_PbListSessionsResponse.Size(m)
+}
+func (m *PbListSessionsResponse) XXX_DiscardUnknown() {
+	xxx_messageInfo_PbListSessionsResponse.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_PbListSessionsResponse proto.InternalMessageInfo
+
+func (m *PbListSessionsResponse) GetSessions() []*PbAdminSession {
+	if m != nil {
+		return m.Sessions
+	}
+	return nil
+}
+
+type PbKillSessionRequest struct {
+	Id                   int32    `protobuf:"varint,1,opt,name=Id,json=id,proto3" json:"Id,omitempty"`
+	Reason               string   `protobuf:"bytes,2,opt,name=Reason,json=reason,proto3" json:"Reason,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *PbKillSessionRequest) Reset()         { *m = PbKillSessionRequest{} }
+func (m *PbKillSessionRequest) String() string { return proto.CompactTextString(m) }
+func (*PbKillSessionRequest) ProtoMessage()    {}
+func (*PbKillSessionRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_73a7fc70dcc2027c, []int{3}
+}
+
+func (m *PbKillSessionRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_PbKillSessionRequest.Unmarshal(m, b)
+}
+func (m *PbKillSessionRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_PbKillSessionRequest.Marshal(b, m, deterministic)
+}
+func (m *PbKillSessionRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_PbKillSessionRequest.Merge(m, src)
+}
+func (m *PbKillSessionRequest) XXX_Size() int {
+	return xxx_messageInfo_PbKillSessionRequest.Size(m)
+}
+func (m *PbKillSessionRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_PbKillSessionRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_PbKillSessionRequest proto.InternalMessageInfo
+
+func (m *PbKillSessionRequest) GetId() int32 {
+	if m != nil {
+		return m.Id
+	}
+	return 0
+}
+
+func (m *PbKillSessionRequest) GetReason() string {
+	if m != nil {
+		return m.Reason
+	}
+	return ""
+}
+
+type PbKillSessionResponse struct {
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *PbKillSessionResponse) Reset()         { *m = PbKillSessionResponse{} }
+func (m *PbKillSessionResponse) String() string { return proto.CompactTextString(m) }
+func (*PbKillSessionResponse) ProtoMessage()    {}
+func (*PbKillSessionResponse) Descriptor() ([]byte, []int) {
+	return fileDescriptor_73a7fc70dcc2027c, []int{4}
+}
+
+func (m *PbKillSessionResponse) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_PbKillSessionResponse.Unmarshal(m, b)
+}
+func (m *PbKillSessionResponse) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_PbKillSessionResponse.Marshal(b, m, deterministic)
+}
+func (m *PbKillSessionResponse) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_PbKillSessionResponse.Merge(m, src)
+}
+func (m *PbKillSessionResponse) XXX_Size() int {
+	return xxx_messageInfo_PbKillSessionResponse.Size(m)
+}
+func (m *PbKillSessionResponse) XXX_DiscardUnknown() {
+	xxx_messageInfo_PbKillSessionResponse.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_PbKillSessionResponse proto.InternalMessageInfo
+
+type PbNotifySessionsRequest struct {
+	Message              string   `protobuf:"bytes,1,opt,name=Message,json=message,proto3" json:"Message,omitempty"`
+	ReconnectByUnix      int64    `protobuf:"varint,2,opt,name=ReconnectByUnix,json=reconnectByUnix,proto3" json:"ReconnectByUnix,omitempty"`
+	SessionIds           []int32  `protobuf:"varint,3,rep,packed,name=SessionIds,json=sessionIds,proto3" json:"SessionIds,omitempty"`
+	User                 string   `protobuf:"bytes,4,opt,name=User,json=user,proto3" json:"User,omitempty"`
+	SessionName          string   `protobuf:"bytes,5,opt,name=SessionName,json=sessionName,proto3" json:"SessionName,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *PbNotifySessionsRequest) Reset()         { *m = PbNotifySessionsRequest{} }
+func (m *PbNotifySessionsRequest) String() string { return proto.CompactTextString(m) }
+func (*PbNotifySessionsRequest) ProtoMessage()    {}
+func (*PbNotifySessionsRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_73a7fc70dcc2027c, []int{5}
+}
+
+func (m *PbNotifySessionsRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_PbNotifySessionsRequest.Unmarshal(m, b)
+}
+func (m *PbNotifySessionsRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_PbNotifySessionsRequest.Marshal(b, m, deterministic)
+}
+func (m *PbNotifySessionsRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_PbNotifySessionsRequest.Merge(m, src)
+}
+func (m *PbNotifySessionsRequest) XXX_Size() int {
+	return xxx_messageInfo_PbNotifySessionsRequest.Size(m)
+}
+func (m *PbNotifySessionsRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_PbNotifySessionsRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_PbNotifySessionsRequest proto.InternalMessageInfo
+
+func (m *PbNotifySessionsRequest) GetMessage() string {
+	if m != nil {
+		return m.Message
+	}
+	return ""
+}
+
+func (m *PbNotifySessionsRequest) GetReconnectByUnix() int64 {
+	if m != nil {
+		return m.ReconnectByUnix
+	}
+	return 0
+}
+
+func (m *PbNotifySessionsRequest) GetSessionIds() []int32 {
+	if m != nil {
+		return m.SessionIds
+	}
+	return nil
+}
+
+func (m *PbNotifySessionsRequest) GetUser() string {
+	if m != nil {
+		return m.User
+	}
+	return ""
+}
+
+func (m *PbNotifySessionsRequest) GetSessionName() string {
+	if m != nil {
+		return m.SessionName
+	}
+	return ""
+}
+
+type PbNotifySessionsResponse struct {
+	NotifiedIds          []int32  `protobuf:"varint,1,rep,packed,name=NotifiedIds,json=notifiedIds,proto3" json:"NotifiedIds,omitempty"`
+	UnacknowledgedIds    []int32  `protobuf:"varint,2,rep,packed,name=UnacknowledgedIds,json=unacknowledgedIds,proto3" json:"UnacknowledgedIds,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *PbNotifySessionsResponse) Reset()         { *m = PbNotifySessionsResponse{} }
+func (m *PbNotifySessionsResponse) String() string { return proto.CompactTextString(m) }
+func (*PbNotifySessionsResponse) ProtoMessage()    {}
+func (*PbNotifySessionsResponse) Descriptor() ([]byte, []int) {
+	return fileDescriptor_73a7fc70dcc2027c, []int{6}
+}
+
+func (m *PbNotifySessionsResponse) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_PbNotifySessionsResponse.Unmarshal(m, b)
+}
+func (m *PbNotifySessionsResponse) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_PbNotifySessionsResponse.Marshal(b, m, deterministic)
+}
+func (m *PbNotifySessionsResponse) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_PbNotifySessionsResponse.Merge(m, src)
+}
+func (m *PbNotifySessionsResponse) XXX_Size() int {
+	return xxx_messageInfo_PbNotifySessionsResponse.Size(m)
+}
+func (m *PbNotifySessionsResponse) XXX_DiscardUnknown() {
+	xxx_messageInfo_PbNotifySessionsResponse.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_PbNotifySessionsResponse proto.InternalMessageInfo
+
+func (m *PbNotifySessionsResponse) GetNotifiedIds() []int32 {
+	if m != nil {
+		return m.NotifiedIds
+	}
+	return nil
+}
+
+func (m *PbNotifySessionsResponse) GetUnacknowledgedIds() []int32 {
+	if m != nil {
+		return m.UnacknowledgedIds
+	}
+	return nil
+}
+
+type PbDrainRequest struct {
+	Drain                bool     `protobuf:"varint,1,opt,name=Drain,json=drain,proto3" json:"Drain,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *PbDrainRequest) Reset()         { *m = PbDrainRequest{} }
+func (m *PbDrainRequest) String() string { return proto.CompactTextString(m) }
+func (*PbDrainRequest) ProtoMessage()    {}
+func (*PbDrainRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_73a7fc70dcc2027c, []int{7}
+}
+
+func (m *PbDrainRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_PbDrainRequest.Unmarshal(m, b)
+}
+func (m *PbDrainRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_PbDrainRequest.Marshal(b, m, deterministic)
+}
+func (m *PbDrainRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_PbDrainRequest.Merge(m, src)
+}
+func (m *PbDrainRequest) XXX_Size() int {
+	return xxx_messageInfo_PbDrainRequest.Size(m)
+}
+func (m *PbDrainRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_PbDrainRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_PbDrainRequest proto.InternalMessageInfo
+
+func (m *PbDrainRequest) GetDrain() bool {
+	if m != nil {
+		return m.Drain
+	}
+	return false
+}
+
+type PbDrainResponse struct {
+	ActiveSessions       int32    `protobuf:"varint,1,opt,name=ActiveSessions,json=activeSessions,proto3" json:"ActiveSessions,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *PbDrainResponse) Reset()         { *m = PbDrainResponse{} }
+func (m *PbDrainResponse) String() string { return proto.CompactTextString(m) }
+func (*PbDrainResponse) ProtoMessage()    {}
+func (*PbDrainResponse) Descriptor() ([]byte, []int) {
+	return fileDescriptor_73a7fc70dcc2027c, []int{8}
+}
+
+func (m *PbDrainResponse) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_PbDrainResponse.Unmarshal(m, b)
+}
+func (m *PbDrainResponse) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_PbDrainResponse.Marshal(b, m, deterministic)
+}
+func (m *PbDrainResponse) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_PbDrainResponse.Merge(m, src)
+}
+func (m *PbDrainResponse) XXX_Size() int {
+	return xxx_messageInfo_PbDrainResponse.Size(m)
+}
+func (m *PbDrainResponse) XXX_DiscardUnknown() {
+	xxx_messageInfo_PbDrainResponse.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_PbDrainResponse proto.InternalMessageInfo
+
+func (m *PbDrainResponse) GetActiveSessions() int32 {
+	if m != nil {
+		return m.ActiveSessions
+	}
+	return 0
+}
+
+type PbAdminUser struct {
+	Name                 string   `protobuf:"bytes,1,opt,name=Name,json=name,proto3" json:"Name,omitempty"`
+	Addrs                []string `protobuf:"bytes,2,rep,name=Addrs,json=addrs,proto3" json:"Addrs,omitempty"`
+	MaxSessions          int32    `protobuf:"varint,3,opt,name=MaxSessions,json=maxSessions,proto3" json:"MaxSessions,omitempty"`
+	Kick                 bool     `protobuf:"varint,4,opt,name=Kick,json=kick,proto3" json:"Kick,omitempty"`
+	CertAuth             bool     `protobuf:"varint,5,opt,name=CertAuth,json=certAuth,proto3" json:"CertAuth,omitempty"`
+	Pending              bool     `protobuf:"varint,6,opt,name=Pending,json=pending,proto3" json:"Pending,omitempty"`
+	MaxChannelBytes      int64    `protobuf:"varint,7,opt,name=MaxChannelBytes,json=maxChannelBytes,proto3" json:"MaxChannelBytes,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *PbAdminUser) Reset()         { *m = PbAdminUser{} }
+func (m *PbAdminUser) String() string { return proto.CompactTextString(m) }
+func (*PbAdminUser) ProtoMessage()    {}
+func (*PbAdminUser) Descriptor() ([]byte, []int) {
+	return fileDescriptor_73a7fc70dcc2027c, []int{9}
+}
+
+func (m *PbAdminUser) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_PbAdminUser.Unmarshal(m, b)
+}
+func (m *PbAdminUser) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_PbAdminUser.Marshal(b, m, deterministic)
+}
+func (m *PbAdminUser) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_PbAdminUser.Merge(m, src)
+}
+func (m *PbAdminUser) XXX_Size() int {
+	return xxx_messageInfo_PbAdminUser.Size(m)
+}
+func (m *PbAdminUser) XXX_DiscardUnknown() {
+	xxx_messageInfo_PbAdminUser.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_PbAdminUser proto.InternalMessageInfo
+
+func (m *PbAdminUser) GetName() string {
+	if m != nil {
+		return m.Name
+	}
+	return ""
+}
+
+func (m *PbAdminUser) GetAddrs() []string {
+	if m != nil {
+		return m.Addrs
+	}
+	return nil
+}
+
+func (m *PbAdminUser) GetMaxSessions() int32 {
+	if m != nil {
+		return m.MaxSessions
+	}
+	return 0
+}
+
+func (m *PbAdminUser) GetKick() bool {
+	if m != nil {
+		return m.Kick
+	}
+	return false
+}
+
+func (m *PbAdminUser) GetCertAuth() bool {
+	if m != nil {
+		return m.CertAuth
+	}
+	return false
+}
+
+func (m *PbAdminUser) GetPending() bool {
+	if m != nil {
+		return m.Pending
+	}
+	return false
+}
+
+func (m *PbAdminUser) GetMaxChannelBytes() int64 {
+	if m != nil {
+		return m.MaxChannelBytes
+	}
+	return 0
+}
+
+type PbListUsersRequest struct {
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *PbListUsersRequest) Reset()         { *m = PbListUsersRequest{} }
+func (m *PbListUsersRequest) String() string { return proto.CompactTextString(m) }
+func (*PbListUsersRequest) ProtoMessage()    {}
+func (*PbListUsersRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_73a7fc70dcc2027c, []int{10}
+}
+
+func (m *PbListUsersRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_PbListUsersRequest.Unmarshal(m, b)
+}
+func (m *PbListUsersRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_PbListUsersRequest.Marshal(b, m, deterministic)
+}
+func (m *PbListUsersRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_PbListUsersRequest.Merge(m, src)
+}
+func (m *PbListUsersRequest) XXX_Size() int {
+	return xxx_messageInfo_PbListUsersRequest.Size(m)
+}
+func (m *PbListUsersRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_PbListUsersRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_PbListUsersRequest proto.InternalMessageInfo
+
+type PbListUsersResponse struct {
+	Users                []*PbAdminUser `protobuf:"bytes,1,rep,name=Users,json=users,proto3" json:"Users,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}       `json:"-"`
+	XXX_unrecognized     []byte         `json:"-"`
+	XXX_sizecache        int32          `json:"-"`
+}
+
+func (m *PbListUsersResponse) Reset()         { *m = PbListUsersResponse{} }
+func (m *PbListUsersResponse) String() string { return proto.CompactTextString(m) }
+func (*PbListUsersResponse) ProtoMessage()    {}
+func (*PbListUsersResponse) Descriptor() ([]byte, []int) {
+	return fileDescriptor_73a7fc70dcc2027c, []int{11}
+}
+
+func (m *PbListUsersResponse) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_PbListUsersResponse.Unmarshal(m, b)
+}
+func (m *PbListUsersResponse) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_PbListUsersResponse.Marshal(b, m, deterministic)
+}
+func (m *PbListUsersResponse) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_PbListUsersResponse.Merge(m, src)
+}
+func (m *PbListUsersResponse) XXX_Size() int {
+	return xxx_messageInfo_PbListUsersResponse.Size(m)
+}
+func (m *PbListUsersResponse) XXX_DiscardUnknown() {
+	xxx_messageInfo_PbListUsersResponse.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_PbListUsersResponse proto.InternalMessageInfo
+
+func (m *PbListUsersResponse) GetUsers() []*PbAdminUser {
+	if m != nil {
+		return m.Users
+	}
+	return nil
+}
+
+type PbSetUserRequest struct {
+	Name                 string   `protobuf:"bytes,1,opt,name=Name,json=name,proto3" json:"Name,omitempty"`
+	Password             string   `protobuf:"bytes,2,opt,name=Password,json=password,proto3" json:"Password,omitempty"`
+	Addrs                []string `protobuf:"bytes,3,rep,name=Addrs,json=addrs,proto3" json:"Addrs,omitempty"`
+	MaxSessions          int32    `protobuf:"varint,4,opt,name=MaxSessions,json=maxSessions,proto3" json:"MaxSessions,omitempty"`
+	Kick                 bool     `protobuf:"varint,5,opt,name=Kick,json=kick,proto3" json:"Kick,omitempty"`
+	MaxChannelBytes      int64    `protobuf:"varint,6,opt,name=MaxChannelBytes,json=maxChannelBytes,proto3" json:"MaxChannelBytes,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *PbSetUserRequest) Reset()         { *m = PbSetUserRequest{} }
+func (m *PbSetUserRequest) String() string { return proto.CompactTextString(m) }
+func (*PbSetUserRequest) ProtoMessage()    {}
+func (*PbSetUserRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_73a7fc70dcc2027c, []int{12}
+}
+
+func (m *PbSetUserRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_PbSetUserRequest.Unmarshal(m, b)
+}
+func (m *PbSetUserRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_PbSetUserRequest.Marshal(b, m, deterministic)
+}
+func (m *PbSetUserRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_PbSetUserRequest.Merge(m, src)
+}
+func (m *PbSetUserRequest) XXX_Size() int {
+	return xxx_messageInfo_PbSetUserRequest.Size(m)
+}
+func (m *PbSetUserRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_PbSetUserRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_PbSetUserRequest proto.InternalMessageInfo
+
+func (m *PbSetUserRequest) GetName() string {
+	if m != nil {
+		return m.Name
+	}
+	return ""
+}
+
+func (m *PbSetUserRequest) GetPassword() string {
+	if m != nil {
+		return m.Password
+	}
+	return ""
+}
+
+func (m *PbSetUserRequest) GetAddrs() []string {
+	if m != nil {
+		return m.Addrs
+	}
+	return nil
+}
+
+func (m *PbSetUserRequest) GetMaxSessions() int32 {
+	if m != nil {
+		return m.MaxSessions
+	}
+	return 0
+}
+
+func (m *PbSetUserRequest) GetKick() bool {
+	if m != nil {
+		return m.Kick
+	}
+	return false
+}
+
+func (m *PbSetUserRequest) GetMaxChannelBytes() int64 {
+	if m != nil {
+		return m.MaxChannelBytes
+	}
+	return 0
+}
+
+type PbSetUserResponse struct {
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *PbSetUserResponse) Reset()         { *m = PbSetUserResponse{} }
+func (m *PbSetUserResponse) String() string { return proto.CompactTextString(m) }
+func (*PbSetUserResponse) ProtoMessage()    {}
+func (*PbSetUserResponse) Descriptor() ([]byte, []int) {
+	return fileDescriptor_73a7fc70dcc2027c, []int{13}
+}
+
+func (m *PbSetUserResponse) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_PbSetUserResponse.Unmarshal(m, b)
+}
+func (m *PbSetUserResponse) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_PbSetUserResponse.Marshal(b, m, deterministic)
+}
+func (m *PbSetUserResponse) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_PbSetUserResponse.Merge(m, src)
+}
+func (m *PbSetUserResponse) XXX_Size() int {
+	return xxx_messageInfo_PbSetUserResponse.Size(m)
+}
+func (m *PbSetUserResponse) XXX_DiscardUnknown() {
+	xxx_messageInfo_PbSetUserResponse.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_PbSetUserResponse proto.InternalMessageInfo
+
+type PbSetUserACLRequest struct {
+	Name                 string   `protobuf:"bytes,1,opt,name=Name,json=name,proto3" json:"Name,omitempty"`
+	Addrs                []string `protobuf:"bytes,2,rep,name=Addrs,json=addrs,proto3" json:"Addrs,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *PbSetUserACLRequest) Reset()         { *m = PbSetUserACLRequest{} }
+func (m *PbSetUserACLRequest) String() string { return proto.CompactTextString(m) }
+func (*PbSetUserACLRequest) ProtoMessage()    {}
+func (*PbSetUserACLRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_73a7fc70dcc2027c, []int{14}
+}
+
+func (m *PbSetUserACLRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_PbSetUserACLRequest.Unmarshal(m, b)
+}
+func (m *PbSetUserACLRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_PbSetUserACLRequest.Marshal(b, m, deterministic)
+}
+func (m *PbSetUserACLRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_PbSetUserACLRequest.Merge(m, src)
+}
+func (m *PbSetUserACLRequest) XXX_Size() int {
+	return xxx_messageInfo_PbSetUserACLRequest.Size(m)
+}
+func (m *PbSetUserACLRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_PbSetUserACLRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_PbSetUserACLRequest proto.InternalMessageInfo
+
+func (m *PbSetUserACLRequest) GetName() string {
+	if m != nil {
+		return m.Name
+	}
+	return ""
+}
+
+func (m *PbSetUserACLRequest) GetAddrs() []string {
+	if m != nil {
+		return m.Addrs
+	}
+	return nil
+}
+
+type PbSetUserACLResponse struct {
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *PbSetUserACLResponse) Reset()         { *m = PbSetUserACLResponse{} }
+func (m *PbSetUserACLResponse) String() string { return proto.CompactTextString(m) }
+func (*PbSetUserACLResponse) ProtoMessage()    {}
+func (*PbSetUserACLResponse) Descriptor() ([]byte, []int) {
+	return fileDescriptor_73a7fc70dcc2027c, []int{15}
+}
+
+func (m *PbSetUserACLResponse) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_PbSetUserACLResponse.Unmarshal(m, b)
+}
+func (m *PbSetUserACLResponse) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_PbSetUserACLResponse.Marshal(b, m, deterministic)
+}
+func (m *PbSetUserACLResponse) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_PbSetUserACLResponse.Merge(m, src)
+}
+func (m *PbSetUserACLResponse) XXX_Size() int {
+	return xxx_messageInfo_PbSetUserACLResponse.Size(m)
+}
+func (m *PbSetUserACLResponse) XXX_DiscardUnknown() {
+	xxx_messageInfo_PbSetUserACLResponse.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_PbSetUserACLResponse proto.InternalMessageInfo
+
+type PbDeleteUserRequest struct {
+	Name                 string   `protobuf:"bytes,1,opt,name=Name,json=name,proto3" json:"Name,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *PbDeleteUserRequest) Reset()         { *m = PbDeleteUserRequest{} }
+func (m *PbDeleteUserRequest) String() string { return proto.CompactTextString(m) }
+func (*PbDeleteUserRequest) ProtoMessage()    {}
+func (*PbDeleteUserRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_73a7fc70dcc2027c, []int{16}
+}
+
+func (m *PbDeleteUserRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_PbDeleteUserRequest.Unmarshal(m, b)
+}
+func (m *PbDeleteUserRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_PbDeleteUserRequest.Marshal(b, m, deterministic)
+}
+func (m *PbDeleteUserRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_PbDeleteUserRequest.Merge(m, src)
+}
+func (m *PbDeleteUserRequest) XXX_Size() int {
+	return xxx_messageInfo_PbDeleteUserRequest.Size(m)
+}
+func (m *PbDeleteUserRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_PbDeleteUserRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_PbDeleteUserRequest proto.InternalMessageInfo
+
+func (m *PbDeleteUserRequest) GetName() string {
+	if m != nil {
+		return m.Name
+	}
+	return ""
+}
+
+type PbDeleteUserResponse struct {
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *PbDeleteUserResponse) Reset()         { *m = PbDeleteUserResponse{} }
+func (m *PbDeleteUserResponse) String() string { return proto.CompactTextString(m) }
+func (*PbDeleteUserResponse) ProtoMessage()    {}
+func (*PbDeleteUserResponse) Descriptor() ([]byte, []int) {
+	return fileDescriptor_73a7fc70dcc2027c, []int{17}
+}
+
+func (m *PbDeleteUserResponse) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_PbDeleteUserResponse.Unmarshal(m, b)
+}
+func (m *PbDeleteUserResponse) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_PbDeleteUserResponse.Marshal(b, m, deterministic)
+}
+func (m *PbDeleteUserResponse) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_PbDeleteUserResponse.Merge(m, src)
+}
+func (m *PbDeleteUserResponse) XXX_Size() int {
+	return xxx_messageInfo_PbDeleteUserResponse.Size(m)
+}
+func (m *PbDeleteUserResponse) XXX_DiscardUnknown() {
+	xxx_messageInfo_PbDeleteUserResponse.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_PbDeleteUserResponse proto.InternalMessageInfo
+
+type PbApproveUserRequest struct {
+	Name                 string   `protobuf:"bytes,1,opt,name=Name,json=name,proto3" json:"Name,omitempty"`
+	Addrs                []string `protobuf:"bytes,2,rep,name=Addrs,json=addrs,proto3" json:"Addrs,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *PbApproveUserRequest) Reset()         { *m = PbApproveUserRequest{} }
+func (m *PbApproveUserRequest) String() string { return proto.CompactTextString(m) }
+func (*PbApproveUserRequest) ProtoMessage()    {}
+func (*PbApproveUserRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_73a7fc70dcc2027c, []int{18}
+}
+
+func (m *PbApproveUserRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_PbApproveUserRequest.Unmarshal(m, b)
+}
+func (m *PbApproveUserRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_PbApproveUserRequest.Marshal(b, m, deterministic)
+}
+func (m *PbApproveUserRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_PbApproveUserRequest.Merge(m, src)
+}
+func (m *PbApproveUserRequest) XXX_Size() int {
+	return xxx_messageInfo_PbApproveUserRequest.Size(m)
+}
+func (m *PbApproveUserRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_PbApproveUserRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_PbApproveUserRequest proto.InternalMessageInfo
+
+func (m *PbApproveUserRequest) GetName() string {
+	if m != nil {
+		return m.Name
+	}
+	return ""
+}
+
+func (m *PbApproveUserRequest) GetAddrs() []string {
+	if m != nil {
+		return m.Addrs
+	}
+	return nil
+}
+
+type PbApproveUserResponse struct {
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *PbApproveUserResponse) Reset()         { *m = PbApproveUserResponse{} }
+func (m *PbApproveUserResponse) String() string { return proto.CompactTextString(m) }
+func (*PbApproveUserResponse) ProtoMessage()    {}
+func (*PbApproveUserResponse) Descriptor() ([]byte, []int) {
+	return fileDescriptor_73a7fc70dcc2027c, []int{19}
+}
+
+func (m *PbApproveUserResponse) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_PbApproveUserResponse.Unmarshal(m, b)
+}
+func (m *PbApproveUserResponse) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_PbApproveUserResponse.Marshal(b, m, deterministic)
+}
+func (m *PbApproveUserResponse) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_PbApproveUserResponse.Merge(m, src)
+}
+func (m *PbApproveUserResponse) XXX_Size() int {
+	return xxx_messageInfo_PbApproveUserResponse.Size(m)
+}
+func (m *PbApproveUserResponse) XXX_DiscardUnknown() {
+	xxx_messageInfo_PbApproveUserResponse.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_PbApproveUserResponse proto.InternalMessageInfo
+
+type PbStat struct {
+	Name                 string            `protobuf:"bytes,1,opt,name=Name,json=name,proto3" json:"Name,omitempty"`
+	Type                 string            `protobuf:"bytes,2,opt,name=Type,json=type,proto3" json:"Type,omitempty"`
+	Labels               map[string]string `protobuf:"bytes,3,rep,name=Labels,json=labels,proto3" json:"Labels,omitempty" protobuf_key:"bytes,1,opt,name=key,proto3" protobuf_val:"bytes,2,opt,name=value,proto3"`
+	Value                int64             `protobuf:"varint,4,opt,name=Value,json=value,proto3" json:"Value,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}          `json:"-"`
+	XXX_unrecognized     []byte            `json:"-"`
+	XXX_sizecache        int32             `json:"-"`
+}
+
+func (m *PbStat) Reset()         { *m = PbStat{} }
+func (m *PbStat) String() string { return proto.CompactTextString(m) }
+func (*PbStat) ProtoMessage()    {}
+func (*PbStat) Descriptor() ([]byte, []int) {
+	return fileDescriptor_73a7fc70dcc2027c, []int{20}
+}
+
+func (m *PbStat) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_PbStat.Unmarshal(m, b)
+}
+func (m *PbStat) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_PbStat.Marshal(b, m, deterministic)
+}
+func (m *PbStat) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_PbStat.Merge(m, src)
+}
+func (m *PbStat) XXX_Size() int {
+	return xxx_messageInfo_PbStat.Size(m)
+}
+func (m *PbStat) XXX_DiscardUnknown() {
+	xxx_messageInfo_PbStat.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_PbStat proto.InternalMessageInfo
+
+func (m *PbStat) GetName() string {
+	if m != nil {
+		return m.Name
+	}
+	return ""
+}
+
+func (m *PbStat) GetType() string {
+	if m != nil {
+		return m.Type
+	}
+	return ""
+}
+
+func (m *PbStat) GetLabels() map[string]string {
+	if m != nil {
+		return m.Labels
+	}
+	return nil
+}
+
+func (m *PbStat) GetValue() int64 {
+	if m != nil {
+		return m.Value
+	}
+	return 0
+}
+
+type PbGetStatsRequest struct {
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *PbGetStatsRequest) Reset()         { *m = PbGetStatsRequest{} }
+func (m *PbGetStatsRequest) String() string { return proto.CompactTextString(m) }
+func (*PbGetStatsRequest) ProtoMessage()    {}
+func (*PbGetStatsRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_73a7fc70dcc2027c, []int{21}
+}
+
+func (m *PbGetStatsRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_PbGetStatsRequest.Unmarshal(m, b)
+}
+func (m *PbGetStatsRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_PbGetStatsRequest.Marshal(b, m, deterministic)
+}
+func (m *PbGetStatsRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_PbGetStatsRequest.Merge(m, src)
+}
+func (m *PbGetStatsRequest) XXX_Size() int {
+	return xxx_messageInfo_PbGetStatsRequest.Size(m)
+}
+func (m *PbGetStatsRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_PbGetStatsRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_PbGetStatsRequest proto.InternalMessageInfo
+
+type PbGetStatsResponse struct {
+	Stats                []*PbStat `protobuf:"bytes,1,rep,name=Stats,json=stats,proto3" json:"Stats,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}  `json:"-"`
+	XXX_unrecognized     []byte    `json:"-"`
+	XXX_sizecache        int32     `json:"-"`
+}
+
+func (m *PbGetStatsResponse) Reset()         { *m = PbGetStatsResponse{} }
+func (m *PbGetStatsResponse) String() string { return proto.CompactTextString(m) }
+func (*PbGetStatsResponse) ProtoMessage()    {}
+func (*PbGetStatsResponse) Descriptor() ([]byte, []int) {
+	return fileDescriptor_73a7fc70dcc2027c, []int{22}
+}
+
+func (m *PbGetStatsResponse) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_PbGetStatsResponse.Unmarshal(m, b)
+}
+func (m *PbGetStatsResponse) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_PbGetStatsResponse.Marshal(b, m, deterministic)
+}
+func (m *PbGetStatsResponse) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_PbGetStatsResponse.Merge(m, src)
+}
+func (m *PbGetStatsResponse) XXX_Size() int {
+	return xxx_messageInfo_PbGetStatsResponse.Size(m)
+}
+func (m *PbGetStatsResponse) XXX_DiscardUnknown() {
+	xxx_messageInfo_PbGetStatsResponse.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_PbGetStatsResponse proto.InternalMessageInfo
+
+func (m *PbGetStatsResponse) GetStats() []*PbStat {
+	if m != nil {
+		return m.Stats
+	}
+	return nil
+}
+
+type PbGetServerInfoRequest struct {
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *PbGetServerInfoRequest) Reset()         { *m = PbGetServerInfoRequest{} }
+func (m *PbGetServerInfoRequest) String() string { return proto.CompactTextString(m) }
+func (*PbGetServerInfoRequest) ProtoMessage()    {}
+func (*PbGetServerInfoRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_73a7fc70dcc2027c, []int{23}
+}
+
+func (m *PbGetServerInfoRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_PbGetServerInfoRequest.Unmarshal(m, b)
+}
+func (m *PbGetServerInfoRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_PbGetServerInfoRequest.Marshal(b, m, deterministic)
+}
+func (m *PbGetServerInfoRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_PbGetServerInfoRequest.Merge(m, src)
+}
+func (m *PbGetServerInfoRequest) XXX_Size() int {
+	return xxx_messageInfo_PbGetServerInfoRequest.Size(m)
+}
+func (m *PbGetServerInfoRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_PbGetServerInfoRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_PbGetServerInfoRequest proto.InternalMessageInfo
+
+type PbGetServerInfoResponse struct {
+	BuildVersion         string          `protobuf:"bytes,1,opt,name=BuildVersion,json=buildVersion,proto3" json:"BuildVersion,omitempty"`
+	ProtocolVersion      string          `protobuf:"bytes,2,opt,name=ProtocolVersion,json=protocolVersion,proto3" json:"ProtocolVersion,omitempty"`
+	Draining             bool            `protobuf:"varint,3,opt,name=Draining,json=draining,proto3" json:"Draining,omitempty"`
+	ActiveSessions       int32           `protobuf:"varint,4,opt,name=ActiveSessions,json=activeSessions,proto3" json:"ActiveSessions,omitempty"`
+	GitCommit            string          `protobuf:"bytes,5,opt,name=GitCommit,json=gitCommit,proto3" json:"GitCommit,omitempty"`
+	GoVersion            string          `protobuf:"bytes,6,opt,name=GoVersion,json=goVersion,proto3" json:"GoVersion,omitempty"`
+	Features             map[string]bool `protobuf:"bytes,7,rep,name=Features,json=features,proto3" json:"Features,omitempty" protobuf_key:"bytes,1,opt,name=key,proto3" protobuf_val:"varint,2,opt,name=value,proto3"`
+	XXX_NoUnkeyedLiteral struct{}        `json:"-"`
+	XXX_unrecognized     []byte          `json:"-"`
+	XXX_sizecache        int32           `json:"-"`
+}
+
+func (m *PbGetServerInfoResponse) Reset()         { *m = PbGetServerInfoResponse{} }
+func (m *PbGetServerInfoResponse) String() string { return proto.CompactTextString(m) }
+func (*PbGetServerInfoResponse) ProtoMessage()    {}
+func (*PbGetServerInfoResponse) Descriptor() ([]byte, []int) {
+	return fileDescriptor_73a7fc70dcc2027c, []int{24}
+}
+
+func (m *PbGetServerInfoResponse) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_PbGetServerInfoResponse.Unmarshal(m, b)
+}
+func (m *PbGetServerInfoResponse) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_PbGetServerInfoResponse.Marshal(b, m, deterministic)
+}
+func (m *PbGetServerInfoResponse) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_PbGetServerInfoResponse.Merge(m, src)
+}
+func (m *PbGetServerInfoResponse) XXX_Size() int {
+	return xxx_messageInfo_PbGetServerInfoResponse.Size(m)
+}
+func (m *PbGetServerInfoResponse) XXX_DiscardUnknown() {
+	xxx_messageInfo_PbGetServerInfoResponse.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_PbGetServerInfoResponse proto.InternalMessageInfo
+
+func (m *PbGetServerInfoResponse) GetBuildVersion() string {
+	if m != nil {
+		return m.BuildVersion
+	}
+	return ""
+}
+
+func (m *PbGetServerInfoResponse) GetProtocolVersion() string {
+	if m != nil {
+		return m.ProtocolVersion
+	}
+	return ""
+}
+
+func (m *PbGetServerInfoResponse) GetDraining() bool {
+	if m != nil {
+		return m.Draining
+	}
+	return false
+}
+
+func (m *PbGetServerInfoResponse) GetActiveSessions() int32 {
+	if m != nil {
+		return m.ActiveSessions
+	}
+	return 0
+}
+
+func (m *PbGetServerInfoResponse) GetGitCommit() string {
+	if m != nil {
+		return m.GitCommit
+	}
+	return ""
+}
+
+func (m *PbGetServerInfoResponse) GetGoVersion() string {
+	if m != nil {
+		return m.GoVersion
+	}
+	return ""
+}
+
+func (m *PbGetServerInfoResponse) GetFeatures() map[string]bool {
+	if m != nil {
+		return m.Features
+	}
+	return nil
+}
+
+type PbGetSessionLogRequest struct {
+	Id                   int32    `protobuf:"varint,1,opt,name=Id,json=id,proto3" json:"Id,omitempty"`
+	MaxLines             int32    `protobuf:"varint,2,opt,name=MaxLines,json=maxLines,proto3" json:"MaxLines,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *PbGetSessionLogRequest) Reset()         { *m = PbGetSessionLogRequest{} }
+func (m *PbGetSessionLogRequest) String() string { return proto.CompactTextString(m) }
+func (*PbGetSessionLogRequest) ProtoMessage()    {}
+func (*PbGetSessionLogRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_73a7fc70dcc2027c, []int{25}
+}
+
+func (m *PbGetSessionLogRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_PbGetSessionLogRequest.Unmarshal(m, b)
+}
+func (m *PbGetSessionLogRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_PbGetSessionLogRequest.Marshal(b, m, deterministic)
+}
+func (m *PbGetSessionLogRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_PbGetSessionLogRequest.Merge(m, src)
+}
+func (m *PbGetSessionLogRequest) XXX_Size() int {
+	return xxx_messageInfo_PbGetSessionLogRequest.Size(m)
+}
+func (m *PbGetSessionLogRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_PbGetSessionLogRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_PbGetSessionLogRequest proto.InternalMessageInfo
+
+func (m *PbGetSessionLogRequest) GetId() int32 {
+	if m != nil {
+		return m.Id
+	}
+	return 0
+}
+
+func (m *PbGetSessionLogRequest) GetMaxLines() int32 {
+	if m != nil {
+		return m.MaxLines
+	}
+	return 0
+}
+
+type PbSessionLogLine struct {
+	TimeUnixMs           int64    `protobuf:"varint,1,opt,name=TimeUnixMs,json=timeUnixMs,proto3" json:"TimeUnixMs,omitempty"`
+	Level                string   `protobuf:"bytes,2,opt,name=Level,json=level,proto3" json:"Level,omitempty"`
+	Message              string   `protobuf:"bytes,3,opt,name=Message,json=message,proto3" json:"Message,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *PbSessionLogLine) Reset()         { *m = PbSessionLogLine{} }
+func (m *PbSessionLogLine) String() string { return proto.CompactTextString(m) }
+func (*PbSessionLogLine) ProtoMessage()    {}
+func (*PbSessionLogLine) Descriptor() ([]byte, []int) {
+	return fileDescriptor_73a7fc70dcc2027c, []int{26}
+}
+
+func (m *PbSessionLogLine) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_PbSessionLogLine.Unmarshal(m, b)
+}
+func (m *PbSessionLogLine) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_PbSessionLogLine.Marshal(b, m, deterministic)
+}
+func (m *PbSessionLogLine) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_PbSessionLogLine.Merge(m, src)
+}
+func (m *PbSessionLogLine) XXX_Size() int {
+	return xxx_messageInfo_PbSessionLogLine.Size(m)
+}
+func (m *PbSessionLogLine) XXX_DiscardUnknown() {
+	xxx_messageInfo_PbSessionLogLine.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_PbSessionLogLine proto.InternalMessageInfo
+
+func (m *PbSessionLogLine) GetTimeUnixMs() int64 {
+	if m != nil {
+		return m.TimeUnixMs
+	}
+	return 0
+}
+
+func (m *PbSessionLogLine) GetLevel() string {
+	if m != nil {
+		return m.Level
+	}
+	return ""
+}
+
+func (m *PbSessionLogLine) GetMessage() string {
+	if m != nil {
+		return m.Message
+	}
+	return ""
+}
+
+type PbGetSessionLogResponse struct {
+	Lines                []*PbSessionLogLine `protobuf:"bytes,1,rep,name=Lines,json=lines,proto3" json:"Lines,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}            `json:"-"`
+	XXX_unrecognized     []byte              `json:"-"`
+	XXX_sizecache        int32               `json:"-"`
+}
+
+func (m *PbGetSessionLogResponse) Reset()         { *m = PbGetSessionLogResponse{} }
+func (m *PbGetSessionLogResponse) String() string { return proto.CompactTextString(m) }
+func (*PbGetSessionLogResponse) ProtoMessage()    {}
+func (*PbGetSessionLogResponse) Descriptor() ([]byte, []int) {
+	return fileDescriptor_73a7fc70dcc2027c, []int{27}
+}
+
+func (m *PbGetSessionLogResponse) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_PbGetSessionLogResponse.Unmarshal(m, b)
+}
+func (m *PbGetSessionLogResponse) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_PbGetSessionLogResponse.Marshal(b, m, deterministic)
+}
+func (m *PbGetSessionLogResponse) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_PbGetSessionLogResponse.Merge(m, src)
+}
+func (m *PbGetSessionLogResponse) XXX_Size() int {
+	return xxx_messageInfo_PbGetSessionLogResponse.Size(m)
+}
+func (m *PbGetSessionLogResponse) XXX_DiscardUnknown() {
+	xxx_messageInfo_PbGetSessionLogResponse.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_PbGetSessionLogResponse proto.InternalMessageInfo
+
+func (m *PbGetSessionLogResponse) GetLines() []*PbSessionLogLine {
+	if m != nil {
+		return m.Lines
+	}
+	return nil
+}
+
+type PbDrainListenerRequest struct {
+	Id                   int32    `protobuf:"varint,1,opt,name=Id,json=id,proto3" json:"Id,omitempty"`
+	ChannelDescriptor    string   `protobuf:"bytes,2,opt,name=ChannelDescriptor,json=channelDescriptor,proto3" json:"ChannelDescriptor,omitempty"`
+	TimeoutSeconds       int32    `protobuf:"varint,3,opt,name=TimeoutSeconds,json=timeoutSeconds,proto3" json:"TimeoutSeconds,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *PbDrainListenerRequest) Reset()         { *m = PbDrainListenerRequest{} }
+func (m *PbDrainListenerRequest) String() string { return proto.CompactTextString(m) }
+func (*PbDrainListenerRequest) ProtoMessage()    {}
+func (*PbDrainListenerRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_73a7fc70dcc2027c, []int{28}
+}
+
+func (m *PbDrainListenerRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_PbDrainListenerRequest.Unmarshal(m, b)
+}
+func (m *PbDrainListenerRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_PbDrainListenerRequest.Marshal(b, m, deterministic)
+}
+func (m *PbDrainListenerRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_PbDrainListenerRequest.Merge(m, src)
+}
+func (m *PbDrainListenerRequest) XXX_Size() int {
+	return xxx_messageInfo_PbDrainListenerRequest.Size(m)
+}
+func (m *PbDrainListenerRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_PbDrainListenerRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_PbDrainListenerRequest proto.InternalMessageInfo
+
+func (m *PbDrainListenerRequest) GetId() int32 {
+	if m != nil {
+		return m.Id
+	}
+	return 0
+}
+
+func (m *PbDrainListenerRequest) GetChannelDescriptor() string {
+	if m != nil {
+		return m.ChannelDescriptor
+	}
+	return ""
+}
+
+func (m *PbDrainListenerRequest) GetTimeoutSeconds() int32 {
+	if m != nil {
+		return m.TimeoutSeconds
+	}
+	return 0
+}
+
+type PbDrainListenerResponse struct {
+	ActiveConnections    int32    `protobuf:"varint,1,opt,name=ActiveConnections,json=activeConnections,proto3" json:"ActiveConnections,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *PbDrainListenerResponse) Reset()         { *m = PbDrainListenerResponse{} }
+func (m *PbDrainListenerResponse) String() string { return proto.CompactTextString(m) }
+func (*PbDrainListenerResponse) ProtoMessage()    {}
+func (*PbDrainListenerResponse) Descriptor() ([]byte, []int) {
+	return fileDescriptor_73a7fc70dcc2027c, []int{29}
+}
+
+func (m *PbDrainListenerResponse) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_PbDrainListenerResponse.Unmarshal(m, b)
+}
+func (m *PbDrainListenerResponse) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_PbDrainListenerResponse.Marshal(b, m, deterministic)
+}
+func (m *PbDrainListenerResponse) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_PbDrainListenerResponse.Merge(m, src)
+}
+func (m *PbDrainListenerResponse) XXX_Size() int {
+	return xxx_messageInfo_PbDrainListenerResponse.Size(m)
+}
+func (m *PbDrainListenerResponse) XXX_DiscardUnknown() {
+	xxx_messageInfo_PbDrainListenerResponse.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_PbDrainListenerResponse proto.InternalMessageInfo
+
+func (m *PbDrainListenerResponse) GetActiveConnections() int32 {
+	if m != nil {
+		return m.ActiveConnections
+	}
+	return 0
+}
+
+type PbListSessionCostsRequest struct {
+	SortBy               string   `protobuf:"bytes,1,opt,name=SortBy,json=sortBy,proto3" json:"SortBy,omitempty"`
+	Limit                int32    `protobuf:"varint,2,opt,name=Limit,json=limit,proto3" json:"Limit,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *PbListSessionCostsRequest) Reset()         { *m = PbListSessionCostsRequest{} }
+func (m *PbListSessionCostsRequest) String() string { return proto.CompactTextString(m) }
+func (*PbListSessionCostsRequest) ProtoMessage()    {}
+func (*PbListSessionCostsRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_73a7fc70dcc2027c, []int{30}
+}
+
+func (m *PbListSessionCostsRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_PbListSessionCostsRequest.Unmarshal(m, b)
+}
+func (m *PbListSessionCostsRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_PbListSessionCostsRequest.Marshal(b, m, deterministic)
+}
+func (m *PbListSessionCostsRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_PbListSessionCostsRequest.Merge(m, src)
+}
+func (m *PbListSessionCostsRequest) XXX_Size() int {
+	return xxx_messageInfo_PbListSessionCostsRequest.Size(m)
+}
+func (m *PbListSessionCostsRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_PbListSessionCostsRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_PbListSessionCostsRequest proto.InternalMessageInfo
+
+func (m *PbListSessionCostsRequest) GetSortBy() string {
+	if m != nil {
+		return m.SortBy
+	}
+	return ""
+}
+
+func (m *PbListSessionCostsRequest) GetLimit() int32 {
+	if m != nil {
+		return m.Limit
+	}
+	return 0
+}
+
+type PbSessionCost struct {
+	Id                   int32    `protobuf:"varint,1,opt,name=Id,json=id,proto3" json:"Id,omitempty"`
+	User                 string   `protobuf:"bytes,2,opt,name=User,json=user,proto3" json:"User,omitempty"`
+	Tenant               string   `protobuf:"bytes,3,opt,name=Tenant,json=tenant,proto3" json:"Tenant,omitempty"`
+	SessionName          string   `protobuf:"bytes,4,opt,name=SessionName,json=sessionName,proto3" json:"SessionName,omitempty"`
+	Channels             int32    `protobuf:"varint,5,opt,name=Channels,json=channels,proto3" json:"Channels,omitempty"`
+	Listeners            int32    `protobuf:"varint,6,opt,name=Listeners,json=listeners,proto3" json:"Listeners,omitempty"`
+	Goroutines           int32    `protobuf:"varint,7,opt,name=Goroutines,json=goroutines,proto3" json:"Goroutines,omitempty"`
+	BufferedBytes        int64    `protobuf:"varint,8,opt,name=BufferedBytes,json=bufferedBytes,proto3" json:"BufferedBytes,omitempty"`
+	MemoryBytes          int64    `protobuf:"varint,9,opt,name=MemoryBytes,json=memoryBytes,proto3" json:"MemoryBytes,omitempty"`
+	TransportBytes       int64    `protobuf:"varint,10,opt,name=TransportBytes,json=transportBytes,proto3" json:"TransportBytes,omitempty"`
+	BytesPerSecond       float64  `protobuf:"fixed64,11,opt,name=BytesPerSecond,json=bytesPerSecond,proto3" json:"BytesPerSecond,omitempty"`
+	Cost                 float64  `protobuf:"fixed64,12,opt,name=Cost,json=cost,proto3" json:"Cost,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *PbSessionCost) Reset()         { *m = PbSessionCost{} }
+func (m *PbSessionCost) String() string { return proto.CompactTextString(m) }
+func (*PbSessionCost) ProtoMessage()    {}
+func (*PbSessionCost) Descriptor() ([]byte, []int) {
+	return fileDescriptor_73a7fc70dcc2027c, []int{31}
+}
+
+func (m *PbSessionCost) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_PbSessionCost.Unmarshal(m, b)
+}
+func (m *PbSessionCost) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_PbSessionCost.Marshal(b, m, deterministic)
+}
+func (m *PbSessionCost) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_PbSessionCost.Merge(m, src)
+}
+func (m *PbSessionCost) XXX_Size() int {
+	return xxx_messageInfo_PbSessionCost.Size(m)
+}
+func (m *PbSessionCost) XXX_DiscardUnknown() {
+	xxx_messageInfo_PbSessionCost.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_PbSessionCost proto.InternalMessageInfo
+
+func (m *PbSessionCost) GetId() int32 {
+	if m != nil {
+		return m.Id
+	}
+	return 0
+}
+
+func (m *PbSessionCost) GetUser() string {
+	if m != nil {
+		return m.User
+	}
+	return ""
+}
+
+func (m *PbSessionCost) GetTenant() string {
+	if m != nil {
+		return m.Tenant
+	}
+	return ""
+}
+
+func (m *PbSessionCost) GetSessionName() string {
+	if m != nil {
+		return m.SessionName
+	}
+	return ""
+}
+
+func (m *PbSessionCost) GetChannels() int32 {
+	if m != nil {
+		return m.Channels
+	}
+	return 0
+}
+
+func (m *PbSessionCost) GetListeners() int32 {
+	if m != nil {
+		return m.Listeners
+	}
+	return 0
+}
+
+func (m *PbSessionCost) GetGoroutines() int32 {
+	if m != nil {
+		return m.Goroutines
+	}
+	return 0
+}
+
+func (m *PbSessionCost) GetBufferedBytes() int64 {
+	if m != nil {
+		return m.BufferedBytes
+	}
+	return 0
+}
+
+func (m *PbSessionCost) GetMemoryBytes() int64 {
+	if m != nil {
+		return m.MemoryBytes
+	}
+	return 0
+}
+
+func (m *PbSessionCost) GetTransportBytes() int64 {
+	if m != nil {
+		return m.TransportBytes
+	}
+	return 0
+}
+
+func (m *PbSessionCost) GetBytesPerSecond() float64 {
+	if m != nil {
+		return m.BytesPerSecond
+	}
+	return 0
+}
+
+func (m *PbSessionCost) GetCost() float64 {
+	if m != nil {
+		return m.Cost
+	}
+	return 0
+}
+
+type PbListSessionCostsResponse struct {
+	Sessions             []*PbSessionCost `protobuf:"bytes,1,rep,name=Sessions,json=sessions,proto3" json:"Sessions,omitempty"`
+	TotalGoroutines      int32            `protobuf:"varint,2,opt,name=TotalGoroutines,json=totalGoroutines,proto3" json:"TotalGoroutines,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}         `json:"-"`
+	XXX_unrecognized     []byte           `json:"-"`
+	XXX_sizecache        int32            `json:"-"`
+}
+
+func (m *PbListSessionCostsResponse) Reset()         { *m = PbListSessionCostsResponse{} }
+func (m *PbListSessionCostsResponse) String() string { return proto.CompactTextString(m) }
+func (*PbListSessionCostsResponse) ProtoMessage()    {}
+func (*PbListSessionCostsResponse) Descriptor() ([]byte, []int) {
+	return fileDescriptor_73a7fc70dcc2027c, []int{32}
+}
+
+func (m *PbListSessionCostsResponse) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_PbListSessionCostsResponse.Unmarshal(m, b)
+}
+func (m *PbListSessionCostsResponse) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_PbListSessionCostsResponse.Marshal(b, m, deterministic)
+}
+func (m *PbListSessionCostsResponse) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_PbListSessionCostsResponse.Merge(m, src)
+}
+func (m *PbListSessionCostsResponse) XXX_Size() int {
+	return xxx_messageInfo_PbListSessionCostsResponse.Size(m)
+}
+func (m *PbListSessionCostsResponse) XXX_DiscardUnknown() {
+	xxx_messageInfo_PbListSessionCostsResponse.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_PbListSessionCostsResponse proto.InternalMessageInfo
+
+func (m *PbListSessionCostsResponse) GetSessions() []*PbSessionCost {
+	if m != nil {
+		return m.Sessions
+	}
+	return nil
+}
+
+func (m *PbListSessionCostsResponse) GetTotalGoroutines() int32 {
+	if m != nil {
+		return m.TotalGoroutines
+	}
+	return 0
+}
+
+func init() {
+	proto.RegisterType((*PbAdminSession)(nil), "PbAdminSession")
+	proto.RegisterType((*PbListSessionsRequest)(nil), "PbListSessionsRequest")
+	proto.RegisterType((*PbListSessionsResponse)(nil), "PbListSessionsResponse")
+	proto.RegisterType((*PbKillSessionRequest)(nil), "PbKillSessionRequest")
+	proto.RegisterType((*PbKillSessionResponse)(nil), "PbKillSessionResponse")
+	proto.RegisterType((*PbNotifySessionsRequest)(nil), "PbNotifySessionsRequest")
+	proto.RegisterType((*PbNotifySessionsResponse)(nil), "PbNotifySessionsResponse")
+	proto.RegisterType((*PbDrainRequest)(nil), "PbDrainRequest")
+	proto.RegisterType((*PbDrainResponse)(nil), "PbDrainResponse")
+	proto.RegisterType((*PbAdminUser)(nil), "PbAdminUser")
+	proto.RegisterType((*PbListUsersRequest)(nil), "PbListUsersRequest")
+	proto.RegisterType((*PbListUsersResponse)(nil), "PbListUsersResponse")
+	proto.RegisterType((*PbSetUserRequest)(nil), "PbSetUserRequest")
+	proto.RegisterType((*PbSetUserResponse)(nil), "PbSetUserResponse")
+	proto.RegisterType((*PbSetUserACLRequest)(nil), "PbSetUserACLRequest")
+	proto.RegisterType((*PbSetUserACLResponse)(nil), "PbSetUserACLResponse")
+	proto.RegisterType((*PbDeleteUserRequest)(nil), "PbDeleteUserRequest")
+	proto.RegisterType((*PbDeleteUserResponse)(nil), "PbDeleteUserResponse")
+	proto.RegisterType((*PbApproveUserRequest)(nil), "PbApproveUserRequest")
+	proto.RegisterType((*PbApproveUserResponse)(nil), "PbApproveUserResponse")
+	proto.RegisterType((*PbStat)(nil), "PbStat")
+	proto.RegisterMapType((map[string]string)(nil), "PbStat.LabelsEntry")
+	proto.RegisterType((*PbGetStatsRequest)(nil), "PbGetStatsRequest")
+	proto.RegisterType((*PbGetStatsResponse)(nil), "PbGetStatsResponse")
+	proto.RegisterType((*PbGetServerInfoRequest)(nil), "PbGetServerInfoRequest")
+	proto.RegisterType((*PbGetServerInfoResponse)(nil), "PbGetServerInfoResponse")
+	proto.RegisterMapType((map[string]bool)(nil), "PbGetServerInfoResponse.FeaturesEntry")
+	proto.RegisterType((*PbGetSessionLogRequest)(nil), "PbGetSessionLogRequest")
+	proto.RegisterType((*PbSessionLogLine)(nil), "PbSessionLogLine")
+	proto.RegisterType((*PbGetSessionLogResponse)(nil), "PbGetSessionLogResponse")
+	proto.RegisterType((*PbDrainListenerRequest)(nil), "PbDrainListenerRequest")
+	proto.RegisterType((*PbDrainListenerResponse)(nil), "PbDrainListenerResponse")
+	proto.RegisterType((*PbListSessionCostsRequest)(nil), "PbListSessionCostsRequest")
+	proto.RegisterType((*PbSessionCost)(nil), "PbSessionCost")
+	proto.RegisterType((*PbListSessionCostsResponse)(nil), "PbListSessionCostsResponse")
+}
+
+func init() { proto.RegisterFile("admin.proto", fileDescriptor_73a7fc70dcc2027c) }
+
+var fileDescriptor_73a7fc70dcc2027c = []byte{
+	// 1570 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x8c, 0x57, 0xcd, 0x6e, 0xdb, 0x46,
+	0x10, 0x86, 0x7e, 0x28, 0x53, 0x23, 0x5b, 0xb6, 0xd6, 0xb6, 0xcc, 0xb0, 0x3f, 0x10, 0x88, 0xc0,
+	0x55, 0xd3, 0x80, 0x29, 0x12, 0xa0, 0xa8, 0xdb, 0xa2, 0xa9, 0x25, 0x27, 0x86, 0x11, 0x3b, 0x10,
+	0x68, 0x27, 0x28, 0x7a, 0xa3, 0xc8, 0xb5, 0xcc, 0x9a, 0x22, 0xd5, 0xdd, 0x95, 0x63, 0x5d, 0xfa,
+	0x2c, 0x7d, 0x82, 0x5e, 0x7a, 0xe9, 0x4b, 0xf4, 0x3d, 0x0a, 0xf4, 0xd8, 0x17, 0x28, 0xf6, 0x87,
+	0x14, 0x29, 0x52, 0x49, 0x6f, 0x9a, 0x6f, 0x76, 0x87, 0xb3, 0xf3, 0xfb, 0x09, 0x5a, 0xae, 0x3f,
+	0x0d, 0x22, 0x7b, 0x46, 0x62, 0x16, 0x5b, 0xbf, 0x55, 0xa1, 0x3d, 0x1a, 0x1f, 0x73, 0xe4, 0x12,
+	0x53, 0x1a, 0xc4, 0x11, 0x6a, 0x43, 0xf5, 0xcc, 0x37, 0x2a, 0xbd, 0x4a, 0x5f, 0x73, 0xaa, 0x81,
+	0x8f, 0x10, 0xd4, 0xdf, 0x50, 0x4c, 0x8c, 0x6a, 0xaf, 0xd2, 0x6f, 0x3a, 0xf5, 0x39, 0xc5, 0x04,
+	0x7d, 0x0a, 0xe0, 0xe0, 0x69, 0xcc, 0xf0, 0xb1, 0xef, 0x13, 0xa3, 0x26, 0x34, 0x40, 0x52, 0x04,
+	0x3d, 0x84, 0xad, 0x4b, 0xe6, 0x12, 0x76, 0x15, 0x4c, 0xf1, 0x9b, 0x28, 0xb8, 0x37, 0xea, 0xbd,
+	0x4a, 0xbf, 0xe6, 0x6c, 0xd1, 0x2c, 0xc8, 0x4f, 0x0d, 0xc3, 0x00, 0x47, 0xec, 0x2d, 0x26, 0xfc,
+	0xd3, 0x86, 0x26, 0x0c, 0x6d, 0x79, 0x59, 0x10, 0xd9, 0x80, 0x86, 0x37, 0x6e, 0x14, 0xe1, 0xf0,
+	0x04, 0x53, 0x8f, 0x04, 0x33, 0x16, 0x13, 0x6a, 0x34, 0x7a, 0xb5, 0x7e, 0xd3, 0x41, 0x5e, 0x41,
+	0x83, 0x7a, 0xd0, 0x52, 0x4f, 0x79, 0xed, 0x4e, 0xb1, 0xb1, 0x21, 0x6c, 0xb6, 0xe8, 0x12, 0x42,
+	0x8f, 0xa1, 0x73, 0x82, 0xef, 0x02, 0x0f, 0xbf, 0x0c, 0xa2, 0x09, 0x26, 0x33, 0x12, 0x44, 0xcc,
+	0xd0, 0xc5, 0xb9, 0x8e, 0xbf, 0xaa, 0xb0, 0x0e, 0x60, 0x7f, 0x34, 0x3e, 0x0f, 0x28, 0x53, 0x56,
+	0xa9, 0x83, 0x7f, 0x99, 0x63, 0xca, 0xac, 0x17, 0xd0, 0x5d, 0x55, 0xd0, 0x59, 0x1c, 0x51, 0x8c,
+	0xbe, 0x00, 0x3d, 0xc1, 0x8c, 0x4a, 0xaf, 0xd6, 0x6f, 0x3d, 0xdd, 0xb6, 0xf3, 0x51, 0x76, 0x74,
+	0xe5, 0x10, 0xb5, 0xbe, 0x87, 0xbd, 0xd1, 0xf8, 0x55, 0x10, 0x86, 0x89, 0x4a, 0x9a, 0x2f, 0xe4,
+	0xa1, 0x0b, 0x0d, 0x07, 0xbb, 0x34, 0x8e, 0x54, 0x26, 0x1a, 0x44, 0x48, 0xd2, 0xbf, 0xdc, 0x7d,
+	0xe9, 0x85, 0xf5, 0x47, 0x05, 0x0e, 0x46, 0xe3, 0xd7, 0x31, 0x0b, 0xae, 0x17, 0x2b, 0xbe, 0x23,
+	0x03, 0x36, 0x2e, 0x30, 0xa5, 0xee, 0x04, 0x8b, 0x2f, 0x34, 0x9d, 0x8d, 0xa9, 0x14, 0x51, 0x1f,
+	0xb6, 0x1d, 0xec, 0xc5, 0x51, 0x84, 0x3d, 0x36, 0x58, 0x88, 0xe4, 0x55, 0x45, 0xf2, 0xb6, 0x49,
+	0x1e, 0xe6, 0x45, 0xa0, 0xcc, 0x9e, 0xf9, 0xd4, 0xa8, 0xf5, 0x6a, 0x7d, 0xcd, 0x01, 0x9a, 0x22,
+	0x69, 0xe1, 0xd4, 0x33, 0x85, 0xb3, 0x92, 0x1c, 0xad, 0x90, 0x1c, 0xeb, 0x67, 0x30, 0x8a, 0x4e,
+	0xab, 0xb8, 0xf6, 0xa0, 0x25, 0x34, 0x01, 0xf6, 0xcf, 0x7c, 0x19, 0x5a, 0xcd, 0x69, 0x45, 0x4b,
+	0x88, 0xa7, 0xf6, 0x4d, 0xe4, 0x7a, 0xb7, 0x51, 0xfc, 0x2e, 0xc4, 0xfe, 0x44, 0x9e, 0xab, 0x8a,
+	0x73, 0x9d, 0xf9, 0xaa, 0xc2, 0x3a, 0xe4, 0xc5, 0x7f, 0x42, 0xdc, 0x20, 0x0d, 0xfa, 0x1e, 0x68,
+	0x42, 0x16, 0x51, 0xd1, 0x1d, 0xcd, 0xe7, 0x82, 0x75, 0x04, 0xdb, 0xe9, 0x39, 0xe5, 0xca, 0x21,
+	0xb4, 0x8f, 0x3d, 0x16, 0xdc, 0xe1, 0x4c, 0xa2, 0x79, 0xa6, 0xda, 0x6e, 0x0e, 0xb5, 0xfe, 0xaa,
+	0x40, 0x4b, 0xa5, 0x9e, 0x07, 0x83, 0x07, 0x45, 0xbc, 0x5c, 0x46, 0xbd, 0x1e, 0xf1, 0x7a, 0xdc,
+	0x03, 0x8d, 0x77, 0x8d, 0x74, 0xb4, 0xe9, 0x68, 0x2e, 0x17, 0xf8, 0x63, 0x2f, 0xdc, 0xfb, 0xd4,
+	0x7c, 0x4d, 0x98, 0x6f, 0x4d, 0x97, 0x10, 0xb7, 0xf5, 0x2a, 0xf0, 0x6e, 0x45, 0x80, 0x75, 0xa7,
+	0x7e, 0x1b, 0x78, 0xb7, 0xc8, 0x04, 0x7d, 0x88, 0x09, 0x3b, 0x9e, 0xb3, 0x1b, 0x11, 0x5d, 0xdd,
+	0xd1, 0x3d, 0x25, 0xf3, 0xa4, 0x8f, 0x70, 0xe4, 0x07, 0xd1, 0xc4, 0x68, 0x08, 0xd5, 0xc6, 0x4c,
+	0x8a, 0x3c, 0xe9, 0x17, 0xee, 0xbd, 0x6a, 0xb3, 0xc1, 0x82, 0x61, 0x2a, 0xfa, 0xa6, 0xe6, 0x6c,
+	0x4f, 0xf3, 0xb0, 0xb5, 0x07, 0x48, 0x16, 0x3d, 0x7f, 0x4d, 0xda, 0x0a, 0x47, 0xb0, 0x9b, 0x43,
+	0x55, 0x90, 0x2c, 0xd0, 0x04, 0xa0, 0x9a, 0x60, 0xd3, 0xce, 0x44, 0xc2, 0xd1, 0x78, 0x41, 0x50,
+	0xeb, 0xcf, 0x0a, 0xec, 0x8c, 0xc6, 0x97, 0x58, 0x5c, 0x4d, 0xd2, 0x50, 0x16, 0x25, 0x13, 0xf4,
+	0x91, 0x4b, 0xe9, 0xbb, 0x98, 0xf8, 0xaa, 0x03, 0xf4, 0x99, 0x92, 0x97, 0x11, 0xac, 0xbd, 0x27,
+	0x82, 0xf5, 0xf5, 0x11, 0xd4, 0x32, 0x11, 0x2c, 0x89, 0x45, 0xa3, 0x3c, 0x16, 0xbb, 0xd0, 0xc9,
+	0x78, 0xae, 0xba, 0xee, 0x39, 0xec, 0xa6, 0xe0, 0xf1, 0xf0, 0xfc, 0x7d, 0x2f, 0x2a, 0xcd, 0xbb,
+	0xd5, 0x85, 0xbd, 0xbc, 0x01, 0x65, 0xf8, 0x73, 0x6e, 0xf8, 0x04, 0x87, 0x98, 0xe1, 0x0f, 0x84,
+	0x4a, 0x9a, 0xc8, 0x1e, 0x55, 0x26, 0x7e, 0xe0, 0xf8, 0xf1, 0x6c, 0x46, 0xe2, 0xbb, 0x0f, 0xd9,
+	0x58, 0xe3, 0x9c, 0x18, 0x36, 0x39, 0x0b, 0xca, 0xf4, 0xef, 0x15, 0x68, 0x8c, 0xc6, 0x97, 0xcc,
+	0x2d, 0xb7, 0x86, 0xa0, 0x7e, 0xb5, 0x98, 0xe1, 0x64, 0x89, 0xb0, 0xc5, 0x8c, 0x4f, 0xc9, 0xc6,
+	0xb9, 0x3b, 0xc6, 0xa1, 0xcc, 0x5a, 0xeb, 0xe9, 0xae, 0x2d, 0x0d, 0xd8, 0x12, 0x7d, 0x11, 0x31,
+	0xb2, 0x70, 0x1a, 0xa1, 0x10, 0xb8, 0x3b, 0x6f, 0xdd, 0x70, 0x8e, 0xd5, 0x26, 0xd1, 0xee, 0xb8,
+	0x60, 0x1e, 0x41, 0x2b, 0x73, 0x18, 0xed, 0x40, 0xed, 0x16, 0x2f, 0xd4, 0x87, 0xf9, 0x4f, 0x7e,
+	0x4d, 0x9c, 0x54, 0x1f, 0x96, 0xc2, 0x37, 0xd5, 0xaf, 0x2b, 0x32, 0x79, 0xa7, 0x98, 0xf1, 0x2f,
+	0xa6, 0x75, 0xfc, 0x0c, 0x50, 0x16, 0x54, 0x65, 0xfc, 0x09, 0x68, 0x02, 0x50, 0x65, 0xbc, 0xa1,
+	0xfc, 0x74, 0x34, 0xca, 0x51, 0xcb, 0x80, 0xae, 0xbc, 0x84, 0xc9, 0x1d, 0x26, 0x67, 0xd1, 0x75,
+	0x9c, 0x98, 0xfb, 0xbb, 0x0a, 0x07, 0x05, 0x55, 0xda, 0x1b, 0x9b, 0x83, 0x79, 0x10, 0xfa, 0xc9,
+	0xee, 0x93, 0x4e, 0x6f, 0x8e, 0x33, 0x18, 0x2f, 0xc5, 0x11, 0x5f, 0xd3, 0x5e, 0x1c, 0x26, 0xc7,
+	0xe4, 0x3b, 0xb6, 0x67, 0x79, 0x98, 0x37, 0x87, 0x98, 0x4f, 0xbc, 0xb7, 0x6b, 0xb2, 0xed, 0x7d,
+	0x25, 0x97, 0x8c, 0xaa, 0x7a, 0xd9, 0xa8, 0x42, 0x1f, 0x43, 0xf3, 0x34, 0x60, 0xc3, 0x78, 0x3a,
+	0x0d, 0x98, 0x9a, 0xcc, 0xcd, 0x49, 0x02, 0x08, 0x6d, 0x9c, 0x78, 0xd1, 0x50, 0xda, 0x04, 0x40,
+	0x03, 0xd0, 0x5f, 0x62, 0x97, 0xcd, 0x89, 0x98, 0x1c, 0x3c, 0x4a, 0x87, 0xf6, 0x9a, 0x97, 0xdb,
+	0xc9, 0x41, 0x99, 0x60, 0xfd, 0x5a, 0x89, 0xe6, 0xb7, 0xb0, 0x95, 0x53, 0x7d, 0x28, 0x9d, 0x7a,
+	0x36, 0x9d, 0x27, 0x69, 0x12, 0xc4, 0x6b, 0xce, 0xe3, 0xc9, 0xba, 0x3d, 0x6a, 0x82, 0x7e, 0xe1,
+	0xde, 0x9f, 0x07, 0x11, 0xa6, 0xc2, 0x8c, 0xe6, 0xe8, 0x53, 0x25, 0x5b, 0x63, 0x39, 0x8b, 0x12,
+	0x13, 0x1c, 0xe4, 0x6b, 0x2e, 0x61, 0x2c, 0x17, 0x72, 0xca, 0xd7, 0x1c, 0x60, 0x29, 0xc2, 0x7d,
+	0x3a, 0xc7, 0x77, 0x38, 0x4c, 0x4a, 0x2c, 0xe4, 0x42, 0x76, 0xc1, 0xd6, 0x72, 0x0b, 0xd6, 0x1a,
+	0xa4, 0x35, 0xb1, 0xf4, 0x54, 0xd5, 0xc4, 0x67, 0xa0, 0x49, 0xbf, 0x64, 0xa1, 0x75, 0xec, 0x55,
+	0x67, 0x1c, 0x2d, 0x14, 0x7e, 0xfe, 0xca, 0x5f, 0x2b, 0x12, 0xce, 0x87, 0x2e, 0x8e, 0x30, 0x59,
+	0xf7, 0xda, 0xc7, 0xd0, 0x29, 0xb0, 0x27, 0xe5, 0x69, 0xa7, 0x40, 0x9e, 0x78, 0xa9, 0xf0, 0xb7,
+	0xc6, 0x73, 0x76, 0xc9, 0x97, 0xbd, 0x9f, 0xac, 0x9d, 0x36, 0xcb, 0xa1, 0xd6, 0x29, 0x1c, 0x14,
+	0xbe, 0xaf, 0xde, 0xf0, 0x18, 0x3a, 0xb2, 0xda, 0x86, 0x92, 0x2c, 0x64, 0x76, 0x63, 0xc7, 0x5d,
+	0x55, 0x58, 0x67, 0xf0, 0x20, 0xc7, 0xa1, 0x86, 0x31, 0x4d, 0xbb, 0x91, 0x33, 0x9e, 0xcb, 0x98,
+	0xb0, 0x41, 0x52, 0x02, 0x0d, 0x2a, 0x24, 0x11, 0xf1, 0x80, 0x17, 0xa9, 0x4c, 0x9f, 0x16, 0x72,
+	0xc1, 0xfa, 0xb7, 0x0a, 0x5b, 0xa3, 0x71, 0xc6, 0xce, 0xff, 0x62, 0xb2, 0x5d, 0x68, 0x5c, 0xe1,
+	0xc8, 0x8d, 0x98, 0x4a, 0x53, 0x83, 0x09, 0x69, 0x95, 0xa8, 0xd4, 0x8b, 0x2c, 0x92, 0x6f, 0x5a,
+	0x19, 0x40, 0x2a, 0xba, 0x45, 0x73, 0x74, 0x15, 0x50, 0xd1, 0x4a, 0x49, 0x60, 0xe4, 0xf6, 0xd0,
+	0x9c, 0x66, 0x98, 0x00, 0xbc, 0xa2, 0x4e, 0x63, 0x12, 0xcf, 0x59, 0x10, 0xa9, 0x45, 0xab, 0x39,
+	0x30, 0x49, 0x11, 0xce, 0x8b, 0x07, 0xf3, 0xeb, 0x6b, 0x4c, 0xb0, 0x2f, 0xf7, 0x8f, 0x2e, 0xd9,
+	0xf3, 0x38, 0x0b, 0x8a, 0xed, 0x86, 0xa7, 0x31, 0x59, 0xc8, 0x33, 0x4d, 0x71, 0xa6, 0x35, 0x5d,
+	0x42, 0x22, 0x9b, 0xc4, 0x8d, 0xe8, 0x4c, 0x84, 0x8d, 0x1f, 0x02, 0x71, 0xa8, 0xcd, 0x72, 0x28,
+	0x3f, 0x27, 0x7e, 0x8c, 0x30, 0x91, 0x09, 0x36, 0x5a, 0xbd, 0x4a, 0xbf, 0xe2, 0xb4, 0xc7, 0x39,
+	0x94, 0xc7, 0x8f, 0xc7, 0xd5, 0xd8, 0x14, 0xda, 0xba, 0x17, 0x53, 0x66, 0x11, 0x30, 0xcb, 0x12,
+	0xa8, 0x8a, 0xe1, 0x51, 0x81, 0x08, 0xb7, 0xed, 0x5c, 0x8e, 0x96, 0x3c, 0x98, 0x0f, 0xbb, 0xab,
+	0x98, 0xb9, 0x61, 0x26, 0x34, 0x32, 0xbf, 0xdb, 0x2c, 0x0f, 0x3f, 0xfd, 0xa7, 0x01, 0xad, 0xe1,
+	0x4d, 0x40, 0x71, 0x28, 0xd8, 0x04, 0x7a, 0x0e, 0x9b, 0x19, 0x0f, 0x28, 0xea, 0xda, 0xa5, 0x84,
+	0xdd, 0x3c, 0xb0, 0xd7, 0xf0, 0xf5, 0xef, 0xa0, 0x95, 0x21, 0xd0, 0x68, 0xdf, 0x2e, 0x23, 0xe4,
+	0x66, 0xd7, 0x2e, 0xe5, 0xd9, 0xe8, 0x05, 0xb4, 0xf3, 0x7c, 0x15, 0x19, 0xf6, 0x1a, 0xde, 0x6d,
+	0x3e, 0xb0, 0xd7, 0x92, 0xdb, 0x47, 0x8a, 0x7a, 0x22, 0xfe, 0x5f, 0x21, 0x4b, 0x4a, 0xcd, 0x1d,
+	0x7b, 0x95, 0x7d, 0x7e, 0x25, 0xeb, 0x4b, 0x90, 0x2b, 0xb4, 0x6b, 0x17, 0x19, 0x99, 0xb9, 0x67,
+	0x97, 0x11, 0xb2, 0x2f, 0x61, 0x43, 0x31, 0x0b, 0x24, 0x87, 0x4b, 0x96, 0x75, 0x99, 0xc8, 0x2e,
+	0xd0, 0x19, 0x74, 0x04, 0xa0, 0xa0, 0xe3, 0xe1, 0x39, 0xda, 0xb3, 0x4b, 0xb8, 0x8d, 0xb9, 0x6f,
+	0x97, 0x11, 0x16, 0x7e, 0x75, 0xc9, 0x41, 0xc4, 0xd5, 0x02, 0x7b, 0x31, 0xf7, 0x57, 0xd0, 0x65,
+	0x42, 0x32, 0x24, 0x43, 0x24, 0xa4, 0x48, 0x5b, 0xcc, 0xee, 0x2a, 0xac, 0x6e, 0x3f, 0x03, 0x3d,
+	0xd9, 0xe1, 0x08, 0xd9, 0x85, 0x2d, 0x6f, 0xee, 0xda, 0x25, 0x4b, 0x7e, 0x00, 0x5b, 0xb9, 0x75,
+	0x85, 0x0e, 0xec, 0xf2, 0xad, 0x6e, 0x1a, 0xeb, 0x36, 0x5b, 0x6a, 0x23, 0x19, 0xd9, 0x4b, 0x1b,
+	0x2b, 0x4b, 0xc9, 0x34, 0x8a, 0x8a, 0xa5, 0x8d, 0xdc, 0x60, 0x15, 0x36, 0xca, 0x46, 0xbd, 0x69,
+	0x14, 0x15, 0xca, 0xc6, 0x2b, 0xd8, 0x59, 0x6d, 0x49, 0x64, 0xda, 0x6b, 0x07, 0xad, 0xf9, 0x91,
+	0xbd, 0xbe, 0x87, 0x07, 0x87, 0x3f, 0x3d, 0x9c, 0x04, 0xec, 0x66, 0x3e, 0xb6, 0xbd, 0x78, 0xfa,
+	0xe4, 0x47, 0x7c, 0x17, 0x9f, 0x45, 0xde, 0x13, 0x4f, 0xf4, 0xdf, 0x13, 0xef, 0x46, 0x30, 0x91,
+	0xf1, 0xfc, 0x7a, 0xdc, 0x10, 0xbf, 0x9e, 0xfd, 0x37, 0x00, 0xe2, 0xb8, 0x22, 0x23, 0x60, 0x10,
+	0x00, 0x00,
+}
+
+// Reference imports to suppress errors if they are not otherwise used.
+var _ context.Context
+var _ grpc.ClientConn
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+const _ = grpc.SupportPackageIsVersion4
+
+// ChiselAdminClient is the client API for ChiselAdmin service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://godoc.org/google.golang.org/grpc#ClientConn.NewStream.
+type ChiselAdminClient interface {
+	ListSessions(ctx context.Context, in *PbListSessionsRequest, opts ...grpc.CallOption) (*PbListSessionsResponse, error)
+	KillSession(ctx context.Context, in *PbKillSessionRequest, opts ...grpc.CallOption) (*PbKillSessionResponse, error)
+	NotifySessions(ctx context.Context, in *PbNotifySessionsRequest, opts ...grpc.CallOption) (*PbNotifySessionsResponse, error)
+	Drain(ctx context.Context, in *PbDrainRequest, opts ...grpc.CallOption) (*PbDrainResponse, error)
+	ListUsers(ctx context.Context, in *PbListUsersRequest, opts ...grpc.CallOption) (*PbListUsersResponse, error)
+	SetUser(ctx context.Context, in *PbSetUserRequest, opts ...grpc.CallOption) (*PbSetUserResponse, error)
+	SetUserACL(ctx context.Context, in *PbSetUserACLRequest, opts ...grpc.CallOption) (*PbSetUserACLResponse, error)
+	DeleteUser(ctx context.Context, in *PbDeleteUserRequest, opts ...grpc.CallOption) (*PbDeleteUserResponse, error)
+	ApproveUser(ctx context.Context, in *PbApproveUserRequest, opts ...grpc.CallOption) (*PbApproveUserResponse, error)
+	GetStats(ctx context.Context, in *PbGetStatsRequest, opts ...grpc.CallOption) (*PbGetStatsResponse, error)
+	GetServerInfo(ctx context.Context, in *PbGetServerInfoRequest, opts ...grpc.CallOption) (*PbGetServerInfoResponse, error)
+	GetSessionLog(ctx context.Context, in *PbGetSessionLogRequest, opts ...grpc.CallOption) (*PbGetSessionLogResponse, error)
+	DrainListener(ctx context.Context, in *PbDrainListenerRequest, opts ...grpc.CallOption) (*PbDrainListenerResponse, error)
+	ListSessionCosts(ctx context.Context, in *PbListSessionCostsRequest, opts ...grpc.CallOption) (*PbListSessionCostsResponse, error)
+}
+
+type chiselAdminClient struct {
+	cc *grpc.ClientConn
+}
+
+func NewChiselAdminClient(cc *grpc.ClientConn) ChiselAdminClient {
+	return &chiselAdminClient{cc}
+}
+
+func (c *chiselAdminClient) ListSessions(ctx context.Context, in *PbListSessionsRequest, opts ...grpc.CallOption) (*PbListSessionsResponse, error) {
+	out := new(PbListSessionsResponse)
+	err := c.cc.Invoke(ctx, "/ChiselAdmin/ListSessions", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *chiselAdminClient) KillSession(ctx context.Context, in *PbKillSessionRequest, opts ...grpc.CallOption) (*PbKillSessionResponse, error) {
+	out := new(PbKillSessionResponse)
+	err := c.cc.Invoke(ctx, "/ChiselAdmin/KillSession", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *chiselAdminClient) NotifySessions(ctx context.Context, in *PbNotifySessionsRequest, opts ...grpc.CallOption) (*PbNotifySessionsResponse, error) {
+	out := new(PbNotifySessionsResponse)
+	err := c.cc.Invoke(ctx, "/ChiselAdmin/NotifySessions", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *chiselAdminClient) Drain(ctx context.Context, in *PbDrainRequest, opts ...grpc.CallOption) (*PbDrainResponse, error) {
+	out := new(PbDrainResponse)
+	err := c.cc.Invoke(ctx, "/ChiselAdmin/Drain", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *chiselAdminClient) ListUsers(ctx context.Context, in *PbListUsersRequest, opts ...grpc.CallOption) (*PbListUsersResponse, error) {
+	out := new(PbListUsersResponse)
+	err := c.cc.Invoke(ctx, "/ChiselAdmin/ListUsers", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *chiselAdminClient) SetUser(ctx context.Context, in *PbSetUserRequest, opts ...grpc.CallOption) (*PbSetUserResponse, error) {
+	out := new(PbSetUserResponse)
+	err := c.cc.Invoke(ctx, "/ChiselAdmin/SetUser", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *chiselAdminClient) SetUserACL(ctx context.Context, in *PbSetUserACLRequest, opts ...grpc.CallOption) (*PbSetUserACLResponse, error) {
+	out := new(PbSetUserACLResponse)
+	err := c.cc.Invoke(ctx, "/ChiselAdmin/SetUserACL", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *chiselAdminClient) DeleteUser(ctx context.Context, in *PbDeleteUserRequest, opts ...grpc.CallOption) (*PbDeleteUserResponse, error) {
+	out := new(PbDeleteUserResponse)
+	err := c.cc.Invoke(ctx, "/ChiselAdmin/DeleteUser", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *chiselAdminClient) ApproveUser(ctx context.Context, in *PbApproveUserRequest, opts ...grpc.CallOption) (*PbApproveUserResponse, error) {
+	out := new(PbApproveUserResponse)
+	err := c.cc.Invoke(ctx, "/ChiselAdmin/ApproveUser", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *chiselAdminClient) GetStats(ctx context.Context, in *PbGetStatsRequest, opts ...grpc.CallOption) (*PbGetStatsResponse, error) {
+	out := new(PbGetStatsResponse)
+	err := c.cc.Invoke(ctx, "/ChiselAdmin/GetStats", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *chiselAdminClient) GetServerInfo(ctx context.Context, in *PbGetServerInfoRequest, opts ...grpc.CallOption) (*PbGetServerInfoResponse, error) {
+	out := new(PbGetServerInfoResponse)
+	err := c.cc.Invoke(ctx, "/ChiselAdmin/GetServerInfo", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *chiselAdminClient) GetSessionLog(ctx context.Context, in *PbGetSessionLogRequest, opts ...grpc.CallOption) (*PbGetSessionLogResponse, error) {
+	out := new(PbGetSessionLogResponse)
+	err := c.cc.Invoke(ctx, "/ChiselAdmin/GetSessionLog", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *chiselAdminClient) DrainListener(ctx context.Context, in *PbDrainListenerRequest, opts ...grpc.CallOption) (*PbDrainListenerResponse, error) {
+	out := new(PbDrainListenerResponse)
+	err := c.cc.Invoke(ctx, "/ChiselAdmin/DrainListener", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *chiselAdminClient) ListSessionCosts(ctx context.Context, in *PbListSessionCostsRequest, opts ...grpc.CallOption) (*PbListSessionCostsResponse, error) {
+	out := new(PbListSessionCostsResponse)
+	err := c.cc.Invoke(ctx, "/ChiselAdmin/ListSessionCosts", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// ChiselAdminServer is the server API for ChiselAdmin service.
+type ChiselAdminServer interface {
+	ListSessions(context.Context, *PbListSessionsRequest) (*PbListSessionsResponse, error)
+	KillSession(context.Context, *PbKillSessionRequest) (*PbKillSessionResponse, error)
+	NotifySessions(context.Context, *PbNotifySessionsRequest) (*PbNotifySessionsResponse, error)
+	Drain(context.Context, *PbDrainRequest) (*PbDrainResponse, error)
+	ListUsers(context.Context, *PbListUsersRequest) (*PbListUsersResponse, error)
+	SetUser(context.Context, *PbSetUserRequest) (*PbSetUserResponse, error)
+	SetUserACL(context.Context, *PbSetUserACLRequest) (*PbSetUserACLResponse, error)
+	DeleteUser(context.Context, *PbDeleteUserRequest) (*PbDeleteUserResponse, error)
+	ApproveUser(context.Context, *PbApproveUserRequest) (*PbApproveUserResponse, error)
+	GetStats(context.Context, *PbGetStatsRequest) (*PbGetStatsResponse, error)
+	GetServerInfo(context.Context, *PbGetServerInfoRequest) (*PbGetServerInfoResponse, error)
+	GetSessionLog(context.Context, *PbGetSessionLogRequest) (*PbGetSessionLogResponse, error)
+	DrainListener(context.Context, *PbDrainListenerRequest) (*PbDrainListenerResponse, error)
+	ListSessionCosts(context.Context, *PbListSessionCostsRequest) (*PbListSessionCostsResponse, error)
+}
+
+// UnimplementedChiselAdminServer can be embedded to have forward compatible implementations.
+type UnimplementedChiselAdminServer struct {
+}
+
+func (*UnimplementedChiselAdminServer) ListSessions(ctx context.Context, req *PbListSessionsRequest) (*PbListSessionsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListSessions not implemented")
+}
+func (*UnimplementedChiselAdminServer) KillSession(ctx context.Context, req *PbKillSessionRequest) (*PbKillSessionResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method KillSession not implemented")
+}
+func (*UnimplementedChiselAdminServer) NotifySessions(ctx context.Context, req *PbNotifySessionsRequest) (*PbNotifySessionsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method NotifySessions not implemented")
+}
+func (*UnimplementedChiselAdminServer) Drain(ctx context.Context, req *PbDrainRequest) (*PbDrainResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Drain not implemented")
+}
+func (*UnimplementedChiselAdminServer) ListUsers(ctx context.Context, req *PbListUsersRequest) (*PbListUsersResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListUsers not implemented")
+}
+func (*UnimplementedChiselAdminServer) SetUser(ctx context.Context, req *PbSetUserRequest) (*PbSetUserResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method SetUser not implemented")
+}
+func (*UnimplementedChiselAdminServer) SetUserACL(ctx context.Context, req *PbSetUserACLRequest) (*PbSetUserACLResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method SetUserACL not implemented")
+}
+func (*UnimplementedChiselAdminServer) DeleteUser(ctx context.Context, req *PbDeleteUserRequest) (*PbDeleteUserResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method DeleteUser not implemented")
+}
+func (*UnimplementedChiselAdminServer) ApproveUser(ctx context.Context, req *PbApproveUserRequest) (*PbApproveUserResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ApproveUser not implemented")
+}
+func (*UnimplementedChiselAdminServer) GetStats(ctx context.Context, req *PbGetStatsRequest) (*PbGetStatsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetStats not implemented")
+}
+func (*UnimplementedChiselAdminServer) GetServerInfo(ctx context.Context, req *PbGetServerInfoRequest) (*PbGetServerInfoResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetServerInfo not implemented")
+}
+func (*UnimplementedChiselAdminServer) GetSessionLog(ctx context.Context, req *PbGetSessionLogRequest) (*PbGetSessionLogResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetSessionLog not implemented")
+}
+func (*UnimplementedChiselAdminServer) DrainListener(ctx context.Context, req *PbDrainListenerRequest) (*PbDrainListenerResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method DrainListener not implemented")
+}
+func (*UnimplementedChiselAdminServer) ListSessionCosts(ctx context.Context, req *PbListSessionCostsRequest) (*PbListSessionCostsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListSessionCosts not implemented")
+}
+
+func RegisterChiselAdminServer(s *grpc.Server, srv ChiselAdminServer) {
+	s.RegisterService(&_ChiselAdmin_serviceDesc, srv)
+}
+
+func _ChiselAdmin_ListSessions_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(PbListSessionsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ChiselAdminServer).ListSessions(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/ChiselAdmin/ListSessions",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ChiselAdminServer).ListSessions(ctx, req.(*PbListSessionsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ChiselAdmin_KillSession_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(PbKillSessionRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ChiselAdminServer).KillSession(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/ChiselAdmin/KillSession",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ChiselAdminServer).KillSession(ctx, req.(*PbKillSessionRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ChiselAdmin_NotifySessions_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(PbNotifySessionsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ChiselAdminServer).NotifySessions(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/ChiselAdmin/NotifySessions",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ChiselAdminServer).NotifySessions(ctx, req.(*PbNotifySessionsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ChiselAdmin_Drain_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(PbDrainRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ChiselAdminServer).Drain(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/ChiselAdmin/Drain",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ChiselAdminServer).Drain(ctx, req.(*PbDrainRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ChiselAdmin_ListUsers_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(PbListUsersRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ChiselAdminServer).ListUsers(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/ChiselAdmin/ListUsers",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ChiselAdminServer).ListUsers(ctx, req.(*PbListUsersRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ChiselAdmin_SetUser_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(PbSetUserRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ChiselAdminServer).SetUser(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/ChiselAdmin/SetUser",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ChiselAdminServer).SetUser(ctx, req.(*PbSetUserRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ChiselAdmin_SetUserACL_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(PbSetUserACLRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ChiselAdminServer).SetUserACL(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/ChiselAdmin/SetUserACL",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ChiselAdminServer).SetUserACL(ctx, req.(*PbSetUserACLRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ChiselAdmin_DeleteUser_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(PbDeleteUserRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ChiselAdminServer).DeleteUser(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/ChiselAdmin/DeleteUser",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ChiselAdminServer).DeleteUser(ctx, req.(*PbDeleteUserRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ChiselAdmin_ApproveUser_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(PbApproveUserRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ChiselAdminServer).ApproveUser(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/ChiselAdmin/ApproveUser",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ChiselAdminServer).ApproveUser(ctx, req.(*PbApproveUserRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ChiselAdmin_GetStats_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(PbGetStatsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ChiselAdminServer).GetStats(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/ChiselAdmin/GetStats",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ChiselAdminServer).GetStats(ctx, req.(*PbGetStatsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ChiselAdmin_GetServerInfo_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(PbGetServerInfoRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ChiselAdminServer).GetServerInfo(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/ChiselAdmin/GetServerInfo",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ChiselAdminServer).GetServerInfo(ctx, req.(*PbGetServerInfoRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ChiselAdmin_GetSessionLog_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(PbGetSessionLogRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ChiselAdminServer).GetSessionLog(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/ChiselAdmin/GetSessionLog",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ChiselAdminServer).GetSessionLog(ctx, req.(*PbGetSessionLogRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ChiselAdmin_DrainListener_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(PbDrainListenerRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ChiselAdminServer).DrainListener(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/ChiselAdmin/DrainListener",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ChiselAdminServer).DrainListener(ctx, req.(*PbDrainListenerRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ChiselAdmin_ListSessionCosts_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(PbListSessionCostsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ChiselAdminServer).ListSessionCosts(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/ChiselAdmin/ListSessionCosts",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ChiselAdminServer).ListSessionCosts(ctx, req.(*PbListSessionCostsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+var _ChiselAdmin_serviceDesc = grpc.ServiceDesc{
+	ServiceName: "ChiselAdmin",
+	HandlerType: (*ChiselAdminServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "ListSessions",
+			Handler:    _ChiselAdmin_ListSessions_Handler,
+		},
+		{
+			MethodName: "KillSession",
+			Handler:    _ChiselAdmin_KillSession_Handler,
+		},
+		{
+			MethodName: "NotifySessions",
+			Handler:    _ChiselAdmin_NotifySessions_Handler,
+		},
+		{
+			MethodName: "Drain",
+			Handler:    _ChiselAdmin_Drain_Handler,
+		},
+		{
+			MethodName: "ListUsers",
+			Handler:    _ChiselAdmin_ListUsers_Handler,
+		},
+		{
+			MethodName: "SetUser",
+			Handler:    _ChiselAdmin_SetUser_Handler,
+		},
+		{
+			MethodName: "SetUserACL",
+			Handler:    _ChiselAdmin_SetUserACL_Handler,
+		},
+		{
+			MethodName: "DeleteUser",
+			Handler:    _ChiselAdmin_DeleteUser_Handler,
+		},
+		{
+			MethodName: "ApproveUser",
+			Handler:    _ChiselAdmin_ApproveUser_Handler,
+		},
+		{
+			MethodName: "GetStats",
+			Handler:    _ChiselAdmin_GetStats_Handler,
+		},
+		{
+			MethodName: "GetServerInfo",
+			Handler:    _ChiselAdmin_GetServerInfo_Handler,
+		},
+		{
+			MethodName: "GetSessionLog",
+			Handler:    _ChiselAdmin_GetSessionLog_Handler,
+		},
+		{
+			MethodName: "DrainListener",
+			Handler:    _ChiselAdmin_DrainListener_Handler,
+		},
+		{
+			MethodName: "ListSessionCosts",
+			Handler:    _ChiselAdmin_ListSessionCosts_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "admin.proto",
+}
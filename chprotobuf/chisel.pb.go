@@ -49,12 +49,13 @@ func (PbEndpointRole) EnumDescriptor() ([]byte, []int) {
 }
 
 type PbEndpointDescriptor struct {
-	Role                 PbEndpointRole `protobuf:"varint,1,opt,name=Role,json=role,proto3,enum=PbEndpointRole" json:"Role,omitempty"`
-	Type                 string         `protobuf:"bytes,2,opt,name=Type,json=type,proto3" json:"Type,omitempty"`
-	Path                 string         `protobuf:"bytes,3,opt,name=Path,json=path,proto3" json:"Path,omitempty"`
-	XXX_NoUnkeyedLiteral struct{}       `json:"-"`
-	XXX_unrecognized     []byte         `json:"-"`
-	XXX_sizecache        int32          `json:"-"`
+	Role                 PbEndpointRole    `protobuf:"varint,1,opt,name=Role,json=role,proto3,enum=PbEndpointRole" json:"Role,omitempty"`
+	Type                 string            `protobuf:"bytes,2,opt,name=Type,json=type,proto3" json:"Type,omitempty"`
+	Path                 string            `protobuf:"bytes,3,opt,name=Path,json=path,proto3" json:"Path,omitempty"`
+	Options              map[string]string `protobuf:"bytes,4,rep,name=Options,json=options,proto3" json:"Options,omitempty" protobuf_key:"bytes,1,opt,name=key,proto3" protobuf_val:"bytes,2,opt,name=value,proto3"`
+	XXX_NoUnkeyedLiteral struct{}          `json:"-"`
+	XXX_unrecognized     []byte            `json:"-"`
+	XXX_sizecache        int32             `json:"-"`
 }
 
 func (m *PbEndpointDescriptor) Reset()         { *m = PbEndpointDescriptor{} }
@@ -103,6 +104,13 @@ func (m *PbEndpointDescriptor) GetPath() string {
 	return ""
 }
 
+func (m *PbEndpointDescriptor) GetOptions() map[string]string {
+	if m != nil {
+		return m.Options
+	}
+	return nil
+}
+
 type PbChannelDescriptor struct {
 	Reverse              bool                  `protobuf:"varint,1,opt,name=Reverse,json=reverse,proto3" json:"Reverse,omitempty"`
 	StubDescriptor       *PbEndpointDescriptor `protobuf:"bytes,2,opt,name=StubDescriptor,json=stubDescriptor,proto3" json:"StubDescriptor,omitempty"`
@@ -161,6 +169,11 @@ func (m *PbChannelDescriptor) GetSkeletonDescriptor() *PbEndpointDescriptor {
 type PbSessionConfigRequest struct {
 	ClientVersion        string                 `protobuf:"bytes,1,opt,name=ClientVersion,json=clientVersion,proto3" json:"ClientVersion,omitempty"`
 	ChannelDescriptors   []*PbChannelDescriptor `protobuf:"bytes,2,rep,name=ChannelDescriptors,json=channelDescriptors,proto3" json:"ChannelDescriptors,omitempty"`
+	WantReply            bool                   `protobuf:"varint,3,opt,name=WantReply,json=wantReply,proto3" json:"WantReply,omitempty"`
+	SessionName          string                 `protobuf:"bytes,4,opt,name=SessionName,json=sessionName,proto3" json:"SessionName,omitempty"`
+	AllowPartial         bool                   `protobuf:"varint,5,opt,name=AllowPartial,json=allowPartial,proto3" json:"AllowPartial,omitempty"`
+	DeviceKey            []byte                 `protobuf:"bytes,6,opt,name=DeviceKey,json=deviceKey,proto3" json:"DeviceKey,omitempty"`
+	DeviceSignature      []byte                 `protobuf:"bytes,7,opt,name=DeviceSignature,json=deviceSignature,proto3" json:"DeviceSignature,omitempty"`
 	XXX_NoUnkeyedLiteral struct{}               `json:"-"`
 	XXX_unrecognized     []byte                 `json:"-"`
 	XXX_sizecache        int32                  `json:"-"`
@@ -205,6 +218,331 @@ func (m *PbSessionConfigRequest) GetChannelDescriptors() []*PbChannelDescriptor
 	return nil
 }
 
+func (m *PbSessionConfigRequest) GetWantReply() bool {
+	if m != nil {
+		return m.WantReply
+	}
+	return false
+}
+
+func (m *PbSessionConfigRequest) GetSessionName() string {
+	if m != nil {
+		return m.SessionName
+	}
+	return ""
+}
+
+func (m *PbSessionConfigRequest) GetAllowPartial() bool {
+	if m != nil {
+		return m.AllowPartial
+	}
+	return false
+}
+
+func (m *PbSessionConfigRequest) GetDeviceKey() []byte {
+	if m != nil {
+		return m.DeviceKey
+	}
+	return nil
+}
+
+func (m *PbSessionConfigRequest) GetDeviceSignature() []byte {
+	if m != nil {
+		return m.DeviceSignature
+	}
+	return nil
+}
+
+type PbSessionNotice struct {
+	Message              string   `protobuf:"bytes,1,opt,name=Message,json=message,proto3" json:"Message,omitempty"`
+	ReconnectByUnix      int64    `protobuf:"varint,2,opt,name=ReconnectByUnix,json=reconnectByUnix,proto3" json:"ReconnectByUnix,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *PbSessionNotice) Reset()         { *m = PbSessionNotice{} }
+func (m *PbSessionNotice) String() string { return proto.CompactTextString(m) }
+func (*PbSessionNotice) ProtoMessage()    {}
+func (*PbSessionNotice) Descriptor() ([]byte, []int) {
+	return fileDescriptor_166ce0f0cfe77f00, []int{3}
+}
+
+func (m *PbSessionNotice) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_PbSessionNotice.Unmarshal(m, b)
+}
+func (m *PbSessionNotice) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_PbSessionNotice.Marshal(b, m, deterministic)
+}
+func (m *PbSessionNotice) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_PbSessionNotice.Merge(m, src)
+}
+func (m *PbSessionNotice) XXX_Size() int {
+	return xxx_messageInfo_PbSessionNotice.Size(m)
+}
+func (m *PbSessionNotice) XXX_DiscardUnknown() {
+	xxx_messageInfo_PbSessionNotice.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_PbSessionNotice proto.InternalMessageInfo
+
+func (m *PbSessionNotice) GetMessage() string {
+	if m != nil {
+		return m.Message
+	}
+	return ""
+}
+
+func (m *PbSessionNotice) GetReconnectByUnix() int64 {
+	if m != nil {
+		return m.ReconnectByUnix
+	}
+	return 0
+}
+
+type PbChannelStats struct {
+	ChannelDescriptor    string   `protobuf:"bytes,1,opt,name=ChannelDescriptor,json=channelDescriptor,proto3" json:"ChannelDescriptor,omitempty"`
+	Conns                int64    `protobuf:"varint,2,opt,name=Conns,json=conns,proto3" json:"Conns,omitempty"`
+	BytesToCalled        int64    `protobuf:"varint,3,opt,name=BytesToCalled,json=bytesToCalled,proto3" json:"BytesToCalled,omitempty"`
+	BytesToCaller        int64    `protobuf:"varint,4,opt,name=BytesToCaller,json=bytesToCaller,proto3" json:"BytesToCaller,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *PbChannelStats) Reset()         { *m = PbChannelStats{} }
+func (m *PbChannelStats) String() string { return proto.CompactTextString(m) }
+func (*PbChannelStats) ProtoMessage()    {}
+func (*PbChannelStats) Descriptor() ([]byte, []int) {
+	return fileDescriptor_166ce0f0cfe77f00, []int{4}
+}
+
+func (m *PbChannelStats) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_PbChannelStats.Unmarshal(m, b)
+}
+func (m *PbChannelStats) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_PbChannelStats.Marshal(b, m, deterministic)
+}
+func (m *PbChannelStats) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_PbChannelStats.Merge(m, src)
+}
+func (m *PbChannelStats) XXX_Size() int {
+	return xxx_messageInfo_PbChannelStats.Size(m)
+}
+func (m *PbChannelStats) XXX_DiscardUnknown() {
+	xxx_messageInfo_PbChannelStats.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_PbChannelStats proto.InternalMessageInfo
+
+func (m *PbChannelStats) GetChannelDescriptor() string {
+	if m != nil {
+		return m.ChannelDescriptor
+	}
+	return ""
+}
+
+func (m *PbChannelStats) GetConns() int64 {
+	if m != nil {
+		return m.Conns
+	}
+	return 0
+}
+
+func (m *PbChannelStats) GetBytesToCalled() int64 {
+	if m != nil {
+		return m.BytesToCalled
+	}
+	return 0
+}
+
+func (m *PbChannelStats) GetBytesToCaller() int64 {
+	if m != nil {
+		return m.BytesToCaller
+	}
+	return 0
+}
+
+type PbStatsUpdate struct {
+	Channels             []*PbChannelStats `protobuf:"bytes,1,rep,name=Channels,json=channels,proto3" json:"Channels,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}          `json:"-"`
+	XXX_unrecognized     []byte            `json:"-"`
+	XXX_sizecache        int32             `json:"-"`
+}
+
+func (m *PbStatsUpdate) Reset()         { *m = PbStatsUpdate{} }
+func (m *PbStatsUpdate) String() string { return proto.CompactTextString(m) }
+func (*PbStatsUpdate) ProtoMessage()    {}
+func (*PbStatsUpdate) Descriptor() ([]byte, []int) {
+	return fileDescriptor_166ce0f0cfe77f00, []int{5}
+}
+
+func (m *PbStatsUpdate) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_PbStatsUpdate.Unmarshal(m, b)
+}
+func (m *PbStatsUpdate) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_PbStatsUpdate.Marshal(b, m, deterministic)
+}
+func (m *PbStatsUpdate) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_PbStatsUpdate.Merge(m, src)
+}
+func (m *PbStatsUpdate) XXX_Size() int {
+	return xxx_messageInfo_PbStatsUpdate.Size(m)
+}
+func (m *PbStatsUpdate) XXX_DiscardUnknown() {
+	xxx_messageInfo_PbStatsUpdate.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_PbStatsUpdate proto.InternalMessageInfo
+
+func (m *PbStatsUpdate) GetChannels() []*PbChannelStats {
+	if m != nil {
+		return m.Channels
+	}
+	return nil
+}
+
+type PbBoundAddr struct {
+	ChannelDescriptor    string   `protobuf:"bytes,1,opt,name=ChannelDescriptor,json=channelDescriptor,proto3" json:"ChannelDescriptor,omitempty"`
+	Addr                 string   `protobuf:"bytes,2,opt,name=Addr,json=addr,proto3" json:"Addr,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *PbBoundAddr) Reset()         { *m = PbBoundAddr{} }
+func (m *PbBoundAddr) String() string { return proto.CompactTextString(m) }
+func (*PbBoundAddr) ProtoMessage()    {}
+func (*PbBoundAddr) Descriptor() ([]byte, []int) {
+	return fileDescriptor_166ce0f0cfe77f00, []int{6}
+}
+
+func (m *PbBoundAddr) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_PbBoundAddr.Unmarshal(m, b)
+}
+func (m *PbBoundAddr) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_PbBoundAddr.Marshal(b, m, deterministic)
+}
+func (m *PbBoundAddr) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_PbBoundAddr.Merge(m, src)
+}
+func (m *PbBoundAddr) XXX_Size() int {
+	return xxx_messageInfo_PbBoundAddr.Size(m)
+}
+func (m *PbBoundAddr) XXX_DiscardUnknown() {
+	xxx_messageInfo_PbBoundAddr.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_PbBoundAddr proto.InternalMessageInfo
+
+func (m *PbBoundAddr) GetChannelDescriptor() string {
+	if m != nil {
+		return m.ChannelDescriptor
+	}
+	return ""
+}
+
+func (m *PbBoundAddr) GetAddr() string {
+	if m != nil {
+		return m.Addr
+	}
+	return ""
+}
+
+type PbChannelError struct {
+	ChannelDescriptor    string   `protobuf:"bytes,1,opt,name=ChannelDescriptor,json=channelDescriptor,proto3" json:"ChannelDescriptor,omitempty"`
+	Error                string   `protobuf:"bytes,2,opt,name=Error,json=error,proto3" json:"Error,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *PbChannelError) Reset()         { *m = PbChannelError{} }
+func (m *PbChannelError) String() string { return proto.CompactTextString(m) }
+func (*PbChannelError) ProtoMessage()    {}
+func (*PbChannelError) Descriptor() ([]byte, []int) {
+	return fileDescriptor_166ce0f0cfe77f00, []int{7}
+}
+
+func (m *PbChannelError) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_PbChannelError.Unmarshal(m, b)
+}
+func (m *PbChannelError) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_PbChannelError.Marshal(b, m, deterministic)
+}
+func (m *PbChannelError) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_PbChannelError.Merge(m, src)
+}
+func (m *PbChannelError) XXX_Size() int {
+	return xxx_messageInfo_PbChannelError.Size(m)
+}
+func (m *PbChannelError) XXX_DiscardUnknown() {
+	xxx_messageInfo_PbChannelError.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_PbChannelError proto.InternalMessageInfo
+
+func (m *PbChannelError) GetChannelDescriptor() string {
+	if m != nil {
+		return m.ChannelDescriptor
+	}
+	return ""
+}
+
+func (m *PbChannelError) GetError() string {
+	if m != nil {
+		return m.Error
+	}
+	return ""
+}
+
+type PbChannelsReply struct {
+	BoundAddrs           []*PbBoundAddr    `protobuf:"bytes,1,rep,name=BoundAddrs,json=boundAddrs,proto3" json:"BoundAddrs,omitempty"`
+	Errors               []*PbChannelError `protobuf:"bytes,2,rep,name=Errors,json=errors,proto3" json:"Errors,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}          `json:"-"`
+	XXX_unrecognized     []byte            `json:"-"`
+	XXX_sizecache        int32             `json:"-"`
+}
+
+func (m *PbChannelsReply) Reset()         { *m = PbChannelsReply{} }
+func (m *PbChannelsReply) String() string { return proto.CompactTextString(m) }
+func (*PbChannelsReply) ProtoMessage()    {}
+func (*PbChannelsReply) Descriptor() ([]byte, []int) {
+	return fileDescriptor_166ce0f0cfe77f00, []int{8}
+}
+
+func (m *PbChannelsReply) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_PbChannelsReply.Unmarshal(m, b)
+}
+func (m *PbChannelsReply) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_PbChannelsReply.Marshal(b, m, deterministic)
+}
+func (m *PbChannelsReply) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_PbChannelsReply.Merge(m, src)
+}
+func (m *PbChannelsReply) XXX_Size() int {
+	return xxx_messageInfo_PbChannelsReply.Size(m)
+}
+func (m *PbChannelsReply) XXX_DiscardUnknown() {
+	xxx_messageInfo_PbChannelsReply.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_PbChannelsReply proto.InternalMessageInfo
+
+func (m *PbChannelsReply) GetBoundAddrs() []*PbBoundAddr {
+	if m != nil {
+		return m.BoundAddrs
+	}
+	return nil
+}
+
+func (m *PbChannelsReply) GetErrors() []*PbChannelError {
+	if m != nil {
+		return m.Errors
+	}
+	return nil
+}
+
 type PbDialRequest struct {
 	UseDescriptor          bool                  `protobuf:"varint,1,opt,name=UseDescriptor,json=useDescriptor,proto3" json:"UseDescriptor,omitempty"`
 	ChannelDescriptorIndex int32                 `protobuf:"varint,2,opt,name=ChannelDescriptorIndex,json=channelDescriptorIndex,proto3" json:"ChannelDescriptorIndex,omitempty"`
@@ -219,7 +557,7 @@ func (m *PbDialRequest) Reset()         { *m = PbDialRequest{} }
 func (m *PbDialRequest) String() string { return proto.CompactTextString(m) }
 func (*PbDialRequest) ProtoMessage()    {}
 func (*PbDialRequest) Descriptor() ([]byte, []int) {
-	return fileDescriptor_166ce0f0cfe77f00, []int{3}
+	return fileDescriptor_166ce0f0cfe77f00, []int{9}
 }
 
 func (m *PbDialRequest) XXX_Unmarshal(b []byte) error {
@@ -268,42 +606,130 @@ func (m *PbDialRequest) GetStubName() string {
 	return ""
 }
 
+type PbDynamicChannelsRequest struct {
+	AddChannelDescriptors    []*PbChannelDescriptor `protobuf:"bytes,1,rep,name=AddChannelDescriptors,json=addChannelDescriptors,proto3" json:"AddChannelDescriptors,omitempty"`
+	RemoveChannelDescriptors []*PbChannelDescriptor `protobuf:"bytes,2,rep,name=RemoveChannelDescriptors,json=removeChannelDescriptors,proto3" json:"RemoveChannelDescriptors,omitempty"`
+	WantReply                bool                   `protobuf:"varint,3,opt,name=WantReply,json=wantReply,proto3" json:"WantReply,omitempty"`
+	XXX_NoUnkeyedLiteral     struct{}               `json:"-"`
+	XXX_unrecognized         []byte                 `json:"-"`
+	XXX_sizecache            int32                  `json:"-"`
+}
+
+func (m *PbDynamicChannelsRequest) Reset()         { *m = PbDynamicChannelsRequest{} }
+func (m *PbDynamicChannelsRequest) String() string { return proto.CompactTextString(m) }
+func (*PbDynamicChannelsRequest) ProtoMessage()    {}
+func (*PbDynamicChannelsRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_166ce0f0cfe77f00, []int{10}
+}
+
+func (m *PbDynamicChannelsRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_PbDynamicChannelsRequest.Unmarshal(m, b)
+}
+func (m *PbDynamicChannelsRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_PbDynamicChannelsRequest.Marshal(b, m, deterministic)
+}
+func (m *PbDynamicChannelsRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_PbDynamicChannelsRequest.Merge(m, src)
+}
+func (m *PbDynamicChannelsRequest) XXX_Size() int {
+	return xxx_messageInfo_PbDynamicChannelsRequest.Size(m)
+}
+func (m *PbDynamicChannelsRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_PbDynamicChannelsRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_PbDynamicChannelsRequest proto.InternalMessageInfo
+
+func (m *PbDynamicChannelsRequest) GetAddChannelDescriptors() []*PbChannelDescriptor {
+	if m != nil {
+		return m.AddChannelDescriptors
+	}
+	return nil
+}
+
+func (m *PbDynamicChannelsRequest) GetRemoveChannelDescriptors() []*PbChannelDescriptor {
+	if m != nil {
+		return m.RemoveChannelDescriptors
+	}
+	return nil
+}
+
+func (m *PbDynamicChannelsRequest) GetWantReply() bool {
+	if m != nil {
+		return m.WantReply
+	}
+	return false
+}
+
 func init() {
 	proto.RegisterEnum("PbEndpointRole", PbEndpointRole_name, PbEndpointRole_value)
 	proto.RegisterType((*PbEndpointDescriptor)(nil), "PbEndpointDescriptor")
+	proto.RegisterMapType((map[string]string)(nil), "PbEndpointDescriptor.OptionsEntry")
 	proto.RegisterType((*PbChannelDescriptor)(nil), "PbChannelDescriptor")
 	proto.RegisterType((*PbSessionConfigRequest)(nil), "PbSessionConfigRequest")
+	proto.RegisterType((*PbSessionNotice)(nil), "PbSessionNotice")
+	proto.RegisterType((*PbChannelStats)(nil), "PbChannelStats")
+	proto.RegisterType((*PbStatsUpdate)(nil), "PbStatsUpdate")
+	proto.RegisterType((*PbBoundAddr)(nil), "PbBoundAddr")
+	proto.RegisterType((*PbChannelError)(nil), "PbChannelError")
+	proto.RegisterType((*PbChannelsReply)(nil), "PbChannelsReply")
 	proto.RegisterType((*PbDialRequest)(nil), "PbDialRequest")
+	proto.RegisterType((*PbDynamicChannelsRequest)(nil), "PbDynamicChannelsRequest")
 }
 
 func init() { proto.RegisterFile("chisel.proto", fileDescriptor_166ce0f0cfe77f00) }
 
 var fileDescriptor_166ce0f0cfe77f00 = []byte{
-	// 402 bytes of a gzipped FileDescriptorProto
-	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0xac, 0x52, 0xc1, 0x6e, 0xd3, 0x40,
-	0x10, 0xc5, 0x89, 0x21, 0xee, 0xa4, 0x09, 0xd1, 0x50, 0x22, 0x8b, 0x53, 0x14, 0x2a, 0x14, 0x71,
-	0x70, 0xa4, 0x20, 0xb8, 0x71, 0x69, 0x92, 0x43, 0x55, 0xe4, 0x5a, 0xeb, 0x04, 0x10, 0x37, 0xef,
-	0x76, 0x5a, 0xaf, 0x70, 0x77, 0x8d, 0x77, 0x5d, 0xd1, 0x3b, 0xbf, 0xc4, 0x7f, 0xf0, 0x49, 0x28,
-	0x36, 0x52, 0x1d, 0x39, 0xe2, 0xd4, 0xdb, 0xce, 0x9b, 0xb7, 0xf3, 0x76, 0xde, 0x3e, 0x38, 0x16,
-	0xa9, 0x34, 0x94, 0x05, 0x79, 0xa1, 0xad, 0x9e, 0x0a, 0x38, 0x89, 0xf8, 0x5a, 0x5d, 0xe5, 0x5a,
-	0x2a, 0xbb, 0x22, 0x23, 0x0a, 0x99, 0x5b, 0x5d, 0xe0, 0x6b, 0x70, 0x99, 0xce, 0xc8, 0x77, 0x26,
-	0xce, 0x6c, 0xb8, 0x78, 0x1e, 0x3c, 0x90, 0x76, 0x30, 0x73, 0x0b, 0x9d, 0x11, 0x22, 0xb8, 0x9b,
-	0xfb, 0x9c, 0xfc, 0xce, 0xc4, 0x99, 0x1d, 0x31, 0xd7, 0xde, 0xe7, 0x15, 0x16, 0x25, 0x36, 0xf5,
-	0xbb, 0x35, 0x96, 0x27, 0x36, 0x9d, 0xfe, 0x76, 0xe0, 0x45, 0xc4, 0x97, 0x69, 0xa2, 0x14, 0x65,
-	0x0d, 0x11, 0x1f, 0x7a, 0x8c, 0xee, 0xa8, 0x30, 0xb5, 0x8e, 0xc7, 0x7a, 0x45, 0x5d, 0xe2, 0x47,
-	0x18, 0xc6, 0xb6, 0xe4, 0x0f, 0xdc, 0x4a, 0xa3, 0xbf, 0x78, 0x19, 0x1c, 0x7a, 0x2d, 0x1b, 0x9a,
-	0x3d, 0x32, 0xae, 0x01, 0xe3, 0xef, 0x94, 0x91, 0xd5, 0xaa, 0x31, 0xa2, 0xfb, 0xbf, 0x11, 0x68,
-	0x5a, 0x17, 0xa6, 0xbf, 0x1c, 0x18, 0x47, 0x3c, 0x26, 0x63, 0xa4, 0x56, 0x4b, 0xad, 0xae, 0xe5,
-	0x0d, 0xa3, 0x1f, 0x25, 0x19, 0x8b, 0xa7, 0x30, 0x58, 0x66, 0x92, 0x94, 0xfd, 0x4c, 0xc5, 0xae,
-	0x5b, 0x2d, 0x70, 0xc4, 0x06, 0xa2, 0x09, 0xe2, 0x0a, 0xb0, 0xb5, 0xb5, 0xf1, 0x3b, 0x93, 0xee,
-	0xac, 0xbf, 0x38, 0x09, 0x0e, 0x58, 0xc2, 0x50, 0xb4, 0xf8, 0xd3, 0x3f, 0x0e, 0x0c, 0x22, 0xbe,
-	0x92, 0x49, 0xd6, 0x50, 0xdf, 0x1a, 0x6a, 0xac, 0x56, 0xdb, 0x37, 0x28, 0x9b, 0x20, 0x7e, 0x80,
-	0x71, 0x4b, 0xe0, 0x5c, 0x5d, 0xd1, 0xcf, 0xca, 0xcc, 0xa7, 0x6c, 0x2c, 0x0e, 0x76, 0x1f, 0xc9,
-	0x3d, 0x7c, 0x05, 0xde, 0xee, 0x0f, 0xc3, 0xe4, 0x96, 0x7c, 0xb7, 0x72, 0xc7, 0x33, 0xff, 0xea,
-	0xb7, 0xef, 0x61, 0xb8, 0x9f, 0x28, 0xec, 0x43, 0x6f, 0x1b, 0x5e, 0x84, 0x97, 0x5f, 0xc2, 0xd1,
-	0x13, 0xf4, 0xc0, 0x8d, 0x37, 0xdb, 0xb3, 0x91, 0x83, 0xc7, 0xe0, 0xc5, 0x17, 0xeb, 0x4f, 0xeb,
-	0xcd, 0x65, 0x38, 0xea, 0x9c, 0xbd, 0xf9, 0x76, 0x7a, 0x23, 0x6d, 0x5a, 0xf2, 0x40, 0xe8, 0xdb,
-	0xf9, 0x57, 0xba, 0xd3, 0xe7, 0x4a, 0xcc, 0xeb, 0x40, 0xcf, 0x45, 0x5a, 0x45, 0x9a, 0x97, 0xd7,
-	0xfc, 0x59, 0x75, 0x7a, 0xf7, 0x37, 0x00, 0x00, 0xff, 0xff, 0x00, 0x75, 0xb2, 0xd0, 0xec, 0x02,
-	0x00, 0x00,
+	// 806 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0xac, 0x55, 0xdd, 0x6e, 0xe3, 0x44,
+	0x14, 0xc6, 0x49, 0xda, 0x38, 0x27, 0x7f, 0xdd, 0xa1, 0xad, 0xac, 0x15, 0x17, 0x91, 0x59, 0x41,
+	0x04, 0xab, 0x54, 0x2a, 0x02, 0xa1, 0xd5, 0x72, 0xd1, 0xfc, 0x5c, 0x2c, 0x85, 0xd4, 0x9a, 0x24,
+	0x2c, 0xe2, 0x6e, 0x6c, 0x9f, 0x6d, 0xac, 0x75, 0x66, 0xcc, 0xcc, 0x24, 0x8b, 0xdf, 0x86, 0x47,
+	0xe0, 0x86, 0xf7, 0xe0, 0x8a, 0x3b, 0xde, 0x05, 0x79, 0xec, 0x34, 0x6e, 0x13, 0x2a, 0x55, 0xe2,
+	0xce, 0xe7, 0x9b, 0x33, 0x67, 0xbe, 0xef, 0x3b, 0x9e, 0x33, 0xd0, 0x0a, 0x96, 0x91, 0xc2, 0x78,
+	0x90, 0x48, 0xa1, 0x85, 0xfb, 0x8f, 0x05, 0xa7, 0x9e, 0x3f, 0xe1, 0x61, 0x22, 0x22, 0xae, 0xc7,
+	0xa8, 0x02, 0x19, 0x25, 0x5a, 0x48, 0xf2, 0x29, 0xd4, 0xa8, 0x88, 0xd1, 0xb1, 0x7a, 0x56, 0xbf,
+	0x73, 0xd9, 0x1d, 0xec, 0x92, 0x32, 0x98, 0xd6, 0xa4, 0x88, 0x91, 0x10, 0xa8, 0xcd, 0xd3, 0x04,
+	0x9d, 0x4a, 0xcf, 0xea, 0x37, 0x68, 0x4d, 0xa7, 0x89, 0xc1, 0x3c, 0xa6, 0x97, 0x4e, 0x35, 0xc7,
+	0x12, 0xa6, 0x97, 0xe4, 0x35, 0xd4, 0x6f, 0x12, 0x1d, 0x09, 0xae, 0x9c, 0x5a, 0xaf, 0xda, 0x6f,
+	0x5e, 0xba, 0x83, 0x43, 0x87, 0x0e, 0x8a, 0xa4, 0x09, 0xd7, 0x32, 0xa5, 0x75, 0x91, 0x47, 0xcf,
+	0x5f, 0x41, 0xab, 0xbc, 0x40, 0x4e, 0xa0, 0xfa, 0x1e, 0x53, 0xc3, 0xac, 0x41, 0xb3, 0x4f, 0x72,
+	0x0a, 0x47, 0x1b, 0x16, 0xaf, 0xb7, 0x44, 0xf2, 0xe0, 0x55, 0xe5, 0x5b, 0xcb, 0xfd, 0xd3, 0x82,
+	0x8f, 0x3d, 0x7f, 0xb4, 0x64, 0x9c, 0x63, 0x5c, 0x92, 0xe7, 0x40, 0x9d, 0xe2, 0x06, 0xa5, 0xca,
+	0x15, 0xda, 0xb4, 0x2e, 0xf3, 0x90, 0x7c, 0x07, 0x9d, 0x99, 0x5e, 0xfb, 0xbb, 0x5c, 0x53, 0xb4,
+	0x79, 0x79, 0x76, 0x90, 0x32, 0xed, 0xa8, 0x7b, 0xc9, 0x64, 0x02, 0x64, 0xf6, 0x1e, 0x63, 0xd4,
+	0x82, 0x97, 0x4a, 0x54, 0x1f, 0x2b, 0x41, 0xd4, 0xde, 0x06, 0xf7, 0x8f, 0x0a, 0x9c, 0x7b, 0xfe,
+	0x0c, 0x95, 0x8a, 0x04, 0x1f, 0x09, 0xfe, 0x2e, 0xba, 0xa5, 0xf8, 0xeb, 0x1a, 0x95, 0x26, 0x2f,
+	0xa0, 0x3d, 0x8a, 0x23, 0xe4, 0xfa, 0x27, 0x94, 0xd9, 0x6a, 0x61, 0x44, 0x3b, 0x28, 0x83, 0x64,
+	0x0c, 0x64, 0x4f, 0xb5, 0x72, 0x2a, 0xc6, 0xfd, 0xd3, 0xc1, 0x01, 0x4b, 0x28, 0x09, 0xf6, 0xf2,
+	0xc9, 0x27, 0xd0, 0x78, 0xcb, 0xb8, 0xa6, 0x98, 0xc4, 0xa9, 0x11, 0x61, 0xd3, 0xc6, 0x87, 0x2d,
+	0x40, 0x7a, 0xd0, 0x2c, 0x18, 0x4e, 0xd9, 0x0a, 0x9d, 0x9a, 0xe1, 0xd1, 0x54, 0x3b, 0x88, 0xb8,
+	0xd0, 0xba, 0x8a, 0x63, 0xf1, 0xc1, 0x63, 0x52, 0x47, 0x2c, 0x76, 0x8e, 0x4c, 0x89, 0x16, 0x2b,
+	0x61, 0xd9, 0x19, 0x63, 0xdc, 0x44, 0x01, 0x5e, 0x63, 0xea, 0x1c, 0xf7, 0xac, 0x7e, 0x8b, 0x36,
+	0xc2, 0x2d, 0x40, 0xfa, 0xd0, 0xcd, 0x57, 0x67, 0xd1, 0x2d, 0x67, 0x7a, 0x2d, 0xd1, 0xa9, 0x9b,
+	0x9c, 0x6e, 0x78, 0x1f, 0x76, 0x17, 0xd0, 0xbd, 0x73, 0x6c, 0x2a, 0x74, 0x14, 0x60, 0xd6, 0xe5,
+	0x1f, 0x51, 0x29, 0x76, 0x8b, 0x85, 0x49, 0xf5, 0x55, 0x1e, 0x66, 0x65, 0x29, 0x06, 0x82, 0x73,
+	0x0c, 0xf4, 0x30, 0x5d, 0xf0, 0xe8, 0x37, 0xd3, 0xe6, 0x2a, 0xed, 0xca, 0xfb, 0xb0, 0xfb, 0xbb,
+	0x05, 0x9d, 0x3b, 0xbb, 0x66, 0x9a, 0x69, 0x45, 0x5e, 0xc2, 0xb3, 0x3d, 0xfb, 0x8a, 0x03, 0x9e,
+	0xed, 0x99, 0x98, 0xfd, 0x9c, 0x23, 0xc1, 0xb9, 0x2a, 0x0e, 0x38, 0xca, 0xca, 0xab, 0xac, 0x8b,
+	0xc3, 0x54, 0xa3, 0x9a, 0x8b, 0x11, 0x8b, 0x63, 0x0c, 0x8d, 0xbb, 0x55, 0xda, 0xf6, 0xcb, 0xe0,
+	0xc3, 0x2c, 0xe9, 0xd4, 0xf6, 0xb3, 0xa4, 0xfb, 0x1a, 0xda, 0x9e, 0x6f, 0xa8, 0x2d, 0x92, 0x90,
+	0x69, 0x24, 0x5f, 0x82, 0x5d, 0x10, 0x54, 0x8e, 0x65, 0x5a, 0xde, 0x1d, 0xdc, 0xd7, 0x40, 0xed,
+	0x82, 0xa8, 0x72, 0x6f, 0xa0, 0xe9, 0xf9, 0x43, 0xb1, 0xe6, 0xe1, 0x55, 0x18, 0xca, 0x27, 0x8a,
+	0x23, 0x50, 0xcb, 0x76, 0x6d, 0x27, 0x00, 0x0b, 0x43, 0xe9, 0xce, 0x4b, 0x86, 0x4d, 0xa4, 0x14,
+	0xf2, 0xe9, 0x86, 0x99, 0x6d, 0xdb, 0xdb, 0x8c, 0x59, 0xe0, 0x2e, 0xb3, 0xf6, 0x6e, 0x55, 0xe5,
+	0xff, 0xdf, 0x4b, 0x80, 0x3b, 0xde, 0x5b, 0xa1, 0xad, 0x41, 0x49, 0x0c, 0x05, 0xff, 0x6e, 0x9d,
+	0x7c, 0x0e, 0xc7, 0xa6, 0xec, 0xf6, 0x16, 0x94, 0x2c, 0x31, 0x38, 0x3d, 0x36, 0x07, 0x29, 0xf7,
+	0x2f, 0x2b, 0xf3, 0x73, 0x1c, 0xb1, 0xb8, 0x74, 0xe5, 0x16, 0x0a, 0x1f, 0x70, 0xb7, 0x69, 0x7b,
+	0x5d, 0x06, 0xc9, 0x37, 0x70, 0xbe, 0xa7, 0xf2, 0x0d, 0x0f, 0x31, 0xff, 0xb5, 0x8e, 0xe8, 0x79,
+	0x70, 0x70, 0xf5, 0x7f, 0x1a, 0x19, 0xe4, 0x39, 0xd8, 0xd9, 0xe0, 0x2a, 0x5d, 0x45, 0x5b, 0x15,
+	0xb1, 0xfb, 0xb7, 0x05, 0x8e, 0xe7, 0x8f, 0x53, 0xce, 0x56, 0x51, 0xb0, 0x33, 0x31, 0x57, 0xf7,
+	0x3d, 0x9c, 0x5d, 0x85, 0xe1, 0x81, 0x69, 0x61, 0x3d, 0x32, 0x2d, 0xce, 0xd8, 0xa1, 0x2d, 0xc4,
+	0x03, 0x87, 0xe2, 0x4a, 0x6c, 0xf0, 0x89, 0xc3, 0xc7, 0x91, 0xff, 0xb1, 0xeb, 0xf1, 0x11, 0xf4,
+	0xc5, 0xd7, 0xd0, 0xd9, 0x19, 0x94, 0xbd, 0x4c, 0xa4, 0x09, 0xf5, 0xc5, 0xf4, 0x7a, 0x7a, 0xf3,
+	0x76, 0x7a, 0xf2, 0x11, 0xb1, 0xa1, 0x36, 0x9b, 0x2f, 0x86, 0x27, 0x16, 0x69, 0x81, 0x3d, 0xbb,
+	0x9e, 0xfc, 0x30, 0x99, 0xdf, 0x4c, 0x4f, 0x2a, 0xc3, 0xcf, 0x7e, 0x79, 0x71, 0x1b, 0xe9, 0xe5,
+	0xda, 0x1f, 0x04, 0x62, 0x75, 0xf1, 0x33, 0x6e, 0xc4, 0x1b, 0x1e, 0x5c, 0xe4, 0x2f, 0xe3, 0x45,
+	0xb0, 0x34, 0x6f, 0xa3, 0xbf, 0x7e, 0xe7, 0x1f, 0x9b, 0xaf, 0xaf, 0xfe, 0x1d, 0x00, 0x86, 0x48,
+	0xb2, 0xdb, 0x35, 0x07, 0x00, 0x00,
 }
@@ -10,7 +10,10 @@ require (
 	github.com/jpillora/requestlog v0.0.0-20181015073026-df8817be5f82
 	github.com/jpillora/sizestr v0.0.0-20160130011556-e2ea2fa42fb9
 	github.com/prep/socketpair v0.0.0-20171228153254-c2c6a7f821c2
-	golang.org/x/crypto v0.0.0-20181015023909-0c41d7ab0a0e
+	golang.org/x/crypto v0.0.0-20190308221718-c2843e01d9a2
+	golang.org/x/net v0.0.0-20190311183353-d8887717615a
+	google.golang.org/grpc v1.24.0
+	gopkg.in/yaml.v2 v2.2.8
 )
 
 go 1.13
@@ -0,0 +1,95 @@
+package chshare
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// validateAcceptRateOption validates the value of the "accept-rate" descriptor option
+func validateAcceptRateOption(value string) error {
+	_, _, err := parseAcceptRateOption(value)
+	return err
+}
+
+// parseAcceptRateOption parses the value of the "accept-rate" descriptor option,
+// "<count>/<period>", where period is a duration such as "10s", or just its unit, e.g. "s" or
+// "m" for one second or minute
+func parseAcceptRateOption(value string) (int, time.Duration, error) {
+	invalid := fmt.Errorf("Invalid accept rate '%s'; must be <count>/<period>, e.g. 20/s or 100/1m", value)
+	i := strings.Index(value, "/")
+	if i < 0 {
+		return 0, 0, invalid
+	}
+	count, err := strconv.Atoi(value[:i])
+	if err != nil || count < 1 {
+		return 0, 0, invalid
+	}
+	period := value[i+1:]
+	if period != "" && (period[0] < '0' || period[0] > '9') {
+		period = "1" + period
+	}
+	d, err := time.ParseDuration(period)
+	if err != nil || d <= 0 {
+		return 0, 0, invalid
+	}
+	return count, d, nil
+}
+
+// AcceptThrottle is a token bucket that limits the rate at which a stub listener accepts
+// connections. The bucket holds up to count tokens, and is refilled at count tokens per
+// period, so that bursts of up to count connections are allowed.
+type AcceptThrottle struct {
+	lock     sync.Mutex
+	clock    Clock
+	capacity float64
+	perToken time.Duration
+	tokens   float64
+	last     time.Time
+
+	// throttling is true if the last connection was refused
+	throttling bool
+}
+
+// NewAcceptThrottle creates an AcceptThrottle for the "accept-rate" option of a stub endpoint,
+// refilled as measured by clock, or returns nil if the endpoint has none
+func NewAcceptThrottle(clock Clock, ced *ChannelEndpointDescriptor) *AcceptThrottle {
+	count, period, err := parseAcceptRateOption(ced.Option("accept-rate"))
+	if err != nil {
+		return nil
+	}
+	return &AcceptThrottle{
+		clock:    clock,
+		capacity: float64(count),
+		perToken: period / time.Duration(count),
+		tokens:   float64(count),
+		last:     clock.Now(),
+	}
+}
+
+// Allow takes a token for a newly accepted connection, returning false if there is none and
+// the connection is to be refused. The second result is true if this changes whether
+// connections are being refused.
+func (t *AcceptThrottle) Allow() (bool, bool) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	now := t.clock.Now()
+	if t.perToken > 0 {
+		t.tokens += float64(now.Sub(t.last)) / float64(t.perToken)
+	} else {
+		t.tokens = t.capacity
+	}
+	if t.tokens > t.capacity {
+		t.tokens = t.capacity
+	}
+	t.last = now
+	allowed := t.tokens >= 1
+	if allowed {
+		t.tokens--
+	}
+	changed := t.throttling == allowed
+	t.throttling = !allowed
+	return allowed, changed
+}
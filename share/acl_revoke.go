@@ -0,0 +1,94 @@
+package chshare
+
+import (
+	"fmt"
+)
+
+// usersChanged is called when the users of an index change, by a reload of its auth file or
+// through the admin API. Once the ACL revocation grace period has passed, the sessions of
+// users that have been deleted are shut down, and other sessions of the index lose the
+// channels that their user's access list no longer allows. Access restored within the grace
+// period is not revoked. Cached access decisions are forgotten straight away.
+func (s *Server) usersChanged(users *UserIndex) {
+	s.decisions.Flush()
+	if s.aclRevokeGrace <= 0 {
+		s.revokeStaleAccess(users)
+		return
+	}
+	for _, session := range s.Sessions() {
+		if session.users != users {
+			continue
+		}
+		if deleted, revoked := session.staleAccess(); deleted {
+			session.ILogf("User \"%s\" deleted; closing session in %s unless restored", session.user.Name, s.aclRevokeGrace)
+		} else if len(revoked) > 0 {
+			session.ILogf("Access to %d channel(s) revoked; closing them in %s unless restored", len(revoked), s.aclRevokeGrace)
+		}
+	}
+	s.clock.AfterFunc(s.aclRevokeGrace, func() {
+		s.revokeStaleAccess(users)
+	})
+}
+
+// revokeStaleAccess applies the current access lists of an index's users to their sessions
+func (s *Server) revokeStaleAccess(users *UserIndex) {
+	for _, session := range s.Sessions() {
+		if session.users == users {
+			session.revokeStaleAccess()
+		}
+	}
+}
+
+// staleAccess returns true if the session's user has been deleted, or otherwise the
+// session's channels that its user may no longer access
+func (s *ServerSSHSession) staleAccess() (bool, []*ChannelDescriptor) {
+	if s.user == nil {
+		return false, nil
+	}
+	user, ok := s.users.Get(s.user.Name)
+	if !ok {
+		return true, nil
+	}
+	s.channelsLock.Lock()
+	defer s.channelsLock.Unlock()
+	var revoked []*ChannelDescriptor
+	for key, chd := range s.chds {
+		if !user.HasAccess(key) {
+			revoked = append(revoked, chd)
+		}
+	}
+	return false, revoked
+}
+
+// revokeStaleAccess shuts the session down if its user has been deleted, or otherwise
+// removes the channels that its user may no longer access, closing their stub listeners and
+// open connections
+func (s *ServerSSHSession) revokeStaleAccess() {
+	deleted, revoked := s.staleAccess()
+	if deleted {
+		s.ILogf("User \"%s\" deleted; closing session", s.user.Name)
+		s.server.aclRevokedSessionsStat.Inc()
+		s.SetChannelCloseReason(&ChannelCloseInfo{Reason: CloseReasonPolicyRevoked, Message: "User deleted"})
+		s.StartShutdown(fmt.Errorf("User \"%s\" deleted", s.user.Name))
+		return
+	}
+	for _, chd := range revoked {
+		// channels are tracked by the endpoint on this side: the stub of a reverse channel,
+		// which may listen on a substitute port, or the skeleton of a forward one
+		ced := chd.Skeleton
+		if chd.Reverse {
+			ced = chd.Stub
+			s.channelsLock.Lock()
+			if proxy, ok := s.reverseProxies[chd.String()]; ok {
+				ced = proxy.chd.Stub
+			}
+			s.channelsLock.Unlock()
+		}
+		s.removeChannelDescriptor(chd)
+		closed := s.closeChannelsMatching(func(open *ChannelEndpointDescriptor) bool {
+			return open.String() == ced.String()
+		}, &ChannelCloseInfo{Reason: CloseReasonPolicyRevoked, Message: "Access revoked"})
+		s.ILogf("Access to %s revoked; removed it and closed %d open connection(s)", chd, closed)
+		s.server.aclRevokedChannelsStat.Inc()
+	}
+}
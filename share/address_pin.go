@@ -0,0 +1,171 @@
+package chshare
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// pinRevalidateInterval is how long the addresses a pinned target resolves to are used before
+// the target is resolved and checked against its pinned addresses again
+const pinRevalidateInterval = time.Minute
+
+// parsePinOption parses the value of the "pin" descriptor option, a '+'-separated list of IP
+// addresses and CIDR networks
+func parsePinOption(value string) ([]*net.IPNet, error) {
+	var networks []*net.IPNet
+	for _, s := range strings.Split(value, "+") {
+		if strings.Contains(s, "/") {
+			_, network, err := net.ParseCIDR(s)
+			if err != nil {
+				return nil, fmt.Errorf("Invalid pinned network '%s': %s", s, err)
+			}
+			networks = append(networks, network)
+		} else if ip := net.ParseIP(s); ip != nil {
+			bits := 8 * net.IPv6len
+			if ip4 := ip.To4(); ip4 != nil {
+				ip = ip4
+				bits = 8 * net.IPv4len
+			}
+			networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+		} else {
+			return nil, fmt.Errorf("Invalid pinned address '%s'; must be an IP address or CIDR network", s)
+		}
+	}
+	return networks, nil
+}
+
+// validatePinOption validates the value of the "pin" descriptor option
+func validatePinOption(value string) error {
+	_, err := parsePinOption(value)
+	return err
+}
+
+// addressPin restricts the dials of a TCP skeleton endpoint to the addresses its target
+// resolves to that are within a set of pinned networks, so that a host name that starts
+// resolving somewhere else (e.g. by DNS rebinding to an internal address) is never dialed
+// there. The approved addresses are shared by all channels to the same target, and
+// revalidated every pinRevalidateInterval.
+type addressPin struct {
+	target   string
+	host     string
+	port     string
+	networks []*net.IPNet
+
+	violationsStat *Stat
+	rejectionsStat *Stat
+
+	lock       sync.Mutex
+	addrs      []string
+	resolvedAt time.Time
+}
+
+var addressPinsLock sync.Mutex
+
+// addressPins holds the addressPin of each pinned target, by target and pin option value
+var addressPins = make(map[string]*addressPin)
+
+// endpointAddressPin returns the addressPin that restricts the dials of a TCP skeleton
+// endpoint, or nil if it has no "pin" option
+func endpointAddressPin(stats *StatsRegistry, ced *ChannelEndpointDescriptor) (*addressPin, error) {
+	value := ced.Option("pin")
+	if value == "" || ced.Role != ChannelEndpointRoleSkeleton || ced.Type != ChannelEndpointTypeTCP {
+		return nil, nil
+	}
+	networks, err := parsePinOption(value)
+	if err != nil {
+		return nil, err
+	}
+	host, port, err := net.SplitHostPort(ced.Path)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid TCP target '%s': %s", ced.Path, err)
+	}
+	key := ced.Path + "?pin=" + value
+	addressPinsLock.Lock()
+	defer addressPinsLock.Unlock()
+	if pin, ok := addressPins[key]; ok {
+		return pin, nil
+	}
+	pin := &addressPin{
+		target:   ced.Path,
+		host:     host,
+		port:     port,
+		networks: networks,
+		violationsStat: stats.Counter(
+			"chisel_pin_violations_total",
+			"Number of times a pinned target resolved to addresses outside its pinned addresses",
+			StatLabels{"target": ced.Path}),
+		rejectionsStat: stats.Counter(
+			"chisel_pin_rejections_total",
+			"Number of dials refused because a pinned target resolved to none of its pinned addresses",
+			StatLabels{"target": ced.Path}),
+	}
+	addressPins[key] = pin
+	return pin, nil
+}
+
+// allows returns true if ip is within one of the pinned networks
+func (p *addressPin) allows(ip net.IP) bool {
+	for _, network := range p.networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// Addrs returns the "<ip>:<port>" addresses that may be dialed for the target, resolving and
+// revalidating them if they are older than pinRevalidateInterval. Addresses outside the
+// pinned networks are logged and left out; if none remain, the dial is refused. If the
+// target cannot be resolved, the addresses approved before are used. The age of the
+// addresses is measured by clock.
+func (p *addressPin) Addrs(ctx context.Context, logger Logger, clock Clock) ([]string, error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	now := clock.Now()
+	if p.addrs != nil && now.Sub(p.resolvedAt) < pinRevalidateInterval {
+		return p.addrs, nil
+	}
+
+	var ips []net.IP
+	if ip := net.ParseIP(p.host); ip != nil {
+		ips = []net.IP{ip}
+	} else {
+		resolved, err := net.DefaultResolver.LookupIPAddr(ctx, p.host)
+		if err != nil {
+			if p.addrs != nil {
+				logger.DLogf("Unable to revalidate pinned target '%s', using the addresses approved before: %s", p.target, err)
+				return p.addrs, nil
+			}
+			return nil, fmt.Errorf("Unable to resolve pinned target '%s': %s", p.target, err)
+		}
+		for _, addr := range resolved {
+			ips = append(ips, addr.IP)
+		}
+	}
+
+	var addrs []string
+	var unpinned []string
+	for _, ip := range ips {
+		if p.allows(ip) {
+			addrs = append(addrs, net.JoinHostPort(ip.String(), p.port))
+		} else {
+			unpinned = append(unpinned, ip.String())
+		}
+	}
+	if len(unpinned) > 0 {
+		p.violationsStat.Inc()
+		logger.WLogf("Pinned target '%s' resolves to addresses that are not pinned, which will not be dialed: %s",
+			p.target, strings.Join(unpinned, ", "))
+	}
+	p.addrs = addrs
+	p.resolvedAt = now
+	if len(addrs) == 0 {
+		p.rejectionsStat.Inc()
+		return nil, fmt.Errorf("Pinned target '%s' resolves to none of its pinned addresses", p.target)
+	}
+	return addrs, nil
+}
@@ -0,0 +1,442 @@
+package chshare
+
+import (
+	"context"
+	"crypto/subtle"
+	"net"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/XevoInc/chisel/chprotobuf"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// AdminServer serves the ChiselAdmin gRPC service, allowing operators to manage a running
+// chisel server's sessions and users and read its metrics. If a token is configured, every
+// call must carry an "authorization: Bearer <token>" metadata entry. A token is required
+// unless the service listens on a unix domain socket, which only the server's owner may use.
+type AdminServer struct {
+	ShutdownHelper
+	server     *Server
+	network    string
+	addr       string
+	token      string
+	grpcServer *grpc.Server
+	listener   net.Listener
+}
+
+// NewAdminServer creates a new AdminServer for a chisel server. An address that begins with
+// "unix:" or contains a "/" is a unix domain socket path; anything else is a TCP address, on
+// which a token is required, since any local user could otherwise manage the server even on
+// a loopback address. It does not start listening until Start is called.
+func NewAdminServer(logger Logger, server *Server, addr string, token string) (*AdminServer, error) {
+	a := &AdminServer{
+		server:  server,
+		network: "tcp",
+		addr:    addr,
+		token:   token,
+	}
+	a.InitShutdownHelper(logger.Fork("admin"), a)
+	if strings.HasPrefix(addr, "unix:") || strings.Contains(addr, "/") {
+		a.network = "unix"
+		a.addr = strings.TrimPrefix(addr, "unix:")
+	} else {
+		if _, _, err := net.SplitHostPort(addr); err != nil {
+			return nil, a.Errorf("Invalid admin listen address '%s': %s", addr, err)
+		}
+		if token == "" {
+			return nil, a.Errorf("The admin service on %s requires a token (--admin-token), unless it listens on a unix domain socket", addr)
+		}
+	}
+	a.grpcServer = grpc.NewServer(grpc.UnaryInterceptor(a.authInterceptor))
+	chprotobuf.RegisterChiselAdminServer(a.grpcServer, a)
+	return a, nil
+}
+
+// HandleOnceShutdown will be called exactly once, in its own goroutine. It should take completionError
+// as an advisory completion value, actually shut down, then return the real completion value.
+func (a *AdminServer) HandleOnceShutdown(completionErr error) error {
+	a.grpcServer.Stop()
+	return completionErr
+}
+
+// Start begins serving the admin service in the background
+func (a *AdminServer) Start(ctx context.Context) error {
+	return a.DoOnceActivate(
+		func() error {
+			var listener net.Listener
+			var err error
+			if a.network == "unix" {
+				listener, err = NewPrivateUnixSocketListener(a.Logger, a.addr)
+			} else {
+				listener, err = net.Listen(a.network, a.addr)
+			}
+			if err != nil {
+				return a.Errorf("Unable to listen on %s: %s", a.addr, err)
+			}
+			a.listener = listener
+			a.ShutdownOnContext(ctx)
+			a.ILogf("Admin service listening on %s", listener.Addr())
+			go func() {
+				err := a.grpcServer.Serve(listener)
+				if err != nil && !a.IsStartedShutdown() {
+					a.StartShutdown(a.DLogErrorf("Admin service failed: %s", err))
+				}
+			}()
+			return nil
+		},
+		true,
+	)
+}
+
+// authInterceptor rejects calls that do not carry the configured bearer token
+func (a *AdminServer) authInterceptor(
+	ctx context.Context,
+	req interface{},
+	info *grpc.UnaryServerInfo,
+	handler grpc.UnaryHandler,
+) (interface{}, error) {
+	if a.token != "" {
+		md, _ := metadata.FromIncomingContext(ctx)
+		authorized := false
+		for _, v := range md.Get("authorization") {
+			if subtle.ConstantTimeCompare([]byte(v), []byte("Bearer "+a.token)) == 1 {
+				authorized = true
+				break
+			}
+		}
+		if !authorized {
+			a.DLogf("Unauthorized admin call to %s", info.FullMethod)
+			return nil, status.Error(codes.Unauthenticated, "invalid or missing admin token")
+		}
+	}
+	a.DLogf("Admin call to %s", info.FullMethod)
+	return handler(ctx, req)
+}
+
+// ListSessions returns all active client sessions
+func (a *AdminServer) ListSessions(
+	ctx context.Context,
+	req *chprotobuf.PbListSessionsRequest,
+) (*chprotobuf.PbListSessionsResponse, error) {
+	resp := &chprotobuf.PbListSessionsResponse{}
+	for _, session := range a.server.Sessions() {
+		info := session.Info()
+		resp.Sessions = append(resp.Sessions, &chprotobuf.PbAdminSession{
+			Id:                 info.ID,
+			User:               info.User,
+			RemoteAddr:         info.RemoteAddr,
+			StartTimeUnix:      info.StartTime.Unix(),
+			ClientVersion:      info.ClientVersion,
+			SessionName:        info.SessionName,
+			DeviceFingerprint:  info.DeviceFingerprint,
+			ChannelDescriptors: info.ChannelDescriptors,
+		})
+	}
+	return resp, nil
+}
+
+// KillSession disconnects an active client session
+func (a *AdminServer) KillSession(
+	ctx context.Context,
+	req *chprotobuf.PbKillSessionRequest,
+) (*chprotobuf.PbKillSessionResponse, error) {
+	session, ok := a.server.GetSession(req.Id)
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "no active session with id %d", req.Id)
+	}
+	reason := req.Reason
+	if reason == "" {
+		reason = "no reason given"
+	}
+	a.ILogf("Killing session %s: %s", session, reason)
+	session.SetChannelCloseReason(&ChannelCloseInfo{Reason: CloseReasonPolicyRevoked, Message: "Session killed by administrator: " + reason})
+	session.StartShutdown(a.Errorf("Session killed by administrator: %s", reason))
+	return &chprotobuf.PbKillSessionResponse{}, nil
+}
+
+// NotifySessions sends a notice, and optionally a time by which to reconnect, to the clients
+// of the selected sessions
+func (a *AdminServer) NotifySessions(
+	ctx context.Context,
+	req *chprotobuf.PbNotifySessionsRequest,
+) (*chprotobuf.PbNotifySessionsResponse, error) {
+	if req.Message == "" && req.ReconnectByUnix == 0 {
+		return nil, status.Errorf(codes.InvalidArgument, "a message or a reconnect time is required")
+	}
+	notice := &SessionNotice{Message: req.Message}
+	if req.ReconnectByUnix != 0 {
+		notice.ReconnectBy = time.Unix(req.ReconnectByUnix, 0)
+	}
+	filter := &SessionNoticeFilter{
+		SessionIDs:  req.SessionIds,
+		User:        req.User,
+		SessionName: req.SessionName,
+	}
+	notified, unacknowledged, err := a.server.NotifySessions(notice, filter)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "%s", err)
+	}
+	a.ILogf("Notified %d session(s); %d did not acknowledge", len(notified), len(unacknowledged))
+	return &chprotobuf.PbNotifySessionsResponse{
+		NotifiedIds:       notified,
+		UnacknowledgedIds: unacknowledged,
+	}, nil
+}
+
+// Drain stops (or resumes) accepting new client sessions
+func (a *AdminServer) Drain(
+	ctx context.Context,
+	req *chprotobuf.PbDrainRequest,
+) (*chprotobuf.PbDrainResponse, error) {
+	n := a.server.SetDraining(req.Drain)
+	return &chprotobuf.PbDrainResponse{ActiveSessions: int32(n)}, nil
+}
+
+// ListUsers returns all users and their access control lists
+func (a *AdminServer) ListUsers(
+	ctx context.Context,
+	req *chprotobuf.PbListUsersRequest,
+) (*chprotobuf.PbListUsersResponse, error) {
+	resp := &chprotobuf.PbListUsersResponse{}
+	for _, user := range a.server.GetUsers().All() {
+		pbu := &chprotobuf.PbAdminUser{
+			Name:        user.Name,
+			MaxSessions: int32(user.MaxSessions),
+			Kick:        user.Kick,
+			CertAuth:    user.CertAuth,
+			Pending:     user.Pending,
+
+			MaxChannelBytes: user.MaxChannelBytes,
+		}
+		for _, re := range user.Addrs {
+			pbu.Addrs = append(pbu.Addrs, re.String())
+		}
+		resp.Users = append(resp.Users, pbu)
+	}
+	return resp, nil
+}
+
+// SetUser creates or replaces a user, who must have a password. Note that adding the first
+// user enables authentication for new client sessions. Users from the --authfile cannot be
+// replaced.
+func (a *AdminServer) SetUser(
+	ctx context.Context,
+	req *chprotobuf.PbSetUserRequest,
+) (*chprotobuf.PbSetUserResponse, error) {
+	if req.Name == "" {
+		return nil, status.Error(codes.InvalidArgument, "user name is required")
+	}
+	if req.Password == "" {
+		return nil, status.Error(codes.InvalidArgument, "password is required")
+	}
+	if req.MaxSessions < 0 {
+		return nil, status.Error(codes.InvalidArgument, "max sessions cannot be negative")
+	}
+	if req.MaxChannelBytes < 0 {
+		return nil, status.Error(codes.InvalidArgument, "max channel bytes cannot be negative")
+	}
+	addrs, err := ParseUserAddrs(req.Addrs)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if a.server.GetUsers().IsFileUser(req.Name) {
+		return nil, fileUserError(req.Name)
+	}
+	a.ILogf("Setting user \"%s\"", req.Name)
+	a.server.GetUsers().AddUser(&User{
+		Name:        req.Name,
+		Pass:        req.Password,
+		Addrs:       addrs,
+		MaxSessions: int(req.MaxSessions),
+		Kick:        req.Kick,
+
+		MaxChannelBytes: req.MaxChannelBytes,
+	})
+	a.server.GetUsers().Changed()
+	return &chprotobuf.PbSetUserResponse{}, nil
+}
+
+// fileUserError is the error for an attempt to change a user from the --authfile, whose
+// changes would be undone when the file is next reloaded
+func fileUserError(name string) error {
+	return status.Errorf(codes.FailedPrecondition, "user \"%s\" is configured in the auth file; change it there", name)
+}
+
+// SetUserACL replaces the access control list of an existing user. The new list applies
+// to channels subsequently requested by the user's active sessions as well as new ones, and
+// channels of active sessions that it no longer allows are closed (see ACLRevokeGrace).
+func (a *AdminServer) SetUserACL(
+	ctx context.Context,
+	req *chprotobuf.PbSetUserACLRequest,
+) (*chprotobuf.PbSetUserACLResponse, error) {
+	users := a.server.GetUsers()
+	user, ok := users.Get(req.Name)
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "no user named \"%s\"", req.Name)
+	}
+	if users.IsFileUser(req.Name) {
+		return nil, fileUserError(req.Name)
+	}
+	addrs, err := ParseUserAddrs(req.Addrs)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	a.ILogf("Setting access list for user \"%s\"", req.Name)
+	updated := *user
+	updated.Addrs = addrs
+	users.AddUser(&updated)
+	users.Changed()
+	return &chprotobuf.PbSetUserACLResponse{}, nil
+}
+
+// DeleteUser removes a user, shutting down the user's active sessions (see ACLRevokeGrace).
+// Users from the --authfile cannot be removed.
+func (a *AdminServer) DeleteUser(
+	ctx context.Context,
+	req *chprotobuf.PbDeleteUserRequest,
+) (*chprotobuf.PbDeleteUserResponse, error) {
+	users := a.server.GetUsers()
+	if _, ok := users.Get(req.Name); !ok {
+		return nil, status.Errorf(codes.NotFound, "no user named \"%s\"", req.Name)
+	}
+	if users.IsFileUser(req.Name) {
+		return nil, fileUserError(req.Name)
+	}
+	a.ILogf("Deleting user \"%s\"", req.Name)
+	users.Del(req.Name)
+	users.Changed()
+	return &chprotobuf.PbDeleteUserResponse{}, nil
+}
+
+// ApproveUser approves a user provisioned from a TLS client certificate, optionally replacing
+// its access control list
+func (a *AdminServer) ApproveUser(
+	ctx context.Context,
+	req *chprotobuf.PbApproveUserRequest,
+) (*chprotobuf.PbApproveUserResponse, error) {
+	user, ok := a.server.GetUsers().Get(req.Name)
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "no user named \"%s\"", req.Name)
+	}
+	if !user.Pending {
+		return nil, status.Errorf(codes.FailedPrecondition, "user \"%s\" is not pending approval", req.Name)
+	}
+	if err := a.server.ApproveUser(req.Name, req.Addrs); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	return &chprotobuf.PbApproveUserResponse{}, nil
+}
+
+// GetStats returns the server's metrics
+func (a *AdminServer) GetStats(
+	ctx context.Context,
+	req *chprotobuf.PbGetStatsRequest,
+) (*chprotobuf.PbGetStatsResponse, error) {
+	resp := &chprotobuf.PbGetStatsResponse{}
+	for _, stat := range a.server.GetStatsRegistry().Snapshot() {
+		resp.Stats = append(resp.Stats, &chprotobuf.PbStat{
+			Name:   stat.Name(),
+			Type:   string(stat.Type()),
+			Labels: stat.Labels(),
+			Value:  stat.Get(),
+		})
+	}
+	return resp, nil
+}
+
+// GetServerInfo returns the server's version, capabilities and health
+func (a *AdminServer) GetServerInfo(
+	ctx context.Context,
+	req *chprotobuf.PbGetServerInfoRequest,
+) (*chprotobuf.PbGetServerInfoResponse, error) {
+	info := BuildInfo()
+	return &chprotobuf.PbGetServerInfoResponse{
+		BuildVersion:    info.Version,
+		ProtocolVersion: info.ProtocolVersion,
+		Draining:        a.server.IsDraining(),
+		ActiveSessions:  int32(len(a.server.Sessions())),
+		GitCommit:       info.Commit,
+		GoVersion:       info.GoVersion,
+		Features:        info.Features,
+	}, nil
+}
+
+// GetSessionLog returns the recent log lines of an active client session
+func (a *AdminServer) GetSessionLog(
+	ctx context.Context,
+	req *chprotobuf.PbGetSessionLogRequest,
+) (*chprotobuf.PbGetSessionLogResponse, error) {
+	session, ok := a.server.GetSession(req.Id)
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "no active session with id %d", req.Id)
+	}
+	if a.server.sessionLogLines <= 0 {
+		return nil, status.Error(codes.FailedPrecondition, "session log lines are not kept (see --session-log-lines)")
+	}
+	resp := &chprotobuf.PbGetSessionLogResponse{}
+	for _, line := range session.LogLines(int(req.MaxLines)) {
+		resp.Lines = append(resp.Lines, &chprotobuf.PbSessionLogLine{
+			TimeUnixMs: line.Time.UnixNano() / int64(time.Millisecond),
+			Level:      line.Level.String(),
+			Message:    line.Message,
+		})
+	}
+	return resp, nil
+}
+
+// DrainListener gracefully removes one reverse listener of an active client session
+func (a *AdminServer) DrainListener(
+	ctx context.Context,
+	req *chprotobuf.PbDrainListenerRequest,
+) (*chprotobuf.PbDrainListenerResponse, error) {
+	session, ok := a.server.GetSession(req.Id)
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "no active session with id %d", req.Id)
+	}
+	if req.TimeoutSeconds < 0 {
+		return nil, status.Error(codes.InvalidArgument, "timeout cannot be negative")
+	}
+	n, err := session.DrainListener(req.ChannelDescriptor, time.Duration(req.TimeoutSeconds)*time.Second)
+	if err != nil {
+		return nil, status.Error(codes.FailedPrecondition, err.Error())
+	}
+	return &chprotobuf.PbDrainListenerResponse{ActiveConnections: int32(n)}, nil
+}
+
+// ListSessionCosts returns the approximate resources used by each active client session,
+// most costly first
+func (a *AdminServer) ListSessionCosts(
+	ctx context.Context,
+	req *chprotobuf.PbListSessionCostsRequest,
+) (*chprotobuf.PbListSessionCostsResponse, error) {
+	if req.Limit < 0 {
+		return nil, status.Error(codes.InvalidArgument, "limit cannot be negative")
+	}
+	costs, err := a.server.SessionCosts(req.SortBy, int(req.Limit))
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	resp := &chprotobuf.PbListSessionCostsResponse{TotalGoroutines: int32(runtime.NumGoroutine())}
+	for _, c := range costs {
+		resp.Sessions = append(resp.Sessions, &chprotobuf.PbSessionCost{
+			Id:             c.ID,
+			User:           c.User,
+			Tenant:         c.Tenant,
+			SessionName:    c.SessionName,
+			Channels:       int32(c.Channels),
+			Listeners:      int32(c.Listeners),
+			Goroutines:     int32(c.Goroutines),
+			BufferedBytes:  c.BufferedBytes,
+			MemoryBytes:    c.MemoryBytes,
+			TransportBytes: c.TransportBytes,
+			BytesPerSecond: c.BytesPerSecond,
+			Cost:           c.Cost,
+		})
+	}
+	return resp, nil
+}
@@ -0,0 +1,18 @@
+package chshare
+
+import (
+	"context"
+	"testing"
+
+	"github.com/XevoInc/chisel/chprotobuf"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestAdminSetUserRejectsEmptyPassword(t *testing.T) {
+	a := &AdminServer{}
+	_, err := a.SetUser(context.Background(), &chprotobuf.PbSetUserRequest{Name: "alice"})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("SetUser with an empty password returned %v, want InvalidArgument", err)
+	}
+}
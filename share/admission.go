@@ -0,0 +1,72 @@
+package chshare
+
+import (
+	"fmt"
+	"runtime"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// memoryWatermarkCheckInterval is how often the heap size is sampled for a MemoryWatermark
+const memoryWatermarkCheckInterval = time.Second
+
+// channelAdmissionRetries is the number of times a channel open rejected for lack of
+// resources is retried before giving up
+const channelAdmissionRetries = 5
+
+// channelAdmissionRetryDelay is the delay before the first retry of a channel open rejected
+// for lack of resources; it doubles for each further retry
+const channelAdmissionRetryDelay = 200 * time.Millisecond
+
+// MemoryWatermark reports whether the process heap has grown past a limit. The heap size is
+// sampled at most once per memoryWatermarkCheckInterval, since reading it is not free.
+type MemoryWatermark struct {
+	limit   uint64
+	clock   Clock
+	lock    sync.Mutex
+	checked time.Time
+	heap    uint64
+}
+
+// NewMemoryWatermark creates a MemoryWatermark for a heap size limit in bytes, sampled at
+// intervals measured by clock
+func NewMemoryWatermark(limit int64, clock Clock) *MemoryWatermark {
+	return &MemoryWatermark{limit: uint64(limit), clock: clock}
+}
+
+// Exceeded returns the most recently sampled heap size, and whether it is above the limit
+func (m *MemoryWatermark) Exceeded() (uint64, bool) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if now := m.clock.Now(); now.Sub(m.checked) >= memoryWatermarkCheckInterval {
+		var ms runtime.MemStats
+		runtime.ReadMemStats(&ms)
+		m.heap = ms.HeapInuse
+		m.checked = now
+	}
+	return m.heap, m.heap > m.limit
+}
+
+// ChannelAdmissionError is returned when a new channel is refused because the local proxy is
+// short of memory. The remote proxy may retry the channel later.
+type ChannelAdmissionError struct {
+	reason string
+}
+
+func (e *ChannelAdmissionError) Error() string {
+	return e.reason + "; retry later"
+}
+
+// NewChannelAdmissionError creates a ChannelAdmissionError
+func NewChannelAdmissionError(format string, args ...interface{}) *ChannelAdmissionError {
+	return &ChannelAdmissionError{reason: fmt.Sprintf(format, args...)}
+}
+
+// isRetryableOpenChannelError returns true if an SSH channel open was rejected by a remote
+// proxy for lack of resources, so that it may succeed if retried
+func isRetryableOpenChannelError(err error) bool {
+	openErr, ok := err.(*ssh.OpenChannelError)
+	return ok && openErr.Reason == ssh.ResourceShortage
+}
@@ -0,0 +1,271 @@
+package chshare
+
+import (
+	"container/list"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Default AuthLimiter settings
+const (
+	DefaultAuthFailureDelay     = 250 * time.Millisecond
+	DefaultAuthMaxFailureDelay  = 10 * time.Second
+	DefaultAuthLockoutThreshold = 10
+	DefaultAuthLockoutDuration  = 15 * time.Minute
+)
+
+// authLimiterMaxEntries is the number of usernames from source IPs, and separately of source
+// IPs and of usernames, whose failures are tracked at once. Beyond it, the least recently
+// failed are forgotten, so that attempts from many addresses or with many usernames cannot
+// exhaust memory.
+const authLimiterMaxEntries = 16384
+
+// authFailures tracks recent failed authentication attempts for a single username from a
+// source IP, for a single source IP, or for a single username
+type authFailures struct {
+	key         string
+	count       int
+	last        time.Time
+	lockedUntil time.Time
+
+	// locked is true while the record is counted in its table's locked out gauge
+	locked bool
+}
+
+// authFailureTable holds failure records by key, ordered from the most to the least recently
+// failed. A table without lockout stats only delays failures, and never locks a key out.
+type authFailureTable struct {
+	entries map[string]*list.Element
+	order   *list.List
+
+	lockoutsStat *Stat
+	lockedStat   *Stat
+}
+
+func newAuthFailureTable(lockoutsStat *Stat, lockedStat *Stat) *authFailureTable {
+	return &authFailureTable{
+		entries:      make(map[string]*list.Element),
+		order:        list.New(),
+		lockoutsStat: lockoutsStat,
+		lockedStat:   lockedStat,
+	}
+}
+
+// AuthLimiter tracks failed authentication attempts per username from each source IP address,
+// per source IP address, and per username. Each failure earns an exponentially increasing
+// delay before the failure is reported, growing with whichever of the three has failed most,
+// and once the number of recent failures for a username from an IP, or for an IP, reaches a
+// threshold, further attempts are refused outright until a lockout period expires. A username
+// is only locked out from the addresses that failed to log in as it, so that attempts from
+// elsewhere cannot lock a user out; its failures from all addresses only add delay. Failures
+// are forgotten after a lockout period passes with no further failures. Authentication
+// succeeding only forgets the failures for the username from that IP, so that logging in to
+// one account does not reset the failures of an IP trying others.
+type AuthLimiter struct {
+	Logger
+	clock            Clock
+	lock             sync.Mutex
+	byUser           *authFailureTable
+	byIP             *authFailureTable
+	byName           *authFailureTable
+	baseDelay        time.Duration
+	maxDelay         time.Duration
+	lockoutThreshold int
+	lockoutDuration  time.Duration
+
+	failuresStat      *Stat
+	lockedRejectsStat *Stat
+}
+
+// NewAuthLimiter creates a new AuthLimiter, which measures delays and lockouts with clock. A
+// lockoutThreshold of 0 disables lockouts (delays still apply), and a maxDelay of 0 disables
+// delays.
+func NewAuthLimiter(
+	logger Logger,
+	stats *StatsRegistry,
+	clock Clock,
+	maxDelay time.Duration,
+	lockoutThreshold int,
+	lockoutDuration time.Duration,
+) *AuthLimiter {
+	l := &AuthLimiter{
+		Logger:           logger.Fork("auth-limiter"),
+		clock:            clock,
+		baseDelay:        DefaultAuthFailureDelay,
+		maxDelay:         maxDelay,
+		lockoutThreshold: lockoutThreshold,
+		lockoutDuration:  lockoutDuration,
+	}
+	if l.baseDelay > maxDelay {
+		l.baseDelay = maxDelay
+	}
+	l.failuresStat = stats.Counter(
+		"chisel_auth_failures_total",
+		"Number of failed client authentication attempts",
+		nil)
+	l.lockedRejectsStat = stats.Counter(
+		"chisel_auth_locked_rejections_total",
+		"Number of client authentication attempts refused because the username or source IP was locked out",
+		nil)
+	lockoutsStat := func(scope string) *Stat {
+		return stats.Counter(
+			"chisel_auth_lockouts_total",
+			"Number of times a username from a source IP, or a source IP, has been locked out after repeated authentication failures",
+			StatLabels{"scope": scope})
+	}
+	lockedStat := func(scope string) *Stat {
+		return stats.Gauge(
+			"chisel_auth_locked_out",
+			"Number of usernames from source IPs, or source IPs, currently locked out",
+			StatLabels{"scope": scope})
+	}
+	l.byUser = newAuthFailureTable(lockoutsStat("user"), lockedStat("user"))
+	l.byIP = newAuthFailureTable(lockoutsStat("ip"), lockedStat("ip"))
+	l.byName = newAuthFailureTable(nil, nil)
+	return l
+}
+
+// userKey returns the key of the failures for a username from a source IP
+func userKey(user string, ip string) string {
+	return user + "\x00" + ip
+}
+
+// expired returns true if a failure record no longer has any effect
+func (l *AuthLimiter) expired(f *authFailures, now time.Time) bool {
+	return now.After(f.lockedUntil) && now.Sub(f.last) > l.lockoutDuration
+}
+
+// get returns the live failure record for a key, discarding it if it has expired. The caller
+// must hold the lock.
+func (l *AuthLimiter) get(t *authFailureTable, key string, now time.Time) *authFailures {
+	e, ok := t.entries[key]
+	if !ok {
+		return nil
+	}
+	f := e.Value.(*authFailures)
+	if l.expired(f, now) {
+		l.remove(t, e)
+		return nil
+	}
+	return f
+}
+
+// remove discards a failure record. The caller must hold the lock.
+func (l *AuthLimiter) remove(t *authFailureTable, e *list.Element) {
+	f := t.order.Remove(e).(*authFailures)
+	delete(t.entries, f.key)
+	l.unlock(t, f)
+}
+
+// unlock stops counting a failure record as locked out. The caller must hold the lock.
+func (l *AuthLimiter) unlock(t *authFailureTable, f *authFailures) {
+	if f.locked {
+		f.locked = false
+		t.lockedStat.Dec()
+	}
+}
+
+// Check returns an error if authentication attempts for a username from a source IP, or from
+// the source IP, are currently locked out
+func (l *AuthLimiter) Check(user string, ip string) error {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	now := l.clock.Now()
+	if f := l.get(l.byUser, userKey(user, ip), now); f != nil && now.Before(f.lockedUntil) {
+		l.lockedRejectsStat.Inc()
+		return fmt.Errorf("Too many failed attempts for username %s from %s; try again in %s",
+			user, ip, f.lockedUntil.Sub(now).Round(time.Second))
+	}
+	if f := l.get(l.byIP, ip, now); f != nil && now.Before(f.lockedUntil) {
+		l.lockedRejectsStat.Inc()
+		return fmt.Errorf("Too many failed attempts from %s; try again in %s",
+			ip, f.lockedUntil.Sub(now).Round(time.Second))
+	}
+	return nil
+}
+
+// IPLockout returns how much longer a source IP is locked out, or 0 if it is not
+func (l *AuthLimiter) IPLockout(ip string) time.Duration {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	now := l.clock.Now()
+	if f := l.get(l.byIP, ip, now); f != nil && now.Before(f.lockedUntil) {
+		l.lockedRejectsStat.Inc()
+		return f.lockedUntil.Sub(now)
+	}
+	return 0
+}
+
+// recordFailure records a failure against a single key, described in the log by what, and
+// returns the resulting failure count. The caller must hold the lock.
+func (l *AuthLimiter) recordFailure(t *authFailureTable, key string, what string, now time.Time) int {
+	f := l.get(t, key, now)
+	if f == nil {
+		if t.order.Len() >= authLimiterMaxEntries {
+			l.remove(t, t.order.Back())
+		}
+		f = &authFailures{key: key}
+		t.entries[key] = t.order.PushFront(f)
+	} else {
+		t.order.MoveToFront(t.entries[key])
+	}
+	f.count++
+	f.last = now
+	if t.lockoutsStat != nil && l.lockoutThreshold > 0 && f.count >= l.lockoutThreshold && !now.Before(f.lockedUntil) {
+		f.lockedUntil = now.Add(l.lockoutDuration)
+		t.lockoutsStat.Inc()
+		if !f.locked {
+			f.locked = true
+			t.lockedStat.Inc()
+		}
+		l.clock.AfterFunc(l.lockoutDuration, func() {
+			l.lock.Lock()
+			defer l.lock.Unlock()
+			if !l.clock.Now().Before(f.lockedUntil) {
+				l.unlock(t, f)
+			}
+		})
+		l.ILogf("Locking out %s for %s after %d failed authentication attempts", what, l.lockoutDuration, f.count)
+	}
+	return f.count
+}
+
+// Failure records a failed authentication attempt for a username from a source IP, and returns
+// the delay that should be imposed before the failure is reported to the client
+func (l *AuthLimiter) Failure(user string, ip string) time.Duration {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	now := l.clock.Now()
+	l.failuresStat.Inc()
+	n := l.recordFailure(l.byUser, userKey(user, ip), "username "+user+" from "+ip, now)
+	if m := l.recordFailure(l.byIP, ip, ip, now); m > n {
+		n = m
+	}
+	if m := l.recordFailure(l.byName, user, "username "+user, now); m > n {
+		n = m
+	}
+
+	if l.maxDelay <= 0 {
+		return 0
+	}
+	delay := l.baseDelay
+	for i := 1; i < n && delay < l.maxDelay; i++ {
+		delay *= 2
+	}
+	if delay > l.maxDelay {
+		delay = l.maxDelay
+	}
+	return delay
+}
+
+// Success records a successful authentication, forgetting previous failures for the username
+// from the source IP. The failures of the source IP, and of the username from elsewhere, are
+// kept until they expire.
+func (l *AuthLimiter) Success(user string, ip string) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if e, ok := l.byUser.entries[userKey(user, ip)]; ok {
+		l.remove(l.byUser, e)
+	}
+}
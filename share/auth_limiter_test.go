@@ -0,0 +1,51 @@
+package chshare
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func newTestAuthLimiter() *AuthLimiter {
+	return NewAuthLimiter(NewLogger("test", LogLevelError), NewStatsRegistry(), SystemClock,
+		DefaultAuthMaxFailureDelay, 3, time.Minute)
+}
+
+func TestAuthLimiterSuccessKeepsIPLockout(t *testing.T) {
+	l := newTestAuthLimiter()
+	l.Failure("alice", "192.0.2.1")
+	l.Failure("bob", "192.0.2.1")
+	// logging in to an account the attacker controls does not reset the IP's failures
+	l.Success("mallory", "192.0.2.1")
+	l.Failure("carol", "192.0.2.1")
+	if err := l.Check("dave", "192.0.2.1"); err == nil {
+		t.Fatalf("source IP not locked out after a success for another username")
+	}
+}
+
+func TestAuthLimiterSuccessForgetsUserFromIP(t *testing.T) {
+	l := newTestAuthLimiter()
+	l.Failure("alice", "192.0.2.1")
+	l.Failure("alice", "192.0.2.1")
+	l.Success("alice", "192.0.2.1")
+	if _, ok := l.byUser.entries[userKey("alice", "192.0.2.1")]; ok {
+		t.Fatalf("success did not forget the username's failures from the IP")
+	}
+	if _, ok := l.byIP.entries["192.0.2.1"]; !ok {
+		t.Fatalf("success forgot the IP's failures")
+	}
+}
+
+func TestAuthLimiterUsernameDelayWithoutLockout(t *testing.T) {
+	l := newTestAuthLimiter()
+	var delay time.Duration
+	for i := 0; i < 5; i++ {
+		delay = l.Failure("alice", fmt.Sprintf("192.0.2.%d", i+1))
+	}
+	if want := 16 * DefaultAuthFailureDelay; delay != want {
+		t.Fatalf("delay after 5 failures from different IPs is %s, want %s", delay, want)
+	}
+	if err := l.Check("alice", "192.0.2.100"); err != nil {
+		t.Fatalf("username locked out from a new address: %s", err)
+	}
+}
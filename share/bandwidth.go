@@ -0,0 +1,155 @@
+package chshare
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// bandwidthActiveWindow is how long a bucket continues to claim its share of the bandwidth
+// after it last sent data
+const bandwidthActiveWindow = time.Second
+
+// bandwidthBurst is how much sending time a bucket may save up while under its share
+const bandwidthBurst = 100 * time.Millisecond
+
+// ParseByteCount parses a number of bytes (or bytes per second), with an optional K, M or G
+// (decimal) suffix, e.g. "500K" or "10M"
+func ParseByteCount(s string) (int64, error) {
+	t := strings.ToUpper(strings.TrimSpace(s))
+	t = strings.TrimSuffix(t, "B")
+	multiplier := int64(1)
+	switch {
+	case strings.HasSuffix(t, "K"):
+		multiplier = 1000
+	case strings.HasSuffix(t, "M"):
+		multiplier = 1000 * 1000
+	case strings.HasSuffix(t, "G"):
+		multiplier = 1000 * 1000 * 1000
+	}
+	if multiplier != 1 {
+		t = t[:len(t)-1]
+	}
+	n, err := strconv.ParseInt(t, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("Invalid byte count '%s'", s)
+	}
+	return n * multiplier, nil
+}
+
+// ParseBandwidthWeights parses a comma-separated list of <user>=<weight> pairs
+func ParseBandwidthWeights(s string) (map[string]int, error) {
+	weights := make(map[string]int)
+	if strings.TrimSpace(s) == "" {
+		return weights, nil
+	}
+	for _, pair := range strings.Split(s, ",") {
+		kv := strings.SplitN(strings.TrimSpace(pair), "=", 2)
+		if len(kv) != 2 || kv[0] == "" {
+			return nil, fmt.Errorf("Invalid bandwidth weight '%s'; expected <user>=<weight>", pair)
+		}
+		w, err := strconv.Atoi(kv[1])
+		if err != nil || w <= 0 {
+			return nil, fmt.Errorf("Invalid bandwidth weight '%s'; weight must be a positive integer", pair)
+		}
+		weights[kv[0]] = w
+	}
+	return weights, nil
+}
+
+// BandwidthScheduler shares a fixed total sending rate between sessions. Each session sends
+// through its own token bucket, and the buckets that have sent recently divide the total rate
+// between them in proportion to their weights, so that a session performing bulk transfers
+// cannot starve the others, while an idle session's share is available to the busy ones.
+type BandwidthScheduler struct {
+	lock    sync.Mutex
+	clock   Clock
+	rate    float64
+	active  map[*BandwidthBucket]struct{}
+	weights int
+
+	activeBucketsStat *Stat
+	throttledMsStat   *Stat
+}
+
+// NewBandwidthScheduler creates a BandwidthScheduler sharing rate bytes per second, as
+// measured by clock
+func NewBandwidthScheduler(stats *StatsRegistry, clock Clock, rate int64) *BandwidthScheduler {
+	return &BandwidthScheduler{
+		clock:  clock,
+		rate:   float64(rate),
+		active: make(map[*BandwidthBucket]struct{}),
+		activeBucketsStat: stats.Gauge(
+			"chisel_bandwidth_active_sessions",
+			"Number of sessions currently sharing the server's bandwidth limit",
+			nil),
+		throttledMsStat: stats.Counter(
+			"chisel_bandwidth_throttled_ms_total",
+			"Total time that session writes have been delayed by the server's bandwidth limit, in milliseconds",
+			nil),
+	}
+}
+
+// NewBucket creates a token bucket for a session with the given weight
+func (b *BandwidthScheduler) NewBucket(weight int) *BandwidthBucket {
+	if weight <= 0 {
+		weight = 1
+	}
+	return &BandwidthBucket{scheduler: b, weight: weight}
+}
+
+// expire stops counting buckets that have not sent recently. The caller must hold the lock.
+func (b *BandwidthScheduler) expire(now time.Time) {
+	for bucket := range b.active {
+		if now.Sub(bucket.lastActive) > bandwidthActiveWindow {
+			delete(b.active, bucket)
+			b.weights -= bucket.weight
+		}
+	}
+	b.activeBucketsStat.Set(int64(len(b.active)))
+}
+
+// BandwidthBucket is a session's token bucket within a BandwidthScheduler
+type BandwidthBucket struct {
+	scheduler  *BandwidthScheduler
+	weight     int
+	tokens     float64
+	last       time.Time
+	lastActive time.Time
+}
+
+// Take takes n bytes' worth of tokens from the bucket. If wait is true and the bucket is in
+// deficit, Take sleeps until the deficit has been repaid at the bucket's share of the rate;
+// otherwise the deficit is left to be repaid by later calls.
+func (bb *BandwidthBucket) Take(n int, wait bool) {
+	b := bb.scheduler
+	b.lock.Lock()
+	now := b.clock.Now()
+	if _, ok := b.active[bb]; !ok {
+		b.active[bb] = struct{}{}
+		b.weights += bb.weight
+		bb.last = now
+	}
+	bb.lastActive = now
+	b.expire(now)
+
+	rate := b.rate * float64(bb.weight) / float64(b.weights)
+	bb.tokens += rate * now.Sub(bb.last).Seconds()
+	if burst := rate * bandwidthBurst.Seconds(); bb.tokens > burst {
+		bb.tokens = burst
+	}
+	bb.last = now
+	bb.tokens -= float64(n)
+	var delay time.Duration
+	if wait && bb.tokens < 0 {
+		delay = time.Duration(-bb.tokens / rate * float64(time.Second))
+	}
+	b.lock.Unlock()
+
+	if delay > 0 {
+		b.throttledMsStat.Add(int64(delay / time.Millisecond))
+		<-b.clock.After(delay)
+	}
+}
@@ -0,0 +1,45 @@
+package chshare
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// DefaultStubBindAddr is the address a TCP stub listens on if its descriptor does not give one
+const DefaultStubBindAddr = "127.0.0.1"
+
+// WildcardStubBindAddr is the address a TCP stub listens on if its descriptor does not give
+// one but has the "bind-any" option set
+const WildcardStubBindAddr = "0.0.0.0"
+
+// validateBindAnyOption validates the value of the "bind-any" descriptor option
+func validateBindAnyOption(value string) error {
+	if _, err := strconv.ParseBool(value); err != nil {
+		return fmt.Errorf("Invalid bind-any value '%s'; expected true or false", value)
+	}
+	return nil
+}
+
+// bindAnyOption returns true if options has the "bind-any" descriptor option set
+func bindAnyOption(options map[string]string) bool {
+	bindAny, _ := strconv.ParseBool(options["bind-any"])
+	return bindAny
+}
+
+// IsWildcardBindAddr returns true if host, a stub's bind address, listens on all interfaces,
+// e.g. 0.0.0.0 or [::]
+func IsWildcardBindAddr(host string) bool {
+	ip := net.ParseIP(strings.TrimSuffix(strings.TrimPrefix(host, "["), "]"))
+	return ip != nil && ip.IsUnspecified()
+}
+
+// EndpointBindsAny returns true if an endpoint is a TCP stub that listens on all interfaces
+func EndpointBindsAny(ced *ChannelEndpointDescriptor) bool {
+	if ced.Role != ChannelEndpointRoleStub || ced.Type != ChannelEndpointTypeTCP {
+		return false
+	}
+	host, _, err := ParseHostPort(ced.Path, "", UnknownPortNumber)
+	return err == nil && IsWildcardBindAddr(host)
+}
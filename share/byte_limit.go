@@ -0,0 +1,164 @@
+package chshare
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+)
+
+// validateMaxBytesOption validates the value of the "max-bytes" descriptor option
+func validateMaxBytesOption(value string) error {
+	_, err := parseMaxBytesOption(value)
+	return err
+}
+
+// parseMaxBytesOption parses the value of the "max-bytes" descriptor option
+func parseMaxBytesOption(value string) (int64, error) {
+	n, err := ParseByteCount(value)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("Invalid byte limit '%s'; must be a positive byte count, e.g. '100M'", value)
+	}
+	return n, nil
+}
+
+// EndpointMaxBytes returns the number of bytes an endpoint's channels may transfer, in both
+// directions together, or 0 for no limit
+func EndpointMaxBytes(ced *ChannelEndpointDescriptor) int64 {
+	n, _ := parseMaxBytesOption(ced.Option("max-bytes"))
+	return n
+}
+
+// minByteLimit returns the smaller of two byte limits, where 0 is no limit
+func minByteLimit(a int64, b int64) int64 {
+	if a == 0 || (b != 0 && b < a) {
+		return b
+	}
+	return a
+}
+
+// ByteCount is a number of bytes in a JSON configuration file, given either as a number or
+// as a string with an optional K, M or G suffix (see ParseByteCount)
+type ByteCount int64
+
+// UnmarshalJSON implements json.Unmarshaler
+func (b *ByteCount) UnmarshalJSON(data []byte) error {
+	var n int64
+	if err := json.Unmarshal(data, &n); err == nil {
+		if n < 0 {
+			return fmt.Errorf("Invalid byte count %d", n)
+		}
+		*b = ByteCount(n)
+		return nil
+	}
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("Invalid byte count %s", data)
+	}
+	n, err := ParseByteCount(s)
+	if err != nil {
+		return err
+	}
+	*b = ByteCount(n)
+	return nil
+}
+
+// LimitChannelConn returns a ChannelConn that closes conn, a connection to the remote proxy,
+// with CloseReasonByteLimit once more than limit bytes have been read from and written to it
+// in total. If limit is 0, conn is returned unchanged.
+func LimitChannelConn(conn ChannelConn, limit int64) ChannelConn {
+	return LimitChannelConnWithWarning(conn, limit, 0, nil)
+}
+
+// LimitChannelConnWithWarning is like LimitChannelConn, but also calls warn, once, when
+// warnAt bytes have been transferred, if warnAt is not 0
+func LimitChannelConnWithWarning(conn ChannelConn, limit int64, warnAt int64, warn func(used int64)) ChannelConn {
+	if limit <= 0 {
+		return conn
+	}
+	return &byteLimitConn{ChannelConn: conn, limit: limit, warnAt: warnAt, warn: warn}
+}
+
+// byteLimitConn is a ChannelConn that limits the bytes read from and written to another
+// ChannelConn
+type byteLimitConn struct {
+	ChannelConn
+	limit int64
+
+	// warn, if not nil, is called once warnAt bytes have been transferred
+	warnAt int64
+	warn   func(used int64)
+
+	// lock protects used, the bytes transferred or about to be, and warned
+	lock   sync.Mutex
+	used   int64
+	warned bool
+}
+
+// reserve claims up to n of the remaining bytes, and returns the number claimed
+func (c *byteLimitConn) reserve(n int) int {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if remaining := c.limit - c.used; int64(n) > remaining {
+		n = int(remaining)
+	}
+	c.used += int64(n)
+	return n
+}
+
+// release returns bytes that were claimed but not transferred, and warns if the bytes that
+// were transferred have reached warnAt
+func (c *byteLimitConn) release(n int) {
+	c.lock.Lock()
+	c.used -= int64(n)
+	used := c.used
+	warn := c.warn != nil && c.warnAt > 0 && used >= c.warnAt && !c.warned
+	if warn {
+		c.warned = true
+	}
+	c.lock.Unlock()
+	if warn {
+		c.warn(used)
+	}
+}
+
+// exceed closes the connection because it has reached its limit
+func (c *byteLimitConn) exceed() error {
+	info := &ChannelCloseInfo{
+		Reason:  CloseReasonByteLimit,
+		Message: fmt.Sprintf("Channel exceeded its limit of %d bytes", c.limit),
+	}
+	c.ChannelConn.SetCloseReason(info)
+	c.ChannelConn.Close()
+	return fmt.Errorf("%s", info.Message)
+}
+
+func (c *byteLimitConn) Read(p []byte) (int, error) {
+	if len(p) == 0 {
+		return c.ChannelConn.Read(p)
+	}
+	allowed := c.reserve(len(p))
+	if allowed == 0 {
+		return 0, c.exceed()
+	}
+	n, err := c.ChannelConn.Read(p[:allowed])
+	c.release(allowed - n)
+	return n, err
+}
+
+func (c *byteLimitConn) Write(p []byte) (int, error) {
+	allowed := c.reserve(len(p))
+	var n int
+	var err error
+	if allowed > 0 {
+		n, err = c.ChannelConn.Write(p[:allowed])
+		c.release(allowed - n)
+	}
+	if err == nil && allowed < len(p) {
+		err = c.exceed()
+	}
+	return n, err
+}
+
+func (c *byteLimitConn) String() string {
+	return fmt.Sprintf("limit:%s", c.ChannelConn)
+}
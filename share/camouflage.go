@@ -0,0 +1,146 @@
+package chshare
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// CamouflageResponse describes a response to a non-chisel HTTP request
+type CamouflageResponse struct {
+	// Status is the HTTP status code; defaults to 200
+	Status int `json:"status"`
+
+	// Headers are added to the response, after the Camouflage's common headers
+	Headers map[string]string `json:"headers"`
+
+	// Body is the response body
+	Body string `json:"body"`
+
+	// BodyFile, if not "", is a file holding the response body, relative to the camouflage
+	// file. It replaces Body.
+	BodyFile string `json:"body_file"`
+
+	// Proxy is true if the request is forwarded to the --proxy server instead
+	Proxy bool `json:"proxy"`
+}
+
+// Camouflage customizes the server's responses to requests that are not from chisel
+// clients, so that they do not identify the server as chisel. It is loaded from a JSON file:
+//
+//	{
+//	  "headers": {"Server": "nginx"},
+//	  "default": {"status": 404, "body_file": "404.html"},
+//	  "paths": {
+//	    "/": {"headers": {"Content-Type": "text/html"}, "body": "<h1>Welcome</h1>"},
+//	    "/static/*": {"proxy": true}
+//	  },
+//	  "hide_builtin": true
+//	}
+type Camouflage struct {
+	// Headers are set on every non-chisel response, including proxied responses
+	Headers map[string]string `json:"headers"`
+
+	// Default, if not nil, is the response to requests for paths that are not otherwise
+	// handled, replacing the plain "Not Found" response
+	Default *CamouflageResponse `json:"default"`
+
+	// Paths holds responses for particular request paths. A path ending in "*" matches
+	// every path with the preceding prefix; the longest matching prefix wins, and an exact
+	// path wins over any prefix. Paths take precedence over /health, /version and --proxy.
+	Paths map[string]*CamouflageResponse `json:"paths"`
+
+	// HideBuiltin is true if the /health and /version paths are not served
+	HideBuiltin bool `json:"hide_builtin"`
+
+	// prefixes holds the prefixes of the Paths ending in "*", longest first
+	prefixes []string
+}
+
+// LoadCamouflage loads and validates a Camouflage from a JSON file
+func LoadCamouflage(path string) (*Camouflage, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read camouflage file: %s", err)
+	}
+	c := &Camouflage{}
+	if err := json.Unmarshal(b, c); err != nil {
+		return nil, fmt.Errorf("Invalid JSON in camouflage file %s: %s", path, err)
+	}
+	dir := filepath.Dir(path)
+	responses := map[string]*CamouflageResponse{"default": c.Default}
+	for p, resp := range c.Paths {
+		if !strings.HasPrefix(p, "/") {
+			return nil, fmt.Errorf("Camouflage path '%s' must start with '/'", p)
+		}
+		if resp == nil {
+			return nil, fmt.Errorf("Missing response for camouflage path '%s'", p)
+		}
+		if strings.HasSuffix(p, "*") {
+			c.prefixes = append(c.prefixes, strings.TrimSuffix(p, "*"))
+		}
+		responses[p] = resp
+	}
+	for name, resp := range responses {
+		if resp == nil {
+			continue
+		}
+		if resp.Status == 0 {
+			resp.Status = http.StatusOK
+		}
+		if resp.Status < 100 || resp.Status > 599 {
+			return nil, fmt.Errorf("Invalid status %d for camouflage response '%s'", resp.Status, name)
+		}
+		if resp.BodyFile != "" {
+			bodyPath := resp.BodyFile
+			if !filepath.IsAbs(bodyPath) {
+				bodyPath = filepath.Join(dir, bodyPath)
+			}
+			body, err := ioutil.ReadFile(bodyPath)
+			if err != nil {
+				return nil, fmt.Errorf("Failed to read body of camouflage response '%s': %s", name, err)
+			}
+			resp.Body = string(body)
+		}
+	}
+	sort.Slice(c.prefixes, func(i, j int) bool {
+		return len(c.prefixes[i]) > len(c.prefixes[j])
+	})
+	return c, nil
+}
+
+// Lookup returns the response configured for a request path, or nil if there is none
+func (c *Camouflage) Lookup(path string) *CamouflageResponse {
+	if resp, ok := c.Paths[path]; ok && !strings.HasSuffix(path, "*") {
+		return resp
+	}
+	for _, prefix := range c.prefixes {
+		if strings.HasPrefix(path, prefix) {
+			return c.Paths[prefix+"*"]
+		}
+	}
+	return nil
+}
+
+// SetHeaders sets the common headers on a response
+func (c *Camouflage) SetHeaders(h http.Header) {
+	for name, value := range c.Headers {
+		h.Set(name, value)
+	}
+}
+
+// Serve writes a response. The common headers must already have been set.
+func (c *Camouflage) Serve(w http.ResponseWriter, resp *CamouflageResponse) {
+	for name, value := range resp.Headers {
+		w.Header().Set(name, value)
+	}
+	if w.Header().Get("Content-Type") == "" && resp.Body != "" {
+		w.Header().Set("Content-Type", http.DetectContentType([]byte(resp.Body)))
+	}
+	w.WriteHeader(resp.Status)
+	w.Write([]byte(resp.Body))
+}
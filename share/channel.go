@@ -5,6 +5,7 @@ import (
 	"io"
 	"sync"
 	"sync/atomic"
+	"time"
 
 	socks5 "github.com/armon/go-socks5"
 	"golang.org/x/crypto/ssh"
@@ -130,6 +131,18 @@ import (
 
 var lastBasicBridgeNum int64 = 0
 
+// bridgeBufferSize is the size of the buffers that BasicBridgeChannels copies through
+const bridgeBufferSize = 32 * 1024
+
+// bridgeBuffers holds the buffers of finished bridges for reuse, so that opening a channel
+// does not allocate them anew
+var bridgeBuffers = sync.Pool{
+	New: func() interface{} {
+		buf := make([]byte, bridgeBufferSize)
+		return &buf
+	},
+}
+
 // BasicBridgeChannels connects two ChannelConn's together, copying betweeen them bi-directionally
 // until end-of-stream is reached in both directions. Both channels are closed before this function
 // returns. Three values are returned:
@@ -156,7 +169,9 @@ func BasicBridgeChannels(
 	wg.Add(2)
 	copyFunc := func(src ChannelConn, dst ChannelConn, bytesCopied *int64, copyErr *error) {
 		// Copy from caller to calledService
-		*bytesCopied, *copyErr = io.Copy(dst, src)
+		buf := bridgeBuffers.Get().(*[]byte)
+		*bytesCopied, *copyErr = io.CopyBuffer(dst, src, *buf)
+		bridgeBuffers.Put(buf)
 		if *copyErr != nil {
 			logger.DLogf("io.Copy(%s->%s) returned error: %s", src, dst, *copyErr)
 		}
@@ -171,6 +186,12 @@ func BasicBridgeChannels(
 	logger.DLogf("Wait complete")
 	logger.DLogf("callerToService=%d, err=%s", callerToServiceBytes, callerToServiceErr)
 	logger.DLogf("serviceToCaller=%d, err=%s", serviceToCallerBytes, serviceToCallerErr)
+	// pass on the reason either side was closed for, so that it reaches the remote proxy
+	if info := calledService.CloseReason(); info != nil {
+		caller.SetCloseReason(info)
+	} else if info := caller.CloseReason(); info != nil {
+		calledService.SetCloseReason(info)
+	}
 	logger.DLogf("Closing calledService")
 	calledService.Close()
 	logger.DLogf("Closing caller")
@@ -188,6 +209,9 @@ type LocalChannelEnv interface {
 	// IsServer returns true if this is a proxy server; false if it is a cliet
 	IsServer() bool
 
+	// IsTUNAllowed returns true if the remote proxy may have this proxy attach to TUN devices
+	IsTUNAllowed() bool
+
 	// GetLoopServer returns the shared LoopServer if loop protocol is enabled; nil otherwise
 	GetLoopServer() *LoopServer
 
@@ -195,6 +219,97 @@ type LocalChannelEnv interface {
 	// nil otherwise
 	GetSocksServer() *socks5.Server
 
+	// GetStatsRegistry returns the StatsRegistry to which local endpoints and proxies
+	// should report their metrics
+	GetStatsRegistry() *StatsRegistry
+
+	// GetClock returns the Clock by which local endpoints and proxies measure time
+	GetClock() Clock
+
+	// GetReconnectQueueTime returns how long a connection accepted by a stub endpoint may
+	// wait for the connection to the remote proxy to be re-established after it is lost, or
+	// 0 to wait for the connection indefinitely, without retrying channels that fail
+	// because it was lost
+	GetReconnectQueueTime() time.Duration
+
+	// GetWriteScheduler returns the WriteScheduler that prioritizes channel writes to the
+	// SSH connection with the remote proxy
+	GetWriteScheduler() *WriteScheduler
+
+	// CheckChannelAdmission returns a ChannelAdmissionError if new channels should be
+	// refused because this proxy is short of memory, or nil if they may be opened
+	CheckChannelAdmission() error
+
+	// CheckChannelPolicy returns an error if a new channel with the given local endpoint is
+	// refused by this proxy's channel policy, or nil if it may be opened. ctx carries the
+	// channel's RequestMetadata.
+	CheckChannelPolicy(ctx context.Context, ced *ChannelEndpointDescriptor) error
+
+	// GetDialAllowlist returns the DialAllowlist that restricts the skeleton endpoints the
+	// remote proxy may request, or nil if they are not restricted
+	GetDialAllowlist() *DialAllowlist
+
+	// GetDialSource returns the default source of the connections skeleton endpoints make to
+	// their Called Services, or nil to let the system choose
+	GetDialSource() *DialSource
+
+	// GetDialProxy returns the proxy through which skeleton endpoints connect to their
+	// Called Services, or nil to connect to them directly
+	GetDialProxy() *DialProxy
+
+	// GetUnixSocketDirs returns the directories to which unix skeleton endpoints are
+	// restricted, or nil if they may connect to any socket
+	GetUnixSocketDirs() *UnixSocketDirs
+
+	// GetChannelObserver returns the permission to observe other channels through observe
+	// skeleton endpoints, or nil if they may not be observed
+	GetChannelObserver() *ChannelObserver
+
+	// GetChannelAliases returns the aliases to which alias skeleton endpoints are resolved,
+	// or nil if none are defined
+	GetChannelAliases() *ChannelAliases
+
+	// GetExecCommands returns the commands that exec skeleton endpoints may run, or nil if
+	// none are defined
+	GetExecCommands() *ExecCommands
+
+	// GetMQTTTopicPrefixes returns the topic prefixes to which MQTT skeleton endpoints restrict
+	// the topics their Callers may use, or nil if they are not restricted
+	GetMQTTTopicPrefixes() []string
+
+	// GetE2EKey returns this proxy's key for end-to-end encrypted channels, or nil if it
+	// has none
+	GetE2EKey() *E2EKey
+
+	// TapChannel offers a channel with the given local endpoint to the proxy's ChannelTap, if
+	// any, and returns the connection to the remote proxy to use for the channel's traffic.
+	// ctx carries the channel's RequestMetadata.
+	TapChannel(ctx context.Context, ced *ChannelEndpointDescriptor, conn ChannelConn) ChannelConn
+
+	// TrackChannel records an open channel, with the given local endpoint, to the remote
+	// proxy, so that the reason for closing the proxy's session can be passed on to it, and
+	// so that its traffic is counted, and returns a function that forgets the channel
+	TrackChannel(ced *ChannelEndpointDescriptor, conn ChannelConn) func()
+
+	// IdentifyChannel returns the connection to the remote proxy to use for a channel to a
+	// skeleton endpoint, which sends the tunnel user's identity to the Called Service if the
+	// endpoint has the "ident" option
+	IdentifyChannel(ced *ChannelEndpointDescriptor, conn ChannelConn) ChannelConn
+
+	// LimitChannel returns the connection to the remote proxy to use for a channel with the
+	// given local endpoint, which closes the channel once it has transferred more bytes than
+	// the endpoint's "max-bytes" option, or the proxy's own limit for the channel, allows
+	LimitChannel(ced *ChannelEndpointDescriptor, conn ChannelConn) ChannelConn
+
+	// GetHeldChannels returns the HeldChannels that hold the skeleton side of channels with
+	// the "hold" option across sessions, or nil if this proxy does not hold channels
+	GetHeldChannels() *HeldChannels
+
+	// GetLocalSkeleton returns a skeleton endpoint in this process that a channel to the
+	// remote skeleton endpoint ced would end up at, so that the channel can be served
+	// locally without a round trip through the remote proxy, or nil if there is none
+	GetLocalSkeleton(ced *ChannelEndpointDescriptor) *ChannelEndpointDescriptor
+
 	// GetSSHConn waits for and returns the main ssh.Conn that this proxy is using to
 	// communicate with the remote proxy. It is possible that goroutines servicing
 	// local stub sockets will ask for this before it is available (if for example
@@ -0,0 +1,170 @@
+package chshare
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"sync"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// channelAliasNameRegexp matches a valid channel alias name
+var channelAliasNameRegexp = regexp.MustCompile(`^[a-zA-Z][a-zA-Z0-9_.-]*$`)
+
+// ValidateChannelAliasName returns an error if name is not a valid channel alias name
+func ValidateChannelAliasName(name string) error {
+	if !channelAliasNameRegexp.MatchString(name) {
+		return fmt.Errorf("Invalid alias name '%s'; must start with a letter and contain only letters, digits, '_', '.' and '-'", name)
+	}
+	return nil
+}
+
+// ChannelAliases is a reloadable set of named skeleton endpoints defined by the server, so
+// that clients can ask for a target by name, e.g. "alias:vehicle-diag", without knowing its
+// address, and access lists can grant the name rather than the address. An alias is resolved
+// when a channel to it is opened, so its target may change without clients reconnecting.
+type ChannelAliases struct {
+	Logger
+	file string
+
+	// lock protects aliases
+	lock    sync.RWMutex
+	aliases map[string]*ChannelEndpointDescriptor
+}
+
+// LoadChannelAliases loads channel aliases from a JSON file mapping each alias name to the
+// skeleton endpoint descriptor of its target, with options, e.g.
+//
+//    {"vehicle-diag": "tcp:10.3.2.1:5555", "db": "tcp://db.internal:5432?pin=<fingerprint>"}
+//
+// The file is reloaded when it changes.
+func LoadChannelAliases(logger Logger, file string) (*ChannelAliases, error) {
+	a := &ChannelAliases{
+		Logger: logger.Fork("aliases"),
+		file:   file,
+	}
+	if err := a.load(); err != nil {
+		return nil, err
+	}
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	if err := watcher.Add(filepath.Dir(file)); err != nil {
+		return nil, err
+	}
+	go func() {
+		for e := range watcher.Events {
+			if e.Name != file || e.Op&fsnotify.Write != fsnotify.Write {
+				continue
+			}
+			if err := a.load(); err != nil {
+				a.ILogf("Failed to reload, keeping the previous aliases: %s", err)
+				continue
+			}
+			a.ILogf("Reloaded from: %s", file)
+		}
+	}()
+	return a, nil
+}
+
+// load parses the aliases file, replacing the current aliases if it is valid
+func (a *ChannelAliases) load() error {
+	b, err := ioutil.ReadFile(a.file)
+	if err != nil {
+		return fmt.Errorf("Failed to read aliases file: %s", err)
+	}
+	var targets map[string]string
+	if err := json.Unmarshal(b, &targets); err != nil {
+		return fmt.Errorf("Invalid JSON in aliases file %s: %s", a.file, err)
+	}
+	aliases := make(map[string]*ChannelEndpointDescriptor, len(targets))
+	for name, target := range targets {
+		if err := ValidateChannelAliasName(name); err != nil {
+			return fmt.Errorf("In aliases file %s: %s", a.file, err)
+		}
+		ced, err := parseChannelAliasTarget(target)
+		if err != nil {
+			return fmt.Errorf("Invalid target for alias '%s' in %s: %s", name, a.file, err)
+		}
+		aliases[name] = ced
+	}
+	a.lock.Lock()
+	a.aliases = aliases
+	a.lock.Unlock()
+	a.DLogf("Loaded %d alias(es)", len(aliases))
+	return nil
+}
+
+// parseChannelAliasTarget parses the skeleton endpoint descriptor of an alias's target, in
+// the legacy or URI-style syntax, with an optional options suffix
+func parseChannelAliasTarget(s string) (*ChannelEndpointDescriptor, error) {
+	base, options, err := SplitDescriptorOptions(s)
+	if err != nil {
+		return nil, err
+	}
+	var ced *ChannelEndpointDescriptor
+	if IsURIChannelDescriptor(base) {
+		ced, err = parseEndpointURI(base, 0, base, ChannelEndpointRoleSkeleton)
+	} else {
+		ced, err = ParseChannelEndpointDescriptor(base, ChannelEndpointRoleSkeleton)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if ced.Type == ChannelEndpointTypeAlias || ced.Type == ChannelEndpointTypeStdio {
+		return nil, fmt.Errorf("%s endpoints cannot be alias targets", ced.Type)
+	}
+	if len(options) > 0 {
+		for k, v := range ced.Options {
+			options[k] = v
+		}
+		ced.Options = options
+	}
+	if err := ced.Validate(); err != nil {
+		return nil, err
+	}
+	return ced, nil
+}
+
+// Has returns true if an alias with the given name is defined. A nil ChannelAliases has no
+// aliases.
+func (a *ChannelAliases) Has(name string) bool {
+	if a == nil {
+		return false
+	}
+	a.lock.RLock()
+	defer a.lock.RUnlock()
+	_, ok := a.aliases[name]
+	return ok
+}
+
+// Resolve returns the skeleton endpoint that an alias endpoint currently stands for. The
+// options of the alias endpoint apply to the target, except those that the alias's own
+// definition sets, which the client cannot override.
+func (a *ChannelAliases) Resolve(ced *ChannelEndpointDescriptor) (*ChannelEndpointDescriptor, error) {
+	if a == nil {
+		return nil, fmt.Errorf("Channel aliases are not defined: %s", ced.String())
+	}
+	a.lock.RLock()
+	target, ok := a.aliases[ced.Path]
+	a.lock.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("Unknown channel alias '%s'", ced.Path)
+	}
+	resolved := *target
+	resolved.Role = ced.Role
+	if len(ced.Options) > 0 {
+		resolved.Options = make(map[string]string, len(ced.Options)+len(target.Options))
+		for k, v := range ced.Options {
+			resolved.Options[k] = v
+		}
+		for k, v := range target.Options {
+			resolved.Options[k] = v
+		}
+	}
+	return &resolved, nil
+}
@@ -23,6 +23,15 @@ type ChannelConn interface {
 
 	// GetNumBytesWritten returns the number of bytes written so far on a ChannelConn
 	GetNumBytesWritten() int64
+
+	// SetCloseReason records why the ChannelConn is being closed, unless a reason has already
+	// been recorded. A connection to the remote proxy sends the reason to the remote proxy
+	// when it is closed.
+	SetCloseReason(info *ChannelCloseInfo)
+
+	// CloseReason returns the reason recorded with SetCloseReason or, for a connection to
+	// the remote proxy, sent by the remote proxy; or nil if there is none
+	CloseReason() *ChannelCloseInfo
 }
 
 var nextBasicConnID int32
@@ -40,6 +49,9 @@ type BasicConn struct {
 	Strname         string
 	NumBytesRead    int64
 	NumBytesWritten int64
+
+	// closeInfo is the reason recorded with SetCloseReason, protected by Lock
+	closeInfo *ChannelCloseInfo
 }
 
 // InitBasicConn initializes the BasicConn portion of a new connection object
@@ -63,6 +75,24 @@ func (c *BasicConn) GetNumBytesWritten() int64 {
 	return atomic.LoadInt64(&c.NumBytesWritten)
 }
 
+// SetCloseReason records why the ChannelConn is being closed, unless a reason has already
+// been recorded. Part of the ChannelConn interface.
+func (c *BasicConn) SetCloseReason(info *ChannelCloseInfo) {
+	c.Lock.Lock()
+	defer c.Lock.Unlock()
+	if c.closeInfo == nil {
+		c.closeInfo = info
+	}
+}
+
+// CloseReason returns the reason recorded with SetCloseReason, or nil. Part of the
+// ChannelConn interface.
+func (c *BasicConn) CloseReason() *ChannelCloseInfo {
+	c.Lock.Lock()
+	defer c.Lock.Unlock()
+	return c.closeInfo
+}
+
 // GetNumBytesWritten returns the number of bytes written so far on a ChannelConn
 func (c *BasicConn) String() string {
 	return c.Strname
@@ -3,6 +3,7 @@ package chshare
 import (
 	"fmt"
 	"github.com/XevoInc/chisel/chprotobuf"
+	"strings"
 )
 
 // ChannelDescriptor describes a pair of endpoints, one on the client proxy and one
@@ -27,6 +28,9 @@ type ChannelDescriptor struct {
 
 // Validate a ChannelDescriptor
 func (d ChannelDescriptor) Validate() error {
+	if d.Stub == nil || d.Skeleton == nil {
+		return fmt.Errorf("Channel descriptor requires both a stub and a skeleton endpoint")
+	}
 	err := d.Stub.Validate()
 	if err != nil {
 		return err
@@ -47,6 +51,30 @@ func (d ChannelDescriptor) Validate() error {
 		return fmt.Errorf("%s: STDIO endpoint must be on client proxy side", d.String())
 	}
 
+	if d.Reverse && d.Skeleton.Type == ChannelEndpointTypeObserve {
+		return fmt.Errorf("%s: Observe endpoints are only supported on forward remotes", d.String())
+	}
+
+	if d.Reverse && d.Skeleton.Type == ChannelEndpointTypeAlias {
+		return fmt.Errorf("%s: Alias endpoints are only supported on forward remotes", d.String())
+	}
+
+	if d.Reverse && d.Stub.Option("hold") != "" {
+		return fmt.Errorf("%s: The hold option is only supported on forward remotes", d.String())
+	}
+
+	if d.Reverse && HasStubTLSOptions(d.Stub) {
+		return fmt.Errorf("%s: The tls-cert, tls-key and tls-client-ca options are only supported on forward remotes", d.String())
+	}
+
+	if err := checkTUNOptions(&d); err != nil {
+		return err
+	}
+
+	if err := checkCompressOption(&d); err != nil {
+		return err
+	}
+
 	return nil
 }
 
@@ -102,7 +130,7 @@ func PbToChannelDescriptor(pb *chprotobuf.PbChannelDescriptor) *ChannelDescripto
 //   <skeleton-type> is one of: TCP, UNIX, SOCKS, STDIO, or LOOP
 //   <stub-path> and <skeleton-path> are formatted according to respective type:
 //        stub TCP:        <IPV4 bind addr>:<port>                          0.0.0.0:22
-//                         [<IPV6 bind addr>]:<port>                        0.0.0.0:22
+//                         [<IPV6 bind addr>]:<port>                        [::]:22
 //        skeleton TCP:
 //
 // Note that any ":"-delimited descriptor element that contains a ":" may be escaped in the following ways:
@@ -131,40 +159,78 @@ func PbToChannelDescriptor(pb *chprotobuf.PbChannelDescriptor) *ChannelDescripto
 //   192.168.0.1:3000:google.com:80 ->
 //     local  192.168.0.1:3000
 //     remote google.com:80
+//
+// Descriptors may also be given in a URI-style syntax (see descriptor_uri.go), e.g.
+//   tcp://0.0.0.0:8080,unix:///var/run/app.sock
+//
+// A legacy descriptor ending in "/udp" forwards UDP rather than TCP at both endpoints, or an
+// endpoint may be given the "udp" type explicitly. A UDP stub with no skeleton type forwards
+// to a UDP skeleton:
+//   5353:1.1.1.1:53/udp
+//   R:5514:localhost:514/udp
+//
+// A TUN stub with no skeleton attaches to the device of the same name at the other end:
+//   tun:tun0?tun-addr=10.9.0.1/24,tun-peer-addr=10.9.0.2/24
+//
+// A VSOCK endpoint is a context ID and port, e.g. to reach a service on the host from a VM:
+//   8080:vsock:2:5000
+//
+// Any descriptor may be followed by channel options, which apply to both endpoints:
+//   2222:localhost:22?priority=interactive
+//
+// A TCP stub with no bind address listens on 127.0.0.1 only. Listening on all interfaces must
+// be asked for, either with a wildcard bind address or with the bind-any option, which makes
+// the bind address 0.0.0.0, so that the descriptor string always shows which it is:
+//   3000:google.com:80?bind-any=true -> 0.0.0.0:3000:google.com:80
 
 // ParseChannelDescriptor parses a string representing a ChannelDescriptor
 func ParseChannelDescriptor(s string) (*ChannelDescriptor, error) {
 	reverse := false
-	parts, err := SplitBracketedParts(s)
+	base, options, err := SplitDescriptorOptions(s)
 	if err != nil {
 		return nil, err
 	}
-	if len(parts) > 0 && parts[0] == "R" {
-		reverse = true
-		parts = parts[1:]
+	d := &ChannelDescriptor{}
+	udp := false
+	if !IsURIChannelDescriptor(base) && strings.HasSuffix(base, "/udp") {
+		udp = true
+		base = strings.TrimSuffix(base, "/udp")
 	}
-	d := &ChannelDescriptor{
-		Reverse: reverse,
-	}
-
-	var skeletonParts []string
-	d.Stub, skeletonParts, err = ParseNextChannelEndpointDescriptor(parts, ChannelEndpointRoleStub)
-	if err != nil {
-		return nil, err
-	}
-
-	remParts := skeletonParts
-	if len(skeletonParts) > 0 {
-		d.Skeleton, remParts, err = ParseNextChannelEndpointDescriptor(skeletonParts, ChannelEndpointRoleSkeleton)
+	if IsURIChannelDescriptor(base) {
+		d.Reverse, d.Stub, d.Skeleton, err = parseURIChannelEndpoints(base)
 		if err != nil {
 			return nil, err
 		}
 	} else {
-		d.Skeleton = &ChannelEndpointDescriptor{Role: ChannelEndpointRoleSkeleton}
-	}
+		parts, err := SplitBracketedParts(base)
+		if err != nil {
+			return nil, err
+		}
+		if len(parts) > 0 && parts[0] == "R" {
+			reverse = true
+			parts = parts[1:]
+		}
+		d.Reverse = reverse
+
+		var skeletonParts []string
+		d.Stub, skeletonParts, err = ParseNextChannelEndpointDescriptor(parts, ChannelEndpointRoleStub)
+		if err != nil {
+			return nil, err
+		}
 
-	if len(remParts) != 0 {
-		return nil, fmt.Errorf("Too many parts in channel descriptor string: '%s': %s + %s + %v", s, d.Stub, d.Skeleton, remParts)
+		remParts := skeletonParts
+		if len(skeletonParts) > 0 {
+			d.Skeleton, remParts, err = ParseNextChannelEndpointDescriptor(skeletonParts, ChannelEndpointRoleSkeleton)
+			if err != nil {
+				return nil, err
+			}
+		} else {
+			d.Skeleton = &ChannelEndpointDescriptor{Role: ChannelEndpointRoleSkeleton, Type: ChannelEndpointTypeUnknown}
+		}
+
+		if len(remParts) != 0 {
+			return nil, fmt.Errorf("Too many parts in channel descriptor string: '%s': %s + %s + %v", s, d.Stub, d.Skeleton, remParts)
+		}
 	}
 
 	if d.Stub.Type == ChannelEndpointTypeSocks {
@@ -172,8 +238,8 @@ func ParseChannelDescriptor(s string) (*ChannelDescriptor, error) {
 		// Skeleton where it belongs
 		if d.Skeleton.Type == ChannelEndpointTypeUnknown {
 			tmp := d.Stub
-			d.Skeleton = d.Stub
-			d.Stub = tmp
+			d.Stub = d.Skeleton
+			d.Skeleton = tmp
 			d.Stub.Role = ChannelEndpointRoleStub
 			d.Skeleton.Role = ChannelEndpointRoleSkeleton
 		}
@@ -186,8 +252,23 @@ func ParseChannelDescriptor(s string) (*ChannelDescriptor, error) {
 		return nil, fmt.Errorf("SOCKS endpoints are only allowed on the skeleton side: '%s'", s)
 	}
 
+	if udp {
+		for _, ced := range []*ChannelEndpointDescriptor{d.Stub, d.Skeleton} {
+			if ced.Type != ChannelEndpointTypeTCP && ced.Type != ChannelEndpointTypeUnknown {
+				return nil, fmt.Errorf("The /udp suffix only applies to host:port endpoints: '%s'", s)
+			}
+			ced.Type = ChannelEndpointTypeUDP
+		}
+	}
+
 	if d.Skeleton.Type == ChannelEndpointTypeUnknown {
 		d.Skeleton.Type = ChannelEndpointTypeTCP
+		if d.Stub.Type == ChannelEndpointTypeUDP {
+			d.Skeleton.Type = ChannelEndpointTypeUDP
+		} else if d.Stub.Type == ChannelEndpointTypeTUN {
+			d.Skeleton.Type = ChannelEndpointTypeTUN
+			d.Skeleton.Path = d.Stub.Path
+		}
 	}
 
 	stubBindAddr := ""
@@ -195,7 +276,7 @@ func ParseChannelDescriptor(s string) (*ChannelDescriptor, error) {
 	skeletonHost := ""
 	skeletonPort := UnknownPortNumber
 
-	if d.Stub.Type == ChannelEndpointTypeTCP {
+	if d.Stub.Type.isHostPort() {
 		if len(d.Stub.Path) > 0 {
 			stubBindAddr, stubPort, err = ParseHostPort(d.Stub.Path, "", UnknownPortNumber)
 			if err != nil {
@@ -204,7 +285,7 @@ func ParseChannelDescriptor(s string) (*ChannelDescriptor, error) {
 		}
 	}
 
-	if d.Skeleton.Type == ChannelEndpointTypeTCP {
+	if d.Skeleton.Type.isHostPort() {
 		if len(d.Skeleton.Path) > 0 {
 			skeletonHost, skeletonPort, err = ParseHostPort(d.Skeleton.Path, "", UnknownPortNumber)
 			if err != nil {
@@ -213,15 +294,15 @@ func ParseChannelDescriptor(s string) (*ChannelDescriptor, error) {
 		}
 	}
 
-	if d.Stub.Type == ChannelEndpointTypeTCP && stubBindAddr == "" {
-		if d.Skeleton.Type == ChannelEndpointTypeSocks {
-			stubBindAddr = "127.0.0.1"
+	if d.Stub.Type.isHostPort() && stubBindAddr == "" {
+		if bindAnyOption(options) {
+			stubBindAddr = WildcardStubBindAddr
 		} else {
-			stubBindAddr = "0.0.0.0"
+			stubBindAddr = DefaultStubBindAddr
 		}
 	}
 
-	if d.Stub.Type == ChannelEndpointTypeTCP && stubPort == UnknownPortNumber {
+	if d.Stub.Type.isHostPort() && stubPort == UnknownPortNumber {
 		if d.Skeleton.Type == ChannelEndpointTypeSocks {
 			stubPort = PortNumber(1080)
 		} else if skeletonPort != UnknownPortNumber {
@@ -229,13 +310,13 @@ func ParseChannelDescriptor(s string) (*ChannelDescriptor, error) {
 		}
 	}
 
-	if d.Skeleton.Type == ChannelEndpointTypeTCP && skeletonPort == UnknownPortNumber {
+	if d.Skeleton.Type.isHostPort() && skeletonPort == UnknownPortNumber {
 		if stubPort != UnknownPortNumber {
 			skeletonPort = stubPort
 		}
 	}
 
-	if d.Stub.Type == ChannelEndpointTypeTCP {
+	if d.Stub.Type.isHostPort() {
 		if stubBindAddr == "" {
 			return nil, fmt.Errorf("Unable to determine stub bind address in channel descriptor string: '%s'", s)
 		}
@@ -246,7 +327,7 @@ func ParseChannelDescriptor(s string) (*ChannelDescriptor, error) {
 		d.Stub.Path = stubBindAddr + ":" + stubPort.String()
 	}
 
-	if d.Skeleton.Type == ChannelEndpointTypeTCP {
+	if d.Skeleton.Type.isHostPort() {
 		if skeletonHost == "" {
 			skeletonHost = "localhost"
 		}
@@ -256,13 +337,7 @@ func ParseChannelDescriptor(s string) (*ChannelDescriptor, error) {
 		d.Skeleton.Path = skeletonHost + ":" + skeletonPort.String()
 	}
 
-	if (d.Stub.Type == ChannelEndpointTypeStdio && d.Reverse) || (d.Skeleton.Type == ChannelEndpointTypeStdio && !d.Reverse) {
-		return nil, fmt.Errorf("Stdio endpoints are only allowed on the client proxy side: '%s'", s)
-	}
-
-	if d.Skeleton.Type == ChannelEndpointTypeUnknown {
-		return nil, fmt.Errorf("Unable to determine skeleton endpoint type: '%s'", s)
-	}
+	d.SetOptions(options)
 
 	err = d.Validate()
 	if err != nil {
@@ -271,3 +346,21 @@ func ParseChannelDescriptor(s string) (*ChannelDescriptor, error) {
 
 	return d, nil
 }
+
+// SetOptions applies channel options to both endpoints of a ChannelDescriptor. Options that an
+// endpoint already has, e.g. the family of a "tcp6" endpoint, are kept.
+func (d *ChannelDescriptor) SetOptions(options map[string]string) {
+	if len(options) == 0 {
+		return
+	}
+	for _, ep := range []*ChannelEndpointDescriptor{d.Stub, d.Skeleton} {
+		epOptions := make(map[string]string, len(options)+len(ep.Options))
+		for k, v := range options {
+			epOptions[k] = v
+		}
+		for k, v := range ep.Options {
+			epOptions[k] = v
+		}
+		ep.Options = epOptions
+	}
+}
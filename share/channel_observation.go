@@ -0,0 +1,317 @@
+package chshare
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// ObservableChannelsRequestType is the SSH request type used by a client to ask the server
+// which channels its user may observe. A successful reply carries a JSON array of
+// ObservableChannel.
+const ObservableChannelsRequestType = "observable-channels"
+
+// observerQueueLength is the number of reads from an observed channel that may wait to be
+// sent to an observer. An observer that falls further behind is detached, rather than
+// slowing the observed channel.
+const observerQueueLength = 256
+
+// ObservableChannel describes a channel on the server that may be observed
+type ObservableChannel struct {
+	ID        int64     `json:"id"`
+	User      string    `json:"user,omitempty"`
+	SessionID int32     `json:"session_id"`
+	Endpoint  string    `json:"endpoint"`
+	Started   time.Time `json:"started"`
+	Observers int       `json:"observers"`
+}
+
+// ParseObservedChannelID parses the path of an observe endpoint, which is a channel ID
+func ParseObservedChannelID(path string) (int64, error) {
+	id, err := strconv.ParseInt(path, 10, 64)
+	if err != nil || id <= 0 {
+		return 0, fmt.Errorf("Invalid observed channel ID '%s'", path)
+	}
+	return id, nil
+}
+
+// ObservationHub lets authorized users attach read-only to the live traffic of the server's
+// channels, e.g. so that a second engineer can watch a supervised remote access session.
+// Every channel is registered with the hub while it is open, by its tap ID. Observers
+// receive the data the channel's Called Service sends to its Caller, i.e. what the Caller
+// sees, and cannot send anything into the channel. Each observation is logged, for audit.
+type ObservationHub struct {
+	logger Logger
+
+	// lock protects channels, the observers of each channel, and sending to and closing
+	// observer queues
+	lock     sync.Mutex
+	channels map[int64]*observedChannel
+
+	observersStat    *Stat
+	observationsStat *Stat
+}
+
+// observedChannel is a channel registered with an ObservationHub. It is the tap writer of
+// the data from the channel's Called Service to its Caller.
+type observedChannel struct {
+	hub       *ObservationHub
+	info      ObservableChannel
+	tenant    string
+	observers map[*channelObserver]struct{}
+}
+
+// channelObserver is an observer attached to an observedChannel
+type channelObserver struct {
+	user      string
+	sessionID int32
+	queue     chan []byte
+	bytes     int64
+}
+
+// NewObservationHub creates a new ObservationHub
+func NewObservationHub(logger Logger, stats *StatsRegistry) *ObservationHub {
+	return &ObservationHub{
+		logger:   logger.Fork("observe"),
+		channels: make(map[int64]*observedChannel),
+		observersStat: stats.Gauge(
+			"chisel_channel_observers",
+			"Number of observers currently attached to channels",
+			nil),
+		observationsStat: stats.Counter(
+			"chisel_channel_observations_total",
+			"Number of times an observer attached to a channel",
+			nil),
+	}
+}
+
+// sessionTap returns a ChannelTap that registers the channels of a session with the hub.
+// Channels to observe endpoints are not registered, so that observations cannot be chained.
+// A nil ObservationHub returns a nil ChannelTap.
+func (h *ObservationHub) sessionTap(tenant string, sessionID int32) ChannelTap {
+	if h == nil {
+		return nil
+	}
+	return func(info *ChannelTapInfo) (io.Writer, io.Writer) {
+		if info.Endpoint.Type == ChannelEndpointTypeObserve {
+			return nil, nil
+		}
+		oc := &observedChannel{
+			hub: h,
+			info: ObservableChannel{
+				ID:        info.ID,
+				User:      info.User,
+				SessionID: sessionID,
+				Endpoint:  info.Endpoint.String(),
+				Started:   info.Started,
+			},
+			tenant:    tenant,
+			observers: make(map[*channelObserver]struct{}),
+		}
+		h.lock.Lock()
+		h.channels[info.ID] = oc
+		h.lock.Unlock()
+		return nil, oc
+	}
+}
+
+// Write copies data from the channel's Called Service to each observer, detaching those
+// that have fallen behind
+func (oc *observedChannel) Write(p []byte) (int, error) {
+	h := oc.hub
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	if len(oc.observers) == 0 {
+		return len(p), nil
+	}
+	data := append([]byte(nil), p...)
+	for obs := range oc.observers {
+		select {
+		case obs.queue <- data:
+			obs.bytes += int64(len(data))
+		default:
+			oc.detachLocked(obs, "fell behind")
+		}
+	}
+	return len(p), nil
+}
+
+// Close unregisters the channel when it ends, and detaches its observers
+func (oc *observedChannel) Close() error {
+	h := oc.hub
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	delete(h.channels, oc.info.ID)
+	for obs := range oc.observers {
+		oc.detachLocked(obs, "channel closed")
+	}
+	return nil
+}
+
+// detach detaches an observer, if it is still attached
+func (oc *observedChannel) detach(obs *channelObserver, reason string) {
+	oc.hub.lock.Lock()
+	defer oc.hub.lock.Unlock()
+	oc.detachLocked(obs, reason)
+}
+
+// detachLocked detaches an observer, if it is still attached. The hub's lock must be held.
+func (oc *observedChannel) detachLocked(obs *channelObserver, reason string) {
+	if _, ok := oc.observers[obs]; !ok {
+		return
+	}
+	delete(oc.observers, obs)
+	close(obs.queue)
+	oc.hub.observersStat.Dec()
+	oc.hub.logger.ILogf("User \"%s\" (session #%d) stopped observing channel %d after %d bytes: %s",
+		obs.user, obs.sessionID, oc.info.ID, obs.bytes, reason)
+}
+
+// ChannelObserver is a session's permission to observe the channels of an ObservationHub,
+// on behalf of its user
+type ChannelObserver struct {
+	hub       *ObservationHub
+	tenant    string
+	user      string
+	sessionID int32
+}
+
+// List returns the channels the observer may observe, which are those of its tenant, by ID
+func (o *ChannelObserver) List() []*ObservableChannel {
+	h := o.hub
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	var list []*ObservableChannel
+	for _, oc := range h.channels {
+		if oc.tenant != o.tenant {
+			continue
+		}
+		info := oc.info
+		info.Observers = len(oc.observers)
+		list = append(list, &info)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+	return list
+}
+
+// Attach attaches the observer to a channel, and returns a ChannelConn from which the
+// channel's data is read. Data written to the ChannelConn is discarded.
+func (o *ChannelObserver) Attach(logger Logger, channelID int64) (ChannelConn, error) {
+	h := o.hub
+	obs := &channelObserver{
+		user:      o.user,
+		sessionID: o.sessionID,
+		queue:     make(chan []byte, observerQueueLength),
+	}
+	h.lock.Lock()
+	oc, ok := h.channels[channelID]
+	if !ok || oc.tenant != o.tenant {
+		h.lock.Unlock()
+		return nil, fmt.Errorf("No observable channel %d", channelID)
+	}
+	oc.observers[obs] = struct{}{}
+	h.observersStat.Inc()
+	h.observationsStat.Inc()
+	h.logger.ILogf("User \"%s\" (session #%d) started observing channel %d (%s of user \"%s\", session #%d)",
+		o.user, o.sessionID, channelID, oc.info.Endpoint, oc.info.User, oc.info.SessionID)
+	h.lock.Unlock()
+
+	pr, pw := io.Pipe()
+	go func() {
+		for data := range obs.queue {
+			if _, err := pw.Write(data); err != nil {
+				break
+			}
+		}
+		pw.Close()
+	}()
+	input := &observerReader{PipeReader: pr, detach: func() { oc.detach(obs, "observer left") }}
+	return NewPipeConn(logger, input, nopWriteCloser{ioutil.Discard})
+}
+
+// observerReader is the reading side of an observer's connection. Closing it detaches the
+// observer.
+type observerReader struct {
+	*io.PipeReader
+	detach    func()
+	closeOnce sync.Once
+}
+
+func (r *observerReader) Close() error {
+	r.closeOnce.Do(r.detach)
+	return r.PipeReader.Close()
+}
+
+// nopWriteCloser is an io.WriteCloser whose Close does nothing
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
+
+// GetChannelObserver returns the session's permission to observe channels, or nil if the
+// server does not allow observation or the session's user may not observe. The user is
+// looked up again so that revoking the permission takes effect immediately.
+func (s *ServerSSHSession) GetChannelObserver() *ChannelObserver {
+	if s.server.observations == nil || s.user == nil {
+		return nil
+	}
+	user, ok := s.users.Get(s.user.Name)
+	if !ok || !user.Observe {
+		return nil
+	}
+	return &ChannelObserver{
+		hub:       s.server.observations,
+		tenant:    s.tenant,
+		user:      user.Name,
+		sessionID: s.ID(),
+	}
+}
+
+// handleObservableChannelsRequest answers an ObservableChannelsRequestType SSH request with
+// the channels the session's user may observe
+func (s *ServerSSHSession) handleObservableChannelsRequest(ctx context.Context, r *ssh.Request) error {
+	observer := s.GetChannelObserver()
+	if observer == nil {
+		err := s.DLogErrorf("Channel observation is not permitted")
+		s.sendSSHErrorReply(ctx, r, err)
+		return err
+	}
+	reply, err := json.Marshal(observer.List())
+	if err != nil {
+		s.sendSSHErrorReply(ctx, r, err)
+		return err
+	}
+	return s.sendSSHReply(ctx, r, true, reply)
+}
+
+// ObservableChannels asks the server for the channels this client's user may observe with
+// an observe:<channel-id> remote
+func (c *Client) ObservableChannels() ([]*ObservableChannel, error) {
+	sshConn, err := c.GetSSHConn()
+	if err != nil {
+		return nil, err
+	}
+	ok, reply, err := sshConn.SendRequest(ObservableChannelsRequestType, true, nil)
+	if err != nil {
+		return nil, fmt.Errorf("Observable channels request failed: %s", err)
+	}
+	if !ok {
+		return nil, fmt.Errorf("Server refused observable channels request: %s", string(reply))
+	}
+	var list []*ObservableChannel
+	if err := json.Unmarshal(reply, &list); err != nil {
+		return nil, fmt.Errorf("Invalid observable channels reply: %s", err)
+	}
+	return list, nil
+}
@@ -0,0 +1,156 @@
+package chshare
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// ChannelPolicy is a reloadable policy expression (see PolicyExpr), evaluated each time a
+// client session opens a channel. The channel is refused unless the expression is true.
+type ChannelPolicy struct {
+	Logger
+	file string
+
+	// lock protects expr and onReload
+	lock     sync.RWMutex
+	expr     *PolicyExpr
+	onReload func()
+}
+
+// LoadChannelPolicy loads a channel policy from a file, which is reloaded when it changes
+func LoadChannelPolicy(logger Logger, file string) (*ChannelPolicy, error) {
+	p := &ChannelPolicy{
+		Logger: logger.Fork("channel-policy"),
+		file:   file,
+	}
+	if err := p.load(); err != nil {
+		return nil, err
+	}
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	if err := watcher.Add(filepath.Dir(file)); err != nil {
+		return nil, err
+	}
+	go func() {
+		for e := range watcher.Events {
+			if e.Name != file || e.Op&fsnotify.Write != fsnotify.Write {
+				continue
+			}
+			if err := p.load(); err != nil {
+				p.ILogf("Failed to reload, keeping the previous policy: %s", err)
+				continue
+			}
+			p.ILogf("Reloaded from: %s", file)
+			p.lock.RLock()
+			onReload := p.onReload
+			p.lock.RUnlock()
+			if onReload != nil {
+				onReload()
+			}
+		}
+	}()
+	return p, nil
+}
+
+// OnReload sets a function to be called after the policy is reloaded
+func (p *ChannelPolicy) OnReload(f func()) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.onReload = f
+}
+
+// load compiles the policy file, replacing the current policy if it compiles
+func (p *ChannelPolicy) load() error {
+	b, err := ioutil.ReadFile(p.file)
+	if err != nil {
+		return fmt.Errorf("Failed to read channel policy file: %s", err)
+	}
+	expr, err := CompilePolicyExpr(string(b))
+	if err != nil {
+		return fmt.Errorf("Invalid channel policy in %s: %s", p.file, err)
+	}
+	p.lock.Lock()
+	p.expr = expr
+	p.lock.Unlock()
+	return nil
+}
+
+// Allow evaluates the policy with the given values. A policy that fails to evaluate, e.g.
+// because it compares values of different types, refuses the channel.
+func (p *ChannelPolicy) Allow(env map[string]interface{}) (bool, error) {
+	p.lock.RLock()
+	expr := p.expr
+	p.lock.RUnlock()
+	return expr.EvalBool(env)
+}
+
+// channelPolicyEnv returns the values that a channel policy is evaluated with, for a channel
+// opened at a given time by the session to its endpoint ced on the server: the stub of a
+// reverse channel, or the skeleton of a forward one
+func (s *ServerSSHSession) channelPolicyEnv(ced *ChannelEndpointDescriptor, now time.Time) map[string]interface{} {
+	env := map[string]interface{}{
+		"user":       "",
+		"labels":     map[string]string{},
+		"tenant":     s.tenant,
+		"addr":       "",
+		"reverse":    false,
+		"descriptor": "",
+		"stub":       "",
+		"skeleton":   ced.String(),
+		"hour":       float64(now.Hour()),
+		"minute":     float64(now.Minute()),
+		"weekday":    now.Weekday().String()[:3],
+	}
+	if s.user != nil {
+		env["user"] = s.user.Name
+		if user, ok := s.users.Get(s.user.Name); ok && user.Labels != nil {
+			env["labels"] = user.Labels
+		}
+	}
+	s.channelsLock.Lock()
+	defer s.channelsLock.Unlock()
+	env["session"] = s.sessionName
+	env["device"] = s.deviceFingerprint
+	if host, _, err := net.SplitHostPort(s.remoteAddr); err == nil {
+		env["addr"] = host
+	}
+	if key, chd := s.descriptorForEndpointLocked(ced); chd != nil {
+		env["reverse"] = chd.Reverse
+		env["descriptor"] = key
+		env["stub"] = chd.Stub.String()
+		env["skeleton"] = chd.Skeleton.String()
+	}
+	return env
+}
+
+// CheckChannelPolicy refuses a new channel with a given endpoint on the server if the
+// server's channel policy does not allow it
+func (s *ServerSSHSession) CheckChannelPolicy(ctx context.Context, ced *ChannelEndpointDescriptor) error {
+	policy := s.server.channelPolicy
+	if policy == nil {
+		return nil
+	}
+	// the environment, including the time to the minute, is the key of the cached decision
+	env := s.channelPolicyEnv(ced, s.server.clock.Now())
+	allowed, err := s.server.decisions.Decide(decisionKindPolicy, fmt.Sprint(env), func() (bool, error) {
+		return policy.Allow(env)
+	})
+	if err != nil {
+		s.server.channelPolicyDenialsStat.Inc()
+		return s.ILogErrorf("%sChannel to %s refused; channel policy failed: %s", requestLogPrefix(ctx), ced, err)
+	}
+	if !allowed {
+		s.server.channelPolicyDenialsStat.Inc()
+		return s.DLogErrorf("%sChannel to %s refused by channel policy", requestLogPrefix(ctx), ced)
+	}
+	return nil
+}
@@ -2,7 +2,9 @@ package chshare
 
 import (
 	"context"
+	"crypto/tls"
 	"encoding/json"
+	"errors"
 	"fmt"
 	socks5 "github.com/armon/go-socks5"
 	"github.com/gorilla/websocket"
@@ -13,6 +15,7 @@ import (
 	"net/url"
 	"regexp"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -29,15 +32,137 @@ type Config struct {
 	HTTPProxy        string
 	ChdStrings       []string
 	HostHeader       string
+	RemotesFile      string
+	ControlAddr      string
+	ControlToken     string
+	DialAllow        string
+	DialSource       string
+	UnixSocketDirs   string
+	ExecCommands     []string
+	PAC              string
+	TUN              bool
+	DialProxy        string
+	E2EKeySeed       string
+
+	// ResolveForServer, if true, lets the server ask the client to resolve host names for its
+	// SOCKS5 proxy (its --socks5-resolver client). Otherwise such requests are refused, so that
+	// a server cannot use the client to look up names on the client's network.
+	ResolveForServer bool
+
+	// ChannelTap, if not nil, is offered a copy of the traffic of every channel
+	ChannelTap ChannelTap
+
+	// RecordingSink, if not nil, receives recordings of channels with the "record" option
+	RecordingSink RecordingSink
+
+	// Transport selects how the client connects to the server; defaults to TransportAuto
+	Transport string
+
+	// TLSCert and TLSKey, if not "", are PEM files holding a TLS client certificate and its
+	// private key, presented to the server. Without Auth, the client logs in as the user
+	// named by the certificate.
+	TLSCert string
+	TLSKey  string
+
+	// StatsInterval, if not 0, is how often to log the statistics of each remote
+	StatsInterval time.Duration
+
+	// Headers are extra HTTP headers, each "<name>: <value>", sent with the requests that
+	// connect to the server, e.g. to present a browser's User-Agent
+	Headers []string
+
+	// UpgradeKey, if not "", is the secret shared with a server with an upgrade key, with
+	// which the client signs a one-time token for each request that opens a transport
+	UpgradeKey string
+
+	// SessionName, if not "", names the client's session to the server, e.g. with a device
+	// serial number, so that the server can refuse or replace duplicate sessions
+	SessionName string
+
+	// ValuesFile, if not "", is a YAML file of values for the variables in remote strings,
+	// which are otherwise taken from the environment (see RemoteValues)
+	ValuesFile string
+
+	// StatsUpdateInterval, if not 0, is how often to send the traffic of each remote to the
+	// server
+	StatsUpdateInterval time.Duration
+
+	// AdaptiveKeepAlive, if true, starts the keepalive interval at KeepAlive, or at
+	// DefaultAdaptiveKeepAlive, and tunes it to the idle timeout of the link to the server
+	// (see AdaptiveKeepAlive)
+	AdaptiveKeepAlive bool
+
+	// Reconnect, if true, makes the client reconnect to the server after the connection is
+	// lost, rather than exiting, keeping its stub endpoints listening in the meantime
+	Reconnect bool
+
+	// ReconnectQueue, if not 0, is how long connections to local stubs may wait for the
+	// client to reconnect to the server, after which they are refused. Connections whose
+	// channels fail to open because the connection to the server was just lost wait for the
+	// next one too.
+	ReconnectQueue time.Duration
+
+	// WebSocketBatchDelay, if not 0, is how long small writes to a websocket transport may
+	// wait to be coalesced into fewer messages
+	WebSocketBatchDelay time.Duration
+
+	// WebSocketMaxMessage, if not 0, is the largest websocket message to send, in bytes
+	WebSocketMaxMessage int64
+
+	// DeviceKeyFile, if not "", is a PEM file holding the client's device key, which is
+	// generated there on first use. Its fingerprint identifies the client's device to the
+	// server (see LoadDeviceKey).
+	DeviceKeyFile string
+
+	// RekeyThreshold, if not 0, is the number of bytes sent or received on the SSH session
+	// after which its keys are renegotiated; 0 leaves it to the SSH library's default for the
+	// negotiated cipher
+	RekeyThreshold int64
+
+	// Takeover, if true, has the server hand the listeners of the client's reverse remotes
+	// over from another session of the same user rather than listen anew, so that a client
+	// can be replaced without the ports being released
+	Takeover bool
+
+	// AllowPartial, if true, has the server start the session even if some of the client's
+	// reverse remotes cannot be added
+	AllowPartial bool
+
+	// Clock, if not nil, replaces SystemClock as the source of time for reconnection,
+	// keepalives, timeouts and held channels, for tests
+	Clock Clock
+
+	// Faults, if not nil, injects faults into the client's connections to the server, for
+	// tests
+	Faults FaultInjector
+
+	// LoopServer, if not nil, is used for the client's local loop endpoints in place of a
+	// LoopServer of its own, so that loop names are shared with a server in the same process
+	// (see "chisel relay")
+	LoopServer *LoopServer
 }
 
+const (
+	// TransportAuto connects with a websocket, and falls back to HTTP long-polling if the
+	// websocket upgrade fails. Once the fallback has succeeded, the client keeps using it.
+	TransportAuto = "auto"
+
+	// TransportWebSocket connects with a websocket only
+	TransportWebSocket = "websocket"
+
+	// TransportPoll connects with HTTP long-polling only
+	TransportPoll = "poll"
+
+	// TransportH2 connects with the HTTP/2 stream transport only, for servers behind
+	// ingresses that only speak HTTP/2
+	TransportH2 = "h2"
+)
+
 //Client represents a client instance
 type Client struct {
 	ShutdownHelper
 	config       *Config
 	sshConfig    *ssh.ClientConfig
-	sshConn      ssh.Conn
-	sshConnReady chan struct{}
 	sshConnErr   error
 	httpProxyURL *url.URL
 	server       string
@@ -46,6 +171,110 @@ type Client struct {
 	connStats    ConnStats
 	socksServer  *socks5.Server
 	loopServer   *LoopServer
+	stats        *StatsRegistry
+	scheduler    *WriteScheduler
+	remotesFile  *RemotesFile
+	remoteValues *RemoteValues
+	control      *ControlServer
+	dialAllow    *DialAllowlist
+	dialSource   *DialSource
+	dialProxy    *DialProxy
+	e2eKey       *E2EKey
+	channelTap   ChannelTap
+	clock        Clock
+	faults       FaultInjector
+
+	// keepAlive tunes the keepalive interval, or is nil for a fixed interval
+	keepAlive *AdaptiveKeepAlive
+
+	// unixSocketDirs restricts the sockets unix skeletons may connect to, or is nil
+	unixSocketDirs *UnixSocketDirs
+
+	// execCommands are the commands exec skeletons may run, or nil
+	execCommands *ExecCommands
+
+	// pacRules are the destinations the "pac" control command routes through the client's
+	// SOCKS remotes, or nil
+	pacRules *PACRules
+
+	// deviceKey signs each session to prove the client's device identity, or is nil
+	deviceKey ssh.Signer
+
+	// headers are the extra HTTP headers sent with the requests that connect to the server
+	headers http.Header
+
+	// upgradeTokens issues the upgrade token sent with each request that opens a transport,
+	// or is nil if the client has no upgrade key
+	upgradeTokens *UpgradeTokens
+
+	// usePoll is true if the client connects with the long-poll transport
+	usePoll bool
+
+	// tlsConfig configures TLS connections to the server with a client certificate, or is
+	// nil to use the defaults
+	tlsConfig *tls.Config
+
+	// sshConnLock protects sshConn, sshConnReady and sshConnDraining
+	sshConnLock sync.Mutex
+
+	// sshConn is the current SSH connection to the server, or nil if not connected
+	sshConn ssh.Conn
+
+	// sshConnReady is closed when sshConn becomes available. It is replaced with
+	// a new chan each time the connection is lost or starts draining.
+	sshConnReady chan struct{}
+
+	// sshConnDraining is true if the server has asked the client to reconnect. New channels
+	// wait for the new connection while the existing ones finish on sshConn.
+	sshConnDraining bool
+
+	// reconnectNow is signalled to request an immediate reconnect to the server
+	reconnectNow chan struct{}
+
+	// remotesLock protects dynamicRemotes, dynamicRemoteKeys, boundAddrs, acceptedRemotes and
+	// nextProxyIndex, and serializes sending of channel updates with establishment of the SSH
+	// connection
+	remotesLock sync.Mutex
+
+	// dynamicRemotes holds remotes added at runtime (from the remotes file or control
+	// socket), by descriptor string
+	dynamicRemotes map[string]*clientRemote
+
+	// portRanges holds the port range remotes given on the command line, whose ports are
+	// added to dynamicRemotes as they are activated
+	portRanges []*PortRangeRemote
+
+	// dynamicRemoteKeys holds the keys of dynamicRemotes in the order they were added
+	dynamicRemoteKeys []string
+
+	// boundAddrs holds the address the server actually listens on for each reverse remote, by
+	// descriptor string, as reported by the server when the remote was configured
+	boundAddrs map[string]string
+
+	// acceptedRemotes holds the descriptor strings of the reverse remotes that the server has
+	// accepted in the current session
+	acceptedRemotes map[string]bool
+
+	// nextProxyIndex is the index assigned to the next local stub listener
+	nextProxyIndex int
+
+	// traffic holds the traffic statistics of each remote that has had connections, by
+	// descriptor string
+	traffic *trafficCounter
+
+	// reconnectTokenLock protects reconnectToken, authUsedToken and forceReauth
+	reconnectTokenLock sync.Mutex
+
+	// reconnectToken is the latest reconnection token issued by the server, or "" if none
+	reconnectToken string
+
+	// authUsedToken is true if the last authentication attempt presented reconnectToken
+	// instead of the configured password
+	authUsedToken bool
+
+	// forceReauth is true if the server has asked the client to reconnect, and the next
+	// authentication must use the configured password
+	forceReauth bool
 }
 
 //NewClient creates a new client instance
@@ -79,17 +308,51 @@ func NewClient(config *Config) (*Client, error) {
 	//swap to websockets scheme
 	u.Scheme = strings.Replace(u.Scheme, "http", "ws", 1)
 	shared := &SessionConfigRequest{}
+	remoteValues, err := LoadRemoteValues(config.ValuesFile)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", logger.Prefix(), err)
+	}
+	var portRanges []*PortRangeRemote
 	for _, s := range config.ChdStrings {
-		chd, err := ParseChannelDescriptor(s)
+		expanded, err := remoteValues.Expand(s)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", logger.Prefix(), err)
+		}
+		if IsPortRangeRemote(expanded) {
+			r, err := ParsePortRangeRemote(expanded)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %s", logger.Prefix(), err)
+			}
+			portRanges = append(portRanges, r)
+			continue
+		}
+		chd, err := remoteValues.ParseRemote(s)
 		if err != nil {
-			return nil, fmt.Errorf("%s: Failed to parse channel descriptor string '%s': %s", logger.Prefix(), s, err)
+			return nil, fmt.Errorf("%s: %s", logger.Prefix(), err)
 		}
 		shared.ChannelDescriptors = append(shared.ChannelDescriptors, chd)
 	}
+	if err := ValidateSessionName(config.SessionName); err != nil {
+		return nil, fmt.Errorf("%s: %s", logger.Prefix(), err)
+	}
+	if config.ControlAddr != "" {
+		if _, _, err := parseControlServerAddr(config.ControlAddr, config.ControlToken); err != nil {
+			return nil, fmt.Errorf("%s: %s", logger.Prefix(), err)
+		}
+	}
+	shared.SessionName = config.SessionName
 	config.shared = shared
-	loopServer, err := NewLoopServer(logger)
-	if err != nil {
-		return nil, fmt.Errorf("%s: Failed to start loop server", logger.Prefix())
+	stats := NewStatsRegistry()
+	loopServer := config.LoopServer
+	if loopServer == nil {
+		loopServer, err = NewLoopServer(logger)
+		if err != nil {
+			return nil, fmt.Errorf("%s: Failed to start loop server", logger.Prefix())
+		}
+	}
+	clock := config.Clock
+	if clock == nil {
+		clock = SystemClock
 	}
 	client := &Client{
 		config:       config,
@@ -97,7 +360,118 @@ func NewClient(config *Config) (*Client, error) {
 		server:       u.String(),
 		//running:      true,
 		//runningc:     make(chan error, 1),
-		loopServer: loopServer,
+		loopServer:      loopServer,
+		remoteValues:    remoteValues,
+		portRanges:      portRanges,
+		stats:           stats,
+		scheduler:       NewWriteScheduler(stats, clock),
+		clock:           clock,
+		reconnectNow:    make(chan struct{}, 1),
+		dynamicRemotes:  make(map[string]*clientRemote),
+		boundAddrs:      make(map[string]string),
+		acceptedRemotes: make(map[string]bool),
+		traffic:         newTrafficCounter(),
+		nextProxyIndex:  len(shared.ChannelDescriptors),
+	}
+	if config.RemotesFile != "" {
+		client.remotesFile = NewRemotesFile(logger, config.RemotesFile)
+		chdStrings, err := client.remotesFile.Load()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", logger.Prefix(), err)
+		}
+		chds, err := client.parseDynamicRemotes(chdStrings)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", logger.Prefix(), err)
+		}
+		for _, chd := range chds {
+			if !client.isStaticRemote(chd.String()) {
+				client.addDynamicRemote(chd, RemoteSourceFile, nil)
+			}
+		}
+	}
+	client.headers, err = parseClientHeaders(config.Headers)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", logger.Prefix(), err)
+	}
+	if config.UpgradeKey != "" {
+		client.upgradeTokens = NewUpgradeTokens(config.UpgradeKey, DefaultUpgradeTokenSkew, client.clock)
+	}
+	if config.DialAllow != "" {
+		client.dialAllow, err = ParseDialAllowlist(config.DialAllow)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", logger.Prefix(), err)
+		}
+	}
+	if err := checkMaxMessage(config.WebSocketMaxMessage); err != nil {
+		return nil, fmt.Errorf("%s: %s", logger.Prefix(), err)
+	}
+	if config.UnixSocketDirs != "" {
+		client.unixSocketDirs, err = ParseUnixSocketDirs(config.UnixSocketDirs)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", logger.Prefix(), err)
+		}
+	}
+	if len(config.ExecCommands) > 0 {
+		client.execCommands, err = ParseExecCommands(config.ExecCommands)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", logger.Prefix(), err)
+		}
+	}
+	if config.PAC != "" {
+		client.pacRules, err = ParsePACRules(config.PAC)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", logger.Prefix(), err)
+		}
+	}
+	if config.DialSource != "" {
+		client.dialSource, err = ParseDialSource(config.DialSource)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", logger.Prefix(), err)
+		}
+	}
+	if config.DialProxy != "" {
+		client.dialProxy, err = ParseDialProxy(config.DialProxy)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", logger.Prefix(), err)
+		}
+		logger.ILogf("Reverse remotes will connect to their targets through %s", client.dialProxy)
+	}
+	if config.E2EKeySeed != "" {
+		client.e2eKey = NewE2EKeyFromSeed(config.E2EKeySeed)
+		logger.ILogf("End-to-end public key %s", client.e2eKey.PublicKeyString())
+	}
+	if config.DeviceKeyFile != "" {
+		var created bool
+		client.deviceKey, created, err = LoadDeviceKey(config.DeviceKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", logger.Prefix(), err)
+		}
+		if created {
+			logger.ILogf("Generated device key %s", config.DeviceKeyFile)
+		}
+		logger.ILogf("Device fingerprint %s", FingerprintKey(client.deviceKey.PublicKey()))
+	}
+	switch config.Transport {
+	case "", TransportAuto, TransportWebSocket:
+	case TransportPoll:
+		client.usePoll = true
+	case TransportH2:
+		if config.HTTPProxy != "" {
+			return nil, fmt.Errorf("%s: The h2 transport cannot be used with --proxy", logger.Prefix())
+		}
+	default:
+		return nil, fmt.Errorf("%s: Unknown transport '%s'; must be auto, websocket, poll or h2", logger.Prefix(), config.Transport)
+	}
+	client.channelTap = config.ChannelTap
+	client.faults = config.Faults
+	if client.faults == nil {
+		client.faults = NoFaults{}
+	}
+	if config.AdaptiveKeepAlive {
+		client.keepAlive = NewAdaptiveKeepAlive(logger, stats, client.clock, config.KeepAlive)
+	}
+	if config.RecordingSink != nil {
+		client.channelTap = CombineChannelTaps(NewRecordingTap(logger, client.clock, config.RecordingSink), client.channelTap)
 	}
 	client.InitShutdownHelper(logger, client)
 	client.PanicOnError(client.PauseShutdown())
@@ -112,13 +486,31 @@ func NewClient(config *Config) (*Client, error) {
 
 	user, pass := ParseAuth(config.Auth)
 
+	if config.TLSCert != "" || config.TLSKey != "" {
+		if config.TLSCert == "" || config.TLSKey == "" {
+			return nil, fmt.Errorf("%s: A TLS client certificate and key must be given together", logger.Prefix())
+		}
+		cert, certName, err := LoadClientCertificate(config.TLSCert, config.TLSKey)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", logger.Prefix(), err)
+		}
+		client.tlsConfig = &tls.Config{Certificates: []tls.Certificate{*cert}}
+		if config.Auth == "" {
+			user = certName
+		}
+	}
+
+	authMethod := ssh.PasswordCallback(func() (string, error) {
+		return client.authPassword(pass), nil
+	})
 	client.sshConfig = &ssh.ClientConfig{
 		User:            user,
-		Auth:            []ssh.AuthMethod{ssh.Password(pass)},
+		Auth:            []ssh.AuthMethod{authMethod},
 		ClientVersion:   "SSH-" + ProtocolVersion + "-client",
 		HostKeyCallback: client.verifyServer,
 		Timeout:         30 * time.Second,
 	}
+	client.sshConfig.RekeyThreshold = uint64(config.RekeyThreshold)
 
 	return client, nil
 }
@@ -130,14 +522,102 @@ func (c *Client) IsServer() bool {
 	return false
 }
 
+// IsTUNAllowed returns true if the server's reverse remotes may attach to TUN devices on the
+// client (see --tun)
+func (c *Client) IsTUNAllowed() bool {
+	return c.config.TUN
+}
+
 // GetSSHConn waits for and returns the main ssh.Conn that this proxy is using to
 // communicate with the remote proxy. It is possible that goroutines servicing
 // local stub sockets will ask for this before it is available (if for example
 // a listener on the client accepts a connection before the server has ackknowledged
-// configuration.
+// configuration, or while the client is reconnecting). An error response indicates
+// that the client is shutting down.
 func (c *Client) GetSSHConn() (ssh.Conn, error) {
-	<-c.sshConnReady
-	return c.sshConn, c.sshConnErr
+	for {
+		c.sshConnLock.Lock()
+		sshConn := c.sshConn
+		sshConnReady := c.sshConnReady
+		if c.sshConnDraining {
+			sshConn = nil
+		}
+		c.sshConnLock.Unlock()
+		if sshConn != nil {
+			return sshConn, nil
+		}
+		select {
+		case <-sshConnReady:
+		case <-c.ShutdownStartedChan():
+			if c.sshConnErr != nil {
+				return nil, c.sshConnErr
+			}
+			return nil, c.Errorf("Client is shutting down")
+		}
+	}
+}
+
+// getConnectedSSHConn returns the current ssh.Conn without waiting, or nil if the client is
+// not currently connected
+func (c *Client) getConnectedSSHConn() ssh.Conn {
+	c.sshConnLock.Lock()
+	defer c.sshConnLock.Unlock()
+	return c.sshConn
+}
+
+// setSSHConn makes a newly established ssh.Conn available, and wakes up anyone waiting for it
+func (c *Client) setSSHConn(sshConn ssh.Conn) {
+	c.sshConnLock.Lock()
+	defer c.sshConnLock.Unlock()
+	c.sshConn = sshConn
+	close(c.sshConnReady)
+}
+
+// clearSSHConn forgets a lost ssh.Conn. Subsequent calls to GetSSHConn will wait until the client
+// has reconnected.
+func (c *Client) clearSSHConn() {
+	c.sshConnLock.Lock()
+	defer c.sshConnLock.Unlock()
+	c.sshConn = nil
+	if !c.sshConnDraining {
+		// otherwise there is already a fresh chan for the next connection
+		c.sshConnReady = make(chan struct{})
+	}
+	c.sshConnDraining = false
+}
+
+// drainSSHConn handles a request from the server to reconnect. New channels wait for the
+// next connection, which authenticates with the configured password, and the server closes
+// the current connection once its channels have finished.
+func (c *Client) drainSSHConn() {
+	c.ILogf("Server asked the client to reconnect; draining channels")
+	c.sshConnLock.Lock()
+	if c.sshConn != nil && !c.sshConnDraining {
+		c.sshConnDraining = true
+		c.sshConnReady = make(chan struct{})
+	}
+	c.sshConnLock.Unlock()
+	c.reconnectTokenLock.Lock()
+	c.reconnectToken = ""
+	c.forceReauth = true
+	c.reconnectTokenLock.Unlock()
+	select {
+	case c.reconnectNow <- struct{}{}:
+	default:
+	}
+}
+
+// Reconnect drops the current connection to the server, if any, and reconnects immediately
+// without waiting for the retry interval
+func (c *Client) Reconnect() {
+	select {
+	case c.reconnectNow <- struct{}{}:
+	default:
+	}
+	if sshConn := c.getConnectedSSHConn(); sshConn != nil {
+		c.ILogf("Reconnect requested; closing connection to server")
+		sshConn.Close()
+	}
 }
 
 // GetLoopServer returns the shared LoopServer if loop protocol is enabled; nil otherwise
@@ -151,6 +631,107 @@ func (c *Client) GetSocksServer() *socks5.Server {
 	return c.socksServer
 }
 
+// GetStatsRegistry returns the client's StatsRegistry
+func (c *Client) GetStatsRegistry() *StatsRegistry {
+	return c.stats
+}
+
+// GetWriteScheduler returns the client's WriteScheduler
+func (c *Client) GetWriteScheduler() *WriteScheduler {
+	return c.scheduler
+}
+
+// CheckChannelAdmission always admits new channels on the client
+func (c *Client) CheckChannelAdmission() error {
+	return nil
+}
+
+// CheckChannelPolicy always allows new channels; the client has no channel policy
+func (c *Client) CheckChannelPolicy(ctx context.Context, ced *ChannelEndpointDescriptor) error {
+	return nil
+}
+
+// GetDialAllowlist returns the client's --dial-allow list, or nil if reverse channels
+// may dial any target
+func (c *Client) GetDialAllowlist() *DialAllowlist {
+	return c.dialAllow
+}
+
+// GetDialSource returns the client's --dial-source, or nil if reverse channels may dial
+// from any address
+func (c *Client) GetDialSource() *DialSource {
+	return c.dialSource
+}
+
+// GetDialProxy returns the client's --dial-proxy, or nil if reverse channels connect to
+// their targets directly
+func (c *Client) GetDialProxy() *DialProxy {
+	return c.dialProxy
+}
+
+// GetUnixSocketDirs returns the client's --unix-socket-dirs, or nil if the server may ask
+// for any socket
+func (c *Client) GetUnixSocketDirs() *UnixSocketDirs {
+	return c.unixSocketDirs
+}
+
+// GetClock returns the client's Clock
+func (c *Client) GetClock() Clock {
+	return c.clock
+}
+
+// GetReconnectQueueTime returns the client's --reconnect-queue
+func (c *Client) GetReconnectQueueTime() time.Duration {
+	return c.config.ReconnectQueue
+}
+
+// GetChannelObserver returns nil; only the server's channels may be observed
+func (c *Client) GetChannelObserver() *ChannelObserver {
+	return nil
+}
+
+// GetChannelAliases returns nil; aliases are only defined by the server
+func (c *Client) GetChannelAliases() *ChannelAliases {
+	return nil
+}
+
+// GetExecCommands returns the client's --exec commands, or nil if none are defined
+func (c *Client) GetExecCommands() *ExecCommands {
+	return c.execCommands
+}
+
+// GetMQTTTopicPrefixes returns nil; the client does not restrict MQTT topics
+func (c *Client) GetMQTTTopicPrefixes() []string {
+	return nil
+}
+
+// GetE2EKey returns the client's end-to-end encryption key, or nil if it has none
+func (c *Client) GetE2EKey() *E2EKey {
+	return c.e2eKey
+}
+
+// TapChannel offers a channel to the client's ChannelTap, if any
+func (c *Client) TapChannel(ctx context.Context, ced *ChannelEndpointDescriptor, conn ChannelConn) ChannelConn {
+	return TapChannelConn(ctx, c.Logger, c.clock, c.channelTap, ced, conn)
+}
+
+// IdentifyChannel sends the client's user to the Called Service of a skeleton endpoint
+// with the "ident" option
+func (c *Client) IdentifyChannel(ced *ChannelEndpointDescriptor, conn ChannelConn) ChannelConn {
+	return WrapIdentChannelConn(&ChannelIdentity{User: c.sshConfig.User}, EndpointIdent(ced), conn)
+}
+
+// LimitChannel limits the bytes a channel may transfer to its endpoint's "max-bytes" option
+func (c *Client) LimitChannel(ced *ChannelEndpointDescriptor, conn ChannelConn) ChannelConn {
+	return LimitChannelConn(conn, EndpointMaxBytes(ced))
+}
+
+// GetHeldChannels returns nil; only forward channels, whose skeleton is on the server, can be
+// held
+func (c *Client) GetHeldChannels() *HeldChannels {
+	return nil
+}
+
 //Run starts client and blocks while connected
 func (c *Client) Run(ctx context.Context) error {
 	subCtx, cancel := context.WithCancel(ctx)
@@ -172,7 +753,7 @@ func (c *Client) verifyServer(hostname string, remote net.Addr, key ssh.PublicKe
 	expect := c.config.Fingerprint
 	got := FingerprintKey(key)
 	if expect != "" && !strings.HasPrefix(got, expect) {
-		return fmt.Errorf("Invalid fingerprint (%s)", got)
+		return fmt.Errorf("%s (%s)", errFingerprintMismatch, got)
 	}
 	//overwrite with complete fingerprint
 	c.ILogf("Fingerprint %s", got)
@@ -181,6 +762,7 @@ func (c *Client) verifyServer(hostname string, remote net.Addr, key ssh.PublicKe
 
 //Start client and does not block
 func (c *Client) Start(ctx context.Context) error {
+	ctx = WithRequestMetadata(ctx, &RequestMetadata{User: c.sshConfig.User})
 	c.ShutdownOnContext(ctx)
 	via := ""
 	if c.httpProxyURL != nil {
@@ -196,42 +778,124 @@ func (c *Client) Start(ctx context.Context) error {
 			}
 		}
 	}
+	//prepare non-reverse proxies from the remotes file, and watch it for changes
+	if c.remotesFile != nil {
+		c.remotesLock.Lock()
+		for _, key := range c.dynamicRemoteKeys {
+			if err := c.startRemoteProxy(ctx, c.dynamicRemotes[key]); err != nil {
+				c.remotesLock.Unlock()
+				return err
+			}
+		}
+		c.remotesLock.Unlock()
+		err := c.remotesFile.Watch(ctx, func(chdStrings []string) {
+			if err := c.applyFileRemotes(ctx, chdStrings); err != nil {
+				c.ILogf("Failed to apply remotes from %s: %s", c.remotesFile.Path(), err)
+			}
+		})
+		if err != nil {
+			return c.Errorf("Unable to watch remotes file %s: %s", c.remotesFile.Path(), err)
+		}
+	}
+	//optional control socket
+	if c.config.ControlAddr != "" {
+		control, err := NewControlServer(c.Logger, c, c.config.ControlAddr, c.config.ControlToken)
+		if err != nil {
+			return err
+		}
+		c.AddShutdownChild(control)
+		if err := control.Start(ctx); err != nil {
+			return err
+		}
+		c.control = control
+	}
 	c.ILogf("Connecting to %s%s\n", c.server, via)
 	//optional keepalive loop
-	if c.config.KeepAlive > 0 {
+	if c.config.KeepAlive > 0 || c.keepAlive != nil {
 		go c.keepAliveLoop()
 	}
+	if c.config.StatsInterval > 0 {
+		go c.statsLoop(ctx)
+	}
+	if c.config.StatsUpdateInterval > 0 {
+		go c.statsUpdateLoop(ctx)
+	}
 	//connection loop
 	go c.connectionLoop(ctx)
 	return nil
 }
 
 func (c *Client) keepAliveLoop() {
-	pingDelay := time.NewTimer(c.config.KeepAlive)
-	defer pingDelay.Stop()
 	for {
+		interval := c.config.KeepAlive
+		if c.keepAlive != nil {
+			interval = c.keepAlive.Interval()
+		}
 		select {
 		case <-c.ShutdownStartedChan():
 			return
-		case <-pingDelay.C:
-			if c.sshConn != nil {
-				c.sshConn.SendRequest("ping", true, nil)
+		case <-c.clock.After(interval):
+			sshConn := c.getConnectedSSHConn()
+			if sshConn == nil {
+				continue
+			}
+			if c.keepAlive == nil {
+				sshConn.SendRequest("ping", true, nil)
+				continue
+			}
+			idle := c.keepAlive.Idle()
+			err := c.adaptiveKeepAlivePing(sshConn)
+			c.keepAlive.PingDone(idle, err)
+			if err != nil {
+				c.DLogf("Keepalive failed: %s", err)
+				sshConn.Close()
 			}
-			pingDelay.Reset(c.config.KeepAlive)
 		}
 	}
 }
 
+// adaptiveKeepAlivePing sends a keepalive ping, and waits up to keepAlivePingTimeout for
+// its reply, so that a connection silently dropped by a NAT is noticed
+func (c *Client) adaptiveKeepAlivePing(sshConn ssh.Conn) error {
+	errc := make(chan error, 1)
+	go func() {
+		_, _, err := sshConn.SendRequest("ping", true, nil)
+		errc <- err
+	}()
+	select {
+	case err := <-errc:
+		return err
+	case <-c.clock.After(keepAlivePingTimeout):
+		return fmt.Errorf("No reply within %s", keepAlivePingTimeout)
+	}
+}
+
 func (c *Client) connectionLoop(ctx context.Context) {
 	//connection loop!
 	var connerr error
+	var fatalErr error
 	// stdioStarted := false
 	b := &backoff.Backoff{Max: c.config.MaxRetryInterval}
 	for !c.IsStartedShutdown() {
 		if connerr != nil {
+			//errors that retrying cannot fix end the client
+			if classified := classifyConnectError(connerr); classified.Fatal() {
+				if classified.Code == ConnectErrorAuth {
+					c.ILogf("Authentication failed")
+					c.DLogf(connerr.Error())
+				} else {
+					c.ILogf("Connection failed: %s; not retrying", classified)
+				}
+				c.sshConnErr = classified
+				fatalErr = classified
+				break
+			}
 			attempt := int(b.Attempt())
 			maxAttempt := c.config.MaxRetryCount
 			d := b.Duration()
+			if hint := retryAfterHint(connerr); hint > d {
+				d = hint
+			}
 			//show error and attempt counts
 			msg := fmt.Sprintf("Connection error: %s", connerr)
 			if attempt > 0 {
@@ -241,80 +905,130 @@ func (c *Client) connectionLoop(ctx context.Context) {
 				}
 				msg += ")"
 			}
-			c.DLogf(msg)
+			var statusErr *HTTPStatusError
+			if errors.As(connerr, &statusErr) && statusErr.Rejection != nil {
+				c.ILogf(msg)
+			} else {
+				c.DLogf(msg)
+			}
 			//give up?
 			if maxAttempt >= 0 && attempt >= maxAttempt {
 				break
 			}
 			c.ILogf("Retrying in %s...", d)
 			connerr = nil
-			SleepSignal(d)
+			SleepSignalOrWake(c.clock, d, c.reconnectNow)
 		}
-		d := websocket.Dialer{
-			ReadBufferSize:   1024,
-			WriteBufferSize:  1024,
-			HandshakeTimeout: 45 * time.Second,
-			Subprotocols:     []string{ProtocolVersion},
-		}
-		//optionally CONNECT proxy
-		if c.httpProxyURL != nil {
-			d.Proxy = func(*http.Request) (*url.URL, error) {
-				return c.httpProxyURL, nil
-			}
-		}
-		wsHeaders := http.Header{}
-		if c.config.HostHeader != "" {
-			wsHeaders = http.Header{
-				"Host": {c.config.HostHeader},
-			}
-		}
-		wsConn, _, err := d.Dial(c.server, wsHeaders)
+		transport, err := c.dialTransport()
 		if err != nil {
 			connerr = err
 			continue
 		}
-		conn := NewWebSocketConn(wsConn)
+		TuneTransport(c.Logger, transport, c.scheduler)
+		SetTransportBatchDelay(transport, c.clock, c.config.WebSocketBatchDelay)
+		SetTransportMaxMessage(transport, int(c.config.WebSocketMaxMessage))
+		transport = c.faults.WrapTransport(FaultSideClient, transport)
+		if c.keepAlive != nil {
+			transport = c.keepAlive.TrackTransport(transport)
+		}
+		conn := c.scheduler.WrapTransport(transport)
 		// perform SSH handshake on net.Conn
+		if d := c.faults.HandshakeDelay(FaultSideClient); d > 0 {
+			c.DLogf("Delaying handshake by %s", d)
+			<-c.clock.After(d)
+		}
 		c.DLogf("Handshaking...")
 		sshConn, chans, reqs, err := ssh.NewClientConn(conn, "", c.sshConfig)
 		if err != nil {
-			c.sshConnErr = err
-			if strings.Contains(err.Error(), "unable to authenticate") {
-				c.ILogf("Authentication failed")
-				c.DLogf(err.Error())
-			} else {
-				c.ILogf(err.Error())
+			if strings.Contains(err.Error(), "unable to authenticate") && c.discardReconnectToken() {
+				c.ILogf("Reconnection token rejected; retrying with password")
+				continue
+			}
+			connerr = err
+			continue
+		}
+		// Hold remotesLock until c.sshConn is set, so that remotes file changes
+		// are either included in the config or sent after it
+		c.remotesLock.Lock()
+		configRequest := c.sessionConfigRequest()
+		if c.deviceKey != nil {
+			configRequest.DeviceKey, configRequest.DeviceSignature, err = signDeviceProof(c.deviceKey, sshConn.SessionID())
+			if err != nil {
+				c.remotesLock.Unlock()
+				sshConn.Close()
+				connerr = fmt.Errorf("Unable to sign with device key: %s", err)
+				continue
 			}
-			break
 		}
-		c.config.shared.Version = BuildVersion
-		conf, _ := c.config.shared.Marshal()
+		conf, _ := configRequest.Marshal()
 		c.DLogf("Sending session config request")
-		t0 := time.Now()
-		_, configerr, err := sshConn.SendRequest("config", true, conf)
+		t0 := c.clock.Now()
+		ok, configReply, err := sshConn.SendRequest("config", true, conf)
 		if err != nil {
-			c.sshConnErr = err
-			c.ILogf("Session config verification failed")
-			break
+			c.remotesLock.Unlock()
+			sshConn.Close()
+			connerr = fmt.Errorf("Session config verification failed: %s", err)
+			continue
 		}
-		if len(configerr) > 0 {
-			c.ILogf(string(configerr))
-			c.sshConnErr = fmt.Errorf("SSH server returned binary config error: %v", configerr)
-			break
+		if !ok {
+			c.remotesLock.Unlock()
+			sshConn.Close()
+			connerr = &ConnectError{Code: ConnectErrorConfig, Err: errors.New(string(configReply))}
+			continue
+		}
+		c.ILogf("Connected (Latency %s)", c.clock.Now().Sub(t0))
+		c.boundAddrs = make(map[string]string)
+		c.acceptedRemotes = make(map[string]bool)
+		c.applyChannelsReply(configRequest.ChannelDescriptors, configReply)
+		if err := c.takeOverListeners(sshConn); err != nil {
+			c.remotesLock.Unlock()
+			sshConn.Close()
+			connerr = err
+			continue
 		}
-		c.ILogf("Connected (Latency %s)", time.Since(t0))
 		//connected
 		b.Reset()
-		go ssh.DiscardRequests(reqs)
-		c.sshConn = sshConn
+		// a reconnect requested while connecting is satisfied by this connection
+		select {
+		case <-c.reconnectNow:
+		default:
+		}
+
+		go c.handleSSHRequests(ctx, reqs)
 
 		// wake up anyone waiting for our ssh connection to be ready
-		close(c.sshConnReady)
+		c.setSSHConn(sshConn)
+		c.remotesLock.Unlock()
 
 		go c.connectStreams(ctx, chans)
 		err = sshConn.Wait()
 
 		//disconnected
+		c.clearSSHConn()
+		c.remotesLock.Lock()
+		c.acceptedRemotes = make(map[string]bool)
+		c.remotesLock.Unlock()
+		c.ILogf("Disconnected\n")
+		if c.IsStartedShutdown() {
+			break
+		}
+		select {
+		case <-c.reconnectNow:
+			//reconnect requested (see Reconnect and drainSSHConn); stub endpoints keep
+			//running, and will wait in GetSSHConn until we have reconnected
+			continue
+		default:
+		}
+
+		if c.config.Reconnect {
+			//stub endpoints keep running, and will wait in GetSSHConn until we have
+			//reconnected
+			connerr = err
+			if connerr == nil {
+				connerr = c.Errorf("Proxy Server disconnected")
+			}
+			continue
+		}
 
 		// sammck: it is *not* ok to reset c.sshConn to nil after we have stub endpoints running
 		//    The safest thing is to shut down here
@@ -323,20 +1037,179 @@ func (c *Client) connectionLoop(ctx context.Context) {
 		//   connerr = err
 		//   continue
 		//   }
-		c.ILogf("Disconnected\n")
 		c.Shutdown(c.Errorf("Proxy Server disconnected"))
 
 		break
 	}
+	if fatalErr != nil {
+		c.Shutdown(fatalErr)
+		return
+	}
 	c.Close()
 }
 
+// dialTransport connects to the server with a websocket or, if the websocket fails and
+// the transport is TransportAuto, with the long-poll transport, or with the HTTP/2 stream
+// transport if it is TransportH2
+func (c *Client) dialTransport() (net.Conn, error) {
+	if err := c.faults.DialFault(c.server); err != nil {
+		return nil, err
+	}
+	wsHeaders := http.Header{}
+	for name, values := range c.headers {
+		wsHeaders[name] = values
+	}
+	if c.config.HostHeader != "" {
+		wsHeaders.Set("Host", c.config.HostHeader)
+	}
+	if err := c.setUpgradeToken(wsHeaders); err != nil {
+		return nil, err
+	}
+	if c.config.Transport == TransportH2 {
+		return DialStreamTransport(c.clock, strings.Replace(c.server, "ws", "http", 1), wsHeaders, c.tlsConfig)
+	}
+	if !c.usePoll {
+		d := websocket.Dialer{
+			ReadBufferSize:   1024,
+			WriteBufferSize:  wsWriteBufferSize,
+			HandshakeTimeout: 45 * time.Second,
+			Subprotocols:     []string{ProtocolVersion},
+			TLSClientConfig:  c.tlsConfig,
+		}
+		//optionally CONNECT proxy
+		if c.httpProxyURL != nil {
+			d.Proxy = func(*http.Request) (*url.URL, error) {
+				return c.httpProxyURL, nil
+			}
+		}
+		wsConn, resp, err := d.Dial(c.server, wsHeaders)
+		if err == nil {
+			return NewWebSocketConn(wsConn), nil
+		}
+		if err == websocket.ErrBadHandshake && resp != nil {
+			statusErr := newHTTPStatusError(resp)
+			if statusErr.Rejection != nil && statusErr.Rejection.Reason != UpgradeRejectedUpgradeLost {
+				//a chisel server refused the client, so long-polling cannot help either
+				return nil, statusErr
+			}
+			err = statusErr
+		}
+		//the long-poll transport cannot help if the server is unreachable
+		var opErr *net.OpError
+		if c.config.Transport == TransportWebSocket || (errors.As(err, &opErr) && opErr.Op == "dial") {
+			return nil, err
+		}
+		c.ILogf("Websocket connection failed (%s); falling back to HTTP long-polling", err)
+	}
+	//http(s) URL of the server
+	pollURL := strings.Replace(c.server, "ws", "http", 1)
+	//the server may have accepted the websocket's token before the upgrade failed
+	if err := c.setUpgradeToken(wsHeaders); err != nil {
+		return nil, err
+	}
+	conn, err := DialPollTransport(c.clock, pollURL, wsHeaders, c.httpProxyURL, c.tlsConfig)
+	if err != nil {
+		return nil, err
+	}
+	c.usePoll = true
+	return conn, nil
+}
+
+// setUpgradeToken sets a new upgrade token in the headers of a request that opens a
+// transport, if the client has an upgrade key
+func (c *Client) setUpgradeToken(header http.Header) error {
+	if c.upgradeTokens == nil {
+		return nil
+	}
+	token, err := c.upgradeTokens.Issue()
+	if err != nil {
+		return err
+	}
+	header.Set(UpgradeTokenHeader, token)
+	return nil
+}
+
+// handleSSHRequests handles incoming requests from the server on the SSH connection
+func (c *Client) handleSSHRequests(ctx context.Context, reqs <-chan *ssh.Request) {
+	for req := range reqs {
+		switch req.Type {
+		case ResolveRequestType:
+			if !c.config.ResolveForServer {
+				c.DLogf("Refusing to resolve '%s' for the server without --socks5-resolve-on-client", req.Payload)
+				req.Reply(false, []byte("The client does not resolve names for the server"))
+			} else {
+				go handleResolveRequest(ctx, c.Logger, req)
+			}
+		case ReconnectTokenRequestType:
+			c.setReconnectToken(string(req.Payload))
+			req.Reply(true, nil)
+		case ReconnectRequestType:
+			c.drainSSHConn()
+			req.Reply(true, nil)
+		case NoticeRequestType:
+			c.handleNotice(req)
+		case StatsUpdateRequestType:
+			handleStatsUpdate(c.Logger, c.stats, req)
+		default:
+			c.DLogf("Unknown SSH request type from server: %s", req.Type)
+			if req.WantReply {
+				req.Reply(false, nil)
+			}
+		}
+	}
+}
+
+// setReconnectToken records a reconnection token issued by the server
+func (c *Client) setReconnectToken(token string) {
+	c.reconnectTokenLock.Lock()
+	defer c.reconnectTokenLock.Unlock()
+	if c.forceReauth {
+		c.DLogf("Ignoring reconnection token while waiting to reconnect")
+		return
+	}
+	c.reconnectToken = token
+	c.DLogf("Received reconnection token")
+}
+
+// authPassword returns the secret to present when authenticating: the latest reconnection
+// token if there is one that has not expired, and otherwise the configured password
+func (c *Client) authPassword(pass string) string {
+	c.reconnectTokenLock.Lock()
+	defer c.reconnectTokenLock.Unlock()
+	c.authUsedToken = false
+	c.forceReauth = false
+	if c.reconnectToken == "" {
+		return pass
+	}
+	if claims, err := parseReconnectToken(c.reconnectToken); err != nil || c.clock.Now().After(claims.expiry) {
+		c.DLogf("Reconnection token expired; using password")
+		c.reconnectToken = ""
+		return pass
+	}
+	c.DLogf("Authenticating with reconnection token")
+	c.authUsedToken = true
+	return c.reconnectToken
+}
+
+// discardReconnectToken forgets the reconnection token if it was presented in the last
+// authentication attempt, and returns true if it was
+func (c *Client) discardReconnectToken() bool {
+	c.reconnectTokenLock.Lock()
+	defer c.reconnectTokenLock.Unlock()
+	if !c.authUsedToken {
+		return false
+	}
+	c.authUsedToken = false
+	c.reconnectToken = ""
+	return true
+}
+
 // HandleOnceShutdown will be called exactly once, in its own goroutine. It should take completionError
 // as an advisory completion value, actually shut down, then return the real completion value.
 func (c *Client) HandleOnceShutdown(completionErr error) error {
 	var err error
-	if c.sshConn != nil {
-		err = c.sshConn.Close()
+	if sshConn := c.getConnectedSSHConn(); sshConn != nil {
+		err = sshConn.Close()
 	}
 	if completionErr == nil {
 		completionErr = err
@@ -367,11 +1240,12 @@ func (c *Client) connectStreams(ctx context.Context, chans <-chan ssh.NewChannel
 		// TODO: **MUST** implement access control (whitelist originally configured reverse-proxy skeletons)
 
 		c.DLogf("Remote channel connect request, endpoint ='%s'", epd.LongString())
-		if epd.Role != ChannelEndpointRoleSkeleton {
-			reject(ssh.Prohibited, c.Errorf("Endpoint role must be skeleton"))
+		if err := epd.ValidateSkeleton(); err != nil {
+			reject(ssh.Prohibited, c.Errorf("Invalid NewChannel endpoint: %s", err))
 			continue
 		}
 
+		chCtx := newChannelContext(ctx, epd)
 		ep, err := NewLocalSkeletonChannelEndpoint(c.Logger, c, epd)
 		if err != nil {
 			reject(ssh.Prohibited, c.Errorf("Failed to create skeleton endpoint for SSH NewChannel: %s", err))
@@ -380,37 +1254,66 @@ func (c *Client) connectStreams(ctx context.Context, chans <-chan ssh.NewChannel
 
 		c.AddShutdownChild(ep)
 
-		// TODO: The actual local connect request should succeed before we accept the remote request.
-		//       Need to refactor code here
+		// connect to the Called Service before accepting the channel, so that a failure is
+		// reported to the server as a rejection with its reason
+		var extraData []byte
+		calledServiceConn, err := ep.Dial(chCtx, extraData)
+		if err != nil {
+			ep.Close()
+			logChannelDialFailure(chCtx, c.Logger, c.stats, err)
+			reject(ssh.ConnectionFailed, err)
+			continue
+		}
+
 		sshChannel, reqs, err := ch.Accept()
 		if err != nil {
 			c.DLogf("Failed to accept remote SSH Channel: %s", err)
+			calledServiceConn.Close()
+			ep.Close()
 			continue
 		}
 
-		// will shutdown when sshChannel is closed
-		go ssh.DiscardRequests(reqs)
-
 		// wrap the ssh.Channel to look like a ChannelConn
-		sshConn, err := NewSSHConn(c.Logger, sshChannel)
+		sshConn, err := NewSSHConn(c.Logger, sshChannel, reqs)
 		if err != nil {
 			c.DLogf("Failed to wrap SSH Channel: %s", err)
 			sshChannel.Close()
+			calledServiceConn.Close()
 			ep.Close()
 			continue
 		}
 
 		// sshChannel is now wrapped by sshConn, and will be closed when sshConn is closed
 
-		var extraData []byte
-		numSent, numReceived, err := ep.DialAndServe(ctx, sshConn, extraData)
-
-		// sshConn and sshChannel have now been closed
+		callerConn, err := WrapE2EChannelConn(c, epd, sshConn)
+		if err != nil {
+			c.DLogf("Failed to set up end-to-end encryption: %s", err)
+			sshConn.Close()
+			calledServiceConn.Close()
+			ep.Close()
+			continue
+		}
 
+		callerConn, err = WrapCompressChannelConn(epd, callerConn)
 		if err != nil {
-			c.DLogf("NewChannel session ended with error after %d bytes (caller->called), %d bytes (called->caller): %s", numSent, numReceived, err)
-		} else {
-			c.DLogf("NewChannel session ended normally after %d bytes (caller->called), %d bytes (called->caller)", numSent, numReceived)
+			c.DLogf("Failed to set up compression: %s", err)
+			sshConn.Close()
+			calledServiceConn.Close()
+			ep.Close()
+			continue
 		}
+
+		callerConn = c.TapChannel(chCtx, epd, callerConn)
+		callerConn = c.IdentifyChannel(epd, callerConn)
+		callerConn = ShadowChannelConn(c.Logger, c, epd, callerConn)
+		callerConn = c.LimitChannel(epd, callerConn)
+		untrack := c.TrackChannel(epd, sshConn)
+
+		numSent, numReceived, err := BasicBridgeChannels(chCtx, c.Logger, callerConn, calledServiceConn)
+		untrack()
+
+		// sshConn and sshChannel have now been closed
+
+		logChannelClose(chCtx, c.Logger, c.stats, channelCloseInfo(sshConn.CloseReason(), err), numSent, numReceived)
 	}
 }
@@ -0,0 +1,390 @@
+package chshare
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The client control protocol is line oriented. Each request is a single line containing a
+// command and optional argument, separated by whitespace. Each response is zero or more lines
+// of output, followed by a final line that is either "OK" or "ERR <message>". Any number of
+// requests may be sent on one connection. If the client has a control token, the first request
+// on each connection must be "auth <token>"; any other first request closes the connection.
+const (
+	controlResponseOK  = "OK"
+	controlResponseErr = "ERR"
+)
+
+const controlHelp = `Commands:
+  auth <token>      Authenticate with the client's control token, if it has one
+  list              List configured remotes and where they came from
+  add <remote>      Add a remote to the running session
+  remove <remote>   Remove a remote added with "add" or from the remotes file
+  activate <port>   Have the server listen on a port of a port range remote
+  deactivate <port> Stop listening on a port activated with "activate"
+  stats             Show connection metrics
+  loops             List the loop names on the server that this client may connect to
+  observable        List the channels on the server that this client may observe
+  pac               Show a proxy auto-configuration file for the SOCKS remotes
+  reconnect         Drop the connection to the server and reconnect immediately
+  shutdown          Shut down the client
+  help              This help text`
+
+// ParseControlAddr parses a control socket address into a network and address suitable for
+// net.Listen or net.Dial. An address that begins with "unix:" or contains a "/" is a unix domain
+// socket path. Anything else is a TCP address, which must be on a loopback interface. A bare
+// port number listens on 127.0.0.1.
+func ParseControlAddr(addr string) (string, string, error) {
+	if strings.HasPrefix(addr, "unix:") {
+		return "unix", strings.TrimPrefix(addr, "unix:"), nil
+	}
+	if strings.Contains(addr, "/") {
+		return "unix", addr, nil
+	}
+	addr = strings.TrimPrefix(addr, "tcp:")
+	if _, err := strconv.ParseUint(addr, 10, 16); err == nil {
+		return "tcp", "127.0.0.1:" + addr, nil
+	}
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "", "", fmt.Errorf("Invalid control socket address '%s': %s", addr, err)
+	}
+	if host != "localhost" {
+		ip := net.ParseIP(host)
+		if ip == nil || !ip.IsLoopback() {
+			return "", "", fmt.Errorf("Control socket address '%s' must be a loopback address", addr)
+		}
+	}
+	return "tcp", addr, nil
+}
+
+// ControlServer serves the client control protocol on a local unix domain socket or
+// loopback TCP port, allowing scripted runtime control of a Client
+type ControlServer struct {
+	ShutdownHelper
+	client   *Client
+	network  string
+	address  string
+	token    string
+	listener net.Listener
+}
+
+// NewControlServer creates a new ControlServer for a client. If token is not "", connections
+// must authenticate with it before sending commands. A token is required on a TCP address,
+// since any local user could otherwise control the client; a unix domain socket is only
+// accessible by its owner. It does not start listening until Start is called.
+func NewControlServer(logger Logger, client *Client, addr string, token string) (*ControlServer, error) {
+	network, address, err := parseControlServerAddr(addr, token)
+	if err != nil {
+		return nil, err
+	}
+	s := &ControlServer{
+		client:  client,
+		network: network,
+		address: address,
+		token:   token,
+	}
+	s.InitShutdownHelper(logger.Fork("control"), s)
+	return s, nil
+}
+
+// parseControlServerAddr parses the address of a control socket to listen on (see
+// ParseControlAddr), returning an error if it is a TCP address and token is ""
+func parseControlServerAddr(addr string, token string) (string, string, error) {
+	network, address, err := ParseControlAddr(addr)
+	if err != nil {
+		return "", "", err
+	}
+	if network != "unix" && token == "" {
+		return "", "", fmt.Errorf("The control socket on %s requires a token (--control-token), unless it is a unix domain socket", addr)
+	}
+	return network, address, nil
+}
+
+// HandleOnceShutdown will be called exactly once, in its own goroutine. It should take completionError
+// as an advisory completion value, actually shut down, then return the real completion value.
+func (s *ControlServer) HandleOnceShutdown(completionErr error) error {
+	var err error
+	if s.listener != nil {
+		err = s.listener.Close()
+	}
+	if completionErr == nil {
+		completionErr = err
+	}
+	return completionErr
+}
+
+// Start begins listening on the control socket and serving requests in the background
+func (s *ControlServer) Start(ctx context.Context) error {
+	return s.DoOnceActivate(
+		func() error {
+			var listener net.Listener
+			var err error
+			if s.network == "unix" {
+				// Without a token, only the owner may use the control socket
+				listener, err = NewPrivateUnixSocketListener(s.Logger, s.address)
+			} else {
+				listener, err = net.Listen(s.network, s.address)
+			}
+			if err != nil {
+				return s.Errorf("Unable to listen on control socket %s: %s", s.address, err)
+			}
+			s.listener = listener
+			s.ShutdownOnContext(ctx)
+			s.ILogf("Listening for control commands on %s", listener.Addr())
+			go s.acceptLoop(ctx)
+			return nil
+		},
+		true,
+	)
+}
+
+func (s *ControlServer) acceptLoop(ctx context.Context) {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			if !s.IsStartedShutdown() {
+				s.StartShutdown(s.DLogErrorf("Control socket accept failed: %s", err))
+			}
+			return
+		}
+		go s.serveConn(ctx, conn)
+	}
+}
+
+func (s *ControlServer) serveConn(ctx context.Context, conn net.Conn) {
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-s.ShutdownStartedChan():
+			conn.Close()
+		case <-done:
+		}
+	}()
+	defer conn.Close()
+
+	scanner := bufio.NewScanner(conn)
+	w := bufio.NewWriter(conn)
+	authenticated := s.token == ""
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		if !authenticated {
+			if !s.checkAuth(line) {
+				s.DLogf("Control connection from %s failed to authenticate", conn.RemoteAddr())
+				fmt.Fprintf(w, "%s invalid or missing control token\n", controlResponseErr)
+				w.Flush()
+				return
+			}
+			authenticated = true
+			fmt.Fprintf(w, "%s\n", controlResponseOK)
+			if w.Flush() != nil {
+				return
+			}
+			continue
+		}
+		output, afterReply, err := s.runCommand(ctx, line)
+		if output != "" {
+			w.WriteString(output)
+			if !strings.HasSuffix(output, "\n") {
+				w.WriteString("\n")
+			}
+		}
+		if err != nil {
+			// keep the response to a single line
+			msg := strings.Replace(err.Error(), "\n", " ", -1)
+			fmt.Fprintf(w, "%s %s\n", controlResponseErr, msg)
+		} else {
+			fmt.Fprintf(w, "%s\n", controlResponseOK)
+		}
+		flushErr := w.Flush()
+		if afterReply != nil {
+			afterReply()
+		}
+		if flushErr != nil {
+			return
+		}
+	}
+}
+
+// checkAuth returns true if a request line is "auth" with the control token
+func (s *ControlServer) checkAuth(line string) bool {
+	fields := strings.Fields(line)
+	return len(fields) == 2 && fields[0] == "auth" &&
+		subtle.ConstantTimeCompare([]byte(fields[1]), []byte(s.token)) == 1
+}
+
+// runCommand executes a single control command, returning its output and an optional
+// action to be taken after the reply has been sent
+func (s *ControlServer) runCommand(ctx context.Context, line string) (string, func(), error) {
+	fields := strings.Fields(line)
+	cmd := fields[0]
+	args := fields[1:]
+	s.DLogf("Control command: %s", line)
+
+	needArgs := func(n int) error {
+		if len(args) != n {
+			return fmt.Errorf("%s: expected %d argument(s), got %d", cmd, n, len(args))
+		}
+		return nil
+	}
+
+	switch cmd {
+	case "help":
+		return controlHelp, nil, nil
+	case "list":
+		if err := needArgs(0); err != nil {
+			return "", nil, err
+		}
+		var b strings.Builder
+		for _, r := range s.client.Remotes() {
+			if r.OnDemand {
+				fmt.Fprintf(&b, "%-8s %s (activated on demand)\n", r.Source, r.Descriptor)
+			} else if r.BoundAddr != "" {
+				fmt.Fprintf(&b, "%-8s %s (listening on %s)\n", r.Source, r.Descriptor, r.BoundAddr)
+			} else {
+				fmt.Fprintf(&b, "%-8s %s\n", r.Source, r.Descriptor)
+			}
+		}
+		return b.String(), nil, nil
+	case "add":
+		if err := needArgs(1); err != nil {
+			return "", nil, err
+		}
+		return "", nil, s.client.AddRemote(ctx, args[0])
+	case "remove":
+		if err := needArgs(1); err != nil {
+			return "", nil, err
+		}
+		return "", nil, s.client.RemoveRemote(ctx, args[0])
+	case "activate":
+		if err := needArgs(1); err != nil {
+			return "", nil, err
+		}
+		return "", nil, s.client.ActivatePort(ctx, args[0])
+	case "deactivate":
+		if err := needArgs(1); err != nil {
+			return "", nil, err
+		}
+		return "", nil, s.client.DeactivatePort(ctx, args[0])
+	case "stats":
+		if err := needArgs(0); err != nil {
+			return "", nil, err
+		}
+		var b bytes.Buffer
+		if err := s.client.GetStatsRegistry().WriteText(&b); err != nil {
+			return "", nil, err
+		}
+		return b.String(), nil, nil
+	case "loops":
+		if err := needArgs(0); err != nil {
+			return "", nil, err
+		}
+		names, err := s.client.LoopNames()
+		if err != nil {
+			return "", nil, err
+		}
+		var b strings.Builder
+		for _, name := range names {
+			fmt.Fprintf(&b, "%s\n", name)
+		}
+		return b.String(), nil, nil
+	case "observable":
+		if err := needArgs(0); err != nil {
+			return "", nil, err
+		}
+		channels, err := s.client.ObservableChannels()
+		if err != nil {
+			return "", nil, err
+		}
+		var b strings.Builder
+		for _, ch := range channels {
+			fmt.Fprintf(&b, "%d user=%q session=#%d %s started=%s observers=%d\n",
+				ch.ID, ch.User, ch.SessionID, ch.Endpoint, ch.Started.Format(time.RFC3339), ch.Observers)
+		}
+		return b.String(), nil, nil
+	case "pac":
+		if err := needArgs(0); err != nil {
+			return "", nil, err
+		}
+		pac, err := s.client.PAC()
+		if err != nil {
+			return "", nil, err
+		}
+		return pac, nil, nil
+	case "reconnect":
+		if err := needArgs(0); err != nil {
+			return "", nil, err
+		}
+		s.client.Reconnect()
+		return "", nil, nil
+	case "shutdown":
+		if err := needArgs(0); err != nil {
+			return "", nil, err
+		}
+		s.ILogf("Shutdown requested on control socket")
+		return "", func() { s.client.StartShutdown(nil) }, nil
+	default:
+		return "", nil, fmt.Errorf("Unknown command \"%s\"; try \"help\"", cmd)
+	}
+}
+
+// SendControlCommand sends a single command to a client control socket, authenticating with
+// token if it is not "", and copies the command's output to out. An error is returned if the
+// command fails.
+func SendControlCommand(addr string, token string, command string, out io.Writer) error {
+	network, address, err := ParseControlAddr(addr)
+	if err != nil {
+		return err
+	}
+	conn, err := net.DialTimeout(network, address, 10*time.Second)
+	if err != nil {
+		return fmt.Errorf("Unable to connect to control socket %s: %s", address, err)
+	}
+	defer conn.Close()
+	if token != "" {
+		if _, err := fmt.Fprintf(conn, "auth %s\n", token); err != nil {
+			return fmt.Errorf("Unable to send control command: %s", err)
+		}
+	}
+	if _, err := fmt.Fprintf(conn, "%s\n", command); err != nil {
+		return fmt.Errorf("Unable to send control command: %s", err)
+	}
+	scanner := bufio.NewScanner(conn)
+	if token != "" {
+		if err := readControlResponse(scanner, ioutil.Discard); err != nil {
+			return err
+		}
+	}
+	return readControlResponse(scanner, out)
+}
+
+// readControlResponse reads the response to one control command, copying its output to out
+func readControlResponse(scanner *bufio.Scanner, out io.Writer) error {
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == controlResponseOK {
+			return nil
+		}
+		if strings.HasPrefix(line, controlResponseErr+" ") {
+			return fmt.Errorf("%s", strings.TrimPrefix(line, controlResponseErr+" "))
+		}
+		fmt.Fprintln(out, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("Control socket read failed: %s", err)
+	}
+	return fmt.Errorf("Control socket closed without a response")
+}
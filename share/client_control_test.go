@@ -0,0 +1,30 @@
+package chshare
+
+import "testing"
+
+func TestControlServerAddrRequiresTokenForTCP(t *testing.T) {
+	if _, _, err := parseControlServerAddr("127.0.0.1:9000", ""); err == nil {
+		t.Fatalf("TCP control socket accepted without a token")
+	}
+	if _, _, err := parseControlServerAddr("9000", "secret"); err != nil {
+		t.Fatalf("TCP control socket with a token rejected: %s", err)
+	}
+	if _, _, err := parseControlServerAddr("/run/chisel.sock", ""); err != nil {
+		t.Fatalf("unix control socket without a token rejected: %s", err)
+	}
+}
+
+func TestControlServerCheckAuth(t *testing.T) {
+	s := &ControlServer{token: "secret"}
+	for line, want := range map[string]bool{
+		"auth secret":       true,
+		"auth wrong":        false,
+		"auth":              false,
+		"auth secret extra": false,
+		"list":              false,
+	} {
+		if got := s.checkAuth(line); got != want {
+			t.Errorf("checkAuth(%q) = %v, want %v", line, got, want)
+		}
+	}
+}
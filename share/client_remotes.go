@@ -0,0 +1,412 @@
+package chshare
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// RemoteSource identifies how a client remote was configured
+type RemoteSource string
+
+const (
+	// RemoteSourceCommandLine is a remote given on the command line. These are fixed for the
+	// life of the client.
+	RemoteSourceCommandLine RemoteSource = "cli"
+
+	// RemoteSourceFile is a remote loaded from the client's remotes file
+	RemoteSourceFile RemoteSource = "file"
+
+	// RemoteSourceControl is a remote added through the client's control socket
+	RemoteSourceControl RemoteSource = "control"
+
+	// RemoteSourceRange is a port of a port range remote, activated through the client's
+	// control socket
+	RemoteSourceRange RemoteSource = "range"
+)
+
+// clientRemote is a remote added to a running client, along with its local stub
+// listener if it is a forward channel
+type clientRemote struct {
+	chd    *ChannelDescriptor
+	source RemoteSource
+	proxy  *TCPProxy
+}
+
+// ClientRemoteInfo describes a remote configured on a running client
+type ClientRemoteInfo struct {
+	Descriptor string
+	Source     RemoteSource
+
+	// BoundAddr is the address the server actually listens on for a reverse remote, or ""
+	// if it is not known, e.g. because the client is not connected or the server is too old
+	// to report it
+	BoundAddr string
+
+	// OnDemand is true for a port range remote, whose ports are only bound once activated
+	OnDemand bool
+}
+
+// Remotes returns the remotes currently configured on the client, command line remotes first
+func (c *Client) Remotes() []ClientRemoteInfo {
+	var result []ClientRemoteInfo
+	c.remotesLock.Lock()
+	defer c.remotesLock.Unlock()
+	for _, chd := range c.config.shared.ChannelDescriptors {
+		key := chd.String()
+		result = append(result, ClientRemoteInfo{Descriptor: key, Source: RemoteSourceCommandLine, BoundAddr: c.boundAddrs[key]})
+	}
+	for _, r := range c.portRanges {
+		result = append(result, ClientRemoteInfo{Descriptor: r.String(), Source: RemoteSourceCommandLine, OnDemand: true})
+	}
+	for _, key := range c.dynamicRemoteKeys {
+		result = append(result, ClientRemoteInfo{Descriptor: key, Source: c.dynamicRemotes[key].source, BoundAddr: c.boundAddrs[key]})
+	}
+	return result
+}
+
+// PAC returns a proxy auto-configuration file that routes the client's --pac destinations
+// through its SOCKS remotes. A remote listening on all interfaces is given as 127.0.0.1.
+func (c *Client) PAC() (string, error) {
+	if c.pacRules == nil {
+		return "", fmt.Errorf("No PAC destinations configured (see --pac)")
+	}
+	var proxyAddrs []string
+	c.remotesLock.Lock()
+	for _, chd := range c.allRemotes() {
+		if !chd.Reverse && isSocksStub(chd) {
+			proxyAddrs = append(proxyAddrs, pacProxyAddr(chd.Stub.Path, DefaultStubBindAddr))
+		}
+	}
+	c.remotesLock.Unlock()
+	return c.pacRules.Generate(proxyAddrs), nil
+}
+
+// BoundAddr returns the address the server actually listens on for the reverse remote with
+// descriptor string key, or "" if it is not known
+func (c *Client) BoundAddr(key string) string {
+	c.remotesLock.Lock()
+	defer c.remotesLock.Unlock()
+	return c.boundAddrs[key]
+}
+
+// applyChannelsReply records the bound addresses in the server's successful reply to a session
+// config or dynamic channels request that added chds, and which of the reverse remotes in chds
+// the server accepted, and logs the remotes the server could not add. A server too old to send
+// a reply, which sends an empty one, accepted all of chds. The caller must hold remotesLock.
+func (c *Client) applyChannelsReply(chds []*ChannelDescriptor, payload []byte) {
+	reply := &ChannelsReply{}
+	if len(payload) > 0 {
+		if err := reply.Unmarshal(payload); err != nil {
+			c.ILogf("Ignoring invalid channels reply from server: %s", err)
+			return
+		}
+	}
+	for key, addr := range reply.BoundAddrs {
+		c.boundAddrs[key] = addr
+		c.ILogf("Server is listening on %s for %s", addr, key)
+	}
+	for key, msg := range reply.Errors {
+		c.WLogf("Server could not add %s: %s", key, msg)
+	}
+	for _, chd := range chds {
+		key := chd.String()
+		if _, failed := reply.Errors[key]; chd.Reverse && !failed {
+			c.acceptedRemotes[key] = true
+		}
+	}
+}
+
+// sessionConfigRequest returns the session config to send to the server, including both
+// command line remotes and remotes added at runtime. The caller must hold remotesLock.
+func (c *Client) sessionConfigRequest() *SessionConfigRequest {
+	config := &SessionConfigRequest{
+		Version:      BuildVersion,
+		WantReply:    true,
+		SessionName:  c.config.shared.SessionName,
+		AllowPartial: c.config.AllowPartial,
+	}
+	for _, chd := range c.allRemotes() {
+		if !c.config.Takeover || !chd.Reverse {
+			config.ChannelDescriptors = append(config.ChannelDescriptors, chd)
+		}
+	}
+	return config
+}
+
+// allRemotes returns the descriptors of all remotes, command line remotes first. The caller
+// must hold remotesLock.
+func (c *Client) allRemotes() []*ChannelDescriptor {
+	chds := append([]*ChannelDescriptor(nil), c.config.shared.ChannelDescriptors...)
+	for _, key := range c.dynamicRemoteKeys {
+		chds = append(chds, c.dynamicRemotes[key].chd)
+	}
+	return chds
+}
+
+// takeOverListeners asks the server, once the session is configured, to add the reverse
+// remotes that were left out of the session config, taking over their listeners from
+// another session of the same user, e.g. that of the client instance this one replaces. A
+// server too old to know the request gets them as a dynamic channels request instead. The
+// caller must hold remotesLock.
+func (c *Client) takeOverListeners(sshConn ssh.Conn) error {
+	req := &DynamicChannelsRequest{WantReply: true}
+	for _, chd := range c.allRemotes() {
+		if chd.Reverse {
+			req.AddChannelDescriptors = append(req.AddChannelDescriptors, chd)
+		}
+	}
+	if !c.config.Takeover || len(req.AddChannelDescriptors) == 0 {
+		return nil
+	}
+	payload, err := req.Marshal()
+	if err != nil {
+		return c.Errorf("Unable to serialize listener takeover request: %s", err)
+	}
+	c.DLogf("Sending listener takeover request")
+	ok, reply, err := sshConn.SendRequest(ListenerTakeoverRequestType, true, payload)
+	if err == nil && !ok && len(reply) == 0 {
+		c.ILogf("Server does not support listener takeover; adding reverse remotes anew")
+		ok, reply, err = sshConn.SendRequest(DynamicChannelsRequestType, true, payload)
+	}
+	if err != nil {
+		return fmt.Errorf("Listener takeover failed: %s", err)
+	}
+	if !ok {
+		return &ConnectError{Code: ConnectErrorConfig, Err: errors.New(string(reply))}
+	}
+	c.applyChannelsReply(req.AddChannelDescriptors, reply)
+	return nil
+}
+
+// GetLocalSkeleton returns the skeleton endpoint of the client's own reverse remote with a loop
+// stub at the path of the loop skeleton endpoint ced, if the server has accepted that remote in
+// the current session. A channel to ced would be routed by the server straight back to that
+// reverse remote, so it can be served without the server. Channels with end-to-end
+// encryption, and stdio skeletons, are left to the server.
+func (c *Client) GetLocalSkeleton(ced *ChannelEndpointDescriptor) *ChannelEndpointDescriptor {
+	if ced.Type != ChannelEndpointTypeLoop || ced.Option("e2e") != "" {
+		return nil
+	}
+	c.remotesLock.Lock()
+	defer c.remotesLock.Unlock()
+	for _, chd := range c.allRemotes() {
+		if chd.Reverse && chd.Stub.Type == ChannelEndpointTypeLoop && chd.Stub.Path == ced.Path &&
+			chd.Skeleton.Type != ChannelEndpointTypeStdio && chd.Skeleton.Option("e2e") == "" &&
+			c.acceptedRemotes[chd.String()] {
+			return chd.Skeleton
+		}
+	}
+	return nil
+}
+
+// isStaticRemote returns true if a descriptor string matches a command line remote
+func (c *Client) isStaticRemote(key string) bool {
+	for _, chd := range c.config.shared.ChannelDescriptors {
+		if chd.String() == key {
+			return true
+		}
+	}
+	return false
+}
+
+// parseDynamicRemotes parses remote strings for remotes to be added at runtime. Duplicates
+// are dropped.
+func (c *Client) parseDynamicRemotes(chdStrings []string) ([]*ChannelDescriptor, error) {
+	seen := make(map[string]bool)
+	var chds []*ChannelDescriptor
+	for _, s := range chdStrings {
+		chd, err := c.remoteValues.ParseRemote(s)
+		if err != nil {
+			return nil, err
+		}
+		if chd.Stub.Type == ChannelEndpointTypeStdio {
+			return nil, fmt.Errorf("stdio remotes can only be given on the command line: '%s'", s)
+		}
+		key := chd.String()
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		chds = append(chds, chd)
+	}
+	return chds, nil
+}
+
+// addDynamicRemote records a remote added at runtime. The caller must hold remotesLock
+// (or have exclusive access to the client).
+func (c *Client) addDynamicRemote(chd *ChannelDescriptor, source RemoteSource, proxy *TCPProxy) {
+	key := chd.String()
+	c.dynamicRemotes[key] = &clientRemote{chd: chd, source: source, proxy: proxy}
+	c.dynamicRemoteKeys = append(c.dynamicRemoteKeys, key)
+}
+
+// removeDynamicRemote forgets a remote added at runtime, closing its local stub listener if it
+// has one. The caller must hold remotesLock.
+func (c *Client) removeDynamicRemote(key string) {
+	r, ok := c.dynamicRemotes[key]
+	if !ok {
+		return
+	}
+	delete(c.dynamicRemotes, key)
+	delete(c.boundAddrs, key)
+	delete(c.acceptedRemotes, key)
+	c.traffic.forget(key)
+	for i, k := range c.dynamicRemoteKeys {
+		if k == key {
+			c.dynamicRemoteKeys = append(c.dynamicRemoteKeys[:i], c.dynamicRemoteKeys[i+1:]...)
+			break
+		}
+	}
+	if r.proxy != nil {
+		r.proxy.Close()
+	}
+}
+
+// startRemoteProxy starts a local stub listener for a forward remote. Has no effect for reverse
+// remotes. The caller must hold remotesLock.
+func (c *Client) startRemoteProxy(ctx context.Context, r *clientRemote) error {
+	if r.chd.Reverse {
+		return nil
+	}
+	proxy := NewTCPProxy(c.Logger, c, c.nextProxyIndex, r.chd)
+	c.nextProxyIndex++
+	c.AddShutdownChild(proxy)
+	if err := proxy.Start(ctx); err != nil {
+		return err
+	}
+	r.proxy = proxy
+	return nil
+}
+
+// updateRemotes adds and removes remotes at runtime. If the client is connected, the changes are
+// first sent to the server as a dynamic channels request, and local changes are only made if the
+// server accepts them. If the client is not connected, the changes will be included in the session
+// config when it reconnects. The caller must hold remotesLock.
+func (c *Client) updateRemotes(
+	ctx context.Context,
+	added []*ChannelDescriptor,
+	removed []*ChannelDescriptor,
+	source RemoteSource,
+) error {
+	if len(added) == 0 && len(removed) == 0 {
+		return nil
+	}
+
+	if sshConn := c.getConnectedSSHConn(); sshConn != nil {
+		req := &DynamicChannelsRequest{
+			AddChannelDescriptors:    added,
+			RemoveChannelDescriptors: removed,
+			WantReply:                true,
+		}
+		payload, err := req.Marshal()
+		if err != nil {
+			return c.Errorf("Unable to serialize dynamic channels request: %s", err)
+		}
+		ok, reply, err := sshConn.SendRequest(DynamicChannelsRequestType, true, payload)
+		if err != nil {
+			// The connection has been lost; the changes will be sent with the
+			// session config when we reconnect
+			c.DLogf("Dynamic channels request failed, deferring to reconnect: %s", err)
+		} else if !ok {
+			return c.Errorf("Server rejected remotes update: %s", string(reply))
+		} else {
+			c.applyChannelsReply(added, reply)
+		}
+	}
+
+	for _, chd := range removed {
+		c.removeDynamicRemote(chd.String())
+	}
+
+	var startErr error
+	for _, chd := range added {
+		r := &clientRemote{chd: chd, source: source}
+		if err := c.startRemoteProxy(ctx, r); err != nil {
+			// leave it out so that it can be added again later
+			startErr = err
+			continue
+		}
+		c.addDynamicRemote(chd, source, r.proxy)
+	}
+
+	c.ILogf("Remotes updated: %d added, %d removed", len(added), len(removed))
+	return startErr
+}
+
+// applyFileRemotes brings the set of remotes loaded from the remotes file in line with a newly
+// loaded list of remote strings. Remotes that are already configured from another source are
+// left alone.
+func (c *Client) applyFileRemotes(ctx context.Context, chdStrings []string) error {
+	chds, err := c.parseDynamicRemotes(chdStrings)
+	if err != nil {
+		return err
+	}
+
+	c.remotesLock.Lock()
+	defer c.remotesLock.Unlock()
+
+	wanted := make(map[string]bool, len(chds))
+	var added, removed []*ChannelDescriptor
+	for _, chd := range chds {
+		key := chd.String()
+		wanted[key] = true
+		if _, ok := c.dynamicRemotes[key]; !ok && !c.isStaticRemote(key) {
+			added = append(added, chd)
+		}
+	}
+	for _, key := range c.dynamicRemoteKeys {
+		r := c.dynamicRemotes[key]
+		if r.source == RemoteSourceFile && !wanted[key] {
+			removed = append(removed, r.chd)
+		}
+	}
+	if len(added) == 0 && len(removed) == 0 {
+		c.DLogf("Remotes file changed, but remotes are unchanged")
+		return nil
+	}
+
+	return c.updateRemotes(ctx, added, removed, RemoteSourceFile)
+}
+
+// AddRemote adds a single remote to the running client
+func (c *Client) AddRemote(ctx context.Context, chdString string) error {
+	chds, err := c.parseDynamicRemotes([]string{chdString})
+	if err != nil {
+		return err
+	}
+	chd := chds[0]
+	key := chd.String()
+
+	c.remotesLock.Lock()
+	defer c.remotesLock.Unlock()
+
+	if _, ok := c.dynamicRemotes[key]; ok || c.isStaticRemote(key) {
+		return fmt.Errorf("Remote %s is already configured", key)
+	}
+	return c.updateRemotes(ctx, []*ChannelDescriptor{chd}, nil, RemoteSourceControl)
+}
+
+// RemoveRemote removes a single remote that was added at runtime from the running client.
+// Command line remotes cannot be removed.
+func (c *Client) RemoveRemote(ctx context.Context, chdString string) error {
+	chd, err := c.remoteValues.ParseRemote(chdString)
+	if err != nil {
+		return err
+	}
+	key := chd.String()
+
+	c.remotesLock.Lock()
+	defer c.remotesLock.Unlock()
+
+	r, ok := c.dynamicRemotes[key]
+	if !ok {
+		if c.isStaticRemote(key) {
+			return fmt.Errorf("Remote %s was given on the command line and cannot be removed", key)
+		}
+		return fmt.Errorf("Remote %s is not configured", key)
+	}
+	return c.updateRemotes(ctx, nil, []*ChannelDescriptor{r.chd}, r.source)
+}
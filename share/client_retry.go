@@ -0,0 +1,138 @@
+package chshare
+
+import (
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// ConnectErrorCode classifies why the client failed to connect to the server
+type ConnectErrorCode string
+
+const (
+	// ConnectErrorAuth is the server rejecting the client's credentials
+	ConnectErrorAuth ConnectErrorCode = "auth_rejected"
+
+	// ConnectErrorFingerprint is the server's key not matching --fingerprint
+	ConnectErrorFingerprint ConnectErrorCode = "fingerprint_mismatch"
+
+	// ConnectErrorTLS is the server's TLS certificate failing verification
+	ConnectErrorTLS ConnectErrorCode = "tls_verification"
+
+	// ConnectErrorProtocol is the server refusing the connection request outright, e.g.
+	// because it does not support the client's protocol version, or the URL is not a
+	// chisel server
+	ConnectErrorProtocol ConnectErrorCode = "protocol_unsupported"
+
+	// ConnectErrorConfig is the server rejecting the client's session configuration, e.g. a
+	// remote the user may not access
+	ConnectErrorConfig ConnectErrorCode = "config_rejected"
+
+	// ConnectErrorUnavailable is the server, or a proxy in front of it, being temporarily
+	// unable to accept the connection, e.g. while draining
+	ConnectErrorUnavailable ConnectErrorCode = "server_unavailable"
+
+	// ConnectErrorNetwork is any other failure to reach the server or complete the
+	// connection, e.g. an unreachable network or a connection reset
+	ConnectErrorNetwork ConnectErrorCode = "network"
+)
+
+// fatalConnectErrors are the codes of errors that retrying cannot fix, on which the client
+// exits instead of reconnecting
+var fatalConnectErrors = map[ConnectErrorCode]bool{
+	ConnectErrorAuth:        true,
+	ConnectErrorFingerprint: true,
+	ConnectErrorTLS:         true,
+	ConnectErrorProtocol:    true,
+	ConnectErrorConfig:      true,
+}
+
+// ConnectError is a classified failure to connect to the server
+type ConnectError struct {
+	Code ConnectErrorCode
+	Err  error
+}
+
+func (e *ConnectError) Error() string {
+	return fmt.Sprintf("%s (%s)", e.Err, e.Code)
+}
+
+func (e *ConnectError) Unwrap() error {
+	return e.Err
+}
+
+// Fatal returns true if retrying the connection cannot succeed
+func (e *ConnectError) Fatal() bool {
+	return fatalConnectErrors[e.Code]
+}
+
+// HTTPStatusError is a connection request refused by the server, or a proxy in front of
+// it, with an HTTP status
+type HTTPStatusError struct {
+	StatusCode int
+	Status     string
+
+	// Rejection is the server's reason for refusing the request, or nil if the response
+	// did not carry one
+	Rejection *UpgradeRejection
+
+	// RetryAfter is how long the response asked the client to wait before retrying, or 0
+	RetryAfter time.Duration
+}
+
+func (e *HTTPStatusError) Error() string {
+	if e.Rejection != nil {
+		return fmt.Sprintf("Server refused the connection: %s", e.Rejection)
+	}
+	return fmt.Sprintf("Server responded with %s", e.Status)
+}
+
+// retryAfterHint returns how long the server asked the client to wait before retrying a
+// failed connection, or 0 if it did not
+func retryAfterHint(err error) time.Duration {
+	var statusErr *HTTPStatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.RetryAfter
+	}
+	return 0
+}
+
+// errFingerprintMismatch is returned when the server's key does not match --fingerprint.
+// The SSH handshake error only carries its text.
+var errFingerprintMismatch = errors.New("Invalid fingerprint")
+
+// classifyConnectError classifies a failure to connect to the server
+func classifyConnectError(err error) *ConnectError {
+	var connectErr *ConnectError
+	if errors.As(err, &connectErr) {
+		return connectErr
+	}
+	code := ConnectErrorNetwork
+	var statusErr *HTTPStatusError
+	var unknownAuthority x509.UnknownAuthorityError
+	var hostnameErr x509.HostnameError
+	var certInvalid x509.CertificateInvalidError
+	switch {
+	case errors.As(err, &statusErr):
+		//a status other than these means the URL is not a chisel server that can accept
+		//the client's protocol, e.g. a web server answering 200 or 404
+		switch statusErr.StatusCode {
+		case http.StatusRequestTimeout, http.StatusGone, http.StatusTooManyRequests,
+			http.StatusInternalServerError, http.StatusBadGateway, http.StatusServiceUnavailable,
+			http.StatusGatewayTimeout:
+			code = ConnectErrorUnavailable
+		default:
+			code = ConnectErrorProtocol
+		}
+	case errors.As(err, &unknownAuthority), errors.As(err, &hostnameErr), errors.As(err, &certInvalid):
+		code = ConnectErrorTLS
+	case strings.Contains(err.Error(), "unable to authenticate"):
+		code = ConnectErrorAuth
+	case strings.Contains(err.Error(), errFingerprintMismatch.Error()):
+		code = ConnectErrorFingerprint
+	}
+	return &ConnectError{Code: code, Err: err}
+}
@@ -0,0 +1,70 @@
+package chshare
+
+import (
+	"context"
+)
+
+// remoteKeyForEndpoint returns the descriptor string of the remote whose local endpoint is
+// ced, or "" if there is none. If several reverse remotes share a skeleton endpoint, the
+// first is returned.
+func (c *Client) remoteKeyForEndpoint(ced *ChannelEndpointDescriptor) string {
+	target := ced.String()
+	matches := func(chd *ChannelDescriptor) bool {
+		local := chd.Stub
+		if chd.Reverse {
+			local = chd.Skeleton
+		}
+		return local.String() == target
+	}
+	c.remotesLock.Lock()
+	defer c.remotesLock.Unlock()
+	for _, chd := range c.config.shared.ChannelDescriptors {
+		if matches(chd) {
+			return chd.String()
+		}
+	}
+	for _, key := range c.dynamicRemoteKeys {
+		if matches(c.dynamicRemotes[key].chd) {
+			return key
+		}
+	}
+	return ""
+}
+
+// TrackChannel counts an open channel, with the local endpoint ced, towards the statistics of
+// the remote it belongs to
+func (c *Client) TrackChannel(ced *ChannelEndpointDescriptor, conn ChannelConn) func() {
+	key := c.remoteKeyForEndpoint(ced)
+	if key == "" {
+		return func() {}
+	}
+	return c.traffic.track(key, ced.Role == ChannelEndpointRoleSkeleton, conn)
+}
+
+// logRemoteStats logs one line of statistics for each configured remote, in the form:
+//
+//	stats remote="<descriptor>" active=<n> conns=<n> bytes_to_called=<n> bytes_to_caller=<n>
+//
+// where active is the number of open connections, conns the total number opened, and the
+// byte counts include the traffic of open connections so far
+func (c *Client) logRemoteStats() {
+	for _, remote := range c.Remotes() {
+		t := c.traffic.totals(remote.Descriptor)
+		c.ILogf("stats remote=%q active=%d conns=%d bytes_to_called=%d bytes_to_caller=%d",
+			remote.Descriptor, t.Active, t.Conns, t.BytesToCalled, t.BytesToCaller)
+	}
+}
+
+// statsLoop logs the statistics of each remote every StatsInterval until the client shuts down
+func (c *Client) statsLoop(ctx context.Context) {
+	for {
+		select {
+		case <-c.clock.After(c.config.StatsInterval):
+			c.logRemoteStats()
+		case <-c.ShutdownStartedChan():
+			return
+		case <-ctx.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,46 @@
+package chshare
+
+import (
+	"time"
+)
+
+// Clock is the source of the current time and of timers for the session, reconnection,
+// timeout and resumption logic of the client and server. Tests replace SystemClock with a
+// clock that they advance themselves (see chiseltest.FakeClock), so that this logic runs
+// deterministically. The deadlines of network connections are the exception: the runtime
+// compares them with the system's real time, so they are always set from it.
+type Clock interface {
+	// Now returns the current time
+	Now() time.Time
+
+	// After returns a chan that receives the current time once d has elapsed
+	After(d time.Duration) <-chan time.Time
+
+	// AfterFunc calls f in its own goroutine once d has elapsed, unless the returned timer is
+	// stopped first
+	AfterFunc(d time.Duration, f func()) ClockTimer
+}
+
+// ClockTimer is a timer started by Clock.AfterFunc
+type ClockTimer interface {
+	// Stop prevents the timer from firing, and returns false if it has already fired or
+	// been stopped
+	Stop() bool
+}
+
+// SystemClock is the Clock of the system's real time
+var SystemClock Clock = systemClock{}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+func (systemClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+func (systemClock) AfterFunc(d time.Duration, f func()) ClockTimer {
+	return time.AfterFunc(d, f)
+}
@@ -0,0 +1,180 @@
+package chshare
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// CloseReason identifies why a channel was closed
+type CloseReason string
+
+const (
+	// CloseReasonPeerEOF is a channel that ended normally, with both sides reaching end of
+	// stream
+	CloseReasonPeerEOF CloseReason = "peer_eof"
+
+	// CloseReasonError is a channel that failed with an I/O error
+	CloseReasonError CloseReason = "error"
+
+	// CloseReasonDialFailed is a channel whose skeleton could not connect to the Called Service
+	CloseReasonDialFailed CloseReason = "dial_failed"
+
+	// CloseReasonIdleTimeout is a channel closed by its stub after no traffic for the
+	// remote's "idle" timeout
+	CloseReasonIdleTimeout CloseReason = "idle_timeout"
+
+	// CloseReasonPolicyRevoked is a channel closed because its session was killed or evicted
+	CloseReasonPolicyRevoked CloseReason = "policy_revoked"
+
+	// CloseReasonServerDrain is a channel closed because the server is shutting down, or its
+	// session did not drain in time
+	CloseReasonServerDrain CloseReason = "server_drain"
+
+	// CloseReasonByteLimit is a channel closed because it transferred more than its
+	// remote's "max-bytes" limit, or its user's channel byte limit
+	CloseReasonByteLimit CloseReason = "byte_limit"
+)
+
+// ChannelCloseRequestType is the SSH channel request type with which a proxy tells the remote
+// proxy why it is closing a channel, just before closing it. The payload is a JSON
+// ChannelCloseInfo, and no reply is wanted. Proxies that do not know the request ignore it.
+const ChannelCloseRequestType = "close-reason"
+
+// ChannelCloseInfo is the structured reason for closing a channel
+type ChannelCloseInfo struct {
+	Reason CloseReason `json:"reason"`
+
+	// Message is a human-readable description, e.g. the dial error
+	Message string `json:"message,omitempty"`
+}
+
+func (i *ChannelCloseInfo) String() string {
+	if i.Message == "" {
+		return string(i.Reason)
+	}
+	return fmt.Sprintf("%s: %s", i.Reason, i.Message)
+}
+
+// validateIdleOption validates the value of the "idle" descriptor option
+func validateIdleOption(value string) error {
+	_, err := parseIdleOption(value)
+	return err
+}
+
+// parseIdleOption parses the value of the "idle" descriptor option
+func parseIdleOption(value string) (time.Duration, error) {
+	d, err := time.ParseDuration(value)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("Invalid idle timeout '%s'; must be a positive duration", value)
+	}
+	return d, nil
+}
+
+// udpDefaultIdleTimeout is the idle timeout of a UDP endpoint's channels without an "idle"
+// option. A UDP peer never closes its flow, so its channel ends when it goes quiet.
+const udpDefaultIdleTimeout = 60 * time.Second
+
+// EndpointIdleTimeout returns the idle timeout of an endpoint's channels, or 0 for none
+func EndpointIdleTimeout(ced *ChannelEndpointDescriptor) time.Duration {
+	if ced.Option("idle") == "" && ced.Type == ChannelEndpointTypeUDP {
+		return udpDefaultIdleTimeout
+	}
+	d, _ := parseIdleOption(ced.Option("idle"))
+	return d
+}
+
+// closeWhenIdle closes both sides of a channel, with CloseReasonIdleTimeout, once no bytes
+// have been read from or written to the local side for the timeout, as measured by clock. It
+// returns when ctx is done.
+func closeWhenIdle(ctx context.Context, logger Logger, clock Clock, timeout time.Duration, local ChannelConn, remote ChannelConn) {
+	lastActive := clock.Now()
+	lastCount := int64(-1)
+	for {
+		select {
+		case <-clock.After(timeout / 4):
+		case <-ctx.Done():
+			return
+		}
+		count := local.GetNumBytesRead() + local.GetNumBytesWritten()
+		if count != lastCount {
+			lastCount = count
+			lastActive = clock.Now()
+			continue
+		}
+		if clock.Now().Sub(lastActive) >= timeout {
+			logger.DLogf("No traffic for %s; closing channel", timeout)
+			info := &ChannelCloseInfo{Reason: CloseReasonIdleTimeout, Message: fmt.Sprintf("No traffic for %s", timeout)}
+			remote.SetCloseReason(info)
+			local.SetCloseReason(info)
+			remote.Close()
+			local.Close()
+			return
+		}
+	}
+}
+
+// closeOnDialFailure records that the skeleton could not connect to the Called Service, and
+// closes callerConn, so that the remote proxy learns why its channel was closed
+func closeOnDialFailure(callerConn ChannelConn, err error) {
+	callerConn.SetCloseReason(&ChannelCloseInfo{Reason: CloseReasonDialFailed, Message: err.Error()})
+	callerConn.Close()
+}
+
+// logChannelDialFailure logs and counts a channel whose skeleton could not connect to the
+// Called Service, and that was therefore rejected rather than accepted
+func logChannelDialFailure(ctx context.Context, logger Logger, stats *StatsRegistry, err error) {
+	logChannelClose(ctx, logger, stats, &ChannelCloseInfo{Reason: CloseReasonDialFailed, Message: err.Error()}, 0, 0)
+}
+
+// handleChannelRequests handles the requests the remote proxy sends on an SSH channel,
+// recording the reason it gives for closing the channel. It returns when the channel is closed.
+func (c *SSHConn) handleChannelRequests(reqs <-chan *ssh.Request) {
+	for req := range reqs {
+		if req.Type != ChannelCloseRequestType {
+			if req.WantReply {
+				req.Reply(false, nil)
+			}
+			continue
+		}
+		info := &ChannelCloseInfo{}
+		if err := json.Unmarshal(req.Payload, info); err != nil || info.Reason == "" {
+			c.DLogf("Ignoring invalid channel close reason: %q", req.Payload)
+			continue
+		}
+		c.Lock.Lock()
+		if c.remoteCloseInfo == nil {
+			c.remoteCloseInfo = info
+		}
+		c.Lock.Unlock()
+	}
+}
+
+// channelCloseInfo returns the reason a channel ended, given the reason recorded on its
+// connection to the remote proxy, if any, and the error the bridge ended with
+func channelCloseInfo(recorded *ChannelCloseInfo, err error) *ChannelCloseInfo {
+	if recorded != nil {
+		return recorded
+	}
+	if err != nil {
+		return &ChannelCloseInfo{Reason: CloseReasonError, Message: err.Error()}
+	}
+	return &ChannelCloseInfo{Reason: CloseReasonPeerEOF}
+}
+
+// logChannelClose logs the end of a channel, and counts it by reason. Channels that ended
+// normally are only logged at debug level.
+func logChannelClose(ctx context.Context, logger Logger, stats *StatsRegistry, info *ChannelCloseInfo, callerToService int64, serviceToCaller int64) {
+	stats.Counter(
+		"chisel_channel_closes_total",
+		"Number of channels closed, by reason",
+		StatLabels{"reason": string(info.Reason)}).Inc()
+	if info.Reason == CloseReasonPeerEOF {
+		logger.DLogf("%sChannel closed (%s) after %d bytes (caller->called), %d bytes (called->caller)", requestLogPrefix(ctx), info, callerToService, serviceToCaller)
+	} else {
+		logger.ILogf("%sChannel closed (%s) after %d bytes (caller->called), %d bytes (called->caller)", requestLogPrefix(ctx), info, callerToService, serviceToCaller)
+	}
+}
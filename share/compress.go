@@ -0,0 +1,157 @@
+package chshare
+
+import (
+	"compress/flate"
+	"fmt"
+	"io"
+	"regexp"
+	"sync"
+)
+
+// Channel compression compresses the payload of a channel between the two proxies, so that
+// compressible streams such as logs and JSON APIs use less bandwidth. It is enabled per
+// remote with the "compress" option, which names the algorithm; already-compressed streams
+// (video, TLS) are best left with the default, "off", which saves the CPU cost. The stub
+// offers the algorithm in the skeleton's descriptor, which is sent as the channel's extra
+// data, and the skeleton answers before any payload with the algorithm it will use:
+//
+//	<1-byte length><algorithm name>
+//
+// A skeleton that does not support the offered algorithm answers "off", and the channel is
+// sent uncompressed. Compressed data is flushed on every write, so that interactive traffic
+// is not held back. Loop endpoints relay the compressed stream untouched.
+
+// Compression algorithms of the "compress" option
+const (
+	CompressOff     = "off"
+	CompressDeflate = "deflate"
+)
+
+// compressAlgorithmRegexp matches a compression algorithm name. Names that the local proxy
+// does not support are accepted from the remote proxy, so that it can offer algorithms that
+// are added later.
+var compressAlgorithmRegexp = regexp.MustCompile(`^[a-z0-9-]{1,32}$`)
+
+// validateCompressOption validates the value of the "compress" descriptor option
+func validateCompressOption(value string) error {
+	if !compressAlgorithmRegexp.MatchString(value) {
+		return fmt.Errorf("Invalid compression algorithm '%s'; must be deflate or off", value)
+	}
+	return nil
+}
+
+// isSupportedCompression returns true if the local proxy implements a compression algorithm
+func isSupportedCompression(alg string) bool {
+	return alg == CompressDeflate
+}
+
+// checkCompressOption returns an error if a channel's stub would offer a compression
+// algorithm that the local proxy does not support
+func checkCompressOption(d *ChannelDescriptor) error {
+	alg := d.Stub.Option("compress")
+	if alg != "" && alg != CompressOff && !isSupportedCompression(alg) {
+		return fmt.Errorf("%s: Unsupported compression algorithm '%s'; must be deflate or off", d.String(), alg)
+	}
+	return nil
+}
+
+// endpointUsesCompression returns true if the local proxy must negotiate compression of the
+// payload of channels to or from an endpoint, rather than relaying it
+func endpointUsesCompression(ced *ChannelEndpointDescriptor) bool {
+	alg := ced.Option("compress")
+	return alg != "" && alg != CompressOff && ced.Type != ChannelEndpointTypeLoop
+}
+
+// WrapCompressChannelConn negotiates compression over conn if the endpoint offers it, and
+// returns a ChannelConn that compresses and decompresses the payload with the agreed
+// algorithm. Otherwise, or if the skeleton declines, conn is returned unchanged.
+func WrapCompressChannelConn(ced *ChannelEndpointDescriptor, conn ChannelConn) (ChannelConn, error) {
+	if !endpointUsesCompression(ced) {
+		return conn, nil
+	}
+	offered := ced.Option("compress")
+	var alg string
+	if ced.Role == ChannelEndpointRoleStub {
+		var length [1]byte
+		if _, err := io.ReadFull(conn, length[:]); err != nil {
+			return nil, fmt.Errorf("Compression negotiation failed: %s", err)
+		}
+		name := make([]byte, length[0])
+		if _, err := io.ReadFull(conn, name); err != nil {
+			return nil, fmt.Errorf("Compression negotiation failed: %s", err)
+		}
+		alg = string(name)
+		if alg != offered && alg != CompressOff {
+			return nil, fmt.Errorf("Compression negotiation failed; the remote proxy chose '%s', which was not offered", alg)
+		}
+	} else {
+		alg = CompressOff
+		if isSupportedCompression(offered) {
+			alg = offered
+		}
+		reply := append([]byte{byte(len(alg))}, alg...)
+		if _, err := conn.Write(reply); err != nil {
+			return nil, fmt.Errorf("Compression negotiation failed: %s", err)
+		}
+	}
+	if alg == CompressOff {
+		return conn, nil
+	}
+	return newDeflateConn(conn)
+}
+
+// deflateConn is a ChannelConn whose payload is compressed with deflate over another
+// ChannelConn. The byte counts of the underlying ChannelConn are of the compressed stream.
+type deflateConn struct {
+	ChannelConn
+
+	writeLock sync.Mutex
+	w         *flate.Writer
+	r         io.ReadCloser
+}
+
+func newDeflateConn(conn ChannelConn) (*deflateConn, error) {
+	w, err := flate.NewWriter(conn, flate.BestSpeed)
+	if err != nil {
+		return nil, err
+	}
+	return &deflateConn{ChannelConn: conn, w: w, r: flate.NewReader(conn)}, nil
+}
+
+// Write compresses p and flushes it to the underlying ChannelConn
+func (c *deflateConn) Write(p []byte) (int, error) {
+	c.writeLock.Lock()
+	defer c.writeLock.Unlock()
+	n, err := c.w.Write(p)
+	if err == nil {
+		err = c.w.Flush()
+	}
+	return n, err
+}
+
+// CloseWrite ends the compressed stream, then shuts down the writing half of the underlying
+// ChannelConn
+func (c *deflateConn) CloseWrite() error {
+	c.writeLock.Lock()
+	err := c.w.Close()
+	c.writeLock.Unlock()
+	if err != nil {
+		return err
+	}
+	return c.ChannelConn.CloseWrite()
+}
+
+// Read returns decompressed payload. Since every write is flushed, a stream that ends
+// without the final block, because the peer closed rather than half-closed the channel,
+// ends at a write boundary and is treated as ending normally.
+func (c *deflateConn) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	if err == io.ErrUnexpectedEOF {
+		err = io.EOF
+	}
+	return n, err
+}
+
+func (c *deflateConn) String() string {
+	return fmt.Sprintf("deflate:%s", c.ChannelConn)
+}
@@ -0,0 +1,144 @@
+package chshare
+
+import (
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// redactedValue replaces the values of secret settings in configuration dumps
+const redactedValue = "<redacted>"
+
+// writeConfigFields writes the exported fields of a configuration struct to w, one
+// "<name>: <value>" line each, leaving out fields that are not set. The values of the fields
+// named in secrets are redacted, and hooks such as ChannelTap are only shown as set. Fields
+// that are pointers to structs, such as TLS, are written as "<name>.<field>" lines.
+func writeConfigFields(w io.Writer, prefix string, config interface{}, secrets ...string) error {
+	v := reflect.Indirect(reflect.ValueOf(config))
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.PkgPath != "" {
+			continue
+		}
+		fv := v.Field(i)
+		if fv.IsZero() || (fv.Kind() == reflect.Map || fv.Kind() == reflect.Slice) && fv.Len() == 0 {
+			continue
+		}
+		name := prefix + field.Name
+		var value interface{} = fv.Interface()
+		switch {
+		case stringInSlice(field.Name, secrets):
+			value = redactedValue
+		case fv.Kind() == reflect.Ptr && fv.Elem().Kind() == reflect.Struct:
+			if err := writeConfigFields(w, name+".", fv.Interface(), secrets...); err != nil {
+				return err
+			}
+			continue
+		case fv.Kind() == reflect.Interface || fv.Kind() == reflect.Func:
+			value = "set"
+		}
+		if _, err := fmt.Fprintf(w, "%s: %v\n", name, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func stringInSlice(s string, list []string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// redactAuth redacts the password of a "<user>:<pass>" setting
+func redactAuth(auth string) string {
+	if auth == "" {
+		return ""
+	}
+	user, _ := ParseAuth(auth)
+	return user + ":" + redactedValue
+}
+
+// DumpConfig writes the server's configuration to w, after defaults have been applied and
+// all files have been loaded, with secrets redacted. It is followed by the server's key
+// fingerprint and the users it knows of, with their access lists but without passwords.
+func (s *Server) DumpConfig(w io.Writer) error {
+	config := *s.config
+	config.Auth = redactAuth(config.Auth)
+	if err := writeConfigFields(w, "", &config, "KeySeed", "AdminToken", "StatusToken"); err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(w, "Fingerprint: %s\n", s.fingerprint); err != nil {
+		return err
+	}
+	for _, user := range s.users.All() {
+		var addrs []string
+		for _, addr := range user.Addrs {
+			if addr == UserAllowAll {
+				addrs = append(addrs, "*")
+			} else {
+				addrs = append(addrs, addr.String())
+			}
+		}
+		if _, err := fmt.Fprintf(w, "User %s: %s\n", user.Name, strings.Join(addrs, " ")); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DumpConfig writes the client's configuration to w, after defaults have been applied and
+// all files have been loaded, with secrets and the values of extra headers redacted. The
+// remotes are written as normalized descriptors, with their options, along with where each
+// came from.
+func (c *Client) DumpConfig(w io.Writer) error {
+	config := *c.config
+	config.Auth = redactAuth(config.Auth)
+	config.ChdStrings = nil
+	config.Headers = nil
+	for name := range c.headers {
+		config.Headers = append(config.Headers, name+": "+redactedValue)
+	}
+	sort.Strings(config.Headers)
+	if err := writeConfigFields(w, "", &config, "E2EKeySeed"); err != nil {
+		return err
+	}
+	c.remotesLock.Lock()
+	defer c.remotesLock.Unlock()
+	for _, chd := range c.allRemotes() {
+		source := RemoteSourceCommandLine
+		if remote, ok := c.dynamicRemotes[chd.String()]; ok {
+			source = remote.source
+		}
+		if _, err := fmt.Fprintf(w, "Remote: %s (%s)\n", descriptorWithOptions(chd), source); err != nil {
+			return err
+		}
+	}
+	for _, r := range c.portRanges {
+		if _, err := fmt.Fprintf(w, "Remote: %s%s (%s, on demand)\n", r.String(), r.options, RemoteSourceCommandLine); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// descriptorWithOptions returns a channel descriptor string followed by the options of its
+// endpoints, if any
+func descriptorWithOptions(chd *ChannelDescriptor) string {
+	options := make(map[string]string)
+	for _, ep := range []*ChannelEndpointDescriptor{chd.Stub, chd.Skeleton} {
+		for k, v := range ep.Options {
+			options[k] = v
+		}
+	}
+	if len(options) == 0 {
+		return chd.String()
+	}
+	return chd.String() + "?" + FormatDescriptorOptions(options)
+}
@@ -1,73 +1,319 @@
 package chshare
 
 import (
+	"fmt"
+	"io"
 	"log"
 	"net"
+	"sync"
 	"time"
 
 	"github.com/gorilla/websocket"
 )
 
+const (
+	// wsWriteTimeout is how long a websocket message may take to send before the remote is
+	// deemed stalled and the connection is failed
+	wsWriteTimeout = 30 * time.Second
+
+	// wsCloseFlushTimeout is how long closing a websocket connection waits for queued
+	// messages to be sent
+	wsCloseFlushTimeout = time.Second
+
+	// wsMaxQueued is the amount of unsent data above which writes to a websocket wait
+	wsMaxQueued = 256 * 1024
+
+	// wsMinMaxMessage is the smallest limit that may be set on the size of websocket
+	// messages
+	wsMinMaxMessage = 512
+
+	// wsMinMessageBuffer is the smallest buffer allocated for a message, large enough for
+	// the SSH transport's usual writes, so that any buffer kept for reuse suits most messages
+	wsMinMessageBuffer = 8 * 1024
+
+	// wsMaxMessageBuffer is the largest buffer kept for reuse once its message is sent
+	wsMaxMessageBuffer = 64 * 1024
+)
+
+// wsMessageBuffers holds the buffers of sent messages for reuse by the writes of any websocket
+// connection, so that queueing a write does not allocate. Being a sync.Pool, it releases the
+// buffers that go unused, e.g. once the connections are idle.
+var wsMessageBuffers = sync.Pool{
+	New: func() interface{} {
+		msg := make([]byte, 0, wsMinMessageBuffer)
+		return &msg
+	},
+}
+
+// wsConn is a net.Conn over a websocket. Writes are queued, up to wsMaxQueued bytes, and
+// sent by a writer goroutine, each message with a deadline of wsWriteTimeout. A full queue
+// makes writes wait, which pushes back on the SSH channels writing to the connection, and a
+// message that misses its deadline fails the connection, so that a stalled remote ends the
+// session instead of holding its writes forever.
 type wsConn struct {
 	*websocket.Conn
-	buff []byte
+
+	// reader is the rest of the message being read, or nil if there is none
+	reader io.Reader
+
+	// maxWrite is the largest write sent in a single message, or 0 for no limit
+	maxWrite int
+
+	// lock protects batchClock, batchDelay, queue, queued, writeErr and closing
+	lock sync.Mutex
+	cond *sync.Cond
+
+	// batchDelay, if not 0, is how long a small message waits for more writes to be
+	// coalesced into it before it is sent, as measured by batchClock
+	batchClock Clock
+	batchDelay time.Duration
+
+	// queue holds the messages waiting to be sent, from wsMessageBuffers, and queued their
+	// total size
+	queue  []*[]byte
+	queued int
+
+	// writeErr is the error that failed the connection's writes, if any
+	writeErr error
+
+	// closing is true once Close has been called
+	closing bool
+
+	// writerDone is closed when the writer goroutine has finished
+	writerDone chan struct{}
+
+	closeOnce sync.Once
+	closeErr  error
 }
 
 // NewWebSocketConn wraps a websocket.Conn to look like a net.Conn
 func NewWebSocketConn(websocketConn *websocket.Conn) net.Conn {
-	c := wsConn{
-		Conn: websocketConn,
+	c := &wsConn{
+		Conn:       websocketConn,
+		writerDone: make(chan struct{}),
 	}
-	return &c
+	c.cond = sync.NewCond(&c.lock)
+	go c.writeLoop()
+	return c
 }
 
 //Read is not threadsafe though thats okay since there
-//should never be more than one reader
+//should never be more than one reader. Messages are read
+//straight into dst, rather than buffered whole.
 func (c *wsConn) Read(dst []byte) (int, error) {
-	ldst := len(dst)
-	//use buffer or read new message
-	var src []byte
-	if l := len(c.buff); l > 0 {
-		src = c.buff
-		c.buff = nil
-	} else {
-		t, msg, err := c.Conn.ReadMessage()
-		if err != nil {
-			return 0, err
-		} else if t != websocket.BinaryMessage {
-			log.Printf("<WARNING> non-binary msg")
+	for {
+		if c.reader == nil {
+			t, r, err := c.Conn.NextReader()
+			if err != nil {
+				return 0, err
+			} else if t != websocket.BinaryMessage {
+				log.Printf("<WARNING> non-binary msg")
+			}
+			c.reader = r
+		}
+		n, err := c.reader.Read(dst)
+		if err == io.EOF {
+			c.reader = nil
+			err = nil
+		}
+		if n > 0 || err != nil || len(dst) == 0 {
+			return n, err
 		}
-		src = msg
-	}
-	//copy src->dest
-	var n int
-	if len(src) > ldst {
-		//copy as much as possible of src into dst
-		n = copy(dst, src[:ldst])
-		//copy remainder into buffer
-		r := src[ldst:]
-		lr := len(r)
-		c.buff = make([]byte, lr)
-		copy(c.buff, r)
-	} else {
-		//copy all of src into dst
-		n = copy(dst, src)
 	}
-	//return bytes copied
-	return n, nil
 }
 
+// Write queues b to be sent, waiting while too much data is already queued. It fails if an
+// earlier write has failed.
 func (c *wsConn) Write(b []byte) (int, error) {
-	if err := c.Conn.WriteMessage(websocket.BinaryMessage, b); err != nil {
-		return 0, err
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	n := 0
+	for n < len(b) {
+		for c.queued >= wsMaxQueued && c.writeErr == nil && !c.closing {
+			c.cond.Wait()
+		}
+		if c.writeErr != nil {
+			return n, c.writeErr
+		}
+		if c.closing {
+			return n, io.ErrClosedPipe
+		}
+		size := len(b) - n
+		if c.maxWrite > 0 && size > c.maxWrite {
+			size = c.maxWrite
+		}
+		if last := len(c.queue) - 1; c.batchDelay > 0 && last >= 0 && len(*c.queue[last])+size <= c.batchSize() {
+			*c.queue[last] = append(*c.queue[last], b[n:n+size]...)
+			c.queued += size
+			n += size
+			c.cond.Broadcast()
+			continue
+		}
+		msg := newWSMessage(size)
+		*msg = append(*msg, b[n:n+size]...)
+		c.queue = append(c.queue, msg)
+		c.queued += size
+		n += size
+		c.cond.Broadcast()
 	}
-	n := len(b)
 	return n, nil
 }
 
-func (c *wsConn) SetDeadline(t time.Time) error {
-	if err := c.Conn.SetReadDeadline(t); err != nil {
-		return err
+// newWSMessage returns an empty buffer from wsMessageBuffers for a message of size bytes. A
+// buffer too small for the message is replaced by one of a power of two that is large enough.
+func newWSMessage(size int) *[]byte {
+	msg := wsMessageBuffers.Get().(*[]byte)
+	if cap(*msg) < size {
+		capacity := wsMinMessageBuffer
+		for capacity < size {
+			capacity *= 2
+		}
+		*msg = make([]byte, 0, capacity)
+	}
+	return msg
+}
+
+// freeWSMessage returns the buffer of a sent message to wsMessageBuffers, unless it is too
+// large to keep
+func freeWSMessage(msg *[]byte) {
+	if cap(*msg) <= wsMaxMessageBuffer {
+		*msg = (*msg)[:0]
+		wsMessageBuffers.Put(msg)
 	}
-	return c.Conn.SetWriteDeadline(t)
+}
+
+// writeLoop sends queued messages until the connection is closed or a write fails. Once the
+// connection is closing, the messages already queued are sent within wsCloseFlushTimeout.
+func (c *wsConn) writeLoop() {
+	defer close(c.writerDone)
+	var flushDeadline time.Time
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	for {
+		for len(c.queue) == 0 && !c.closing {
+			c.cond.Wait()
+		}
+		if len(c.queue) == 0 {
+			return
+		}
+		c.waitForBatch()
+		msg := c.queue[0]
+		c.queue = c.queue[1:]
+		deadline := time.Now().Add(wsWriteTimeout)
+		if c.closing {
+			if flushDeadline.IsZero() {
+				flushDeadline = time.Now().Add(wsCloseFlushTimeout)
+			}
+			deadline = flushDeadline
+		}
+		c.lock.Unlock()
+		c.Conn.SetWriteDeadline(deadline)
+		err := c.Conn.WriteMessage(websocket.BinaryMessage, *msg)
+		c.lock.Lock()
+		c.queued -= len(*msg)
+		freeWSMessage(msg)
+		c.cond.Broadcast()
+		if err != nil {
+			if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+				err = fmt.Errorf("Websocket write timed out; the remote is not reading: %s", err)
+			}
+			c.writeErr = err
+			c.queue = nil
+			c.queued = 0
+			// unblock the reader too, so that the session notices the failure
+			c.Conn.Close()
+			return
+		}
+	}
+}
+
+// batchSize returns the size up to which small writes are coalesced into a message
+func (c *wsConn) batchSize() int {
+	if c.maxWrite > 0 {
+		return c.maxWrite
+	}
+	return wsWriteBufferSize
+}
+
+// waitForBatch waits up to batchDelay for a single small queued message to be joined by
+// more writes, so that chatty protocols send fewer, larger messages. The lock must be held.
+func (c *wsConn) waitForBatch() {
+	if c.batchDelay <= 0 || c.closing || len(c.queue) != 1 || len(*c.queue[0]) >= c.batchSize() {
+		return
+	}
+	expired := false
+	timer := c.batchClock.AfterFunc(c.batchDelay, func() {
+		c.lock.Lock()
+		expired = true
+		c.cond.Broadcast()
+		c.lock.Unlock()
+	})
+	for !expired && !c.closing && len(c.queue) == 1 && len(*c.queue[0]) < c.batchSize() {
+		c.cond.Wait()
+	}
+	timer.Stop()
+}
+
+// SetTransportBatchDelay has a websocket transport wait up to delay to coalesce small writes
+// into fewer messages, trading a little latency for less per-message overhead. The wait is
+// measured by clock. Other transports are left alone.
+func SetTransportBatchDelay(transport net.Conn, clock Clock, delay time.Duration) {
+	ws, ok := transport.(*wsConn)
+	if !ok {
+		return
+	}
+	ws.lock.Lock()
+	ws.batchClock = clock
+	ws.batchDelay = delay
+	ws.lock.Unlock()
+}
+
+// SetTransportMaxMessage limits the websocket messages a websocket transport sends to size
+// bytes, for intermediaries that refuse larger messages or frames. Larger writes, such as
+// large SSH packets, are split across several messages. Each message is sent as a single
+// frame by the server, and in frames of at most wsWriteBufferSize by the client. Other
+// transports are left alone.
+func SetTransportMaxMessage(transport net.Conn, size int) {
+	ws, ok := transport.(*wsConn)
+	if !ok || size <= 0 {
+		return
+	}
+	ws.lock.Lock()
+	if ws.maxWrite == 0 || ws.maxWrite > size {
+		ws.maxWrite = size
+	}
+	ws.lock.Unlock()
+}
+
+// checkMaxMessage returns an error if size is too small a limit on the size of websocket
+// messages
+func checkMaxMessage(size int64) error {
+	if size != 0 && size < wsMinMaxMessage {
+		return fmt.Errorf("Invalid websocket message size limit %d; must be at least %d", size, wsMinMaxMessage)
+	}
+	return nil
+}
+
+// Close sends the queued messages, waiting up to wsCloseFlushTimeout, then closes the
+// websocket
+func (c *wsConn) Close() error {
+	c.closeOnce.Do(func() {
+		c.lock.Lock()
+		c.closing = true
+		c.cond.Broadcast()
+		c.lock.Unlock()
+		<-c.writerDone
+		c.closeErr = c.Conn.Close()
+	})
+	return c.closeErr
+}
+
+// SetDeadline sets the read deadline only; write deadlines are managed by the connection
+func (c *wsConn) SetDeadline(t time.Time) error {
+	return c.Conn.SetReadDeadline(t)
+}
+
+// SetWriteDeadline does nothing; each queued message is sent with a deadline of wsWriteTimeout
+func (c *wsConn) SetWriteDeadline(t time.Time) error {
+	return nil
 }
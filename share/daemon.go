@@ -0,0 +1,86 @@
+//+build !windows
+
+package chshare
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+// daemonEnv is set in the environment of a daemon started by StartDaemon
+const daemonEnv = "CHISEL_DAEMON"
+
+// daemonReadyFd is the file descriptor on which a daemon started by StartDaemon reports that
+// it is running, by writing daemonReadyMessage and closing it
+const daemonReadyFd = 3
+
+const daemonReadyMessage = "ready"
+
+// IsDaemon returns true if this process is a daemon started by StartDaemon
+func IsDaemon() bool {
+	return os.Getenv(daemonEnv) == "1"
+}
+
+// StartDaemon runs this program again, with the same arguments, as a daemon in a new session
+// detached from the terminal, and waits until it reports with DaemonReady that it is running.
+// Until then, the daemon's output goes to this process's stdout and stderr, so that startup
+// errors are seen. Returns the daemon's process ID.
+func StartDaemon() (int, error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return 0, fmt.Errorf("Unable to find executable: %s", err)
+	}
+	r, w, err := os.Pipe()
+	if err != nil {
+		return 0, err
+	}
+	defer r.Close()
+	cmd := exec.Command(exe, os.Args[1:]...)
+	cmd.Env = append(os.Environ(), daemonEnv+"=1")
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.ExtraFiles = []*os.File{w}
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+	err = cmd.Start()
+	w.Close()
+	if err != nil {
+		return 0, fmt.Errorf("Unable to start daemon: %s", err)
+	}
+	// reading ends when the daemon closes the pipe, or exits
+	status, _ := ioutil.ReadAll(r)
+	if string(status) != daemonReadyMessage {
+		if err := cmd.Wait(); err != nil {
+			return 0, fmt.Errorf("Daemon failed to start: %s", err)
+		}
+		return 0, fmt.Errorf("Daemon exited during startup")
+	}
+	pid := cmd.Process.Pid
+	cmd.Process.Release()
+	return pid, nil
+}
+
+// DaemonReady reports to the process that started this daemon that it is running, and
+// redirects the daemon's standard input and output to /dev/null, so that it no longer
+// writes to that process's terminal. Does nothing if this process is not a daemon.
+func DaemonReady() error {
+	if !IsDaemon() {
+		return nil
+	}
+	devNull, err := os.OpenFile(os.DevNull, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	defer devNull.Close()
+	for fd := 0; fd <= 2; fd++ {
+		if err := dupFd(int(devNull.Fd()), fd); err != nil {
+			return fmt.Errorf("Unable to redirect file descriptor %d: %s", fd, err)
+		}
+	}
+	ready := os.NewFile(daemonReadyFd, "daemon-ready")
+	defer ready.Close()
+	_, err = ready.Write([]byte(daemonReadyMessage))
+	return err
+}
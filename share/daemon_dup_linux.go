@@ -0,0 +1,11 @@
+//+build linux
+
+package chshare
+
+import "syscall"
+
+// dupFd makes newfd a copy of oldfd, closing newfd first if it is open. Linux on some
+// architectures only has dup3.
+func dupFd(oldfd int, newfd int) error {
+	return syscall.Dup3(oldfd, newfd, 0)
+}
@@ -0,0 +1,10 @@
+//+build !linux,!windows
+
+package chshare
+
+import "syscall"
+
+// dupFd makes newfd a copy of oldfd, closing newfd first if it is open
+func dupFd(oldfd int, newfd int) error {
+	return syscall.Dup2(oldfd, newfd)
+}
@@ -0,0 +1,20 @@
+//+build windows
+
+package chshare
+
+import "fmt"
+
+// IsDaemon returns false; daemons are not supported on Windows
+func IsDaemon() bool {
+	return false
+}
+
+// StartDaemon is not supported on Windows, where a service manager should be used instead
+func StartDaemon() (int, error) {
+	return 0, fmt.Errorf("Daemon mode is not supported on Windows")
+}
+
+// DaemonReady does nothing on Windows
+func DaemonReady() error {
+	return nil
+}
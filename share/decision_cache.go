@@ -0,0 +1,118 @@
+package chshare
+
+import (
+	"sync"
+	"time"
+)
+
+// DefaultDecisionCacheTTL is the default time for which access decisions are cached
+const DefaultDecisionCacheTTL = 30 * time.Second
+
+// decisionCacheMaxEntries is the number of decisions a DecisionCache holds before it drops
+// them, so that a client opening channels to many distinct destinations cannot grow it
+// without bound
+const decisionCacheMaxEntries = 10000
+
+// Kinds of decisions held by a DecisionCache, which label its metrics
+const (
+	decisionKindACL    = "acl"
+	decisionKindPolicy = "policy"
+)
+
+// decisionCacheEntry is a cached decision, and when it expires
+type decisionCacheEntry struct {
+	allowed bool
+	expiry  time.Time
+}
+
+// DecisionCache caches the access list and channel policy decisions made as clients open
+// channels, by kind and by a key naming everything the decision depends on, such as the user
+// and the channel's descriptor, so that clients opening channels at a high rate do not pay
+// for the decision each time. Decisions expire after a TTL, and the server flushes the cache
+// when its users change or its channel policy is reloaded. Errors are not cached.
+type DecisionCache struct {
+	ttl   time.Duration
+	clock Clock
+
+	// hitsStats and missesStats count the hits and misses of each kind of decision
+	hitsStats   map[string]*Stat
+	missesStats map[string]*Stat
+
+	// lock protects entries
+	lock    sync.Mutex
+	entries map[string]decisionCacheEntry
+}
+
+// NewDecisionCache creates a new DecisionCache with the given TTL, or returns nil, which
+// caches nothing, if the TTL is not positive
+func NewDecisionCache(stats *StatsRegistry, clock Clock, ttl time.Duration) *DecisionCache {
+	if ttl <= 0 {
+		return nil
+	}
+	c := &DecisionCache{
+		ttl:         ttl,
+		clock:       clock,
+		hitsStats:   make(map[string]*Stat),
+		missesStats: make(map[string]*Stat),
+		entries:     make(map[string]decisionCacheEntry),
+	}
+	for _, kind := range []string{decisionKindACL, decisionKindPolicy} {
+		c.hitsStats[kind] = stats.Counter(
+			"chisel_decision_cache_hits_total",
+			"Number of access decisions answered from the decision cache",
+			StatLabels{"kind": kind})
+		c.missesStats[kind] = stats.Counter(
+			"chisel_decision_cache_misses_total",
+			"Number of access decisions that had to be made because they were not in the decision cache",
+			StatLabels{"kind": kind})
+	}
+	return c
+}
+
+// Decide returns the cached decision of a kind (decisionKindACL or decisionKindPolicy) for
+// key, or else calls decide, and caches its
+// result if it does not fail. A nil DecisionCache calls decide every time.
+func (c *DecisionCache) Decide(kind string, key string, decide func() (bool, error)) (bool, error) {
+	if c == nil {
+		return decide()
+	}
+	key = kind + "\x00" + key
+	now := c.clock.Now()
+	c.lock.Lock()
+	entry, ok := c.entries[key]
+	c.lock.Unlock()
+	if ok && now.Before(entry.expiry) {
+		c.hitsStats[kind].Inc()
+		return entry.allowed, nil
+	}
+	c.missesStats[kind].Inc()
+	allowed, err := decide()
+	if err != nil {
+		return allowed, err
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if len(c.entries) >= decisionCacheMaxEntries {
+		for k, e := range c.entries {
+			if !now.Before(e.expiry) {
+				delete(c.entries, k)
+			}
+		}
+		if len(c.entries) >= decisionCacheMaxEntries {
+			c.entries = make(map[string]decisionCacheEntry)
+		}
+	}
+	c.entries[key] = decisionCacheEntry{allowed: allowed, expiry: now.Add(c.ttl)}
+	return allowed, nil
+}
+
+// Flush forgets all cached decisions, e.g. because what they depend on has changed. A nil
+// DecisionCache has nothing to flush.
+func (c *DecisionCache) Flush() {
+	if c == nil {
+		return
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.entries = make(map[string]decisionCacheEntry)
+}
@@ -0,0 +1,188 @@
+package chshare
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// URI-style channel descriptors are an alternative to the ":"-delimited syntax, which is
+// ambiguous once unix socket paths and IPv6 addresses are involved:
+//
+//    ["R:"]<stub-uri>[,<skeleton-uri>]["?"<options>]
+//
+// where each endpoint URI is one of:
+//
+//    tcp://<host>:<port>       tcp://[<IPV6 addr>]:<port>      tcp://:<port>
+//    tcp4://<host>:<port>      tcp6://<host>:<port>            (IPv4 or IPv6 only)
+//    udp://<host>:<port>       udp4://<host>:<port>            udp6://<host>:<port>
+//    unix://<path>             e.g. unix:///var/run/app.sock
+//    loop://<name>
+//    stdio:
+//    socks:                    (skeleton only)
+//    observe://<channel-id>    (skeleton only)
+//    alias://<name>            (skeleton only; see --aliases)
+//    exec://<name>             (skeleton only; see --exec)
+//    tun://<device>
+//    vsock://<cid>:<port>
+//    mqtt://<host>:<port>      (skeleton only)
+//
+// Paths and names are percent-decoded, so a ',' or '?' in them may be written as %2C or %3F.
+// If the skeleton URI is omitted, it is derived from the stub URI as for the legacy syntax.
+
+// uriDescriptorRegexp matches the start of a URI-style channel descriptor
+var uriDescriptorRegexp = regexp.MustCompile(`^(R:)?([a-zA-Z][a-zA-Z0-9+.-]*://|(stdio|socks):(,|$))`)
+
+// IsURIChannelDescriptor returns true if a channel descriptor string (without options) uses
+// the URI-style syntax
+func IsURIChannelDescriptor(s string) bool {
+	return uriDescriptorRegexp.MatchString(s)
+}
+
+// DescriptorParseError describes a syntax error at a particular position in a descriptor
+// string. Its message repeats the string with the offending token underlined.
+type DescriptorParseError struct {
+	Descriptor string
+	Start      int
+	End        int
+	Msg        string
+}
+
+func (e *DescriptorParseError) Error() string {
+	n := e.End - e.Start
+	if n < 1 {
+		n = 1
+	}
+	return fmt.Sprintf("%s at offset %d in descriptor '%s'\n    %s\n    %s%s",
+		e.Msg, e.Start, e.Descriptor, e.Descriptor, strings.Repeat(" ", e.Start), strings.Repeat("^", n))
+}
+
+// parseEndpointURI parses one endpoint URI, which starts at offset start in the full
+// descriptor string s
+func parseEndpointURI(s string, start int, uri string, role ChannelEndpointRole) (*ChannelEndpointDescriptor, error) {
+	fail := func(tokenStart, tokenEnd int, format string, args ...interface{}) error {
+		return &DescriptorParseError{
+			Descriptor: s,
+			Start:      start + tokenStart,
+			End:        start + tokenEnd,
+			Msg:        fmt.Sprintf(format, args...),
+		}
+	}
+	i := strings.Index(uri, ":")
+	if i < 0 {
+		return nil, fail(0, len(uri), "Missing scheme in endpoint URI '%s'", uri)
+	}
+	scheme := uri[:i]
+	rest := uri[i+1:]
+	restStart := i + 1
+	d := &ChannelEndpointDescriptor{Role: role}
+	switch scheme {
+	case "stdio", "socks":
+		if rest != "" {
+			return nil, fail(restStart, len(uri), "%s endpoint URI cannot have a path", scheme)
+		}
+		d.Type = ChannelEndpointTypeStdio
+		if scheme == "socks" {
+			d.Type = ChannelEndpointTypeSocks
+		}
+		return d, nil
+	case "tcp", "tcp4", "tcp6", "udp", "udp4", "udp6", "unix", "loop", "observe", "alias", "exec", "tun", "vsock", "mqtt":
+	default:
+		return nil, fail(0, i, "Unknown endpoint URI scheme '%s'; expected tcp, tcp4, tcp6, udp, udp4, udp6, unix, loop, observe, alias, exec, tun, vsock, mqtt, stdio or socks", scheme)
+	}
+	if !strings.HasPrefix(rest, "//") {
+		return nil, fail(restStart, restStart, "Expected '//' after '%s:'", scheme)
+	}
+	rest = rest[2:]
+	restStart += 2
+	path, err := url.PathUnescape(rest)
+	if err != nil {
+		return nil, fail(restStart, len(uri), "Invalid percent-encoding in endpoint URI: %s", err)
+	}
+	if path == "" {
+		return nil, fail(restStart, restStart, "Missing %s endpoint path", scheme)
+	}
+	switch scheme {
+	case "unix":
+		d.Type = ChannelEndpointTypeUnix
+		d.Path = path
+	case "loop":
+		d.Type = ChannelEndpointTypeLoop
+		d.Path = path
+	case "observe":
+		d.Type = ChannelEndpointTypeObserve
+		d.Path = path
+	case "alias":
+		d.Type = ChannelEndpointTypeAlias
+		d.Path = path
+	case "exec":
+		d.Type = ChannelEndpointTypeExec
+		d.Path = path
+	case "tun":
+		d.Type = ChannelEndpointTypeTUN
+		d.Path = path
+	case "vsock":
+		d.Type = ChannelEndpointTypeVSOCK
+		d.Path = path
+	case "tcp", "tcp4", "tcp6", "udp", "udp4", "udp6", "mqtt":
+		d.Type = ChannelEndpointTypeTCP
+		if strings.HasPrefix(scheme, "udp") {
+			d.Type = ChannelEndpointTypeUDP
+		} else if scheme == "mqtt" {
+			d.Type = ChannelEndpointTypeMQTT
+		}
+		if len(scheme) > 3 && d.Type != ChannelEndpointTypeMQTT {
+			d.setFamily(scheme[3:])
+		}
+		proto := strings.ToUpper(string(d.Type))
+		host, port, err := ParseHostPort(path, "", UnknownPortNumber)
+		if err != nil {
+			return nil, fail(restStart, len(uri), "Invalid %s host/port '%s'", proto, path)
+		}
+		if port == UnknownPortNumber {
+			return nil, fail(len(uri), len(uri), "Missing port number in %s endpoint URI", proto)
+		}
+		d.Path = host + ":" + port.String()
+	}
+	return d, nil
+}
+
+// parseURIChannelEndpoints parses the stub and skeleton endpoints of a URI-style channel
+// descriptor, without options. If there is no skeleton URI, the returned skeleton has an
+// unknown type, to be derived from the stub.
+func parseURIChannelEndpoints(s string) (bool, *ChannelEndpointDescriptor, *ChannelEndpointDescriptor, error) {
+	reverse := strings.HasPrefix(s, "R:")
+	offset := 0
+	if reverse {
+		offset = 2
+	}
+	uris := strings.Split(s[offset:], ",")
+	if len(uris) > 2 {
+		extra := offset + len(uris[0]) + 1 + len(uris[1])
+		return false, nil, nil, &DescriptorParseError{
+			Descriptor: s,
+			Start:      extra,
+			End:        len(s),
+			Msg:        "Too many endpoint URIs; expected <stub-uri>[,<skeleton-uri>]",
+		}
+	}
+	stub, err := parseEndpointURI(s, offset, uris[0], ChannelEndpointRoleStub)
+	if err != nil {
+		return false, nil, nil, err
+	}
+	skeleton := &ChannelEndpointDescriptor{Role: ChannelEndpointRoleSkeleton, Type: ChannelEndpointTypeUnknown}
+	if len(uris) == 1 && stub.Type == ChannelEndpointTypeSocks {
+		// "socks:" alone is a SOCKS skeleton with the default stub listener
+		stub.Role = ChannelEndpointRoleSkeleton
+		skeleton = stub
+		stub = &ChannelEndpointDescriptor{Role: ChannelEndpointRoleStub, Type: ChannelEndpointTypeTCP}
+	}
+	if len(uris) == 2 {
+		skeleton, err = parseEndpointURI(s, offset+len(uris[0])+1, uris[1], ChannelEndpointRoleSkeleton)
+		if err != nil {
+			return false, nil, nil, err
+		}
+	}
+	return reverse, stub, skeleton, nil
+}
@@ -0,0 +1,87 @@
+package chshare
+
+import (
+	"crypto/rand"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// deviceProofPrefix is prepended to the SSH session ID in the data signed with a device key,
+// so that the signature cannot be used for anything else
+const deviceProofPrefix = "chisel-device-key\x00"
+
+// LoadDeviceKey loads the client's device key from the PEM file at path, generating it and
+// saving it there, readable only by the current user, if the file does not exist. The
+// device key identifies the client's device to the server, in addition to the user it logs
+// in as, so that a user's sessions can be pinned to known devices.
+func LoadDeviceKey(path string) (ssh.Signer, bool, error) {
+	created := false
+	key, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		key, err = GenerateKey("")
+		if err != nil {
+			return nil, false, fmt.Errorf("Unable to generate device key: %s", err)
+		}
+		if err = os.MkdirAll(filepath.Dir(path), 0700); err == nil {
+			err = writeNewFile(path, key, 0600)
+		}
+		if err != nil {
+			return nil, false, fmt.Errorf("Unable to save device key: %s", err)
+		}
+		created = true
+	} else if err != nil {
+		return nil, false, fmt.Errorf("Unable to read device key: %s", err)
+	}
+	signer, err := ssh.ParsePrivateKey(key)
+	if err != nil {
+		return nil, false, fmt.Errorf("Invalid device key '%s': %s", path, err)
+	}
+	return signer, created, nil
+}
+
+// writeNewFile writes data to a file that must not already exist
+func writeNewFile(path string, data []byte, perm os.FileMode) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, perm)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(path)
+	}
+	return err
+}
+
+// signDeviceProof returns the public key of a device key, and its signature of an SSH
+// session ID, both in SSH wire format
+func signDeviceProof(signer ssh.Signer, sessionID []byte) ([]byte, []byte, error) {
+	sig, err := signer.Sign(rand.Reader, append([]byte(deviceProofPrefix), sessionID...))
+	if err != nil {
+		return nil, nil, err
+	}
+	return signer.PublicKey().Marshal(), ssh.Marshal(sig), nil
+}
+
+// verifyDeviceProof returns the device public key presented by a client, after checking its
+// signature of the SSH session ID
+func verifyDeviceProof(sessionID []byte, key []byte, signature []byte) (ssh.PublicKey, error) {
+	pub, err := ssh.ParsePublicKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid device key: %s", err)
+	}
+	sig := &ssh.Signature{}
+	if err := ssh.Unmarshal(signature, sig); err != nil {
+		return nil, fmt.Errorf("Invalid device key signature: %s", err)
+	}
+	if err := pub.Verify(append([]byte(deviceProofPrefix), sessionID...), sig); err != nil {
+		return nil, fmt.Errorf("Device key signature verification failed: %s", err)
+	}
+	return pub, nil
+}
@@ -0,0 +1,260 @@
+package chshare
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"runtime"
+	"runtime/debug"
+	"runtime/pprof"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The diagnostics protocol serves a single command per connection. The request is a line
+// containing a command and optional argument. The response is a line that is either "OK" or
+// "ERR <message>", followed, after "OK", by the command's output, which may be binary (e.g. a
+// heap profile), up to the end of the connection.
+const (
+	diagResponseOK  = "OK"
+	diagResponseErr = "ERR"
+)
+
+// diagMaxProfileTime is the longest CPU profile the diagnostics agent takes
+const diagMaxProfileTime = 5 * time.Minute
+
+// diagDefaultProfileTime is the length of a CPU profile if the command does not give one
+const diagDefaultProfileTime = 30 * time.Second
+
+const diagHelp = `Commands:
+  stack             Dump the stacks of all goroutines
+  memstats          Show memory allocator and garbage collector statistics
+  gc                Run a garbage collection, then show memory statistics
+  heap              Write a heap profile in pprof format
+  profile [secs]    Take a CPU profile for secs seconds (default 30), in pprof format
+  version           Show the chisel and Go versions
+  help              This help text`
+
+// DiagAgent serves runtime diagnostics of the running process, such as goroutine dumps and
+// heap profiles, on demand on a local unix domain socket, so that a wedged process can be
+// diagnosed where a debugger cannot be attached. Since heap profiles may hold passwords and
+// keys, and the protocol is not authenticated, only the process's owner may connect.
+type DiagAgent struct {
+	ShutdownHelper
+	clock    Clock
+	network  string
+	address  string
+	listener net.Listener
+}
+
+// NewDiagAgent creates a new DiagAgent. The address has the same form as a client control
+// socket address (see ParseControlAddr), but must be a unix domain socket, since any local
+// user could connect to a loopback TCP port. CPU profiles run for a time measured by clock.
+// It does not start listening until Start is called.
+func NewDiagAgent(logger Logger, clock Clock, addr string) (*DiagAgent, error) {
+	network, address, err := ParseControlAddr(addr)
+	if err != nil {
+		return nil, err
+	}
+	if network != "unix" {
+		return nil, fmt.Errorf("Diagnostics socket '%s' must be a unix domain socket path", addr)
+	}
+	a := &DiagAgent{
+		clock:   clock,
+		network: network,
+		address: address,
+	}
+	a.InitShutdownHelper(logger.Fork("diag"), a)
+	return a, nil
+}
+
+// HandleOnceShutdown will be called exactly once, in its own goroutine. It should take completionError
+// as an advisory completion value, actually shut down, then return the real completion value.
+func (a *DiagAgent) HandleOnceShutdown(completionErr error) error {
+	var err error
+	if a.listener != nil {
+		err = a.listener.Close()
+	}
+	if completionErr == nil {
+		completionErr = err
+	}
+	return completionErr
+}
+
+// Start begins listening on the diagnostics socket and serving requests in the background
+func (a *DiagAgent) Start(ctx context.Context) error {
+	return a.DoOnceActivate(
+		func() error {
+			listener, err := NewPrivateUnixSocketListener(a.Logger, a.address)
+			if err != nil {
+				return a.Errorf("Unable to listen on diagnostics socket %s: %s", a.address, err)
+			}
+			a.listener = listener
+			a.ShutdownOnContext(ctx)
+			a.ILogf("Listening for diagnostics commands on %s", listener.Addr())
+			go a.acceptLoop()
+			return nil
+		},
+		true,
+	)
+}
+
+func (a *DiagAgent) acceptLoop() {
+	for {
+		conn, err := a.listener.Accept()
+		if err != nil {
+			if !a.IsStartedShutdown() {
+				a.StartShutdown(a.DLogErrorf("Diagnostics socket accept failed: %s", err))
+			}
+			return
+		}
+		go a.serveConn(conn)
+	}
+}
+
+func (a *DiagAgent) serveConn(conn net.Conn) {
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		return
+	}
+	conn.SetReadDeadline(time.Time{})
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		fmt.Fprintf(conn, "%s Empty command; try \"help\"\n", diagResponseErr)
+		return
+	}
+	a.ILogf("Diagnostics command: %s", strings.TrimSpace(line))
+	w := bufio.NewWriter(conn)
+	defer w.Flush()
+	if err := a.runCommand(w, fields[0], fields[1:]); err != nil {
+		a.DLogf("Diagnostics command %s failed: %s", fields[0], err)
+	}
+}
+
+// runCommand executes a single diagnostics command, writing the response to w
+func (a *DiagAgent) runCommand(w io.Writer, cmd string, args []string) error {
+	fail := func(err error) error {
+		fmt.Fprintf(w, "%s %s\n", diagResponseErr, strings.Replace(err.Error(), "\n", " ", -1))
+		return err
+	}
+	maxArgs := 0
+	if cmd == "profile" {
+		maxArgs = 1
+	}
+	if len(args) > maxArgs {
+		return fail(fmt.Errorf("%s: expected at most %d argument(s), got %d", cmd, maxArgs, len(args)))
+	}
+
+	switch cmd {
+	case "help":
+		fmt.Fprintf(w, "%s\n%s\n", diagResponseOK, diagHelp)
+	case "stack":
+		fmt.Fprintf(w, "%s\n", diagResponseOK)
+		return pprof.Lookup("goroutine").WriteTo(w, 2)
+	case "gc":
+		runtime.GC()
+		fmt.Fprintf(w, "%s\n", diagResponseOK)
+		writeMemStats(w)
+	case "memstats":
+		fmt.Fprintf(w, "%s\n", diagResponseOK)
+		writeMemStats(w)
+	case "heap":
+		fmt.Fprintf(w, "%s\n", diagResponseOK)
+		return pprof.WriteHeapProfile(w)
+	case "profile":
+		d := diagDefaultProfileTime
+		if len(args) > 0 {
+			secs, err := strconv.Atoi(args[0])
+			if err != nil || secs < 1 || time.Duration(secs)*time.Second > diagMaxProfileTime {
+				return fail(fmt.Errorf("Invalid profile time '%s'; must be 1 to %d seconds", args[0], int(diagMaxProfileTime/time.Second)))
+			}
+			d = time.Duration(secs) * time.Second
+		}
+		pr, pw := io.Pipe()
+		if err := pprof.StartCPUProfile(pw); err != nil {
+			return fail(err)
+		}
+		fmt.Fprintf(w, "%s\n", diagResponseOK)
+		go func() {
+			<-a.clock.After(d)
+			pprof.StopCPUProfile()
+			pw.Close()
+		}()
+		_, err := io.Copy(w, pr)
+		return err
+	case "version":
+		fmt.Fprintf(w, "%s\nchisel %s, %s %s/%s\n", diagResponseOK, BuildVersion, runtime.Version(), runtime.GOOS, runtime.GOARCH)
+	default:
+		return fail(fmt.Errorf("Unknown command \"%s\"; try \"help\"", cmd))
+	}
+	return nil
+}
+
+// writeMemStats writes the process's memory and garbage collector statistics to w
+func writeMemStats(w io.Writer) {
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	var gc debug.GCStats
+	debug.ReadGCStats(&gc)
+	fmt.Fprintf(w, "goroutines: %d\n", runtime.NumGoroutine())
+	fmt.Fprintf(w, "alloc: %d\n", m.Alloc)
+	fmt.Fprintf(w, "total-alloc: %d\n", m.TotalAlloc)
+	fmt.Fprintf(w, "sys: %d\n", m.Sys)
+	fmt.Fprintf(w, "heap-alloc: %d\n", m.HeapAlloc)
+	fmt.Fprintf(w, "heap-sys: %d\n", m.HeapSys)
+	fmt.Fprintf(w, "heap-idle: %d\n", m.HeapIdle)
+	fmt.Fprintf(w, "heap-in-use: %d\n", m.HeapInuse)
+	fmt.Fprintf(w, "heap-released: %d\n", m.HeapReleased)
+	fmt.Fprintf(w, "heap-objects: %d\n", m.HeapObjects)
+	fmt.Fprintf(w, "stack-in-use: %d\n", m.StackInuse)
+	fmt.Fprintf(w, "mallocs: %d\n", m.Mallocs)
+	fmt.Fprintf(w, "frees: %d\n", m.Frees)
+	fmt.Fprintf(w, "next-gc: %d\n", m.NextGC)
+	fmt.Fprintf(w, "num-gc: %d\n", m.NumGC)
+	fmt.Fprintf(w, "gc-pause-total: %s\n", time.Duration(m.PauseTotalNs))
+	if !gc.LastGC.IsZero() {
+		fmt.Fprintf(w, "last-gc: %s\n", gc.LastGC.Format(time.RFC3339))
+	}
+	if len(gc.Pause) > 0 {
+		fmt.Fprintf(w, "last-gc-pause: %s\n", gc.Pause[0])
+	}
+	fmt.Fprintf(w, "gc-cpu-fraction: %.4f\n", m.GCCPUFraction)
+}
+
+// SendDiagCommand sends a single command to a diagnostics socket, copying the command's
+// output to out. An error is returned if the command fails.
+func SendDiagCommand(addr string, command string, out io.Writer) error {
+	network, address, err := ParseControlAddr(addr)
+	if err != nil {
+		return err
+	}
+	conn, err := net.DialTimeout(network, address, 10*time.Second)
+	if err != nil {
+		return fmt.Errorf("Unable to connect to diagnostics socket %s: %s", address, err)
+	}
+	defer conn.Close()
+	if _, err := fmt.Fprintf(conn, "%s\n", command); err != nil {
+		return fmt.Errorf("Unable to send diagnostics command: %s", err)
+	}
+	r := bufio.NewReader(conn)
+	status, err := r.ReadString('\n')
+	if err != nil {
+		return fmt.Errorf("Diagnostics socket closed without a response")
+	}
+	status = strings.TrimRight(status, "\n")
+	if strings.HasPrefix(status, diagResponseErr+" ") {
+		return fmt.Errorf("%s", strings.TrimPrefix(status, diagResponseErr+" "))
+	}
+	if status != diagResponseOK {
+		return fmt.Errorf("Invalid diagnostics response: %s", status)
+	}
+	if _, err := io.Copy(out, r); err != nil {
+		return fmt.Errorf("Diagnostics socket read failed: %s", err)
+	}
+	return nil
+}
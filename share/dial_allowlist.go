@@ -0,0 +1,230 @@
+package chshare
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// dialAllowRule is one entry of a DialAllowlist. A rule matches either a network (CIDR or
+// single address), a host name, or a unix socket path. A TCP rule with no port range matches
+// any port.
+type dialAllowRule struct {
+	network  *net.IPNet
+	hostname string
+	unixPath string
+	minPort  int
+	maxPort  int
+}
+
+// DialAllowlist restricts the local services that a remote proxy may ask this proxy to
+// connect to for reverse channels, so that a compromised server cannot use a client to
+// reach arbitrary hosts on the client's network. TCP targets are resolved before they are
+// checked, and every address a name resolves to must be allowed; the connection is then made
+// to the checked address, so a second lookup cannot redirect it.
+type DialAllowlist struct {
+	rules []*dialAllowRule
+}
+
+// ParseDialAllowlist parses a comma-separated list of allowed dial targets, each of which
+// is one of:
+//
+//	<cidr>[:<ports>]          e.g. 10.1.0.0/16:443, [fd00::/8]:8000-8999
+//	<ip-addr>[:<ports>]       e.g. 127.0.0.1:22, [::1]:22
+//	<hostname>[:<ports>]      e.g. db.internal:5432
+//	unix:<path>               e.g. unix:/var/run/app.sock
+//
+// where <ports> is a single port or an inclusive range <lo>-<hi>, and an omitted port
+// allows all ports. A hostname rule matches targets given by that name, without resolution.
+func ParseDialAllowlist(s string) (*DialAllowlist, error) {
+	a := &DialAllowlist{}
+	for _, entry := range strings.Split(s, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		rule, err := parseDialAllowRule(entry)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid dial allowlist entry '%s': %s", entry, err)
+		}
+		a.rules = append(a.rules, rule)
+	}
+	if len(a.rules) == 0 {
+		return nil, fmt.Errorf("Empty dial allowlist")
+	}
+	return a, nil
+}
+
+// parseDialAllowRule parses a single DialAllowlist entry
+func parseDialAllowRule(entry string) (*dialAllowRule, error) {
+	if strings.HasPrefix(entry, "unix:") {
+		path := entry[len("unix:"):]
+		if path == "" {
+			return nil, fmt.Errorf("missing unix socket path")
+		}
+		return &dialAllowRule{unixPath: path}, nil
+	}
+
+	host := entry
+	ports := ""
+	if strings.HasPrefix(entry, "[") {
+		end := strings.Index(entry, "]")
+		if end < 0 {
+			return nil, fmt.Errorf("missing ']'")
+		}
+		host = entry[1:end]
+		rest := entry[end+1:]
+		if rest != "" {
+			if !strings.HasPrefix(rest, ":") {
+				return nil, fmt.Errorf("expected ':' after ']'")
+			}
+			ports = rest[1:]
+		}
+	} else if i := strings.LastIndex(entry, ":"); i >= 0 {
+		if strings.Count(entry, ":") > 1 {
+			return nil, fmt.Errorf("IPv6 addresses with a port must be enclosed in '[]'")
+		}
+		host = entry[:i]
+		ports = entry[i+1:]
+	}
+
+	rule := &dialAllowRule{minPort: 1, maxPort: 65535}
+	if ports != "" {
+		var err error
+		rule.minPort, rule.maxPort, err = ParsePortRange(ports)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if host == "" {
+		return nil, fmt.Errorf("missing address")
+	}
+	if strings.Contains(host, "/") {
+		_, network, err := net.ParseCIDR(host)
+		if err != nil {
+			return nil, err
+		}
+		rule.network = network
+	} else if ip := net.ParseIP(host); ip != nil {
+		bits := 8 * net.IPv6len
+		if ip4 := ip.To4(); ip4 != nil {
+			ip = ip4
+			bits = 8 * net.IPv4len
+		}
+		rule.network = &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}
+	} else {
+		rule.hostname = strings.ToLower(host)
+	}
+	return rule, nil
+}
+
+// allowsPort returns true if a TCP rule's port range includes port
+func (r *dialAllowRule) allowsPort(port int) bool {
+	return port >= r.minPort && port <= r.maxPort
+}
+
+// allowsIP returns true if an IP address and port are allowed
+func (a *DialAllowlist) allowsIP(ip net.IP, port int) bool {
+	for _, r := range a.rules {
+		if r.network != nil && r.network.Contains(ip) && r.allowsPort(port) {
+			return true
+		}
+	}
+	return false
+}
+
+// CheckTCP checks a "<host>:<port>" dial target against the allowlist. It returns the
+// address that should actually be dialed, which is the target itself if it is allowed by
+// host name, or else one of the addresses it resolves to.
+func (a *DialAllowlist) CheckTCP(ctx context.Context, target string) (string, error) {
+	host, portStr, err := net.SplitHostPort(target)
+	if err != nil {
+		return "", fmt.Errorf("Invalid dial target '%s': %s", target, err)
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		return "", fmt.Errorf("Invalid port in dial target '%s'", target)
+	}
+	lowerHost := strings.ToLower(host)
+	for _, r := range a.rules {
+		if r.hostname != "" && r.hostname == lowerHost && r.allowsPort(port) {
+			return target, nil
+		}
+	}
+	var ips []net.IP
+	if ip := net.ParseIP(host); ip != nil {
+		ips = []net.IP{ip}
+	} else {
+		addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+		if err != nil {
+			return "", fmt.Errorf("Unable to resolve dial target '%s': %s", target, err)
+		}
+		for _, addr := range addrs {
+			ips = append(ips, addr.IP)
+		}
+	}
+	if len(ips) == 0 {
+		return "", fmt.Errorf("Dial target '%s' has no addresses", target)
+	}
+	for _, ip := range ips {
+		if !a.allowsIP(ip, port) {
+			return "", fmt.Errorf("Dial target '%s' (%s) is not in the dial allowlist", target, ip)
+		}
+	}
+	return net.JoinHostPort(ips[0].String(), portStr), nil
+}
+
+// CheckUnix checks a unix socket path dial target against the allowlist
+func (a *DialAllowlist) CheckUnix(path string) error {
+	for _, r := range a.rules {
+		if r.unixPath != "" && r.unixPath == path {
+			return nil
+		}
+	}
+	return fmt.Errorf("Unix socket '%s' is not in the dial allowlist", path)
+}
+
+// CheckSkeletonEndpoint checks a skeleton endpoint requested by the remote proxy against the
+// allowlist. It returns the descriptor to use for the local endpoint, whose TCP or UDP
+// address may have been replaced with the resolved address that was checked; host and port
+// rules apply to both. Loop, stdio and observe skeletons do not reach the network and are
+// always allowed. Every other type is refused, including SOCKS skeletons, which would dial
+// targets chosen after the endpoint is created, exec skeletons, whose commands may connect
+// anywhere, aliases, which only the server resolves, and any type added later, until it is
+// given a case here.
+func (a *DialAllowlist) CheckSkeletonEndpoint(ctx context.Context, ced *ChannelEndpointDescriptor) (*ChannelEndpointDescriptor, error) {
+	switch ced.Type {
+	case ChannelEndpointTypeTCP, ChannelEndpointTypeUDP, ChannelEndpointTypeMQTT:
+		addr, err := a.CheckTCP(ctx, ced.Path)
+		if err != nil {
+			return nil, err
+		}
+		if addr != ced.Path {
+			checked := *ced
+			checked.Path = addr
+			return &checked, nil
+		}
+		return ced, nil
+	case ChannelEndpointTypeUnix:
+		if err := a.CheckUnix(ced.Path); err != nil {
+			return nil, err
+		}
+		return ced, nil
+	case ChannelEndpointTypeLoop, ChannelEndpointTypeStdio, ChannelEndpointTypeObserve:
+		return ced, nil
+	case ChannelEndpointTypeSocks:
+		return nil, fmt.Errorf("SOCKS endpoints are not allowed with a dial allowlist")
+	case ChannelEndpointTypeTUN:
+		return nil, fmt.Errorf("TUN endpoints are not allowed with a dial allowlist")
+	case ChannelEndpointTypeVSOCK:
+		return nil, fmt.Errorf("VSOCK endpoints are not allowed with a dial allowlist")
+	case ChannelEndpointTypeExec:
+		return nil, fmt.Errorf("Exec endpoints are not allowed with a dial allowlist")
+	case ChannelEndpointTypeAlias:
+		return nil, fmt.Errorf("Alias endpoints are not allowed with a dial allowlist")
+	}
+	return nil, fmt.Errorf("%s endpoints are not allowed with a dial allowlist", ced.Type)
+}
@@ -0,0 +1,109 @@
+package chshare
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+)
+
+// DialSource is the local source of the connections a skeleton makes to its Called Service:
+// either an IP address, or the name of a network interface whose address is used, so that
+// on a multi-homed host, connections come from the subnet the Called Service accepts
+type DialSource struct {
+	IP        net.IP
+	Interface string
+}
+
+// ParseDialSource parses a dial source, which is an IP address or a network interface name
+func ParseDialSource(value string) (*DialSource, error) {
+	if ip := net.ParseIP(strings.TrimSuffix(strings.TrimPrefix(value, "["), "]")); ip != nil {
+		if ip.IsUnspecified() || ip.IsMulticast() {
+			return nil, fmt.Errorf("Invalid source address '%s'", value)
+		}
+		return &DialSource{IP: ip}, nil
+	}
+	if value == "" || len(value) > 15 || strings.ContainsAny(value, "/:% \t") {
+		return nil, fmt.Errorf("Invalid source '%s'; expected an IP address or interface name", value)
+	}
+	return &DialSource{Interface: value}, nil
+}
+
+// validateSourceOption validates the value of the "source" descriptor option
+func validateSourceOption(value string) error {
+	_, err := ParseDialSource(value)
+	return err
+}
+
+// endpointDialSource returns the dial source of a TCP skeleton endpoint, which is its
+// "source" option if it has one, or defaultSource, which may be nil
+func endpointDialSource(ced *ChannelEndpointDescriptor, defaultSource *DialSource) (*DialSource, error) {
+	value := ced.Option("source")
+	if value == "" || ced.Role != ChannelEndpointRoleSkeleton {
+		return defaultSource, nil
+	}
+	return ParseDialSource(value)
+}
+
+func (ds *DialSource) String() string {
+	if ds.Interface != "" {
+		return ds.Interface
+	}
+	return ds.IP.String()
+}
+
+// LocalAddr returns the local address to dial from on network ("tcp", "tcp4" or "tcp6"). An
+// interface's first IPv4 address is used, unless network is "tcp6" or it has none, in which
+// case its first IPv6 address is used. A nil DialSource returns nil, for any address.
+func (ds *DialSource) LocalAddr(network string) (*net.TCPAddr, error) {
+	if ds == nil {
+		return nil, nil
+	}
+	if ds.Interface == "" {
+		return &net.TCPAddr{IP: ds.IP}, nil
+	}
+	iface, err := net.InterfaceByName(ds.Interface)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to find source interface '%s': %s", ds.Interface, err)
+	}
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return nil, fmt.Errorf("Unable to get the addresses of source interface '%s': %s", ds.Interface, err)
+	}
+	var ip4, ip6 net.IP
+	for _, addr := range addrs {
+		ipnet, ok := addr.(*net.IPNet)
+		if !ok || ipnet.IP.IsLinkLocalUnicast() {
+			continue
+		}
+		if ipnet.IP.To4() != nil {
+			if ip4 == nil {
+				ip4 = ipnet.IP
+			}
+		} else if ip6 == nil {
+			ip6 = ipnet.IP
+		}
+	}
+	ip := ip4
+	if (network == "tcp6" && ip6 != nil) || ip == nil {
+		ip = ip6
+	}
+	if ip == nil || (network == "tcp4" && ip.To4() == nil) {
+		return nil, fmt.Errorf("Source interface '%s' has no usable address", ds.Interface)
+	}
+	return &net.TCPAddr{IP: ip}, nil
+}
+
+// DialContext connects to address on network from the dial source. A nil DialSource dials
+// from any address.
+func (ds *DialSource) DialContext(ctx context.Context, network string, address string) (net.Conn, error) {
+	d := &net.Dialer{}
+	laddr, err := ds.LocalAddr(network)
+	if err != nil {
+		return nil, err
+	}
+	if laddr != nil {
+		d.LocalAddr = laddr
+	}
+	return d.DialContext(ctx, network, address)
+}
@@ -0,0 +1,73 @@
+package chshare
+
+import (
+	"fmt"
+	"github.com/XevoInc/chisel/chprotobuf"
+	"github.com/golang/protobuf/proto"
+)
+
+// DynamicChannelsRequestType is the SSH request type used by a client to add or remove
+// channels on an already-configured session
+const DynamicChannelsRequestType = "channels"
+
+// DynamicChannelsRequest describes a set of channels to be added to and removed from a
+// running chisel session. It is sent from the client to the server after initial
+// configuration, e.g., when the client's remotes file changes. Removals are applied before
+// additions.
+type DynamicChannelsRequest struct {
+	AddChannelDescriptors    []*ChannelDescriptor
+	RemoveChannelDescriptors []*ChannelDescriptor
+
+	// WantReply is true if the client understands a ChannelsReply payload in the
+	// server's success reply
+	WantReply bool
+}
+
+func channelDescriptorsToPb(chds []*ChannelDescriptor) []*chprotobuf.PbChannelDescriptor {
+	pbcds := make([]*chprotobuf.PbChannelDescriptor, len(chds))
+	for i, cd := range chds {
+		pbcds[i] = cd.ToPb()
+	}
+	return pbcds
+}
+
+func pbToChannelDescriptors(pbcds []*chprotobuf.PbChannelDescriptor) []*ChannelDescriptor {
+	chds := make([]*ChannelDescriptor, len(pbcds))
+	for i, pbcd := range pbcds {
+		chds[i] = PbToChannelDescriptor(pbcd)
+	}
+	return chds
+}
+
+// ToPb converts a DynamicChannelsRequest to its protobuf value
+func (c *DynamicChannelsRequest) ToPb() *chprotobuf.PbDynamicChannelsRequest {
+	return &chprotobuf.PbDynamicChannelsRequest{
+		AddChannelDescriptors:    channelDescriptorsToPb(c.AddChannelDescriptors),
+		RemoveChannelDescriptors: channelDescriptorsToPb(c.RemoveChannelDescriptors),
+		WantReply:                c.WantReply,
+	}
+}
+
+// FromPb initializes a DynamicChannelsRequest from its protobuf value
+func (c *DynamicChannelsRequest) FromPb(pb *chprotobuf.PbDynamicChannelsRequest) {
+	c.AddChannelDescriptors = pbToChannelDescriptors(pb.AddChannelDescriptors)
+	c.RemoveChannelDescriptors = pbToChannelDescriptors(pb.RemoveChannelDescriptors)
+	c.WantReply = pb.GetWantReply()
+}
+
+// Unmarshal unserializes a DynamicChannelsRequest from protobuf bytes
+func (c *DynamicChannelsRequest) Unmarshal(b []byte) error {
+	pbc := &chprotobuf.PbDynamicChannelsRequest{}
+	err := proto.Unmarshal(b, pbc)
+	if err != nil {
+		return fmt.Errorf("Invalid protobuf data for DynamicChannelsRequest")
+	}
+	c.FromPb(pbc)
+	return nil
+}
+
+// Marshal serializes a DynamicChannelsRequest to protobuf bytes
+func (c *DynamicChannelsRequest) Marshal() ([]byte, error) {
+	pbc := c.ToPb()
+	return proto.Marshal(pbc)
+}
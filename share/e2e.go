@@ -0,0 +1,210 @@
+package chshare
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	"golang.org/x/crypto/curve25519"
+	"golang.org/x/crypto/nacl/box"
+	"golang.org/x/crypto/nacl/secretbox"
+)
+
+// End-to-end encryption protects the payload of channels that pass through a loop endpoint
+// on the server (or any other relaying proxy) from the relay itself. Each proxy that
+// terminates such channels has a static X25519 key pair, and each remote names the public
+// key of the peer proxy at its other end with the "e2e" option. When a channel is opened,
+// the two ends exchange ephemeral public keys over the channel, and derive a key for each
+// direction from both the static and the ephemeral shared secrets, so that only the
+// designated peer can read or forge the traffic, and a later compromise of a static key does
+// not expose earlier sessions. The payload is then sent as a sequence of NaCl secretbox
+// frames with counter nonces:
+//
+//	<2-byte big-endian sealed length><secretbox sealed payload>
+//
+// Loop endpoints relay the encrypted stream untouched; every other endpoint type that has
+// the "e2e" option encrypts and decrypts it, and requires the local proxy to have a key.
+
+// e2eMaxFramePayload is the largest plaintext sent in a single frame
+const e2eMaxFramePayload = 16 * 1024
+
+// E2EKey is a proxy's static key pair for end-to-end channel encryption
+type E2EKey struct {
+	private [32]byte
+	public  [32]byte
+}
+
+// NewE2EKeyFromSeed deterministically derives an E2EKey from a seed string
+func NewE2EKeyFromSeed(seed string) *E2EKey {
+	k := &E2EKey{private: sha256.Sum256([]byte(seed))}
+	curve25519.ScalarBaseMult(&k.public, &k.private)
+	return k
+}
+
+// PublicKeyString returns the public key in the form used by the "e2e" descriptor option
+func (k *E2EKey) PublicKeyString() string {
+	return base64.RawURLEncoding.EncodeToString(k.public[:])
+}
+
+// ParseE2EPublicKey parses a public key in the form used by the "e2e" descriptor option
+func ParseE2EPublicKey(s string) (*[32]byte, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil || len(b) != 32 {
+		return nil, fmt.Errorf("Invalid end-to-end public key '%s'; expected 43 characters of unpadded base64url", s)
+	}
+	var key [32]byte
+	copy(key[:], b)
+	return &key, nil
+}
+
+// endpointUsesE2E returns true if the local proxy must encrypt and decrypt the payload of
+// channels to or from an endpoint, rather than relaying it
+func endpointUsesE2E(ced *ChannelEndpointDescriptor) bool {
+	return ced.Option("e2e") != "" && ced.Type != ChannelEndpointTypeLoop
+}
+
+// CheckE2EEndpoint returns an error if an endpoint requires end-to-end encryption and the
+// local proxy has no key with which to provide it
+func CheckE2EEndpoint(env LocalChannelEnv, ced *ChannelEndpointDescriptor) error {
+	if endpointUsesE2E(ced) && env.GetE2EKey() == nil {
+		if env.IsServer() {
+			return fmt.Errorf("End-to-end encrypted endpoint %s must be a loop endpoint on the server", ced.LongString())
+		}
+		return fmt.Errorf("End-to-end encrypted endpoint %s requires an end-to-end key (--e2e-key)", ced.LongString())
+	}
+	return nil
+}
+
+// WrapE2EChannelConn performs the end-to-end key exchange over conn if the endpoint uses
+// end-to-end encryption, and returns a ChannelConn that encrypts and decrypts the payload.
+// Otherwise conn is returned unchanged. The stub end of the channel is the initiator.
+func WrapE2EChannelConn(env LocalChannelEnv, ced *ChannelEndpointDescriptor, conn ChannelConn) (ChannelConn, error) {
+	if !endpointUsesE2E(ced) {
+		return conn, nil
+	}
+	if err := CheckE2EEndpoint(env, ced); err != nil {
+		return nil, err
+	}
+	peer, err := ParseE2EPublicKey(ced.Option("e2e"))
+	if err != nil {
+		return nil, err
+	}
+	return newE2EConn(conn, env.GetE2EKey(), peer, ced.Role == ChannelEndpointRoleStub)
+}
+
+// e2eConn is a ChannelConn whose payload is end-to-end encrypted over another ChannelConn.
+// The byte counts of the underlying ChannelConn include the framing and key exchange.
+type e2eConn struct {
+	ChannelConn
+
+	sendKey   [32]byte
+	sendCount uint64
+	recvKey   [32]byte
+	recvCount uint64
+
+	// recvBuf holds decrypted payload not yet returned by Read
+	recvBuf []byte
+}
+
+// newE2EConn exchanges ephemeral keys with the peer over conn, and derives the session keys
+func newE2EConn(conn ChannelConn, key *E2EKey, peer *[32]byte, initiator bool) (*e2eConn, error) {
+	ephPublic, ephPrivate, err := box.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := conn.Write(ephPublic[:]); err != nil {
+		return nil, fmt.Errorf("End-to-end key exchange failed: %s", err)
+	}
+	var peerEph [32]byte
+	if _, err := io.ReadFull(conn, peerEph[:]); err != nil {
+		return nil, fmt.Errorf("End-to-end key exchange failed: %s", err)
+	}
+
+	var staticShared, ephShared [32]byte
+	box.Precompute(&staticShared, peer, &key.private)
+	box.Precompute(&ephShared, &peerEph, ephPrivate)
+
+	initiatorEph, responderEph := ephPublic, &peerEph
+	if !initiator {
+		initiatorEph, responderEph = responderEph, initiatorEph
+	}
+	derive := func(label string) [32]byte {
+		h := sha256.New()
+		h.Write([]byte(label))
+		h.Write(staticShared[:])
+		h.Write(ephShared[:])
+		h.Write(initiatorEph[:])
+		h.Write(responderEph[:])
+		var k [32]byte
+		copy(k[:], h.Sum(nil))
+		return k
+	}
+	c := &e2eConn{ChannelConn: conn}
+	c.sendKey, c.recvKey = derive("chisel-e2e initiator"), derive("chisel-e2e responder")
+	if !initiator {
+		c.sendKey, c.recvKey = c.recvKey, c.sendKey
+	}
+	return c, nil
+}
+
+// e2eNonce returns the nonce for the frame with the given sequence number
+func e2eNonce(count uint64) *[24]byte {
+	var nonce [24]byte
+	binary.BigEndian.PutUint64(nonce[:8], count)
+	return &nonce
+}
+
+// Write encrypts p and writes it to the underlying ChannelConn as one or more frames
+func (c *e2eConn) Write(p []byte) (int, error) {
+	total := 0
+	for len(p) > 0 {
+		chunk := p
+		if len(chunk) > e2eMaxFramePayload {
+			chunk = chunk[:e2eMaxFramePayload]
+		}
+		frame := make([]byte, 2, 2+len(chunk)+secretbox.Overhead)
+		binary.BigEndian.PutUint16(frame, uint16(len(chunk)+secretbox.Overhead))
+		frame = secretbox.Seal(frame, chunk, e2eNonce(c.sendCount), &c.sendKey)
+		c.sendCount++
+		if _, err := c.ChannelConn.Write(frame); err != nil {
+			return total, err
+		}
+		total += len(chunk)
+		p = p[len(chunk):]
+	}
+	return total, nil
+}
+
+// Read returns decrypted payload, reading and authenticating another frame from the
+// underlying ChannelConn if none is buffered
+func (c *e2eConn) Read(p []byte) (int, error) {
+	if len(c.recvBuf) == 0 {
+		var header [2]byte
+		if _, err := io.ReadFull(c.ChannelConn, header[:]); err != nil {
+			if err == io.ErrUnexpectedEOF {
+				err = fmt.Errorf("End-to-end encrypted stream truncated")
+			}
+			return 0, err
+		}
+		sealed := make([]byte, binary.BigEndian.Uint16(header[:]))
+		if _, err := io.ReadFull(c.ChannelConn, sealed); err != nil {
+			return 0, fmt.Errorf("End-to-end encrypted stream truncated: %s", err)
+		}
+		plain, ok := secretbox.Open(nil, sealed, e2eNonce(c.recvCount), &c.recvKey)
+		if !ok {
+			return 0, fmt.Errorf("End-to-end decryption failed; the peer does not hold the expected key")
+		}
+		c.recvCount++
+		c.recvBuf = plain
+	}
+	n := copy(p, c.recvBuf)
+	c.recvBuf = c.recvBuf[n:]
+	return n, nil
+}
+
+func (c *e2eConn) String() string {
+	return fmt.Sprintf("e2e:%s", c.ChannelConn)
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"io"
+	"net"
 )
 
 // ChannelEndpoint is a virtual network endpoint service of any type and role. Stub endpoints
@@ -83,6 +84,25 @@ type LocalStubChannelEndpoint interface {
 	AcceptorChannelEndpoint
 }
 
+// BoundAddrEndpoint is a LocalStubChannelEndpoint that can report the address it is actually
+// listening on, which may differ from the path in its descriptor
+type BoundAddrEndpoint interface {
+	// BoundAddr returns the listening address, or "" if the endpoint is not listening
+	BoundAddr() string
+}
+
+// ListenerHandoffEndpoint is a LocalStubChannelEndpoint whose listener can be handed to
+// another endpoint, so that the listening address is never released in between
+type ListenerHandoffEndpoint interface {
+	// DetachListener stops the endpoint accepting connections, and returns its listener,
+	// still open
+	DetachListener() (net.Listener, error)
+
+	// AdoptListener makes the endpoint accept connections on a listener detached from
+	// another endpoint, rather than listening itself. Must be called before StartListening.
+	AdoptListener(listener net.Listener) error
+}
+
 // LocalSkeletonChannelEndpoint is a Dialer that connects to local network services
 type LocalSkeletonChannelEndpoint interface {
 	DialerChannelEndpoint
@@ -137,10 +157,21 @@ func NewLocalStubChannelEndpoint(
 		}
 	} else if ced.Type == ChannelEndpointTypeTCP {
 		ep, err = NewTCPStubEndpoint(logger, ced)
+	} else if ced.Type == ChannelEndpointTypeUDP {
+		ep, err = NewUDPStubEndpoint(logger, ced)
 	} else if ced.Type == ChannelEndpointTypeUnix {
 		ep, err = NewUnixStubEndpoint(logger, ced)
-	} else if ced.Type == ChannelEndpointTypeSocks {
-		err = fmt.Errorf("%s: Socks endpoint Role must be skeleton: %s", logger.Prefix(), ced.LongString())
+	} else if ced.Type == ChannelEndpointTypeVSOCK {
+		ep, err = NewVSOCKStubEndpoint(logger, ced)
+	} else if ced.Type == ChannelEndpointTypeTUN {
+		if env.IsServer() && !env.IsTUNAllowed() {
+			err = fmt.Errorf("%s: TUN endpoints are disabled: %s", logger.Prefix(), ced.LongString())
+		} else {
+			ep, err = NewTUNStubEndpoint(logger, ced)
+		}
+	} else if ced.Type == ChannelEndpointTypeSocks || ced.Type == ChannelEndpointTypeObserve || ced.Type == ChannelEndpointTypeAlias ||
+		ced.Type == ChannelEndpointTypeExec || ced.Type == ChannelEndpointTypeMQTT {
+		err = fmt.Errorf("%s: %s endpoint Role must be skeleton: %s", logger.Prefix(), ced.Type, ced.LongString())
 	} else {
 		err = fmt.Errorf("%s: Unsupported endpoint type '%s': %s", logger.Prefix(), ced.Type, ced.LongString())
 	}
@@ -157,6 +188,35 @@ func NewLocalSkeletonChannelEndpoint(
 	var ep LocalSkeletonChannelEndpoint
 	var err error
 
+	if ced.Type == ChannelEndpointTypeAlias && ced.Role == ChannelEndpointRoleSkeleton {
+		resolved, err := env.GetChannelAliases().Resolve(ced)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", logger.Prefix(), err)
+		}
+		logger.DLogf("Resolved alias %s to %s", ced.String(), resolved.String())
+		ced = resolved
+	}
+
+	if allowlist := env.GetDialAllowlist(); allowlist != nil && ced.Role == ChannelEndpointRoleSkeleton {
+		checked, err := allowlist.CheckSkeletonEndpoint(context.Background(), ced)
+		if err != nil {
+			env.GetStatsRegistry().Counter(
+				"chisel_dial_allowlist_rejections_total",
+				"Number of channels refused because their target is not in the dial allowlist",
+				nil).Inc()
+			return nil, fmt.Errorf("%s: %s", logger.Prefix(), err)
+		}
+		ced = checked
+	}
+
+	if err := CheckE2EEndpoint(env, ced); err != nil {
+		return nil, fmt.Errorf("%s: %s", logger.Prefix(), err)
+	}
+
+	if err := ced.Validate(); err != nil {
+		return nil, fmt.Errorf("%s: %s", logger.Prefix(), err)
+	}
+
 	if ced.Role != ChannelEndpointRoleSkeleton {
 		err = fmt.Errorf("%s: Role must be skeleton: %s", logger.Prefix(), ced.LongString())
 	} else if ced.Type == ChannelEndpointTypeStdio {
@@ -173,9 +233,15 @@ func NewLocalSkeletonChannelEndpoint(
 			ep, err = NewLoopSkeletonEndpoint(logger, ced, loopServer)
 		}
 	} else if ced.Type == ChannelEndpointTypeTCP {
-		ep, err = NewTCPSkeletonEndpoint(logger, ced)
+		ep, err = NewTCPSkeletonEndpoint(logger, env.GetStatsRegistry(), env.GetClock(), ced, env.GetDialSource(), env.GetDialProxy())
+	} else if ced.Type == ChannelEndpointTypeMQTT {
+		ep, err = NewMQTTSkeletonEndpoint(logger, env.GetStatsRegistry(), env.GetClock(), ced, env.GetDialSource(), env.GetDialProxy(), env.GetMQTTTopicPrefixes())
+	} else if ced.Type == ChannelEndpointTypeUDP {
+		ep, err = NewUDPSkeletonEndpoint(logger, ced)
 	} else if ced.Type == ChannelEndpointTypeUnix {
-		ep, err = NewUnixSkeletonEndpoint(logger, ced)
+		ep, err = NewUnixSkeletonEndpoint(logger, ced, env.GetUnixSocketDirs())
+	} else if ced.Type == ChannelEndpointTypeVSOCK {
+		ep, err = NewVSOCKSkeletonEndpoint(logger, ced)
 	} else if ced.Type == ChannelEndpointTypeSocks {
 		socksServer := env.GetSocksServer()
 		if socksServer == nil {
@@ -183,6 +249,24 @@ func NewLocalSkeletonChannelEndpoint(
 		} else {
 			ep, err = NewSocksSkeletonEndpoint(logger, ced, socksServer)
 		}
+	} else if ced.Type == ChannelEndpointTypeObserve {
+		observer := env.GetChannelObserver()
+		if observer == nil {
+			err = fmt.Errorf("%s: Channel observation is not permitted: %s", logger.Prefix(), ced.LongString())
+		} else {
+			ep, err = NewObserveSkeletonEndpoint(logger, ced, observer)
+		}
+	} else if ced.Type == ChannelEndpointTypeTUN {
+		if !env.IsTUNAllowed() {
+			err = fmt.Errorf("%s: TUN endpoints are disabled: %s", logger.Prefix(), ced.LongString())
+		} else {
+			ep, err = NewTUNSkeletonEndpoint(logger, ced)
+		}
+	} else if ced.Type == ChannelEndpointTypeExec {
+		ep, err = NewExecSkeletonEndpoint(logger, ced, env.GetExecCommands())
+		if err != nil {
+			err = fmt.Errorf("%s: %s: %s", logger.Prefix(), err, ced.LongString())
+		}
 	} else {
 		err = fmt.Errorf("%s: Unsupported endpoint type '%s': %s", logger.Prefix(), ced.Type, ced.LongString())
 	}
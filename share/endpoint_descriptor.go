@@ -3,6 +3,7 @@ package chshare
 import (
 	"fmt"
 	"github.com/XevoInc/chisel/chprotobuf"
+	"sort"
 	"strconv"
 	"strings"
 )
@@ -78,6 +79,11 @@ const (
 	//  a local bind address/port for Stub
 	ChannelEndpointTypeTCP ChannelEndpointType = "tcp"
 
+	// ChannelEndpointTypeUDP is a UDP endpoint--either a host/port for Skeleton or
+	//  a local bind address/port for Stub. Datagrams are carried over the channel as
+	//  length-prefixed frames (see udp_framed_conn.go).
+	ChannelEndpointTypeUDP ChannelEndpointType = "udp"
+
 	// ChannelEndpointTypeUnix is a Unix Domain Socket (AKA local socket) endpoint, identified
 	// by filesystem pathname, for either a Skeleton or Stub.
 	ChannelEndpointTypeUnix ChannelEndpointType = "unix"
@@ -105,8 +111,47 @@ const (
 	// directly forwarded between the Stub and the Skeleton on the Chisel Proxy server, eliminating two
 	// open os socket handles and two extra socket hops that would be required if ordinary sockets were used.
 	ChannelEndpointTypeLoop ChannelEndpointType = "loop"
+
+	// ChannelEndpointTypeObserve is a read-only view of another channel on the Chisel Proxy server,
+	// identified by the channel's ID. Only meaningful for a Skeleton on the server. Connections
+	// receive the data the observed channel's Called Service sends to its Caller, from the time
+	// they are made until the observed channel ends; data sent to them is discarded.
+	ChannelEndpointTypeObserve ChannelEndpointType = "observe"
+
+	// ChannelEndpointTypeAlias is a name defined by the Chisel Proxy server (see --aliases) that
+	// stands for the Skeleton endpoint of a Called Service, so that clients need not know its
+	// address and access lists can grant the name. Only meaningful for a Skeleton on the server,
+	// which resolves the name each time a channel is opened. A dial allowlist checks the resolved
+	// target, and refuses an alias that reaches it unresolved.
+	ChannelEndpointTypeAlias ChannelEndpointType = "alias"
+
+	// ChannelEndpointTypeExec is a command defined by the Chisel Proxy that hosts the Skeleton
+	// (see --exec), identified by its name. Only meaningful for a Skeleton. Each channel starts a
+	// new process running the command, and is connected to the process's stdin and stdout. A
+	// client's dial allowlist refuses it, since the command may connect anywhere.
+	ChannelEndpointTypeExec ChannelEndpointType = "exec"
+
+	// ChannelEndpointTypeTUN is a TUN network device, identified by its name, whose IP packets
+	// are relayed to and from the TUN device at the other end of the channel (see tun.go). A
+	// proxy only attaches to TUN devices for its remote proxy with --tun.
+	ChannelEndpointTypeTUN ChannelEndpointType = "tun"
+
+	// ChannelEndpointTypeVSOCK is an AF_VSOCK socket, through which a virtual machine and its
+	// host talk, identified by a context ID and port (see vsock.go), for either a Skeleton or
+	// Stub.
+	ChannelEndpointTypeVSOCK ChannelEndpointType = "vsock"
+
+	// ChannelEndpointTypeMQTT is an MQTT broker, identified by host and port, that is
+	// connected to as a TCP endpoint is, and whose Callers may be restricted to the topic
+	// prefixes of the session's user (see mqtt.go). Only meaningful for a Skeleton.
+	ChannelEndpointTypeMQTT ChannelEndpointType = "mqtt"
 )
 
+// isHostPort returns true if endpoints of the type are identified by a host and port
+func (x ChannelEndpointType) isHostPort() bool {
+	return x == ChannelEndpointTypeTCP || x == ChannelEndpointTypeUDP || x == ChannelEndpointTypeMQTT
+}
+
 // ToPb converts a ChannelEndpointType to its protobuf value
 func (x ChannelEndpointType) ToPb() string {
 	return string(x)
@@ -135,6 +180,8 @@ type ChannelEndpointDescriptor struct {
 	//     TYPE    ROLE        PATH
 	//     TCP     Stub        <local-ipv4-bind-address>:<port> for listen
 	//     TCP     Skeleton    <hostname>:<port> for connect
+	//     UDP     Stub        <local-ipv4-bind-address>:<port> for listen
+	//     UDP     Skeleton    <hostname>:<port> for connect
 	//     Unix    Stub        <Filesystem path of domain socket> for listen
 	//     Unix    Skeleton    <Filesystem path of domain socket> for connect
 	//     SOCKS   Skeleton    nil
@@ -142,15 +189,27 @@ type ChannelEndpointDescriptor struct {
 	//     Stdio   Skeleton    nil
 	//     Loop    Stub        <loop-endpoint-name> for listen
 	//     Loop    Skeleton    <loop-endpoint-name> for connect
+	//     Alias   Skeleton    <alias-name> defined by the server
+	//     Exec    Skeleton    <command-name> defined by the skeleton's proxy
+	//     TUN     Stub        <TUN device name>, opened when the stub starts
+	//     TUN     Skeleton    <TUN device name>, opened for each channel
+	//     VSOCK   Stub        <cid>:<port> for listen; the cid may be "any"
+	//     VSOCK   Skeleton    <cid>:<port> for connect
+	//     MQTT    Skeleton    <hostname>:<port> of the broker for connect
 	Path string `json:"path"`
+
+	// Options holds optional settings for channels to or from the endpoint, such as
+	// "priority". Options given for a ChannelDescriptor apply to both of its endpoints.
+	Options map[string]string `json:"options,omitempty"`
 }
 
 // ToPb converts a ChannelEndpointDescriptor to its protobuf value
 func (d *ChannelEndpointDescriptor) ToPb() *chprotobuf.PbEndpointDescriptor {
 	return &chprotobuf.PbEndpointDescriptor{
-		Role: d.Role.ToPb(),
-		Type: d.Type.ToPb(),
-		Path: d.Path,
+		Role:    d.Role.ToPb(),
+		Type:    d.Type.ToPb(),
+		Path:    d.Path,
+		Options: d.Options,
 	}
 }
 
@@ -159,14 +218,16 @@ func (d *ChannelEndpointDescriptor) FromPb(pb *chprotobuf.PbEndpointDescriptor)
 	d.Role.FromPb(pb.GetRole())
 	d.Type.FromPb(pb.GetType())
 	d.Path = pb.GetPath()
+	d.Options = pb.GetOptions()
 }
 
 // PbToChannelEndpointDescriptor returns a ChannelEndpointDescriptor from its protobuf value
 func PbToChannelEndpointDescriptor(pb *chprotobuf.PbEndpointDescriptor) *ChannelEndpointDescriptor {
 	ced := &ChannelEndpointDescriptor{
-		Role: PbToChannelEndpointRole(pb.GetRole()),
-		Type: PbToChannelEndpointType(pb.GetType()),
-		Path: pb.GetPath(),
+		Role:    PbToChannelEndpointRole(pb.GetRole()),
+		Type:    PbToChannelEndpointType(pb.GetType()),
+		Path:    pb.GetPath(),
+		Options: pb.GetOptions(),
 	}
 	return ced
 }
@@ -176,28 +237,32 @@ func (d ChannelEndpointDescriptor) Validate() error {
 	if d.Role != ChannelEndpointRoleStub && d.Role != ChannelEndpointRoleSkeleton {
 		return fmt.Errorf("%s: Unknown role type '%s'", d.String(), d.Role)
 	}
-	if d.Type == ChannelEndpointTypeTCP {
+	if d.Type.isHostPort() {
+		proto := strings.ToUpper(string(d.Type))
 		if d.Path == "" {
 			if d.Role == ChannelEndpointRoleStub {
-				return fmt.Errorf("%s: TCP stub endpoint requires a bind address and port", d.String())
+				return fmt.Errorf("%s: %s stub endpoint requires a bind address and port", d.String(), proto)
 			}
-			return fmt.Errorf("%s: TCP skeleton endpoint requires a target hostname and port", d.String())
+			return fmt.Errorf("%s: %s skeleton endpoint requires a target hostname and port", d.String(), proto)
 		}
 		host, port, err := ParseHostPort(d.Path, "", InvalidPortNumber)
 		if err != nil {
 			if d.Role == ChannelEndpointRoleStub {
-				return fmt.Errorf("%s: TCP stub endpoint <bind-address>:<port> is invalid: %v", d.String(), err)
+				return fmt.Errorf("%s: %s stub endpoint <bind-address>:<port> is invalid: %v", d.String(), proto, err)
 			}
-			return fmt.Errorf("%s: TCP skeleton endpoint <hostname>:<port> is invalid: %v", d.String(), err)
+			return fmt.Errorf("%s: %s skeleton endpoint <hostname>:<port> is invalid: %v", d.String(), proto, err)
 		}
 		if host == "" {
 			if d.Role == ChannelEndpointRoleStub {
-				return fmt.Errorf("%s: TCP stub endpoint requires a bind address: %v", d.String(), err)
+				return fmt.Errorf("%s: %s stub endpoint requires a bind address: %v", d.String(), proto, err)
 			}
-			return fmt.Errorf("%s: TCP skeleton endpoint requires a target hostname: %v", d.String(), err)
+			return fmt.Errorf("%s: %s skeleton endpoint requires a target hostname: %v", d.String(), proto, err)
 		}
 		if port == InvalidPortNumber {
-			return fmt.Errorf("%s: TCP endpoint requires a port number", d.String())
+			return fmt.Errorf("%s: %s endpoint requires a port number", d.String(), proto)
+		}
+		if d.Type == ChannelEndpointTypeMQTT && d.Role != ChannelEndpointRoleSkeleton {
+			return fmt.Errorf("%s: MQTT endpoint must be placed on the skeleton side", d.String())
 		}
 	} else if d.Type == ChannelEndpointTypeUnix {
 		if d.Path == "" {
@@ -218,9 +283,90 @@ func (d ChannelEndpointDescriptor) Validate() error {
 		if d.Role != ChannelEndpointRoleSkeleton {
 			return fmt.Errorf("%s: SOCKS endpoint must be placed on the skeleton side", d.String())
 		}
+	} else if d.Type == ChannelEndpointTypeObserve {
+		if _, err := ParseObservedChannelID(d.Path); err != nil {
+			return fmt.Errorf("%s: %s", d.String(), err)
+		}
+		if d.Role != ChannelEndpointRoleSkeleton {
+			return fmt.Errorf("%s: Observe endpoint must be placed on the skeleton side", d.String())
+		}
+	} else if d.Type == ChannelEndpointTypeAlias {
+		if err := ValidateChannelAliasName(d.Path); err != nil {
+			return fmt.Errorf("%s: %s", d.String(), err)
+		}
+		if d.Role != ChannelEndpointRoleSkeleton {
+			return fmt.Errorf("%s: Alias endpoint must be placed on the skeleton side", d.String())
+		}
+	} else if d.Type == ChannelEndpointTypeExec {
+		if err := ValidateExecCommandName(d.Path); err != nil {
+			return fmt.Errorf("%s: %s", d.String(), err)
+		}
+		if d.Role != ChannelEndpointRoleSkeleton {
+			return fmt.Errorf("%s: Exec endpoint must be placed on the skeleton side", d.String())
+		}
+	} else if d.Type == ChannelEndpointTypeTUN {
+		if err := ValidateTUNDeviceName(d.Path); err != nil {
+			return fmt.Errorf("%s: %s", d.String(), err)
+		}
+	} else if d.Type == ChannelEndpointTypeVSOCK {
+		if err := validateVSOCKEndpoint(d.Role, d.Path); err != nil {
+			return fmt.Errorf("%s: %s", d.String(), err)
+		}
 	} else {
 		return fmt.Errorf("%s: Unknown endpoint type '%s'", d.String(), d.Type)
 	}
+	return d.validateOptions()
+}
+
+// ValidateSkeleton validates a ChannelEndpointDescriptor that must be a skeleton, such as the
+// endpoint of a NewChannel request
+func (d ChannelEndpointDescriptor) ValidateSkeleton() error {
+	if d.Role != ChannelEndpointRoleSkeleton {
+		return fmt.Errorf("%s: Role must be skeleton", d.String())
+	}
+	return d.Validate()
+}
+
+// validateOptions verifies that the endpoint's options are known and valid, and that those
+// that apply to an endpoint of its role are supported by its type. Options that only apply
+// to the other endpoint of a channel are ignored, since channel options are given to both.
+func (d ChannelEndpointDescriptor) validateOptions() error {
+	for name, value := range d.Options {
+		validate, ok := descriptorOptionValidators[name]
+		if !ok {
+			return fmt.Errorf("%s: Unknown option '%s'", d.String(), name)
+		}
+		if err := validate(value); err != nil {
+			return fmt.Errorf("%s: Invalid option '%s=%s': %s", d.String(), name, value, err)
+		}
+	}
+	if d.Role == ChannelEndpointRoleStub {
+		if bindAnyOption(d.Options) {
+			if !d.Type.isHostPort() {
+				return fmt.Errorf("%s: The bind-any option is only supported on TCP and UDP stub endpoints", d.String())
+			}
+			if !EndpointBindsAny(&d) {
+				return fmt.Errorf("%s: The bind-any option conflicts with the stub bind address", d.String())
+			}
+		}
+		if HasStubTLSOptions(&d) {
+			if d.Type != ChannelEndpointTypeTCP {
+				return fmt.Errorf("%s: The tls-cert, tls-key and tls-client-ca options are only supported on TCP stub endpoints", d.String())
+			}
+			if err := checkStubTLSOptions(&d); err != nil {
+				return fmt.Errorf("%s: %s", d.String(), err)
+			}
+		}
+	} else {
+		for _, name := range []string{"shadow", "pin", "source"} {
+			if d.Option(name) != "" && d.Type != ChannelEndpointTypeTCP {
+				return fmt.Errorf("%s: The %s option is only supported on TCP skeleton endpoints", d.String(), name)
+			}
+		}
+		if err := CheckIdentEndpoint(&d); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
@@ -244,7 +390,111 @@ func (d ChannelEndpointDescriptor) LongString() string {
 		typeName = "unknown"
 	}
 
-	return "ChannelEndpointDescriptor(role='" + roleName + "', type='" + typeName + "', path='" + d.Path + "')"
+	result := "ChannelEndpointDescriptor(role='" + roleName + "', type='" + typeName + "', path='" + d.Path + "'"
+	if len(d.Options) > 0 {
+		result += ", options='" + FormatDescriptorOptions(d.Options) + "'"
+	}
+	return result + ")"
+}
+
+// Option returns the value of an endpoint option, or "" if it is not set
+func (d ChannelEndpointDescriptor) Option(name string) string {
+	return d.Options[name]
+}
+
+// descriptorOptionValidators holds a validation function for each known descriptor option
+var descriptorOptionValidators = map[string]func(value string) error{
+	"priority": func(value string) error {
+		_, err := ParseChannelPriority(value)
+		return err
+	},
+	"e2e": func(value string) error {
+		_, err := ParseE2EPublicKey(value)
+		return err
+	},
+	"record":      validateRecordOption,
+	"ident":       validateIdentOption,
+	"idle":        validateIdleOption,
+	"accept-rate": validateAcceptRateOption,
+	"hold":        validateHoldOption,
+	"max-bytes":   validateMaxBytesOption,
+	"shadow":      validateShadowOption,
+	"pin":         validatePinOption,
+	"source":      validateSourceOption,
+	"bind-any":    validateBindAnyOption,
+	"delay":       validateDelayOption,
+	"jitter":      validateDelayOption,
+	"rate":        validateRateOption,
+	"loss":        validateLossOption,
+	"compress":    validateCompressOption,
+
+	"tls-cert":      validateStubTLSFileOption,
+	"tls-key":       validateStubTLSFileOption,
+	"tls-client-ca": validateStubTLSFileOption,
+
+	"mtu":           validateMTUOption,
+	"tun-addr":      validateTUNAddrOption,
+	"tun-peer-addr": validateTUNAddrOption,
+}
+
+// SplitDescriptorOptions separates the "?"-prefixed options suffix from a descriptor string:
+//
+//    <descriptor>?<name>=<value>[,<name>=<value>...]
+//
+// A '?' inside brackets or escaped with '\' does not start the options. Unknown option names
+// and invalid values are rejected. If there are no options, the returned map is nil.
+func SplitDescriptorOptions(s string) (string, map[string]string, error) {
+	bStack := &bracketStack{}
+	haveBackslash := false
+	optPos := -1
+	for i, c := range s {
+		if haveBackslash {
+			haveBackslash = false
+		} else if c == '\\' {
+			haveBackslash = true
+		} else if c == '[' || c == '<' {
+			bStack.pushBracket(c)
+		} else if c == ']' || c == '>' {
+			bStack.popBracket()
+		} else if c == '?' && bStack.isBalanced() {
+			optPos = i
+			break
+		}
+	}
+	if optPos < 0 {
+		return s, nil, nil
+	}
+	options := make(map[string]string)
+	for _, opt := range strings.Split(s[optPos+1:], ",") {
+		kv := strings.SplitN(opt, "=", 2)
+		if len(kv) != 2 || kv[0] == "" {
+			return "", nil, fmt.Errorf("Invalid option '%s' in descriptor '%s'; expected <name>=<value>", opt, s)
+		}
+		validate, ok := descriptorOptionValidators[kv[0]]
+		if !ok {
+			return "", nil, fmt.Errorf("Unknown option '%s' in descriptor '%s'", kv[0], s)
+		}
+		if err := validate(kv[1]); err != nil {
+			return "", nil, fmt.Errorf("Invalid option '%s' in descriptor '%s': %s", opt, s, err)
+		}
+		options[kv[0]] = kv[1]
+	}
+	return s[:optPos], options, nil
+}
+
+// FormatDescriptorOptions formats options as a descriptor options suffix, without the
+// leading '?', in sorted order
+func FormatDescriptorOptions(options map[string]string) string {
+	names := make([]string, 0, len(options))
+	for name := range options {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	parts := make([]string, len(names))
+	for i, name := range names {
+		parts[i] = name + "=" + options[name]
+	}
+	return strings.Join(parts, ",")
 }
 
 type bracketStack struct {
@@ -505,11 +755,23 @@ func ParseNextChannelEndpointDescriptor(parts []string, role ChannelEndpointRole
 			d.Type = ChannelEndpointTypeSocks
 			lastI = i
 			break
-		} else if sp == "tcp" {
+		} else if sp == "tcp" || sp == "tcp4" || sp == "tcp6" {
 			if haveType {
 				break
 			}
 			d.Type = ChannelEndpointTypeTCP
+			if sp != "tcp" {
+				d.setFamily(sp[3:])
+			}
+			haveType = true
+		} else if sp == "udp" || sp == "udp4" || sp == "udp6" {
+			if haveType {
+				break
+			}
+			d.Type = ChannelEndpointTypeUDP
+			if sp != "udp" {
+				d.setFamily(sp[3:])
+			}
 			haveType = true
 		} else if sp == "unix" {
 			if haveType {
@@ -523,11 +785,65 @@ func ParseNextChannelEndpointDescriptor(parts []string, role ChannelEndpointRole
 			}
 			d.Type = ChannelEndpointTypeLoop
 			haveType = true
+		} else if sp == "observe" {
+			if haveType {
+				break
+			}
+			d.Type = ChannelEndpointTypeObserve
+			haveType = true
+		} else if sp == "alias" {
+			if haveType {
+				break
+			}
+			d.Type = ChannelEndpointTypeAlias
+			haveType = true
+		} else if sp == "exec" {
+			if haveType {
+				break
+			}
+			d.Type = ChannelEndpointTypeExec
+			haveType = true
+		} else if sp == "tun" {
+			if haveType {
+				break
+			}
+			d.Type = ChannelEndpointTypeTUN
+			haveType = true
+		} else if sp == "vsock" {
+			if haveType {
+				break
+			}
+			d.Type = ChannelEndpointTypeVSOCK
+			haveType = true
+		} else if sp == "mqtt" {
+			if haveType {
+				break
+			}
+			d.Type = ChannelEndpointTypeMQTT
+			haveType = true
+		} else if d.Type == ChannelEndpointTypeVSOCK && !havePath {
+			// A VSOCK address is a context ID, which looks like a port number, then a port
+			if d.Path == "" {
+				d.Path = sp
+			} else {
+				d.Path = d.Path + ":" + sp
+				havePath = true
+				lastI = i
+				break
+			}
+		} else if d.Type == ChannelEndpointTypeObserve && !havePath {
+			// An observed channel ID looks like a port number
+			d.Path = sp
+			havePath = true
+			lastI = i
+			break
 		} else if IsPortNumberString(sp) {
-			if haveType && d.Type != ChannelEndpointTypeTCP {
+			if haveType && !d.Type.isHostPort() {
 				break
 			}
-			d.Type = ChannelEndpointTypeTCP
+			if !haveType {
+				d.Type = ChannelEndpointTypeTCP
+			}
 			port, _ := ParsePortNumber(sp)
 			d.Path = d.Path + ":" + port.String()
 			lastI = i
@@ -586,17 +902,17 @@ func ParseNextChannelEndpointDescriptor(parts []string, role ChannelEndpointRole
 				havePath = true
 			} else {
 				// a path to go with explicitly provided endpoint type
-				if d.Type != ChannelEndpointTypeTCP {
+				if !d.Type.isHostPort() {
 					d.Path = StripAngleBrackets(sp)
 					havePath = true
 					lastI = i
 					break
 				}
-				// A TCP path may contain a port number already in it, or
+				// A TCP or UDP path may contain a port number already in it, or
 				// consist of nothing but a port
 				host, port, err := ParseHostPort(sp, "", UnknownPortNumber)
 				if err != nil {
-					return nil, parts, fmt.Errorf("Invalid TCP host/port in endpoint descriptor string'%s': '%s'", s, err)
+					return nil, parts, fmt.Errorf("Invalid %s host/port in endpoint descriptor string'%s': '%s'", strings.ToUpper(string(d.Type)), s, err)
 				}
 				if port == UnknownPortNumber {
 					d.Path = host
@@ -616,7 +932,8 @@ func ParseNextChannelEndpointDescriptor(parts []string, role ChannelEndpointRole
 		return nil, parts, fmt.Errorf("Unable to determine type from endpoint descriptor string '%s'", s)
 	}
 
-	if (d.Type == ChannelEndpointTypeUnix || d.Type == ChannelEndpointTypeLoop) && d.Path == "" {
+	if (d.Type == ChannelEndpointTypeUnix || d.Type == ChannelEndpointTypeLoop || d.Type == ChannelEndpointTypeObserve ||
+		d.Type == ChannelEndpointTypeAlias || d.Type == ChannelEndpointTypeExec || d.Type == ChannelEndpointTypeTUN || d.Type == ChannelEndpointTypeVSOCK) && d.Path == "" {
 		return nil, parts, fmt.Errorf("Missing endpoint path in endpoint descriptor string '%s'", s)
 	}
 
@@ -0,0 +1,80 @@
+package chshare
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// ValidateExecCommandName returns an error if name is not a valid exec command name
+func ValidateExecCommandName(name string) error {
+	if !channelAliasNameRegexp.MatchString(name) {
+		return fmt.Errorf("Invalid exec command name '%s'; must start with a letter and contain only letters, digits, '_', '.' and '-'", name)
+	}
+	return nil
+}
+
+// ExecCommands is the set of named commands that exec skeleton endpoints may run. The remote
+// proxy asks for a command by name, e.g. "exec:rsync", and only the proxy running the command
+// decides its command line, so that a peer cannot run arbitrary programs.
+type ExecCommands struct {
+	commands map[string][]string
+}
+
+// ParseExecCommands parses a list of command definitions, each written as
+// <name>=<command line>. The command line is split at whitespace into the program and its
+// arguments; it is not run by a shell.
+func ParseExecCommands(defs []string) (*ExecCommands, error) {
+	c := &ExecCommands{
+		commands: make(map[string][]string),
+	}
+	for _, def := range defs {
+		parts := strings.SplitN(def, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("Invalid exec command '%s'; expected <name>=<command line>", def)
+		}
+		name := strings.TrimSpace(parts[0])
+		if err := ValidateExecCommandName(name); err != nil {
+			return nil, err
+		}
+		if _, ok := c.commands[name]; ok {
+			return nil, fmt.Errorf("Duplicate exec command name '%s'", name)
+		}
+		args := strings.Fields(parts[1])
+		if len(args) == 0 {
+			return nil, fmt.Errorf("Empty command line for exec command '%s'", name)
+		}
+		c.commands[name] = args
+	}
+	return c, nil
+}
+
+func (c *ExecCommands) String() string {
+	names := make([]string, 0, len(c.commands))
+	for name := range c.commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ",")
+}
+
+// Has returns true if a command is defined with the given name. A nil ExecCommands has none.
+func (c *ExecCommands) Has(name string) bool {
+	if c == nil {
+		return false
+	}
+	_, ok := c.commands[name]
+	return ok
+}
+
+// Lookup returns the program and arguments of the named command
+func (c *ExecCommands) Lookup(name string) ([]string, error) {
+	if c == nil {
+		return nil, fmt.Errorf("Exec endpoints are disabled")
+	}
+	args, ok := c.commands[name]
+	if !ok {
+		return nil, fmt.Errorf("Unknown exec command '%s'", name)
+	}
+	return args, nil
+}
@@ -0,0 +1,135 @@
+package chshare
+
+import (
+	"context"
+	"io"
+	"os"
+	"os/exec"
+	"sync"
+)
+
+// ExecSkeletonEndpoint implements a local exec skeleton. Each channel starts its own process
+// running the named command (see ExecCommands), and is bridged to the process's stdin and
+// stdout, as OpenSSH does for a command. The process's stderr goes to this proxy's stderr.
+// Closing the channel's write side closes the process's stdin; closing the channel kills the
+// process if it has not already exited.
+type ExecSkeletonEndpoint struct {
+	// Implements LocalSkeletonChannelEndpoint
+	BasicEndpoint
+	args []string
+}
+
+// NewExecSkeletonEndpoint creates a new ExecSkeletonEndpoint
+func NewExecSkeletonEndpoint(logger Logger, ced *ChannelEndpointDescriptor, commands *ExecCommands) (*ExecSkeletonEndpoint, error) {
+	args, err := commands.Lookup(ced.Path)
+	if err != nil {
+		return nil, err
+	}
+	ep := &ExecSkeletonEndpoint{
+		BasicEndpoint: BasicEndpoint{
+			ced: ced,
+		},
+		args: args,
+	}
+	ep.InitBasicEndpoint(logger, ep, "ExecSkeletonEndpoint: %s", ced)
+	return ep, nil
+}
+
+// HandleOnceShutdown will be called exactly once, in its own goroutine. It should take completionError
+// as an advisory completion value, actually shut down, then return the real completion value.
+func (ep *ExecSkeletonEndpoint) HandleOnceShutdown(completionErr error) error {
+	return completionErr
+}
+
+// Dial starts a new process for the command, and returns a connection to its stdin and
+// stdout. Part of the DialerChannelEndpoint interface
+func (ep *ExecSkeletonEndpoint) Dial(ctx context.Context, extraData []byte) (ChannelConn, error) {
+	ep.DLogf("Starting command %q", ep.args)
+
+	if ep.IsStartedShutdown() {
+		err := ep.Errorf("Endpoint is closed: %s", ep.String())
+		return nil, err
+	}
+
+	cmd := exec.Command(ep.args[0], ep.args[1:]...)
+	cmd.Stderr = os.Stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, ep.Errorf("Unable to create stdin pipe: %s", err)
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		stdin.Close()
+		return nil, ep.Errorf("Unable to create stdout pipe: %s", err)
+	}
+	if err := cmd.Start(); err != nil {
+		stdin.Close()
+		stdout.Close()
+		return nil, ep.Errorf("Unable to start command %q: %s", ep.args, err)
+	}
+
+	output := &execProcessOutput{
+		ReadCloser: stdout,
+		ep:         ep,
+		cmd:        cmd,
+	}
+	conn, err := NewPipeConn(ep.Logger, output, stdin)
+	if err != nil {
+		stdin.Close()
+		output.Close()
+		return nil, ep.Errorf("Unable to create PipeConn: %s", err)
+	}
+
+	ep.AddShutdownChild(conn)
+
+	ep.DLogf("Started command %s, pid %d", ep.ced.Path, cmd.Process.Pid)
+	return conn, nil
+}
+
+// DialAndServe initiates a new connection to a Called Service as specified in the
+// endpoint configuration, then services the connection using an already established
+// callerConn as the proxied Caller's end of the session. This call does not return until
+// the bridged session completes or an error occurs. The context may be used to cancel
+// connection or servicing of the active session.
+// Ownership of callerConn is transferred to this function, and it will be closed before
+// this function returns, regardless of whether an error occurs.
+// The return value is a tuple consisting of:
+//        Number of bytes sent from callerConn to the dialed calledServiceConn
+//        Number of bytes sent from the dialed calledServiceConn callerConn
+//        An error, if one occured during dial or copy in either direction
+func (ep *ExecSkeletonEndpoint) DialAndServe(
+	ctx context.Context,
+	callerConn ChannelConn,
+	extraData []byte,
+) (int64, int64, error) {
+	calledServiceConn, err := ep.Dial(ctx, extraData)
+	if err != nil {
+		closeOnDialFailure(callerConn, err)
+		return 0, 0, err
+	}
+	return BasicBridgeChannels(ctx, ep.Logger, callerConn, calledServiceConn)
+}
+
+// execProcessOutput is the stdout of an exec skeleton's process. Closing it also ends the
+// process, killing it if it is still running, and reaps it.
+type execProcessOutput struct {
+	io.ReadCloser
+	ep        *ExecSkeletonEndpoint
+	cmd       *exec.Cmd
+	closeOnce sync.Once
+	closeErr  error
+}
+
+func (o *execProcessOutput) Close() error {
+	o.closeOnce.Do(func() {
+		o.closeErr = o.ReadCloser.Close()
+		o.cmd.Process.Kill()
+		err := o.cmd.Wait()
+		if err != nil {
+			o.ep.DLogf("Command %s, pid %d ended: %s", o.ep.ced.Path, o.cmd.Process.Pid, err)
+		} else {
+			o.ep.DLogf("Command %s, pid %d exited", o.ep.ced.Path, o.cmd.Process.Pid)
+		}
+	})
+	return o.closeErr
+}
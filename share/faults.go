@@ -0,0 +1,54 @@
+package chshare
+
+import (
+	"net"
+	"time"
+)
+
+// FaultSide is the side of the connection between a client and the server on which a fault is
+// injected
+type FaultSide string
+
+const (
+	// FaultSideClient is the client's side of the connection
+	FaultSideClient FaultSide = "client"
+
+	// FaultSideServer is the server's side of the connection
+	FaultSideServer FaultSide = "server"
+)
+
+// FaultInjector lets tests inject failures into the connections between clients and the
+// server, such as failed dials, slow handshakes and reset websockets, to exercise reconnection,
+// timeout and resumption logic (see chiseltest.Faults).
+type FaultInjector interface {
+	// DialFault is called before the client connects to server. A non-nil error fails the
+	// connection attempt, as if the server could not be reached.
+	DialFault(server string) error
+
+	// HandshakeDelay returns how long to wait, on side, before the SSH handshake on a new
+	// connection
+	HandshakeDelay(side FaultSide) time.Duration
+
+	// WrapTransport is called, on side, with each new websocket or long-poll transport
+	// between a client and the server, and returns the transport to use in its place, e.g.
+	// one that the test can reset
+	WrapTransport(side FaultSide, transport net.Conn) net.Conn
+}
+
+// NoFaults is a FaultInjector that injects no faults
+type NoFaults struct{}
+
+// DialFault returns nil
+func (NoFaults) DialFault(server string) error {
+	return nil
+}
+
+// HandshakeDelay returns 0
+func (NoFaults) HandshakeDelay(side FaultSide) time.Duration {
+	return 0
+}
+
+// WrapTransport returns transport
+func (NoFaults) WrapTransport(side FaultSide, transport net.Conn) net.Conn {
+	return transport
+}
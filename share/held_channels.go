@@ -0,0 +1,236 @@
+package chshare
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// holdHandshakeTimeout is how long the skeleton waits for the first frame of a held channel
+const holdHandshakeTimeout = 10 * time.Second
+
+// heldChannel is the skeleton side of a channel with the "hold" option
+type heldChannel struct {
+	conn *HoldConn
+
+	// skeleton is the skeleton endpoint the channel was opened for, which a channel that
+	// re-establishes it must ask for too
+	skeleton string
+}
+
+// HeldChannels holds the skeleton side of channels with the "hold" option, by ID, so that
+// they outlive the session they were opened in, and can be re-established in another session
+// after the connection between the proxies is lost
+type HeldChannels struct {
+	ShutdownHelper
+	stats *StatsRegistry
+	clock Clock
+
+	// ctx is cancelled when shutdown starts
+	ctx       context.Context
+	ctxCancel context.CancelFunc
+
+	// lock protects channels
+	lock     sync.Mutex
+	channels map[string]*heldChannel
+}
+
+// NewHeldChannels creates a new HeldChannels
+func NewHeldChannels(logger Logger, stats *StatsRegistry, clock Clock) *HeldChannels {
+	h := &HeldChannels{
+		stats:    stats,
+		clock:    clock,
+		channels: make(map[string]*heldChannel),
+	}
+	h.ctx, h.ctxCancel = context.WithCancel(context.Background())
+	h.InitShutdownHelper(logger.Fork("HeldChannels"), h)
+	h.PanicOnError(h.Activate())
+	return h
+}
+
+// HandleOnceShutdown will be called exactly once, in its own goroutine. It should take completionError
+// as an advisory completion value, actually shut down, then return the real completion value.
+func (h *HeldChannels) HandleOnceShutdown(completionErr error) error {
+	h.ctxCancel()
+	h.lock.Lock()
+	var conns []*HoldConn
+	for _, ch := range h.channels {
+		conns = append(conns, ch.conn)
+	}
+	h.lock.Unlock()
+	for _, conn := range conns {
+		conn.Close()
+	}
+	return completionErr
+}
+
+// remove forgets a held channel that has been closed
+func (h *HeldChannels) remove(id string, conn *HoldConn) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	if ch, ok := h.channels[id]; ok && ch.conn == conn {
+		delete(h.channels, id)
+	}
+}
+
+// accept reads the first frame of conn, a newly accepted channel to the skeleton endpoint
+// epd, and attaches conn to the new held channel it opens or the one it re-establishes.
+// Returns the held channel, a chan that is closed when conn stops carrying its traffic, and
+// true if the channel is new.
+func (h *HeldChannels) accept(logger Logger, epd *ChannelEndpointDescriptor, conn ChannelConn, holdTime time.Duration) (*HoldConn, <-chan struct{}, bool, error) {
+	r := bufio.NewReader(conn)
+	timer := h.clock.AfterFunc(holdHandshakeTimeout, func() { conn.Close() })
+	frameType, value, payload, err := readHoldFrame(r)
+	timer.Stop()
+	if err != nil {
+		conn.Close()
+		return nil, nil, false, err
+	}
+	id := string(payload)
+	switch frameType {
+	case holdFrameOpen:
+		c := newHoldConn(logger, h.stats, h.clock, id, holdTime)
+		h.lock.Lock()
+		if _, ok := h.channels[id]; ok || h.IsStartedShutdown() {
+			h.lock.Unlock()
+			c.Close()
+			conn.Close()
+			return nil, nil, false, fmt.Errorf("Held channel %s already exists", id)
+		}
+		h.channels[id] = &heldChannel{conn: c, skeleton: epd.LongString()}
+		h.lock.Unlock()
+		c.onClose = func() { h.remove(id, c) }
+		if err := writeHoldFrame(conn, holdFrameResume, 0, payload); err != nil {
+			c.Close()
+			conn.Close()
+			return nil, nil, false, err
+		}
+		done, err := c.attach(conn, r, 0)
+		if err != nil {
+			c.Close()
+			return nil, nil, false, err
+		}
+		return c, done, true, nil
+	case holdFrameResume:
+		h.lock.Lock()
+		ch := h.channels[id]
+		h.lock.Unlock()
+		if ch == nil || ch.skeleton != epd.LongString() {
+			info, _ := json.Marshal(&ChannelCloseInfo{Reason: CloseReasonError, Message: "Held channel has expired"})
+			writeHoldFrame(conn, holdFrameClose, 0, info)
+			conn.Close()
+			return nil, nil, false, fmt.Errorf("Unknown held channel %s", id)
+		}
+		done, err := ch.conn.reattach(conn, r, value)
+		if err != nil {
+			return nil, nil, false, err
+		}
+		return ch.conn, done, false, nil
+	default:
+		conn.Close()
+		return nil, nil, false, fmt.Errorf("Unexpected held channel frame type %d", frameType)
+	}
+}
+
+// serve connects a new held channel to its skeleton endpoint, and serves it until the
+// channel is closed. The channel outlives the session that opened it, so only its metadata
+// is kept.
+func (h *HeldChannels) serve(md *RequestMetadata, logger Logger, env LocalChannelEnv, epd *ChannelEndpointDescriptor, conn *HoldConn) {
+	ctx := WithRequestMetadata(h.ctx, md)
+	ep, err := NewLocalSkeletonChannelEndpoint(logger, env, epd)
+	if err != nil {
+		logger.DLogf("Failed to create skeleton endpoint for held channel: %s", err)
+		closeOnDialFailure(conn, err)
+		return
+	}
+	h.AddShutdownChild(ep)
+	defer ep.Close()
+
+	callerConn, err := WrapE2EChannelConn(env, epd, conn)
+	if err != nil {
+		logger.DLogf("Failed to set up end-to-end encryption: %s", err)
+		conn.Close()
+		return
+	}
+
+	callerConn, err = WrapCompressChannelConn(epd, callerConn)
+	if err != nil {
+		logger.DLogf("Failed to set up compression: %s", err)
+		conn.Close()
+		return
+	}
+
+	callerConn = env.TapChannel(ctx, epd, callerConn)
+	callerConn = env.IdentifyChannel(epd, callerConn)
+	callerConn = ShadowChannelConn(logger, env, epd, callerConn)
+	callerConn = env.LimitChannel(epd, callerConn)
+
+	numSent, numReceived, err := ep.DialAndServe(ctx, callerConn, nil)
+	logChannelClose(ctx, logger, env.GetStatsRegistry(), channelCloseInfo(conn.CloseReason(), err), numSent, numReceived)
+}
+
+// reattach attaches conn, a channel that re-establishes this held channel on the skeleton
+// side, after the stub has received peerReceived bytes. The channel it replaces is closed, in
+// case the skeleton has not yet noticed that it was lost.
+func (c *HoldConn) reattach(conn ChannelConn, r *bufio.Reader, peerReceived int64) (<-chan struct{}, error) {
+	c.holdLock.Lock()
+	old := c.att
+	prevDone := c.readerDone
+	c.holdLock.Unlock()
+	if old != nil {
+		c.detach(old, fmt.Errorf("Channel re-established by remote proxy"))
+	}
+	// the number of bytes received is final once the lost channel's reader has finished
+	<-prevDone
+	c.holdLock.Lock()
+	received := c.received
+	c.holdLock.Unlock()
+	if err := writeHoldFrame(conn, holdFrameResume, received, []byte(c.id)); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return c.attach(conn, r, peerReceived)
+}
+
+// serveHeldChannel serves a new channel to a skeleton endpoint with the "hold" option, which
+// either opens a held channel or re-establishes one whose connection was lost. It returns
+// when the channel stops carrying the held channel's traffic.
+func (s *SSHSession) serveHeldChannel(ctx context.Context, held *HeldChannels, ch ssh.NewChannel, epd *ChannelEndpointDescriptor, holdTime time.Duration) error {
+	sshChannel, sshRequests, err := ch.Accept()
+	if err != nil {
+		s.DLogf("Failed to accept SSH NewChannel: %s", err)
+		return err
+	}
+
+	sshConn, err := NewSSHConn(s.Logger, sshChannel, sshRequests)
+	if err != nil {
+		s.DLogf("Failed wrap SSH NewChannel: %s", err)
+		sshChannel.Close()
+		return err
+	}
+
+	sshConn.SetPriority(s.localChannelEnv.GetWriteScheduler(), EndpointChannelPriority(epd))
+
+	conn, done, isNew, err := held.accept(s.Logger, epd, sshConn, holdTime)
+	if err != nil {
+		return s.DLogErrorf("Unable to serve held channel: %s", err)
+	}
+
+	atomic.AddInt32(&s.activeChannels, 1)
+	defer atomic.AddInt32(&s.activeChannels, -1)
+
+	defer s.localChannelEnv.TrackChannel(epd, conn.newAttachment())()
+
+	if isNew {
+		go held.serve(RequestMetadataFromContext(ctx), s.Logger, s.localChannelEnv, epd, conn)
+	}
+
+	<-done
+	return nil
+}
@@ -0,0 +1,594 @@
+package chshare
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// MaxHoldTime is the longest a held channel may wait to be re-established
+const MaxHoldTime = time.Minute
+
+// The traffic of a channel with the "hold" option is carried in frames, so that each side
+// can tell a channel it may re-establish from one that has ended, and knows which bytes the
+// other side has received. Each frame is a type byte, a big-endian int64 value and a
+// big-endian uint32 payload length, followed by the payload.
+const (
+	// holdFrameOpen is the first frame the stub sends on a new held channel. The payload is
+	// the channel's ID.
+	holdFrameOpen byte = 1
+
+	// holdFrameResume is the first frame the stub sends on a channel that re-establishes a
+	// held channel, and the skeleton's reply to holdFrameOpen or holdFrameResume. The value
+	// is the number of bytes the sender has received, and the payload is the channel's ID.
+	holdFrameResume byte = 2
+
+	// holdFrameData carries data
+	holdFrameData byte = 3
+
+	// holdFrameAck tells the other side how many bytes have been received, in the value, so
+	// that it can discard them
+	holdFrameAck byte = 4
+
+	// holdFrameFin is the end of the sender's data
+	holdFrameFin byte = 5
+
+	// holdFrameClose closes the channel. The payload is a JSON ChannelCloseInfo, or empty.
+	holdFrameClose byte = 6
+)
+
+const holdFrameHeaderSize = 13
+
+// holdMaxFrameData is the largest payload of a data frame
+const holdMaxFrameData = 32 * 1024
+
+// holdMaxFramePayload is the largest payload of any frame that is accepted
+const holdMaxFramePayload = 64 * 1024
+
+// holdAckInterval is the number of bytes received after which an ack is sent
+const holdAckInterval = 64 * 1024
+
+// holdBufferSize is the number of unacknowledged bytes above which writes block
+const holdBufferSize = 1024 * 1024
+
+// holdReopenDelay is the delay between attempts to re-establish a held channel
+const holdReopenDelay = 250 * time.Millisecond
+
+// validateHoldOption validates the value of the "hold" descriptor option
+func validateHoldOption(value string) error {
+	_, err := parseHoldOption(value)
+	return err
+}
+
+// parseHoldOption parses the value of the "hold" descriptor option
+func parseHoldOption(value string) (time.Duration, error) {
+	d, err := time.ParseDuration(value)
+	if err != nil || d <= 0 || d > MaxHoldTime {
+		return 0, fmt.Errorf("Invalid hold time '%s'; must be a positive duration of at most %s", value, MaxHoldTime)
+	}
+	return d, nil
+}
+
+// EndpointHoldTime returns how long an endpoint's channels are held open while the
+// connection between the proxies is re-established, or 0 if they are not
+func EndpointHoldTime(ced *ChannelEndpointDescriptor) time.Duration {
+	d, _ := parseHoldOption(ced.Option("hold"))
+	return d
+}
+
+// writeHoldFrame writes a single frame
+func writeHoldFrame(w io.Writer, frameType byte, value int64, payload []byte) error {
+	b := make([]byte, holdFrameHeaderSize+len(payload))
+	b[0] = frameType
+	binary.BigEndian.PutUint64(b[1:9], uint64(value))
+	binary.BigEndian.PutUint32(b[9:13], uint32(len(payload)))
+	copy(b[holdFrameHeaderSize:], payload)
+	_, err := w.Write(b)
+	return err
+}
+
+// readHoldFrame reads a single frame
+func readHoldFrame(r io.Reader) (byte, int64, []byte, error) {
+	var header [holdFrameHeaderSize]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return 0, 0, nil, err
+	}
+	n := binary.BigEndian.Uint32(header[9:13])
+	if n > holdMaxFramePayload {
+		return 0, 0, nil, fmt.Errorf("Held channel frame too large: %d bytes", n)
+	}
+	payload := make([]byte, n)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return 0, 0, nil, err
+	}
+	return header[0], int64(binary.BigEndian.Uint64(header[1:9])), payload, nil
+}
+
+// HoldReopenFunc opens a new channel to the remote proxy for the same skeleton endpoint, to
+// re-establish a held channel, giving up at the deadline
+type HoldReopenFunc func(deadline time.Time) (ChannelConn, error)
+
+// HoldConn is a ChannelConn to the remote proxy for a channel with the "hold" option. If the
+// connection between the proxies is lost, the channel is held open for the hold time: the
+// stub keeps opening new channels to re-establish it, the skeleton attaches the first that
+// arrives with the channel's ID, and the bytes the other side had not received are sent
+// again, so that the local connection never notices. The channel is closed if it cannot be
+// re-established in time.
+type HoldConn struct {
+	BasicConn
+
+	// id identifies the channel when it is re-established
+	id string
+
+	holdTime time.Duration
+	clock    Clock
+
+	// reopen re-establishes the channel on the stub side, or is nil on the skeleton side
+	reopen HoldReopenFunc
+
+	// onClose is called when the connection is closed, or is nil
+	onClose func()
+
+	// pipeReader and pipeWriter pass received data to Read
+	pipeReader *io.PipeReader
+	pipeWriter *io.PipeWriter
+
+	resumesStat  *Stat
+	expiriesStat *Stat
+
+	// writeLock serializes writing frames to the current channel
+	writeLock sync.Mutex
+
+	// holdLock protects the fields below, and cond waits for them to change
+	holdLock sync.Mutex
+	cond     *sync.Cond
+
+	// att is the channel currently carrying the traffic, or nil while the channel is held
+	att ChannelConn
+
+	// attSent is the number of bytes sent so far over att
+	attSent int64
+
+	// readerDone is closed when the reader of the last attached channel has finished
+	readerDone chan struct{}
+
+	// sent is the number of bytes written, acked the number the other side has received,
+	// and unacked holds the bytes in between
+	sent    int64
+	acked   int64
+	unacked []byte
+
+	// received is the number of bytes received, and ackSent the number last acknowledged
+	received int64
+	ackSent  int64
+
+	// finSent is true once CloseWrite has been called
+	finSent bool
+
+	// closed is true once the connection is closing
+	closed bool
+
+	// detachedAt is when the last channel was lost, and holdTimer closes the connection if
+	// it is not re-established in time
+	detachedAt time.Time
+	holdTimer  ClockTimer
+
+	// remoteCloseInfo is the reason the remote proxy gave for closing the channel
+	remoteCloseInfo *ChannelCloseInfo
+}
+
+func newHoldConn(logger Logger, stats *StatsRegistry, clock Clock, id string, holdTime time.Duration) *HoldConn {
+	c := &HoldConn{
+		id:         id,
+		holdTime:   holdTime,
+		clock:      clock,
+		readerDone: make(chan struct{}),
+	}
+	close(c.readerDone)
+	c.cond = sync.NewCond(&c.holdLock)
+	c.pipeReader, c.pipeWriter = io.Pipe()
+	c.resumesStat = stats.Counter(
+		"chisel_channel_hold_resumes_total",
+		"Number of held channels re-established after the connection between the proxies was lost",
+		nil)
+	c.expiriesStat = stats.Counter(
+		"chisel_channel_hold_expiries_total",
+		"Number of held channels closed because they could not be re-established within their hold time",
+		nil)
+	c.InitBasicConn(logger, c, "HoldConn")
+	return c
+}
+
+// DialHoldConn starts a held channel on the stub side over conn, a newly opened channel to
+// the remote proxy, and returns the connection that carries its traffic. reopen is used to
+// re-establish the channel if the connection between the proxies is lost.
+func DialHoldConn(logger Logger, stats *StatsRegistry, clock Clock, holdTime time.Duration, conn ChannelConn, reopen HoldReopenFunc) (*HoldConn, error) {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	c := newHoldConn(logger, stats, clock, hex.EncodeToString(b[:]), holdTime)
+	c.reopen = reopen
+	if err := c.handshake(conn, holdFrameOpen, clock.Now().Add(holdTime)); err != nil {
+		c.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// handshake asks the skeleton to attach conn to the channel, and attaches it when the
+// skeleton agrees
+func (c *HoldConn) handshake(conn ChannelConn, frameType byte, deadline time.Time) error {
+	// don't wait for the reply past the deadline
+	timer := c.clock.AfterFunc(deadline.Sub(c.clock.Now()), func() { conn.Close() })
+	defer timer.Stop()
+	c.holdLock.Lock()
+	received := c.received
+	c.holdLock.Unlock()
+	if err := writeHoldFrame(conn, frameType, received, []byte(c.id)); err != nil {
+		conn.Close()
+		return err
+	}
+	r := bufio.NewReader(conn)
+	replyType, value, payload, err := readHoldFrame(r)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	switch replyType {
+	case holdFrameResume:
+	case holdFrameClose:
+		conn.Close()
+		info := &ChannelCloseInfo{}
+		if json.Unmarshal(payload, info) == nil && info.Reason != "" {
+			c.setRemoteCloseInfo(info)
+			return fmt.Errorf("Remote proxy closed held channel: %s", info)
+		}
+		return fmt.Errorf("Remote proxy closed held channel")
+	default:
+		conn.Close()
+		return fmt.Errorf("Unexpected held channel frame type %d", replyType)
+	}
+	_, err = c.attach(conn, r, value)
+	return err
+}
+
+// attach makes conn carry the channel's traffic, starting with the bytes the other side has
+// not received. It returns a chan that is closed when conn is lost or closed.
+func (c *HoldConn) attach(conn ChannelConn, r *bufio.Reader, peerReceived int64) (<-chan struct{}, error) {
+	c.writeLock.Lock()
+	defer c.writeLock.Unlock()
+	c.holdLock.Lock()
+	if c.closed {
+		c.holdLock.Unlock()
+		conn.Close()
+		return nil, c.Errorf("Held channel is closed")
+	}
+	if peerReceived < c.acked || peerReceived > c.sent {
+		c.holdLock.Unlock()
+		conn.Close()
+		return nil, c.Errorf("Remote proxy received %d bytes, but %d-%d were expected", peerReceived, c.acked, c.sent)
+	}
+	c.unacked = c.unacked[peerReceived-c.acked:]
+	c.acked = peerReceived
+	c.att = conn
+	c.attSent = peerReceived
+	if c.holdTimer != nil {
+		c.holdTimer.Stop()
+		c.holdTimer = nil
+		c.resumesStat.Inc()
+		c.ILogf("Held channel re-established after %s", c.clock.Now().Sub(c.detachedAt).Round(time.Millisecond))
+	}
+	prevDone := c.readerDone
+	done := make(chan struct{})
+	c.readerDone = done
+	finSent := c.finSent
+	c.cond.Broadcast()
+	c.holdLock.Unlock()
+	c.flushLocked()
+	if finSent {
+		c.sendLocked(holdFrameFin, 0, nil)
+	}
+	go c.readLoop(conn, r, prevDone, done)
+	return done, nil
+}
+
+// detach forgets conn after it has been lost, and holds the channel open until it is
+// re-established or the hold time expires
+func (c *HoldConn) detach(conn ChannelConn, err error) {
+	conn.Close()
+	c.holdLock.Lock()
+	if c.att != conn || c.closed {
+		c.holdLock.Unlock()
+		return
+	}
+	c.att = nil
+	c.detachedAt = c.clock.Now()
+	c.holdTimer = c.clock.AfterFunc(c.holdTime, c.expire)
+	prevDone := c.readerDone
+	c.holdLock.Unlock()
+	c.ILogf("Connection to remote proxy lost (%s); holding channel open for %s", err, c.holdTime)
+	if c.reopen != nil {
+		go c.resume(prevDone, c.detachedAt.Add(c.holdTime))
+	}
+}
+
+// expire closes the channel if it has not been re-established within the hold time
+func (c *HoldConn) expire() {
+	c.holdLock.Lock()
+	expired := c.att == nil && !c.closed
+	c.holdLock.Unlock()
+	if !expired {
+		return
+	}
+	c.expiriesStat.Inc()
+	c.SetCloseReason(&ChannelCloseInfo{
+		Reason:  CloseReasonError,
+		Message: fmt.Sprintf("Connection to remote proxy not re-established within %s", c.holdTime),
+	})
+	c.StartShutdown(nil)
+}
+
+// resume keeps opening new channels on the stub side until one is attached, or the deadline
+// passes. prevDone is closed when the reader of the lost channel has finished, so that the
+// number of bytes received is final.
+func (c *HoldConn) resume(prevDone <-chan struct{}, deadline time.Time) {
+	<-prevDone
+	for c.clock.Now().Before(deadline) && !c.IsStartedShutdown() {
+		conn, err := c.reopen(deadline)
+		if err == nil {
+			err = c.handshake(conn, holdFrameResume, deadline)
+			if err == nil {
+				return
+			}
+			if c.getRemoteCloseInfo() != nil {
+				c.StartShutdown(err)
+				return
+			}
+		}
+		c.DLogf("Unable to re-establish held channel, retrying: %s", err)
+		select {
+		case <-c.clock.After(holdReopenDelay):
+		case <-c.ShutdownStartedChan():
+			return
+		}
+	}
+}
+
+// readLoop reads the frames arriving on conn until it is lost or the channel is closed.
+// prevDone is closed when the reader of the previously attached channel has finished.
+func (c *HoldConn) readLoop(conn ChannelConn, r *bufio.Reader, prevDone <-chan struct{}, done chan struct{}) {
+	defer close(done)
+	<-prevDone
+	for {
+		frameType, value, payload, err := readHoldFrame(r)
+		if err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			c.detach(conn, err)
+			return
+		}
+		switch frameType {
+		case holdFrameData:
+			if _, err := c.pipeWriter.Write(payload); err != nil {
+				return
+			}
+			c.holdLock.Lock()
+			c.received += int64(len(payload))
+			ack := c.received-c.ackSent >= holdAckInterval
+			if ack {
+				c.ackSent = c.received
+			}
+			value = c.received
+			c.holdLock.Unlock()
+			if ack {
+				c.writeLock.Lock()
+				c.sendLocked(holdFrameAck, value, nil)
+				c.writeLock.Unlock()
+			}
+		case holdFrameAck:
+			c.holdLock.Lock()
+			if value > c.acked && value <= c.sent {
+				c.unacked = c.unacked[value-c.acked:]
+				c.acked = value
+				c.cond.Broadcast()
+			}
+			c.holdLock.Unlock()
+		case holdFrameFin:
+			c.pipeWriter.Close()
+		case holdFrameClose:
+			info := &ChannelCloseInfo{}
+			if json.Unmarshal(payload, info) == nil && info.Reason != "" {
+				c.setRemoteCloseInfo(info)
+			}
+			c.pipeWriter.Close()
+			c.StartShutdown(nil)
+			return
+		default:
+			c.detach(conn, fmt.Errorf("Unexpected held channel frame type %d", frameType))
+			return
+		}
+	}
+}
+
+// sendLocked writes a frame to the attached channel, if any, detaching it if the write
+// fails. writeLock must be held.
+func (c *HoldConn) sendLocked(frameType byte, value int64, payload []byte) {
+	c.holdLock.Lock()
+	conn := c.att
+	c.holdLock.Unlock()
+	if conn == nil {
+		return
+	}
+	if err := writeHoldFrame(conn, frameType, value, payload); err != nil {
+		go c.detach(conn, err)
+	}
+}
+
+// flushLocked sends the written bytes that have not been sent over the attached channel.
+// writeLock must be held.
+func (c *HoldConn) flushLocked() {
+	for {
+		c.holdLock.Lock()
+		conn := c.att
+		if conn == nil || c.attSent == c.sent {
+			c.holdLock.Unlock()
+			return
+		}
+		start := c.attSent - c.acked
+		end := c.sent - c.acked
+		if end-start > holdMaxFrameData {
+			end = start + holdMaxFrameData
+		}
+		data := append([]byte(nil), c.unacked[start:end]...)
+		c.holdLock.Unlock()
+		if err := writeHoldFrame(conn, holdFrameData, 0, data); err != nil {
+			go c.detach(conn, err)
+			return
+		}
+		c.holdLock.Lock()
+		if c.att == conn {
+			c.attSent += int64(len(data))
+		}
+		c.holdLock.Unlock()
+	}
+}
+
+// Read implements the Reader interface
+func (c *HoldConn) Read(p []byte) (int, error) {
+	n, err := c.pipeReader.Read(p)
+	atomic.AddInt64(&c.NumBytesRead, int64(n))
+	return n, err
+}
+
+// Write implements the Writer interface. The data is kept until the remote proxy has
+// received it, and writes block while too much is waiting.
+func (c *HoldConn) Write(p []byte) (int, error) {
+	c.holdLock.Lock()
+	for len(c.unacked) >= holdBufferSize && !c.closed {
+		c.cond.Wait()
+	}
+	if c.closed {
+		c.holdLock.Unlock()
+		return 0, io.ErrClosedPipe
+	}
+	c.unacked = append(c.unacked, p...)
+	c.sent += int64(len(p))
+	c.holdLock.Unlock()
+	atomic.AddInt64(&c.NumBytesWritten, int64(len(p)))
+	c.writeLock.Lock()
+	c.flushLocked()
+	c.writeLock.Unlock()
+	return len(p), nil
+}
+
+// CloseWrite shuts down the writing side of the connection. Part of the ChannelConn
+// interface.
+func (c *HoldConn) CloseWrite() error {
+	c.writeLock.Lock()
+	defer c.writeLock.Unlock()
+	c.holdLock.Lock()
+	c.finSent = true
+	c.holdLock.Unlock()
+	c.flushLocked()
+	c.sendLocked(holdFrameFin, 0, nil)
+	return nil
+}
+
+// setRemoteCloseInfo records the reason the remote proxy gave for closing the channel
+func (c *HoldConn) setRemoteCloseInfo(info *ChannelCloseInfo) {
+	c.holdLock.Lock()
+	defer c.holdLock.Unlock()
+	if c.remoteCloseInfo == nil {
+		c.remoteCloseInfo = info
+	}
+}
+
+// getRemoteCloseInfo returns the reason the remote proxy gave for closing the channel, or nil
+func (c *HoldConn) getRemoteCloseInfo() *ChannelCloseInfo {
+	c.holdLock.Lock()
+	defer c.holdLock.Unlock()
+	return c.remoteCloseInfo
+}
+
+// CloseReason returns the reason recorded with SetCloseReason, or else the reason sent by the
+// remote proxy, or nil. Part of the ChannelConn interface.
+func (c *HoldConn) CloseReason() *ChannelCloseInfo {
+	if info := c.BasicConn.CloseReason(); info != nil {
+		return info
+	}
+	return c.getRemoteCloseInfo()
+}
+
+// WaitForClose blocks until the Close() method has been called and completed
+func (c *HoldConn) WaitForClose() error {
+	return c.WaitShutdown()
+}
+
+// HandleOnceShutdown will be called exactly once, in its own goroutine. It should take completionError
+// as an advisory completion value, actually shut down, then return the real completion value.
+func (c *HoldConn) HandleOnceShutdown(completionErr error) error {
+	c.holdLock.Lock()
+	c.closed = true
+	if c.holdTimer != nil {
+		c.holdTimer.Stop()
+	}
+	c.cond.Broadcast()
+	c.holdLock.Unlock()
+	var payload []byte
+	if info := c.BasicConn.CloseReason(); info != nil {
+		payload, _ = json.Marshal(info)
+	}
+	c.writeLock.Lock()
+	c.holdLock.Lock()
+	conn := c.att
+	c.att = nil
+	c.holdLock.Unlock()
+	if conn != nil {
+		// best effort; the remote proxy may already have closed the channel
+		writeHoldFrame(conn, holdFrameClose, 0, payload)
+		conn.Close()
+	}
+	c.writeLock.Unlock()
+	// pending reads return io.EOF, and the reader's pending writes fail
+	c.pipeWriter.Close()
+	if c.onClose != nil {
+		c.onClose()
+	}
+	return completionErr
+}
+
+// holdAttachment is the part of a HoldConn's traffic carried by one channel to the remote
+// proxy. It is tracked by the session the channel belongs to, so that closing the session's
+// channels with a reason closes the HoldConn, while its traffic is only counted once.
+type holdAttachment struct {
+	*HoldConn
+	readBase    int64
+	writtenBase int64
+}
+
+func (a *holdAttachment) GetNumBytesRead() int64 {
+	return a.HoldConn.GetNumBytesRead() - a.readBase
+}
+
+func (a *holdAttachment) GetNumBytesWritten() int64 {
+	return a.HoldConn.GetNumBytesWritten() - a.writtenBase
+}
+
+// newAttachment returns the part of the connection's traffic carried from now on
+func (c *HoldConn) newAttachment() *holdAttachment {
+	return &holdAttachment{
+		HoldConn:    c,
+		readBase:    c.GetNumBytesRead(),
+		writtenBase: c.GetNumBytesWritten(),
+	}
+}
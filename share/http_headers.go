@@ -0,0 +1,71 @@
+package chshare
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// reservedClientHeaders are set by the client's transports themselves, and cannot be given
+// as extra headers
+var reservedClientHeaders = []string{
+	"Connection",
+	"Upgrade",
+	"Sec-Websocket-Key",
+	"Sec-Websocket-Version",
+	"Sec-Websocket-Extensions",
+	"Sec-Websocket-Protocol",
+	PollProtocolHeader,
+	PollActionHeader,
+	PollIDHeader,
+	UpgradeTokenHeader,
+}
+
+// ParseHTTPHeader parses a header given as "<name>: <value>"
+func ParseHTTPHeader(s string) (string, string, error) {
+	i := strings.Index(s, ":")
+	if i <= 0 {
+		return "", "", fmt.Errorf("Invalid header '%s'; must be '<name>: <value>'", s)
+	}
+	name := http.CanonicalHeaderKey(strings.TrimSpace(s[:i]))
+	if name == "" || strings.ContainsAny(name, " \t") {
+		return "", "", fmt.Errorf("Invalid header name in '%s'", s)
+	}
+	return name, strings.TrimSpace(s[i+1:]), nil
+}
+
+// parseClientHeaders parses the extra headers that the client sends with its connection
+// requests, each given as "<name>: <value>". A header given more than once is sent with
+// each value.
+func parseClientHeaders(headers []string) (http.Header, error) {
+	result := http.Header{}
+	for _, s := range headers {
+		name, value, err := ParseHTTPHeader(s)
+		if err != nil {
+			return nil, err
+		}
+		for _, reserved := range reservedClientHeaders {
+			if strings.EqualFold(name, reserved) {
+				return nil, fmt.Errorf("Header '%s' is set by the client and cannot be overridden", name)
+			}
+		}
+		result.Add(name, value)
+	}
+	return result, nil
+}
+
+// requiredHeaderOk returns true if a client connection request carries the header required
+// by --require-header, or if none is required. Requests without it are answered as if the
+// server were not chisel, before any authentication.
+func (s *Server) requiredHeaderOk(r *http.Request) bool {
+	if s.requireHeaderName == "" {
+		return true
+	}
+	for _, value := range r.Header[s.requireHeaderName] {
+		if subtle.ConstantTimeCompare([]byte(value), []byte(s.requireHeaderValue)) == 1 {
+			return true
+		}
+	}
+	return false
+}
@@ -2,6 +2,7 @@ package chshare
 
 import (
 	"context"
+	"crypto/tls"
 	"net"
 	"net/http"
 )
@@ -42,23 +43,51 @@ func (h *HTTPServer) HandleOnceShutdown(completionErr error) error {
 
 // ListenAndServe Runs the HTTP server 
 // on the given bind address, invoking the provided handler for each
-// request. It returns after the server has shutdown. The server can be
+// request. If TLSConfig is set, connections are accepted with TLS. It returns after the server has shutdown. The server can be
 // shutdown either by cancelling the context or by calling Shutdown().
 func (h *HTTPServer) ListenAndServe(ctx context.Context, addr string, handler http.Handler) error {
+	return h.serve(ctx, func() (net.Listener, error) {
+		l, err := net.Listen("tcp", addr)
+		if err != nil {
+			return nil, h.DLogErrorf("Listen failed: %s", err)
+		}
+		return l, nil
+	}, handler)
+}
+
+// ServeConn runs the HTTP server on a single, already established connection (e.g., from
+// NewStdioConn), like ListenAndServe. The server shuts down once the connection is closed,
+// including when it has been upgraded to a websocket.
+func (h *HTTPServer) ServeConn(ctx context.Context, conn net.Conn, handler http.Handler) error {
+	return h.serve(ctx, func() (net.Listener, error) {
+		return newSingleConnListener(conn), nil
+	}, handler)
+}
+
+// serve runs the HTTP server on the listener returned by listen
+func (h *HTTPServer) serve(ctx context.Context, listen func() (net.Listener, error), handler http.Handler) error {
 
 	err := h.DoOnceActivate(
 		func() error {
 			h.ShutdownOnContext(ctx)
 
-			l, err := net.Listen("tcp", addr)
+			l, err := listen()
 			if err != nil {
-				return h.DLogErrorf("Listen failed: %s", err)
+				return err
+			}
+			if h.TLSConfig != nil {
+				l = tls.NewListener(l, h.TLSConfig)
 			}
 			h.Handler = handler
 			h.listener = l
 
 			go func() {
-				h.Shutdown(h.Serve(l))
+				err := h.Serve(l)
+				if err == errListenerClosed {
+					//the connection given to ServeConn has closed
+					err = nil
+				}
+				h.Shutdown(err)
 			}()
 
 			return nil
@@ -0,0 +1,373 @@
+package chshare
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/textproto"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// ChannelIdentity identifies the tunnel user on whose behalf a skeleton connects to its
+// Called Service, for the "ident" descriptor option
+type ChannelIdentity struct {
+	// User is the authenticated user of the session on the server, or the user the client
+	// authenticates as; empty if authentication is not in use
+	User string
+
+	// SessionID is the server's ID of the session carrying the channel, or 0 on the client,
+	// which does not know it
+	SessionID int32
+
+	// RemoteAddr is the address of the chisel client, on the server, or "" on the client
+	RemoteAddr string
+}
+
+// The "ident" option makes a TCP or unix skeleton send a ChannelIdentity to its Called
+// Service, so that the service's logs can attribute connections to tunnel users:
+//
+//	ident=proxy-v2      a PROXY protocol v2 header, before any data from the Caller
+//	ident=header:<Name> an HTTP header "<Name>: user=<user>; session=<id>" added to every
+//	                    HTTP/1.x request from the Caller, replacing any that the Caller sent
+const (
+	identProxyV2      = "proxy-v2"
+	identHeaderPrefix = "header:"
+)
+
+// The PROXY protocol v2 TLV types carrying the ChannelIdentity, from the range reserved for
+// custom use
+const (
+	ProxyV2TypeChiselUser      = 0xE0
+	ProxyV2TypeChiselSessionID = 0xE1
+)
+
+// proxyV2Signature starts every PROXY protocol v2 header
+var proxyV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// validateIdentOption validates the value of the "ident" descriptor option
+func validateIdentOption(value string) error {
+	if value == identProxyV2 {
+		return nil
+	}
+	if strings.HasPrefix(value, identHeaderPrefix) {
+		name := strings.TrimPrefix(value, identHeaderPrefix)
+		if name == "" || strings.IndexFunc(name, func(r rune) bool {
+			return !(r == '-' || r >= '0' && r <= '9' || r >= 'A' && r <= 'Z' || r >= 'a' && r <= 'z')
+		}) >= 0 {
+			return fmt.Errorf("Invalid HTTP header name '%s'", name)
+		}
+		return nil
+	}
+	return fmt.Errorf("Unknown ident value '%s'; must be proxy-v2 or header:<name>", value)
+}
+
+// CheckIdentEndpoint returns an error if a skeleton endpoint has the "ident" option but
+// cannot send an identity
+func CheckIdentEndpoint(ced *ChannelEndpointDescriptor) error {
+	if ced.Role != ChannelEndpointRoleSkeleton || ced.Option("ident") == "" {
+		return nil
+	}
+	if ced.Type != ChannelEndpointTypeTCP && ced.Type != ChannelEndpointTypeUnix {
+		return fmt.Errorf("The ident option requires a TCP or unix target: %s", ced.LongString())
+	}
+	return nil
+}
+
+// EndpointIdent returns the "ident" option of a skeleton endpoint, or "" if it has none or is
+// not a skeleton
+func EndpointIdent(ced *ChannelEndpointDescriptor) string {
+	if ced.Role != ChannelEndpointRoleSkeleton {
+		return ""
+	}
+	return ced.Option("ident")
+}
+
+// IdentRule makes the server send identities, as an "ident" option value would, to the
+// targets that match an address regular expression, whatever the client asks for, so that
+// targets that trust the identity cannot be reached without it
+type IdentRule struct {
+	// Addr matches the path of a target: host:port for TCP targets, or a unix socket path
+	Addr *regexp.Regexp
+
+	// Ident is the "ident" option value to apply
+	Ident string
+}
+
+// ParseIdentRule parses an IdentRule in the form "<address regex>=<ident>"
+func ParseIdentRule(s string) (*IdentRule, error) {
+	i := strings.LastIndex(s, "=")
+	if i < 0 {
+		return nil, fmt.Errorf("Invalid ident rule '%s'; must be <address regex>=<ident>", s)
+	}
+	if err := validateIdentOption(s[i+1:]); err != nil {
+		return nil, err
+	}
+	addr, err := regexp.Compile(s[:i])
+	if err != nil {
+		return nil, fmt.Errorf("Invalid ident rule address regex '%s': %s", s[:i], err)
+	}
+	return &IdentRule{Addr: addr, Ident: s[i+1:]}, nil
+}
+
+// RequiredIdent returns the "ident" option value of the first rule whose address matches a
+// skeleton endpoint, or "" if none does. An error is returned if a rule matches an endpoint
+// that cannot send an identity.
+func RequiredIdent(rules []*IdentRule, ced *ChannelEndpointDescriptor) (string, error) {
+	if ced.Role != ChannelEndpointRoleSkeleton {
+		return "", nil
+	}
+	for _, rule := range rules {
+		if !rule.Addr.MatchString(ced.Path) {
+			continue
+		}
+		if ced.Type != ChannelEndpointTypeTCP && ced.Type != ChannelEndpointTypeUnix {
+			return "", fmt.Errorf("The server requires ident=%s for %s, which is not a TCP or unix target", rule.Ident, ced.LongString())
+		}
+		return rule.Ident, nil
+	}
+	return "", nil
+}
+
+// channelIdent returns the "ident" option value with which the server sends identities to
+// the target of a skeleton endpoint, after resolving aliases: that of the first ident rule
+// matching the target, whatever the endpoint's own option, or else the endpoint's option
+func (s *Server) channelIdent(ced *ChannelEndpointDescriptor) (string, error) {
+	if ced.Role == ChannelEndpointRoleSkeleton && ced.Type == ChannelEndpointTypeAlias {
+		resolved, err := s.aliases.Resolve(ced)
+		if err != nil {
+			//unknown aliases are refused when the channel is opened
+			return "", nil
+		}
+		ced = resolved
+	}
+	ident, err := RequiredIdent(s.identRules, ced)
+	if ident == "" && err == nil {
+		ident = EndpointIdent(ced)
+	}
+	return ident, err
+}
+
+// WrapIdentChannelConn returns a ChannelConn that sends id to the Called Service of a
+// skeleton endpoint as an "ident" option value asks. conn is the connection to the remote
+// proxy, and is returned unchanged if ident is "". The Caller cannot forge an identity: a
+// PROXY protocol v2 header at the start of its data, or an identity header in its requests,
+// is dropped.
+func WrapIdentChannelConn(id *ChannelIdentity, ident string, conn ChannelConn) ChannelConn {
+	if ident == "" {
+		return conn
+	}
+	if ident == identProxyV2 {
+		preamble := proxyV2Header(id)
+		pr, pw := io.Pipe()
+		go func() {
+			if _, err := pw.Write(preamble); err != nil {
+				return
+			}
+			pw.CloseWithError(stripProxyV2Header(pw, bufio.NewReader(conn)))
+		}()
+		return &rewrittenReadConn{ChannelConn: conn, reader: pr}
+	}
+	name := textproto.CanonicalMIMEHeaderKey(strings.TrimPrefix(ident, identHeaderPrefix))
+	user := strings.NewReplacer("\r", "", "\n", "").Replace(id.User)
+	value := fmt.Sprintf("user=%s; session=%d", user, id.SessionID)
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(injectHTTPHeader(pw, bufio.NewReader(conn), name, value))
+	}()
+	return &rewrittenReadConn{ChannelConn: conn, reader: pr}
+}
+
+// proxyV2Header builds a PROXY protocol v2 header for a ChannelIdentity. The source address
+// is the chisel client's, if known; the destination is not known, and is all zeroes.
+func proxyV2Header(id *ChannelIdentity) []byte {
+	var addrs []byte
+	family := byte(0x00) // AF_UNSPEC
+	if host, portStr, err := net.SplitHostPort(id.RemoteAddr); err == nil {
+		ip := net.ParseIP(host)
+		port, _ := strconv.Atoi(portStr)
+		portBytes := []byte{byte(port >> 8), byte(port), 0, 0}
+		if ip4 := ip.To4(); ip4 != nil {
+			family = 0x11 // AF_INET, STREAM
+			addrs = append(append(append(addrs, ip4...), make([]byte, 4)...), portBytes...)
+		} else if ip != nil {
+			family = 0x21 // AF_INET6, STREAM
+			addrs = append(append(append(addrs, ip...), make([]byte, 16)...), portBytes...)
+		}
+	}
+	tlvs := appendProxyV2TLV(nil, ProxyV2TypeChiselUser, []byte(id.User))
+	tlvs = appendProxyV2TLV(tlvs, ProxyV2TypeChiselSessionID, []byte(strconv.Itoa(int(id.SessionID))))
+	header := append([]byte{}, proxyV2Signature...)
+	header = append(header, 0x21, family) // version 2, PROXY command
+	var length [2]byte
+	binary.BigEndian.PutUint16(length[:], uint16(len(addrs)+len(tlvs)))
+	header = append(header, length[:]...)
+	header = append(header, addrs...)
+	return append(header, tlvs...)
+}
+
+// appendProxyV2TLV appends a PROXY protocol v2 TLV to b
+func appendProxyV2TLV(b []byte, t byte, value []byte) []byte {
+	return append(append(b, t, byte(len(value)>>8), byte(len(value))), value...)
+}
+
+// stripProxyV2Header copies a stream from r to w, dropping a PROXY protocol v2 header at its
+// start. Data that starts like the header's signature is held back until it diverges from it.
+func stripProxyV2Header(w io.Writer, r *bufio.Reader) error {
+	for n := 1; n <= len(proxyV2Signature); n++ {
+		b, err := r.Peek(n)
+		if err != nil || !bytes.Equal(b, proxyV2Signature[:n]) {
+			break
+		}
+		if n == len(proxyV2Signature) {
+			header, err := r.Peek(len(proxyV2Signature) + 4)
+			if err != nil {
+				return err
+			}
+			length := int(binary.BigEndian.Uint16(header[len(header)-2:]))
+			if _, err := r.Discard(len(header) + length); err != nil {
+				return err
+			}
+		}
+	}
+	_, err := io.Copy(w, r)
+	return err
+}
+
+// rewrittenReadConn is a ChannelConn whose data is read through a pipe from a goroutine
+// that rewrites it
+type rewrittenReadConn struct {
+	ChannelConn
+	reader *io.PipeReader
+}
+
+func (c *rewrittenReadConn) Read(p []byte) (int, error) {
+	return c.reader.Read(p)
+}
+
+// Close closes the conn, and the pipe so that the rewriting goroutine cannot block on it
+func (c *rewrittenReadConn) Close() error {
+	c.reader.Close()
+	return c.ChannelConn.Close()
+}
+
+// injectHTTPHeader copies a stream of HTTP/1.x requests from r to w, setting a header on
+// each. Request bodies are copied unchanged. After a request that switches protocols, or
+// data that is not an HTTP request, the rest of the stream is copied unchanged.
+func injectHTTPHeader(w io.Writer, r *bufio.Reader, name, value string) error {
+	for {
+		if _, err := r.Peek(1); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		requestLine, err := r.ReadString('\n')
+		if err != nil {
+			w.Write([]byte(requestLine))
+			return err
+		}
+		fields := strings.Fields(requestLine)
+		if len(fields) != 3 || !strings.HasPrefix(fields[2], "HTTP/1.") {
+			//not HTTP
+			w.Write([]byte(requestLine))
+			_, err = io.Copy(w, r)
+			return err
+		}
+		var head bytes.Buffer
+		head.WriteString(requestLine)
+		fmt.Fprintf(&head, "%s: %s\r\n", name, value)
+		contentLength := int64(0)
+		chunked := false
+		upgrade := fields[0] == "CONNECT"
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				w.Write(head.Bytes())
+				w.Write([]byte(line))
+				return err
+			}
+			trimmed := strings.TrimRight(line, "\r\n")
+			if trimmed == "" {
+				head.WriteString(line)
+				break
+			}
+			colon := strings.IndexByte(trimmed, ':')
+			if colon > 0 {
+				key := textproto.CanonicalMIMEHeaderKey(strings.TrimSpace(trimmed[:colon]))
+				val := strings.TrimSpace(trimmed[colon+1:])
+				//drop the Caller's value, including spellings with underscores, which
+				//some servers treat as dashes
+				if textproto.CanonicalMIMEHeaderKey(strings.Replace(key, "_", "-", -1)) == name {
+					continue
+				}
+				switch key {
+				case "Content-Length":
+					contentLength, _ = strconv.ParseInt(val, 10, 64)
+				case "Transfer-Encoding":
+					chunked = strings.Contains(strings.ToLower(val), "chunked")
+				case "Upgrade":
+					upgrade = true
+				}
+			}
+			head.WriteString(line)
+		}
+		if _, err := w.Write(head.Bytes()); err != nil {
+			return err
+		}
+		if upgrade {
+			_, err = io.Copy(w, r)
+			return err
+		}
+		if chunked {
+			err = copyChunkedBody(w, r)
+		} else if contentLength > 0 {
+			_, err = io.CopyN(w, r, contentLength)
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// copyChunkedBody copies a chunked HTTP body, including its trailer, unchanged
+func copyChunkedBody(w io.Writer, r *bufio.Reader) error {
+	for {
+		line, err := r.ReadString('\n')
+		if _, werr := w.Write([]byte(line)); werr != nil {
+			return werr
+		}
+		if err != nil {
+			return err
+		}
+		sizeStr := strings.TrimSpace(strings.SplitN(line, ";", 2)[0])
+		size, err := strconv.ParseInt(sizeStr, 16, 64)
+		if err != nil {
+			return fmt.Errorf("Invalid chunk size '%s'", sizeStr)
+		}
+		if size == 0 {
+			break
+		}
+		//the chunk and its CRLF
+		if _, err := io.CopyN(w, r, size+2); err != nil {
+			return err
+		}
+	}
+	//trailer, ending with an empty line
+	for {
+		line, err := r.ReadString('\n')
+		if _, werr := w.Write([]byte(line)); werr != nil {
+			return werr
+		}
+		if err != nil {
+			return err
+		}
+		if strings.TrimRight(line, "\r\n") == "" {
+			return nil
+		}
+	}
+}
@@ -0,0 +1,171 @@
+package chshare
+
+import (
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultAdaptiveKeepAlive is the keepalive interval an adaptive keepalive starts at if no
+// interval is given
+const DefaultAdaptiveKeepAlive = time.Minute
+
+// The bounds of an adaptive keepalive interval
+const (
+	adaptiveKeepAliveMin = 10 * time.Second
+	adaptiveKeepAliveMax = 10 * time.Minute
+)
+
+// keepAlivePingTimeout is how long a keepalive ping may wait for its reply before the
+// connection is considered lost
+const keepAlivePingTimeout = 15 * time.Second
+
+// keepAliveStableCount is the number of keepalive pings that must survive an idle interval
+// before an adaptive keepalive interval is lengthened
+const keepAliveStableCount = 5
+
+// keepAliveCeilingTTL is how long an adaptive keepalive remembers the idle time after which
+// the link failed, so that a move to a more lenient network is eventually noticed
+const keepAliveCeilingTTL = time.Hour
+
+// AdaptiveKeepAlive tunes the client's keepalive interval to the idle timeout of the NATs
+// and middleboxes between it and the server, e.g. on cellular networks, to send as few
+// pings as possible without the connection being dropped. A ping that fails after the link
+// has been idle for most of an interval shows that the interval is longer than the idle
+// timeout, so the interval is halved, and is never again lengthened past most of that
+// idle time while it is remembered. Each keepAliveStableCount pings that survive an idle
+// interval lengthen it by half.
+type AdaptiveKeepAlive struct {
+	logger Logger
+	clock  Clock
+
+	// lastRead is the time data was last read from the transport, in UnixNano
+	lastRead int64
+
+	// lock protects interval, ceiling, ceilingTime and stable
+	lock        sync.Mutex
+	interval    time.Duration
+	ceiling     time.Duration
+	ceilingTime time.Time
+	stable      int
+
+	intervalStat *Stat
+	failuresStat *Stat
+}
+
+// NewAdaptiveKeepAlive creates a new AdaptiveKeepAlive, starting at interval, or at
+// DefaultAdaptiveKeepAlive if interval is 0
+func NewAdaptiveKeepAlive(logger Logger, stats *StatsRegistry, clock Clock, interval time.Duration) *AdaptiveKeepAlive {
+	if interval <= 0 {
+		interval = DefaultAdaptiveKeepAlive
+	}
+	if interval < adaptiveKeepAliveMin {
+		interval = adaptiveKeepAliveMin
+	} else if interval > adaptiveKeepAliveMax {
+		interval = adaptiveKeepAliveMax
+	}
+	k := &AdaptiveKeepAlive{
+		logger:   logger.Fork("keepalive"),
+		clock:    clock,
+		lastRead: clock.Now().UnixNano(),
+		interval: interval,
+		intervalStat: stats.Gauge(
+			"chisel_client_keepalive_interval_seconds",
+			"Current adaptive keepalive interval",
+			nil),
+		failuresStat: stats.Counter(
+			"chisel_client_keepalive_idle_failures_total",
+			"Number of times the connection to the server was lost while idle between keepalives",
+			nil),
+	}
+	k.intervalStat.Set(int64(interval / time.Second))
+	return k
+}
+
+// Interval returns the current keepalive interval
+func (k *AdaptiveKeepAlive) Interval() time.Duration {
+	k.lock.Lock()
+	defer k.lock.Unlock()
+	return k.interval
+}
+
+// TrackTransport returns a net.Conn that records when data is read from transport, so that
+// the keepalive knows how long the link has been idle
+func (k *AdaptiveKeepAlive) TrackTransport(transport net.Conn) net.Conn {
+	atomic.StoreInt64(&k.lastRead, k.clock.Now().UnixNano())
+	return &keepAliveTrackedConn{Conn: transport, keepAlive: k}
+}
+
+// Idle returns how long it has been since data was last read from the transport
+func (k *AdaptiveKeepAlive) Idle() time.Duration {
+	return k.clock.Now().Sub(time.Unix(0, atomic.LoadInt64(&k.lastRead)))
+}
+
+// PingDone adapts the interval to the outcome of a keepalive ping, sent after the link had
+// been idle for idle
+func (k *AdaptiveKeepAlive) PingDone(idle time.Duration, err error) {
+	k.lock.Lock()
+	defer k.lock.Unlock()
+	if idle < k.interval*3/4 {
+		// the link was busy, so the ping says nothing about the idle timeout
+		return
+	}
+	now := k.clock.Now()
+	if k.ceiling > 0 && now.Sub(k.ceilingTime) > keepAliveCeilingTTL {
+		k.ceiling = 0
+	}
+	if err != nil {
+		k.stable = 0
+		k.failuresStat.Inc()
+		idle = idle.Round(time.Second)
+		if k.ceiling == 0 || idle < k.ceiling {
+			k.ceiling = idle
+		}
+		k.ceilingTime = now
+		k.setIntervalLocked(k.interval/2, "the connection was lost after %s idle", idle)
+		return
+	}
+	k.stable++
+	if k.stable < keepAliveStableCount {
+		return
+	}
+	k.stable = 0
+	interval := k.interval * 3 / 2
+	if k.ceiling > 0 && interval > k.ceiling*3/4 {
+		interval = k.ceiling * 3 / 4
+	}
+	if interval > k.interval {
+		k.setIntervalLocked(interval, "the link has stayed up while idle")
+	}
+}
+
+// setIntervalLocked changes the interval, within its bounds, and logs why. The lock must be
+// held.
+func (k *AdaptiveKeepAlive) setIntervalLocked(interval time.Duration, reason string, args ...interface{}) {
+	if interval < adaptiveKeepAliveMin {
+		interval = adaptiveKeepAliveMin
+	} else if interval > adaptiveKeepAliveMax {
+		interval = adaptiveKeepAliveMax
+	}
+	if interval == k.interval {
+		return
+	}
+	k.logger.ILogf("Keepalive interval changed from %s to %s: "+reason, append([]interface{}{k.interval, interval}, args...)...)
+	k.interval = interval
+	k.intervalStat.Set(int64(interval / time.Second))
+}
+
+// keepAliveTrackedConn is a transport whose reads are recorded by an AdaptiveKeepAlive
+type keepAliveTrackedConn struct {
+	net.Conn
+	keepAlive *AdaptiveKeepAlive
+}
+
+func (c *keepAliveTrackedConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	if n > 0 {
+		atomic.StoreInt64(&c.keepAlive.lastRead, c.keepAlive.clock.Now().UnixNano())
+	}
+	return n, err
+}
@@ -0,0 +1,45 @@
+package chshare
+
+import (
+	"errors"
+	"fmt"
+)
+
+// errKeychainItemNotFound is returned by keychainGet if the credential store has no such item
+var errKeychainItemNotFound = errors.New("Item not found")
+
+// Keychain reads the client's secrets from the operating system's credential store, rather
+// than from the command line or environment, where other users can see them in process
+// listings. Each secret is an item named by the service and an account, e.g. "chisel" and
+// "auth":
+//
+//     Linux      A "user" key described as <service>:<account> in the session or user
+//                keyring, e.g. keyctl add user chisel:auth bob:secret @u
+//     macOS      A generic password with the service and account in the login keychain,
+//                e.g. security add-generic-password -s chisel -a auth -w bob:secret
+//     Windows    A generic credential targeting <service>:<account>, e.g.
+//                cmdkey /generic:chisel:auth /user:chisel /pass:bob:secret
+type Keychain struct {
+	service string
+}
+
+// NewKeychain creates a Keychain for the items of a service
+func NewKeychain(service string) (*Keychain, error) {
+	if service == "" {
+		return nil, fmt.Errorf("Empty keychain service name")
+	}
+	return &Keychain{service: service}, nil
+}
+
+// Lookup returns the secret of the service's item for account, and true, or false if there is
+// no such item
+func (k *Keychain) Lookup(account string) (string, bool, error) {
+	secret, err := keychainGet(k.service, account)
+	if err == errKeychainItemNotFound {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, fmt.Errorf("Unable to read %s:%s from the keychain: %s", k.service, account, err)
+	}
+	return secret, true, nil
+}
@@ -0,0 +1,32 @@
+//+build darwin
+
+package chshare
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// securityItemNotFound is the exit status of the security tool for a missing item
+const securityItemNotFound = 44
+
+// keychainGet returns the generic password with the service and account, using the security
+// tool
+func keychainGet(service string, account string) (string, error) {
+	var stderr bytes.Buffer
+	cmd := exec.Command("/usr/bin/security", "find-generic-password", "-s", service, "-a", account, "-w")
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			if exitErr.ExitCode() == securityItemNotFound {
+				return "", errKeychainItemNotFound
+			}
+			return "", fmt.Errorf("%s: %s", err, strings.TrimSpace(stderr.String()))
+		}
+		return "", err
+	}
+	return strings.TrimSuffix(string(out), "\n"), nil
+}
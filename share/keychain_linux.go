@@ -0,0 +1,57 @@
+//+build linux
+
+package chshare
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+// keyctl(2) operations and special keyring IDs
+const (
+	keyctlSearch          = 10
+	keyctlRead            = 11
+	keySpecSessionKeyring = -3
+	keySpecUserKeyring    = -4
+)
+
+// keychainGet returns the "user" key described as <service>:<account>, searching the session
+// keyring, then the user keyring
+func keychainGet(service string, account string) (string, error) {
+	keyType, err := syscall.BytePtrFromString("user")
+	if err != nil {
+		return "", err
+	}
+	desc, err := syscall.BytePtrFromString(service + ":" + account)
+	if err != nil {
+		return "", err
+	}
+	for _, keyring := range []int{keySpecSessionKeyring, keySpecUserKeyring} {
+		id, _, errno := syscall.Syscall6(syscall.SYS_KEYCTL, keyctlSearch, uintptr(keyring),
+			uintptr(unsafe.Pointer(keyType)), uintptr(unsafe.Pointer(desc)), 0, 0)
+		if errno == syscall.ENOKEY || errno == syscall.EKEYEXPIRED || errno == syscall.EKEYREVOKED {
+			continue
+		}
+		if errno != 0 {
+			return "", errno
+		}
+		return keyctlReadKey(id)
+	}
+	return "", errKeychainItemNotFound
+}
+
+// keyctlReadKey returns the payload of a key
+func keyctlReadKey(id uintptr) (string, error) {
+	buf := make([]byte, 256)
+	for {
+		n, _, errno := syscall.Syscall6(syscall.SYS_KEYCTL, keyctlRead, id,
+			uintptr(unsafe.Pointer(&buf[0])), uintptr(len(buf)), 0, 0)
+		if errno != 0 {
+			return "", errno
+		}
+		if int(n) <= len(buf) {
+			return string(buf[:n]), nil
+		}
+		buf = make([]byte, n)
+	}
+}
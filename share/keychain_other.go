@@ -0,0 +1,10 @@
+//+build !linux,!darwin,!windows
+
+package chshare
+
+import "fmt"
+
+// keychainGet is not implemented on this platform
+func keychainGet(service string, account string) (string, error) {
+	return "", fmt.Errorf("No supported credential store on this platform")
+}
@@ -0,0 +1,77 @@
+//+build windows
+
+package chshare
+
+import (
+	"bytes"
+	"syscall"
+	"unicode/utf16"
+	"unicode/utf8"
+	"unsafe"
+)
+
+// credTypeGeneric is the CRED_TYPE_GENERIC credential type
+const credTypeGeneric = 1
+
+// errorNotFound is the ERROR_NOT_FOUND error code
+const errorNotFound = syscall.Errno(1168)
+
+var (
+	advapi32     = syscall.NewLazyDLL("advapi32.dll")
+	procCredRead = advapi32.NewProc("CredReadW")
+	procCredFree = advapi32.NewProc("CredFree")
+)
+
+// credential is the CREDENTIALW structure
+type credential struct {
+	Flags              uint32
+	Type               uint32
+	TargetName         *uint16
+	Comment            *uint16
+	LastWritten        syscall.Filetime
+	CredentialBlobSize uint32
+	CredentialBlob     *byte
+	Persist            uint32
+	AttributeCount     uint32
+	Attributes         uintptr
+	TargetAlias        *uint16
+	UserName           *uint16
+}
+
+// keychainGet returns the password of the generic credential targeting <service>:<account>
+// from the Windows Credential Manager
+func keychainGet(service string, account string) (string, error) {
+	target, err := syscall.UTF16PtrFromString(service + ":" + account)
+	if err != nil {
+		return "", err
+	}
+	var cred *credential
+	r, _, err := procCredRead.Call(uintptr(unsafe.Pointer(target)), credTypeGeneric, 0, uintptr(unsafe.Pointer(&cred)))
+	if r == 0 {
+		if err == errorNotFound {
+			return "", errKeychainItemNotFound
+		}
+		return "", err
+	}
+	defer procCredFree.Call(uintptr(unsafe.Pointer(cred)))
+
+	blob := make([]byte, cred.CredentialBlobSize)
+	if len(blob) > 0 {
+		copy(blob, (*[1 << 20]byte)(unsafe.Pointer(cred.CredentialBlob))[:len(blob):len(blob)])
+	}
+	return decodeCredentialBlob(blob), nil
+}
+
+// decodeCredentialBlob returns a credential's password. Passwords stored by cmdkey and the
+// Credential Manager are UTF-16, which ASCII text shows by its zero bytes; others are taken to
+// be UTF-8.
+func decodeCredentialBlob(blob []byte) string {
+	if len(blob)%2 != 0 || (bytes.IndexByte(blob, 0) < 0 && utf8.Valid(blob)) {
+		return string(blob)
+	}
+	u := make([]uint16, len(blob)/2)
+	for i := range u {
+		u[i] = uint16(blob[2*i]) | uint16(blob[2*i+1])<<8
+	}
+	return string(utf16.Decode(u))
+}
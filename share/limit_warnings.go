@@ -0,0 +1,178 @@
+package chshare
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// Names of the user limits that LimitWarner warns about
+const (
+	LimitSessions     = "sessions"
+	LimitChannelBytes = "channel_bytes"
+)
+
+// limitWarningQueueLength is the number of warnings that may wait to be posted to the
+// webhook. Further warnings are logged and counted, but not posted.
+const limitWarningQueueLength = 100
+
+// limitWarningWebhookTimeout is how long posting a warning to the webhook may take
+const limitWarningWebhookTimeout = 10 * time.Second
+
+// LimitWarning is an event raised when a user's usage reaches the warning percentage of one
+// of the user's limits. It is posted to the webhook as JSON.
+type LimitWarning struct {
+	Time    time.Time `json:"time"`
+	User    string    `json:"user"`
+	Limit   string    `json:"limit"`
+	Used    int64     `json:"used"`
+	Max     int64     `json:"max"`
+	Percent int       `json:"percent"`
+
+	// SessionID is the session whose usage raised the warning
+	SessionID int32 `json:"session_id"`
+}
+
+// LimitWarner raises warnings when users reach a percentage of their limits, e.g. of
+// max_sessions or max_channel_bytes, so that operators are told before the limits are
+// enforced. Warnings are logged, counted in the chisel_limit_warnings_total metric, and
+// optionally posted to a webhook.
+type LimitWarner struct {
+	logger  Logger
+	clock   Clock
+	percent int
+	webhook string
+	queue   chan *LimitWarning
+
+	warningsStat   map[string]*Stat
+	webhookErrStat *Stat
+}
+
+// NewLimitWarner creates a LimitWarner that warns at percent of each limit, posting warnings
+// to webhook if it is not "", and timestamping them by clock. Returns nil if percent is 0.
+func NewLimitWarner(logger Logger, stats *StatsRegistry, clock Clock, percent int, webhook string) (*LimitWarner, error) {
+	if percent < 0 || percent > 100 {
+		return nil, fmt.Errorf("Invalid limit warning percentage %d; must be 1 to 100", percent)
+	}
+	if percent == 0 {
+		if webhook != "" {
+			return nil, fmt.Errorf("A limit warning webhook requires a limit warning percentage")
+		}
+		return nil, nil
+	}
+	if webhook != "" {
+		u, err := url.Parse(webhook)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, fmt.Errorf("Invalid limit warning webhook URL '%s'", webhook)
+		}
+	}
+	w := &LimitWarner{
+		logger:       logger.Fork("limits"),
+		clock:        clock,
+		percent:      percent,
+		webhook:      webhook,
+		warningsStat: make(map[string]*Stat),
+	}
+	for _, limit := range []string{LimitSessions, LimitChannelBytes} {
+		w.warningsStat[limit] = stats.Counter(
+			"chisel_limit_warnings_total",
+			"Number of times a user's usage reached the warning percentage of one of the user's limits",
+			StatLabels{"limit": limit})
+	}
+	if webhook != "" {
+		w.webhookErrStat = stats.Counter(
+			"chisel_limit_warning_webhook_errors_total",
+			"Number of limit warnings that could not be posted to the webhook",
+			nil)
+		w.queue = make(chan *LimitWarning, limitWarningQueueLength)
+		go w.postLoop()
+	}
+	return w, nil
+}
+
+// Threshold returns the usage of a limit of max at which a warning is raised, or 0 if no
+// warnings are raised. A nil LimitWarner raises no warnings.
+func (w *LimitWarner) Threshold(max int64) int64 {
+	if w == nil || max <= 0 {
+		return 0
+	}
+	threshold := (max*int64(w.percent) + 99) / 100
+	if threshold < 1 {
+		threshold = 1
+	}
+	return threshold
+}
+
+// Check raises a warning if used has reached the warning percentage of a user's limit of
+// max. A nil LimitWarner raises no warnings.
+func (w *LimitWarner) Check(user string, sessionID int32, limit string, used int64, max int64) {
+	threshold := w.Threshold(max)
+	if threshold == 0 || used < threshold {
+		return
+	}
+	w.Warn(&LimitWarning{
+		Time:      w.clock.Now(),
+		User:      user,
+		Limit:     limit,
+		Used:      used,
+		Max:       max,
+		Percent:   int(used * 100 / max),
+		SessionID: sessionID,
+	})
+}
+
+// Warn raises a warning
+func (w *LimitWarner) Warn(warning *LimitWarning) {
+	w.logger.ILogf("User \"%s\" (session #%d) is at %d%% of their %s limit: %d of %d",
+		warning.User, warning.SessionID, warning.Percent, warning.Limit, warning.Used, warning.Max)
+	if stat, ok := w.warningsStat[warning.Limit]; ok {
+		stat.Inc()
+	}
+	if w.queue == nil {
+		return
+	}
+	select {
+	case w.queue <- warning:
+	default:
+		w.webhookErrStat.Inc()
+		w.logger.DLogf("Limit warning webhook queue full; not posting warning")
+	}
+}
+
+// postLoop posts queued warnings to the webhook, one at a time
+func (w *LimitWarner) postLoop() {
+	for warning := range w.queue {
+		if err := w.post(warning); err != nil {
+			w.webhookErrStat.Inc()
+			w.logger.ILogf("Unable to post limit warning to webhook: %s", err)
+		}
+	}
+}
+
+// post posts a warning to the webhook
+func (w *LimitWarner) post(warning *LimitWarning) error {
+	body, err := json.Marshal(warning)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), limitWarningWebhookTimeout)
+	defer cancel()
+	req, err := http.NewRequest("POST", w.webhook, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("Webhook returned %s", resp.Status)
+	}
+	return nil
+}
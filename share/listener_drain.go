@@ -0,0 +1,62 @@
+package chshare
+
+import (
+	"fmt"
+	"time"
+)
+
+// DrainListener gracefully removes the stub listener of one of the session's reverse
+// channels, given by its descriptor string, without affecting the rest of the session: new
+// connections to it are refused, and once the connections it has accepted finish, or the
+// timeout expires, it stops listening and the channel is removed. A timeout of 0 uses the
+// server's session drain timeout. Returns the number of connections still open. The client is
+// not told, and adds the channel again if it reconnects.
+func (s *ServerSSHSession) DrainListener(key string, timeout time.Duration) (int, error) {
+	s.channelsLock.Lock()
+	proxy, ok := s.reverseProxies[key]
+	s.channelsLock.Unlock()
+	if !ok {
+		return 0, fmt.Errorf("No reverse listener for %s", key)
+	}
+	if !proxy.Drain() {
+		return 0, fmt.Errorf("Listener for %s is already draining", key)
+	}
+	if timeout <= 0 {
+		timeout = s.server.sessionDrainTimeout
+	}
+	n := proxy.ActiveConnections()
+	s.ILogf("Draining listener for %s: %d connection(s) open", key, n)
+	go s.drainListenerLoop(key, proxy, timeout)
+	return n, nil
+}
+
+// drainListenerLoop waits for a draining proxy's connections to finish, or for the timeout to
+// expire, then removes its channel from the session, unless the channel has since been
+// removed or replaced
+func (s *ServerSSHSession) drainListenerLoop(key string, proxy *TCPProxy, timeout time.Duration) {
+	deadline := s.server.clock.After(timeout)
+drain:
+	for proxy.ActiveConnections() > 0 {
+		select {
+		case <-s.server.clock.After(sessionDrainPollInterval):
+		case <-deadline:
+			s.ILogf("Listener for %s did not drain within %s; closing %d connection(s)",
+				key, timeout, proxy.ActiveConnections())
+			break drain
+		case <-proxy.ShutdownStartedChan():
+			return
+		case <-s.ShutdownStartedChan():
+			return
+		}
+	}
+	s.channelsLock.Lock()
+	if s.reverseProxies[key] != proxy {
+		s.channelsLock.Unlock()
+		return
+	}
+	delete(s.reverseProxies, key)
+	delete(s.chds, key)
+	s.channelsLock.Unlock()
+	s.ILogf("Listener for %s drained; no longer listening", key)
+	proxy.Close()
+}
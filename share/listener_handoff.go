@@ -0,0 +1,132 @@
+package chshare
+
+import (
+	"context"
+	"fmt"
+	"net"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// ListenerTakeoverRequestType is the SSH request type used by a client to take over the stub
+// listeners of its reverse channels from another session of the same user, e.g. that of the
+// client instance it is replacing, so that the listening ports are never released in
+// between. The payload is a DynamicChannelsRequest whose added channels are the reverse
+// channels to take over; a channel that no other session listens for is added as usual. The
+// success reply carries a ChannelsReply if asked for.
+const ListenerTakeoverRequestType = "takeover"
+
+// listenerOwner returns another session of this session's user that has a stub listener for
+// the reverse channel with descriptor string key, or nil if there is none. Sessions without a
+// user never hand listeners to each other.
+func (s *ServerSSHSession) listenerOwner(key string) *ServerSSHSession {
+	if s.user == nil {
+		return nil
+	}
+	for _, session := range s.server.Sessions() {
+		if session == s || session.user == nil || session.users != s.users || session.user.Name != s.user.Name {
+			continue
+		}
+		session.channelsLock.Lock()
+		_, ok := session.reverseProxies[key]
+		session.channelsLock.Unlock()
+		if ok {
+			return session
+		}
+	}
+	return nil
+}
+
+// releaseListener removes the reverse channel with descriptor string key from the session,
+// and returns the listener of its stub, still listening, along with the descriptor the stub
+// listens for, which may have a substitute port. Connections already accepted are still
+// served until the session ends.
+func (s *ServerSSHSession) releaseListener(key string) (net.Listener, *ChannelDescriptor, error) {
+	s.channelsLock.Lock()
+	proxy, ok := s.reverseProxies[key]
+	if !ok {
+		s.channelsLock.Unlock()
+		return nil, nil, fmt.Errorf("No listener for %s", key)
+	}
+	delete(s.reverseProxies, key)
+	delete(s.chds, key)
+	s.channelsLock.Unlock()
+	listener, err := proxy.HandOffListener()
+	if err != nil {
+		proxy.Close()
+		return nil, nil, err
+	}
+	s.ILogf("Listener for %s handed off to another session", key)
+	return listener, proxy.chd, nil
+}
+
+// takeOverChannelDescriptor adds a reverse channel to this session, taking over the stub
+// listener of another session of the same user if there is one
+func (s *ServerSSHSession) takeOverChannelDescriptor(ctx context.Context, chd *ChannelDescriptor) error {
+	key := chd.String()
+	owner := s.listenerOwner(key)
+	if owner == nil {
+		return s.addChannelDescriptor(ctx, chd)
+	}
+	listener, stubChd, err := owner.releaseListener(key)
+	if err != nil {
+		s.DLogf("Unable to take over listener for %s from session #%d, listening anew: %s", key, owner.ID(), err)
+		return s.addChannelDescriptor(ctx, chd)
+	}
+	s.channelsLock.Lock()
+	defer s.channelsLock.Unlock()
+	if _, ok := s.chds[key]; ok {
+		listener.Close()
+		return nil
+	}
+	i := s.nextProxyIndex
+	s.nextProxyIndex++
+	proxy := NewTCPProxy(s.Logger, s, i, stubChd)
+	s.AddShutdownChild(proxy)
+	if err := proxy.StartWithListener(ctx, listener); err != nil {
+		listener.Close()
+		return s.DLogErrorf("Unable to start taken over listener %s: %s", key, err)
+	}
+	s.reverseProxies[key] = proxy
+	s.chds[key] = chd
+	s.server.listenerTakeoversStat.Inc()
+	s.ILogf("Took over listener for %s from session #%d", key, owner.ID())
+	return nil
+}
+
+// handleListenerTakeoverRequest handles a request from the client to add reverse channels,
+// taking over their stub listeners from other sessions of the same user. All channels are
+// validated before any changes are made.
+func (s *ServerSSHSession) handleListenerTakeoverRequest(ctx context.Context, r *ssh.Request) error {
+	failed := func(err error) error {
+		s.sendSSHErrorReply(ctx, r, err)
+		return err
+	}
+
+	c := &DynamicChannelsRequest{}
+	err := c.Unmarshal(r.Payload)
+	if err != nil {
+		return failed(s.DLogErrorf("Invalid listener takeover request encoding: %s", err))
+	}
+
+	for _, chd := range c.AddChannelDescriptors {
+		if !chd.Reverse {
+			return failed(s.DLogErrorf("Only the listeners of reverse remotes can be taken over: %s", chd))
+		}
+		if err := s.checkChannelDescriptor(chd); err != nil {
+			return failed(err)
+		}
+	}
+
+	for _, chd := range c.AddChannelDescriptors {
+		if err := s.takeOverChannelDescriptor(ctx, chd); err != nil {
+			return failed(err)
+		}
+	}
+
+	payload, err := s.channelsReplyPayload(c.WantReply, c.AddChannelDescriptors, nil)
+	if err != nil {
+		return failed(err)
+	}
+	return s.sendSSHReply(ctx, r, true, payload)
+}
@@ -135,6 +135,22 @@ func NewLockedUnixSocketListener(logger Logger, path string) (*LockedUnixSocketL
 	return l, nil
 }
 
+// privateUmaskLock serializes changes to the process umask by NewPrivateUnixSocketListener
+var privateUmaskLock sync.Mutex
+
+// NewPrivateUnixSocketListener is like NewLockedUnixSocketListener, but creates the socket
+// and its lockfile with a umask of 0077, so that only the owner can ever connect to it; a
+// chmod after listening would leave a window in which anyone could. Since the umask belongs
+// to the process, files created by other goroutines meanwhile are also only accessible to
+// the owner.
+func NewPrivateUnixSocketListener(logger Logger, path string) (*LockedUnixSocketListener, error) {
+	privateUmaskLock.Lock()
+	defer privateUmaskLock.Unlock()
+	oldUmask := syscall.Umask(0077)
+	defer syscall.Umask(oldUmask)
+	return NewLockedUnixSocketListener(logger, path)
+}
+
 func (l *LockedUnixSocketListener) String() string {
 	return l.Logger.Prefix()
 }
@@ -0,0 +1,90 @@
+package chshare
+
+import (
+	"sync"
+	"time"
+)
+
+// DefaultSessionLogLines is the default number of recent log lines kept for each server session
+const DefaultSessionLogLines = 100
+
+// LogRingLine is a log line captured by a LogRing
+type LogRingLine struct {
+	Time    time.Time
+	Level   LogLevel
+	Message string
+}
+
+// LogRing keeps the most recent log lines of a logger and the loggers forked from it, down to
+// its own log level, whatever the level of the loggers' output. This allows e.g. the debug
+// log of a single session to be read without enabling debug logging for the whole process.
+type LogRing struct {
+	level LogLevel
+	clock Clock
+
+	// lock protects lines and next
+	lock  sync.Mutex
+	lines []LogRingLine
+
+	// next is the index in lines of the oldest line, which the next line replaces once
+	// lines is full
+	next int
+	size int
+}
+
+// NewLogRing creates a LogRing that keeps the last size lines logged at level or lower,
+// timestamped by clock
+func NewLogRing(size int, level LogLevel, clock Clock) *LogRing {
+	return &LogRing{
+		level: level,
+		clock: clock,
+		lines: make([]LogRingLine, 0, size),
+		size:  size,
+	}
+}
+
+// Captures returns true if lines logged at logLevel are kept. A nil LogRing keeps nothing.
+func (r *LogRing) Captures(logLevel LogLevel) bool {
+	return r != nil && logLevel <= r.level
+}
+
+// Add keeps a log line if its level is captured, replacing the oldest line if the ring is full
+func (r *LogRing) Add(logLevel LogLevel, msg string) {
+	if !r.Captures(logLevel) || r.size <= 0 {
+		return
+	}
+	line := LogRingLine{Time: r.clock.Now(), Level: logLevel, Message: msg}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if len(r.lines) < r.size {
+		r.lines = append(r.lines, line)
+		return
+	}
+	r.lines[r.next] = line
+	r.next = (r.next + 1) % r.size
+}
+
+// Lines returns up to max of the most recent lines, oldest first, or all of them if max is 0
+func (r *LogRing) Lines(max int) []LogRingLine {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	result := make([]LogRingLine, 0, len(r.lines))
+	result = append(result, r.lines[r.next:]...)
+	result = append(result, r.lines[:r.next]...)
+	if max > 0 && len(result) > max {
+		result = result[len(result)-max:]
+	}
+	return result
+}
+
+// WithLogRing returns a copy of logger that also keeps its log lines, and those of the
+// loggers forked from it, in ring. Loggers other than BasicLogger are returned unchanged.
+func WithLogRing(logger Logger, ring *LogRing) Logger {
+	bl, ok := logger.(*BasicLogger)
+	if !ok {
+		return logger
+	}
+	result := *bl
+	result.ring = ring
+	return &result
+}
@@ -226,6 +226,10 @@ type BasicLogger struct {
 	prefixC  string
 	logger   MinLogger
 	logLevel LogLevel
+
+	// ring, if not nil, keeps recent log lines regardless of logLevel; it is shared with
+	// forked loggers
+	ring *LogRing
 }
 
 // NewLogWrapper creates a new Logger that wraps an existing MinLogger
@@ -293,6 +297,9 @@ func (l *BasicLogger) Printf(f string, args ...interface{}) {
 // LogNoPrefix outputs to a Logger without the prefix if the given logLevel is enabled. Then,
 // if the given logLevel is LogLevelPanic or LogLevelFatal, exits appropriately
 func (l *BasicLogger) LogNoPrefix(logLevel LogLevel, args ...interface{}) {
+	if l.ring.Captures(logLevel) {
+		l.ring.Add(logLevel, fmt.Sprint(args...))
+	}
 	if logLevel <= l.logLevel || logLevel <= LogLevelFatal {
 		msg := fmt.Sprint(args...)
 		if logLevel >= LogLevelPanic {
@@ -310,6 +317,9 @@ func (l *BasicLogger) LogNoPrefix(logLevel LogLevel, args ...interface{}) {
 // LogfNoPrefix outputs to a Logger without the prefix if the given logLevel is enabled. Then,
 // if the given logLevel is LogLevelPanic or LogLevelFatal, exits appropriately
 func (l *BasicLogger) LogfNoPrefix(logLevel LogLevel, f string, args ...interface{}) {
+	if l.ring.Captures(logLevel) {
+		l.ring.Add(logLevel, fmt.Sprintf(f, args...))
+	}
 	if logLevel <= l.logLevel || logLevel <= LogLevelFatal {
 		msg := fmt.Sprintf(f, args...)
 		if logLevel <= LogLevelPanic {
@@ -327,7 +337,7 @@ func (l *BasicLogger) LogfNoPrefix(logLevel LogLevel, f string, args ...interfac
 // Log outputs to a Logger if the given logLevel is enabled. Then,
 // if the given logLevel is LogLevelPanic or LogLevelFatal, exits appropriately
 func (l *BasicLogger) Log(logLevel LogLevel, args ...interface{}) {
-	if logLevel <= l.logLevel || logLevel <= LogLevelFatal {
+	if logLevel <= l.logLevel || logLevel <= LogLevelFatal || l.ring.Captures(logLevel) {
 		msg := l.Sprint(args...)
 		l.LogNoPrefix(logLevel, msg)
 	}
@@ -336,7 +346,7 @@ func (l *BasicLogger) Log(logLevel LogLevel, args ...interface{}) {
 // Logf outputs to a Logger if the given logLevel is enabled. Then,
 // if the given logLevel is LogLevelPanic or LogLevelFatal, exits appropriately
 func (l *BasicLogger) Logf(logLevel LogLevel, f string, args ...interface{}) {
-	if logLevel <= l.logLevel || logLevel <= LogLevelFatal {
+	if logLevel <= l.logLevel || logLevel <= LogLevelFatal || l.ring.Captures(logLevel) {
 		msg := l.Sprintf(f, args...)
 		l.LogNoPrefix(logLevel, msg)
 	}
@@ -554,6 +564,7 @@ func (l *BasicLogger) Fork(prefix string, args ...interface{}) Logger {
 	args = append([]interface{}{l.prefix}, args...)
 	newPrefix := fmt.Sprintf("%s: "+prefix, args...)
 	ll := NewLoggerWithFlags(newPrefix, l.Flags(), l.GetLogLevel())
+	ll.(*BasicLogger).ring = l.ring
 	return ll
 }
 
@@ -0,0 +1,161 @@
+package chshare
+
+import (
+	"context"
+	"fmt"
+)
+
+// LoopBridge connects a loop name on the server to a TCP or unix domain socket on the server
+// host, so that local processes that are not chisel clients can take part in loops. A bridge
+// is written as a pair of endpoint URIs, like a remote. With a loop on the listening side,
+// e.g. "loop://db,tcp://localhost:5432", clients that connect to the loop name are connected
+// to the local service, as if it were a client's R:loop://db remote. With a loop on the
+// connecting side, e.g. "tcp://127.0.0.1:7000,loop://jobqueue", local processes that connect
+// to the listener are connected to the loop name's listener, e.g. a client's
+// R:loop://jobqueue remote.
+type LoopBridge struct {
+	ShutdownHelper
+	chd        *ChannelDescriptor
+	loopServer *LoopServer
+	stats      *StatsRegistry
+	clock      Clock
+	dialSource *DialSource
+	stub       LocalStubChannelEndpoint
+	connsStat  *Stat
+}
+
+// ParseLoopBridge parses a loop bridge's pair of endpoint URIs. Exactly one endpoint must be a
+// loop, and the other a TCP or unix domain socket.
+func ParseLoopBridge(s string) (*ChannelDescriptor, error) {
+	chd, err := ParseChannelDescriptor(s)
+	if err != nil {
+		return nil, err
+	}
+	if chd.Reverse {
+		return nil, fmt.Errorf("Invalid loop bridge '%s'; a loop bridge cannot be reversed", s)
+	}
+	if len(chd.Stub.Options) > 0 {
+		return nil, fmt.Errorf("Invalid loop bridge '%s'; a loop bridge takes no options", s)
+	}
+	stubIsLoop := chd.Stub.Type == ChannelEndpointTypeLoop
+	other := chd.Skeleton
+	if !stubIsLoop {
+		other = chd.Stub
+	}
+	if stubIsLoop == (chd.Skeleton.Type == ChannelEndpointTypeLoop) ||
+		(other.Type != ChannelEndpointTypeTCP && other.Type != ChannelEndpointTypeUnix) {
+		return nil, fmt.Errorf("Invalid loop bridge '%s'; expected a loop and a TCP or unix endpoint", s)
+	}
+	return chd, nil
+}
+
+// NewLoopBridge creates a new LoopBridge. TCP connections to local services are made from
+// dialSource, if it is not nil, and measure time by clock. It does not start listening until
+// Start is called.
+func NewLoopBridge(
+	logger Logger,
+	loopServer *LoopServer,
+	stats *StatsRegistry,
+	clock Clock,
+	dialSource *DialSource,
+	chd *ChannelDescriptor,
+) (*LoopBridge, error) {
+	if loopServer == nil {
+		return nil, fmt.Errorf("Loop bridge %s requires loop endpoints, which are disabled", chd)
+	}
+	b := &LoopBridge{
+		chd:        chd,
+		loopServer: loopServer,
+		stats:      stats,
+		clock:      clock,
+		dialSource: dialSource,
+		connsStat: stats.Counter(
+			"chisel_loop_bridge_connections_total",
+			"Number of connections through a loop bridge",
+			StatLabels{"bridge": chd.String()}),
+	}
+	b.InitShutdownHelper(logger.Fork("LoopBridge: %s", chd), b)
+	var err error
+	switch chd.Stub.Type {
+	case ChannelEndpointTypeLoop:
+		b.stub, err = NewLoopStubEndpoint(b.Logger, chd.Stub, loopServer)
+	case ChannelEndpointTypeTCP:
+		b.stub, err = NewTCPStubEndpoint(b.Logger, chd.Stub)
+	case ChannelEndpointTypeUnix:
+		b.stub, err = NewUnixStubEndpoint(b.Logger, chd.Stub)
+	}
+	if err != nil {
+		return nil, err
+	}
+	b.AddShutdownChild(b.stub)
+	return b, nil
+}
+
+func (b *LoopBridge) String() string {
+	return b.Logger.Prefix()
+}
+
+// HandleOnceShutdown will be called exactly once, in its own goroutine. It should take completionError
+// as an advisory completion value, actually shut down, then return the real completion value.
+func (b *LoopBridge) HandleOnceShutdown(completionErr error) error {
+	return completionErr
+}
+
+// Start begins listening on the bridge's listening side and serving connections in the
+// background
+func (b *LoopBridge) Start(ctx context.Context) error {
+	return b.DoOnceActivate(
+		func() error {
+			if err := b.stub.StartListening(); err != nil {
+				return err
+			}
+			b.ShutdownOnContext(ctx)
+			b.ILogf("Bridging %s to %s", b.chd.Stub, b.chd.Skeleton)
+			go b.acceptLoop(ctx)
+			return nil
+		},
+		true,
+	)
+}
+
+func (b *LoopBridge) acceptLoop(ctx context.Context) {
+	for {
+		callerConn, err := b.stub.Accept(ctx)
+		if err != nil {
+			if !b.IsStartedShutdown() {
+				b.StartShutdown(b.ILogErrorf("Accept failed: %s", err))
+			}
+			return
+		}
+		b.connsStat.Inc()
+		go b.serve(ctx, callerConn)
+	}
+}
+
+// serve connects a connection accepted by the bridge's listening side to its other side
+func (b *LoopBridge) serve(ctx context.Context, callerConn ChannelConn) {
+	var ep LocalSkeletonChannelEndpoint
+	var err error
+	switch b.chd.Skeleton.Type {
+	case ChannelEndpointTypeLoop:
+		ep, err = NewLoopSkeletonEndpoint(b.Logger, b.chd.Skeleton, b.loopServer)
+	case ChannelEndpointTypeTCP:
+		ep, err = NewTCPSkeletonEndpoint(b.Logger, b.stats, b.clock, b.chd.Skeleton, b.dialSource, nil)
+	case ChannelEndpointTypeUnix:
+		ep, err = NewUnixSkeletonEndpoint(b.Logger, b.chd.Skeleton, nil)
+	}
+	if err != nil {
+		callerConn.Close()
+		b.DLogf("Unable to create endpoint %s: %s", b.chd.Skeleton, err)
+		return
+	}
+	defer ep.Close()
+	callerToService, serviceToCaller, err := ep.DialAndServe(ctx, callerConn, nil)
+	if err != nil {
+		b.DLogf("Connection failed after %d bytes to %s, %d bytes from it: %s",
+			callerToService, b.chd.Skeleton, serviceToCaller, err)
+		return
+	}
+	b.DLogf("Connection ended normally, %d bytes to %s, %d bytes from it",
+		callerToService, b.chd.Skeleton, serviceToCaller)
+}
@@ -0,0 +1,78 @@
+package chshare
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// LoopNamesRequestType is the SSH request type used by a client to ask the server which loop
+// names currently have a listener, so that clients can discover each other's services. A
+// successful reply carries the names, one per line.
+const LoopNamesRequestType = "loop-names"
+
+// Names returns the loop pathnames that currently have a registered acceptor, in sorted order
+func (s *LoopServer) Names() []string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	names := make([]string, 0, len(s.entries))
+	for name := range s.entries {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// handleLoopNamesRequest answers a LoopNamesRequestType SSH request with the loop names that
+// the session's user may connect to, i.e. whose loop endpoint descriptor ("<loop:name>")
+// matches the user's access list
+func (s *ServerSSHSession) handleLoopNamesRequest(ctx context.Context, r *ssh.Request) error {
+	loopServer := s.GetLoopServer()
+	if loopServer == nil {
+		err := s.DLogErrorf("Loop server disabled")
+		s.sendSSHErrorReply(ctx, r, err)
+		return err
+	}
+	var names []string
+	user := s.user
+	if user != nil {
+		var ok bool
+		user, ok = s.users.Get(user.Name)
+		if !ok {
+			err := s.DLogErrorf("User \"%s\" no longer exists", s.user.Name)
+			s.sendSSHErrorReply(ctx, r, err)
+			return err
+		}
+	}
+	for _, name := range loopServer.Names() {
+		ced := ChannelEndpointDescriptor{Type: ChannelEndpointTypeLoop, Path: name}
+		if user == nil || user.HasAccess(ced.String()) {
+			names = append(names, name)
+		}
+	}
+	return s.sendSSHReply(ctx, r, true, []byte(strings.Join(names, "\n")))
+}
+
+// LoopNames asks the server for the loop names that currently have a listener and that this
+// client's user may connect to with a loop://<name> remote. It waits for the client to be
+// connected.
+func (c *Client) LoopNames() ([]string, error) {
+	sshConn, err := c.GetSSHConn()
+	if err != nil {
+		return nil, err
+	}
+	ok, reply, err := sshConn.SendRequest(LoopNamesRequestType, true, nil)
+	if err != nil {
+		return nil, fmt.Errorf("Loop names request failed: %s", err)
+	}
+	if !ok {
+		return nil, fmt.Errorf("Server refused loop names request: %s", string(reply))
+	}
+	if len(reply) == 0 {
+		return nil, nil
+	}
+	return strings.Split(string(reply), "\n"), nil
+}
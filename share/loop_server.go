@@ -115,7 +115,9 @@ func (s *LoopServer) DialAndServe(
 ) (int64, int64, error) {
 	acceptor := s.GetAcceptor(name)
 	if acceptor == nil {
-		return 0, 0, fmt.Errorf("%s: Nothing listening on loopback name: %s", s.Logger.Prefix(), name)
+		err := fmt.Errorf("%s: Nothing listening on loopback name: %s", s.Logger.Prefix(), name)
+		closeOnDialFailure(callerConn, err)
+		return 0, 0, err
 	}
 	return acceptor.HandleDialAndServe(ctx, callerConn, extraData)
 }
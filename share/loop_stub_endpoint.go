@@ -47,6 +47,9 @@ func (ep *LoopStubEndpoint) HandleOnceShutdown(completionErr error) error {
 		ep.loopServer.UnregisterAcceptor(ep.GetLoopPath(), ep)
 		ep.listening = false
 	}
+	// nothing more can be enqueued once the endpoint is not listening, so closing the queue
+	// lets the range below, and any pending Accept, end
+	close(ep.callerConns)
 	ep.Lock.Unlock()
 
 	for dc := range ep.callerConns {
@@ -55,8 +58,6 @@ func (ep *LoopStubEndpoint) HandleOnceShutdown(completionErr error) error {
 		}
 	}
 
-	close(ep.callerConns)
-
 	return completionErr
 }
 
@@ -0,0 +1,331 @@
+package chshare
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// MQTT skeleton endpoints connect to an MQTT broker, like TCP skeletons, and can restrict
+// the topics that the Caller may use to the topic prefixes of the session's user (see the
+// "mqtt_topics" list in the --authfile). The Caller's stream is parsed as MQTT control
+// packets, and the channel is closed at the first one that names another topic:
+//
+//     CONNECT      the will topic, if there is one
+//     PUBLISH      the topic name
+//     SUBSCRIBE    each topic filter, up to its first wildcard
+//
+// CONNECT and SUBSCRIBE packets are buffered until they are complete, and PUBLISH packets
+// until their topic has been read; everything else is passed through as it arrives. MQTT
+// 3.1, 3.1.1 and 5 are supported.
+
+// MQTT control packet types that are inspected
+const (
+	mqttConnect   = 1
+	mqttPublish   = 3
+	mqttSubscribe = 8
+)
+
+// mqttMaxInspectedPacket is the largest CONNECT or SUBSCRIBE packet that is accepted
+const mqttMaxInspectedPacket = 1024 * 1024
+
+var errMQTTMalformed = errors.New("Malformed MQTT packet")
+
+// mqttFilterPrefix returns the part of a topic filter that a topic it matches must begin
+// with: the filter up to its first wildcard, without the "$share/<group>/" prefix of a
+// shared subscription
+func mqttFilterPrefix(filter string) string {
+	if strings.HasPrefix(filter, "$share/") {
+		parts := strings.SplitN(filter, "/", 3)
+		if len(parts) == 3 {
+			filter = parts[2]
+		}
+	}
+	if i := strings.IndexAny(filter, "+#"); i >= 0 {
+		filter = filter[:i]
+	}
+	return filter
+}
+
+// mqttReader reads the fields of an MQTT control packet. The first error is kept, and
+// later reads return zero values.
+type mqttReader struct {
+	b   []byte
+	err error
+}
+
+func (r *mqttReader) bytes(n int) []byte {
+	if r.err != nil || n > len(r.b) {
+		r.err = errMQTTMalformed
+		return nil
+	}
+	v := r.b[:n]
+	r.b = r.b[n:]
+	return v
+}
+
+func (r *mqttReader) byte() byte {
+	b := r.bytes(1)
+	if b == nil {
+		return 0
+	}
+	return b[0]
+}
+
+func (r *mqttReader) uint16() int {
+	b := r.bytes(2)
+	if b == nil {
+		return 0
+	}
+	return int(binary.BigEndian.Uint16(b))
+}
+
+func (r *mqttReader) string() string {
+	return string(r.bytes(r.uint16()))
+}
+
+func (r *mqttReader) varint() int {
+	v := 0
+	for i := 0; i < 4; i++ {
+		b := r.byte()
+		v |= int(b&0x7f) << (7 * uint(i))
+		if b&0x80 == 0 {
+			return v
+		}
+	}
+	r.err = errMQTTMalformed
+	return 0
+}
+
+// skipProperties skips the properties of an MQTT 5 packet
+func (r *mqttReader) skipProperties() {
+	r.bytes(r.varint())
+}
+
+// mqttInspector parses a Caller's stream of MQTT control packets, and checks the topics
+// they name against a list of allowed topic prefixes
+type mqttInspector struct {
+	prefixes []string
+
+	// level is the protocol level given by CONNECT, e.g. 4 for MQTT 3.1.1 or 5 for MQTT 5
+	level byte
+
+	// header is the fixed header of the current packet, while it is read or buffered
+	header []byte
+
+	// body is the buffered part of the current packet's body, of which need bytes are
+	// buffered before it is inspected
+	body []byte
+	need int
+
+	// remaining is the number of bytes of the current packet's body not yet passed on
+	remaining int
+
+	// passthrough is true while the rest of the current packet is passed on as it arrives
+	passthrough bool
+}
+
+func newMQTTInspector(prefixes []string) *mqttInspector {
+	return &mqttInspector{prefixes: prefixes, level: 4}
+}
+
+// feed parses p, the next part of the Caller's stream, calling emit with the parts that may
+// be passed on to the broker. An error is returned at the first packet that is denied or
+// malformed.
+func (m *mqttInspector) feed(p []byte, emit func([]byte) error) error {
+	for len(p) > 0 {
+		if m.passthrough {
+			n := len(p)
+			if n > m.remaining {
+				n = m.remaining
+			}
+			if err := emit(p[:n]); err != nil {
+				return err
+			}
+			p = p[n:]
+			m.remaining -= n
+			m.passthrough = m.remaining > 0
+		} else if m.need > 0 {
+			n := m.need - len(m.body)
+			if n > len(p) {
+				n = len(p)
+			}
+			m.body = append(m.body, p[:n]...)
+			p = p[n:]
+			if len(m.body) == m.need {
+				if err := m.inspectBody(emit); err != nil {
+					return err
+				}
+			}
+		} else {
+			m.header = append(m.header, p[0])
+			p = p[1:]
+			if err := m.readHeader(emit); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// readHeader handles a fixed header byte, deciding how much of the packet to buffer once
+// the header is complete
+func (m *mqttInspector) readHeader(emit func([]byte) error) error {
+	if len(m.header) < 2 {
+		return nil
+	}
+	if m.header[len(m.header)-1]&0x80 != 0 {
+		if len(m.header) == 5 {
+			return errMQTTMalformed
+		}
+		return nil
+	}
+	r := &mqttReader{b: m.header[1:]}
+	m.remaining = r.varint()
+	switch m.header[0] >> 4 {
+	case mqttConnect, mqttSubscribe:
+		if m.remaining > mqttMaxInspectedPacket {
+			return fmt.Errorf("MQTT packet of %d bytes is too large to inspect", m.remaining)
+		}
+		m.need = m.remaining
+	case mqttPublish:
+		if m.remaining < 2 {
+			return errMQTTMalformed
+		}
+		// the topic length, then the topic
+		m.need = 2
+	default:
+		m.need = 0
+	}
+	if m.need == 0 {
+		return m.pass(emit)
+	}
+	return nil
+}
+
+// inspectBody checks the buffered part of the current packet, and passes it on if it is
+// allowed
+func (m *mqttInspector) inspectBody(emit func([]byte) error) error {
+	r := &mqttReader{b: m.body}
+	switch m.header[0] >> 4 {
+	case mqttConnect:
+		r.string()
+		m.level = r.byte()
+		flags := r.byte()
+		r.uint16()
+		if m.level >= 5 {
+			r.skipProperties()
+		}
+		r.string()
+		if flags&0x04 != 0 {
+			if m.level >= 5 {
+				r.skipProperties()
+			}
+			if topic := r.string(); r.err == nil && !m.topicAllowed(topic) {
+				return fmt.Errorf("MQTT will topic '%s' denied", topic)
+			}
+		}
+	case mqttPublish:
+		if len(m.body) == 2 {
+			topicLen := r.uint16()
+			if 2+topicLen > m.remaining {
+				return errMQTTMalformed
+			}
+			if topicLen > 0 {
+				m.need = 2 + topicLen
+				return nil
+			}
+			// an empty MQTT 5 topic uses a topic alias, which was set up by a PUBLISH
+			// whose topic was checked
+			if m.level < 5 {
+				return errMQTTMalformed
+			}
+			break
+		}
+		if topic := r.string(); !m.topicAllowed(topic) {
+			return fmt.Errorf("MQTT publish to topic '%s' denied", topic)
+		}
+	case mqttSubscribe:
+		r.uint16()
+		if m.level >= 5 {
+			r.skipProperties()
+		}
+		for r.err == nil && len(r.b) > 0 {
+			filter := r.string()
+			r.byte()
+			if r.err == nil && !m.topicAllowed(mqttFilterPrefix(filter)) {
+				return fmt.Errorf("MQTT subscription to topic filter '%s' denied", filter)
+			}
+		}
+	}
+	if r.err != nil {
+		return r.err
+	}
+	return m.pass(emit)
+}
+
+// pass passes on the current packet's header and buffered body, and then the rest of the
+// packet as it arrives
+func (m *mqttInspector) pass(emit func([]byte) error) error {
+	packet := append(m.header, m.body...)
+	m.remaining -= len(m.body)
+	m.header, m.body, m.need = nil, nil, 0
+	m.passthrough = m.remaining > 0
+	return emit(packet)
+}
+
+// topicAllowed returns true if topic begins with one of the allowed prefixes
+func (m *mqttInspector) topicAllowed(topic string) bool {
+	for _, prefix := range m.prefixes {
+		if strings.HasPrefix(topic, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// mqttFilterConn is a ChannelConn to an MQTT broker that only passes on the packets that
+// an mqttInspector allows. The first packet that is denied closes the connection, after
+// calling denied.
+type mqttFilterConn struct {
+	ChannelConn
+	inspector *mqttInspector
+	denied    func(err error)
+	err       error
+}
+
+// Write inspects p and writes the parts that are allowed to the underlying ChannelConn
+func (c *mqttFilterConn) Write(p []byte) (int, error) {
+	if c.err != nil {
+		return 0, c.err
+	}
+	c.err = c.inspector.feed(p, func(b []byte) error {
+		_, err := c.ChannelConn.Write(b)
+		return err
+	})
+	if c.err != nil {
+		c.denied(c.err)
+		c.ChannelConn.Close()
+		return 0, c.err
+	}
+	return len(p), nil
+}
+
+func (c *mqttFilterConn) String() string {
+	return fmt.Sprintf("mqtt:%s", c.ChannelConn)
+}
+
+// GetMQTTTopicPrefixes returns the topic prefixes of the session's user, to which MQTT
+// skeleton endpoints restrict the topics their Callers may use, or nil if they are not
+// restricted
+func (s *ServerSSHSession) GetMQTTTopicPrefixes() []string {
+	if s.user == nil {
+		return nil
+	}
+	user, ok := s.users.Get(s.user.Name)
+	if !ok {
+		return []string{}
+	}
+	return user.MQTTTopics
+}
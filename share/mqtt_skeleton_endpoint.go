@@ -0,0 +1,92 @@
+package chshare
+
+import (
+	"context"
+)
+
+// MQTTSkeletonEndpoint implements a local MQTT skeleton, which connects to an MQTT broker as
+// a TCP skeleton does, and restricts the topics its Callers may use (see mqtt.go)
+type MQTTSkeletonEndpoint struct {
+	// Implements LocalSkeletonChannelEndpoint
+	BasicEndpoint
+	tcp        *TCPSkeletonEndpoint
+	prefixes   []string
+	deniedStat *Stat
+}
+
+// NewMQTTSkeletonEndpoint creates a new MQTTSkeletonEndpoint. Its Callers may only use topics
+// that begin with one of prefixes, unless prefixes is nil.
+func NewMQTTSkeletonEndpoint(
+	logger Logger,
+	stats *StatsRegistry,
+	clock Clock,
+	ced *ChannelEndpointDescriptor,
+	defaultSource *DialSource,
+	proxy *DialProxy,
+	prefixes []string,
+) (*MQTTSkeletonEndpoint, error) {
+	ep := &MQTTSkeletonEndpoint{
+		BasicEndpoint: BasicEndpoint{
+			ced: ced,
+		},
+		prefixes: prefixes,
+		deniedStat: stats.Counter(
+			"chisel_mqtt_denials_total",
+			"Number of MQTT channels closed for using a topic outside their user's mqtt_topics",
+			nil),
+	}
+	ep.InitBasicEndpoint(logger, ep, "MQTTSkeletonEndpoint: %s", ced)
+	tcp, err := NewTCPSkeletonEndpoint(ep.Logger, stats, clock, ced, defaultSource, proxy)
+	if err != nil {
+		return nil, err
+	}
+	ep.tcp = tcp
+	ep.AddShutdownChild(tcp)
+	return ep, nil
+}
+
+// HandleOnceShutdown will be called exactly once, in its own goroutine. It should take completionError
+// as an advisory completion value, actually shut down, then return the real completion value.
+func (ep *MQTTSkeletonEndpoint) HandleOnceShutdown(completionErr error) error {
+	return completionErr
+}
+
+// Dial connects to the broker. Part of the DialerChannelEndpoint interface
+func (ep *MQTTSkeletonEndpoint) Dial(ctx context.Context, extraData []byte) (ChannelConn, error) {
+	conn, err := ep.tcp.Dial(ctx, extraData)
+	if err != nil || ep.prefixes == nil {
+		return conn, err
+	}
+	return &mqttFilterConn{
+		ChannelConn: conn,
+		inspector:   newMQTTInspector(ep.prefixes),
+		denied: func(err error) {
+			ep.ILogf("Closing MQTT connection: %s", err)
+			ep.deniedStat.Inc()
+		},
+	}, nil
+}
+
+// DialAndServe initiates a new connection to a Called Service as specified in the
+// endpoint configuration, then services the connection using an already established
+// callerConn as the proxied Caller's end of the session. This call does not return until
+// the bridged session completes or an error occurs. The context may be used to cancel
+// connection or servicing of the active session.
+// Ownership of callerConn is transferred to this function, and it will be closed before
+// this function returns, regardless of whether an error occurs.
+// The return value is a tuple consisting of:
+//        Number of bytes sent from callerConn to the dialed calledServiceConn
+//        Number of bytes sent from the dialed calledServiceConn callerConn
+//        An error, if one occured during dial or copy in either direction
+func (ep *MQTTSkeletonEndpoint) DialAndServe(
+	ctx context.Context,
+	callerConn ChannelConn,
+	extraData []byte,
+) (int64, int64, error) {
+	calledServiceConn, err := ep.Dial(ctx, extraData)
+	if err != nil {
+		closeOnDialFailure(callerConn, err)
+		return 0, 0, err
+	}
+	return BasicBridgeChannels(ctx, ep.Logger, callerConn, calledServiceConn)
+}
@@ -0,0 +1,67 @@
+package chshare
+
+import (
+	"context"
+)
+
+// ObserveSkeletonEndpoint implements a read-only view of another channel on the server
+type ObserveSkeletonEndpoint struct {
+	// Implements LocalSkeletonChannelEndpoint
+	BasicEndpoint
+	observer *ChannelObserver
+}
+
+// NewObserveSkeletonEndpoint creates a new ObserveSkeletonEndpoint for an observer
+func NewObserveSkeletonEndpoint(
+	logger Logger,
+	ced *ChannelEndpointDescriptor,
+	observer *ChannelObserver,
+) (*ObserveSkeletonEndpoint, error) {
+	ep := &ObserveSkeletonEndpoint{
+		BasicEndpoint: BasicEndpoint{
+			ced: ced,
+		},
+		observer: observer,
+	}
+	ep.InitBasicEndpoint(logger, ep, "ObserveSkeletonEndpoint: %s", ced)
+	return ep, nil
+}
+
+// HandleOnceShutdown will be called exactly once, in its own goroutine. It should take completionError
+// as an advisory completion value, actually shut down, then return the real completion value.
+func (ep *ObserveSkeletonEndpoint) HandleOnceShutdown(completionErr error) error {
+	return completionErr
+}
+
+// Dial attaches to the observed channel. Part of the DialerChannelEndpoint interface
+func (ep *ObserveSkeletonEndpoint) Dial(ctx context.Context, extraData []byte) (ChannelConn, error) {
+	if ep.IsStartedShutdown() {
+		return nil, ep.Errorf("Endpoint is closed: %s", ep.String())
+	}
+	id, err := ParseObservedChannelID(ep.ced.Path)
+	if err != nil {
+		return nil, ep.Errorf("%s", err)
+	}
+	conn, err := ep.observer.Attach(ep.Logger, id)
+	if err != nil {
+		return nil, ep.Errorf("%s", err)
+	}
+	ep.AddShutdownChild(conn)
+	return conn, nil
+}
+
+// DialAndServe attaches to the observed channel, then copies its data to callerConn until
+// either ends. Data from callerConn is discarded. Part of the DialerChannelEndpoint
+// interface; see TCPSkeletonEndpoint.DialAndServe.
+func (ep *ObserveSkeletonEndpoint) DialAndServe(
+	ctx context.Context,
+	callerConn ChannelConn,
+	extraData []byte,
+) (int64, int64, error) {
+	calledServiceConn, err := ep.Dial(ctx, extraData)
+	if err != nil {
+		closeOnDialFailure(callerConn, err)
+		return 0, 0, err
+	}
+	return BasicBridgeChannels(ctx, ep.Logger, callerConn, calledServiceConn)
+}
@@ -0,0 +1,127 @@
+package chshare
+
+import (
+	"fmt"
+	"net"
+	"regexp"
+	"strings"
+)
+
+// PACContentType is the MIME type of a proxy auto-configuration file
+const PACContentType = "application/x-ns-proxy-autoconfig"
+
+// pacHostPatternRegexp matches a valid host name or shell expression destination of a
+// PACRules. It excludes anything that would need quoting in the generated script.
+var pacHostPatternRegexp = regexp.MustCompile(`^[a-zA-Z0-9_.*?-]+$`)
+
+// PACRules is the set of destinations that a proxy auto-configuration (PAC) file routes
+// through a tunnel's SOCKS stubs, so that browsers can be pointed at a single PAC URL
+// rather than configured by hand. Other destinations are reached directly.
+type PACRules struct {
+	rules []string
+	conds []string
+}
+
+// ParsePACRules parses a comma-separated list of destinations, each of which is one of:
+//
+//     .example.com        example.com's subdomains (also written *.example.com)
+//     10.0.0.0/8          An IPv4 network, matched after resolving the host name
+//     db*.internal        A shell expression matched against the host name
+//     intranet            A single host name or IP address
+//
+// or "*" for every destination.
+func ParsePACRules(s string) (*PACRules, error) {
+	p := &PACRules{}
+	for _, rule := range strings.Split(s, ",") {
+		rule = strings.ToLower(strings.TrimSpace(rule))
+		if rule == "" {
+			continue
+		}
+		cond, err := pacCondition(rule)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid PAC destination '%s': %s", rule, err)
+		}
+		p.rules = append(p.rules, rule)
+		p.conds = append(p.conds, cond)
+	}
+	if len(p.rules) == 0 {
+		return nil, fmt.Errorf("Empty PAC destination list")
+	}
+	return p, nil
+}
+
+// pacCondition returns the PAC script condition that matches a destination rule
+func pacCondition(rule string) (string, error) {
+	if rule == "*" {
+		return "true", nil
+	}
+	if strings.Contains(rule, "/") {
+		ip, ipNet, err := net.ParseCIDR(rule)
+		if err != nil {
+			return "", err
+		}
+		if ip.To4() == nil {
+			return "", fmt.Errorf("Only IPv4 networks are supported")
+		}
+		return fmt.Sprintf("isInNet(host, %q, %q)", ipNet.IP.String(), net.IP(ipNet.Mask).String()), nil
+	}
+	if !pacHostPatternRegexp.MatchString(rule) {
+		return "", fmt.Errorf("Must be a host name, domain, IPv4 network or shell expression")
+	}
+	if strings.HasPrefix(rule, "*.") && !strings.ContainsAny(rule[2:], "*?") {
+		rule = rule[1:]
+	}
+	if strings.HasPrefix(rule, ".") && !strings.ContainsAny(rule, "*?") {
+		return fmt.Sprintf("dnsDomainIs(host, %q)", rule), nil
+	}
+	if strings.ContainsAny(rule, "*?") {
+		return fmt.Sprintf("shExpMatch(host, %q)", rule), nil
+	}
+	return fmt.Sprintf("host == %q", rule), nil
+}
+
+func (p *PACRules) String() string {
+	return strings.Join(p.rules, ",")
+}
+
+// Generate returns a PAC file that routes the destinations through the SOCKS5 proxies at
+// proxyAddrs, each a <host>:<port>, in order of preference. If there are no proxies, every
+// destination is reached directly.
+func (p *PACRules) Generate(proxyAddrs []string) string {
+	var b strings.Builder
+	b.WriteString("// Generated by chisel " + BuildVersion + "\n")
+	b.WriteString("function FindProxyForURL(url, host) {\n")
+	if len(proxyAddrs) == 0 {
+		b.WriteString("  // No SOCKS remote is listening\n")
+	} else {
+		proxies := make([]string, len(proxyAddrs))
+		for i, addr := range proxyAddrs {
+			proxies[i] = "SOCKS5 " + addr
+		}
+		b.WriteString("  host = host.toLowerCase();\n")
+		b.WriteString("  if (" + strings.Join(p.conds, " ||\n      ") + ") {\n")
+		fmt.Fprintf(&b, "    return %q;\n", strings.Join(proxies, "; "))
+		b.WriteString("  }\n")
+	}
+	b.WriteString("  return \"DIRECT\";\n")
+	b.WriteString("}\n")
+	return b.String()
+}
+
+// pacProxyAddr returns the address that browsers should use to reach a SOCKS stub listening
+// on addr, substituting host for a wildcard bind address such as 0.0.0.0
+func pacProxyAddr(addr string, host string) string {
+	bindHost, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	if IsWildcardBindAddr(bindHost) {
+		bindHost = host
+	}
+	return net.JoinHostPort(bindHost, port)
+}
+
+// isSocksStub returns true if chd is a channel with a TCP stub and a SOCKS skeleton
+func isSocksStub(chd *ChannelDescriptor) bool {
+	return chd.Stub.Type == ChannelEndpointTypeTCP && chd.Skeleton.Type == ChannelEndpointTypeSocks
+}
@@ -0,0 +1,93 @@
+package chshare
+
+import (
+	"fmt"
+	"net"
+	"syscall"
+)
+
+// sshPacketOverhead is a conservative allowance for the bytes the SSH protocol adds to a
+// chunk of channel data: the channel data message header, the packet length, padding and MAC
+const sshPacketOverhead = 96
+
+// wsFrameOverhead is a conservative allowance for the bytes the websocket and TLS protocols
+// add to a websocket message: the masked frame header and the TLS record header and tag
+const wsFrameOverhead = 40
+
+// wsWriteBufferSize is the websocket write buffer size, which must hold a whole tuned
+// websocket message so that it is sent as a single frame
+const wsWriteBufferSize = 16 * 1024
+
+// PathProfile holds the write sizes tuned to the network path of a transport connection
+type PathProfile struct {
+	// MSS is the maximum TCP segment size of the transport connection, or 0 if unknown
+	MSS int
+
+	// ChannelWriteSize is the largest chunk of channel data sent in a single SSH packet
+	ChannelWriteSize int
+
+	// TransportWriteSize is the largest write sent in a single websocket message, or 0 for
+	// no limit
+	TransportWriteSize int
+}
+
+func (p *PathProfile) String() string {
+	if p.TransportWriteSize == 0 {
+		return fmt.Sprintf("MSS=%d (default write sizes)", p.MSS)
+	}
+	return fmt.Sprintf("MSS=%d, channel writes=%d, websocket writes=%d", p.MSS, p.ChannelWriteSize, p.TransportWriteSize)
+}
+
+// newPathProfile returns the write sizes for a path with the given maximum segment size.
+// Websocket messages are sized to fill a whole number of segments, rather than leaving a
+// small segment at the end of each SSH packet, which matters on links with small MTUs such
+// as cellular ones. Paths whose segments are larger than the default writes are left alone.
+func newPathProfile(mss int) *PathProfile {
+	p := &PathProfile{MSS: mss, ChannelWriteSize: schedulerWriteChunkSize}
+	if mss <= 0 {
+		return p
+	}
+	segments := (schedulerWriteChunkSize + sshPacketOverhead + wsFrameOverhead) / mss
+	if segments < 1 {
+		return p
+	}
+	p.TransportWriteSize = segments*mss - wsFrameOverhead
+	p.ChannelWriteSize = p.TransportWriteSize - sshPacketOverhead
+	return p
+}
+
+// tcpMSS returns the maximum segment size of the TCP connection underlying conn, as
+// negotiated with the peer and lowered by path MTU discovery, or 0 if it is unknown
+func tcpMSS(conn net.Conn) int {
+	if tlsConn, ok := conn.(interface{ NetConn() net.Conn }); ok {
+		conn = tlsConn.NetConn()
+	}
+	sc, ok := conn.(syscall.Conn)
+	if !ok {
+		return 0
+	}
+	raw, err := sc.SyscallConn()
+	if err != nil {
+		return 0
+	}
+	mss := 0
+	raw.Control(func(fd uintptr) {
+		mss = getRawTCPMSS(fd)
+	})
+	return mss
+}
+
+// TuneTransport probes the network path of a new transport connection, before the SSH
+// handshake, and tunes the size of the transport's writes and of the scheduler's channel
+// writes to it. Transports other than websockets keep the default sizes.
+func TuneTransport(logger Logger, transport net.Conn, scheduler *WriteScheduler) {
+	ws, ok := transport.(*wsConn)
+	if !ok {
+		scheduler.SetWriteChunkSize(0)
+		return
+	}
+	profile := newPathProfile(tcpMSS(ws.UnderlyingConn()))
+	ws.maxWrite = profile.TransportWriteSize
+	scheduler.SetWriteChunkSize(profile.ChannelWriteSize)
+	logger.DLogf("Transport path: %s", profile)
+}
@@ -0,0 +1,15 @@
+//+build linux
+
+package chshare
+
+import "syscall"
+
+// getRawTCPMSS returns the maximum segment size of a connected TCP socket, or 0 if it cannot
+// be read
+func getRawTCPMSS(fd uintptr) int {
+	mss, err := syscall.GetsockoptInt(int(fd), syscall.IPPROTO_TCP, syscall.TCP_MAXSEG)
+	if err != nil {
+		return 0
+	}
+	return mss
+}
@@ -0,0 +1,8 @@
+//+build !linux
+
+package chshare
+
+// getRawTCPMSS is not implemented on this platform; the default write sizes are used
+func getRawTCPMSS(fd uintptr) int {
+	return 0
+}
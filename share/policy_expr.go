@@ -0,0 +1,568 @@
+package chshare
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// PolicyExpr is a compiled policy expression: a small expression language over named
+// values, with string, number, boolean, list and map values, the operators ||, &&, !, ==,
+// !=, <, <=, >, >=, in and matches, indexing of maps and lists with [...], parentheses, list
+// literals [a, b, ...], and the functions hasPrefix(s, prefix), hasSuffix(s, suffix) and
+// contains(s, substring). "matches" takes a regular expression on its right. Lines starting
+// with "#" are comments.
+type PolicyExpr struct {
+	root policyNode
+}
+
+// policyNode is a node of a compiled policy expression
+type policyNode interface {
+	eval(env map[string]interface{}) (interface{}, error)
+}
+
+// CompilePolicyExpr compiles a policy expression
+func CompilePolicyExpr(src string) (*PolicyExpr, error) {
+	lines := strings.Split(src, "\n")
+	for i, line := range lines {
+		if strings.HasPrefix(strings.TrimSpace(line), "#") {
+			lines[i] = ""
+		}
+	}
+	tokens, err := lexPolicyExpr(strings.Join(lines, "\n"))
+	if err != nil {
+		return nil, err
+	}
+	p := &policyParser{tokens: tokens}
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.peek().kind != policyTokenEOF {
+		return nil, fmt.Errorf("unexpected '%s'", p.peek().text)
+	}
+	return &PolicyExpr{root: root}, nil
+}
+
+// Eval evaluates the expression with the given named values, which must be strings,
+// float64s, bools, []interface{}s or map[string]string values
+func (e *PolicyExpr) Eval(env map[string]interface{}) (interface{}, error) {
+	return e.root.eval(env)
+}
+
+// EvalBool evaluates the expression, which must result in a boolean
+func (e *PolicyExpr) EvalBool(env map[string]interface{}) (bool, error) {
+	v, err := e.Eval(env)
+	if err != nil {
+		return false, err
+	}
+	b, ok := v.(bool)
+	if !ok {
+		return false, fmt.Errorf("expression is %s, not a boolean", policyTypeName(v))
+	}
+	return b, nil
+}
+
+type policyTokenKind int
+
+const (
+	policyTokenEOF policyTokenKind = iota
+	policyTokenIdent
+	policyTokenString
+	policyTokenNumber
+	policyTokenOp
+)
+
+type policyToken struct {
+	kind policyTokenKind
+	text string
+}
+
+// policyOps are the operators and punctuation of the language, longest first
+var policyOps = []string{"||", "&&", "==", "!=", "<=", ">=", "!", "<", ">", "(", ")", "[", "]", ","}
+
+func lexPolicyExpr(s string) ([]policyToken, error) {
+	var tokens []policyToken
+	i := 0
+	for i < len(s) {
+		c := rune(s[i])
+		switch {
+		case unicode.IsSpace(c):
+			i++
+		case c == '"':
+			j := i + 1
+			for j < len(s) && s[j] != '"' {
+				if s[j] == '\\' {
+					j++
+				}
+				j++
+			}
+			if j >= len(s) {
+				return nil, fmt.Errorf("unterminated string")
+			}
+			str, err := strconv.Unquote(s[i : j+1])
+			if err != nil {
+				return nil, fmt.Errorf("invalid string %s", s[i:j+1])
+			}
+			tokens = append(tokens, policyToken{policyTokenString, str})
+			i = j + 1
+		case unicode.IsDigit(c):
+			j := i
+			for j < len(s) && (unicode.IsDigit(rune(s[j])) || s[j] == '.') {
+				j++
+			}
+			tokens = append(tokens, policyToken{policyTokenNumber, s[i:j]})
+			i = j
+		case unicode.IsLetter(c) || c == '_':
+			j := i
+			for j < len(s) && (unicode.IsLetter(rune(s[j])) || unicode.IsDigit(rune(s[j])) || s[j] == '_') {
+				j++
+			}
+			tokens = append(tokens, policyToken{policyTokenIdent, s[i:j]})
+			i = j
+		default:
+			op := ""
+			for _, candidate := range policyOps {
+				if strings.HasPrefix(s[i:], candidate) {
+					op = candidate
+					break
+				}
+			}
+			if op == "" {
+				return nil, fmt.Errorf("unexpected character '%c'", c)
+			}
+			tokens = append(tokens, policyToken{policyTokenOp, op})
+			i += len(op)
+		}
+	}
+	return append(tokens, policyToken{kind: policyTokenEOF, text: "end of expression"}), nil
+}
+
+type policyParser struct {
+	tokens []policyToken
+	pos    int
+}
+
+func (p *policyParser) peek() policyToken {
+	return p.tokens[p.pos]
+}
+
+func (p *policyParser) next() policyToken {
+	t := p.tokens[p.pos]
+	if t.kind != policyTokenEOF {
+		p.pos++
+	}
+	return t
+}
+
+// accept consumes the next token if it is the given operator or keyword
+func (p *policyParser) accept(text string) bool {
+	t := p.peek()
+	if (t.kind == policyTokenOp || t.kind == policyTokenIdent) && t.text == text {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *policyParser) expect(text string) error {
+	if !p.accept(text) {
+		return fmt.Errorf("expected '%s', found '%s'", text, p.peek().text)
+	}
+	return nil
+}
+
+func (p *policyParser) parseOr() (policyNode, error) {
+	left, err := p.parseAnd()
+	for err == nil && p.accept("||") {
+		var right policyNode
+		right, err = p.parseAnd()
+		left = &policyLogicNode{and: false, left: left, right: right}
+	}
+	return left, err
+}
+
+func (p *policyParser) parseAnd() (policyNode, error) {
+	left, err := p.parseNot()
+	for err == nil && p.accept("&&") {
+		var right policyNode
+		right, err = p.parseNot()
+		left = &policyLogicNode{and: true, left: left, right: right}
+	}
+	return left, err
+}
+
+func (p *policyParser) parseNot() (policyNode, error) {
+	if p.accept("!") {
+		operand, err := p.parseNot()
+		return &policyNotNode{operand: operand}, err
+	}
+	return p.parseComparison()
+}
+
+func (p *policyParser) parseComparison() (policyNode, error) {
+	left, err := p.parsePostfix()
+	if err != nil {
+		return nil, err
+	}
+	for _, op := range []string{"==", "!=", "<=", ">=", "<", ">", "in", "matches"} {
+		if !p.accept(op) {
+			continue
+		}
+		right, err := p.parsePostfix()
+		if err != nil {
+			return nil, err
+		}
+		if op == "matches" {
+			lit, ok := right.(*policyLiteralNode)
+			pattern, isString := lit.valueOrNil().(string)
+			if !ok || !isString {
+				return nil, fmt.Errorf("'matches' requires a string literal regular expression")
+			}
+			re, err := regexp.Compile(pattern)
+			if err != nil {
+				return nil, fmt.Errorf("invalid regular expression \"%s\": %s", pattern, err)
+			}
+			return &policyMatchNode{left: left, re: re}, nil
+		}
+		return &policyCompareNode{op: op, left: left, right: right}, nil
+	}
+	return left, nil
+}
+
+func (p *policyParser) parsePostfix() (policyNode, error) {
+	node, err := p.parsePrimary()
+	for err == nil && p.accept("[") {
+		var index policyNode
+		index, err = p.parseOr()
+		if err == nil {
+			err = p.expect("]")
+		}
+		node = &policyIndexNode{target: node, index: index}
+	}
+	return node, err
+}
+
+func (p *policyParser) parseList(end string) ([]policyNode, error) {
+	var items []policyNode
+	if p.accept(end) {
+		return items, nil
+	}
+	for {
+		item, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+		if p.accept(end) {
+			return items, nil
+		}
+		if err := p.expect(","); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (p *policyParser) parsePrimary() (policyNode, error) {
+	t := p.next()
+	switch t.kind {
+	case policyTokenString:
+		return &policyLiteralNode{value: t.text}, nil
+	case policyTokenNumber:
+		f, err := strconv.ParseFloat(t.text, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number '%s'", t.text)
+		}
+		return &policyLiteralNode{value: f}, nil
+	case policyTokenIdent:
+		switch t.text {
+		case "true":
+			return &policyLiteralNode{value: true}, nil
+		case "false":
+			return &policyLiteralNode{value: false}, nil
+		case "in", "matches":
+			return nil, fmt.Errorf("unexpected '%s'", t.text)
+		}
+		if p.accept("(") {
+			fn, ok := policyFuncs[t.text]
+			if !ok {
+				return nil, fmt.Errorf("unknown function '%s'", t.text)
+			}
+			args, err := p.parseList(")")
+			if err != nil {
+				return nil, err
+			}
+			if len(args) != 2 {
+				return nil, fmt.Errorf("%s() takes 2 arguments", t.text)
+			}
+			return &policyCallNode{name: t.text, fn: fn, args: args}, nil
+		}
+		return &policyNameNode{name: t.text}, nil
+	case policyTokenOp:
+		switch t.text {
+		case "(":
+			node, err := p.parseOr()
+			if err == nil {
+				err = p.expect(")")
+			}
+			return node, err
+		case "[":
+			items, err := p.parseList("]")
+			return &policyListNode{items: items}, err
+		}
+	}
+	return nil, fmt.Errorf("unexpected '%s'", t.text)
+}
+
+// policyFuncs are the functions available to policy expressions
+var policyFuncs = map[string]func(a, b string) bool{
+	"hasPrefix": strings.HasPrefix,
+	"hasSuffix": strings.HasSuffix,
+	"contains":  strings.Contains,
+}
+
+func policyTypeName(v interface{}) string {
+	switch v.(type) {
+	case string:
+		return "a string"
+	case float64:
+		return "a number"
+	case bool:
+		return "a boolean"
+	case []interface{}:
+		return "a list"
+	case map[string]string:
+		return "a map"
+	}
+	return fmt.Sprintf("%T", v)
+}
+
+type policyLiteralNode struct {
+	value interface{}
+}
+
+func (n *policyLiteralNode) valueOrNil() interface{} {
+	if n == nil {
+		return nil
+	}
+	return n.value
+}
+
+func (n *policyLiteralNode) eval(env map[string]interface{}) (interface{}, error) {
+	return n.value, nil
+}
+
+type policyNameNode struct {
+	name string
+}
+
+func (n *policyNameNode) eval(env map[string]interface{}) (interface{}, error) {
+	v, ok := env[n.name]
+	if !ok {
+		return nil, fmt.Errorf("unknown name '%s'", n.name)
+	}
+	return v, nil
+}
+
+type policyListNode struct {
+	items []policyNode
+}
+
+func (n *policyListNode) eval(env map[string]interface{}) (interface{}, error) {
+	result := make([]interface{}, len(n.items))
+	for i, item := range n.items {
+		v, err := item.eval(env)
+		if err != nil {
+			return nil, err
+		}
+		result[i] = v
+	}
+	return result, nil
+}
+
+type policyIndexNode struct {
+	target policyNode
+	index  policyNode
+}
+
+// eval returns a map's value for a key, "" if it has none, or a list's item
+func (n *policyIndexNode) eval(env map[string]interface{}) (interface{}, error) {
+	target, err := n.target.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	index, err := n.index.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	switch t := target.(type) {
+	case map[string]string:
+		key, ok := index.(string)
+		if !ok {
+			return nil, fmt.Errorf("map index is %s, not a string", policyTypeName(index))
+		}
+		return t[key], nil
+	case []interface{}:
+		f, ok := index.(float64)
+		if !ok || f < 0 || int(f) >= len(t) || f != float64(int(f)) {
+			return nil, fmt.Errorf("invalid list index %v", index)
+		}
+		return t[int(f)], nil
+	}
+	return nil, fmt.Errorf("cannot index %s", policyTypeName(target))
+}
+
+type policyCallNode struct {
+	name string
+	fn   func(a, b string) bool
+	args []policyNode
+}
+
+func (n *policyCallNode) eval(env map[string]interface{}) (interface{}, error) {
+	var strs [2]string
+	for i, arg := range n.args {
+		v, err := arg.eval(env)
+		if err != nil {
+			return nil, err
+		}
+		s, ok := v.(string)
+		if !ok {
+			return nil, fmt.Errorf("%s() argument is %s, not a string", n.name, policyTypeName(v))
+		}
+		strs[i] = s
+	}
+	return n.fn(strs[0], strs[1]), nil
+}
+
+type policyNotNode struct {
+	operand policyNode
+}
+
+func (n *policyNotNode) eval(env map[string]interface{}) (interface{}, error) {
+	v, err := n.operand.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	b, ok := v.(bool)
+	if !ok {
+		return nil, fmt.Errorf("'!' operand is %s, not a boolean", policyTypeName(v))
+	}
+	return !b, nil
+}
+
+// policyLogicNode is a short-circuiting && or ||
+type policyLogicNode struct {
+	and         bool
+	left, right policyNode
+}
+
+func (n *policyLogicNode) eval(env map[string]interface{}) (interface{}, error) {
+	for i, operand := range []policyNode{n.left, n.right} {
+		v, err := operand.eval(env)
+		if err != nil {
+			return nil, err
+		}
+		b, ok := v.(bool)
+		if !ok {
+			return nil, fmt.Errorf("logical operand is %s, not a boolean", policyTypeName(v))
+		}
+		if i == 0 && b != n.and {
+			return b, nil
+		}
+		if i == 1 {
+			return b, nil
+		}
+	}
+	return nil, nil
+}
+
+type policyMatchNode struct {
+	left policyNode
+	re   *regexp.Regexp
+}
+
+func (n *policyMatchNode) eval(env map[string]interface{}) (interface{}, error) {
+	v, err := n.left.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	s, ok := v.(string)
+	if !ok {
+		return nil, fmt.Errorf("'matches' operand is %s, not a string", policyTypeName(v))
+	}
+	return n.re.MatchString(s), nil
+}
+
+type policyCompareNode struct {
+	op          string
+	left, right policyNode
+}
+
+func (n *policyCompareNode) eval(env map[string]interface{}) (interface{}, error) {
+	left, err := n.left.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	right, err := n.right.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	switch n.op {
+	case "==":
+		return policyEqual(left, right), nil
+	case "!=":
+		return !policyEqual(left, right), nil
+	case "in":
+		switch r := right.(type) {
+		case []interface{}:
+			for _, item := range r {
+				if policyEqual(left, item) {
+					return true, nil
+				}
+			}
+			return false, nil
+		case map[string]string:
+			key, ok := left.(string)
+			if !ok {
+				return nil, fmt.Errorf("'in' map key is %s, not a string", policyTypeName(left))
+			}
+			_, found := r[key]
+			return found, nil
+		}
+		return nil, fmt.Errorf("'in' right operand is %s, not a list or map", policyTypeName(right))
+	}
+	if l, ok := left.(float64); ok {
+		if r, ok := right.(float64); ok {
+			return policyOrdered(n.op, l < r, l == r), nil
+		}
+	}
+	if l, ok := left.(string); ok {
+		if r, ok := right.(string); ok {
+			return policyOrdered(n.op, l < r, l == r), nil
+		}
+	}
+	return nil, fmt.Errorf("cannot compare %s with %s using '%s'", policyTypeName(left), policyTypeName(right), n.op)
+}
+
+// policyEqual returns true if two scalar values are equal; values of different types are not
+func policyEqual(a, b interface{}) bool {
+	switch a.(type) {
+	case string, float64, bool:
+		return a == b
+	}
+	return false
+}
+
+func policyOrdered(op string, less bool, equal bool) bool {
+	switch op {
+	case "<":
+		return less
+	case "<=":
+		return less || equal
+	case ">":
+		return !less && !equal
+	}
+	return !less
+}
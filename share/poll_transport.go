@@ -0,0 +1,428 @@
+package chshare
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The HTTP long-poll transport carries a client session over ordinary HTTP requests, for
+// networks whose middleboxes break websockets. Every request has a PollProtocolHeader
+// holding ProtocolVersion and a PollActionHeader:
+//
+//	open:  POST; the response body is the new transport's ID
+//	send:  POST with the PollIDHeader; the body is data for the server. A client has at
+//	       most one send in flight, so data arrives in order.
+//	recv:  GET with the PollIDHeader; the response body is data for the client, and is
+//	       held back for up to pollRecvWait while there is none. 410 Gone means the
+//	       transport has closed.
+//	close: POST with the PollIDHeader
+const (
+	PollProtocolHeader = "X-Chisel-Protocol"
+	PollActionHeader   = "X-Chisel-Poll"
+	PollIDHeader       = "X-Chisel-Poll-Id"
+)
+
+const (
+	// pollRecvWait is how long the server holds a recv request while it has no data
+	pollRecvWait = 20 * time.Second
+
+	// pollIdleTimeout is how long a transport may go without any request before the server
+	// closes it
+	pollIdleTimeout = time.Minute
+
+	// pollMaxChunk is the largest amount of data carried by a single request or response
+	pollMaxChunk = 256 * 1024
+
+	// pollMaxBuffered is the amount of unsent data above which writes to a transport wait
+	pollMaxBuffered = 1024 * 1024
+)
+
+// pollBuffer holds data written to a long-poll transport until a request carries it
+type pollBuffer struct {
+	clock  Clock
+	lock   sync.Mutex
+	cond   *sync.Cond
+	data   []byte
+	closed bool
+
+	// done is closed when the buffer is closed
+	done chan struct{}
+}
+
+func newPollBuffer(clock Clock) *pollBuffer {
+	b := &pollBuffer{clock: clock, done: make(chan struct{})}
+	b.cond = sync.NewCond(&b.lock)
+	return b
+}
+
+// write appends p, waiting while too much data is already buffered
+func (b *pollBuffer) write(p []byte) (int, error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	for len(b.data) >= pollMaxBuffered && !b.closed {
+		b.cond.Wait()
+	}
+	if b.closed {
+		return 0, io.ErrClosedPipe
+	}
+	b.data = append(b.data, p...)
+	b.cond.Broadcast()
+	return len(p), nil
+}
+
+// take removes and returns up to pollMaxChunk bytes, waiting up to wait for there to be
+// some. It returns io.EOF once the buffer is closed and empty.
+func (b *pollBuffer) take(wait time.Duration) ([]byte, error) {
+	timer := b.clock.AfterFunc(wait, func() {
+		b.lock.Lock()
+		b.cond.Broadcast()
+		b.lock.Unlock()
+	})
+	defer timer.Stop()
+	deadline := b.clock.Now().Add(wait)
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	for len(b.data) == 0 && !b.closed && b.clock.Now().Before(deadline) {
+		b.cond.Wait()
+	}
+	if len(b.data) == 0 && b.closed {
+		return nil, io.EOF
+	}
+	n := len(b.data)
+	if n > pollMaxChunk {
+		n = pollMaxChunk
+	}
+	chunk := make([]byte, n)
+	copy(chunk, b.data)
+	b.data = b.data[n:]
+	b.cond.Broadcast()
+	return chunk, nil
+}
+
+// close stops further writes; data already buffered can still be taken
+func (b *pollBuffer) close() {
+	b.lock.Lock()
+	if !b.closed {
+		b.closed = true
+		close(b.done)
+	}
+	b.cond.Broadcast()
+	b.lock.Unlock()
+}
+
+// pollAddr is the net.Addr of either end of a long-poll transport
+type pollAddr string
+
+func (a pollAddr) Network() string { return "http-poll" }
+func (a pollAddr) String() string  { return string(a) }
+
+// pollConn is the net.Conn common to both ends of a long-poll transport. Data received is
+// written to inWriter, and data sent is buffered in out until a request carries it.
+type pollConn struct {
+	inReader  *io.PipeReader
+	inWriter  *io.PipeWriter
+	out       *pollBuffer
+	local     net.Addr
+	remote    net.Addr
+	closeOnce sync.Once
+	onClose   func()
+}
+
+func newPollConn(clock Clock, local, remote net.Addr, onClose func()) *pollConn {
+	c := &pollConn{out: newPollBuffer(clock), local: local, remote: remote, onClose: onClose}
+	c.inReader, c.inWriter = io.Pipe()
+	return c
+}
+
+func (c *pollConn) Read(p []byte) (int, error)  { return c.inReader.Read(p) }
+func (c *pollConn) Write(p []byte) (int, error) { return c.out.write(p) }
+
+// Close closes both directions of the transport
+func (c *pollConn) Close() error {
+	c.closeOnce.Do(func() {
+		c.out.close()
+		c.inWriter.Close()
+		c.inReader.Close()
+		if c.onClose != nil {
+			c.onClose()
+		}
+	})
+	return nil
+}
+
+func (c *pollConn) LocalAddr() net.Addr                { return c.local }
+func (c *pollConn) RemoteAddr() net.Addr               { return c.remote }
+func (c *pollConn) SetDeadline(t time.Time) error      { return nil }
+func (c *pollConn) SetReadDeadline(t time.Time) error  { return nil }
+func (c *pollConn) SetWriteDeadline(t time.Time) error { return nil }
+
+// pollServerTransport is the server's end of a long-poll transport
+type pollServerTransport struct {
+	*pollConn
+
+	// sendLock serializes send requests, in case a client sends more than one at a time
+	sendLock sync.Mutex
+
+	// lock protects inFlight and lastSeen
+	lock     sync.Mutex
+	inFlight int
+	lastSeen time.Time
+}
+
+// touch records the start (delta 1) or end (delta -1) of a request
+func (t *pollServerTransport) touch(delta int) {
+	t.lock.Lock()
+	t.inFlight += delta
+	t.lastSeen = t.out.clock.Now()
+	t.lock.Unlock()
+}
+
+// idle returns true if the transport has had no requests for pollIdleTimeout
+func (t *pollServerTransport) idle() bool {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	return t.inFlight == 0 && t.out.clock.Now().Sub(t.lastSeen) > pollIdleTimeout
+}
+
+// PollServer accepts long-poll transports on behalf of a chisel server
+type PollServer struct {
+	Logger
+	clock      Clock
+	lock       sync.Mutex
+	transports map[string]*pollServerTransport
+
+	// accept is called with each new transport and the request that opened it, in its own
+	// goroutine, and closes the transport when the session on it ends
+	accept func(conn net.Conn, r *http.Request)
+}
+
+// NewPollServer creates a PollServer that passes each new transport to accept, and measures
+// idle transports with clock
+func NewPollServer(logger Logger, clock Clock, accept func(conn net.Conn, r *http.Request)) *PollServer {
+	return &PollServer{
+		Logger:     logger.Fork("poll"),
+		clock:      clock,
+		transports: make(map[string]*pollServerTransport),
+		accept:     accept,
+	}
+}
+
+// IsPollRequest returns true if r is a long-poll transport request, of any protocol version
+func IsPollRequest(r *http.Request) bool {
+	return r.Header.Get(PollActionHeader) != ""
+}
+
+// Handle handles a long-poll transport request. It returns false if the request did not
+// name an open transport, so that the caller can answer it like any unknown request.
+func (p *PollServer) Handle(w http.ResponseWriter, r *http.Request) bool {
+	action := r.Header.Get(PollActionHeader)
+	if action == "open" && r.Method == http.MethodPost {
+		p.open(w, r)
+		return true
+	}
+	p.lock.Lock()
+	t, ok := p.transports[r.Header.Get(PollIDHeader)]
+	p.lock.Unlock()
+	if !ok {
+		return false
+	}
+	t.touch(1)
+	defer t.touch(-1)
+	switch {
+	case action == "send" && r.Method == http.MethodPost:
+		t.sendLock.Lock()
+		_, err := io.Copy(t.inWriter, r.Body)
+		t.sendLock.Unlock()
+		if err != nil {
+			http.Error(w, "Gone", http.StatusGone)
+			return true
+		}
+		w.WriteHeader(http.StatusNoContent)
+	case action == "recv" && r.Method == http.MethodGet:
+		data, err := t.out.take(pollRecvWait)
+		if err != nil {
+			http.Error(w, "Gone", http.StatusGone)
+			return true
+		}
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Header().Set("Cache-Control", "no-store")
+		w.Write(data)
+	case action == "close" && r.Method == http.MethodPost:
+		t.Close()
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		return false
+	}
+	return true
+}
+
+// open creates a new transport
+func (p *PollServer) open(w http.ResponseWriter, r *http.Request) {
+	var idBytes [16]byte
+	if _, err := rand.Read(idBytes[:]); err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	id := hex.EncodeToString(idBytes[:])
+	t := &pollServerTransport{lastSeen: p.clock.Now()}
+	t.pollConn = newPollConn(p.clock, pollAddr(r.Host), pollAddr(r.RemoteAddr), func() {
+		p.lock.Lock()
+		delete(p.transports, id)
+		p.lock.Unlock()
+	})
+	p.lock.Lock()
+	p.transports[id] = t
+	p.lock.Unlock()
+	p.DLogf("Opened long-poll transport from %s", r.RemoteAddr)
+	go p.reapWhenIdle(t)
+	go p.accept(t, r)
+	w.Header().Set("Cache-Control", "no-store")
+	w.Write([]byte(id))
+}
+
+// reapWhenIdle closes a transport once its client stops polling it
+func (p *PollServer) reapWhenIdle(t *pollServerTransport) {
+	for {
+		select {
+		case <-t.out.done:
+			return
+		case <-p.clock.After(pollIdleTimeout / 4):
+		}
+		if t.idle() {
+			p.DLogf("Closing idle long-poll transport from %s", t.remote)
+			t.Close()
+			return
+		}
+	}
+}
+
+// pollClientTransport is the client's end of a long-poll transport
+type pollClientTransport struct {
+	*pollConn
+	httpClient *http.Client
+	url        string
+	header     http.Header
+	id         string
+}
+
+// DialPollTransport opens a long-poll transport to a chisel server at an http or https
+// URL. header is added to every request, proxyURL, if not nil, is an HTTP proxy to send
+// requests through, tlsConfig, if not nil, configures https connections, and clock measures
+// the wait for data to send.
+func DialPollTransport(clock Clock, serverURL string, header http.Header, proxyURL *url.URL, tlsConfig *tls.Config) (net.Conn, error) {
+	httpTransport := &http.Transport{
+		// one connection for sends and one for the pending recv
+		MaxIdleConnsPerHost: 2,
+		IdleConnTimeout:     pollIdleTimeout,
+		TLSClientConfig:     tlsConfig,
+	}
+	if proxyURL != nil {
+		httpTransport.Proxy = http.ProxyURL(proxyURL)
+	}
+	t := &pollClientTransport{
+		httpClient: &http.Client{Transport: httpTransport, Timeout: pollRecvWait + 30*time.Second},
+		url:        serverURL,
+		header:     header,
+	}
+	resp, err := t.do(http.MethodPost, "open", nil)
+	if err != nil {
+		return nil, err
+	}
+	id, err := ioutil.ReadAll(io.LimitReader(resp.Body, 64))
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	t.id = string(id)
+	u, _ := url.Parse(serverURL)
+	t.pollConn = newPollConn(clock, pollAddr("client"), pollAddr(u.Host), func() {
+		go func() {
+			//best effort, so that the server need not wait for the transport to go idle
+			resp, err := t.do(http.MethodPost, "close", nil)
+			if err == nil {
+				resp.Body.Close()
+			}
+			httpTransport.CloseIdleConnections()
+		}()
+	})
+	go t.sendLoop()
+	go t.recvLoop()
+	return t, nil
+}
+
+// do makes a request for an action, and returns the response if it succeeded
+func (t *pollClientTransport) do(method, action string, body []byte) (*http.Response, error) {
+	req, err := http.NewRequest(method, t.url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	for name, values := range t.header {
+		if strings.EqualFold(name, "Host") {
+			req.Host = values[0]
+			continue
+		}
+		req.Header[name] = values
+	}
+	req.Header.Set(PollProtocolHeader, ProtocolVersion)
+	req.Header.Set(PollActionHeader, action)
+	if t.id != "" {
+		req.Header.Set(PollIDHeader, t.id)
+	}
+	resp, err := t.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 != 2 {
+		statusErr := newHTTPStatusError(resp)
+		resp.Body.Close()
+		return nil, fmt.Errorf("Long-poll %s failed: %w", action, statusErr)
+	}
+	return resp, nil
+}
+
+// sendLoop sends written data to the server, one request at a time
+func (t *pollClientTransport) sendLoop() {
+	for {
+		data, err := t.out.take(pollRecvWait)
+		if err != nil {
+			return
+		}
+		if len(data) == 0 {
+			continue
+		}
+		resp, err := t.do(http.MethodPost, "send", data)
+		if err != nil {
+			t.inWriter.CloseWithError(err)
+			t.Close()
+			return
+		}
+		resp.Body.Close()
+	}
+}
+
+// recvLoop polls the server for data, and passes it to the reader
+func (t *pollClientTransport) recvLoop() {
+	for {
+		resp, err := t.do(http.MethodGet, "recv", nil)
+		if err == nil {
+			_, err = io.Copy(t.inWriter, resp.Body)
+			resp.Body.Close()
+		}
+		if err != nil {
+			t.inWriter.CloseWithError(err)
+			t.Close()
+			return
+		}
+	}
+}
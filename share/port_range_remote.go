@@ -0,0 +1,176 @@
+package chshare
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+)
+
+// portRangeRemoteRegexp matches the base of a port range remote string, without its options:
+//
+//    R:[<local-interface>:]<first-port>-<last-port>:<remote-host>:<remote-port>|<same>
+var portRangeRemoteRegexp = regexp.MustCompile(`^R:(?:(.+?):)?(\d+)-(\d+):(.+):(<same>|\d+)$`)
+
+// PortRangeRemote is a reverse remote that grants a range of server ports, of which only those
+// the client activates are bound, so that a large range costs nothing until it is used. An
+// active port is added to the session as an ordinary reverse remote, with a dynamic channels
+// request, and is subject to the server's access list like any other.
+type PortRangeRemote struct {
+	// BindAddr is the local interface of the server's listeners, or "" for the default
+	BindAddr string
+
+	// First and Last are the first and last ports of the range
+	First PortNumber
+	Last  PortNumber
+
+	// TargetHost is the host the client connects to. TargetPort is the port it connects
+	// to, or UnknownPortNumber to connect to the same port as the server listens on.
+	TargetHost string
+	TargetPort PortNumber
+
+	// options is the options suffix of the remote string, including the '?', if any
+	options string
+}
+
+// IsPortRangeRemote returns true if s, with its variables expanded, has the form of a port
+// range remote
+func IsPortRangeRemote(s string) bool {
+	base, _, err := SplitDescriptorOptions(s)
+	return err == nil && portRangeRemoteRegexp.MatchString(base)
+}
+
+// ParsePortRangeRemote parses a port range remote string, e.g.
+// "R:20000-20100:localhost:<same>"
+func ParsePortRangeRemote(s string) (*PortRangeRemote, error) {
+	base, _, err := SplitDescriptorOptions(s)
+	if err != nil {
+		return nil, err
+	}
+	m := portRangeRemoteRegexp.FindStringSubmatch(base)
+	if m == nil {
+		return nil, fmt.Errorf("Invalid port range remote '%s'", s)
+	}
+	r := &PortRangeRemote{
+		BindAddr:   m[1],
+		TargetHost: m[4],
+		options:    s[len(base):],
+	}
+	if r.First, err = ParsePortNumber(m[2]); err != nil {
+		return nil, fmt.Errorf("Invalid port range remote '%s': %s", s, err)
+	}
+	if r.Last, err = ParsePortNumber(m[3]); err != nil {
+		return nil, fmt.Errorf("Invalid port range remote '%s': %s", s, err)
+	}
+	if r.Last < r.First {
+		return nil, fmt.Errorf("Invalid port range remote '%s': the last port is before the first", s)
+	}
+	if m[5] != "<same>" {
+		if r.TargetPort, err = ParsePortNumber(m[5]); err != nil {
+			return nil, fmt.Errorf("Invalid port range remote '%s': %s", s, err)
+		}
+	}
+	//the range is only valid if each of its remotes is, and they differ only in their ports
+	if _, err := r.Descriptor(r.First); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// String returns the remote string of the range, without its options
+func (r *PortRangeRemote) String() string {
+	target := "<same>"
+	if r.TargetPort != UnknownPortNumber {
+		target = r.TargetPort.String()
+	}
+	if r.BindAddr == "" {
+		return fmt.Sprintf("R:%d-%d:%s:%s", r.First, r.Last, r.TargetHost, target)
+	}
+	return fmt.Sprintf("R:%s:%d-%d:%s:%s", r.BindAddr, r.First, r.Last, r.TargetHost, target)
+}
+
+// Contains returns true if port is in the range
+func (r *PortRangeRemote) Contains(port PortNumber) bool {
+	return port >= r.First && port <= r.Last
+}
+
+// Descriptor returns the descriptor of the reverse remote that activates port in the range
+func (r *PortRangeRemote) Descriptor(port PortNumber) (*ChannelDescriptor, error) {
+	if !r.Contains(port) {
+		return nil, fmt.Errorf("Port %d is not in the range %s", port, r.String())
+	}
+	targetPort := r.TargetPort
+	if targetPort == UnknownPortNumber {
+		targetPort = port
+	}
+	s := fmt.Sprintf("R:%d:%s:%d%s", port, r.TargetHost, targetPort, r.options)
+	if r.BindAddr != "" {
+		s = fmt.Sprintf("R:%s:%d:%s:%d%s", r.BindAddr, port, r.TargetHost, targetPort, r.options)
+	}
+	chd, err := ParseChannelDescriptor(s)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to parse channel descriptor string '%s': %s", s, err)
+	}
+	return chd, nil
+}
+
+// portRangeFor returns the client's port range remote that contains port, or nil if there is
+// none
+func (c *Client) portRangeFor(port PortNumber) *PortRangeRemote {
+	for _, r := range c.portRanges {
+		if r.Contains(port) {
+			return r
+		}
+	}
+	return nil
+}
+
+// parseRangePort parses the port argument of ActivatePort or DeactivatePort, and returns the
+// descriptor of the remote that activates it
+func (c *Client) parseRangePort(portString string) (*ChannelDescriptor, error) {
+	port, err := ParsePortNumber(portString)
+	if err != nil {
+		return nil, err
+	}
+	r := c.portRangeFor(port)
+	if r == nil {
+		return nil, fmt.Errorf("Port %d is not in any of the client's port range remotes", port)
+	}
+	return r.Descriptor(port)
+}
+
+// ActivatePort has the server bind a port of one of the client's port range remotes, by
+// adding the remote for that port to the running session
+func (c *Client) ActivatePort(ctx context.Context, portString string) error {
+	chd, err := c.parseRangePort(portString)
+	if err != nil {
+		return err
+	}
+	key := chd.String()
+
+	c.remotesLock.Lock()
+	defer c.remotesLock.Unlock()
+
+	if _, ok := c.dynamicRemotes[key]; ok || c.isStaticRemote(key) {
+		return fmt.Errorf("Port %s is already active: %s", portString, key)
+	}
+	return c.updateRemotes(ctx, []*ChannelDescriptor{chd}, nil, RemoteSourceRange)
+}
+
+// DeactivatePort has the server stop listening on a port of one of the client's port range
+// remotes that was activated with ActivatePort
+func (c *Client) DeactivatePort(ctx context.Context, portString string) error {
+	chd, err := c.parseRangePort(portString)
+	if err != nil {
+		return err
+	}
+	key := chd.String()
+
+	c.remotesLock.Lock()
+	defer c.remotesLock.Unlock()
+
+	r, ok := c.dynamicRemotes[key]
+	if !ok || r.source != RemoteSourceRange {
+		return fmt.Errorf("Port %s is not active", portString)
+	}
+	return c.updateRemotes(ctx, nil, []*ChannelDescriptor{r.chd}, r.source)
+}
@@ -0,0 +1,184 @@
+package chshare
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// provisionedUserEntry is the saved state of a provisioned user in a provisioning file
+type provisionedUserEntry struct {
+	Addrs   []string `json:"addrs"`
+	Pending bool     `json:"pending"`
+}
+
+// authEnabled returns true if clients must authenticate as a user
+func (s *Server) authEnabled() bool {
+	return s.users.Len() > 0 || s.provision
+}
+
+// sshConfigFor returns the SSH server configuration for a session of a tenant (see tenantFor)
+// whose client presented a verified TLS client certificate with the common name
+// clientCertName, or "" if it did not. A client with a certificate may authenticate as the
+// user named by the certificate without a password, if that user allows it or is provisioned
+// on the spot.
+func (s *Server) sshConfigFor(tenant string, users *UserIndex, clientCertName string) *ssh.ServerConfig {
+	if tenant == "" && clientCertName == "" {
+		return s.sshConfig
+	}
+	config := *s.sshConfig
+	config.PasswordCallback = func(c ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+		if clientCertName != "" && c.User() == clientCertName {
+			if user := s.certificateUser(users, clientCertName); user != nil {
+				s.DLogf("Login for user %s from %s using TLS client certificate", user.Name, c.RemoteAddr())
+				s.sessions.Set(string(c.SessionID()), user)
+				return nil, nil
+			}
+		}
+		return s.authTenantUser(tenant, users, c, password)
+	}
+	return &config
+}
+
+// certificateUser returns the user in users that a verified TLS client certificate with the
+// common name name authenticates, or nil if there is none. If there is no user with that name
+// and provisioning is enabled, a new user is created with the provisioning ACL, pending
+// approval. Users are only provisioned for the server's own users, not for tenants.
+func (s *Server) certificateUser(users *UserIndex, name string) *User {
+	s.provisionLock.Lock()
+	defer s.provisionLock.Unlock()
+	if user, found := users.Get(name); found {
+		if !user.CertAuth {
+			return nil
+		}
+		return user
+	}
+	if !s.provision || users != s.users {
+		return nil
+	}
+	user := &User{
+		Name:     name,
+		Addrs:    s.provisionAddrs,
+		CertAuth: true,
+		Pending:  true,
+	}
+	s.users.AddUser(user)
+	s.provisionedUsers[name] = struct{}{}
+	s.provisionedUsersStat.Inc()
+	s.ILogf("Provisioned user \"%s\" from TLS client certificate; pending approval", name)
+	s.saveProvisionedUsers()
+	return user
+}
+
+// ApproveUser approves a user provisioned from a TLS client certificate that is pending
+// approval, replacing its access list with addrs unless addrs is empty
+func (s *Server) ApproveUser(name string, addrs []string) error {
+	s.provisionLock.Lock()
+	user, found := s.users.Get(name)
+	if !found {
+		s.provisionLock.Unlock()
+		return fmt.Errorf("No user named \"%s\"", name)
+	}
+	if !user.Pending {
+		s.provisionLock.Unlock()
+		return fmt.Errorf("User \"%s\" is not pending approval", name)
+	}
+	updated := *user
+	updated.Pending = false
+	if len(addrs) > 0 {
+		res, err := ParseUserAddrs(addrs)
+		if err != nil {
+			s.provisionLock.Unlock()
+			return err
+		}
+		updated.Addrs = res
+	}
+	s.users.AddUser(&updated)
+	s.provisionLock.Unlock()
+	s.users.Changed()
+	s.ILogf("Approved user \"%s\"", name)
+	return nil
+}
+
+// loadProvisionedUsers adds the users saved in the provisioning file, if it exists, to the
+// server's users. A user of the same name from the auth file takes precedence.
+func (s *Server) loadProvisionedUsers() error {
+	data, err := ioutil.ReadFile(s.provisionFile)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("Unable to read provisioning file: %s", err)
+	}
+	var entries map[string]*provisionedUserEntry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return fmt.Errorf("Invalid provisioning file %s: %s", s.provisionFile, err)
+	}
+	s.provisionLock.Lock()
+	defer s.provisionLock.Unlock()
+	for name, entry := range entries {
+		if _, found := s.users.Get(name); found {
+			continue
+		}
+		addrs, err := ParseUserAddrs(entry.Addrs)
+		if err != nil {
+			return fmt.Errorf("Invalid provisioning file %s: user \"%s\": %s", s.provisionFile, name, err)
+		}
+		s.users.AddUser(&User{
+			Name:     name,
+			Addrs:    addrs,
+			CertAuth: true,
+			Pending:  entry.Pending,
+		})
+		s.provisionedUsers[name] = struct{}{}
+	}
+	if len(s.provisionedUsers) > 0 {
+		s.ILogf("Loaded %d provisioned users from %s", len(s.provisionedUsers), s.provisionFile)
+	}
+	return nil
+}
+
+// provisionedUsersChanged saves the provisioned users after a change to the server's users,
+// such as an approval, an update or deletion through the admin API, or an auth file reload
+func (s *Server) provisionedUsersChanged() {
+	s.provisionLock.Lock()
+	defer s.provisionLock.Unlock()
+	s.saveProvisionedUsers()
+}
+
+// saveProvisionedUsers writes the provisioned users that still exist, and have not been
+// replaced by users of the same name in the auth file, to the provisioning file, if there is
+// one. provisionLock must be held.
+func (s *Server) saveProvisionedUsers() {
+	if s.provisionFile == "" {
+		return
+	}
+	entries := make(map[string]*provisionedUserEntry, len(s.provisionedUsers))
+	for name := range s.provisionedUsers {
+		user, found := s.users.Get(name)
+		if !found || s.users.IsFileUser(name) {
+			delete(s.provisionedUsers, name)
+			continue
+		}
+		addrs := make([]string, len(user.Addrs))
+		for i, addr := range user.Addrs {
+			addrs[i] = addr.String()
+		}
+		entries[name] = &provisionedUserEntry{Addrs: addrs, Pending: user.Pending}
+	}
+	var data bytes.Buffer
+	enc := json.NewEncoder(&data)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(entries); err != nil {
+		s.ILogf("Unable to save provisioning file: %s", err)
+		return
+	}
+	if err := replaceFile(s.provisionFile, data.Bytes()); err != nil {
+		s.ILogf("Unable to save provisioning file: %s", err)
+	}
+}
@@ -3,10 +3,34 @@ package chshare
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"github.com/jpillora/backoff"
 	"golang.org/x/crypto/ssh"
+	"net"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
 )
 
+// listenerRetryMaxDelay is the longest delay between attempts to accept on, or re-create, a
+// failed stub listener
+const listenerRetryMaxDelay = 30 * time.Second
+
+// reconnectQueuePollInterval is how often a queued connection checks whether a lost
+// connection to the remote proxy has been replaced
+const reconnectQueuePollInterval = 100 * time.Millisecond
+
+// lastListenerStatID numbers TCPProxy listeners, to label their per-listener metrics.
+// Accessed atomically.
+var lastListenerStatID int64
+
+// errConnectionLost is the failure to open a channel because the connection to the remote
+// proxy was lost
+var errConnectionLost = errors.New("Connection to remote proxy lost")
+
 // GetSSHConn is a callback that is used to defer fetching of the ssh.Conn
 // until after it is established
 type GetSSHConn func() ssh.Conn
@@ -20,7 +44,47 @@ type TCPProxy struct {
 	strname         string
 	count           int
 	chd             *ChannelDescriptor
-	ep              LocalStubChannelEndpoint
+
+	// activeConns is the number of accepted connections currently being served. Accessed
+	// atomically.
+	activeConns int32
+
+	// throttle limits the rate at which connections are accepted, or is nil for no limit
+	throttle *AcceptThrottle
+
+	// draining is non-zero once the proxy refuses new connections so that it can be removed
+	// when its open ones finish. Accessed atomically.
+	draining int32
+
+	// epLock protects ep, which is replaced if its listener fails
+	epLock sync.Mutex
+	ep     LocalStubChannelEndpoint
+
+	// adopted, if not nil, is a listener handed off by another proxy, for the first
+	// listen to use
+	adopted net.Listener
+
+	// handedOff is closed when the proxy hands its listener off to another proxy, and
+	// acceptDone is closed when the accept loop has stopped
+	handedOffOnce sync.Once
+	handedOff     chan struct{}
+	acceptDone    chan struct{}
+
+	// Per-descriptor metrics, shared with any other TCPProxy serving the same descriptor,
+	// and released when the proxy shuts down
+	acceptsStat      *Stat
+	acceptErrorsStat *Stat
+	activeConnsStat  *Stat
+	restartsStat     *Stat
+	localConnsStat   *Stat
+	throttledStat    *Stat
+	tlsRejectedStat  *Stat
+	queuedStat       *Stat
+	queueTimeoutStat *Stat
+
+	// listenerUpStat is labelled with the proxy's own listener, since whether one proxy is
+	// listening says nothing about another serving the same descriptor
+	listenerUpStat *Stat
 }
 
 // NewTCPProxy creates a new TCPProxy
@@ -33,11 +97,87 @@ func NewTCPProxy(logger Logger, localChannelEnv LocalChannelEnv, index int, chd
 		id:              id,
 		strname:         strname,
 		chd:             chd,
+		throttle:        NewAcceptThrottle(localChannelEnv.GetClock(), chd.Stub),
+		handedOff:       make(chan struct{}),
+		acceptDone:      make(chan struct{}),
 	}
 	p.InitShutdownHelper(myLogger, p)
+	p.initStats()
 	return p
 }
 
+// initStats registers this proxy's metrics with the local StatsRegistry. Metrics are
+// labelled with the channel descriptor so operators can see which listeners are in use.
+// They are released by releaseStats.
+func (p *TCPProxy) initStats() {
+	stats := p.localChannelEnv.GetStatsRegistry()
+	labels := StatLabels{"descriptor": p.chd.String()}
+	p.acceptsStat = stats.Counter(
+		"chisel_stub_accepts_total",
+		"Number of connections accepted by a stub listener",
+		labels)
+	p.acceptErrorsStat = stats.Counter(
+		"chisel_stub_accept_errors_total",
+		"Number of accept errors on a stub listener",
+		labels)
+	p.activeConnsStat = stats.Gauge(
+		"chisel_stub_active_connections",
+		"Number of currently open connections accepted by a stub listener",
+		labels)
+	p.listenerUpStat = stats.Gauge(
+		"chisel_stub_listener_up",
+		"1 if a stub listener is currently listening, 0 otherwise",
+		StatLabels{
+			"descriptor": p.chd.String(),
+			"listener":   strconv.FormatInt(atomic.AddInt64(&lastListenerStatID, 1), 10),
+		})
+	p.restartsStat = stats.Counter(
+		"chisel_stub_listener_restarts_total",
+		"Number of times a failed stub listener has been re-created",
+		labels)
+	p.localConnsStat = stats.Counter(
+		"chisel_stub_local_connections_total",
+		"Number of connections accepted by a stub listener that were served in this process, bypassing the remote proxy",
+		labels)
+	p.throttledStat = stats.Counter(
+		"chisel_stub_throttled_connections_total",
+		"Number of connections accepted by a stub listener that were closed because they exceeded its accept rate",
+		labels)
+	p.tlsRejectedStat = stats.Counter(
+		"chisel_stub_tls_rejections_total",
+		"Number of connections accepted by a stub listener that requires TLS that were closed because the TLS handshake or client certificate verification failed",
+		labels)
+	p.queuedStat = stats.Counter(
+		"chisel_stub_queued_connections_total",
+		"Number of connections accepted by a stub listener that waited for the connection to the remote proxy to be re-established",
+		labels)
+	p.queueTimeoutStat = stats.Counter(
+		"chisel_stub_queue_timeouts_total",
+		"Number of connections accepted by a stub listener that were closed because the connection to the remote proxy was not re-established in time",
+		labels)
+}
+
+// releaseStats releases this proxy's references to its metrics. Proxies for the same
+// descriptor share its metrics, which the StatsRegistry reference counts, so the metrics of
+// a descriptor stay registered until the last proxy serving it releases them.
+func (p *TCPProxy) releaseStats() {
+	stats := p.localChannelEnv.GetStatsRegistry()
+	for _, stat := range []*Stat{
+		p.acceptsStat,
+		p.acceptErrorsStat,
+		p.activeConnsStat,
+		p.listenerUpStat,
+		p.restartsStat,
+		p.localConnsStat,
+		p.throttledStat,
+		p.tlsRejectedStat,
+		p.queuedStat,
+		p.queueTimeoutStat,
+	} {
+		stats.Unregister(stat)
+	}
+}
+
 func (p *TCPProxy) String() string {
 	return p.strname
 }
@@ -45,6 +185,8 @@ func (p *TCPProxy) String() string {
 // HandleOnceShutdown will be called exactly once, in its own goroutine. It should take completionError
 // as an advisory completion value, actually shut down, then return the real completion value.
 func (p *TCPProxy) HandleOnceShutdown(completionErr error) error {
+	p.listenerUpStat.SetBool(false)
+	p.releaseStats()
 	return completionErr
 }
 
@@ -54,17 +196,13 @@ func (p *TCPProxy) Start(ctx context.Context) error {
 	// acceptLoop should not be included
 	err := p.DoOnceActivate(
 		func() error {
-			ep, err := NewLocalStubChannelEndpoint(p.Logger, p.localChannelEnv, p.chd.Stub)
-			if err != nil {
-				return p.Errorf("Unable to create Stub endpoint from descriptor %s: %s", p.chd.Stub, err)
+			if err := CheckE2EEndpoint(p.localChannelEnv, p.chd.Stub); err != nil {
+				return p.Errorf("%s", err)
 			}
-			p.AddShutdownChild(ep)
 			p.ShutdownOnContext(ctx)
-			err = ep.StartListening()
-			if err != nil {
-				return p.Errorf("StartListening failed for %s: %s", p.chd.Stub, err)
+			if err := p.listen(); err != nil {
+				return err
 			}
-			p.ep = ep
 
 			go p.acceptLoop(ctx)
 
@@ -75,42 +213,256 @@ func (p *TCPProxy) Start(ctx context.Context) error {
 	return err
 }
 
+// StartWithListener starts the proxy like Start, but accepting connections on a listener
+// handed off by another proxy (see HandOffListener) rather than listening anew
+func (p *TCPProxy) StartWithListener(ctx context.Context, listener net.Listener) error {
+	p.adopted = listener
+	return p.Start(ctx)
+}
+
+// HandOffListener stops the proxy accepting connections, and returns its listener, still
+// listening, for another proxy to adopt with StartWithListener. Connections already accepted
+// are still served.
+func (p *TCPProxy) HandOffListener() (net.Listener, error) {
+	ep, ok := p.getEndpoint().(ListenerHandoffEndpoint)
+	if !ok {
+		return nil, p.Errorf("Listener %s cannot be handed off", p.chd.Stub)
+	}
+	p.handedOffOnce.Do(func() { close(p.handedOff) })
+	listener, err := ep.DetachListener()
+	if err != nil {
+		return nil, err
+	}
+	// the accept loop must not take another connection from the listener
+	<-p.acceptDone
+	return listener, nil
+}
+
+// Drain makes the proxy refuse new connections, while still listening, so that it can be
+// closed once the connections it has already accepted finish. Returns false if it was already
+// draining.
+func (p *TCPProxy) Drain() bool {
+	return atomic.CompareAndSwapInt32(&p.draining, 0, 1)
+}
+
+// listen creates the local stub endpoint and starts listening on it
+func (p *TCPProxy) listen() error {
+	ep, err := NewLocalStubChannelEndpoint(p.Logger, p.localChannelEnv, p.chd.Stub)
+	if err != nil {
+		return p.Errorf("Unable to create Stub endpoint from descriptor %s: %s", p.chd.Stub, err)
+	}
+	if err := p.PauseShutdown(); err != nil {
+		ep.Close()
+		return err
+	}
+	defer p.ResumeShutdown()
+	p.AddShutdownChild(ep)
+	if p.adopted != nil {
+		adopted := p.adopted
+		p.adopted = nil
+		h, ok := ep.(ListenerHandoffEndpoint)
+		if !ok {
+			adopted.Close()
+			ep.Close()
+			return p.Errorf("Endpoint %s cannot adopt a listener", p.chd.Stub)
+		}
+		if err := h.AdoptListener(adopted); err != nil {
+			adopted.Close()
+			ep.Close()
+			return p.Errorf("%s", err)
+		}
+	}
+	err = ep.StartListening()
+	if err != nil {
+		ep.Close()
+		return p.Errorf("StartListening failed for %s: %s", p.chd.Stub, err)
+	}
+	p.epLock.Lock()
+	p.ep = ep
+	p.epLock.Unlock()
+	p.listenerUpStat.SetBool(true)
+	return nil
+}
+
+// getEndpoint returns the current local stub endpoint
+func (p *TCPProxy) getEndpoint() LocalStubChannelEndpoint {
+	p.epLock.Lock()
+	defer p.epLock.Unlock()
+	return p.ep
+}
+
+// ActiveConnections returns the number of accepted connections currently being served
+func (p *TCPProxy) ActiveConnections() int {
+	return int(atomic.LoadInt32(&p.activeConns))
+}
+
+// BoundAddr returns the address the local stub endpoint is actually listening on, or "" if
+// it is not listening or cannot report its address
+func (p *TCPProxy) BoundAddr() string {
+	if ep, ok := p.getEndpoint().(BoundAddrEndpoint); ok {
+		return ep.BoundAddr()
+	}
+	return ""
+}
+
+// isTemporaryAcceptError returns true if an accept error leaves the listener usable, e.g.
+// because the process has run out of file descriptors for the moment
+func isTemporaryAcceptError(err error) bool {
+	return errors.Is(err, syscall.EMFILE) || errors.Is(err, syscall.ENFILE) ||
+		errors.Is(err, syscall.ENOBUFS) || errors.Is(err, syscall.ENOMEM) ||
+		errors.Is(err, syscall.ECONNABORTED)
+}
+
+// acceptLoop accepts connections until the proxy is closed. If accepting fails, it retries
+// with backoff, and if the listener itself has failed, it re-creates the listener, so that
+// the remote is not left advertised but unusable.
 func (p *TCPProxy) acceptLoop(ctx context.Context) {
+	defer close(p.acceptDone)
 	done := make(chan struct{})
 	go func() {
 		select {
 		case <-ctx.Done():
 			p.ILogf("Forcing close of listening endpoint %s: %s", p.chd.Stub, ctx.Err())
-			p.ep.Close()
+			p.getEndpoint().Close()
 			p.DLogf("Done forcing close of listening endpoint")
 		case <-done:
 		}
 	}()
+	defer close(done)
+	b := &backoff.Backoff{Min: 100 * time.Millisecond, Max: listenerRetryMaxDelay}
 	for {
-		callerConn, err := p.ep.Accept(ctx)
+		ep := p.getEndpoint()
+		callerConn, err := ep.Accept(ctx)
 		if err != nil {
-			select {
-			case <-ctx.Done():
-				//listener closed
-			default:
-				p.ILogf("Accept error from %s, shutting down accept loop: %s", p.chd.Stub, err)
+			if p.isStopping(ctx) {
+				p.listenerUpStat.SetBool(false)
+				return
+			}
+			p.acceptErrorsStat.Inc()
+			if isTemporaryAcceptError(err) {
+				d := b.Duration()
+				p.ILogf("Accept error from %s, retrying in %s: %s", p.chd.Stub, d, err)
+				if !p.sleepUnlessStopping(ctx, d) {
+					p.listenerUpStat.SetBool(false)
+					return
+				}
+				continue
+			}
+			p.ILogf("Listener %s failed, re-creating it: %s", p.chd.Stub, err)
+			p.listenerUpStat.SetBool(false)
+			ep.Close()
+			for {
+				if !p.sleepUnlessStopping(ctx, b.Duration()) {
+					return
+				}
+				err = p.listen()
+				if err == nil {
+					break
+				}
+				if p.isStopping(ctx) {
+					return
+				}
+				p.ILogf("Unable to re-create listener %s, will retry: %s", p.chd.Stub, err)
+			}
+			p.restartsStat.Inc()
+			p.ILogf("Re-created listener %s", p.chd.Stub)
+			continue
+		}
+		b.Reset()
+		p.acceptsStat.Inc()
+		if atomic.LoadInt32(&p.draining) != 0 {
+			p.DLogf("Refusing connection to draining listener %s", p.chd.Stub)
+			callerConn.Close()
+			continue
+		}
+		if p.throttle != nil {
+			allowed, changed := p.throttle.Allow()
+			if changed {
+				if allowed {
+					p.ILogf("Accept rate of %s back within its limit", p.chd.Stub)
+				} else {
+					p.ILogf("Accept rate of %s exceeded; closing new connections", p.chd.Stub)
+				}
+			}
+			if !allowed {
+				p.throttledStat.Inc()
+				callerConn.Close()
+				continue
 			}
-			close(done)
-			return
 		}
 		go p.runWithLocalCallerConn(ctx, callerConn)
 	}
 }
 
+// isStopping returns true if the proxy is closing, its context is done, or it has handed its
+// listener off. The shutdown started and shutdown handler done chans are both closed before
+// the endpoint, a shutdown child, is closed, so either keeps that close from being mistaken
+// for a failure; waiting for the handler done chan means that a listener that fails while
+// HandleOnceShutdown is running is still treated as failed.
+func (p *TCPProxy) isStopping(ctx context.Context) bool {
+	select {
+	case <-ctx.Done():
+		return true
+	case <-p.ShutdownHandlerDoneChan():
+		return true
+	case <-p.handedOff:
+		return true
+	default:
+		return false
+	}
+}
+
+// sleepUnlessStopping waits for d, and returns false if the proxy started stopping first
+func (p *TCPProxy) sleepUnlessStopping(ctx context.Context, d time.Duration) bool {
+	select {
+	case <-p.localChannelEnv.GetClock().After(d):
+		return true
+	case <-ctx.Done():
+		return false
+	case <-p.ShutdownHandlerDoneChan():
+		return false
+	case <-p.handedOff:
+		return false
+	}
+}
+
 func (p *TCPProxy) runWithLocalCallerConn(ctx context.Context, callerConn ChannelConn) error {
 	subCtx, subCtxCancel := context.WithCancel(ctx)
 	defer subCtxCancel()
+	subCtx = newChannelContext(subCtx, p.chd.Stub)
 
 	p.count++
+	p.activeConnsStat.Inc()
+	defer p.activeConnsStat.Dec()
+	atomic.AddInt32(&p.activeConns, 1)
+	defer atomic.AddInt32(&p.activeConns, -1)
+
+	if hc, ok := callerConn.(HandshakeConn); ok {
+		name, err := hc.Handshake()
+		if err != nil {
+			p.tlsRejectedStat.Inc()
+			callerConn.Close()
+			return p.ILogErrorf("Rejected local connection to %s: TLS handshake failed: %s", p.chd.Stub, err)
+		}
+		if name != "" {
+			p.DLogf("Local connection authenticated with client certificate '%s'", name)
+		}
+	}
 
 	p.DLogf("TCPProxy Open, getting remote connection")
-	sshPrimaryConn, err := p.localChannelEnv.GetSSHConn()
+	var queueDeadline time.Time
+	if queueTime := p.localChannelEnv.GetReconnectQueueTime(); queueTime > 0 {
+		queueDeadline = p.localChannelEnv.GetClock().Now().Add(queueTime)
+	}
+	var sshPrimaryConn ssh.Conn
+	var err error
+	if queueDeadline.IsZero() {
+		sshPrimaryConn, err = p.localChannelEnv.GetSSHConn()
+	} else {
+		sshPrimaryConn, err = p.queuedSSHConn(subCtx, nil, queueDeadline)
+	}
 	if err != nil {
+		callerConn.Close()
 		return p.DLogErrorf("Unable to fetch sshPrimaryConn , exiting proxy: %s", err)
 	}
 
@@ -126,32 +478,232 @@ func (p *TCPProxy) runWithLocalCallerConn(ctx context.Context, callerConn Channe
 		return p.DLogErrorf("Unable to serialize endpoint descriptor '%s': %s", p.chd.Skeleton, err)
 	}
 
-	serviceSSHConn, reqs, err := sshPrimaryConn.OpenChannel("chisel", skeletonEndpointJSON)
+	if err := p.localChannelEnv.CheckChannelAdmission(); err != nil {
+		callerConn.Close()
+		return p.DLogErrorf("Refusing connection to remote endpoint %s: %s", p.chd.Skeleton, err)
+	}
+
+	if err := p.localChannelEnv.CheckChannelPolicy(subCtx, p.chd.Stub); err != nil {
+		callerConn.Close()
+		return err
+	}
+
+	if skeleton := p.localChannelEnv.GetLocalSkeleton(p.chd.Skeleton); skeleton != nil {
+		return p.serveLocally(subCtx, callerConn, skeleton)
+	}
+
+	serviceConn, err := p.openServiceConn(subCtx, sshPrimaryConn, skeletonEndpointJSON)
+	for !queueDeadline.IsZero() && errors.Is(err, errConnectionLost) {
+		sshPrimaryConn, err = p.queuedSSHConn(subCtx, sshPrimaryConn, queueDeadline)
+		if err != nil {
+			break
+		}
+		serviceConn, err = p.openServiceConn(subCtx, sshPrimaryConn, skeletonEndpointJSON)
+	}
+	if err != nil {
+		callerConn.Close()
+		return err
+	}
+
+	var remoteConn ChannelConn = serviceConn
+	if holdTime := EndpointHoldTime(p.chd.Stub); holdTime > 0 {
+		reopen := func(deadline time.Time) (ChannelConn, error) {
+			return p.reopenServiceConn(subCtx, skeletonEndpointJSON, deadline)
+		}
+		remoteConn, err = DialHoldConn(p.Logger, p.localChannelEnv.GetStatsRegistry(), p.localChannelEnv.GetClock(), holdTime, serviceConn, reopen)
+		if err != nil {
+			callerConn.Close()
+			return p.DLogErrorf("Unable to open held channel to remote endpoint %s: %s", p.chd.Skeleton, err)
+		}
+	}
+
+	e2eServiceConn, err := WrapE2EChannelConn(p.localChannelEnv, p.chd.Stub, remoteConn)
 	if err != nil {
+		remoteConn.Close()
 		callerConn.Close()
-		return p.DLogErrorf("SSH open channel to remote endpoint %s failed: %s", p.chd.Skeleton, err)
+		return p.DLogErrorf("End-to-end encryption with remote endpoint %s failed: %s", p.chd.Skeleton, err)
 	}
 
-	// will terminate when serviceSSHConn is closed
-	go ssh.DiscardRequests(reqs)
+	compressedServiceConn, err := WrapCompressChannelConn(p.chd.Stub, e2eServiceConn)
+	if err != nil {
+		e2eServiceConn.Close()
+		callerConn.Close()
+		return p.DLogErrorf("Compression negotiation with remote endpoint %s failed: %s", p.chd.Skeleton, err)
+	}
+
+	tappedServiceConn := p.localChannelEnv.TapChannel(subCtx, p.chd.Stub, compressedServiceConn)
+	tappedServiceConn = p.localChannelEnv.LimitChannel(p.chd.Stub, tappedServiceConn)
+	tappedServiceConn = ShapeChannelConn(p.localChannelEnv.GetClock(), p.chd.Stub, tappedServiceConn)
+	defer p.localChannelEnv.TrackChannel(p.chd.Stub, remoteConn)()
+
+	if timeout := EndpointIdleTimeout(p.chd.Stub); timeout > 0 {
+		go closeWhenIdle(subCtx, p.Logger, p.localChannelEnv.GetClock(), timeout, callerConn, tappedServiceConn)
+	}
+
+	callerToService, serviceToCaller, err := BasicBridgeChannels(subCtx, p.Logger, callerConn, tappedServiceConn)
+	logChannelClose(subCtx, p.Logger, p.localChannelEnv.GetStatsRegistry(), channelCloseInfo(remoteConn.CloseReason(), err), callerToService, serviceToCaller)
+	return err
+}
+
+// openServiceConn opens a channel to the remote skeleton endpoint. Channels refused by the
+// remote proxy for lack of resources are retried with backoff.
+func (p *TCPProxy) openServiceConn(ctx context.Context, sshPrimaryConn ssh.Conn, skeletonEndpointJSON []byte) (*SSHConn, error) {
+	serviceSSHConn, reqs, err := sshPrimaryConn.OpenChannel("chisel", skeletonEndpointJSON)
+	delay := channelAdmissionRetryDelay
+	for i := 0; i < channelAdmissionRetries && isRetryableOpenChannelError(err); i++ {
+		p.DLogf("Remote endpoint %s refused connection, retrying in %s: %s", p.chd.Skeleton, delay, err)
+		select {
+		case <-p.localChannelEnv.GetClock().After(delay):
+		case <-ctx.Done():
+			return nil, p.DLogErrorf("SSH open channel to remote endpoint %s cancelled", p.chd.Skeleton)
+		}
+		delay *= 2
+		serviceSSHConn, reqs, err = sshPrimaryConn.OpenChannel("chisel", skeletonEndpointJSON)
+	}
+	if openErr, ok := err.(*ssh.OpenChannelError); ok && openErr.Reason == ssh.ConnectionFailed {
+		// the remote skeleton could not connect to the Called Service
+		logChannelDialFailure(ctx, p.Logger, p.localChannelEnv.GetStatsRegistry(), errors.New(openErr.Message))
+	}
+	if err != nil {
+		_, refused := err.(*ssh.OpenChannelError)
+		err = p.DLogErrorf("SSH open channel to remote endpoint %s failed: %s", p.chd.Skeleton, err)
+		if !refused {
+			// the remote proxy did not answer, so the connection to it was lost
+			err = fmt.Errorf("%w: %s", errConnectionLost, err)
+		}
+		return nil, err
+	}
 
-	serviceConn, err := NewSSHConn(p.Logger, serviceSSHConn)
+	serviceConn, err := NewSSHConn(p.Logger, serviceSSHConn, reqs)
 	if err != nil {
 		sshCloseErr := serviceSSHConn.Close()
 		if sshCloseErr != nil {
 			p.DLogf("Cose of ssh.Conn failed, ignoring: %s", sshCloseErr)
 		}
+		return nil, p.DLogErrorf("SSH open channel to remote endpoint %s failed: %s", p.chd.Skeleton, err)
+	}
+
+	serviceConn.SetPriority(p.localChannelEnv.GetWriteScheduler(), EndpointChannelPriority(p.chd.Stub))
+	return serviceConn, nil
+}
+
+// reopenServiceConn opens a channel to the remote skeleton endpoint to re-establish a held
+// channel, waiting until the deadline for the connection to the remote proxy to be
+// re-established
+func (p *TCPProxy) reopenServiceConn(ctx context.Context, skeletonEndpointJSON []byte, deadline time.Time) (ChannelConn, error) {
+	type result struct {
+		sshConn ssh.Conn
+		err     error
+	}
+	ready := make(chan result, 1)
+	go func() {
+		sshConn, err := p.localChannelEnv.GetSSHConn()
+		ready <- result{sshConn, err}
+	}()
+	var r result
+	select {
+	case r = <-ready:
+	case <-p.localChannelEnv.GetClock().After(deadline.Sub(p.localChannelEnv.GetClock().Now())):
+		return nil, fmt.Errorf("Timed out waiting for connection to remote proxy")
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	if r.err != nil {
+		return nil, r.err
+	}
+	if r.sshConn == nil {
+		return nil, fmt.Errorf("No connection to remote proxy")
+	}
+	return p.openServiceConn(ctx, r.sshConn, skeletonEndpointJSON)
+}
+
+// queuedSSHConn returns the connection to the remote proxy for a connection accepted by the
+// stub while it is being re-established, waiting until deadline for a connection other
+// than lost, which may be nil
+func (p *TCPProxy) queuedSSHConn(ctx context.Context, lost ssh.Conn, deadline time.Time) (ssh.Conn, error) {
+	clock := p.localChannelEnv.GetClock()
+	queued := false
+	queue := func() {
+		if !queued {
+			queued = true
+			p.queuedStat.Inc()
+			p.DLogf("Queueing connection until the connection to the remote proxy is re-established")
+		}
+	}
+	for {
+		type result struct {
+			sshConn ssh.Conn
+			err     error
+		}
+		ready := make(chan result, 1)
+		go func() {
+			sshConn, err := p.localChannelEnv.GetSSHConn()
+			ready <- result{sshConn, err}
+		}()
+		var r result
+		select {
+		case r = <-ready:
+		case <-clock.After(reconnectQueuePollInterval):
+			queue()
+			select {
+			case r = <-ready:
+			case <-clock.After(deadline.Sub(clock.Now())):
+				p.queueTimeoutStat.Inc()
+				return nil, fmt.Errorf("Timed out waiting for connection to remote proxy")
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		if r.err != nil || r.sshConn != lost {
+			return r.sshConn, r.err
+		}
+		// the lost connection has not been replaced yet
+		queue()
+		if !clock.Now().Before(deadline) {
+			p.queueTimeoutStat.Inc()
+			return nil, fmt.Errorf("Timed out waiting for connection to remote proxy")
+		}
+		select {
+		case <-clock.After(reconnectQueuePollInterval):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// serveLocally serves a connection with a skeleton endpoint in this process that the remote
+// proxy would have routed it back to, bridging the two directly instead of sending the data
+// through the remote proxy and back
+func (p *TCPProxy) serveLocally(ctx context.Context, callerConn ChannelConn, skeleton *ChannelEndpointDescriptor) error {
+	p.DLogf("Remote endpoint %s is served by local endpoint %s; bypassing remote proxy", p.chd.Skeleton, skeleton)
+	ep, err := NewLocalSkeletonChannelEndpoint(p.Logger, p.localChannelEnv, skeleton)
+	if err != nil {
 		callerConn.Close()
-		return p.DLogErrorf("SSH open channel to remote endpoint %s failed: %s", p.chd.Skeleton, err)
+		return p.DLogErrorf("Unable to create local skeleton endpoint %s: %s", skeleton, err)
 	}
+	defer ep.Close()
+	p.localConnsStat.Inc()
 
-	callerToService, serviceToCaller, err := BasicBridgeChannels(subCtx, p.Logger, callerConn, serviceConn)
-	if err == nil {
-		p.DLogf("Proxy Connection for %s ended normally, caller sent %d bytes, service sent %d bytes",
-			p.chd, callerToService, serviceToCaller)
-	} else {
-		return p.DLogErrorf("Proxy conn for %s failed after %d bytes to service, %d bytes to caller: %s",
+	// the channel is limited, shaped and tracked as it would be through the remote proxy,
+	// first as a channel of the stub, then as one of the reverse remote's skeleton
+	callerConn = p.localChannelEnv.LimitChannel(p.chd.Stub, callerConn)
+	callerConn = ShapeChannelConn(p.localChannelEnv.GetClock(), p.chd.Stub, callerConn)
+	defer p.localChannelEnv.TrackChannel(p.chd.Stub, callerConn)()
+
+	callerConn = p.localChannelEnv.TapChannel(ctx, skeleton, callerConn)
+	callerConn = p.localChannelEnv.IdentifyChannel(skeleton, callerConn)
+	callerConn = ShadowChannelConn(p.Logger, p.localChannelEnv, skeleton, callerConn)
+	callerConn = p.localChannelEnv.LimitChannel(skeleton, callerConn)
+	defer p.localChannelEnv.TrackChannel(skeleton, callerConn)()
+
+	callerToService, serviceToCaller, err := ep.DialAndServe(ctx, callerConn, nil)
+	if err != nil {
+		return p.DLogErrorf("Local conn for %s failed after %d bytes to service, %d bytes to caller: %s",
 			p.chd, callerToService, serviceToCaller, err)
 	}
+	p.DLogf("Local connection for %s ended normally, caller sent %d bytes, service sent %d bytes",
+		p.chd, callerToService, serviceToCaller)
 	return nil
 }
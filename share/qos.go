@@ -0,0 +1,304 @@
+package chshare
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ChannelPriority is the scheduling class of a channel's writes to the SSH connection. It is
+// set with the "priority" channel descriptor option.
+type ChannelPriority string
+
+const (
+	// ChannelPriorityInteractive is for latency-sensitive traffic such as SSH shells. Its
+	// writes never wait for other channels.
+	ChannelPriorityInteractive ChannelPriority = "interactive"
+
+	// ChannelPriorityBulk is for ordinary traffic. It is the default.
+	ChannelPriorityBulk ChannelPriority = "bulk"
+
+	// ChannelPriorityBackground is for traffic that should only use capacity that
+	// no other channel wants
+	ChannelPriorityBackground ChannelPriority = "background"
+)
+
+// channelPriorityRanks orders the priority classes, most preferred first
+var channelPriorityRanks = map[ChannelPriority]int{
+	ChannelPriorityInteractive: 0,
+	ChannelPriorityBulk:        1,
+	ChannelPriorityBackground:  2,
+}
+
+const numChannelPriorities = 3
+
+// ParseChannelPriority converts a string to a ChannelPriority
+func ParseChannelPriority(s string) (ChannelPriority, error) {
+	p := ChannelPriority(s)
+	if _, ok := channelPriorityRanks[p]; !ok {
+		return ChannelPriorityBulk, fmt.Errorf("Unknown priority '%s'; must be interactive, bulk or background", s)
+	}
+	return p, nil
+}
+
+// EndpointChannelPriority returns the priority of channels to or from an endpoint, which
+// is bulk if the endpoint has no valid "priority" option
+func EndpointChannelPriority(d *ChannelEndpointDescriptor) ChannelPriority {
+	p, _ := ParseChannelPriority(d.Option("priority"))
+	return p
+}
+
+// schedulerWriteChunkSize is the default largest write made to the SSH connection at once by
+// a scheduled channel, so that other channels' writes can be interleaved
+const schedulerWriteChunkSize = 8 * 1024
+
+// schedulerMaxYield bounds how long a single write will wait for higher priority channels,
+// so that lower priority channels are never starved outright, and a higher priority channel
+// that is itself stalled on flow control cannot stop the others
+const schedulerMaxYield = 50 * time.Millisecond
+
+// WriteScheduler prioritizes the writes of channels sharing an SSH connection. While the
+// connection's transport is congested (a write to the underlying websocket is already in
+// progress), writes from a channel wait for writes from any higher priority channels to
+// finish first. When the transport is keeping up, all writes proceed immediately.
+//
+// A channel write that has been in progress for longer than schedulerMaxYield is assumed to
+// be stalled on the channel's flow control window, and is no longer waited for.
+type WriteScheduler struct {
+	lock  sync.Mutex
+	clock Clock
+
+	// active holds the start times of the channel writes in progress, by priority rank and
+	// write number
+	active [numChannelPriorities]map[uint64]time.Time
+
+	// lastWrite is the number of the last channel write to start
+	lastWrite uint64
+
+	// waiting counts the channel writes yielding to higher priority channels, by priority rank
+	waiting [numChannelPriorities]int
+
+	// changed is closed and replaced whenever a channel write finishes while other writes
+	// are waiting
+	changed chan struct{}
+
+	// transportWrites counts the writes to the transport in progress
+	transportWrites int32
+
+	// bandwidth limits the rate of channel writes, or is nil for no limit
+	bandwidth *BandwidthBucket
+
+	// chunkSize is the largest write made to the SSH connection at once
+	chunkSize int
+
+	// pending counts the bytes passed to channel writes that have not yet been written
+	pending int64
+
+	// transportBytes counts the bytes read from and written to the transport
+	transportBytes int64
+
+	pendingStat *Stat
+
+	yieldsStats [numChannelPriorities]*Stat
+}
+
+// NewWriteScheduler creates a new WriteScheduler that measures yields with clock
+func NewWriteScheduler(stats *StatsRegistry, clock Clock) *WriteScheduler {
+	s := &WriteScheduler{
+		clock:     clock,
+		changed:   make(chan struct{}),
+		chunkSize: schedulerWriteChunkSize,
+		pendingStat: stats.Gauge(
+			"chisel_pending_write_bytes",
+			"Number of bytes waiting to be written to SSH connections, e.g. because the remote end of a channel is not reading",
+			nil),
+	}
+	for rank := range s.active {
+		s.active[rank] = make(map[uint64]time.Time)
+	}
+	for p, rank := range channelPriorityRanks {
+		s.yieldsStats[rank] = stats.Counter(
+			"chisel_qos_yields_total",
+			"Number of channel writes that waited for other channels on a congested connection",
+			StatLabels{"priority": string(p)})
+	}
+	return s
+}
+
+// WrapTransport wraps the connection that carries the SSH protocol, so that the scheduler
+// can tell when it is congested
+func (s *WriteScheduler) WrapTransport(conn net.Conn) net.Conn {
+	return &scheduledTransportConn{Conn: conn, scheduler: s}
+}
+
+// SetBandwidthBucket limits the rate of channel writes to the share of a BandwidthScheduler
+// given by a bucket. Interactive writes are never delayed, but their bytes are still counted.
+func (s *WriteScheduler) SetBandwidthBucket(bucket *BandwidthBucket) {
+	s.lock.Lock()
+	s.bandwidth = bucket
+	s.lock.Unlock()
+}
+
+// SetWriteChunkSize sets the largest write made to the SSH connection at once, e.g. to fit
+// the network path of the transport, or restores the default if size is 0
+func (s *WriteScheduler) SetWriteChunkSize(size int) {
+	if size <= 0 {
+		size = schedulerWriteChunkSize
+	}
+	s.lock.Lock()
+	s.chunkSize = size
+	s.lock.Unlock()
+}
+
+// PendingBytes returns the number of bytes passed to channel writes that have not yet been
+// written, e.g. because the remote end of a channel is not reading
+func (s *WriteScheduler) PendingBytes() int64 {
+	return atomic.LoadInt64(&s.pending)
+}
+
+// TransportBytes returns the number of bytes read from and written to the transport
+func (s *WriteScheduler) TransportBytes() int64 {
+	return atomic.LoadInt64(&s.transportBytes)
+}
+
+// addPending adjusts the number of pending bytes
+func (s *WriteScheduler) addPending(delta int64) {
+	atomic.AddInt64(&s.pending, delta)
+	s.pendingStat.Add(delta)
+}
+
+// congested returns true if a write to the transport is in progress
+func (s *WriteScheduler) congested() bool {
+	return atomic.LoadInt32(&s.transportWrites) > 0
+}
+
+// mustYield returns true if a write of the given rank should wait. While the transport is
+// congested, interactive writes never wait, but other writes wait for all writes of higher
+// priority, and are made one at a time so that few are queued ahead of interactive writes.
+// The caller must hold the lock.
+func (s *WriteScheduler) mustYield(rank int, now time.Time) bool {
+	if rank == 0 || !s.congested() {
+		return false
+	}
+	for r := 0; r < numChannelPriorities; r++ {
+		if r < rank && s.waiting[r] > 0 {
+			return true
+		}
+		for _, start := range s.active[r] {
+			if now.Sub(start) < schedulerMaxYield {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// acquire waits until a channel write of the given rank may proceed, and returns its number
+// for release
+func (s *WriteScheduler) acquire(rank int) uint64 {
+	var deadline time.Time
+	yielded := false
+	s.lock.Lock()
+	now := s.clock.Now()
+	for s.mustYield(rank, now) {
+		if !yielded {
+			yielded = true
+			deadline = now.Add(schedulerMaxYield)
+			s.waiting[rank]++
+			s.yieldsStats[rank].Inc()
+		}
+		remaining := deadline.Sub(now)
+		if remaining <= 0 {
+			break
+		}
+		changed := s.changed
+		s.lock.Unlock()
+		select {
+		case <-changed:
+		case <-s.clock.After(remaining):
+		}
+		s.lock.Lock()
+		now = s.clock.Now()
+	}
+	if yielded {
+		s.waiting[rank]--
+	}
+	s.lastWrite++
+	write := s.lastWrite
+	s.active[rank][write] = now
+	s.lock.Unlock()
+	return write
+}
+
+// release ends a channel write of the given rank. Writes that are waiting are woken; when
+// none are, as while the transport keeps up, nothing is allocated.
+func (s *WriteScheduler) release(rank int, write uint64) {
+	s.lock.Lock()
+	delete(s.active[rank], write)
+	for _, n := range s.waiting {
+		if n > 0 {
+			close(s.changed)
+			s.changed = make(chan struct{})
+			break
+		}
+	}
+	s.lock.Unlock()
+}
+
+// Write writes p to w on behalf of a channel with the given priority, in chunks that are
+// each scheduled against other channels' writes
+func (s *WriteScheduler) Write(priority ChannelPriority, w func([]byte) (int, error), p []byte) (int, error) {
+	rank, ok := channelPriorityRanks[priority]
+	if !ok {
+		rank = channelPriorityRanks[ChannelPriorityBulk]
+	}
+	s.lock.Lock()
+	bandwidth := s.bandwidth
+	chunkSize := s.chunkSize
+	s.lock.Unlock()
+	s.addPending(int64(len(p)))
+	// bytes left unwritten after an error are no longer pending
+	defer func() { s.addPending(-int64(len(p))) }()
+	total := 0
+	for len(p) > 0 {
+		chunk := p
+		if len(chunk) > chunkSize {
+			chunk = chunk[:chunkSize]
+		}
+		if bandwidth != nil {
+			bandwidth.Take(len(chunk), rank != 0)
+		}
+		write := s.acquire(rank)
+		n, err := w(chunk)
+		s.release(rank, write)
+		total += n
+		s.addPending(-int64(n))
+		p = p[n:]
+		if err != nil {
+			return total, err
+		}
+	}
+	return total, nil
+}
+
+// scheduledTransportConn tracks writes in progress on an SSH transport connection
+type scheduledTransportConn struct {
+	net.Conn
+	scheduler *WriteScheduler
+}
+
+func (c *scheduledTransportConn) Write(b []byte) (int, error) {
+	atomic.AddInt32(&c.scheduler.transportWrites, 1)
+	defer atomic.AddInt32(&c.scheduler.transportWrites, -1)
+	n, err := c.Conn.Write(b)
+	atomic.AddInt64(&c.scheduler.transportBytes, int64(n))
+	return n, err
+}
+
+func (c *scheduledTransportConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	atomic.AddInt64(&c.scheduler.transportBytes, int64(n))
+	return n, err
+}
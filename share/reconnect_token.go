@@ -0,0 +1,165 @@
+package chshare
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ReconnectTokenRequestType is the SSH request type used by the server to hand the client a
+// reconnection token. The payload is the token.
+const ReconnectTokenRequestType = "reconnect-token"
+
+// DefaultReconnectTokenTTL is the default lifetime of a reconnection token
+const DefaultReconnectTokenTTL = time.Hour
+
+// DefaultReconnectTokenMaxAge is the default time after a user logged in with a password, or a
+// TLS client certificate, after which the reconnection tokens issued to its sessions, and to
+// the sessions that reconnected with them, are no longer valid
+const DefaultReconnectTokenMaxAge = 24 * time.Hour
+
+// reconnectTokenPrefix marks a password as a reconnection token, and versions the token format
+const reconnectTokenPrefix = "chtok2."
+
+// reconnectTokenLoginExtension is the ssh.Permissions extension in which the server passes
+// the time of the original login of a session that authenticated with a reconnection token,
+// as Unix seconds, from authentication to the session
+const reconnectTokenLoginExtension = "chisel-login-time"
+
+// ReconnectTokenIssuer issues and verifies short-lived reconnection tokens. A token names a
+// user, the user's token generation, the time the user originally logged in with a password
+// or certificate, and an expiry time, and is signed with HMAC-SHA256. A client presents its
+// token in place of its password when reconnecting, so a user's password can be rotated on
+// the server without disconnecting clients that have not yet been given the new password,
+// provided they reconnect before their token expires. Tokens are only accepted for users that
+// still exist with the same token generation, so that raising a user's generation revokes its
+// tokens, and not after the maximum age from the original login, however often they are
+// refreshed.
+type ReconnectTokenIssuer struct {
+	key    []byte
+	ttl    time.Duration
+	maxAge time.Duration
+	clock  Clock
+}
+
+// reconnectTokenClaims is the content of a reconnection token
+type reconnectTokenClaims struct {
+	userName   string
+	generation int
+	login      time.Time
+	expiry     time.Time
+}
+
+// NewReconnectTokenIssuer creates a ReconnectTokenIssuer that signs tokens with a key derived
+// from secret, and issues tokens that are valid for ttl, but not beyond maxAge after the
+// original login, as measured by clock
+func NewReconnectTokenIssuer(secret []byte, ttl time.Duration, maxAge time.Duration, clock Clock) *ReconnectTokenIssuer {
+	h := sha256.New()
+	h.Write([]byte("chisel reconnect token\x00"))
+	h.Write(secret)
+	return &ReconnectTokenIssuer{key: h.Sum(nil), ttl: ttl, maxAge: maxAge, clock: clock}
+}
+
+// TTL returns the lifetime of issued tokens
+func (t *ReconnectTokenIssuer) TTL() time.Duration {
+	return t.ttl
+}
+
+// CanIssue returns true if tokens may still be issued to a session whose user originally
+// logged in at login
+func (t *ReconnectTokenIssuer) CanIssue(login time.Time) bool {
+	return t.clock.Now().Before(login.Add(t.maxAge))
+}
+
+func (t *ReconnectTokenIssuer) sign(body string) []byte {
+	mac := hmac.New(sha256.New, t.key)
+	mac.Write([]byte(body))
+	return mac.Sum(nil)
+}
+
+// Issue returns a new token for a user with a token generation, who originally logged in at
+// login. The token expires after the TTL, or at the maximum age from login if that is sooner.
+func (t *ReconnectTokenIssuer) Issue(userName string, generation int, login time.Time) string {
+	expiry := t.clock.Now().Add(t.ttl)
+	if limit := login.Add(t.maxAge); expiry.After(limit) {
+		expiry = limit
+	}
+	body := reconnectTokenPrefix +
+		base64.RawURLEncoding.EncodeToString([]byte(userName)) + "." +
+		strconv.Itoa(generation) + "." +
+		strconv.FormatInt(login.Unix(), 10) + "." +
+		strconv.FormatInt(expiry.Unix(), 10)
+	return body + "." + base64.RawURLEncoding.EncodeToString(t.sign(body))
+}
+
+// Verify checks a token's signature and expiry, that it was issued to a user, and for the
+// user's current token generation, and that the maximum age from the original login has not
+// passed. It returns the time of the original login.
+func (t *ReconnectTokenIssuer) Verify(token string, userName string, generation int) (time.Time, error) {
+	i := strings.LastIndex(token, ".")
+	if !IsReconnectToken(token) || i < 0 {
+		return time.Time{}, fmt.Errorf("Malformed reconnection token")
+	}
+	body := token[:i]
+	mac, err := base64.RawURLEncoding.DecodeString(token[i+1:])
+	if err != nil || !hmac.Equal(mac, t.sign(body)) {
+		return time.Time{}, fmt.Errorf("Invalid reconnection token signature")
+	}
+	claims, err := parseReconnectToken(token)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if claims.userName != userName {
+		return time.Time{}, fmt.Errorf("Reconnection token issued to another user")
+	}
+	if claims.generation != generation {
+		return time.Time{}, fmt.Errorf("Reconnection token issued for token generation %d, not %d", claims.generation, generation)
+	}
+	now := t.clock.Now()
+	if now.After(claims.expiry) {
+		return time.Time{}, fmt.Errorf("Reconnection token expired at %s", claims.expiry)
+	}
+	if !t.CanIssue(claims.login) {
+		return time.Time{}, fmt.Errorf("Reconnection token login at %s is older than %s", claims.login, t.maxAge)
+	}
+	return claims.login, nil
+}
+
+// IsReconnectToken returns true if a password is a reconnection token
+func IsReconnectToken(password string) bool {
+	return strings.HasPrefix(password, reconnectTokenPrefix)
+}
+
+// parseReconnectToken returns the content of a token, without verifying it
+func parseReconnectToken(token string) (*reconnectTokenClaims, error) {
+	parts := strings.Split(strings.TrimPrefix(token, reconnectTokenPrefix), ".")
+	if !IsReconnectToken(token) || len(parts) != 5 {
+		return nil, fmt.Errorf("Malformed reconnection token")
+	}
+	userName, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, fmt.Errorf("Malformed reconnection token user: %s", err)
+	}
+	generation, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return nil, fmt.Errorf("Malformed reconnection token generation: %s", err)
+	}
+	login, err := strconv.ParseInt(parts[2], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("Malformed reconnection token login: %s", err)
+	}
+	expiry, err := strconv.ParseInt(parts[3], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("Malformed reconnection token expiry: %s", err)
+	}
+	return &reconnectTokenClaims{
+		userName:   string(userName),
+		generation: generation,
+		login:      time.Unix(login, 0),
+		expiry:     time.Unix(expiry, 0),
+	}, nil
+}
@@ -0,0 +1,367 @@
+package chshare
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// RecordingFormat is the file format of a channel recording
+type RecordingFormat string
+
+const (
+	// RecordingFormatCast is the asciinema asciicast v2 format, for interactive terminal
+	// sessions. Data from the Called Service is recorded as output ("o") events, and data
+	// from the Caller as input ("i") events. Bytes that are not valid UTF-8 are not
+	// preserved exactly.
+	RecordingFormatCast RecordingFormat = "cast"
+
+	// RecordingFormatRaw preserves binary traffic exactly. A recording starts with a line of
+	// JSON holding the ChannelTapInfo, followed by one record per read from the channel:
+	//
+	//	<8-byte big-endian elapsed nanoseconds><'i' or 'o'><4-byte big-endian length><data>
+	RecordingFormatRaw RecordingFormat = "raw"
+)
+
+// maxRecordingRecordSize is the largest raw recording record that ReplayRecording accepts.
+// Records hold a single read from a channel, so are far smaller; a larger length is
+// corrupt, and is not trusted to size a buffer.
+const maxRecordingRecordSize = 16 * 1024 * 1024
+
+// recordingFileExtensions holds the file name extension for each RecordingFormat
+var recordingFileExtensions = map[RecordingFormat]string{
+	RecordingFormatCast: ".cast",
+	RecordingFormatRaw:  ".chrec",
+}
+
+// validateRecordOption validates the value of the "record" descriptor option
+func validateRecordOption(value string) error {
+	switch value {
+	case "true", "false", string(RecordingFormatCast), string(RecordingFormatRaw):
+		return nil
+	}
+	return fmt.Errorf("Unknown record value '%s'; must be true, false, cast or raw", value)
+}
+
+// EndpointRecordingFormat returns the format in which channels to or from an endpoint are
+// recorded, or "" if they are not recorded. With "record=true", interactive channels are
+// recorded as asciicasts and all others as raw recordings.
+func EndpointRecordingFormat(d *ChannelEndpointDescriptor) RecordingFormat {
+	return recordingFormat(d, d.Option("record"))
+}
+
+// channelRecordingFormat returns the format in which a tapped channel is recorded, or "" if
+// it is not recorded. A recording required by the channel's user overrides the endpoint's
+// record option.
+func channelRecordingFormat(info *ChannelTapInfo) RecordingFormat {
+	if info.Record != "" {
+		return recordingFormat(info.Endpoint, info.Record)
+	}
+	return EndpointRecordingFormat(info.Endpoint)
+}
+
+// recordingFormat returns the format in which channels to or from an endpoint are recorded
+// for a "record" option value
+func recordingFormat(d *ChannelEndpointDescriptor, value string) RecordingFormat {
+	switch value {
+	case "true":
+		if EndpointChannelPriority(d) == ChannelPriorityInteractive {
+			return RecordingFormatCast
+		}
+		return RecordingFormatRaw
+	case string(RecordingFormatCast), string(RecordingFormatRaw):
+		return RecordingFormat(value)
+	}
+	return ""
+}
+
+// RecordingSink creates the destination for a recording of a channel in the given format.
+// The recording is closed when the channel ends.
+type RecordingSink func(info *ChannelTapInfo, format RecordingFormat) (io.WriteCloser, error)
+
+// NewDirRecordingSink returns a RecordingSink that writes each recording to a new file in a
+// directory, named from the channel's start time, ID and user
+func NewDirRecordingSink(dir string) (RecordingSink, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("Unable to create recording directory: %s", err)
+	}
+	sink := func(info *ChannelTapInfo, format RecordingFormat) (io.WriteCloser, error) {
+		name := fmt.Sprintf("%s-%d", info.Started.UTC().Format("20060102T150405.000Z"), info.ID)
+		if info.User != "" {
+			name += "-" + tapFileNameUnsafeChars.ReplaceAllString(info.User, "_")
+		}
+		path := filepath.Join(dir, name+recordingFileExtensions[format])
+		return os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	}
+	return sink, nil
+}
+
+// NewRecordingTap returns a ChannelTap that records the channels whose endpoints have the
+// "record" option, or whose users require recording, to a RecordingSink, timing their data
+// by clock
+func NewRecordingTap(logger Logger, clock Clock, sink RecordingSink) ChannelTap {
+	return func(info *ChannelTapInfo) (io.Writer, io.Writer) {
+		format := channelRecordingFormat(info)
+		if format == "" {
+			return nil, nil
+		}
+		w, err := sink(info, format)
+		if err != nil {
+			logger.ILogf("Unable to start recording of channel %d (%s): %s", info.ID, info.Endpoint, err)
+			return nil, nil
+		}
+		r := &channelRecorder{out: bufio.NewWriter(w), closer: w, format: format, clock: clock, start: info.Started, open: 2}
+		if err := r.writeHeader(info); err != nil {
+			logger.ILogf("Unable to start recording of channel %d (%s): %s", info.ID, info.Endpoint, err)
+			w.Close()
+			return nil, nil
+		}
+		logger.DLogf("Recording channel %d (%s) as %s", info.ID, info.Endpoint, format)
+		return &channelRecorderStream{r, 'i'}, &channelRecorderStream{r, 'o'}
+	}
+}
+
+// channelRecorder writes a recording of both directions of a channel
+type channelRecorder struct {
+	lock   sync.Mutex
+	out    *bufio.Writer
+	closer io.Closer
+	format RecordingFormat
+	clock  Clock
+	start  time.Time
+
+	// open counts the streams that have not been closed
+	open int
+}
+
+// writeHeader writes the start of the recording
+func (r *channelRecorder) writeHeader(info *ChannelTapInfo) error {
+	var header []byte
+	var err error
+	if r.format == RecordingFormatCast {
+		header, err = json.Marshal(map[string]interface{}{
+			"version":   2,
+			"width":     80,
+			"height":    24,
+			"timestamp": info.Started.Unix(),
+			"title":     info.Endpoint.String(),
+			"chisel":    info,
+		})
+	} else {
+		header, err = json.Marshal(info)
+	}
+	if err != nil {
+		return err
+	}
+	r.out.Write(header)
+	r.out.WriteByte('\n')
+	return r.out.Flush()
+}
+
+// write records data read from one direction of the channel. Records are flushed as they
+// are written, so that a recording is complete up to the moment a proxy is stopped.
+func (r *channelRecorder) write(kind byte, data []byte) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	elapsed := r.clock.Now().Sub(r.start)
+	if r.format == RecordingFormatCast {
+		event, err := json.Marshal(string(data))
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(r.out, "[%s, \"%c\", %s]\n",
+			strconv.FormatFloat(elapsed.Seconds(), 'f', 6, 64), kind, event)
+	} else {
+		var header [13]byte
+		binary.BigEndian.PutUint64(header[0:8], uint64(elapsed))
+		header[8] = kind
+		binary.BigEndian.PutUint32(header[9:13], uint32(len(data)))
+		r.out.Write(header[:])
+		r.out.Write(data)
+	}
+	return r.out.Flush()
+}
+
+// closeStream closes one direction, and the recording once both are closed
+func (r *channelRecorder) closeStream() error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.open--
+	if r.open > 0 {
+		return nil
+	}
+	r.out.Flush()
+	return r.closer.Close()
+}
+
+// channelRecorderStream is the io.WriteCloser through which a ChannelTap delivers one
+// direction of a channel to a channelRecorder
+type channelRecorderStream struct {
+	recorder *channelRecorder
+	kind     byte
+}
+
+func (s *channelRecorderStream) Write(p []byte) (int, error) {
+	if err := s.recorder.write(s.kind, p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (s *channelRecorderStream) Close() error {
+	return s.recorder.closeStream()
+}
+
+// CombineChannelTaps returns a ChannelTap that offers each channel to all of the given
+// taps, any of which may be nil. It returns nil if all of the taps are nil.
+func CombineChannelTaps(taps ...ChannelTap) ChannelTap {
+	var active []ChannelTap
+	for _, tap := range taps {
+		if tap != nil {
+			active = append(active, tap)
+		}
+	}
+	if len(active) <= 1 {
+		if len(active) == 0 {
+			return nil
+		}
+		return active[0]
+	}
+	return func(info *ChannelTapInfo) (io.Writer, io.Writer) {
+		callerToService := &multiTapWriter{}
+		serviceToCaller := &multiTapWriter{}
+		for _, tap := range active {
+			c2s, s2c := tap(info)
+			callerToService.add(c2s)
+			serviceToCaller.add(s2c)
+		}
+		return callerToService.writer(), serviceToCaller.writer()
+	}
+}
+
+// multiTapWriter copies writes to several tap writers, and stops copying to any writer that
+// fails without affecting the others
+type multiTapWriter struct {
+	writers []io.Writer
+}
+
+func (m *multiTapWriter) add(w io.Writer) {
+	if w != nil {
+		m.writers = append(m.writers, w)
+	}
+}
+
+// writer returns the writer to give to a tapConn, which is nil if there are no writers
+func (m *multiTapWriter) writer() io.Writer {
+	switch len(m.writers) {
+	case 0:
+		return nil
+	case 1:
+		return m.writers[0]
+	}
+	return m
+}
+
+func (m *multiTapWriter) Write(p []byte) (int, error) {
+	for i, w := range m.writers {
+		if w == nil {
+			continue
+		}
+		if _, err := w.Write(p); err != nil {
+			closeTapWriter(w)
+			m.writers[i] = nil
+		}
+	}
+	return len(p), nil
+}
+
+func (m *multiTapWriter) Close() error {
+	for _, w := range m.writers {
+		if w != nil {
+			closeTapWriter(w)
+		}
+	}
+	return nil
+}
+
+// ReplayRecording plays a recording made by a recording tap to w, in either format. The
+// original timing is reproduced, scaled by speed (2 plays twice as fast); a speed of 0 or
+// less writes the recording without delays, which are otherwise measured by clock. Only data
+// from the Called Service is played unless includeInput is true.
+func ReplayRecording(clock Clock, r io.Reader, w io.Writer, speed float64, includeInput bool) error {
+	br := bufio.NewReader(r)
+	headerLine, err := br.ReadBytes('\n')
+	if err != nil {
+		return fmt.Errorf("Unable to read recording header: %s", err)
+	}
+	var header map[string]interface{}
+	if err := json.Unmarshal(headerLine, &header); err != nil {
+		return fmt.Errorf("Invalid recording header: %s", err)
+	}
+	_, isCast := header["version"]
+
+	var played time.Duration
+	play := func(elapsed time.Duration, kind byte, data []byte) error {
+		if kind != 'o' && !(kind == 'i' && includeInput) {
+			return nil
+		}
+		if speed > 0 && elapsed > played {
+			<-clock.After(time.Duration(float64(elapsed-played) / speed))
+			played = elapsed
+		}
+		_, err := w.Write(data)
+		return err
+	}
+
+	for {
+		if isCast {
+			line, err := br.ReadBytes('\n')
+			if len(line) == 0 && err == io.EOF {
+				return nil
+			}
+			if err != nil && err != io.EOF {
+				return err
+			}
+			var event []interface{}
+			if err := json.Unmarshal(line, &event); err != nil || len(event) != 3 {
+				return fmt.Errorf("Invalid asciicast event: %s", line)
+			}
+			seconds, ok1 := event[0].(float64)
+			kind, ok2 := event[1].(string)
+			data, ok3 := event[2].(string)
+			if !ok1 || !ok2 || !ok3 || len(kind) != 1 {
+				return fmt.Errorf("Invalid asciicast event: %s", line)
+			}
+			if err := play(time.Duration(seconds*float64(time.Second)), kind[0], []byte(data)); err != nil {
+				return err
+			}
+		} else {
+			var recordHeader [13]byte
+			if _, err := io.ReadFull(br, recordHeader[:]); err != nil {
+				if err == io.EOF {
+					return nil
+				}
+				return fmt.Errorf("Truncated recording: %s", err)
+			}
+			size := binary.BigEndian.Uint32(recordHeader[9:13])
+			if size > maxRecordingRecordSize {
+				return fmt.Errorf("Invalid recording: record of %d bytes is larger than %d", size, maxRecordingRecordSize)
+			}
+			data := make([]byte, size)
+			if _, err := io.ReadFull(br, data); err != nil {
+				return fmt.Errorf("Truncated recording: %s", err)
+			}
+			elapsed := time.Duration(binary.BigEndian.Uint64(recordHeader[0:8]))
+			if err := play(elapsed, recordHeader[8], data); err != nil {
+				return err
+			}
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package chshare
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// RemoteValues expands variables in remote strings, so that the same remotes can be used on
+// many devices with only a few values differing between them. "${NAME}" is replaced with the
+// value of NAME from the values file, if any, or else from the environment, and
+// "${NAME:-default}" falls back to default if NAME is set in neither. An unset variable
+// without a default is an error.
+type RemoteValues struct {
+	values map[string]string
+}
+
+// LoadRemoteValues loads the values file, a YAML object of names and values, e.g.:
+//
+//	DB_HOST: db.site-12.internal
+//	SITE: "12"
+//
+// If path is "", only the environment is used.
+func LoadRemoteValues(path string) (*RemoteValues, error) {
+	v := &RemoteValues{values: map[string]string{}}
+	if path == "" {
+		return v, nil
+	}
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read values file: %s, error: %s", path, err)
+	}
+	if err := yaml.Unmarshal(b, &v.values); err != nil {
+		return nil, fmt.Errorf("Invalid values file %s: %s", path, err)
+	}
+	return v, nil
+}
+
+// lookup returns the value of a variable, and whether it is set
+func (v *RemoteValues) lookup(name string) (string, bool) {
+	if value, ok := v.values[name]; ok {
+		return value, true
+	}
+	return os.LookupEnv(name)
+}
+
+// Expand replaces the variables in a remote string with their values
+func (v *RemoteValues) Expand(remote string) (string, error) {
+	s := remote
+	var result strings.Builder
+	for {
+		start := strings.Index(s, "${")
+		if start < 0 {
+			result.WriteString(s)
+			return result.String(), nil
+		}
+		end := strings.Index(s[start:], "}")
+		if end < 0 {
+			return "", fmt.Errorf("Unterminated variable in remote '%s'", remote)
+		}
+		end += start
+		name, def := s[start+2:end], ""
+		hasDefault := false
+		if i := strings.Index(name, ":-"); i >= 0 {
+			name, def, hasDefault = name[:i], name[i+2:], true
+		}
+		if name == "" {
+			return "", fmt.Errorf("Empty variable name in remote '%s'", remote)
+		}
+		value, ok := v.lookup(name)
+		if !ok {
+			if !hasDefault {
+				return "", fmt.Errorf("Variable '%s' in remote '%s' is not set", name, remote)
+			}
+			value = def
+		}
+		result.WriteString(s[:start])
+		result.WriteString(value)
+		s = s[end+1:]
+	}
+}
+
+// ParseRemote expands the variables in a remote string and parses it
+func (v *RemoteValues) ParseRemote(s string) (*ChannelDescriptor, error) {
+	expanded, err := v.Expand(s)
+	if err != nil {
+		return nil, err
+	}
+	chd, err := ParseChannelDescriptor(expanded)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to parse channel descriptor string '%s': %s", expanded, err)
+	}
+	return chd, nil
+}
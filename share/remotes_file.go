@@ -0,0 +1,127 @@
+package chshare
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"github.com/fsnotify/fsnotify"
+	"gopkg.in/yaml.v2"
+)
+
+// RemotesFile is a reloadable source of client remote strings. The file is YAML, and may
+// either be a list of remote strings or an object with a "remotes" list, e.g.:
+//
+//	remotes:
+//	  - 3000:google.com:80
+//	  - R:2222:localhost:22
+//
+// An empty file, or an object without a "remotes" list or with other keys, is invalid rather
+// than an empty list, so that a mistake does not remove every remote.
+type RemotesFile struct {
+	Logger
+	path string
+}
+
+// NewRemotesFile creates a source for remotes read from a file
+func NewRemotesFile(logger Logger, path string) *RemotesFile {
+	return &RemotesFile{
+		Logger: logger.Fork("remotes-file"),
+		path:   filepath.Clean(path),
+	}
+}
+
+// Path returns the path of the remotes file
+func (f *RemotesFile) Path() string {
+	return f.path
+}
+
+// Load reads and parses the remotes file, returning the list of remote strings
+func (f *RemotesFile) Load() ([]string, error) {
+	b, err := ioutil.ReadFile(f.path)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read remotes file: %s, error: %s", f.path, err)
+	}
+	var node interface{}
+	if err := yaml.Unmarshal(b, &node); err != nil {
+		return nil, fmt.Errorf("Invalid remotes file %s: %s", f.path, err)
+	}
+	var remotes []string
+	switch node.(type) {
+	case []interface{}:
+		if err := yaml.UnmarshalStrict(b, &remotes); err != nil {
+			return nil, fmt.Errorf("Invalid remotes file %s: %s", f.path, err)
+		}
+	case map[interface{}]interface{}:
+		var doc struct {
+			Remotes *[]string `yaml:"remotes"`
+		}
+		if err := yaml.UnmarshalStrict(b, &doc); err != nil {
+			return nil, fmt.Errorf("Invalid remotes file %s: %s", f.path, err)
+		}
+		if doc.Remotes == nil {
+			return nil, fmt.Errorf("Invalid remotes file %s: no \"remotes\" list", f.path)
+		}
+		remotes = *doc.Remotes
+	default:
+		return nil, fmt.Errorf("Invalid remotes file %s: expected a list of remotes or an object with a \"remotes\" list", f.path)
+	}
+	result := make([]string, 0, len(remotes))
+	for _, r := range remotes {
+		r = strings.TrimSpace(r)
+		if r != "" {
+			result = append(result, r)
+		}
+	}
+	return result, nil
+}
+
+// Watch watches the remotes file for changes in the background, and calls onChange with the
+// newly loaded list of remote strings each time it is modified or replaced. Files that fail to
+// load are logged and ignored. Watching stops when ctx is done.
+func (f *RemotesFile) Watch(ctx context.Context, onChange func(remotes []string)) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	// Watch the directory rather than the file, so that atomic replacement
+	// of the file (write to temp, then rename) is seen
+	if err := watcher.Add(filepath.Dir(f.path)); err != nil {
+		watcher.Close()
+		return err
+	}
+	go func() {
+		defer watcher.Close()
+		for {
+			select {
+			case e, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				if filepath.Clean(e.Name) != f.path {
+					continue
+				}
+				if e.Op&(fsnotify.Write|fsnotify.Create) == 0 {
+					continue
+				}
+				remotes, err := f.Load()
+				if err != nil {
+					f.ILogf("Failed to reload remotes: %s", err)
+					continue
+				}
+				f.DLogf("Remotes successfully reloaded from: %s", f.path)
+				onChange(remotes)
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				f.DLogf("Remotes file watch error, ignoring: %s", err)
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return nil
+}
@@ -0,0 +1,58 @@
+package chshare
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func loadTestRemotesFile(t *testing.T, content string) ([]string, error) {
+	dir, err := ioutil.TempDir("", "chisel-remotes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "remotes.yaml")
+	if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return NewRemotesFile(NewLogger("test", LogLevelError), path).Load()
+}
+
+func TestRemotesFileLoad(t *testing.T) {
+	want := []string{"3000:google.com:80", "R:2222:localhost:22"}
+	for _, content := range []string{
+		"remotes:\n  - 3000:google.com:80\n  - R:2222:localhost:22\n",
+		"- 3000:google.com:80\n- R:2222:localhost:22\n",
+	} {
+		remotes, err := loadTestRemotesFile(t, content)
+		if err != nil {
+			t.Fatalf("Load(%q) failed: %s", content, err)
+		}
+		if !reflect.DeepEqual(remotes, want) {
+			t.Fatalf("Load(%q) = %v, want %v", content, remotes, want)
+		}
+	}
+	for _, content := range []string{"remotes: []\n", "[]\n"} {
+		remotes, err := loadTestRemotesFile(t, content)
+		if err != nil || len(remotes) != 0 {
+			t.Fatalf("Load(%q) = %v, %v, want an empty list", content, remotes, err)
+		}
+	}
+}
+
+func TestRemotesFileLoadRejectsMissingRemotes(t *testing.T) {
+	for _, content := range []string{
+		"",
+		"remote:\n  - 3000:google.com:80\n",
+		"remotes:\n  - 3000:google.com:80\nextra: 1\n",
+		"remotes:\n",
+		"3000:google.com:80\n",
+	} {
+		if remotes, err := loadTestRemotesFile(t, content); err == nil {
+			t.Fatalf("Load(%q) = %v, want an error", content, remotes)
+		}
+	}
+}
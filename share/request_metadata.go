@@ -0,0 +1,95 @@
+package chshare
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync/atomic"
+)
+
+// RequestMetadata attributes the work done for a request, such as serving a channel, to the
+// session and user it is done for. It travels in the request's context, from the session
+// through the channel's handlers, endpoints and wrappers, so that logs, taps, metrics and
+// policy checks all see the same attribution without it being passed to each of them.
+type RequestMetadata struct {
+	// SessionID is the server's ID of the client session, or 0 on the client
+	SessionID int32
+
+	// User is the authenticated user of the session on the server, or the user the client
+	// authenticates as; empty if authentication is not in use
+	User string
+
+	// Tenant is the tenant of the session on the server, or "" for the default tenant
+	Tenant string
+
+	// ChannelID uniquely identifies a channel within this process, or is 0 for a request
+	// that is not for a channel. It is the ID that taps and observe endpoints see.
+	ChannelID int64
+
+	// Endpoint is the local endpoint of the channel, or nil for a request that is not for a
+	// channel
+	Endpoint *ChannelEndpointDescriptor
+}
+
+// requestMetadataKey is the context key of a RequestMetadata. It is unexported, so that only
+// WithRequestMetadata can set it.
+type requestMetadataKey struct{}
+
+// lastChannelID is the ID of the most recently opened channel in this process
+var lastChannelID int64
+
+// WithRequestMetadata returns a context carrying md
+func WithRequestMetadata(ctx context.Context, md *RequestMetadata) context.Context {
+	return context.WithValue(ctx, requestMetadataKey{}, md)
+}
+
+// RequestMetadataFromContext returns the RequestMetadata carried by ctx, or nil if it has
+// none. The result must not be modified.
+func RequestMetadataFromContext(ctx context.Context) *RequestMetadata {
+	md, _ := ctx.Value(requestMetadataKey{}).(*RequestMetadata)
+	return md
+}
+
+// newChannelContext returns a context for serving a new channel with local endpoint ced,
+// whose metadata adds a new channel ID and the endpoint to the metadata of ctx
+func newChannelContext(ctx context.Context, ced *ChannelEndpointDescriptor) context.Context {
+	md := &RequestMetadata{}
+	if parent := RequestMetadataFromContext(ctx); parent != nil {
+		*md = *parent
+	}
+	md.ChannelID = atomic.AddInt64(&lastChannelID, 1)
+	md.Endpoint = ced
+	return WithRequestMetadata(ctx, md)
+}
+
+// String returns the attribution for log messages, e.g. "channel #12, user bob, session 3",
+// with empty fields left out
+func (md *RequestMetadata) String() string {
+	if md == nil {
+		return ""
+	}
+	var parts []string
+	if md.ChannelID != 0 {
+		parts = append(parts, fmt.Sprintf("channel #%d", md.ChannelID))
+	}
+	if md.User != "" {
+		parts = append(parts, "user "+md.User)
+	}
+	if md.Tenant != "" {
+		parts = append(parts, "tenant "+md.Tenant)
+	}
+	if md.SessionID != 0 {
+		parts = append(parts, fmt.Sprintf("session %d", md.SessionID))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// requestLogPrefix returns the attribution of ctx's request as a prefix for a log message,
+// or "" if it has none
+func requestLogPrefix(ctx context.Context) string {
+	s := RequestMetadataFromContext(ctx).String()
+	if s == "" {
+		return ""
+	}
+	return "[" + s + "] "
+}
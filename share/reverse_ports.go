@@ -0,0 +1,98 @@
+package chshare
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// ParsePortRange parses a port, or a range of ports given as "<low>-<high>"
+func ParsePortRange(s string) (int, int, error) {
+	lo, hi := s, s
+	if i := strings.Index(s, "-"); i >= 0 {
+		lo, hi = s[:i], s[i+1:]
+	}
+	minPort, err := strconv.Atoi(lo)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid port or port range '%s'", s)
+	}
+	maxPort, err := strconv.Atoi(hi)
+	if err != nil || minPort < 1 || maxPort > 65535 || minPort > maxPort {
+		return 0, 0, fmt.Errorf("invalid port or port range '%s'", s)
+	}
+	return minPort, maxPort, nil
+}
+
+// withStubPort returns a copy of a reverse channel descriptor with its TCP stub listening on
+// another port
+func withStubPort(chd *ChannelDescriptor, port int) *ChannelDescriptor {
+	host, _, _ := net.SplitHostPort(chd.Stub.Path)
+	stub := *chd.Stub
+	stub.Path = net.JoinHostPort(host, strconv.Itoa(port))
+	alt := *chd
+	alt.Stub = &stub
+	return &alt
+}
+
+// startReverseProxy starts a stub listener for a reverse channel. If the server has a
+// reverse port range and a TCP stub cannot listen on its port, e.g. because the port is in
+// use, the first free port in the range that the session's user may access is used instead.
+// The client learns the substitute from the bound addresses in the channels reply.
+//
+// If the server has a state file, a TCP stub that listened on another port before the server
+// restarted tries that port first, and ports reserved for other sessions are not used.
+func (s *ServerSSHSession) startReverseProxy(ctx context.Context, index int, chd *ChannelDescriptor) (*TCPProxy, error) {
+	owner := s.stateOwner()
+	_, requested, _ := net.SplitHostPort(chd.Stub.Path)
+	if chd.Stub.Type == ChannelEndpointTypeTCP {
+		if port := s.server.state.PreviousPort(owner, chd); port != 0 && strconv.Itoa(port) != requested {
+			alt := withStubPort(chd, port)
+			if s.checkChannelDescriptor(alt) == nil {
+				altProxy := NewTCPProxy(s.Logger, s, index, alt)
+				s.AddShutdownChild(altProxy)
+				if altProxy.Start(ctx) == nil {
+					s.ILogf("Listening on port %d for %s, as before the server restarted", port, chd)
+					return altProxy, nil
+				}
+				altProxy.Close()
+			}
+		}
+	}
+	var err error
+	if port, _ := strconv.Atoi(requested); chd.Stub.Type == ChannelEndpointTypeTCP && s.server.state.ReservedForOther(owner, port) {
+		err = fmt.Errorf("Port %d is reserved for a session that had it before the server restarted", port)
+	} else {
+		proxy := NewTCPProxy(s.Logger, s, index, chd)
+		s.AddShutdownChild(proxy)
+		err = proxy.Start(ctx)
+		if err == nil {
+			return proxy, nil
+		}
+		proxy.Close()
+	}
+	minPort, maxPort := s.server.reversePortMin, s.server.reversePortMax
+	if minPort == 0 || chd.Stub.Type != ChannelEndpointTypeTCP {
+		return nil, err
+	}
+	s.DLogf("Unable to listen for %s (%s); trying ports %d-%d", chd, err, minPort, maxPort)
+	for port := minPort; port <= maxPort; port++ {
+		if strconv.Itoa(port) == requested || s.server.state.ReservedForOther(owner, port) {
+			continue
+		}
+		alt := withStubPort(chd, port)
+		if s.checkChannelDescriptor(alt) != nil {
+			continue
+		}
+		altProxy := NewTCPProxy(s.Logger, s, index, alt)
+		s.AddShutdownChild(altProxy)
+		if altProxy.Start(ctx) == nil {
+			s.ILogf("Port %s unavailable; listening on port %d instead for %s", requested, port, chd)
+			s.server.reversePortSubstitutionsStat.Inc()
+			return altProxy, nil
+		}
+		altProxy.Close()
+	}
+	return nil, fmt.Errorf("%s, and no port in %d-%d is free", err, minPort, maxPort)
+}
@@ -8,25 +8,225 @@ import (
 	"github.com/gorilla/websocket"
 	"github.com/jpillora/requestlog"
 	"golang.org/x/crypto/ssh"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+	"io"
 	"io/ioutil"
 	"log"
+	"net"
 	"net/http"
 	"net/http/httputil"
 	"net/url"
 	"os"
 	"regexp"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
 )
 
 // ProxyServerConfig is the configuration for the chisel service
 type ProxyServerConfig struct {
-	KeySeed  string
-	AuthFile string
-	Auth     string
-	Proxy    string
-	Socks5   bool
-	NoLoop   bool
-	Reverse  bool
-	Debug    bool
+	KeySeed        string
+	AuthFile       string
+	Auth           string
+	Proxy          string
+	Socks5         bool
+	Socks5Resolver string
+	DialSource     string
+	UnixSocketDirs string
+	NoLoop         bool
+	Reverse        bool
+	MetricsAddr    string
+	AdminAddr      string
+	AdminToken     string
+	Debug          bool
+
+	// ProxyPreserveHost is true if requests forwarded to Proxy keep the Host header the
+	// client sent, instead of the Proxy URL's host
+	ProxyPreserveHost bool
+
+	// ProxyForwardedHeaders is true if requests forwarded to Proxy are given
+	// X-Forwarded-Proto and X-Forwarded-Host headers describing the client's request
+	ProxyForwardedHeaders bool
+
+	AuthMaxDelay         time.Duration
+	AuthLockoutThreshold int
+	AuthLockoutDuration  time.Duration
+
+	ReconnectTokenTTL time.Duration
+
+	// ReconnectTokenMaxAge is the time after a user's password or certificate login beyond
+	// which no reconnection token issued since is valid; defaults to
+	// DefaultReconnectTokenMaxAge
+	ReconnectTokenMaxAge time.Duration
+
+	// MaxSessionAge, if not 0, is the age at which a client session is asked to reconnect
+	MaxSessionAge time.Duration
+
+	// SessionDrainTimeout is how long a session past MaxSessionAge is given for its channels
+	// to finish before it is closed; defaults to DefaultSessionDrainTimeout
+	SessionDrainTimeout time.Duration
+
+	// WebSocketBatchDelay, if not 0, is how long small writes to a websocket transport may
+	// wait to be coalesced into fewer messages
+	WebSocketBatchDelay time.Duration
+
+	// WebSocketMaxMessage, if not 0, is the largest websocket message to send, in bytes
+	WebSocketMaxMessage int64
+
+	// HTTP2 is true if the server also accepts HTTP/2 connections, cleartext (h2c) or
+	// negotiated with TLS, for clients using the HTTP/2 stream transport
+	HTTP2 bool
+
+	// RekeyThreshold, if not 0, is the number of bytes sent or received on a client session
+	// after which its SSH keys are renegotiated; 0 leaves it to the SSH library's default for
+	// the negotiated cipher
+	RekeyThreshold int64
+
+	Bandwidth        int64
+	BandwidthWeights map[string]int
+
+	SessionBufferLimit int64
+	MemoryWatermark    int64
+
+	// ChannelTap, if not nil, is offered a copy of the traffic of every channel
+	ChannelTap ChannelTap
+
+	// RecordingSink, if not nil, receives recordings of channels with the "record" option
+	RecordingSink RecordingSink
+
+	// TLS, if not nil, configures the server to accept connections with TLS
+	TLS *TLSServerConfig
+
+	// CamouflageFile, if not "", is a JSON file customizing the responses to non-chisel
+	// HTTP requests
+	CamouflageFile string
+
+	// NoStatus is true if the /health and /version endpoints are disabled
+	NoStatus bool
+
+	// StatusToken, if not "", is a bearer token that requests to the /health and /version
+	// endpoints must present
+	StatusToken string
+
+	// RequireHeader, if not "", is a header, "<name>: <value>", that client connection
+	// requests must carry before they are authenticated
+	RequireHeader string
+
+	// UpgradeKey, if not "", is a secret shared with clients, with which they sign a one-time
+	// token for each request that opens a transport (see UpgradeTokens)
+	UpgradeKey string
+
+	// UpgradeTokenSkew is the difference allowed between a client's clock and the server's
+	// when an upgrade token is checked. Defaults to DefaultUpgradeTokenSkew.
+	UpgradeTokenSkew time.Duration
+
+	// DuplicateSessions is the policy for a new session with the same name as another session
+	// of the same user: DuplicateSessionAllow (the default), DuplicateSessionReject or
+	// DuplicateSessionReplace
+	DuplicateSessions string
+
+	// ReversePortRange, if not "", is a range of ports, "<low>-<high>", from which a reverse
+	// remote's stub listener takes a free port if it cannot listen on the port requested
+	ReversePortRange string
+
+	// BindAny is true if reverse remotes may listen on all interfaces, e.g. 0.0.0.0. Otherwise
+	// they must listen on specific addresses, such as the default 127.0.0.1.
+	BindAny bool
+
+	// Observe is true if users with the "observe" permission may observe other channels
+	// read-only (see ObservationHub)
+	Observe bool
+
+	// LoopBridges are loop bridges (see LoopBridge) between loop names and sockets on the
+	// server host
+	LoopBridges []string
+
+	// TUN is true if clients' remotes may attach to TUN devices on the server host
+	TUN bool
+
+	// PAC, if not "", is a comma-separated list of destinations (see PACRules) that the
+	// server's /proxy.pac routes through the SOCKS stubs of clients' reverse remotes
+	PAC string
+
+	// ExecCommands are the commands (see ExecCommands) that clients' exec remotes may run on
+	// the server host, each written as <name>=<command line>
+	ExecCommands []string
+
+	// StateFile, if not "", is a file in which the state of the server's sessions is saved,
+	// so that after a restart, reconnecting sessions get back the ports of their reverse
+	// listeners (see ServerState)
+	StateFile string
+
+	// StateReservationTime is how long after a restart the ports in StateFile are reserved
+	// for the sessions that had them; defaults to DefaultStateReservationTime
+	StateReservationTime time.Duration
+
+	// LimitWarnPercent, if not 0, is the percentage of a user's limits, such as max_sessions
+	// and max_channel_bytes, at which a warning is raised
+	LimitWarnPercent int
+
+	// LimitWarnWebhook, if not "", is a URL to which limit warnings are posted as JSON
+	LimitWarnWebhook string
+
+	// ACLRevokeGrace is how long after a user's access list is narrowed, or the user is
+	// deleted, that the user's sessions lose the channels no longer allowed, or are shut down
+	ACLRevokeGrace time.Duration
+
+	// ChannelPolicyFile, if not "", is a file holding a policy expression (see PolicyExpr)
+	// that must be true for a client session to open a channel
+	ChannelPolicyFile string
+
+	// ChannelAliasesFile, if not "", is a JSON file defining the channel aliases (see
+	// ChannelAliases) that clients may use as skeleton endpoints
+	ChannelAliasesFile string
+
+	// IdentRules are "<address regex>=<ident>" rules (see IdentRule) for the targets of the
+	// server's skeleton endpoints to which the server sends identities, whatever the client
+	// asks for
+	IdentRules []string
+
+	// DecisionCacheTTL, if not 0, is how long access list and channel policy decisions are
+	// cached (see DecisionCache)
+	DecisionCacheTTL time.Duration
+
+	// StatsUpdateInterval, if not 0, is how often each session sends the traffic of its
+	// channel descriptors to its client
+	StatsUpdateInterval time.Duration
+
+	// Tenants maps TLS server names to the auth files of separate sets of users. Clients
+	// that ask for one of the server names with SNI authenticate as, and are limited by the
+	// access lists of, that tenant's users instead of the server's own.
+	Tenants map[string]string
+
+	// Provision is true if a client presenting a verified TLS client certificate for an
+	// unknown user is registered as a new user, pending approval through the admin API
+	Provision bool
+
+	// ProvisionACL is the address regular expression that users provisioned automatically
+	// are allowed to connect to until approved, or "" to allow none
+	ProvisionACL string
+
+	// ProvisionFile, if not "", is a file in which provisioned users, and their approval, are
+	// saved whenever they change, and from which they are loaded when the server starts
+	ProvisionFile string
+
+	// SessionLogLines is the number of recent log lines, down to debug level, kept for each
+	// client session and returned by the admin API, or 0 to keep none
+	SessionLogLines int
+
+	// Clock, if not nil, replaces SystemClock as the source of time for session timeouts,
+	// reconnection tokens and held channels, for tests
+	Clock Clock
+
+	// Faults, if not nil, injects faults into the connections from clients, for tests
+	Faults FaultInjector
+
+	// LoopServer, if not nil, is used for loop endpoints in place of a LoopServer of the
+	// server's own, so that loop names are shared with a client in the same process (see
+	// "chisel relay")
+	LoopServer *LoopServer
 }
 
 // Server respresent a chisel service
@@ -38,16 +238,190 @@ type Server struct {
 	reverseProxy *httputil.ReverseProxy
 	sessions     *Users
 	socksServer  *socks5.Server
+	socksConfig  *socks5.Config
+	dialSource   *DialSource
 	loopServer   *LoopServer
 	sshConfig    *ssh.ServerConfig
 	users        *UserIndex
 	reverseOk    bool
+	bindAnyOk    bool
+	http2Ok      bool
+	stats        *StatsRegistry
 	httpHandler  http.Handler
+	adminServer  *AdminServer
+	authLimiter  *AuthLimiter
+
+	// metricsServer serves /metrics on the --metrics-addr listener, or is nil
+	metricsServer *HTTPServer
+
+	// unixSocketDirs restricts the sockets unix skeletons may connect to, or is nil
+	unixSocketDirs *UnixSocketDirs
+
+	// execCommands are the commands exec skeletons may run, or nil
+	execCommands *ExecCommands
+
+	// pacRules are the destinations /proxy.pac routes through reverse SOCKS stubs, or nil
+	// if it is not served
+	pacRules *PACRules
+
+	// config is the configuration the server was created with
+	config *ProxyServerConfig
+
+	// reconnectTokens issues reconnection tokens to authenticated clients, or is nil if
+	// reconnection tokens are disabled
+	reconnectTokens *ReconnectTokenIssuer
+
+	// activeSessionsLock protects activeSessions and draining
+	activeSessionsLock sync.Mutex
+
+	// activeSessions holds all client sessions currently running on the server, by session ID
+	activeSessions map[int32]*ServerSSHSession
+
+	// draining is true if the server is not accepting new client sessions
+	draining bool
+
+	// bandwidth shares the server's sending rate between sessions, or is nil if unlimited
+	bandwidth *BandwidthScheduler
+
+	// bandwidthWeights holds each user's share of the bandwidth, relative to the default of 1
+	bandwidthWeights map[string]int
+
+	// sessionBufferLimit is the number of bytes waiting to be written above which a session
+	// refuses new channels, or 0 for no limit
+	sessionBufferLimit int64
+
+	// memoryWatermark refuses new channels while the heap is too large, or is nil for no limit
+	memoryWatermark *MemoryWatermark
+
+	sessionBufferRejectsStat   *Stat
+	memoryWatermarkRejectsStat *Stat
+	userSessionRejectsStat     *Stat
+	userSessionEvictionsStat   *Stat
+
+	// channelTap is offered a copy of the traffic of every channel, including the recording
+	// tap if recording is enabled, or is nil
+	channelTap ChannelTap
+
+	// clock is the source of time, and faults injects faults into client connections
+	clock  Clock
+	faults FaultInjector
+
+	// socksResolveOnClient is true if SOCKS5 host names are resolved on the client side of the tunnel
+	socksResolveOnClient bool
+
+	// pollServer accepts clients on the long-poll transport
+	pollServer *PollServer
+
+	// heldChannels holds the server side of forward channels with the "hold" option
+	heldChannels *HeldChannels
+
+	// camouflage customizes the responses to non-chisel HTTP requests, or is nil
+	camouflage *Camouflage
+
+	// noStatus is true if the /health and /version endpoints are disabled
+	noStatus bool
+
+	// statusToken is the bearer token required by the /health and /version endpoints, or ""
+	statusToken string
+
+	// requireHeaderName and requireHeaderValue are the header that client connection requests
+	// must carry, or "" if none is required
+	requireHeaderName  string
+	requireHeaderValue string
+
+	// upgradeTokens verifies the upgrade tokens of requests that open transports, or is nil
+	// if none are required
+	upgradeTokens *UpgradeTokens
+
+	// tenants holds the users of each tenant, by lower case TLS server name
+	tenants map[string]*UserIndex
+
+	// reversePortMin and reversePortMax are the range of ports that reverse stub listeners
+	// fall back to, or 0 if there is none
+	reversePortMin int
+	reversePortMax int
+
+	reversePortSubstitutionsStat *Stat
+
+	// duplicateSessions is the policy for sessions with the same name
+	duplicateSessions string
+
+	sessionNameRejectsStat      *Stat
+	sessionNameReplacementsStat *Stat
+
+	// provision is true if unknown users with verified client certificates are provisioned
+	provision bool
+
+	// provisionAddrs is the access list given to users when they are provisioned
+	provisionAddrs []*regexp.Regexp
+
+	// provisionLock serializes provisioning, so that a user is only provisioned once, and
+	// protects provisionedUsers
+	provisionLock sync.Mutex
+
+	// provisionFile is the file in which provisioned users are saved, or "" if they are not
+	provisionFile string
+
+	// provisionedUsers holds the names of the users provisioned, or loaded from provisionFile
+	provisionedUsers map[string]struct{}
+
+	provisionedUsersStat *Stat
+
+	// maxSessionAge is the age at which a session is asked to reconnect, or 0 for no limit
+	maxSessionAge time.Duration
+
+	// sessionDrainTimeout is how long a session past maxSessionAge is given to drain
+	sessionDrainTimeout time.Duration
+
+	sessionMaxAgeStat *Stat
+
+	// observations lets authorized users observe channels, or is nil if they may not
+	observations *ObservationHub
+
+	// loopBridges are the server's bridges between loop names and local sockets
+	loopBridges []*LoopBridge
+
+	// state saves the state of the server's sessions, or is nil if it is not saved
+	state *ServerState
+
+	// limitWarner raises warnings when users near their limits, or is nil for none
+	limitWarner *LimitWarner
+
+	// aclRevokeGrace is how long revoked access is kept before it is enforced
+	aclRevokeGrace time.Duration
+
+	aclRevokedChannelsStat *Stat
+	aclRevokedSessionsStat *Stat
+
+	// channelPolicy, if not nil, decides whether each new channel may be opened
+	channelPolicy *ChannelPolicy
+
+	// aliases, if not nil, resolves alias skeleton endpoints
+	aliases *ChannelAliases
+
+	// identRules are the targets to which identities are sent whatever the client asks for
+	identRules []*IdentRule
+
+	// decisions caches access list and channel policy decisions, or is nil
+	decisions *DecisionCache
+
+	channelPolicyDenialsStat *Stat
+
+	socksDenialsStat *Stat
+
+	listenerTakeoversStat *Stat
+
+	// statsUpdateInterval is how often each session sends its traffic to its client, or 0
+	// for never
+	statsUpdateInterval time.Duration
+
+	// sessionLogLines is the number of recent log lines kept for each session, or 0
+	sessionLogLines int
 }
 
 var upgrader = websocket.Upgrader{
 	ReadBufferSize:  1024,
-	WriteBufferSize: 1024,
+	WriteBufferSize: wsWriteBufferSize,
 	CheckOrigin:     func(r *http.Request) bool { return true },
 }
 
@@ -62,9 +436,132 @@ func NewServer(config *ProxyServerConfig) (*Server, error) {
 		httpServer: NewHTTPServer(logger),
 		sessions:   NewUsers(),
 		reverseOk:  config.Reverse,
+		bindAnyOk:  config.BindAny,
+		http2Ok:    config.HTTP2,
+		stats:      NewStatsRegistry(),
+		config:     config,
+
+		activeSessions: make(map[int32]*ServerSSHSession),
 	}
 	s.InitShutdownHelper(logger, s)
+	s.clock = config.Clock
+	if s.clock == nil {
+		s.clock = SystemClock
+	}
+	s.faults = config.Faults
+	if s.faults == nil {
+		s.faults = NoFaults{}
+	}
+	if config.TLS != nil {
+		tlsConfig, err := NewTLSConfig(s.Logger, s.clock, config.TLS, s.ShutdownStartedChan())
+		if err != nil {
+			return nil, err
+		}
+		if config.HTTP2 {
+			tlsConfig.NextProtos = append([]string{http2.NextProtoTLS}, tlsConfig.NextProtos...)
+		}
+		s.httpServer.TLSConfig = tlsConfig
+	}
+	s.authLimiter = NewAuthLimiter(
+		s.Logger,
+		s.stats,
+		s.clock,
+		config.AuthMaxDelay,
+		config.AuthLockoutThreshold,
+		config.AuthLockoutDuration)
+	if config.Bandwidth > 0 {
+		s.bandwidth = NewBandwidthScheduler(s.stats, s.clock, config.Bandwidth)
+		s.bandwidthWeights = config.BandwidthWeights
+	}
+	s.sessionBufferLimit = config.SessionBufferLimit
+	s.channelTap = config.ChannelTap
+	if config.RecordingSink != nil {
+		s.channelTap = CombineChannelTaps(NewRecordingTap(s.Logger, s.clock, config.RecordingSink), s.channelTap)
+	}
+	if config.MemoryWatermark > 0 {
+		s.memoryWatermark = NewMemoryWatermark(config.MemoryWatermark, s.clock)
+	}
+	s.sessionBufferRejectsStat = s.stats.Counter(
+		"chisel_channel_admission_rejections_total",
+		"Number of new channels refused because the server was short of memory",
+		StatLabels{"reason": "session_buffer"})
+	s.memoryWatermarkRejectsStat = s.stats.Counter(
+		"chisel_channel_admission_rejections_total",
+		"Number of new channels refused because the server was short of memory",
+		StatLabels{"reason": "memory_watermark"})
+	s.userSessionRejectsStat = s.stats.Counter(
+		"chisel_user_session_limit_rejections_total",
+		"Number of client sessions refused because their user had the maximum number of sessions",
+		nil)
+	s.userSessionEvictionsStat = s.stats.Counter(
+		"chisel_user_session_evictions_total",
+		"Number of client sessions shut down to make room for a newer session of the same user",
+		nil)
+	limitWarner, err := NewLimitWarner(s.Logger, s.stats, s.clock, config.LimitWarnPercent, config.LimitWarnWebhook)
+	if err != nil {
+		return nil, err
+	}
+	s.limitWarner = limitWarner
+	if config.StateFile != "" {
+		reservationTime := config.StateReservationTime
+		if reservationTime <= 0 {
+			reservationTime = DefaultStateReservationTime
+		}
+		if s.state, err = LoadServerState(s.Logger, s.clock, config.StateFile, reservationTime); err != nil {
+			return nil, err
+		}
+	}
+	s.aclRevokeGrace = config.ACLRevokeGrace
+	s.statsUpdateInterval = config.StatsUpdateInterval
+	s.sessionLogLines = config.SessionLogLines
+	s.aclRevokedChannelsStat = s.stats.Counter(
+		"chisel_acl_revoked_channels_total",
+		"Number of session channels removed because their user's access list no longer allowed them",
+		nil)
+	s.aclRevokedSessionsStat = s.stats.Counter(
+		"chisel_acl_revoked_sessions_total",
+		"Number of client sessions shut down because their user was deleted",
+		nil)
+	s.socksDenialsStat = s.stats.Counter(
+		"chisel_socks_access_denials_total",
+		"Number of SOCKS5 requests refused because the destination was not in the user's access list",
+		nil)
+	s.listenerTakeoversStat = s.stats.Counter(
+		"chisel_listener_takeovers_total",
+		"Number of reverse listeners handed from one session to another of the same user",
+		nil)
+	s.decisions = NewDecisionCache(s.stats, s.clock, config.DecisionCacheTTL)
+	if config.ChannelPolicyFile != "" {
+		policy, err := LoadChannelPolicy(s.Logger, config.ChannelPolicyFile)
+		if err != nil {
+			return nil, err
+		}
+		policy.OnReload(s.decisions.Flush)
+		s.channelPolicy = policy
+		s.channelPolicyDenialsStat = s.stats.Counter(
+			"chisel_channel_policy_denials_total",
+			"Number of channels refused by the channel policy",
+			nil)
+	}
+	if config.ChannelAliasesFile != "" {
+		aliases, err := LoadChannelAliases(s.Logger, config.ChannelAliasesFile)
+		if err != nil {
+			return nil, err
+		}
+		s.aliases = aliases
+	}
+	for _, r := range config.IdentRules {
+		rule, err := ParseIdentRule(r)
+		if err != nil {
+			return nil, s.Errorf("%s", err)
+		}
+		s.identRules = append(s.identRules, rule)
+	}
 	s.users = NewUserIndex(s.Logger)
+	s.users.OnChange(func() {
+		s.usersChanged(s.users)
+		s.provisionedUsersChanged()
+	})
 	if config.AuthFile != "" {
 		if err := s.users.LoadUsers(config.AuthFile); err != nil {
 			return nil, err
@@ -77,6 +574,68 @@ func NewServer(config *ProxyServerConfig) (*Server, error) {
 			s.users.AddUser(u)
 		}
 	}
+	switch config.DuplicateSessions {
+	case "", DuplicateSessionAllow:
+		s.duplicateSessions = DuplicateSessionAllow
+	case DuplicateSessionReject, DuplicateSessionReplace:
+		s.duplicateSessions = config.DuplicateSessions
+	default:
+		return nil, s.Errorf("Invalid duplicate session policy '%s'; expected allow, reject or replace", config.DuplicateSessions)
+	}
+	s.sessionNameRejectsStat = s.stats.Counter(
+		"chisel_session_name_rejections_total",
+		"Number of client sessions refused because another session of their user had the same name",
+		nil)
+	s.sessionNameReplacementsStat = s.stats.Counter(
+		"chisel_session_name_replacements_total",
+		"Number of client sessions shut down for a newer session of their user with the same name",
+		nil)
+	if config.ReversePortRange != "" {
+		var err error
+		s.reversePortMin, s.reversePortMax, err = ParsePortRange(config.ReversePortRange)
+		if err != nil {
+			return nil, s.Errorf("Invalid reverse port range: %s", err)
+		}
+		s.reversePortSubstitutionsStat = s.stats.Counter(
+			"chisel_reverse_port_substitutions_total",
+			"Number of reverse stub listeners that listened on a port from the reverse port range because the requested port was unavailable",
+			nil)
+	}
+	if len(config.Tenants) > 0 {
+		if config.TLS == nil {
+			return nil, s.Errorf("Tenants require TLS")
+		}
+		if err := s.loadTenants(config.Tenants); err != nil {
+			return nil, err
+		}
+	}
+	if config.Provision {
+		if config.TLS == nil || config.TLS.ClientCAFile == "" {
+			return nil, s.Errorf("Provisioning users requires a TLS client CA")
+		}
+		s.provision = true
+		s.provisionAddrs = []*regexp.Regexp{}
+		if config.ProvisionACL != "" {
+			addrs, err := ParseUserAddrs([]string{config.ProvisionACL})
+			if err != nil {
+				return nil, s.Errorf("Invalid provisioning ACL '%s': %s", config.ProvisionACL, err)
+			}
+			s.provisionAddrs = addrs
+		}
+		s.provisionedUsersStat = s.stats.Counter(
+			"chisel_users_provisioned_total",
+			"Number of users provisioned automatically from TLS client certificates",
+			nil)
+		s.provisionedUsers = make(map[string]struct{})
+		if config.ProvisionFile != "" {
+			s.provisionFile = config.ProvisionFile
+			if err := s.loadProvisionedUsers(); err != nil {
+				return nil, err
+			}
+		}
+	} else if config.ProvisionFile != "" {
+		return nil, s.Errorf("A provisioning file requires provisioning")
+	}
 	//generate private key (optionally using seed)
 	key, _ := GenerateKey(config.KeySeed)
 	//convert into ssh.PrivateKey
@@ -91,7 +650,27 @@ func NewServer(config *ProxyServerConfig) (*Server, error) {
 		ServerVersion:    "SSH-" + ProtocolVersion + "-server",
 		PasswordCallback: s.authUser,
 	}
+	s.sshConfig.RekeyThreshold = uint64(config.RekeyThreshold)
 	s.sshConfig.AddHostKey(private)
+	if config.ReconnectTokenTTL > 0 {
+		maxAge := config.ReconnectTokenMaxAge
+		if maxAge <= 0 {
+			maxAge = DefaultReconnectTokenMaxAge
+		}
+		s.reconnectTokens = NewReconnectTokenIssuer(key, config.ReconnectTokenTTL, maxAge, s.clock)
+	}
+	s.heldChannels = NewHeldChannels(s.Logger, s.stats, s.clock)
+	s.sessionDrainTimeout = config.SessionDrainTimeout
+	if s.sessionDrainTimeout <= 0 {
+		s.sessionDrainTimeout = DefaultSessionDrainTimeout
+	}
+	if config.MaxSessionAge > 0 {
+		s.maxSessionAge = config.MaxSessionAge
+		s.sessionMaxAgeStat = s.stats.Counter(
+			"chisel_session_max_age_reconnects_total",
+			"Number of client sessions asked to reconnect because they reached the maximum session age",
+			nil)
+	}
 	//setup reverse proxy
 	if config.Proxy != "" {
 		u, err := url.Parse(config.Proxy)
@@ -102,21 +681,116 @@ func NewServer(config *ProxyServerConfig) (*Server, error) {
 			return nil, s.Errorf("Missing protocol (%s)", u)
 		}
 		s.reverseProxy = httputil.NewSingleHostReverseProxy(u)
-		//always use proxy host
+		//use proxy host unless the client's is preserved. httputil.ReverseProxy
+		//itself appends the client's address to X-Forwarded-For, and removes
+		//hop-by-hop headers (including those named by Connection) both ways.
 		s.reverseProxy.Director = func(r *http.Request) {
+			if config.ProxyForwardedHeaders {
+				proto := "http"
+				if r.TLS != nil {
+					proto = "https"
+				}
+				r.Header.Set("X-Forwarded-Proto", proto)
+				r.Header.Set("X-Forwarded-Host", r.Host)
+			}
 			r.URL.Scheme = u.Scheme
 			r.URL.Host = u.Host
-			r.Host = u.Host
+			if !config.ProxyPreserveHost {
+				r.Host = u.Host
+			}
+		}
+	}
+	s.noStatus = config.NoStatus
+	s.statusToken = config.StatusToken
+	if config.RequireHeader != "" {
+		s.requireHeaderName, s.requireHeaderValue, err = ParseHTTPHeader(config.RequireHeader)
+		if err != nil {
+			return nil, s.Errorf("Invalid required header: %s", err)
+		}
+	}
+	if config.UpgradeKey != "" {
+		skew := config.UpgradeTokenSkew
+		if skew <= 0 {
+			skew = DefaultUpgradeTokenSkew
+		}
+		s.upgradeTokens = NewUpgradeTokens(config.UpgradeKey, skew, s.clock)
+	}
+	if config.CamouflageFile != "" {
+		s.camouflage, err = LoadCamouflage(config.CamouflageFile)
+		if err != nil {
+			return nil, err
+		}
+		for p, resp := range s.camouflage.Paths {
+			if resp.Proxy && s.reverseProxy == nil {
+				return nil, s.Errorf("Camouflage path '%s' is proxied, but there is no --proxy", p)
+			}
+		}
+		if s.reverseProxy != nil && len(s.camouflage.Headers) > 0 {
+			//the camouflage headers replace the proxied server's
+			s.reverseProxy.ModifyResponse = func(resp *http.Response) error {
+				for name := range s.camouflage.Headers {
+					resp.Header.Del(name)
+				}
+				return nil
+			}
+		}
+	}
+	if config.DialSource != "" {
+		s.dialSource, err = ParseDialSource(config.DialSource)
+		if err != nil {
+			return nil, err
 		}
+		s.ILogf("Connecting to targets from %s", s.dialSource)
+	}
+	if err := checkMaxMessage(config.WebSocketMaxMessage); err != nil {
+		return nil, err
+	}
+	if config.UnixSocketDirs != "" {
+		s.unixSocketDirs, err = ParseUnixSocketDirs(config.UnixSocketDirs)
+		if err != nil {
+			return nil, err
+		}
+		s.ILogf("Unix socket targets restricted to %s", s.unixSocketDirs)
+	}
+	if len(config.ExecCommands) > 0 {
+		s.execCommands, err = ParseExecCommands(config.ExecCommands)
+		if err != nil {
+			return nil, err
+		}
+		s.ILogf("Exec commands: %s", s.execCommands)
+	}
+	if config.PAC != "" {
+		s.pacRules, err = ParsePACRules(config.PAC)
+		if err != nil {
+			return nil, err
+		}
+		s.ILogf("Serving /proxy.pac for %s", s.pacRules)
 	}
 	//setup socks server (not listening on any port!)
 	if config.Socks5 {
 		socksConfig := &socks5.Config{}
+		if s.dialSource != nil {
+			socksConfig.Dial = s.dialSource.DialContext
+		}
 		if s.GetLogLevel() >= LogLevelDebug {
-			socksConfig.Logger = log.New(os.Stdout, "[socks]", log.Ldate|log.Ltime)
+			socksConfig.Logger = log.New(os.Stderr, "[socks]", log.Ldate|log.Ltime)
 		} else {
 			socksConfig.Logger = log.New(ioutil.Discard, "", 0)
 		}
+		switch config.Socks5Resolver {
+		case "":
+		case SocksResolveOnClient:
+			s.socksResolveOnClient = true
+			s.ILogf("SOCKS5 host names will be resolved by clients")
+		default:
+			resolver, err := NewDNSServerResolver(config.Socks5Resolver)
+			if err != nil {
+				return nil, err
+			}
+			socksConfig.Resolver = resolver
+			s.ILogf("SOCKS5 host names will be resolved by DNS server %s", config.Socks5Resolver)
+		}
+		s.socksConfig = socksConfig
 		s.socksServer, err = socks5.New(socksConfig)
 		if err != nil {
 			return nil, err
@@ -125,30 +799,86 @@ func NewServer(config *ProxyServerConfig) (*Server, error) {
 	}
 	//setup socks server (not listening on any port!)
 	if config.NoLoop {
+		if config.LoopServer != nil {
+			return nil, fmt.Errorf("%s: A shared loop server cannot be used with loop endpoints disabled", s.Logger.Prefix())
+		}
 		s.ILogf("Loop server disabled")
+	} else if config.LoopServer != nil {
+		s.loopServer = config.LoopServer
 	} else {
 		s.loopServer, err = NewLoopServer(s.Logger)
 		if err != nil {
 			return nil, fmt.Errorf("%s: Could not create loopback server: %s", s.Logger.Prefix(), err)
 		}
 	}
+	if config.Observe {
+		s.observations = NewObservationHub(s.Logger, s.stats)
+		s.ILogf("Channel observation enabled")
+	}
+	for _, bridge := range config.LoopBridges {
+		chd, err := ParseLoopBridge(bridge)
+		if err != nil {
+			return nil, err
+		}
+		b, err := NewLoopBridge(s.Logger, s.loopServer, s.stats, s.clock, s.dialSource, chd)
+		if err != nil {
+			return nil, err
+		}
+		s.loopBridges = append(s.loopBridges, b)
+	}
 
 	//print when reverse tunnelling is enabled
 	if config.Reverse {
 		s.ILogf("Reverse tunnelling enabled")
 	}
+	if config.MetricsAddr != "" {
+		s.metricsServer = NewHTTPServer(s.Logger.Fork("metrics"))
+	}
+	if config.AdminAddr != "" {
+		s.adminServer, err = NewAdminServer(s.Logger, s, config.AdminAddr, config.AdminToken)
+		if err != nil {
+			return nil, err
+		}
+	}
 	return s, nil
 }
 
 // Run is responsible for starting the chisel service
 func (s *Server) Run(ctx context.Context, host, port string) error {
-	err := s.DoOnceActivate(
+	err := s.activate(ctx, host+":"+port, os.Stdout)
+	if err != nil {
+		return err
+	}
+
+	s.httpServer.ListenAndServe(ctx, host+":"+port, s.httpHandler)
+
+	return s.Close()
+}
+
+// RunStdio runs the chisel service for exactly one client connection over stdin and stdout,
+// e.g. when started by inetd or as an sshd ForceCommand. It returns once the connection has
+// closed.
+func (s *Server) RunStdio(ctx context.Context) error {
+	err := s.activate(ctx, "stdio", os.Stderr)
+	if err != nil {
+		return err
+	}
+
+	s.httpServer.ServeConn(ctx, NewStdioConn(), s.httpHandler)
+
+	return s.Close()
+}
+
+// activate prepares the chisel service to serve clients on the described listener. In debug
+// mode, requests are logged to requestLog.
+func (s *Server) activate(ctx context.Context, listenerDesc string, requestLog io.Writer) error {
+	return s.DoOnceActivate(
 		func() error {
 			s.ShutdownOnContext(ctx)
 
 			s.ILogf("Fingerprint %s", s.fingerprint)
 
-			if s.users.Len() > 0 {
+			if s.authEnabled() {
 				s.ILogf("User authentication enabled")
 			}
 
@@ -156,36 +886,86 @@ func (s *Server) Run(ctx context.Context, host, port string) error {
 				s.ILogf("Reverse proxy enabled")
 			}
 
-			s.ILogf("Listening on %s:%s...", host, port)
+			if s.httpServer.TLSConfig != nil {
+				s.ILogf("Listening with TLS on %s...", listenerDesc)
+			} else {
+				s.ILogf("Listening on %s...", listenerDesc)
+			}
+
+			s.pollServer = NewPollServer(s.Logger, s.clock, func(conn net.Conn, r *http.Request) {
+				s.handleTransport(ctx, conn, r.TLS)
+			})
 
 			h := http.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 				s.handleClientHandler(ctx, w, r)
 			}))
 
 			if s.GetLogLevel() >= LogLevelDebug {
-				h = requestlog.Wrap(h)
+				h = requestlog.WrapWith(h, requestlog.Options{Writer: requestLog})
+			}
+
+			if s.http2Ok && s.httpServer.TLSConfig == nil {
+				//HTTP/2 over TLS is negotiated by the TLS listener
+				h = h2c.NewHandler(h, &http2.Server{})
 			}
 
 			s.httpHandler = h
 
+			s.AddShutdownChild(s.heldChannels)
+
+			if s.state != nil {
+				go s.stateSaveLoop(ctx)
+			}
+
+			go s.sessionCostLoop(ctx)
+
+			for _, b := range s.loopBridges {
+				s.AddShutdownChild(b)
+				if err := b.Start(ctx); err != nil {
+					return err
+				}
+			}
+
+			if s.metricsServer != nil {
+				if err := s.startMetricsServer(ctx); err != nil {
+					return err
+				}
+			}
+
+			if s.adminServer != nil {
+				s.AddShutdownChild(s.adminServer)
+				if err := s.adminServer.Start(ctx); err != nil {
+					return err
+				}
+			}
+
 			return nil
 		},
 		true,
 	)
+}
 
+// startMetricsServer serves the server's metrics at /metrics on the --metrics-addr listener
+func (s *Server) startMetricsServer(ctx context.Context) error {
+	l, err := net.Listen("tcp", s.config.MetricsAddr)
 	if err != nil {
-		return err
+		return s.Errorf("Unable to listen for metrics on %s: %s", s.config.MetricsAddr, err)
 	}
-
-	s.httpServer.ListenAndServe(ctx, host+":"+port, s.httpHandler)
-
-	return s.Close()
+	s.ILogf("Metrics endpoint listening on %s", l.Addr())
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", s.stats)
+	s.AddShutdownChild(s.metricsServer)
+	go s.metricsServer.serve(ctx, func() (net.Listener, error) { return l, nil }, mux)
+	return nil
 }
 
 // HandleOnceShutdown will be called exactly once, in its own goroutine. It should take completionError
 // as an advisory completion value, actually shut down, then return the real completion value.
 func (s *Server) HandleOnceShutdown(completionErr error) error {
 	s.DLogf("HandleOnceShutdown")
+	for _, session := range s.Sessions() {
+		session.SetChannelCloseReason(&ChannelCloseInfo{Reason: CloseReasonServerDrain, Message: "Server shutting down"})
+	}
 	err := s.httpServer.Close()
 
 	if completionErr == nil {
@@ -194,30 +974,246 @@ func (s *Server) HandleOnceShutdown(completionErr error) error {
 	return completionErr
 }
 
+// stateSaveLoop saves the state of the server's sessions every stateSaveInterval until the
+// server starts shutting down. The state is not saved during shutdown, when sessions are
+// closing, so that the state file keeps the sessions to restore after a restart.
+func (s *Server) stateSaveLoop(ctx context.Context) {
+	for {
+		select {
+		case <-s.clock.After(stateSaveInterval):
+		case <-s.ShutdownStartedChan():
+			return
+		case <-ctx.Done():
+			return
+		}
+		if s.IsStartedShutdown() {
+			return
+		}
+		if err := s.state.Save(s.Sessions()); err != nil {
+			s.ILogf("%s", err)
+		}
+	}
+}
+
+// GetStatsRegistry returns the server's shared StatsRegistry
+func (s *Server) GetStatsRegistry() *StatsRegistry {
+	return s.stats
+}
+
+// registerSession adds a client session to the set of active sessions
+func (s *Server) registerSession(session *ServerSSHSession) {
+	s.activeSessionsLock.Lock()
+	s.activeSessions[session.ID()] = session
+	s.activeSessionsLock.Unlock()
+}
+
+// unregisterSession removes a client session from the set of active sessions
+func (s *Server) unregisterSession(session *ServerSSHSession) {
+	s.activeSessionsLock.Lock()
+	delete(s.activeSessions, session.ID())
+	s.activeSessionsLock.Unlock()
+}
+
+// admitUserSession counts a newly authenticated session against its user's MaxSessions
+// limit. If the user already has the maximum number of sessions, either the new session is
+// refused, or, if the user has Kick set, the user's oldest sessions are shut down to make room.
+func (s *Server) admitUserSession(session *ServerSSHSession) error {
+	if session.user == nil {
+		return nil
+	}
+	// use the latest limits, which may have changed since the user authenticated
+	user, ok := session.users.Get(session.user.Name)
+	if !ok {
+		user = session.user
+	}
+	s.activeSessionsLock.Lock()
+	var others []*ServerSSHSession
+	if user.MaxSessions > 0 {
+		for _, other := range s.activeSessions {
+			if other != session && other.userAdmitted && other.users == session.users && other.user.Name == user.Name {
+				others = append(others, other)
+			}
+		}
+	}
+	var evicted []*ServerSSHSession
+	if user.MaxSessions > 0 && len(others) >= user.MaxSessions {
+		if !user.Kick {
+			s.activeSessionsLock.Unlock()
+			s.userSessionRejectsStat.Inc()
+			return fmt.Errorf("User \"%s\" already has the maximum of %d sessions", user.Name, user.MaxSessions)
+		}
+		sort.Slice(others, func(i, j int) bool {
+			return others[i].ID() < others[j].ID()
+		})
+		evicted = others[:len(others)-user.MaxSessions+1]
+		for _, other := range evicted {
+			other.userAdmitted = false
+		}
+	}
+	session.userAdmitted = true
+	s.activeSessionsLock.Unlock()
+
+	if user.MaxSessions > 0 {
+		used := int64(len(others) - len(evicted) + 1)
+		s.limitWarner.Check(user.Name, session.ID(), LimitSessions, used, int64(user.MaxSessions))
+	}
+
+	for _, other := range evicted {
+		s.ILogf("Evicting session #%d of user \"%s\" for new session #%d", other.ID(), user.Name, session.ID())
+		s.userSessionEvictionsStat.Inc()
+		other.SetChannelCloseReason(&ChannelCloseInfo{Reason: CloseReasonPolicyRevoked, Message: "Session evicted by a newer session"})
+		other.StartShutdown(fmt.Errorf("Session evicted by a newer session of user \"%s\"", user.Name))
+	}
+	return nil
+}
+
+// servePAC serves /proxy.pac, which routes the server's PAC destinations through the SOCKS
+// stubs of the reverse remotes of all active sessions. A stub listening on all interfaces is
+// given the host name the request was sent to.
+func (s *Server) servePAC(w http.ResponseWriter, r *http.Request) {
+	host, _, err := net.SplitHostPort(r.Host)
+	if err != nil {
+		host = r.Host
+	}
+	var proxyAddrs []string
+	for _, session := range s.Sessions() {
+		for _, addr := range session.socksStubAddrs() {
+			proxyAddrs = append(proxyAddrs, pacProxyAddr(addr, host))
+		}
+	}
+	w.Header().Set("Content-Type", PACContentType)
+	w.Write([]byte(s.pacRules.Generate(proxyAddrs)))
+}
+
+// Sessions returns all active client sessions, ordered by session ID
+func (s *Server) Sessions() []*ServerSSHSession {
+	s.activeSessionsLock.Lock()
+	result := make([]*ServerSSHSession, 0, len(s.activeSessions))
+	for _, session := range s.activeSessions {
+		result = append(result, session)
+	}
+	s.activeSessionsLock.Unlock()
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].ID() < result[j].ID()
+	})
+	return result
+}
+
+// GetSession returns the active client session with the given ID
+func (s *Server) GetSession(id int32) (*ServerSSHSession, bool) {
+	s.activeSessionsLock.Lock()
+	defer s.activeSessionsLock.Unlock()
+	session, ok := s.activeSessions[id]
+	return session, ok
+}
+
+// SetDraining stops (if draining is true) or resumes accepting new client sessions. Returns
+// the number of currently active sessions.
+func (s *Server) SetDraining(draining bool) int {
+	s.activeSessionsLock.Lock()
+	defer s.activeSessionsLock.Unlock()
+	if draining != s.draining {
+		if draining {
+			s.ILogf("Draining; new client sessions will be refused")
+		} else {
+			s.ILogf("No longer draining; accepting new client sessions")
+		}
+	}
+	s.draining = draining
+	return len(s.activeSessions)
+}
+
+// IsDraining returns true if the server is refusing new client sessions
+func (s *Server) IsDraining() bool {
+	s.activeSessionsLock.Lock()
+	defer s.activeSessionsLock.Unlock()
+	return s.draining
+}
+
+// GetUsers returns the server's user index
+func (s *Server) GetUsers() *UserIndex {
+	return s.users
+}
+
 // GetFingerprint is used to access the server fingerprint
 func (s *Server) GetFingerprint() string {
 	return s.fingerprint
 }
 
+// errAuthFailed is returned for every rejected login, so that the peer cannot tell
+// unknown users, wrong passwords and lockouts apart
+var errAuthFailed = errors.New("Invalid authentication")
+
 // authUser is responsible for validating the ssh user / password combination
 func (s *Server) authUser(c ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+	return s.authTenantUser("", s.users, c, password)
+}
+
+// authTenantUser validates the ssh user / password combination against the users of a
+// tenant, or the server's own users if tenant is "". Tenants always require authentication.
+func (s *Server) authTenantUser(tenant string, users *UserIndex, c ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
 	// check if user authenication is enable and it not allow all
-	if s.users.Len() == 0 {
+	if tenant == "" && !s.authEnabled() {
 		return nil, nil
 	}
-	// check the user exists and has matching password
+	// refuse attempts from locked out usernames and addresses
 	n := c.User()
-	user, found := s.users.Get(n)
-	if !found || user.Pass != string(password) {
-		s.DLogf("Login failed for user: %s", n)
-		return nil, errors.New("Invalid authentication for username: %s")
+	limiterName := tenantUserName(tenant, n)
+	ip := c.RemoteAddr().String()
+	if host, _, err := net.SplitHostPort(ip); err == nil {
+		ip = host
+	}
+	if err := s.authLimiter.Check(limiterName, ip); err != nil {
+		s.DLogf("Login refused for user %s from %s: %s", n, ip, err)
+		return nil, errAuthFailed
+	}
+	// a valid reconnection token for an existing user is accepted in place of the password.
+	// The session learns the time of the original login, to which its new tokens are bound.
+	if s.reconnectTokens != nil && IsReconnectToken(string(password)) {
+		if user, found := users.Get(n); found {
+			login, err := s.reconnectTokens.Verify(string(password), limiterName, user.TokenGeneration)
+			if err == nil {
+				s.DLogf("Login for user %s from %s using reconnection token", n, ip)
+				s.authLimiter.Success(limiterName, ip)
+				s.sessions.Set(string(c.SessionID()), user)
+				return &ssh.Permissions{Extensions: map[string]string{
+					reconnectTokenLoginExtension: strconv.FormatInt(login.Unix(), 10),
+				}}, nil
+			}
+			s.DLogf("Reconnection token rejected for user %s from %s: %s", n, ip, err)
+		}
 	}
+	// check the user exists and has matching password. Unknown users are checked
+	// against a dummy password so that they take as long to reject as known users.
+	// Users that log in with a TLS client certificate, such as provisioned users, and
+	// users without a password never log in with a password.
+	user, found := users.Get(n)
+	checkUser := user
+	if !found {
+		checkUser = dummyUser
+	}
+	if !checkUser.CheckPassword(password) || !found || user.CertAuth || user.Pass == "" {
+		delay := s.authLimiter.Failure(limiterName, ip)
+		s.DLogf("Login failed for user %s from %s; delaying %s", n, ip, delay)
+		<-s.clock.After(delay)
+		return nil, errAuthFailed
+	}
+	s.authLimiter.Success(limiterName, ip)
 	// insert the user session map
 	// @note: this should probably have a lock on it given the map isn't thread-safe??
 	s.sessions.Set(string(c.SessionID()), user)
 	return nil, nil
 }
 
+// takeSessionUser removes and returns the user that authenticated the SSH session with ID
+// sid, or nil if the session did not authenticate as a user. Tenant users are looked up here
+// too, so this does not depend on whether the server has users of its own.
+func (s *Server) takeSessionUser(sid string) *User {
+	user, _ := s.sessions.Get(sid)
+	s.sessions.Del(sid)
+	return user
+}
+
 // AddUser adds a new user into the server user index
 func (s *Server) AddUser(user, pass string, addrs ...string) error {
 	authorizedAddrs := make([]*regexp.Regexp, 0)
@@ -233,10 +1229,12 @@ func (s *Server) AddUser(user, pass string, addrs ...string) error {
 
 	u := &User{Name: user, Pass: pass, Addrs: authorizedAddrs}
 	s.users.AddUser(u)
+	s.users.Changed()
 	return nil
 }
 
 // DeleteUser removes a user from the server user index
 func (s *Server) DeleteUser(user string) {
 	s.users.Del(user)
+	s.users.Changed()
 }
@@ -0,0 +1,96 @@
+package chshare
+
+import (
+	"net"
+	"testing"
+)
+
+// testConnMetadata is the ssh.ConnMetadata of a client logging in from 192.0.2.1
+type testConnMetadata struct {
+	user      string
+	sessionID string
+}
+
+func (m *testConnMetadata) User() string          { return m.user }
+func (m *testConnMetadata) SessionID() []byte     { return []byte(m.sessionID) }
+func (m *testConnMetadata) ClientVersion() []byte { return []byte("SSH-2.0-test") }
+func (m *testConnMetadata) ServerVersion() []byte { return []byte("SSH-2.0-test") }
+func (m *testConnMetadata) RemoteAddr() net.Addr {
+	return &net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 40000}
+}
+func (m *testConnMetadata) LocalAddr() net.Addr {
+	return &net.TCPAddr{IP: net.ParseIP("192.0.2.2"), Port: 8080}
+}
+
+// newTestAuthServer returns a server with just enough set up to authenticate users, without
+// failure delays or lockouts
+func newTestAuthServer() *Server {
+	logger := NewLogger("test", LogLevelError)
+	s := &Server{
+		sessions: NewUsers(),
+		clock:    SystemClock,
+	}
+	s.InitShutdownHelper(logger, s)
+	s.users = NewUserIndex(logger)
+	s.authLimiter = NewAuthLimiter(logger, NewStatsRegistry(), SystemClock, 0, 0, 0)
+	return s
+}
+
+func TestAuthRejectsEmptyPasswordForProvisionedUser(t *testing.T) {
+	s := newTestAuthServer()
+	s.provision = true
+	s.users.AddUser(&User{Name: "alice", CertAuth: true})
+	if _, err := s.authTenantUser("", s.users, &testConnMetadata{user: "alice", sessionID: "1"}, []byte("")); err == nil {
+		t.Fatalf("empty password accepted for provisioned user")
+	}
+	if _, found := s.sessions.Get("1"); found {
+		t.Fatalf("rejected login left a session user")
+	}
+}
+
+func TestAuthRejectsPasswordForCertUser(t *testing.T) {
+	s := newTestAuthServer()
+	s.users.AddUser(&User{Name: "bob", Pass: "secret", CertAuth: true})
+	if _, err := s.authTenantUser("", s.users, &testConnMetadata{user: "bob", sessionID: "1"}, []byte("secret")); err == nil {
+		t.Fatalf("password accepted for certificate user")
+	}
+}
+
+func TestAuthRejectsEmptyPassword(t *testing.T) {
+	s := newTestAuthServer()
+	s.users.AddUser(&User{Name: "carol"})
+	if _, err := s.authTenantUser("", s.users, &testConnMetadata{user: "carol", sessionID: "1"}, []byte("")); err == nil {
+		t.Fatalf("empty password accepted for user without a password")
+	}
+}
+
+func TestAuthAcceptsPassword(t *testing.T) {
+	s := newTestAuthServer()
+	s.users.AddUser(&User{Name: "dave", Pass: "secret"})
+	if _, err := s.authTenantUser("", s.users, &testConnMetadata{user: "dave", sessionID: "1"}, []byte("secret")); err != nil {
+		t.Fatalf("password rejected: %s", err)
+	}
+	if user, found := s.sessions.Get("1"); !found || user.Name != "dave" {
+		t.Fatalf("login did not record the session user")
+	}
+}
+
+func TestTenantOnlySessionTakesUser(t *testing.T) {
+	s := newTestAuthServer()
+	tenantUsers := NewUserIndex(s.Logger)
+	tenantUsers.AddUser(&User{Name: "erin", Pass: "secret"})
+	s.tenants = map[string]*UserIndex{"acme.example.com": tenantUsers}
+	if s.authEnabled() {
+		t.Fatalf("server without users of its own has authentication enabled")
+	}
+	if _, err := s.authTenantUser("acme.example.com", tenantUsers, &testConnMetadata{user: "erin", sessionID: "1"}, []byte("secret")); err != nil {
+		t.Fatalf("tenant password rejected: %s", err)
+	}
+	user := s.takeSessionUser("1")
+	if user == nil || user.Name != "erin" {
+		t.Fatalf("session did not take the tenant user")
+	}
+	if s.sessions.Len() != 0 {
+		t.Fatalf("session user entry was not removed")
+	}
+}
@@ -2,8 +2,11 @@ package chshare
 
 import (
 	"context"
-	"github.com/gorilla/websocket"
+	"crypto/subtle"
+	"crypto/tls"
+	"fmt"
 	"io"
+	"net"
 	"net/http"
 	"strings"
 )
@@ -15,7 +18,20 @@ func (s *Server) handleClientHandler(ctx context.Context, w http.ResponseWriter,
 	if upgrade == "websocket" {
 		protocol := r.Header.Get("Sec-WebSocket-Protocol")
 		if strings.HasPrefix(protocol, "xevo-chisel-") {
+			if !s.requiredHeaderOk(r) {
+				s.DLogf("Refusing client connection without the required header")
+				s.serveNotFound(w)
+				return
+			}
+			if !s.upgradeTokenOk(r) {
+				s.serveNotFound(w)
+				return
+			}
 			if protocol == ProtocolVersion {
+				if status, rejection := s.checkUpgrade(r); rejection != nil {
+					s.rejectUpgrade(w, status, rejection)
+					return
+				}
 				s.DLogf("Upgrading to websocket, URL tail=\"%s\", protocol=\"%s\"", r.URL.String(), protocol)
 				wsConn, err := upgrader.Upgrade(w, r, nil)
 				if err != nil {
@@ -25,8 +41,7 @@ func (s *Server) handleClientHandler(ctx context.Context, w http.ResponseWriter,
 				}
 
 				go func() {
-					s.handleWebsocket(ctx, wsConn)
-					wsConn.Close()
+					s.handleTransport(ctx, NewWebSocketConn(wsConn), r.TLS)
 				}()
 
 				return
@@ -35,7 +50,89 @@ func (s *Server) handleClientHandler(ctx context.Context, w http.ResponseWriter,
 			s.ILogf("Client connection using unsupported websocket protocol '%s', expected '%s'",
 				protocol, ProtocolVersion)
 
-			http.Error(w, "Not Found", 404)
+			s.rejectUpgrade(w, http.StatusBadRequest, &UpgradeRejection{
+				Reason:  UpgradeRejectedProtocol,
+				Message: fmt.Sprintf("Unsupported protocol '%s'", protocol),
+			})
+			return
+		}
+	} else if strings.HasPrefix(r.Header.Get("Sec-WebSocket-Protocol"), "xevo-chisel-") && s.requiredHeaderOk(r) {
+		//a chisel websocket request whose upgrade was dropped on the way, typically by an
+		//ingress that speaks HTTP/2 to the server, would otherwise get a puzzling 404
+		s.ILogf("Client websocket request from %s arrived over %s without its Upgrade header",
+			r.RemoteAddr, r.Proto)
+		s.rejectUpgrade(w, http.StatusBadRequest, &UpgradeRejection{
+			Reason: UpgradeRejectedUpgradeLost,
+			Message: fmt.Sprintf("The websocket upgrade was lost on the way to the server (which received %s); "+
+				"a proxy or ingress may only speak HTTP/2 to it. Connect with --transport h2 "+
+				"(with chisel server --http2) or --transport poll", r.Proto),
+		})
+		return
+	}
+
+	//HTTP/2 stream transport, for clients behind ingresses that cannot pass on websockets
+	if s.http2Ok && IsStreamRequest(r) {
+		if !s.requiredHeaderOk(r) {
+			s.DLogf("Refusing HTTP/2 stream client without the required header")
+			s.serveNotFound(w)
+			return
+		}
+		if !s.upgradeTokenOk(r) {
+			s.serveNotFound(w)
+			return
+		}
+		s.handleStreamRequest(ctx, w, r)
+		return
+	}
+
+	//long-poll transport, for clients whose websocket upgrades are blocked
+	if IsPollRequest(r) {
+		if !s.requiredHeaderOk(r) {
+			s.DLogf("Refusing long-poll client without the required header")
+			s.serveNotFound(w)
+			return
+		}
+		protocol := r.Header.Get(PollProtocolHeader)
+		if protocol != ProtocolVersion {
+			s.ILogf("Long-poll client using unsupported protocol '%s', expected '%s'",
+				protocol, ProtocolVersion)
+			s.rejectUpgrade(w, http.StatusBadRequest, &UpgradeRejection{
+				Reason:  UpgradeRejectedProtocol,
+				Message: fmt.Sprintf("Unsupported protocol '%s'", protocol),
+			})
+			return
+		}
+		if r.Header.Get(PollActionHeader) == "open" {
+			if !s.upgradeTokenOk(r) {
+				s.serveNotFound(w)
+				return
+			}
+			if status, rejection := s.checkUpgrade(r); rejection != nil {
+				s.rejectUpgrade(w, status, rejection)
+				return
+			}
+		}
+		if !s.pollServer.Handle(w, r) {
+			s.serveNotFound(w)
+		}
+		return
+	}
+
+	//the PAC file is served even when a proxy target is provided, which browsers fetch without credentials
+	if s.pacRules != nil && r.URL.Path == "/proxy.pac" {
+		s.servePAC(w, r)
+		return
+	}
+
+	//camouflage paths take precedence over the proxy and health/version checks
+	if s.camouflage != nil {
+		s.camouflage.SetHeaders(w.Header())
+		if resp := s.camouflage.Lookup(r.URL.Path); resp != nil {
+			if resp.Proxy {
+				s.reverseProxy.ServeHTTP(w, r)
+			} else {
+				s.camouflage.Serve(w, resp)
+			}
 			return
 		}
 	}
@@ -47,32 +144,70 @@ func (s *Server) handleClientHandler(ctx context.Context, w http.ResponseWriter,
 	}
 
 	//no proxy defined, provide access to health/version checks
-	switch r.URL.String() {
-	case "/health":
-		w.Write([]byte("OK\n"))
-		return
-	case "/version":
-		w.Write([]byte(BuildVersion))
-		return
+	if s.statusAllowed(r) {
+		switch r.URL.String() {
+		case "/health":
+			w.Write([]byte("OK\n"))
+			return
+		case "/version":
+			w.Write([]byte(BuildVersion))
+			return
+		}
+	}
+
+	s.serveNotFound(w)
+}
+
+// statusAllowed returns true if a request may be answered by the /health and /version
+// endpoints. Refused requests get the same response as unknown paths, so that scanners cannot
+// tell that the endpoints exist.
+func (s *Server) statusAllowed(r *http.Request) bool {
+	if s.noStatus || (s.camouflage != nil && s.camouflage.HideBuiltin) {
+		return false
+	}
+	if s.statusToken == "" {
+		return true
 	}
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return subtle.ConstantTimeCompare([]byte(token), []byte(s.statusToken)) == 1
+}
 
+// serveNotFound responds to a request that the server does not handle, with the camouflage
+// default response if there is one
+func (s *Server) serveNotFound(w http.ResponseWriter) {
+	if s.camouflage != nil && s.camouflage.Default != nil {
+		s.camouflage.Serve(w, s.camouflage.Default)
+		return
+	}
 	http.Error(w, "Not Found", 404)
 }
 
-// handleWebsocket handles an incoming client request that is intended tois responsible for handling the websocket connection
-// It upgrades . It is guaranteed on return
-//
-func (s *Server) handleWebsocket(ctx context.Context, wsConn *websocket.Conn) {
+// handleTransport runs a client session over a websocket or long-poll transport, and closes
+// the transport when the session ends. tlsState is the state of the client's TLS connection
+// to the server, or nil if it did not use TLS.
+func (s *Server) handleTransport(ctx context.Context, transport net.Conn, tlsState *tls.ConnectionState) {
 	session, err := NewServerSSHSession(s)
 	if err != nil {
 		session.DLogf("Failed to create ServerSSHSession: %s", err)
+		transport.Close()
 		return
 	}
+	session.clientCertName = VerifiedClientCertName(tlsState)
+	session.tenant, session.users = s.tenantFor(tlsState)
+	if session.tenant != "" {
+		session.DLogf("Client connection for tenant %s", session.tenant)
+	}
 	s.AddShutdownChild(session)
+	s.registerSession(session)
+	defer s.unregisterSession(session)
 	session.ShutdownOnContext(ctx)
-	conn := NewWebSocketConn(wsConn)
+	TuneTransport(session.Logger, transport, session.GetWriteScheduler())
+	SetTransportBatchDelay(transport, s.clock, s.config.WebSocketBatchDelay)
+	SetTransportMaxMessage(transport, int(s.config.WebSocketMaxMessage))
+	transport = s.faults.WrapTransport(FaultSideServer, transport)
+	conn := session.GetWriteScheduler().WrapTransport(transport)
 	session.Run(ctx, conn)
-	conn.Close() // closes the websocket too
+	conn.Close() // closes the transport too
 	session.Close()
 }
 
@@ -4,7 +4,11 @@ import (
 	"context"
 	socks5 "github.com/armon/go-socks5"
 	"golang.org/x/crypto/ssh"
+	"io"
 	"net"
+	"sort"
+	"strconv"
+	"sync"
 	"time"
 )
 
@@ -14,14 +18,116 @@ type ServerSSHSession struct {
 
 	// Server is the chisel proxy server on which this session is running
 	server *Server
+
+	// user is the authenticated user for this session, or nil if authentication is not enabled
+	user *User
+
+	// loginTime is the time the user logged in with a password or certificate, for this
+	// session or, if it authenticated with a reconnection token, for an earlier one
+	loginTime time.Time
+
+	// userAdmitted is true once the session has been counted against its user's session
+	// limit. It is protected by the server's activeSessionsLock.
+	userAdmitted bool
+
+	// admittedName is the session's name once it has been admitted under the server's
+	// duplicate session policy, or "". It is protected by the server's activeSessionsLock.
+	admittedName string
+
+	// scheduler prioritizes channel writes to the client
+	scheduler *WriteScheduler
+
+	// channelsLock protects chds, reverseProxies, nextProxyIndex, remoteAddr, clientVersion,
+	// sessionName and deviceFingerprint
+	channelsLock sync.Mutex
+
+	// chds holds the channel descriptors currently configured for this session, by descriptor string
+	chds map[string]*ChannelDescriptor
+
+	// reverseProxies holds the stub listeners for reverse channels, by descriptor string
+	reverseProxies map[string]*TCPProxy
+
+	// nextProxyIndex is the index assigned to the next channel added to the session
+	nextProxyIndex int
+
+	// startTime is the time at which the session was created
+	startTime time.Time
+
+	// remoteAddr is the address of the client, once known
+	remoteAddr string
+
+	// clientVersion is the version of chisel reported by the client, once configured
+	clientVersion string
+
+	// sessionName is the name the client gave the session, once configured
+	sessionName string
+
+	// deviceFingerprint is the fingerprint of the client's verified device key, once
+	// configured, or "" if it presented none
+	deviceFingerprint string
+
+	// clientCertName is the common name of the client's verified TLS client certificate,
+	// or "" if it presented none
+	clientCertName string
+
+	// tenant is the TLS server name of the tenant the session is for, or "" for the server's
+	// own users, and users are the users the session's client authenticates against
+	tenant string
+	users  *UserIndex
+
+	// socksServerOnce guards creation of socksServer
+	socksServerOnce sync.Once
+
+	// socksServer is a session-specific socks5 server that resolves names on the client,
+	// if the server is configured to do so, and checks destinations against the access list
+	// of the session's user
+	socksServer *socks5.Server
+
+	// traffic holds the traffic statistics of the session's channel descriptors
+	traffic *trafficCounter
+
+	// logRing keeps the session's recent log lines, or is nil if they are not kept
+	logRing *LogRing
+
+	// throughput measures the session's recent transport throughput
+	throughput *throughputMeter
+}
+
+// ServerSessionInfo is a summary of a client session, for administrative purposes
+type ServerSessionInfo struct {
+	ID                 int32
+	User               string
+	RemoteAddr         string
+	StartTime          time.Time
+	ClientVersion      string
+	SessionName        string
+	DeviceFingerprint  string
+	ChannelDescriptors []string
 }
 
 // NewServerSSHSession creates a server-side proxy session object
 func NewServerSSHSession(server *Server) (*ServerSSHSession, error) {
 	s := &ServerSSHSession{
-		server: server,
+		server:         server,
+		chds:           make(map[string]*ChannelDescriptor),
+		reverseProxies: make(map[string]*TCPProxy),
+		startTime:      server.clock.Now(),
+		scheduler:      NewWriteScheduler(server.stats, server.clock),
+		users:          server.users,
+		traffic:        newTrafficCounter(),
+	}
+	s.throughput = newThroughputMeter(s.startTime)
+	logger := server.Logger
+	if server.sessionLogLines > 0 {
+		s.logRing = NewLogRing(server.sessionLogLines, LogLevelDebug, server.clock)
+		logger = WithLogRing(logger, s.logRing)
 	}
-	s.InitSSHSession(server.Logger, s)
+	s.InitSSHSession(logger, s)
+	s.RegisterSSHRequestHandler(DynamicChannelsRequestType, s.handleDynamicChannelsRequest)
+	s.RegisterSSHRequestHandler(ListenerTakeoverRequestType, s.handleListenerTakeoverRequest)
+	s.RegisterSSHRequestHandler(LoopNamesRequestType, s.handleLoopNamesRequest)
+	s.RegisterSSHRequestHandler(ObservableChannelsRequestType, s.handleObservableChannelsRequest)
+	s.RegisterSSHRequestHandler(StatsUpdateRequestType, s.handleStatsUpdateRequest)
 	return s, nil
 }
 
@@ -32,15 +138,194 @@ func (s *ServerSSHSession) IsServer() bool {
 	return true
 }
 
+// IsTUNAllowed returns true if the client's remotes may attach to TUN devices on the server
+// (see --tun)
+func (s *ServerSSHSession) IsTUNAllowed() bool {
+	return s.server.config.TUN
+}
+
 // GetLoopServer returns the shared LoopServer if loop protocol is enabled; nil otherwise
 func (s *ServerSSHSession) GetLoopServer() *LoopServer {
 	return s.server.loopServer
 }
 
-// GetSocksServer returns the shared socks5 server if socks protocol is enabled;
-// nil otherwise
+// GetSocksServer returns the socks5 server if socks protocol is enabled; nil otherwise.
+// The server's shared socks5 server is used unless names are to be resolved on the client,
+// or the session has a user whose access list must allow each destination, in which case
+// each session has its own.
 func (s *ServerSSHSession) GetSocksServer() *socks5.Server {
-	return s.server.socksServer
+	if s.server.socksServer == nil || (!s.server.socksResolveOnClient && s.user == nil) {
+		return s.server.socksServer
+	}
+	s.socksServerOnce.Do(func() {
+		socksConfig := *s.server.socksConfig
+		if s.server.socksResolveOnClient {
+			socksConfig.Resolver = NewRemoteProxyResolver(s)
+		}
+		if s.user != nil {
+			socksConfig.Rules = &userSocksRules{session: s}
+		}
+		socksServer, err := socks5.New(&socksConfig)
+		if err != nil {
+			// the shared server would not enforce the user's access list
+			s.ILogf("Unable to create SOCKS5 server for session: %s", err)
+			return
+		}
+		s.socksServer = socksServer
+	})
+	return s.socksServer
+}
+
+// GetStatsRegistry returns the server's shared StatsRegistry
+func (s *ServerSSHSession) GetStatsRegistry() *StatsRegistry {
+	return s.server.stats
+}
+
+// GetClock returns the server's Clock
+func (s *ServerSSHSession) GetClock() Clock {
+	return s.server.clock
+}
+
+// GetWriteScheduler returns the session's WriteScheduler
+func (s *ServerSSHSession) GetWriteScheduler() *WriteScheduler {
+	return s.scheduler
+}
+
+// CheckChannelAdmission refuses new channels if this session has more than the server's
+// limit of bytes waiting to be written, or the server's heap is above its memory watermark
+func (s *ServerSSHSession) CheckChannelAdmission() error {
+	if limit := s.server.sessionBufferLimit; limit > 0 {
+		if pending := s.scheduler.PendingBytes(); pending > limit {
+			s.server.sessionBufferRejectsStat.Inc()
+			return NewChannelAdmissionError(
+				"Session has %d bytes waiting to be written, above the limit of %d", pending, limit)
+		}
+	}
+	if s.server.memoryWatermark != nil {
+		if heap, exceeded := s.server.memoryWatermark.Exceeded(); exceeded {
+			s.server.memoryWatermarkRejectsStat.Inc()
+			return NewChannelAdmissionError("Server heap size %d is above its memory watermark", heap)
+		}
+	}
+	return nil
+}
+
+// GetDialAllowlist returns nil; the server does not restrict the skeletons clients may request
+func (s *ServerSSHSession) GetDialAllowlist() *DialAllowlist {
+	return nil
+}
+
+// GetDialSource returns the server's --dial-source, or nil if channels may dial from any
+// address
+func (s *ServerSSHSession) GetDialSource() *DialSource {
+	return s.server.dialSource
+}
+
+// GetDialProxy returns nil; the server connects to targets directly
+func (s *ServerSSHSession) GetDialProxy() *DialProxy {
+	return nil
+}
+
+// GetReconnectQueueTime returns 0; a session's stubs end with its connection
+func (s *ServerSSHSession) GetReconnectQueueTime() time.Duration {
+	return 0
+}
+
+// GetUnixSocketDirs returns the server's --unix-socket-dirs, or nil if clients may ask for
+// any socket
+func (s *ServerSSHSession) GetUnixSocketDirs() *UnixSocketDirs {
+	return s.server.unixSocketDirs
+}
+
+// GetChannelAliases returns the server's --aliases, or nil if none are defined
+func (s *ServerSSHSession) GetChannelAliases() *ChannelAliases {
+	return s.server.aliases
+}
+
+// GetExecCommands returns the server's --exec commands, or nil if none are defined
+func (s *ServerSSHSession) GetExecCommands() *ExecCommands {
+	return s.server.execCommands
+}
+
+// GetE2EKey returns nil; the server only relays end-to-end encrypted channels
+func (s *ServerSSHSession) GetE2EKey() *E2EKey {
+	return nil
+}
+
+// TapChannel offers a channel to the server's ChannelTap, if any. If the session's user
+// requires recording, the channel is recorded whatever its endpoint's record option, and is
+// closed if the server cannot record it.
+func (s *ServerSSHSession) TapChannel(ctx context.Context, ced *ChannelEndpointDescriptor, conn ChannelConn) ChannelConn {
+	tap := CombineChannelTaps(s.server.channelTap, s.server.observations.sessionTap(s.tenant, s.ID()))
+	if s.user != nil {
+		if user, ok := s.users.Get(s.user.Name); ok && user.Record != "" {
+			if s.server.config.RecordingSink == nil {
+				s.ILogf("Closing channel %s: user \"%s\" requires recording, but the server has no --record-dir", ced, user.Name)
+				conn.Close()
+				return conn
+			}
+			record := user.Record
+			inner := tap
+			tap = func(info *ChannelTapInfo) (io.Writer, io.Writer) {
+				info.Record = record
+				return inner(info)
+			}
+		}
+	}
+	return TapChannelConn(ctx, s.Logger, s.server.clock, tap, ced, conn)
+}
+
+// IdentifyChannel sends the session's user and ID to the Called Service of a skeleton
+// endpoint with the "ident" option, or whose target the server's ident rules match. The
+// channel is closed if its target cannot be sent the identity that a rule requires.
+func (s *ServerSSHSession) IdentifyChannel(ced *ChannelEndpointDescriptor, conn ChannelConn) ChannelConn {
+	ident, err := s.server.channelIdent(ced)
+	if err != nil {
+		s.ILogf("Closing channel %s: %s", ced, err)
+		conn.Close()
+		return conn
+	}
+	id := &ChannelIdentity{SessionID: s.ID()}
+	if s.user != nil {
+		id.User = s.user.Name
+	}
+	s.channelsLock.Lock()
+	id.RemoteAddr = s.remoteAddr
+	s.channelsLock.Unlock()
+	return WrapIdentChannelConn(id, ident, conn)
+}
+
+// LimitChannel limits the bytes a channel may transfer to the smaller of its endpoint's
+// "max-bytes" option and its user's channel byte limit. A warning is raised if the channel
+// nears its user's limit.
+func (s *ServerSSHSession) LimitChannel(ced *ChannelEndpointDescriptor, conn ChannelConn) ChannelConn {
+	limit := EndpointMaxBytes(ced)
+	var warnAt int64
+	var warn func(used int64)
+	if s.user != nil {
+		if user, ok := s.users.Get(s.user.Name); ok {
+			limit = minByteLimit(limit, user.MaxChannelBytes)
+			if limit == user.MaxChannelBytes {
+				warnAt = s.server.limitWarner.Threshold(limit)
+				warn = func(used int64) {
+					s.server.limitWarner.Check(user.Name, s.ID(), LimitChannelBytes, used, limit)
+				}
+			}
+		}
+	}
+	return LimitChannelConnWithWarning(conn, limit, warnAt, warn)
+}
+
+// GetHeldChannels returns the server's HeldChannels, so that held forward channels outlive
+// the session
+func (s *ServerSSHSession) GetHeldChannels() *HeldChannels {
+	return s.server.heldChannels
+}
+
+// GetLocalSkeleton returns nil; the skeleton endpoints of a session's reverse channels are
+// always on the client
+func (s *ServerSSHSession) GetLocalSkeleton(ced *ChannelEndpointDescriptor) *ChannelEndpointDescriptor {
+	return nil
 }
 
 // GetSSHConn waits for and returns the main ssh.Conn that this proxy is using to
@@ -52,6 +337,344 @@ func (s *ServerSSHSession) GetSSHConn() (ssh.Conn, error) {
 	return s.sshConn, nil
 }
 
+// Info returns a summary of the session
+func (s *ServerSSHSession) Info() *ServerSessionInfo {
+	s.channelsLock.Lock()
+	defer s.channelsLock.Unlock()
+	info := &ServerSessionInfo{
+		ID:                s.id,
+		RemoteAddr:        s.remoteAddr,
+		StartTime:         s.startTime,
+		ClientVersion:     s.clientVersion,
+		SessionName:       s.sessionName,
+		DeviceFingerprint: s.deviceFingerprint,
+	}
+	if s.user != nil {
+		info.User = s.user.Name
+	}
+	for key := range s.chds {
+		info.ChannelDescriptors = append(info.ChannelDescriptors, key)
+	}
+	sort.Strings(info.ChannelDescriptors)
+	return info
+}
+
+// LogLines returns up to max of the session's most recent log lines, oldest first, or all
+// that are kept if max is 0. Returns nil if the server keeps no session log lines.
+func (s *ServerSSHSession) LogLines(max int) []LogRingLine {
+	if s.logRing == nil {
+		return nil
+	}
+	return s.logRing.Lines(max)
+}
+
+// checkChannelDescriptor verifies that a channel descriptor is permitted for this session
+func (s *ServerSSHSession) checkChannelDescriptor(chd *ChannelDescriptor) error {
+	if err := chd.Validate(); err != nil {
+		return s.DLogErrorf("Invalid channel descriptor: %s", err)
+	}
+	//confirm reverse tunnels are allowed
+	if chd.Reverse && !s.server.reverseOk {
+		return s.DLogErrorf("Reverse port forwarding not enabled on server")
+	}
+	//confirm reverse tunnels may listen on all interfaces
+	if chd.Reverse && !s.server.bindAnyOk && EndpointBindsAny(chd.Stub) {
+		return s.DLogErrorf("Reverse remote \"%s\" listens on all interfaces, which is not enabled on server (see --bind-any)", chd.String())
+	}
+	//confirm the user may observe channels
+	if chd.Skeleton.Type == ChannelEndpointTypeObserve && s.GetChannelObserver() == nil {
+		return s.DLogErrorf("Channel observation is not permitted for \"%s\"", chd.String())
+	}
+	//confirm the alias is defined
+	if chd.Skeleton.Type == ChannelEndpointTypeAlias && !s.server.aliases.Has(chd.Skeleton.Path) {
+		return s.DLogErrorf("Unknown channel alias in \"%s\"", chd.String())
+	}
+	//confirm TUN devices may be used
+	if (chd.Stub.Type == ChannelEndpointTypeTUN || chd.Skeleton.Type == ChannelEndpointTypeTUN) && !s.IsTUNAllowed() {
+		return s.DLogErrorf("TUN endpoints are not enabled on server (see --tun) for \"%s\"", chd.String())
+	}
+	//confirm the command is defined
+	if !chd.Reverse && chd.Skeleton.Type == ChannelEndpointTypeExec && !s.server.execCommands.Has(chd.Skeleton.Path) {
+		return s.DLogErrorf("Unknown exec command in \"%s\"", chd.String())
+	}
+	//confirm the target can be sent the identity the server requires for it
+	if !chd.Reverse {
+		if _, err := s.server.channelIdent(chd.Skeleton); err != nil {
+			return s.DLogErrorf("%s", err)
+		}
+	}
+	//if user is provided, ensure they have
+	//access to the desired remote. The user is looked up again so
+	//that changes to their access list take effect immediately.
+	if s.user != nil {
+		user, ok := s.users.Get(s.user.Name)
+		if !ok {
+			return s.DLogErrorf("User \"%s\" no longer exists", s.user.Name)
+		}
+		chdString := chd.String()
+		if !s.userHasAccess(user, chdString) {
+			return s.DLogErrorf("Access to \"%s\" denied", chdString)
+		}
+	}
+	return nil
+}
+
+// userHasAccess returns true if the session's user, as currently configured, may access addr
+// (see User.HasAccess). Decisions are cached in the server's DecisionCache.
+func (s *ServerSSHSession) userHasAccess(user *User, addr string) bool {
+	key := tenantUserName(s.tenant, user.Name) + "\x00" + addr
+	allowed, _ := s.server.decisions.Decide(decisionKindACL, key, func() (bool, error) {
+		return user.HasAccess(addr), nil
+	})
+	return allowed
+}
+
+// addChannelDescriptor adds a channel to this session, starting a stub listener if it is a
+// reverse channel. Adding a channel that is already configured has no effect.
+func (s *ServerSSHSession) addChannelDescriptor(ctx context.Context, chd *ChannelDescriptor) error {
+	return s.addChannelDescriptors(ctx, []*ChannelDescriptor{chd})[chd.String()]
+}
+
+// addChannelDescriptors adds channels to this session, starting the stub listeners of the
+// reverse channels concurrently, so that a session with many reverse channels is set up
+// quickly. Channels that are already configured are ignored. Returns the error of each
+// channel that could not be added, by descriptor string; the others are added regardless.
+func (s *ServerSSHSession) addChannelDescriptors(ctx context.Context, chds []*ChannelDescriptor) map[string]error {
+	type pendingProxy struct {
+		index int
+		chd   *ChannelDescriptor
+		proxy *TCPProxy
+		err   error
+	}
+	var pending []*pendingProxy
+	seen := make(map[string]bool)
+	s.channelsLock.Lock()
+	for _, chd := range chds {
+		key := chd.String()
+		if _, ok := s.chds[key]; ok || seen[key] {
+			s.DLogf("Route %s already configured; ignoring", key)
+			continue
+		}
+		seen[key] = true
+		i := s.nextProxyIndex
+		s.nextProxyIndex++
+		if chd.Reverse {
+			s.DLogf("Reverse-mode route[%d] %s; starting stub listener", i, key)
+			pending = append(pending, &pendingProxy{index: i, chd: chd})
+		} else {
+			s.DLogf("Forward-mode route[%d] %s; connections will be created on demand", i, key)
+			s.chds[key] = chd
+		}
+	}
+	s.channelsLock.Unlock()
+
+	var wg sync.WaitGroup
+	for _, p := range pending {
+		wg.Add(1)
+		go func(p *pendingProxy) {
+			defer wg.Done()
+			p.proxy, p.err = s.startReverseProxy(ctx, p.index, p.chd)
+		}(p)
+	}
+	wg.Wait()
+
+	errs := make(map[string]error)
+	s.channelsLock.Lock()
+	defer s.channelsLock.Unlock()
+	for _, p := range pending {
+		key := p.chd.String()
+		if p.err != nil {
+			errs[key] = s.DLogErrorf("Unable to start stub listener %s: %s", key, p.err)
+			continue
+		}
+		if _, ok := s.chds[key]; ok {
+			// added by a concurrent request in the meantime
+			p.proxy.Close()
+			continue
+		}
+		s.reverseProxies[key] = p.proxy
+		s.chds[key] = p.chd
+	}
+	return errs
+}
+
+// firstChannelError returns the error of the first of chds that is in errs, or nil
+func firstChannelError(chds []*ChannelDescriptor, errs map[string]error) error {
+	for _, chd := range chds {
+		if err := errs[chd.String()]; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// removeChannelDescriptor removes a channel from this session, closing its stub listener if
+// it is a reverse channel. Removing a channel that is not configured has no effect. Returns
+// the channel's descriptor, or nil if it was not configured.
+func (s *ServerSSHSession) removeChannelDescriptor(chd *ChannelDescriptor) *ChannelDescriptor {
+	s.channelsLock.Lock()
+	defer s.channelsLock.Unlock()
+	key := chd.String()
+	removed, ok := s.chds[key]
+	if !ok {
+		s.DLogf("Route %s not configured; ignoring removal", key)
+		return nil
+	}
+	delete(s.chds, key)
+	if proxy, ok := s.reverseProxies[key]; ok {
+		s.DLogf("Removing reverse-mode route %s; closing stub listener", key)
+		delete(s.reverseProxies, key)
+		proxy.Close()
+	} else {
+		s.DLogf("Removing forward-mode route %s", key)
+	}
+	return removed
+}
+
+// hasChannelDescriptor returns true if a channel is configured on this session
+func (s *ServerSSHSession) hasChannelDescriptor(chd *ChannelDescriptor) bool {
+	s.channelsLock.Lock()
+	defer s.channelsLock.Unlock()
+	_, ok := s.chds[chd.String()]
+	return ok
+}
+
+// descriptorForEndpointLocked returns the descriptor string and channel descriptor of the
+// session's channel with the given endpoint on the server: the stub of a reverse channel,
+// which may listen on a substitute port, or the skeleton of a forward one. Returns "" and nil
+// if there is none. s.channelsLock must be held.
+func (s *ServerSSHSession) descriptorForEndpointLocked(ced *ChannelEndpointDescriptor) (string, *ChannelDescriptor) {
+	target := ced.String()
+	for key, chd := range s.chds {
+		local := chd.Skeleton
+		if chd.Reverse {
+			local = chd.Stub
+			if proxy, ok := s.reverseProxies[key]; ok {
+				local = proxy.chd.Stub
+			}
+		}
+		if local.String() == target {
+			return key, chd
+		}
+	}
+	return "", nil
+}
+
+// descriptorKeyForEndpoint returns the descriptor string of the session's channel with the
+// given endpoint on the server, or "" if there is none
+func (s *ServerSSHSession) descriptorKeyForEndpoint(ced *ChannelEndpointDescriptor) string {
+	s.channelsLock.Lock()
+	defer s.channelsLock.Unlock()
+	key, _ := s.descriptorForEndpointLocked(ced)
+	return key
+}
+
+// handleDynamicChannelsRequest handles a request from the client to add and/or remove channels
+// on the running session. All added channels are validated before any changes are made.
+func (s *ServerSSHSession) handleDynamicChannelsRequest(ctx context.Context, r *ssh.Request) error {
+	failed := func(err error) error {
+		s.sendSSHErrorReply(ctx, r, err)
+		return err
+	}
+
+	c := &DynamicChannelsRequest{}
+	err := c.Unmarshal(r.Payload)
+	if err != nil {
+		return failed(s.DLogErrorf("Invalid dynamic channels request encoding: %s", err))
+	}
+
+	for _, chd := range c.AddChannelDescriptors {
+		if err := s.checkChannelDescriptor(chd); err != nil {
+			return failed(err)
+		}
+	}
+
+	// the change is applied as a unit: if an added channel cannot be started, e.g. because
+	// its port is in use, the channels added so far are removed and the removed ones restored,
+	// so that the client, which only applies the change once it is accepted, stays in step
+	var added []*ChannelDescriptor
+	for _, chd := range c.AddChannelDescriptors {
+		if !s.hasChannelDescriptor(chd) {
+			added = append(added, chd)
+		}
+	}
+	var removed []*ChannelDescriptor
+	for _, chd := range c.RemoveChannelDescriptors {
+		if r := s.removeChannelDescriptor(chd); r != nil {
+			removed = append(removed, r)
+		}
+	}
+
+	if err := firstChannelError(c.AddChannelDescriptors, s.addChannelDescriptors(ctx, c.AddChannelDescriptors)); err != nil {
+		for _, chd := range added {
+			s.removeChannelDescriptor(chd)
+		}
+		if restoreErr := firstChannelError(removed, s.addChannelDescriptors(ctx, removed)); restoreErr != nil {
+			// the session no longer has the channels the client thinks it has
+			s.ILogf("Unable to restore removed channels after a failed update; closing session: %s", restoreErr)
+			s.sendSSHErrorReply(ctx, r, err)
+			s.sshConn.Close()
+			return err
+		}
+		return failed(err)
+	}
+
+	s.ILogf("Channels updated: %d added, %d removed", len(c.AddChannelDescriptors), len(c.RemoveChannelDescriptors))
+
+	payload, err := s.channelsReplyPayload(c.WantReply, c.AddChannelDescriptors, nil)
+	if err != nil {
+		return failed(err)
+	}
+	return s.sendSSHReply(ctx, r, true, payload)
+}
+
+// socksStubAddrs returns the addresses on which the session's reverse SOCKS remotes listen,
+// ordered by descriptor
+func (s *ServerSSHSession) socksStubAddrs() []string {
+	var keys []string
+	s.channelsLock.Lock()
+	for key, proxy := range s.reverseProxies {
+		if isSocksStub(proxy.chd) && proxy.BoundAddr() != "" {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	addrs := make([]string, len(keys))
+	for i, key := range keys {
+		addrs[i] = s.reverseProxies[key].BoundAddr()
+	}
+	s.channelsLock.Unlock()
+	return addrs
+}
+
+// channelsReplyPayload returns the success reply payload for a request that added chds: the
+// serialized ChannelsReply if the client asked for one, or nil otherwise. errs holds the
+// channels that could not be added, if the request allowed partial success.
+func (s *ServerSSHSession) channelsReplyPayload(wantReply bool, chds []*ChannelDescriptor, errs map[string]error) ([]byte, error) {
+	if !wantReply {
+		return nil, nil
+	}
+	reply := &ChannelsReply{BoundAddrs: make(map[string]string), Errors: make(map[string]string)}
+	for key, err := range errs {
+		reply.Errors[key] = err.Error()
+	}
+	s.channelsLock.Lock()
+	for _, chd := range chds {
+		key := chd.String()
+		if proxy, ok := s.reverseProxies[key]; ok {
+			if addr := proxy.BoundAddr(); addr != "" {
+				reply.BoundAddrs[key] = addr
+			}
+		}
+	}
+	s.channelsLock.Unlock()
+	payload, err := reply.Marshal()
+	if err != nil {
+		return nil, s.DLogErrorf("Unable to serialize channels reply: %s", err)
+	}
+	return payload, nil
+}
+
 // startWithSSHConn startss a proxy session runing in the background, given
 // an incoming ssh.ServerConn.
 func (s *ServerSSHSession) startWithSSHConn(
@@ -72,11 +695,27 @@ func (s *ServerSSHSession) startWithSSHConn(
 	s.sshRequests = sshRequests
 
 	// pull the users from the session map
-	var user *User
-	if s.server.users.Len() > 0 {
-		sid := string(sshConn.SessionID())
-		user, _ = s.server.sessions.Get(sid)
-		s.server.sessions.Del(sid)
+	s.user = s.server.takeSessionUser(string(sshConn.SessionID()))
+	s.loginTime = s.server.clock.Now()
+	if sshConn.Permissions != nil {
+		if login, ok := sshConn.Permissions.Extensions[reconnectTokenLoginExtension]; ok {
+			if seconds, err := strconv.ParseInt(login, 10, 64); err == nil {
+				s.loginTime = time.Unix(seconds, 0)
+			}
+		}
+	}
+	md := &RequestMetadata{SessionID: s.ID(), Tenant: s.tenant}
+	if s.user != nil {
+		md.User = s.user.Name
+	}
+	ctx = WithRequestMetadata(ctx, md)
+
+	if s.server.bandwidth != nil {
+		weight := 1
+		if s.user != nil && s.server.bandwidthWeights[s.user.Name] > 0 {
+			weight = s.server.bandwidthWeights[s.user.Name]
+		}
+		s.scheduler.SetBandwidthBucket(s.server.bandwidth.NewBucket(weight))
 	}
 
 	//verify configuration
@@ -112,6 +751,34 @@ func (s *ServerSSHSession) startWithSSHConn(
 		return failed(s.DLogErrorf("Invalid session config request encoding: %s", err))
 	}
 
+	if err := ValidateSessionName(c.SessionName); err != nil {
+		return failed(s.DLogErrorf("%s", err))
+	}
+
+	deviceFingerprint := ""
+	if len(c.DeviceKey) > 0 {
+		deviceKey, err := verifyDeviceProof(sshConn.SessionID(), c.DeviceKey, c.DeviceSignature)
+		if err != nil {
+			return failed(s.DLogErrorf("%s", err))
+		}
+		deviceFingerprint = FingerprintKey(deviceKey)
+		s.ILogf("Device fingerprint is %s", deviceFingerprint)
+	}
+	if s.user != nil {
+		if user, ok := s.users.Get(s.user.Name); ok && !user.HasDevice(deviceFingerprint) {
+			if deviceFingerprint == "" {
+				return failed(s.ILogErrorf("User \"%s\" must connect with a known device key", s.user.Name))
+			}
+			return failed(s.ILogErrorf("Device %s is not allowed for user \"%s\"", deviceFingerprint, s.user.Name))
+		}
+	}
+
+	s.channelsLock.Lock()
+	s.clientVersion = c.Version
+	s.sessionName = c.SessionName
+	s.deviceFingerprint = deviceFingerprint
+	s.channelsLock.Unlock()
+
 	//print if client and server  versions dont match
 	if c.Version != BuildVersion {
 		v := c.Version
@@ -121,39 +788,40 @@ func (s *ServerSSHSession) startWithSSHConn(
 		s.ILogf("WARNING: Chisel Client version (%s) differs from server version (%s)", v, BuildVersion)
 	}
 
-	//confirm reverse tunnels are allowed
-	for _, chd := range c.ChannelDescriptors {
-		if chd.Reverse && !s.server.reverseOk {
-			return failed(s.DLogErrorf("Reverse port forwarding not enabled on server"))
-		}
+	if err := s.server.admitUserSession(s); err != nil {
+		return failed(s.DLogErrorf("%s", err))
 	}
-	//if user is provided, ensure they have
-	//access to the desired remotes
-	if user != nil {
-		for _, chd := range c.ChannelDescriptors {
-			chdString := chd.String()
-			if !user.HasAccess(chdString) {
-				return failed(s.DLogErrorf("Access to \"%s\" denied", chdString))
-			}
+
+	if err := s.server.admitNamedSession(s, c.SessionName); err != nil {
+		return failed(s.DLogErrorf("%s", err))
+	}
+	if c.SessionName != "" {
+		s.ILogf("Session name is %q", c.SessionName)
+	}
+
+	//confirm all channels are permitted before starting any of them
+	for _, chd := range c.ChannelDescriptors {
+		if err := s.checkChannelDescriptor(chd); err != nil {
+			return failed(err)
 		}
 	}
 
 	//set up reverse port forwarding
-	for i, chd := range c.ChannelDescriptors {
-		if chd.Reverse {
-			s.DLogf("Reverse-mode route[%d] %s; starting stub listener", i, chd.String())
-			proxy := NewTCPProxy(s.Logger, s, i, chd)
-			s.AddShutdownChild(proxy)
-			if err := proxy.Start(ctx); err != nil {
-				return failed(s.DLogErrorf("Unable to start stub listener %s: %s", chd.String(), err))
-			}
-		} else {
-			s.DLogf("Forward-mode route[%d] %s; connections will be created on demand", i, chd.String())
+	errs := s.addChannelDescriptors(ctx, c.ChannelDescriptors)
+	if len(errs) > 0 {
+		if !c.AllowPartial || !c.WantReply {
+			return failed(firstChannelError(c.ChannelDescriptors, errs))
 		}
+		s.ILogf("Unable to add %d of %d channels; starting the session without them", len(errs), len(c.ChannelDescriptors))
+	}
+
+	payload, err := s.channelsReplyPayload(c.WantReply, c.ChannelDescriptors, errs)
+	if err != nil {
+		return failed(err)
 	}
 
 	//success!
-	err = s.sendSSHReply(ctx, r, true, nil)
+	err = s.sendSSHReply(ctx, r, true, payload)
 	if err != nil {
 		err = s.DLogErrorf("Failed to send SSH config success response: %s", err)
 		s.StartShutdown(err)
@@ -165,6 +833,18 @@ func (s *ServerSSHSession) startWithSSHConn(
 
 	s.DLogf("SSH session up and running")
 
+	if s.user != nil && s.server.reconnectTokens != nil {
+		go s.reconnectTokenLoop(ctx)
+	}
+
+	if s.server.maxSessionAge > 0 {
+		go s.maxAgeLoop(ctx)
+	}
+
+	if s.server.statsUpdateInterval > 0 {
+		go s.statsUpdateLoop(ctx)
+	}
+
 	go func(){
 		err := sshConn.Wait()
 		s.StartShutdown(err)
@@ -174,6 +854,39 @@ func (s *ServerSSHSession) startWithSSHConn(
 
 
 
+// reconnectTokenLoop hands the client a fresh reconnection token at the start of the session,
+// and again each time half of the token lifetime has passed, until the session ends
+func (s *ServerSSHSession) reconnectTokenLoop(ctx context.Context) {
+	issuer := s.server.reconnectTokens
+	for {
+		if !issuer.CanIssue(s.loginTime) {
+			s.DLogf("Login at %s is too old; not issuing more reconnection tokens", s.loginTime)
+			return
+		}
+		if user, ok := s.users.Get(s.user.Name); !ok {
+			s.DLogf("User \"%s\" no longer exists; not issuing reconnection token", s.user.Name)
+		} else {
+			token := issuer.Issue(tenantUserName(s.tenant, user.Name), user.TokenGeneration, s.loginTime)
+			ok, _, err := s.sshConn.SendRequest(ReconnectTokenRequestType, true, []byte(token))
+			if err != nil {
+				return
+			}
+			if !ok {
+				s.DLogf("Client does not accept reconnection tokens")
+				return
+			}
+			s.DLogf("Issued reconnection token valid for %s", issuer.TTL())
+		}
+		select {
+		case <-s.server.clock.After(issuer.TTL() / 2):
+		case <-s.ShutdownStartedChan():
+			return
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
 // runWithSSHConn runs a proxy session from a client from start to end, given
 // an incoming ssh.ServerConn. On exit, the incoming ssh.ServerConn still
 // needs to be closed.
@@ -200,8 +913,16 @@ func (s *ServerSSHSession) Run(ctx context.Context, conn net.Conn) error {
 	}
 
 	
+	s.channelsLock.Lock()
+	s.remoteAddr = conn.RemoteAddr().String()
+	s.channelsLock.Unlock()
+
+	if d := s.server.faults.HandshakeDelay(FaultSideServer); d > 0 {
+		s.DLogf("Delaying SSH handshake by %s", d)
+		<-s.server.clock.After(d)
+	}
 	s.DLogf("SSH Handshaking...")
-	sshConn, newSSHChannels, sshRequests, err := ssh.NewServerConn(conn, s.server.sshConfig)
+	sshConn, newSSHChannels, sshRequests, err := ssh.NewServerConn(conn, s.server.sshConfigFor(s.tenant, s.users, s.clientCertName))
 	if err != nil {
 		return s.ResumeAndShutdown(s.DLogErrorf("Failed to handshake (%s)", err))
 	}
@@ -0,0 +1,232 @@
+package chshare
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// DefaultStateReservationTime is the default time after a server restart for which the reverse
+// listener ports in its state file are kept for the sessions that had them
+const DefaultStateReservationTime = time.Minute
+
+// stateSaveInterval is how often the server state file is rewritten, if the state has changed
+const stateSaveInterval = 5 * time.Second
+
+// serverStateFile is the content of a server state file
+type serverStateFile struct {
+	Saved    time.Time       `json:"saved"`
+	Sessions []*sessionState `json:"sessions"`
+}
+
+// sessionState is the saved state of a client session
+type sessionState struct {
+	User        string          `json:"user,omitempty"`
+	SessionName string          `json:"session_name,omitempty"`
+	Channels    []*channelState `json:"channels"`
+}
+
+// channelState is the saved state of a channel of a client session. For a reverse channel
+// with a TCP stub, Port is the port its stub listener is on, which differs from the port it
+// asked for if that was unavailable (see --reverse-port-range).
+type channelState struct {
+	Descriptor string `json:"descriptor"`
+	Port       int    `json:"port,omitempty"`
+}
+
+// stateOwner returns the key by which a session's saved state is found again after a
+// restart: its user, qualified by tenant, and its session name
+func stateOwner(user string, sessionName string) string {
+	return user + "\x00" + sessionName
+}
+
+// ServerState persists the minimal state of the server's sessions, their users, names and
+// channels, including the ports of their reverse listeners, to a file. After a restart, the
+// ports in the file are reserved for a while for the sessions that had them, so that clients
+// that reconnect with the same user, session name and remotes get the same ports back, even
+// those that had been given a substitute port, and other sessions cannot take them first.
+type ServerState struct {
+	logger Logger
+	clock  Clock
+	path   string
+
+	// lock protects reservations, reservedPorts and savedSessions
+	lock sync.Mutex
+
+	// reservations holds the saved stub port of each reverse channel, by owner and descriptor
+	// string, and reservedPorts holds the owner of each saved port, until expiry
+	reservations  map[string]int
+	reservedPorts map[int]string
+	expiry        time.Time
+
+	// savedSessions is the JSON of the sessions last written to the file
+	savedSessions []byte
+}
+
+// LoadServerState creates a ServerState that persists to path, and reserves the ports saved
+// in it, if it exists, for reservationTime, as measured by clock
+func LoadServerState(logger Logger, clock Clock, path string, reservationTime time.Duration) (*ServerState, error) {
+	st := &ServerState{
+		logger:        logger.Fork("state"),
+		clock:         clock,
+		path:          path,
+		reservations:  make(map[string]int),
+		reservedPorts: make(map[int]string),
+		expiry:        clock.Now().Add(reservationTime),
+	}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return st, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Unable to read state file: %s", err)
+	}
+	file := &serverStateFile{}
+	if err := json.Unmarshal(data, file); err != nil {
+		return nil, fmt.Errorf("Invalid state file %s: %s", path, err)
+	}
+	for _, session := range file.Sessions {
+		owner := stateOwner(session.User, session.SessionName)
+		for _, channel := range session.Channels {
+			if channel.Port == 0 {
+				continue
+			}
+			st.reservations[owner+"\x00"+channel.Descriptor] = channel.Port
+			st.reservedPorts[channel.Port] = owner
+		}
+	}
+	if len(st.reservedPorts) > 0 {
+		st.logger.ILogf("Reserving %d reverse listener ports saved at %s for %s", len(st.reservedPorts), file.Saved.Format(time.RFC3339), reservationTime)
+	}
+	st.savedSessions, _ = json.Marshal(file.Sessions)
+	return st, nil
+}
+
+// PreviousPort returns the port the stub listener of a reverse channel of the session with
+// the given owner was on before a restart, or 0 if there is none or its reservation has
+// expired. A nil ServerState has no saved ports.
+func (st *ServerState) PreviousPort(owner string, chd *ChannelDescriptor) int {
+	if st == nil {
+		return 0
+	}
+	st.lock.Lock()
+	defer st.lock.Unlock()
+	if st.clock.Now().After(st.expiry) {
+		return 0
+	}
+	return st.reservations[owner+"\x00"+chd.String()]
+}
+
+// ReservedForOther returns true if a port is reserved for a session other than the one with
+// the given owner. A nil ServerState reserves no ports.
+func (st *ServerState) ReservedForOther(owner string, port int) bool {
+	if st == nil {
+		return false
+	}
+	st.lock.Lock()
+	defer st.lock.Unlock()
+	if st.clock.Now().After(st.expiry) {
+		return false
+	}
+	reservedOwner, ok := st.reservedPorts[port]
+	return ok && reservedOwner != owner
+}
+
+// Save writes the state of sessions to the state file, if it has changed. The file is
+// replaced atomically, so that a crash while saving leaves the previous state.
+func (st *ServerState) Save(sessions []*ServerSSHSession) error {
+	file := &serverStateFile{}
+	for _, session := range sessions {
+		if state := session.savedState(); state != nil {
+			file.Sessions = append(file.Sessions, state)
+		}
+	}
+	sessionsJSON, err := json.Marshal(file.Sessions)
+	if err != nil {
+		return err
+	}
+	st.lock.Lock()
+	defer st.lock.Unlock()
+	if bytes.Equal(sessionsJSON, st.savedSessions) {
+		return nil
+	}
+	file.Saved = st.clock.Now()
+	var data bytes.Buffer
+	enc := json.NewEncoder(&data)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(file); err != nil {
+		return err
+	}
+	if err := replaceFile(st.path, data.Bytes()); err != nil {
+		return fmt.Errorf("Unable to save state file: %s", err)
+	}
+	st.savedSessions = sessionsJSON
+	st.logger.DLogf("Saved the state of %d sessions", len(file.Sessions))
+	return nil
+}
+
+// replaceFile replaces the content of a file with data atomically, by writing a temporary
+// file beside it and renaming it over the file
+func replaceFile(path string, data []byte) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+	return err
+}
+
+// stateOwner returns the key of the session's saved state (see stateOwner)
+func (s *ServerSSHSession) stateOwner() string {
+	user := ""
+	if s.user != nil {
+		user = tenantUserName(s.tenant, s.user.Name)
+	}
+	s.channelsLock.Lock()
+	defer s.channelsLock.Unlock()
+	return stateOwner(user, s.sessionName)
+}
+
+// savedState returns the state of the session to save, or nil if it has no channels yet
+func (s *ServerSSHSession) savedState() *sessionState {
+	s.channelsLock.Lock()
+	defer s.channelsLock.Unlock()
+	if len(s.chds) == 0 {
+		return nil
+	}
+	state := &sessionState{SessionName: s.sessionName}
+	if s.user != nil {
+		state.User = tenantUserName(s.tenant, s.user.Name)
+	}
+	for key, chd := range s.chds {
+		channel := &channelState{Descriptor: key}
+		if proxy, ok := s.reverseProxies[key]; ok && chd.Stub.Type == ChannelEndpointTypeTCP {
+			if _, port, err := net.SplitHostPort(proxy.chd.Stub.Path); err == nil {
+				channel.Port, _ = strconv.Atoi(port)
+			}
+		}
+		state.Channels = append(state.Channels, channel)
+	}
+	sort.Slice(state.Channels, func(i, j int) bool {
+		return state.Channels[i].Descriptor < state.Channels[j].Descriptor
+	})
+	return state
+}
@@ -0,0 +1,93 @@
+package chshare
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// ReconnectRequestType is the SSH request type used by the server to ask the client to
+// reconnect, e.g. because the session has reached its maximum age. The client stops opening
+// new channels on the session, lets its existing channels finish, and authenticates again
+// with its password when it reconnects.
+const ReconnectRequestType = "reconnect"
+
+// DefaultSessionDrainTimeout is the default time a session that has reached its maximum age
+// is given for its channels to finish before it is closed
+const DefaultSessionDrainTimeout = time.Minute
+
+// sessionDrainPollInterval is how often a draining session checks for remaining channels
+const sessionDrainPollInterval = 250 * time.Millisecond
+
+// activeChannelCount returns the number of channels currently open on the session, in
+// either direction
+func (s *ServerSSHSession) activeChannelCount() int {
+	n := s.ActiveChannels()
+	s.channelsLock.Lock()
+	for _, proxy := range s.reverseProxies {
+		n += proxy.ActiveConnections()
+	}
+	s.channelsLock.Unlock()
+	return n
+}
+
+// maxAgeLoop waits until the session reaches the server's maximum session age, then asks the
+// client to reconnect, so that credential and access list changes are picked up. The session
+// is closed once its channels have drained, or when the drain timeout expires. A client that
+// does not understand the request is disconnected straight away.
+func (s *ServerSSHSession) maxAgeLoop(ctx context.Context) {
+	maxAge := s.server.maxSessionAge
+	select {
+	case <-s.server.clock.After(s.startTime.Add(maxAge).Sub(s.server.clock.Now())):
+	case <-s.ShutdownStartedChan():
+		return
+	case <-ctx.Done():
+		return
+	}
+	s.server.sessionMaxAgeStat.Inc()
+	s.ILogf("Session reached the maximum age of %s; asking the client to reconnect", maxAge)
+	s.reconnectAndDrain(ctx, fmt.Errorf("Session reached the maximum age of %s", maxAge))
+}
+
+// reconnectAndDrain asks the client to reconnect, then closes the session, with completion
+// error reason, once its channels have drained or when the drain timeout expires. A client
+// that does not understand the request is disconnected straight away.
+func (s *ServerSSHSession) reconnectAndDrain(ctx context.Context, reason error) {
+	ok, _, err := s.sshConn.SendRequest(ReconnectRequestType, true, nil)
+	if err != nil {
+		return
+	}
+	if ok {
+		deadline := s.server.clock.After(s.server.sessionDrainTimeout)
+		last := -1
+	drain:
+		for {
+			n := s.activeChannelCount()
+			if n == 0 {
+				s.DLogf("Session channels drained")
+				break
+			}
+			if n != last {
+				s.DLogf("Waiting for %d channel(s) to drain", n)
+				last = n
+			}
+			select {
+			case <-s.server.clock.After(sessionDrainPollInterval):
+			case <-deadline:
+				s.ILogf("Session channels did not drain within %s; closing them", s.server.sessionDrainTimeout)
+				s.SetChannelCloseReason(&ChannelCloseInfo{
+					Reason:  CloseReasonServerDrain,
+					Message: fmt.Sprintf("Session did not drain within %s", s.server.sessionDrainTimeout),
+				})
+				break drain
+			case <-s.ShutdownStartedChan():
+				return
+			case <-ctx.Done():
+				return
+			}
+		}
+	} else {
+		s.DLogf("Client does not accept reconnect requests")
+	}
+	s.StartShutdown(reason)
+}
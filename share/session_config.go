@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"github.com/XevoInc/chisel/chprotobuf"
 	"github.com/golang/protobuf/proto"
+	"sort"
 )
 
 // SessionConfigRequest describes a chisel proxy/client session configuration. It is
@@ -11,6 +12,24 @@ import (
 type SessionConfigRequest struct {
 	Version            string
 	ChannelDescriptors []*ChannelDescriptor
+
+	// WantReply is true if the client understands a ChannelsReply payload in the
+	// server's success reply
+	WantReply bool
+
+	// SessionName is the name the client gives its session, e.g. a device serial number,
+	// or "" for an unnamed session
+	SessionName string
+
+	// AllowPartial is true if the session may start even if some channels cannot be added.
+	// The channels that failed are listed in the ChannelsReply, so WantReply must be true.
+	AllowPartial bool
+
+	// DeviceKey is the public key of the client's device key, in SSH wire format, and
+	// DeviceSignature its signature of the SSH session ID; both are nil if the client has no
+	// device key
+	DeviceKey       []byte
+	DeviceSignature []byte
 }
 
 // ToPb converts a SessionConfigRequest to its protobuf value
@@ -23,6 +42,11 @@ func (c *SessionConfigRequest) ToPb() *chprotobuf.PbSessionConfigRequest {
 	return &chprotobuf.PbSessionConfigRequest{
 		ClientVersion:      c.Version,
 		ChannelDescriptors: pbcds,
+		WantReply:          c.WantReply,
+		SessionName:        c.SessionName,
+		AllowPartial:       c.AllowPartial,
+		DeviceKey:          c.DeviceKey,
+		DeviceSignature:    c.DeviceSignature,
 	}
 }
 
@@ -34,6 +58,11 @@ func (c *SessionConfigRequest) FromPb(pb *chprotobuf.PbSessionConfigRequest) {
 	for i, pbcd := range pb.ChannelDescriptors {
 		c.ChannelDescriptors[i] = PbToChannelDescriptor(pbcd)
 	}
+	c.WantReply = pb.GetWantReply()
+	c.SessionName = pb.GetSessionName()
+	c.AllowPartial = pb.GetAllowPartial()
+	c.DeviceKey = pb.GetDeviceKey()
+	c.DeviceSignature = pb.GetDeviceSignature()
 }
 
 // PbToSessionConfigRequest returns a SessionConfigRequest from its protobuf value
@@ -46,6 +75,11 @@ func PbToSessionConfigRequest(pb *chprotobuf.PbSessionConfigRequest) *SessionCon
 	return &SessionConfigRequest{
 		Version:            pb.GetClientVersion(),
 		ChannelDescriptors: cds,
+		WantReply:          pb.GetWantReply(),
+		SessionName:        pb.GetSessionName(),
+		AllowPartial:       pb.GetAllowPartial(),
+		DeviceKey:          pb.GetDeviceKey(),
+		DeviceSignature:    pb.GetDeviceSignature(),
 	}
 }
 
@@ -65,3 +99,72 @@ func (c *SessionConfigRequest) Marshal() ([]byte, error) {
 	pbc := c.ToPb()
 	return proto.Marshal(pbc)
 }
+
+// ChannelsReply is the payload of the server's success reply to a session config or dynamic
+// channels request that asked for one. It holds the address actually listened on by the stub
+// of each added reverse channel, keyed by channel descriptor string, which may differ from the
+// requested address, e.g. when a bind hostname is resolved.
+type ChannelsReply struct {
+	BoundAddrs map[string]string
+
+	// Errors holds why each channel that could not be added failed, keyed by channel
+	// descriptor string, if the request allowed partial success
+	Errors map[string]string
+}
+
+// ToPb converts a ChannelsReply to its protobuf value
+func (c *ChannelsReply) ToPb() *chprotobuf.PbChannelsReply {
+	keys := make([]string, 0, len(c.BoundAddrs))
+	for key := range c.BoundAddrs {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	pb := &chprotobuf.PbChannelsReply{}
+	for _, key := range keys {
+		pb.BoundAddrs = append(pb.BoundAddrs, &chprotobuf.PbBoundAddr{
+			ChannelDescriptor: key,
+			Addr:              c.BoundAddrs[key],
+		})
+	}
+	keys = keys[:0]
+	for key := range c.Errors {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		pb.Errors = append(pb.Errors, &chprotobuf.PbChannelError{
+			ChannelDescriptor: key,
+			Error:             c.Errors[key],
+		})
+	}
+	return pb
+}
+
+// FromPb initializes a ChannelsReply from its protobuf value
+func (c *ChannelsReply) FromPb(pb *chprotobuf.PbChannelsReply) {
+	c.BoundAddrs = make(map[string]string, len(pb.BoundAddrs))
+	for _, ba := range pb.BoundAddrs {
+		c.BoundAddrs[ba.GetChannelDescriptor()] = ba.GetAddr()
+	}
+	c.Errors = make(map[string]string, len(pb.Errors))
+	for _, ce := range pb.Errors {
+		c.Errors[ce.GetChannelDescriptor()] = ce.GetError()
+	}
+}
+
+// Unmarshal unserializes a ChannelsReply from protobuf bytes
+func (c *ChannelsReply) Unmarshal(b []byte) error {
+	pbc := &chprotobuf.PbChannelsReply{}
+	err := proto.Unmarshal(b, pbc)
+	if err != nil {
+		return fmt.Errorf("Invalid protobuf data for ChannelsReply")
+	}
+	c.FromPb(pbc)
+	return nil
+}
+
+// Marshal serializes a ChannelsReply to protobuf bytes
+func (c *ChannelsReply) Marshal() ([]byte, error) {
+	pbc := c.ToPb()
+	return proto.Marshal(pbc)
+}
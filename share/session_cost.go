@@ -0,0 +1,180 @@
+package chshare
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// The resources used by a client session are estimated from what the server already
+// tracks, rather than measured, which would need profiling
+const (
+	// sessionBaseGoroutines is the approximate number of goroutines a session runs
+	// regardless of its channels, e.g. to read its transport and SSH connection, handle
+	// its requests and send its keepalives and stats updates
+	sessionBaseGoroutines = 8
+
+	// channelGoroutines is the approximate number of goroutines that serve an open channel,
+	// copying its data in each direction
+	channelGoroutines = 2
+
+	// listenerGoroutines is the number of goroutines that accept connections for a reverse
+	// listener
+	listenerGoroutines = 1
+
+	// goroutineStackBytes is the approximate size of a goroutine's stack
+	goroutineStackBytes = 8 * 1024
+
+	// channelBufferBytes is the size of the buffers copying an open channel's data
+	channelBufferBytes = 2 * 32 * 1024
+)
+
+// sessionCostSampleInterval is how often the server samples the transport bytes of its
+// sessions, to measure their recent throughput
+const sessionCostSampleInterval = 10 * time.Second
+
+// SessionCostSorts are the orders in which session costs can be listed, by name
+var SessionCostSorts = map[string]func(a, b *SessionCost) bool{
+	"cost":       func(a, b *SessionCost) bool { return a.Cost > b.Cost },
+	"memory":     func(a, b *SessionCost) bool { return a.MemoryBytes > b.MemoryBytes },
+	"throughput": func(a, b *SessionCost) bool { return a.BytesPerSecond > b.BytesPerSecond },
+	"goroutines": func(a, b *SessionCost) bool { return a.Goroutines > b.Goroutines },
+	"buffered":   func(a, b *SessionCost) bool { return a.BufferedBytes > b.BufferedBytes },
+}
+
+// SessionCost is the approximate resource usage of a client session
+type SessionCost struct {
+	ID          int32
+	User        string
+	Tenant      string
+	SessionName string
+
+	// Channels and Listeners are the session's open channels and reverse listeners
+	Channels  int
+	Listeners int
+
+	// Goroutines is the approximate number of goroutines serving the session
+	Goroutines int
+
+	// BufferedBytes is the number of bytes waiting to be written to the client
+	BufferedBytes int64
+
+	// MemoryBytes is the approximate memory used by the session's goroutines and buffers
+	MemoryBytes int64
+
+	// TransportBytes is the number of bytes the session's transport has carried in both
+	// directions, and BytesPerSecond its recent rate
+	TransportBytes int64
+	BytesPerSecond float64
+
+	// Cost weighs memory against throughput, which stands for the CPU spent copying and
+	// encrypting data: a byte per second counts as much as a byte of memory
+	Cost float64
+}
+
+// throughputSample is the bytes a session's transport had carried at a point in time
+type throughputSample struct {
+	at    time.Time
+	bytes int64
+}
+
+// throughputMeter measures a session's recent throughput from the last two samples of its
+// transport bytes
+type throughputMeter struct {
+	lock    sync.Mutex
+	samples [2]throughputSample
+}
+
+// newThroughputMeter creates a throughputMeter for a session started at start
+func newThroughputMeter(start time.Time) *throughputMeter {
+	m := &throughputMeter{}
+	m.samples[0].at = start
+	m.samples[1].at = start
+	return m
+}
+
+// sample records the bytes carried as of now
+func (m *throughputMeter) sample(now time.Time, bytes int64) {
+	m.lock.Lock()
+	m.samples[0] = m.samples[1]
+	m.samples[1] = throughputSample{at: now, bytes: bytes}
+	m.lock.Unlock()
+}
+
+// rate returns the bytes per second carried since the older of the two samples
+func (m *throughputMeter) rate(now time.Time, bytes int64) float64 {
+	m.lock.Lock()
+	oldest := m.samples[0]
+	m.lock.Unlock()
+	elapsed := now.Sub(oldest.at).Seconds()
+	if elapsed <= 0 {
+		return 0
+	}
+	return float64(bytes-oldest.bytes) / elapsed
+}
+
+// Cost returns the session's approximate resource usage as of now
+func (s *ServerSSHSession) Cost(now time.Time) *SessionCost {
+	s.channelsLock.Lock()
+	c := &SessionCost{
+		ID:          s.id,
+		Tenant:      s.tenant,
+		SessionName: s.sessionName,
+		Listeners:   len(s.reverseProxies),
+	}
+	s.channelsLock.Unlock()
+	if s.user != nil {
+		c.User = s.user.Name
+	}
+	c.Channels = s.openChannels()
+	c.Goroutines = sessionBaseGoroutines + c.Channels*channelGoroutines + c.Listeners*listenerGoroutines
+	c.BufferedBytes = s.scheduler.PendingBytes()
+	c.MemoryBytes = int64(c.Goroutines)*goroutineStackBytes + int64(c.Channels)*channelBufferBytes +
+		c.BufferedBytes
+	c.TransportBytes = s.scheduler.TransportBytes()
+	c.BytesPerSecond = s.throughput.rate(now, c.TransportBytes)
+	c.Cost = float64(c.MemoryBytes) + c.BytesPerSecond
+	return c
+}
+
+// SessionCosts returns the approximate resource usage of the active sessions, sorted by one
+// of SessionCostSorts, most costly first, and limited to the first limit if it is not 0
+func (s *Server) SessionCosts(sortBy string, limit int) ([]*SessionCost, error) {
+	if sortBy == "" {
+		sortBy = "cost"
+	}
+	less, ok := SessionCostSorts[sortBy]
+	if !ok {
+		return nil, fmt.Errorf("Unknown sort '%s'; must be cost, memory, throughput, goroutines or buffered", sortBy)
+	}
+	now := s.clock.Now()
+	var costs []*SessionCost
+	for _, session := range s.Sessions() {
+		costs = append(costs, session.Cost(now))
+	}
+	sort.SliceStable(costs, func(i, j int) bool { return less(costs[i], costs[j]) })
+	if limit > 0 && len(costs) > limit {
+		costs = costs[:limit]
+	}
+	return costs, nil
+}
+
+// sessionCostLoop samples the transport bytes of the active sessions every
+// sessionCostSampleInterval, until the server shuts down
+func (s *Server) sessionCostLoop(ctx context.Context) {
+	for {
+		select {
+		case <-s.clock.After(sessionCostSampleInterval):
+		case <-s.ShutdownStartedChan():
+			return
+		case <-ctx.Done():
+			return
+		}
+		now := s.clock.Now()
+		for _, session := range s.Sessions() {
+			session.throughput.sample(now, session.scheduler.TransportBytes())
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package chshare
+
+import (
+	"fmt"
+	"sort"
+	"time"
+	"unicode"
+)
+
+const (
+	// DuplicateSessionAllow lets any number of sessions share a session name
+	DuplicateSessionAllow = "allow"
+
+	// DuplicateSessionReject refuses a new session whose name is already in use
+	DuplicateSessionReject = "reject"
+
+	// DuplicateSessionReplace shuts down the existing session with a new session's name
+	DuplicateSessionReplace = "replace"
+)
+
+// maxSessionNameLength is the longest session name a client may give
+const maxSessionNameLength = 255
+
+// replacedSessionWait bounds how long a new session waits for the session it replaces to shut
+// down, and release its reverse listeners, before the new session's channels are set up
+const replacedSessionWait = 10 * time.Second
+
+// ValidateSessionName returns an error if a session name given by a client is too long or
+// contains characters that are not printable
+func ValidateSessionName(name string) error {
+	if len(name) > maxSessionNameLength {
+		return fmt.Errorf("Session name is longer than %d bytes", maxSessionNameLength)
+	}
+	for _, r := range name {
+		if !unicode.IsPrint(r) {
+			return fmt.Errorf("Session name %q contains a character that is not printable", name)
+		}
+	}
+	return nil
+}
+
+// sameSessionScope returns true if two sessions' names are compared for uniqueness, i.e.
+// they are sessions of the same user, so that one user cannot claim another's names
+func sameSessionScope(a *ServerSSHSession, b *ServerSSHSession) bool {
+	if a.user == nil || b.user == nil {
+		return a.user == b.user
+	}
+	return a.users == b.users && a.user.Name == b.user.Name
+}
+
+// admitNamedSession applies the server's duplicate session policy to a newly configured
+// session that has a name. If another session of the same user already has the name, either
+// the new session is refused, or the older session is shut down in its favor, so that two
+// instances of the same device cannot hold conflicting reverse listeners. A replaced session
+// is given time to shut down before this returns.
+func (s *Server) admitNamedSession(session *ServerSSHSession, name string) error {
+	if name == "" {
+		return nil
+	}
+	s.activeSessionsLock.Lock()
+	var others []*ServerSSHSession
+	if s.duplicateSessions != DuplicateSessionAllow {
+		for _, other := range s.activeSessions {
+			if other != session && other.admittedName == name && sameSessionScope(other, session) {
+				others = append(others, other)
+			}
+		}
+	}
+	if len(others) > 0 && s.duplicateSessions == DuplicateSessionReject {
+		s.activeSessionsLock.Unlock()
+		s.sessionNameRejectsStat.Inc()
+		return fmt.Errorf("Session name %q is already in use by session #%d", name, others[0].ID())
+	}
+	for _, other := range others {
+		other.admittedName = ""
+	}
+	session.admittedName = name
+	s.activeSessionsLock.Unlock()
+
+	sort.Slice(others, func(i, j int) bool {
+		return others[i].ID() < others[j].ID()
+	})
+	for _, other := range others {
+		s.ILogf("Replacing session #%d named %q with new session #%d", other.ID(), name, session.ID())
+		s.sessionNameReplacementsStat.Inc()
+		other.SetChannelCloseReason(&ChannelCloseInfo{Reason: CloseReasonPolicyRevoked, Message: "Session replaced by a newer session with the same name"})
+		other.StartShutdown(fmt.Errorf("Session replaced by a newer session named %q", name))
+	}
+	timeout := s.clock.After(replacedSessionWait)
+	for _, other := range others {
+		select {
+		case <-other.ShutdownDoneChan():
+		case <-timeout:
+			s.ILogf("Session #%d named %q has not shut down after %s; continuing", other.ID(), name, replacedSessionWait)
+			return nil
+		}
+	}
+	return nil
+}
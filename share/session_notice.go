@@ -0,0 +1,191 @@
+package chshare
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/XevoInc/chisel/chprotobuf"
+	"github.com/golang/protobuf/proto"
+	"golang.org/x/crypto/ssh"
+)
+
+// NoticeRequestType is the SSH request type used by the server to send the client an
+// administrative notice, e.g. of a maintenance window, and optionally the time by which the
+// server will ask the client to reconnect. The payload is a protobuf SessionNotice.
+const NoticeRequestType = "notice"
+
+// noticeReplyTimeout bounds how long the server waits for a client to acknowledge a notice
+const noticeReplyTimeout = 10 * time.Second
+
+// SessionNotice is an administrative notice sent from the server to clients
+type SessionNotice struct {
+	// Message is logged by the client, if not ""
+	Message string
+
+	// ReconnectBy, if not zero, is the time by which the client is to reconnect. The server
+	// asks each client to reconnect at a random time before then, so that they do not all
+	// reconnect at once.
+	ReconnectBy time.Time
+}
+
+// Unmarshal unserializes a SessionNotice from protobuf bytes
+func (n *SessionNotice) Unmarshal(b []byte) error {
+	pb := &chprotobuf.PbSessionNotice{}
+	err := proto.Unmarshal(b, pb)
+	if err != nil {
+		return fmt.Errorf("Invalid protobuf data for SessionNotice")
+	}
+	n.Message = pb.GetMessage()
+	n.ReconnectBy = time.Time{}
+	if pb.GetReconnectByUnix() != 0 {
+		n.ReconnectBy = time.Unix(pb.GetReconnectByUnix(), 0)
+	}
+	return nil
+}
+
+// Marshal serializes a SessionNotice to protobuf bytes
+func (n *SessionNotice) Marshal() ([]byte, error) {
+	pb := &chprotobuf.PbSessionNotice{Message: n.Message}
+	if !n.ReconnectBy.IsZero() {
+		pb.ReconnectByUnix = n.ReconnectBy.Unix()
+	}
+	return proto.Marshal(pb)
+}
+
+// SessionNoticeFilter selects the sessions that a notice is sent to. Every filter that is
+// given must match; the zero value selects all sessions.
+type SessionNoticeFilter struct {
+	SessionIDs  []int32
+	User        string
+	SessionName string
+}
+
+// matches returns true if the filter selects a session
+func (f *SessionNoticeFilter) matches(info *ServerSessionInfo) bool {
+	if len(f.SessionIDs) > 0 {
+		found := false
+		for _, id := range f.SessionIDs {
+			if id == info.ID {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if f.User != "" && f.User != info.User {
+		return false
+	}
+	if f.SessionName != "" && f.SessionName != info.SessionName {
+		return false
+	}
+	return true
+}
+
+// sendNotice sends a notice to the session's client, returning true if the client
+// acknowledged it
+func (s *ServerSSHSession) sendNotice(payload []byte) bool {
+	type result struct {
+		ok  bool
+		err error
+	}
+	resultChan := make(chan result, 1)
+	go func() {
+		ok, _, err := s.sshConn.SendRequest(NoticeRequestType, true, payload)
+		resultChan <- result{ok, err}
+	}()
+	select {
+	case r := <-resultChan:
+		if r.err != nil {
+			s.DLogf("Notice send failed: %s", r.err)
+		} else if !r.ok {
+			s.DLogf("Client does not accept notices")
+		}
+		return r.err == nil && r.ok
+	case <-s.server.clock.After(noticeReplyTimeout):
+		s.DLogf("Client did not acknowledge notice within %s", noticeReplyTimeout)
+		return false
+	}
+}
+
+// scheduleReconnect asks the session's client to reconnect, as when the session reaches its
+// maximum age, at a random time before a deadline
+func (s *ServerSSHSession) scheduleReconnect(by time.Time) {
+	now := s.server.clock.Now()
+	window := by.Sub(now)
+	var delay time.Duration
+	if window > 0 {
+		delay = time.Duration(rand.New(rand.NewSource(now.UnixNano() + int64(s.ID()))).Int63n(int64(window)))
+	}
+	s.DLogf("Asking the client to reconnect in %s", delay.Round(time.Second))
+	go func() {
+		select {
+		case <-s.server.clock.After(delay):
+		case <-s.ShutdownStartedChan():
+			return
+		}
+		s.ILogf("Asking the client to reconnect, as scheduled by an administrator")
+		s.reconnectAndDrain(context.Background(), fmt.Errorf("Session reconnect scheduled by an administrator"))
+	}()
+}
+
+// NotifySessions sends a notice to the clients of the active sessions selected by a filter.
+// If the notice has a ReconnectBy time, each selected session is asked to reconnect at a
+// random time before then, whether or not its client understood the notice. It returns the
+// IDs of the sessions whose clients acknowledged the notice, and of those that did not, e.g.
+// because they are too old to understand it.
+func (s *Server) NotifySessions(notice *SessionNotice, filter *SessionNoticeFilter) ([]int32, []int32, error) {
+	payload, err := notice.Marshal()
+	if err != nil {
+		return nil, nil, err
+	}
+	var sessions []*ServerSSHSession
+	for _, session := range s.Sessions() {
+		if session.sshConn != nil && filter.matches(session.Info()) {
+			sessions = append(sessions, session)
+		}
+	}
+	acked := make([]bool, len(sessions))
+	var wg sync.WaitGroup
+	for i, session := range sessions {
+		wg.Add(1)
+		go func(i int, session *ServerSSHSession) {
+			defer wg.Done()
+			acked[i] = session.sendNotice(payload)
+		}(i, session)
+	}
+	wg.Wait()
+	var notified, unacknowledged []int32
+	for i, session := range sessions {
+		if !notice.ReconnectBy.IsZero() {
+			session.scheduleReconnect(notice.ReconnectBy)
+		}
+		if acked[i] {
+			notified = append(notified, session.ID())
+		} else {
+			unacknowledged = append(unacknowledged, session.ID())
+		}
+	}
+	return notified, unacknowledged, nil
+}
+
+// handleNotice logs a notice from the server
+func (c *Client) handleNotice(req *ssh.Request) {
+	notice := &SessionNotice{}
+	if err := notice.Unmarshal(req.Payload); err != nil {
+		c.DLogf("Invalid notice from server: %s", err)
+		req.Reply(false, nil)
+		return
+	}
+	req.Reply(true, nil)
+	if notice.Message != "" {
+		c.ILogf("Server notice: %s", notice.Message)
+	}
+	if !notice.ReconnectBy.IsZero() {
+		c.ILogf("Server will ask the client to reconnect by %s", notice.ReconnectBy.Format(time.RFC3339))
+	}
+}
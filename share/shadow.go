@@ -0,0 +1,305 @@
+package chshare
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+// shadowDialTimeout is how long to wait to connect to a shadow target
+const shadowDialTimeout = 10 * time.Second
+
+// shadowQueueLength is the number of chunks of the Caller's data that may wait to be sent to
+// a shadow target. A shadow target that falls further behind is dropped, so that it never
+// slows down the channel.
+const shadowQueueLength = 256
+
+// shadowCloseGrace is how long a shadow target may keep responding after the channel ends
+const shadowCloseGrace = 2 * time.Second
+
+// validateShadowOption validates the value of the "shadow" descriptor option
+func validateShadowOption(value string) error {
+	host, port, err := net.SplitHostPort(value)
+	if err != nil || host == "" || port == "" {
+		return fmt.Errorf("Invalid shadow target '%s'; expected <host>:<port>", value)
+	}
+	return nil
+}
+
+// EndpointShadowTarget returns the TCP address to which a skeleton endpoint's channels are
+// shadowed, or "" if they are not
+func EndpointShadowTarget(ced *ChannelEndpointDescriptor) string {
+	if ced.Role != ChannelEndpointRoleSkeleton || validateShadowOption(ced.Option("shadow")) != nil {
+		return ""
+	}
+	return ced.Option("shadow")
+}
+
+// shadowStats are the metrics of a shadow target
+type shadowStats struct {
+	connsStat  *Stat
+	errorsStat *Stat
+
+	// by target: the Called Service ("primary") or the shadow target ("shadow")
+	responseBytesStat   map[string]*Stat
+	firstResponseMsStat map[string]*Stat
+	firstResponsesStat  map[string]*Stat
+}
+
+func newShadowStats(stats *StatsRegistry, addr string) *shadowStats {
+	s := &shadowStats{
+		connsStat: stats.Counter(
+			"chisel_shadow_connections_total",
+			"Number of channels whose Caller's data was copied to a shadow target",
+			StatLabels{"shadow": addr}),
+		errorsStat: stats.Counter(
+			"chisel_shadow_errors_total",
+			"Number of channels that stopped being shadowed because the shadow target could not be reached, failed or fell behind",
+			StatLabels{"shadow": addr}),
+		responseBytesStat:   make(map[string]*Stat),
+		firstResponseMsStat: make(map[string]*Stat),
+		firstResponsesStat:  make(map[string]*Stat),
+	}
+	for _, target := range []string{"primary", "shadow"} {
+		labels := StatLabels{"shadow": addr, "target": target}
+		s.responseBytesStat[target] = stats.Counter(
+			"chisel_shadow_response_bytes_total",
+			"Number of bytes sent back by the Called Service (primary) and the shadow target of shadowed channels",
+			labels)
+		s.firstResponseMsStat[target] = stats.Counter(
+			"chisel_shadow_first_response_ms_total",
+			"Total milliseconds from the Caller's first data to the first response of the Called Service (primary) and the shadow target of shadowed channels",
+			labels)
+		s.firstResponsesStat[target] = stats.Counter(
+			"chisel_shadow_first_responses_total",
+			"Number of shadowed channels on which the Called Service (primary) and the shadow target responded, for averaging chisel_shadow_first_response_ms_total",
+			labels)
+	}
+	return s
+}
+
+// ShadowChannelConn returns the connection to the remote proxy to use for a channel to a
+// skeleton endpoint with the "shadow" option. The data the Caller sends is also sent to the
+// shadow target, whose responses are discarded, and the response volumes and times of both
+// targets are recorded, to compare a new backend with the one in use. If the endpoint is not
+// shadowed, or the shadow target is refused by the dial allowlist, conn is returned
+// unchanged.
+func ShadowChannelConn(logger Logger, env LocalChannelEnv, ced *ChannelEndpointDescriptor, conn ChannelConn) ChannelConn {
+	addr := EndpointShadowTarget(ced)
+	if addr == "" {
+		return conn
+	}
+	stats := newShadowStats(env.GetStatsRegistry(), addr)
+	if allowlist := env.GetDialAllowlist(); allowlist != nil {
+		checked, err := allowlist.CheckTCP(context.Background(), addr)
+		if err != nil {
+			logger.ILogf("Not shadowing channel to %s: %s", addr, err)
+			stats.errorsStat.Inc()
+			return conn
+		}
+		addr = checked
+	}
+	stats.connsStat.Inc()
+	c := &shadowConn{
+		ChannelConn: conn,
+		logger:      logger.Fork("shadow:%s", addr),
+		clock:       env.GetClock(),
+		stats:       stats,
+		queue:       make(chan []byte, shadowQueueLength),
+		dialed:      make(chan net.Conn, 1),
+		readerDone:  make(chan struct{}),
+	}
+	go c.dial(addr)
+	go c.writeLoop()
+	return c
+}
+
+// shadowConn is a ChannelConn that copies the data read from another ChannelConn, the
+// Caller's data, to a shadow target
+type shadowConn struct {
+	ChannelConn
+	logger Logger
+	clock  Clock
+	stats  *shadowStats
+
+	// queue holds the Caller's data waiting to be sent to the shadow target, and is closed
+	// at the end of the Caller's data
+	queue chan []byte
+
+	// dialed receives the connection to the shadow target, or nil if it failed
+	dialed chan net.Conn
+
+	// readerDone is closed when the shadow target's responses have all been read
+	readerDone chan struct{}
+
+	// lock protects the fields below
+	lock sync.Mutex
+
+	// dropped is true once the shadow target is no longer sent data
+	dropped bool
+
+	// queueClosed is true once queue has been closed
+	queueClosed bool
+
+	// shadowConn is the connection to the shadow target, once connected
+	shadowConn net.Conn
+
+	// started is when the Caller first sent data, and primaryResponded and shadowResponded
+	// are true once each target has responded
+	started          time.Time
+	primaryResponded bool
+	shadowResponded  bool
+
+	primaryBytes int64
+	shadowBytes  int64
+}
+
+func (c *shadowConn) dial(addr string) {
+	netConn, err := net.DialTimeout("tcp", addr, shadowDialTimeout)
+	if err != nil {
+		c.logger.DLogf("Unable to connect to shadow target: %s", err)
+		c.drop()
+		c.dialed <- nil
+		close(c.readerDone)
+		return
+	}
+	c.lock.Lock()
+	c.shadowConn = netConn
+	c.lock.Unlock()
+	c.dialed <- netConn
+	go c.readLoop(netConn)
+}
+
+// drop stops shadowing the channel after a failure
+func (c *shadowConn) drop() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if !c.dropped {
+		c.dropped = true
+		c.stats.errorsStat.Inc()
+	}
+}
+
+// writeLoop sends the Caller's data to the shadow target
+func (c *shadowConn) writeLoop() {
+	netConn := <-c.dialed
+	for data := range c.queue {
+		if netConn == nil {
+			continue
+		}
+		if _, err := netConn.Write(data); err != nil {
+			c.logger.DLogf("Write to shadow target failed: %s", err)
+			c.drop()
+			netConn.Close()
+			netConn = nil
+		}
+	}
+	if tcpConn, ok := netConn.(*net.TCPConn); ok {
+		tcpConn.CloseWrite()
+	}
+}
+
+// readLoop reads and discards the shadow target's responses
+func (c *shadowConn) readLoop(netConn net.Conn) {
+	defer close(c.readerDone)
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := netConn.Read(buf)
+		if n > 0 {
+			c.lock.Lock()
+			c.shadowBytes += int64(n)
+			c.recordResponseLocked("shadow", &c.shadowResponded)
+			c.lock.Unlock()
+			c.stats.responseBytesStat["shadow"].Add(int64(n))
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// recordResponseLocked records a target's first response. c.lock must be held.
+func (c *shadowConn) recordResponseLocked(target string, responded *bool) {
+	if *responded || c.started.IsZero() {
+		return
+	}
+	*responded = true
+	c.stats.firstResponseMsStat[target].Add(c.clock.Now().Sub(c.started).Milliseconds())
+	c.stats.firstResponsesStat[target].Inc()
+}
+
+// closeQueueLocked ends the data sent to the shadow target. c.lock must be held.
+func (c *shadowConn) closeQueueLocked() {
+	if !c.queueClosed {
+		c.queueClosed = true
+		close(c.queue)
+	}
+}
+
+// Read reads the Caller's data, and queues a copy for the shadow target
+func (c *shadowConn) Read(p []byte) (int, error) {
+	n, err := c.ChannelConn.Read(p)
+	c.lock.Lock()
+	if n > 0 && c.started.IsZero() {
+		c.started = c.clock.Now()
+	}
+	if n > 0 && !c.dropped && !c.queueClosed {
+		select {
+		case c.queue <- append([]byte(nil), p[:n]...):
+		default:
+			c.logger.DLogf("Shadow target fell behind; no longer shadowing channel")
+			c.dropped = true
+			c.stats.errorsStat.Inc()
+			c.closeQueueLocked()
+		}
+	}
+	if err != nil {
+		c.closeQueueLocked()
+	}
+	c.lock.Unlock()
+	return n, err
+}
+
+// Write sends the Called Service's response to the Caller
+func (c *shadowConn) Write(p []byte) (int, error) {
+	n, err := c.ChannelConn.Write(p)
+	if n > 0 {
+		c.lock.Lock()
+		c.primaryBytes += int64(n)
+		c.recordResponseLocked("primary", &c.primaryResponded)
+		c.lock.Unlock()
+		c.stats.responseBytesStat["primary"].Add(int64(n))
+	}
+	return n, err
+}
+
+// Close closes the channel, and the connection to the shadow target once it has finished
+// responding or the grace period is over
+func (c *shadowConn) Close() error {
+	err := c.ChannelConn.Close()
+	c.lock.Lock()
+	c.closeQueueLocked()
+	c.lock.Unlock()
+	go func() {
+		select {
+		case <-c.readerDone:
+		case <-c.clock.After(shadowCloseGrace):
+		}
+		c.lock.Lock()
+		netConn := c.shadowConn
+		c.shadowConn = nil
+		primaryBytes, shadowBytes := c.primaryBytes, c.shadowBytes
+		c.lock.Unlock()
+		if netConn != nil {
+			netConn.Close()
+			c.logger.DLogf("Shadowed channel ended; primary responded with %d bytes, shadow with %d bytes", primaryBytes, shadowBytes)
+		}
+	}()
+	return err
+}
+
+func (c *shadowConn) String() string {
+	return fmt.Sprintf("shadow:%s", c.ChannelConn)
+}
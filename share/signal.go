@@ -12,13 +12,20 @@ import (
 //SleepSignal sleeps for the given duration,
 //or until a SIGHUP is received
 func SleepSignal(d time.Duration) {
+	SleepSignalOrWake(SystemClock, d, nil)
+}
+
+//SleepSignalOrWake sleeps for the given duration on clock,
+//or until a SIGHUP is received or wake is signalled
+func SleepSignalOrWake(clock Clock, d time.Duration, wake <-chan struct{}) {
 	//during this time, also listen for SIGHUP
 	//(this uses 0xc to allow windows to compile)
 	sig := make(chan os.Signal, 1)
 	signal.Notify(sig, syscall.SIGHUP)
 	select {
-	case <-time.After(d):
+	case <-clock.After(d):
 	case <-sig:
+	case <-wake:
 	}
 	signal.Stop(sig)
 }
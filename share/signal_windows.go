@@ -8,3 +8,11 @@ import "time"
 func SleepSignal(d time.Duration) {
 	time.Sleep(d) //not supported
 }
+
+//Sleep on clock unless woken
+func SleepSignalOrWake(clock Clock, d time.Duration, wake <-chan struct{}) {
+	select {
+	case <-clock.After(d):
+	case <-wake:
+	}
+}
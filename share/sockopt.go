@@ -0,0 +1,245 @@
+package chshare
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+// SocketOptions holds the socket options of a TCP or UDP endpoint, set with the descriptor options:
+//
+//	keepalive=<duration>|off  the TCP keepalive period, or off to disable keepalives
+//	sndbuf=<bytes>            the socket's send buffer size (SO_SNDBUF)
+//	rcvbuf=<bytes>            the socket's receive buffer size (SO_RCVBUF)
+//	tos=<0-255>               the IP TOS byte (or IPv6 traffic class), e.g. 0xb8 for DSCP EF
+//	freebind=true             allow a stub to listen on an address that is not (yet) assigned
+//	family=4|6                listen or dial with IPv4 or IPv6 only
+//
+// Each option applies to both endpoints of a channel, unless prefixed with "local-" to apply
+// to the stub only, or "remote-" to apply to the skeleton only. A prefixed option overrides
+// an unprefixed one.
+type SocketOptions struct {
+	// KeepAlive is the keepalive period; 0 leaves Go's default, and negative disables it
+	KeepAlive time.Duration
+
+	// SendBuffer and ReceiveBuffer are the buffer sizes, or 0 to leave the system's default
+	SendBuffer    int
+	ReceiveBuffer int
+
+	// TOS is the IP TOS byte, or -1 to leave it unset
+	TOS int
+
+	// FreeBind is true if a listener may bind to a nonlocal address
+	FreeBind bool
+
+	// Family is 4 or 6 to listen or dial with only IPv4 or IPv6, or 0 for either
+	Family int
+}
+
+// socketOptionValidators holds a validation function for each socket option
+var socketOptionValidators = map[string]func(value string) error{
+	"keepalive": func(value string) error {
+		_, err := parseKeepAliveOption(value)
+		return err
+	},
+	"sndbuf": func(value string) error {
+		_, err := parseBufferSizeOption(value)
+		return err
+	},
+	"rcvbuf": func(value string) error {
+		_, err := parseBufferSizeOption(value)
+		return err
+	},
+	"tos": func(value string) error {
+		_, err := parseTOSOption(value)
+		return err
+	},
+	"freebind": func(value string) error {
+		_, err := strconv.ParseBool(value)
+		return err
+	},
+	"family": func(value string) error {
+		_, err := parseFamilyOption(value)
+		return err
+	},
+}
+
+func init() {
+	for name, validate := range socketOptionValidators {
+		for _, prefix := range []string{"", "local-", "remote-"} {
+			descriptorOptionValidators[prefix+name] = validate
+		}
+	}
+}
+
+// parseKeepAliveOption parses the value of the "keepalive" option
+func parseKeepAliveOption(value string) (time.Duration, error) {
+	if value == "off" {
+		return -1, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("Invalid keepalive '%s'; must be a positive duration or off", value)
+	}
+	return d, nil
+}
+
+// parseBufferSizeOption parses the value of the "sndbuf" and "rcvbuf" options
+func parseBufferSizeOption(value string) (int, error) {
+	n, err := strconv.Atoi(value)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("Invalid buffer size '%s'; must be a positive number of bytes", value)
+	}
+	return n, nil
+}
+
+// parseTOSOption parses the value of the "tos" option
+func parseTOSOption(value string) (int, error) {
+	n, err := strconv.ParseUint(value, 0, 8)
+	if err != nil {
+		return 0, fmt.Errorf("Invalid tos '%s'; must be 0-255", value)
+	}
+	return int(n), nil
+}
+
+// parseFamilyOption parses the value of the "family" option
+func parseFamilyOption(value string) (int, error) {
+	switch value {
+	case "4":
+		return 4, nil
+	case "6":
+		return 6, nil
+	}
+	return 0, fmt.Errorf("Invalid family '%s'; must be 4 or 6", value)
+}
+
+// setFamily forces the address family of an endpoint, as given with a "tcp4" or "tcp6"
+// endpoint type. It is recorded as the endpoint's role-prefixed "family" option, so that it
+// takes precedence over a family option given for the whole descriptor.
+func (d *ChannelEndpointDescriptor) setFamily(family string) {
+	prefix := "local-"
+	if d.Role == ChannelEndpointRoleSkeleton {
+		prefix = "remote-"
+	}
+	if d.Options == nil {
+		d.Options = make(map[string]string)
+	}
+	d.Options[prefix+"family"] = family
+}
+
+// socketOption returns the value of a socket option for an endpoint, preferring the option
+// prefixed for the endpoint's role
+func socketOption(ced *ChannelEndpointDescriptor, name string) string {
+	prefix := "local-"
+	if ced.Role == ChannelEndpointRoleSkeleton {
+		prefix = "remote-"
+	}
+	if value := ced.Option(prefix + name); value != "" {
+		return value
+	}
+	return ced.Option(name)
+}
+
+// EndpointSocketOptions returns the socket options of an endpoint. Values have already been
+// validated when the descriptor was parsed, but a descriptor from a peer is checked again.
+func EndpointSocketOptions(ced *ChannelEndpointDescriptor) (*SocketOptions, error) {
+	o := &SocketOptions{TOS: -1}
+	var err error
+	if value := socketOption(ced, "keepalive"); value != "" {
+		if o.KeepAlive, err = parseKeepAliveOption(value); err != nil {
+			return nil, err
+		}
+	}
+	if value := socketOption(ced, "sndbuf"); value != "" {
+		if o.SendBuffer, err = parseBufferSizeOption(value); err != nil {
+			return nil, err
+		}
+	}
+	if value := socketOption(ced, "rcvbuf"); value != "" {
+		if o.ReceiveBuffer, err = parseBufferSizeOption(value); err != nil {
+			return nil, err
+		}
+	}
+	if value := socketOption(ced, "tos"); value != "" {
+		if o.TOS, err = parseTOSOption(value); err != nil {
+			return nil, err
+		}
+	}
+	if value := socketOption(ced, "freebind"); value != "" {
+		if o.FreeBind, err = strconv.ParseBool(value); err != nil {
+			return nil, fmt.Errorf("Invalid freebind '%s'; must be true or false", value)
+		}
+	}
+	if value := socketOption(ced, "family"); value != "" {
+		if o.Family, err = parseFamilyOption(value); err != nil {
+			return nil, err
+		}
+	}
+	if o.needsControl() && !rawSocketOptionsSupported {
+		return nil, fmt.Errorf("The sndbuf, rcvbuf, tos and freebind options are not supported on this platform")
+	}
+	return o, nil
+}
+
+// needsControl returns true if any option must be set on the raw socket
+func (o *SocketOptions) needsControl() bool {
+	return o.SendBuffer > 0 || o.ReceiveBuffer > 0 || o.TOS >= 0 || o.FreeBind
+}
+
+// control sets the options on a socket before it is bound or connected. It is used as the
+// Control function of a net.ListenConfig or net.Dialer.
+func (o *SocketOptions) control(network, address string, c syscall.RawConn) error {
+	var err error
+	cerr := c.Control(func(fd uintptr) {
+		err = setRawSocketOptions(fd, network, o)
+	})
+	if cerr != nil {
+		return cerr
+	}
+	return err
+}
+
+// Network returns the network to listen on or dial for the Family option, or defaultNetwork
+// if no family is set
+func (o *SocketOptions) Network(defaultNetwork string) string {
+	switch o.Family {
+	case 4:
+		return "tcp4"
+	case 6:
+		return "tcp6"
+	}
+	return defaultNetwork
+}
+
+// UDPNetwork returns the network to listen on or dial for the Family option on a UDP
+// endpoint, or defaultNetwork if no family is set
+func (o *SocketOptions) UDPNetwork(defaultNetwork string) string {
+	switch o.Family {
+	case 4:
+		return "udp4"
+	case 6:
+		return "udp6"
+	}
+	return defaultNetwork
+}
+
+// ListenConfig returns a net.ListenConfig that applies the options to the listening socket,
+// from which accepted sockets inherit them
+func (o *SocketOptions) ListenConfig() *net.ListenConfig {
+	lc := &net.ListenConfig{KeepAlive: o.KeepAlive}
+	if o.needsControl() {
+		lc.Control = o.control
+	}
+	return lc
+}
+
+// Dialer returns a net.Dialer that applies the options to dialed sockets
+func (o *SocketOptions) Dialer() *net.Dialer {
+	d := &net.Dialer{KeepAlive: o.KeepAlive}
+	if o.needsControl() {
+		d.Control = o.control
+	}
+	return d
+}
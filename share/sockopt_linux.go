@@ -0,0 +1,45 @@
+//+build linux
+
+package chshare
+
+import (
+	"fmt"
+	"syscall"
+)
+
+// rawSocketOptionsSupported is true if setRawSocketOptions can set every SocketOptions field
+const rawSocketOptionsSupported = true
+
+// setRawSocketOptions sets the options that have no net package equivalent on a socket
+func setRawSocketOptions(fd uintptr, network string, o *SocketOptions) error {
+	s := int(fd)
+	if o.SendBuffer > 0 {
+		if err := syscall.SetsockoptInt(s, syscall.SOL_SOCKET, syscall.SO_SNDBUF, o.SendBuffer); err != nil {
+			return fmt.Errorf("Unable to set SO_SNDBUF: %s", err)
+		}
+	}
+	if o.ReceiveBuffer > 0 {
+		if err := syscall.SetsockoptInt(s, syscall.SOL_SOCKET, syscall.SO_RCVBUF, o.ReceiveBuffer); err != nil {
+			return fmt.Errorf("Unable to set SO_RCVBUF: %s", err)
+		}
+	}
+	ipv6 := network == "tcp6" || network == "udp6"
+	if o.TOS >= 0 {
+		var err error
+		if ipv6 {
+			err = syscall.SetsockoptInt(s, syscall.IPPROTO_IPV6, syscall.IPV6_TCLASS, o.TOS)
+		} else {
+			err = syscall.SetsockoptInt(s, syscall.IPPROTO_IP, syscall.IP_TOS, o.TOS)
+		}
+		if err != nil {
+			return fmt.Errorf("Unable to set TOS: %s", err)
+		}
+	}
+	if o.FreeBind {
+		// IP_FREEBIND applies to IPv6 sockets too
+		if err := syscall.SetsockoptInt(s, syscall.IPPROTO_IP, syscall.IP_FREEBIND, 1); err != nil {
+			return fmt.Errorf("Unable to set IP_FREEBIND: %s", err)
+		}
+	}
+	return nil
+}
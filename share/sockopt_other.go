@@ -0,0 +1,12 @@
+//+build !linux
+
+package chshare
+
+// rawSocketOptionsSupported is true if setRawSocketOptions can set every SocketOptions field
+const rawSocketOptionsSupported = false
+
+// setRawSocketOptions is not implemented on this platform; EndpointSocketOptions refuses
+// the options that need it
+func setRawSocketOptions(fd uintptr, network string, o *SocketOptions) error {
+	return nil
+}
@@ -0,0 +1,48 @@
+package chshare
+
+import (
+	"context"
+	"net"
+	"strconv"
+
+	socks5 "github.com/armon/go-socks5"
+)
+
+// userSocksRules is a socks5.RuleSet that allows a SOCKS5 request only if the destination is
+// in the access list of the session's user, as an explicit remote to it would have to be.
+// Otherwise a user allowed to open SOCKS channels could reach any destination through them.
+type userSocksRules struct {
+	session *ServerSSHSession
+}
+
+// socksDestinations returns the addresses of a SOCKS5 request's destination that are
+// matched against the access list: "<host>:<port>" if it was given by name, and
+// "<ip>:<port>" for the address it is dialed at
+func socksDestinations(dest *socks5.AddrSpec) []string {
+	var addrs []string
+	port := strconv.Itoa(dest.Port)
+	if dest.FQDN != "" {
+		addrs = append(addrs, net.JoinHostPort(dest.FQDN, port))
+	}
+	if dest.IP != nil {
+		addrs = append(addrs, net.JoinHostPort(dest.IP.String(), port))
+	}
+	return addrs
+}
+
+// Allow implements socks5.RuleSet. The user is looked up again so that changes to their
+// access list take effect immediately.
+func (r *userSocksRules) Allow(ctx context.Context, req *socks5.Request) (context.Context, bool) {
+	s := r.session
+	user, ok := s.users.Get(s.user.Name)
+	if ok && req.DestAddr != nil {
+		for _, addr := range socksDestinations(req.DestAddr) {
+			if s.userHasAccess(user, addr) {
+				return ctx, true
+			}
+		}
+	}
+	s.DLogf("SOCKS5 access to \"%s\" denied", req.DestAddr)
+	s.server.socksDenialsStat.Inc()
+	return ctx, false
+}
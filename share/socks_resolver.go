@@ -0,0 +1,112 @@
+package chshare
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// ResolveRequestType is the SSH request type used by the server to ask the client to
+// resolve a host name on its side of the tunnel. The payload is the host name; a successful
+// reply carries the resolved IP address as a string.
+const ResolveRequestType = "resolve"
+
+// SocksResolveOnClient is the --socks5-resolver value that selects resolution on the client
+// side of the tunnel
+const SocksResolveOnClient = "client"
+
+// resolveTimeout bounds how long a single name resolution may take
+const resolveTimeout = 10 * time.Second
+
+// DNSServerResolver is a socks5.NameResolver that resolves names using a specific DNS server
+// rather than the system resolver configuration
+type DNSServerResolver struct {
+	resolver *net.Resolver
+}
+
+// NewDNSServerResolver creates a DNSServerResolver that sends queries to the DNS server at
+// addr ("<host>" or "<host>:<port>"; the port defaults to 53)
+func NewDNSServerResolver(addr string) (*DNSServerResolver, error) {
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		addr = net.JoinHostPort(addr, "53")
+		if _, _, err := net.SplitHostPort(addr); err != nil {
+			return nil, fmt.Errorf("Invalid DNS server address '%s': %s", addr, err)
+		}
+	}
+	r := &DNSServerResolver{
+		resolver: &net.Resolver{
+			PreferGo: true,
+			Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+				d := net.Dialer{}
+				return d.DialContext(ctx, network, addr)
+			},
+		},
+	}
+	return r, nil
+}
+
+// Resolve implements socks5.NameResolver
+func (r *DNSServerResolver) Resolve(ctx context.Context, name string) (context.Context, net.IP, error) {
+	lookupCtx, cancel := context.WithTimeout(ctx, resolveTimeout)
+	defer cancel()
+	addrs, err := r.resolver.LookupIPAddr(lookupCtx, name)
+	if err != nil {
+		return ctx, nil, err
+	}
+	if len(addrs) == 0 {
+		return ctx, nil, fmt.Errorf("No addresses found for '%s'", name)
+	}
+	return ctx, addrs[0].IP, nil
+}
+
+// RemoteProxyResolver is a socks5.NameResolver that asks the remote proxy to resolve names
+// on its side of the tunnel, using a ResolveRequestType SSH request
+type RemoteProxyResolver struct {
+	localChannelEnv LocalChannelEnv
+}
+
+// NewRemoteProxyResolver creates a RemoteProxyResolver that sends requests over the
+// SSH connection of localChannelEnv
+func NewRemoteProxyResolver(localChannelEnv LocalChannelEnv) *RemoteProxyResolver {
+	return &RemoteProxyResolver{localChannelEnv: localChannelEnv}
+}
+
+// Resolve implements socks5.NameResolver
+func (r *RemoteProxyResolver) Resolve(ctx context.Context, name string) (context.Context, net.IP, error) {
+	sshConn, err := r.localChannelEnv.GetSSHConn()
+	if err != nil {
+		return ctx, nil, err
+	}
+	ok, reply, err := sshConn.SendRequest(ResolveRequestType, true, []byte(name))
+	if err != nil {
+		return ctx, nil, fmt.Errorf("Remote resolve request for '%s' failed: %s", name, err)
+	}
+	if !ok {
+		return ctx, nil, fmt.Errorf("Remote resolve of '%s' failed: %s", name, string(reply))
+	}
+	ip := net.ParseIP(string(reply))
+	if ip == nil {
+		return ctx, nil, fmt.Errorf("Remote resolve of '%s' returned invalid address '%s'", name, string(reply))
+	}
+	return ctx, ip, nil
+}
+
+// handleResolveRequest answers a ResolveRequestType SSH request using the local system resolver
+func handleResolveRequest(ctx context.Context, logger Logger, req *ssh.Request) error {
+	name := string(req.Payload)
+	lookupCtx, cancel := context.WithTimeout(ctx, resolveTimeout)
+	defer cancel()
+	addrs, err := net.DefaultResolver.LookupIPAddr(lookupCtx, name)
+	if err == nil && len(addrs) == 0 {
+		err = fmt.Errorf("No addresses found for '%s'", name)
+	}
+	if err != nil {
+		logger.DLogf("Resolve of '%s' for remote proxy failed: %s", name, err)
+		return req.Reply(false, []byte(err.Error()))
+	}
+	logger.DLogf("Resolved '%s' for remote proxy: %s", name, addrs[0].IP)
+	return req.Reply(true, []byte(addrs[0].IP.String()))
+}
@@ -24,6 +24,7 @@ func NewSocksSkeletonEndpoint(
 		BasicEndpoint: BasicEndpoint{
 			ced: ced,
 		},
+		socksServer: socksServer,
 	}
 	ep.InitBasicEndpoint(logger, ep, "SocksSkeletonEndpoint: %s", ced)
 	return ep, nil
@@ -60,15 +61,18 @@ func (ep *SocksSkeletonEndpoint) Dial(ctx context.Context, extraData []byte) (Ch
 		return nil, fmt.Errorf("%s: Unable to wrap net.Conn with SocketConn: %s", ep.Logger.Prefix(), err)
 	}
 
-	err = ep.socksServer.ServeConn(socksNetConn)
-	if err != nil {
-		socksNetConn.Close()
-		conn.Close()
-		return nil, fmt.Errorf("%s: Socks5 server refused connect: %s", ep.Logger.Prefix(), err)
-	}
-
 	ep.AddShutdownChild(conn)
 
+	// The socks5 server session runs until the caller disconnects, so it must run in
+	// the background while our end of the socketpair is bridged to the caller.
+	// ServeConn closes socksNetConn when it returns.
+	go func() {
+		err := ep.socksServer.ServeConn(socksNetConn)
+		if err != nil {
+			ep.DLogf("Socks5 session ended with error: %s", err)
+		}
+	}()
+
 	return conn, nil
 }
 
@@ -94,7 +98,7 @@ func (ep *SocksSkeletonEndpoint) DialAndServe(
 ) (int64, int64, error) {
 	calledServiceConn, err := ep.Dial(ctx, extraData)
 	if err != nil {
-		callerConn.Close()
+		closeOnDialFailure(callerConn, err)
 		return 0, 0, err
 	}
 	return BasicBridgeChannels(ctx, ep.Logger, callerConn, calledServiceConn)
@@ -3,6 +3,7 @@ package chshare
 // Implementation of a wrapper turning ssh.Channel into a ChannelConn
 
 import (
+	"encoding/json"
 	"fmt"
 	"golang.org/x/crypto/ssh"
 	"sync/atomic"
@@ -12,14 +13,22 @@ import (
 type SSHConn struct {
 	BasicConn
 	rawSSHConn ssh.Channel
+	scheduler  *WriteScheduler
+	priority   ChannelPriority
+
+	// remoteCloseInfo is the reason the remote proxy gave for closing the channel, protected
+	// by Lock
+	remoteCloseInfo *ChannelCloseInfo
 }
 
-// NewSSHConn creates a new SSHConn
-func NewSSHConn(logger Logger, rawSSHConn ssh.Channel) (*SSHConn, error) {
+// NewSSHConn creates a new SSHConn. reqs are the requests received on the channel, which
+// are handled until the channel is closed.
+func NewSSHConn(logger Logger, rawSSHConn ssh.Channel, reqs <-chan *ssh.Request) (*SSHConn, error) {
 	c := &SSHConn{
 		rawSSHConn: rawSSHConn,
 	}
 	c.InitBasicConn(logger, c, "SSHConn")
+	go c.handleChannelRequests(reqs)
 	return c, nil
 }
 
@@ -39,6 +48,11 @@ func (c *SSHConn) CloseWrite() error {
 // HandleOnceShutdown will be called exactly once, in its own goroutine. It should take completionError
 // as an advisory completion value, actually shut down, then return the real completion value.
 func (c *SSHConn) HandleOnceShutdown(completionErr error) error {
+	if info := c.BasicConn.CloseReason(); info != nil {
+		payload, _ := json.Marshal(info)
+		// best effort; the remote proxy may already have closed the channel
+		c.rawSSHConn.SendRequest(ChannelCloseRequestType, false, payload)
+	}
 	err := c.rawSSHConn.Close()
 	if err != nil {
 		err = c.Errorf("%s", err)
@@ -49,6 +63,17 @@ func (c *SSHConn) HandleOnceShutdown(completionErr error) error {
 	return completionErr
 }
 
+// CloseReason returns the reason recorded with SetCloseReason, or else the reason sent by the
+// remote proxy, or nil. Part of the ChannelConn interface.
+func (c *SSHConn) CloseReason() *ChannelCloseInfo {
+	if info := c.BasicConn.CloseReason(); info != nil {
+		return info
+	}
+	c.Lock.Lock()
+	defer c.Lock.Unlock()
+	return c.remoteCloseInfo
+}
+
 // WaitForClose blocks until the Close() method has been called and completed
 func (c *SSHConn) WaitForClose() error {
 	return c.WaitShutdown()
@@ -61,9 +86,20 @@ func (c *SSHConn) Read(p []byte) (n int, err error) {
 	return n, err
 }
 
+// SetPriority schedules writes to the channel against other channels' writes on the
+// same SSH connection, using the given priority
+func (c *SSHConn) SetPriority(scheduler *WriteScheduler, priority ChannelPriority) {
+	c.scheduler = scheduler
+	c.priority = priority
+}
+
 // Write implements the Writer interface
 func (c *SSHConn) Write(p []byte) (n int, err error) {
-	n, err = c.rawSSHConn.Write(p)
+	if c.scheduler != nil {
+		n, err = c.scheduler.Write(c.priority, c.rawSSHConn.Write, p)
+	} else {
+		n, err = c.rawSSHConn.Write(p)
+	}
 	atomic.AddInt64(&c.NumBytesWritten, int64(n))
 	return n, err
 }
@@ -5,9 +5,15 @@ import (
 	"encoding/json"
 	"fmt"
 	"golang.org/x/crypto/ssh"
+	"sync"
 	"sync/atomic"
 )
 
+// SSHRequestHandler handles a single incoming SSH request of a registered type. The handler
+// is responsible for sending a reply if one is wanted. Requests are handled one at a time,
+// in the order in which they are received.
+type SSHRequestHandler func(ctx context.Context, req *ssh.Request) error
+
 // SSHSession wraps a primary SSH connection to the remote proxy
 type SSHSession struct {
 	ShutdownHelper
@@ -28,6 +34,27 @@ type SSHSession struct {
 
 	// sshRequests is the chan on which ssh requests are received (including initial config request)
 	sshRequests <-chan *ssh.Request
+
+	// requestHandlersLock protects requestHandlers
+	requestHandlersLock sync.Mutex
+
+	// requestHandlers maps SSH request types to handlers, in addition to the built-in "ping"
+	requestHandlers map[string]SSHRequestHandler
+
+	// activeChannels is the number of channels opened by the remote proxy that are
+	// currently being served. Accessed atomically.
+	activeChannels int32
+
+	// channelConnsLock protects channelConns and channelCloseInfo
+	channelConnsLock sync.Mutex
+
+	// channelConns holds the connections to the remote proxy of the session's open channels,
+	// with the local endpoint of each
+	channelConns map[ChannelConn]*ChannelEndpointDescriptor
+
+	// channelCloseInfo is the reason given to the remote proxy for closing the open channels
+	// when the session shuts down, or nil to just drop the connection
+	channelCloseInfo *ChannelCloseInfo
 }
 
 // LastSSHSessionID is the last allocated ID for SSH sessions, for logging purposes
@@ -46,6 +73,102 @@ func (s *SSHSession) InitSSHSession(logger Logger, localChannelEnv LocalChannelE
 	s.ShutdownHelper.InitShutdownHelper(logger.Fork("%s", s.strname), s)
 	s.PanicOnError(s.Activate())
 	s.localChannelEnv = localChannelEnv
+	s.requestHandlers = make(map[string]SSHRequestHandler)
+	s.channelConns = make(map[ChannelConn]*ChannelEndpointDescriptor)
+}
+
+// RegisterSSHRequestHandler installs a handler for incoming SSH requests of a given type,
+// replacing any previously registered handler for that type
+func (s *SSHSession) RegisterSSHRequestHandler(reqType string, handler SSHRequestHandler) {
+	s.requestHandlersLock.Lock()
+	s.requestHandlers[reqType] = handler
+	s.requestHandlersLock.Unlock()
+}
+
+// getSSHRequestHandler returns the registered handler for an SSH request type, or nil
+func (s *SSHSession) getSSHRequestHandler(reqType string) SSHRequestHandler {
+	s.requestHandlersLock.Lock()
+	defer s.requestHandlersLock.Unlock()
+	return s.requestHandlers[reqType]
+}
+
+// ActiveChannels returns the number of channels opened by the remote proxy that are
+// currently being served
+func (s *SSHSession) ActiveChannels() int {
+	return int(atomic.LoadInt32(&s.activeChannels))
+}
+
+// TrackChannel records the connection to the remote proxy of an open channel, so that the
+// channel can be closed with a reason when the session shuts down. The returned function
+// forgets the channel, and must be called when it ends.
+func (s *SSHSession) TrackChannel(ced *ChannelEndpointDescriptor, conn ChannelConn) func() {
+	s.channelConnsLock.Lock()
+	s.channelConns[conn] = ced
+	s.channelConnsLock.Unlock()
+	return func() {
+		s.channelConnsLock.Lock()
+		delete(s.channelConns, conn)
+		s.channelConnsLock.Unlock()
+	}
+}
+
+// openChannels returns the number of the session's open channels
+func (s *SSHSession) openChannels() int {
+	s.channelConnsLock.Lock()
+	defer s.channelConnsLock.Unlock()
+	return len(s.channelConns)
+}
+
+// SetChannelCloseReason sets the reason given to the remote proxy for closing the session's
+// open channels when it shuts down, unless a reason has already been set. It should be called
+// before StartShutdown.
+func (s *SSHSession) SetChannelCloseReason(info *ChannelCloseInfo) {
+	s.channelConnsLock.Lock()
+	defer s.channelConnsLock.Unlock()
+	if s.channelCloseInfo == nil {
+		s.channelCloseInfo = info
+	}
+}
+
+// closeChannels closes the session's open channels, telling the remote proxy why, if a
+// reason has been set
+func (s *SSHSession) closeChannels() {
+	s.channelConnsLock.Lock()
+	info := s.channelCloseInfo
+	var conns []ChannelConn
+	if info != nil {
+		for conn := range s.channelConns {
+			conns = append(conns, conn)
+		}
+	}
+	s.channelConnsLock.Unlock()
+	for _, conn := range conns {
+		conn.SetCloseReason(info)
+		conn.Close()
+	}
+}
+
+// closeChannelsMatching closes the session's open channels whose local endpoint matches,
+// telling the remote proxy why. Returns the number of channels closed.
+func (s *SSHSession) closeChannelsMatching(match func(ced *ChannelEndpointDescriptor) bool, info *ChannelCloseInfo) int {
+	s.channelConnsLock.Lock()
+	var conns []ChannelConn
+	for conn, ced := range s.channelConns {
+		if match(ced) {
+			conns = append(conns, conn)
+		}
+	}
+	s.channelConnsLock.Unlock()
+	for _, conn := range conns {
+		conn.SetCloseReason(info)
+		conn.Close()
+	}
+	return len(conns)
+}
+
+// ID returns the unique id of this session
+func (s *SSHSession) ID() int32 {
+	return s.id
 }
 
 func (s *SSHSession) String() string {
@@ -99,7 +222,8 @@ func (s *SSHSession) sendSSHErrorReply(ctx context.Context, r *ssh.Request, err
 	return s.sendSSHReply(ctx, r, false, []byte(err.Error()))
 }
 
-// handleSSHRequests handles incoming requests for the SSH session. Currently only ping is supported.
+// handleSSHRequests handles incoming requests for the SSH session. Other than ping, only
+// request types registered with RegisterSSHRequestHandler are supported.
 func (s *SSHSession) handleSSHRequests(ctx context.Context, sshRequests <-chan *ssh.Request) {
 	for {
 		select {
@@ -108,6 +232,13 @@ func (s *SSHSession) handleSSHRequests(ctx context.Context, sshRequests <-chan *
 				s.DLogf("End of incoming SSH request stream")
 				return
 			}
+			if handler := s.getSSHRequestHandler(req.Type); handler != nil {
+				err := handler(ctx, req)
+				if err != nil {
+					s.DLogf("SSH request \"%s\" failed: %s", req.Type, err)
+				}
+				continue
+			}
 			switch req.Type {
 			case "ping":
 				err := s.sendSSHReply(ctx, req, true, nil)
@@ -147,10 +278,31 @@ func (s *SSHSession) handleSSHNewChannel(ctx context.Context, ch ssh.NewChannel)
 	if err != nil {
 		return reject(ssh.UnknownChannelType, s.Errorf("Badly formatted NewChannel request"))
 	}
-	s.DLogf("SSH NewChannel request, endpoint ='%s'", epd.String())
+	if err := epd.ValidateSkeleton(); err != nil {
+		return reject(ssh.Prohibited, s.Errorf("Invalid NewChannel endpoint: %s", err))
+	}
+	ctx = newChannelContext(ctx, epd)
+	s.DLogf("%sSSH NewChannel request, endpoint ='%s'", requestLogPrefix(ctx), epd.String())
 
 	// TODO: ***MUST*** implement access control here
 
+	if err := s.localChannelEnv.CheckChannelAdmission(); err != nil {
+		return reject(ssh.ResourceShortage, err)
+	}
+
+	if err := s.localChannelEnv.CheckChannelPolicy(ctx, epd); err != nil {
+		return reject(ssh.Prohibited, err)
+	}
+
+	if holdTime := EndpointHoldTime(epd); holdTime > 0 {
+		if held := s.localChannelEnv.GetHeldChannels(); held != nil {
+			return s.serveHeldChannel(ctx, held, ch, epd, holdTime)
+		}
+	}
+
+	atomic.AddInt32(&s.activeChannels, 1)
+	defer atomic.AddInt32(&s.activeChannels, -1)
+
 	ep, err := NewLocalSkeletonChannelEndpoint(s.Logger, s.localChannelEnv, epd)
 	if err != nil {
 		s.DLogf("Failed to create skeleton endpoint for SSH NewChannel: %s", err)
@@ -159,41 +311,69 @@ func (s *SSHSession) handleSSHNewChannel(ctx context.Context, ch ssh.NewChannel)
 
 	s.AddShutdownChild(ep)
 
-	// TODO: The actual local connect request should succeed before we accept the remote request.
-	//       Need to refactor code here
+	// connect to the Called Service before accepting the channel, so that a failure is
+	// reported to the remote proxy as a rejection with its reason
+	var extraData []byte
+	calledServiceConn, err := ep.Dial(ctx, extraData)
+	if err != nil {
+		ep.Close()
+		logChannelDialFailure(ctx, s.Logger, s.localChannelEnv.GetStatsRegistry(), err)
+		return reject(ssh.ConnectionFailed, err)
+	}
+
 	// TODO: Allow cancellation with ctx
 	sshChannel, sshRequests, err := ch.Accept()
 	if err != nil {
 		s.DLogf("Failed to accept SSH NewChannel: %s", err)
+		calledServiceConn.Close()
 		ep.Close()
 		return err
 	}
 
-	// This will shut down when sshChannel is closed
-	go ssh.DiscardRequests(sshRequests)
-
 	// wrap the ssh.Channel to look like a ChannelConn
-	sshConn, err := NewSSHConn(s.Logger, sshChannel)
+	sshConn, err := NewSSHConn(s.Logger, sshChannel, sshRequests)
 	if err != nil {
 		s.DLogf("Failed wrap SSH NewChannel: %s", err)
 		sshChannel.Close()
+		calledServiceConn.Close()
 		ep.Close()
 		return err
 	}
+	defer s.localChannelEnv.TrackChannel(epd, sshConn)()
 
 	// sshChannel is now wrapped by sshConn, and will be closed when sshConn is closed
 
-	var extraData []byte
-	numSent, numReceived, err := ep.DialAndServe(ctx, sshConn, extraData)
+	sshConn.SetPriority(s.localChannelEnv.GetWriteScheduler(), EndpointChannelPriority(epd))
 
-	// sshConn and sshChannel have now been closed
+	callerConn, err := WrapE2EChannelConn(s.localChannelEnv, epd, sshConn)
+	if err != nil {
+		s.DLogf("Failed to set up end-to-end encryption: %s", err)
+		sshConn.Close()
+		calledServiceConn.Close()
+		ep.Close()
+		return err
+	}
 
+	callerConn, err = WrapCompressChannelConn(epd, callerConn)
 	if err != nil {
-		s.DLogf("NewChannel session ended with error after %d bytes (caller->called), %d bytes (called->caller): %s", numSent, numReceived, err)
-	} else {
-		s.DLogf("NewChannel session ended normally after %d bytes (caller->called), %d bytes (called->caller)", numSent, numReceived)
+		s.DLogf("Failed to set up compression: %s", err)
+		sshConn.Close()
+		calledServiceConn.Close()
+		ep.Close()
+		return err
 	}
 
+	callerConn = s.localChannelEnv.TapChannel(ctx, epd, callerConn)
+	callerConn = s.localChannelEnv.IdentifyChannel(epd, callerConn)
+	callerConn = ShadowChannelConn(s.Logger, s.localChannelEnv, epd, callerConn)
+	callerConn = s.localChannelEnv.LimitChannel(epd, callerConn)
+
+	numSent, numReceived, err := BasicBridgeChannels(ctx, s.Logger, callerConn, calledServiceConn)
+
+	// sshConn and sshChannel have now been closed
+
+	logChannelClose(ctx, s.Logger, s.localChannelEnv.GetStatsRegistry(), channelCloseInfo(sshConn.CloseReason(), err), numSent, numReceived)
+
 	return err
 }
 
@@ -217,6 +397,7 @@ func (s *SSHSession) handleSSHChannels(ctx context.Context, newChannels <-chan s
 // as an advisory completion value, actually shut down, then return the real completion value.
 func (s *SSHSession) HandleOnceShutdown(completionErr error) error {
 	var err error
+	s.closeChannels()
 	if s.sshConn != nil {
 		s.sshConn.Close()
 	}
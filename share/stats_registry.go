@@ -0,0 +1,256 @@
+package chshare
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// StatType describes how a Stat value should be interpreted by a metrics consumer
+type StatType string
+
+const (
+	// StatTypeCounter is a monotonically increasing count of events
+	StatTypeCounter StatType = "counter"
+
+	// StatTypeGauge is a value that may go up or down (e.g., number of open connections)
+	StatTypeGauge StatType = "gauge"
+)
+
+// StatLabels is a set of name/value pairs that distinguish multiple instances of
+// the same named Stat (e.g., one per channel descriptor)
+type StatLabels map[string]string
+
+// String renders a StatLabels in metrics exposition format, e.g. {a="x",b="y"}. Labels are
+// sorted by name so that the result can be used as a stable key.
+func (l StatLabels) String() string {
+	if len(l) == 0 {
+		return ""
+	}
+	names := make([]string, 0, len(l))
+	for name := range l {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	parts := make([]string, len(names))
+	for i, name := range names {
+		parts[i] = name + "=\"" + escapeStatLabelValue(l[name]) + "\""
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
+
+var statLabelValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeStatLabelValue(s string) string {
+	return statLabelValueEscaper.Replace(s)
+}
+
+// Stat is a single named, labelled int64 metric value. All methods are safe for concurrent use.
+type Stat struct {
+	name     string
+	statType StatType
+	labels   StatLabels
+	key      string
+	value    int64
+
+	// refs is the number of references taken by Counter and Gauge that have not been
+	// released with Unregister. Protected by the registry's lock.
+	refs int
+}
+
+// Name returns the metric name of the Stat
+func (s *Stat) Name() string {
+	return s.name
+}
+
+// Type returns the type of the Stat
+func (s *Stat) Type() StatType {
+	return s.statType
+}
+
+// Labels returns the labels of the Stat. The result must not be modified.
+func (s *Stat) Labels() StatLabels {
+	return s.labels
+}
+
+// Add adds a delta to the Stat value and returns the new value
+func (s *Stat) Add(delta int64) int64 {
+	return atomic.AddInt64(&s.value, delta)
+}
+
+// Inc adds one to the Stat value
+func (s *Stat) Inc() {
+	s.Add(1)
+}
+
+// Dec subtracts one from the Stat value
+func (s *Stat) Dec() {
+	s.Add(-1)
+}
+
+// Set sets the Stat value. Only meaningful for gauges
+func (s *Stat) Set(value int64) {
+	atomic.StoreInt64(&s.value, value)
+}
+
+// SetBool sets the Stat value to 1 if b is true, or 0 if it is false. Only meaningful for gauges
+func (s *Stat) SetBool(b bool) {
+	var v int64
+	if b {
+		v = 1
+	}
+	s.Set(v)
+}
+
+// Get returns the current Stat value
+func (s *Stat) Get() int64 {
+	return atomic.LoadInt64(&s.value)
+}
+
+// statFamily is the collection of all Stats that share a name
+type statFamily struct {
+	name     string
+	help     string
+	statType StatType
+	stats    map[string]*Stat
+}
+
+// StatsRegistry is a named collection of counters and gauges that can be rendered
+// in a text metrics exposition format, for example for a /metrics HTTP endpoint.
+type StatsRegistry struct {
+	lock     sync.Mutex
+	families map[string]*statFamily
+}
+
+// NewStatsRegistry creates a new, empty StatsRegistry
+func NewStatsRegistry() *StatsRegistry {
+	return &StatsRegistry{
+		families: make(map[string]*statFamily),
+	}
+}
+
+// getStat returns the Stat with the given name and labels, creating it if it does not
+// already exist, and takes a reference to it. Panics if a Stat with the same name has
+// already been registered with a different type.
+func (r *StatsRegistry) getStat(name string, help string, statType StatType, labels StatLabels) *Stat {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	family, ok := r.families[name]
+	if !ok {
+		family = &statFamily{
+			name:     name,
+			help:     help,
+			statType: statType,
+			stats:    make(map[string]*Stat),
+		}
+		r.families[name] = family
+	} else if family.statType != statType {
+		panic(fmt.Sprintf("Stat %s registered as both %s and %s", name, family.statType, statType))
+	}
+	key := labels.String()
+	stat, ok := family.stats[key]
+	if !ok {
+		copiedLabels := make(StatLabels, len(labels))
+		for k, v := range labels {
+			copiedLabels[k] = v
+		}
+		stat = &Stat{name: name, statType: statType, labels: copiedLabels, key: key}
+		family.stats[key] = stat
+	}
+	stat.refs++
+	return stat
+}
+
+// Counter returns the counter Stat with the given name and labels, creating it if necessary
+func (r *StatsRegistry) Counter(name string, help string, labels StatLabels) *Stat {
+	return r.getStat(name, help, StatTypeCounter, labels)
+}
+
+// Gauge returns the gauge Stat with the given name and labels, creating it if necessary
+func (r *StatsRegistry) Gauge(name string, help string, labels StatLabels) *Stat {
+	return r.getStat(name, help, StatTypeGauge, labels)
+}
+
+// Unregister releases a reference to a Stat taken by Counter or Gauge, and removes the Stat
+// from the registry once every reference has been released, so that Stats labelled with
+// something short-lived, such as a channel descriptor, do not accumulate. Has no effect if
+// the Stat is not registered.
+func (r *StatsRegistry) Unregister(stat *Stat) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	family, ok := r.families[stat.name]
+	if !ok || family.stats[stat.key] != stat {
+		return
+	}
+	stat.refs--
+	if stat.refs <= 0 {
+		delete(family.stats, stat.key)
+		if len(family.stats) == 0 {
+			delete(r.families, stat.name)
+		}
+	}
+}
+
+// Snapshot returns a copy of all registered Stats, sorted by name and labels
+func (r *StatsRegistry) Snapshot() []*Stat {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	var result []*Stat
+	for _, family := range r.families {
+		for _, stat := range family.stats {
+			result = append(result, &Stat{
+				name:     stat.name,
+				statType: stat.statType,
+				labels:   stat.labels,
+				key:      stat.key,
+				value:    stat.Get(),
+			})
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].name != result[j].name {
+			return result[i].name < result[j].name
+		}
+		return result[i].key < result[j].key
+	})
+	return result
+}
+
+// WriteText writes all registered Stats to a writer in text exposition format
+func (r *StatsRegistry) WriteText(w io.Writer) error {
+	r.lock.Lock()
+	names := make([]string, 0, len(r.families))
+	for name := range r.families {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	bw := bufio.NewWriter(w)
+	for _, name := range names {
+		family := r.families[name]
+		if family.help != "" {
+			fmt.Fprintf(bw, "# HELP %s %s\n", name, family.help)
+		}
+		fmt.Fprintf(bw, "# TYPE %s %s\n", name, family.statType)
+		keys := make([]string, 0, len(family.stats))
+		for key := range family.stats {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			fmt.Fprintf(bw, "%s%s %d\n", name, key, family.stats[key].Get())
+		}
+	}
+	r.lock.Unlock()
+	return bw.Flush()
+}
+
+// ServeHTTP implements http.Handler, responding with all registered Stats in text exposition format
+func (r *StatsRegistry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	r.WriteText(w)
+}
@@ -0,0 +1,43 @@
+package chshare
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestStatsRegistrySharedStatUnregister(t *testing.T) {
+	r := NewStatsRegistry()
+	labels := StatLabels{"descriptor": "3000:localhost:80"}
+	first := r.Counter("chisel_test_total", "Test counter", labels)
+	second := r.Counter("chisel_test_total", "Test counter", labels)
+	if first != second {
+		t.Fatalf("stats with the same name and labels are not shared")
+	}
+	first.Inc()
+
+	r.Unregister(first)
+	if len(r.Snapshot()) != 1 {
+		t.Fatalf("stat removed while still referenced")
+	}
+	var b bytes.Buffer
+	if err := r.WriteText(&b); err != nil {
+		t.Fatalf("WriteText failed: %s", err)
+	}
+	if !strings.Contains(b.String(), "chisel_test_total") {
+		t.Fatalf("stat missing from text output while still referenced:\n%s", b.String())
+	}
+
+	r.Unregister(second)
+	if len(r.Snapshot()) != 0 {
+		t.Fatalf("stat not removed after its last reference was released")
+	}
+
+	// releasing a stat that has been replaced leaves the replacement registered
+	third := r.Counter("chisel_test_total", "Test counter", labels)
+	r.Unregister(first)
+	if len(r.Snapshot()) != 1 {
+		t.Fatalf("stale release removed a newly registered stat")
+	}
+	r.Unregister(third)
+}
@@ -0,0 +1,156 @@
+package chshare
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/XevoInc/chisel/chprotobuf"
+	"github.com/golang/protobuf/proto"
+	"golang.org/x/crypto/ssh"
+)
+
+// StatsUpdateRequestType is the SSH request type used by either side to periodically send
+// the other the traffic of each of the session's channel descriptors since its previous
+// update, so that both sides' metrics show the same volumes. No reply is wanted. The payload
+// is a protobuf StatsUpdate.
+const StatsUpdateRequestType = "stats-update"
+
+// StatsUpdate holds the traffic of channel descriptors since the previous update. Active
+// connections are not sent.
+type StatsUpdate struct {
+	Channels map[string]TrafficTotals
+}
+
+// Unmarshal unserializes a StatsUpdate from protobuf bytes
+func (u *StatsUpdate) Unmarshal(b []byte) error {
+	pb := &chprotobuf.PbStatsUpdate{}
+	err := proto.Unmarshal(b, pb)
+	if err != nil {
+		return fmt.Errorf("Invalid protobuf data for StatsUpdate")
+	}
+	u.Channels = make(map[string]TrafficTotals, len(pb.GetChannels()))
+	for _, ch := range pb.GetChannels() {
+		u.Channels[ch.GetChannelDescriptor()] = TrafficTotals{
+			Conns:         ch.GetConns(),
+			BytesToCalled: ch.GetBytesToCalled(),
+			BytesToCaller: ch.GetBytesToCaller(),
+		}
+	}
+	return nil
+}
+
+// Marshal serializes a StatsUpdate to protobuf bytes
+func (u *StatsUpdate) Marshal() ([]byte, error) {
+	pb := &chprotobuf.PbStatsUpdate{}
+	for key, t := range u.Channels {
+		pb.Channels = append(pb.Channels, &chprotobuf.PbChannelStats{
+			ChannelDescriptor: key,
+			Conns:             t.Conns,
+			BytesToCalled:     t.BytesToCalled,
+			BytesToCaller:     t.BytesToCaller,
+		})
+	}
+	return proto.Marshal(pb)
+}
+
+// recordTraffic adds the traffic of channel descriptors to the counters of a stats registry,
+// labeled with the side that counted it: "local" or "peer"
+func recordTraffic(stats *StatsRegistry, side string, channels map[string]TrafficTotals) {
+	for key, t := range channels {
+		stats.Counter(
+			"chisel_channel_connections_total",
+			"Number of connections opened for a channel descriptor, as counted by this side or its peer",
+			StatLabels{"descriptor": key, "side": side}).Add(t.Conns)
+		stats.Counter(
+			"chisel_channel_bytes_total",
+			"Number of bytes sent to and from the Called Service of a channel descriptor, as counted by this side or its peer",
+			StatLabels{"descriptor": key, "side": side, "direction": "to_called"}).Add(t.BytesToCalled)
+		stats.Counter(
+			"chisel_channel_bytes_total",
+			"Number of bytes sent to and from the Called Service of a channel descriptor, as counted by this side or its peer",
+			StatLabels{"descriptor": key, "side": side, "direction": "to_caller"}).Add(t.BytesToCaller)
+	}
+}
+
+// sendStatsUpdate sends the traffic counted since the previous update to the peer, if there
+// has been any, and adds it to the local counters
+func sendStatsUpdate(logger Logger, sshConn ssh.Conn, stats *StatsRegistry, traffic *trafficCounter) {
+	update := &StatsUpdate{Channels: traffic.deltas()}
+	if len(update.Channels) == 0 {
+		return
+	}
+	recordTraffic(stats, "local", update.Channels)
+	payload, err := update.Marshal()
+	if err != nil {
+		logger.DLogf("Unable to serialize stats update: %s", err)
+		return
+	}
+	if _, _, err := sshConn.SendRequest(StatsUpdateRequestType, false, payload); err != nil {
+		logger.DLogf("Unable to send stats update: %s", err)
+	}
+}
+
+// handleStatsUpdate adds the traffic in a stats update from the peer to the peer counters
+func handleStatsUpdate(logger Logger, stats *StatsRegistry, req *ssh.Request) error {
+	if req.WantReply {
+		req.Reply(true, nil)
+	}
+	update := &StatsUpdate{}
+	if err := update.Unmarshal(req.Payload); err != nil {
+		return logger.DLogErrorf("Invalid stats update: %s", err)
+	}
+	recordTraffic(stats, "peer", update.Channels)
+	return nil
+}
+
+// statsUpdateLoop sends the session's traffic to the server every StatsUpdateInterval while
+// connected, until the client shuts down
+func (c *Client) statsUpdateLoop(ctx context.Context) {
+	for {
+		select {
+		case <-c.clock.After(c.config.StatsUpdateInterval):
+			if sshConn := c.getConnectedSSHConn(); sshConn != nil {
+				sendStatsUpdate(c.Logger, sshConn, c.stats, c.traffic)
+			}
+		case <-c.ShutdownStartedChan():
+			return
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// statsUpdateLoop sends the session's traffic to the client every stats update interval
+// until the session ends
+func (s *ServerSSHSession) statsUpdateLoop(ctx context.Context) {
+	for {
+		select {
+		case <-s.server.clock.After(s.server.statsUpdateInterval):
+			sendStatsUpdate(s.Logger, s.sshConn, s.server.stats, s.traffic)
+		case <-s.ShutdownStartedChan():
+			return
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// handleStatsUpdateRequest handles a stats update from the client
+func (s *ServerSSHSession) handleStatsUpdateRequest(ctx context.Context, r *ssh.Request) error {
+	return handleStatsUpdate(s.Logger, s.server.stats, r)
+}
+
+// TrackChannel records an open channel so that it can be closed with a reason when the
+// session shuts down, and counts it towards the traffic of its channel descriptor
+func (s *ServerSSHSession) TrackChannel(ced *ChannelEndpointDescriptor, conn ChannelConn) func() {
+	untrack := s.SSHSession.TrackChannel(ced, conn)
+	key := s.descriptorKeyForEndpoint(ced)
+	if key == "" {
+		return untrack
+	}
+	untrackTraffic := s.traffic.track(key, ced.Role == ChannelEndpointRoleSkeleton, conn)
+	return func() {
+		untrackTraffic()
+		untrack()
+	}
+}
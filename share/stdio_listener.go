@@ -0,0 +1,87 @@
+package chshare
+
+import (
+	"errors"
+	"io"
+	"net"
+	"os"
+	"sync"
+)
+
+// errListenerClosed is returned by Accept once a singleConnListener's connection has closed
+var errListenerClosed = errors.New("Listener closed")
+
+// NewStdioConn returns a net.Conn that reads from stdin and writes to stdout. If stdin is a
+// socket (e.g., when started by inetd or a systemd socket with Accept=yes), the socket
+// itself is used, so that the peer's address is known.
+func NewStdioConn() net.Conn {
+	// net.FileConn makes the file nonblocking even if it fails, so only try it on a socket
+	if fi, err := os.Stdin.Stat(); err == nil && fi.Mode()&os.ModeSocket != 0 {
+		if conn, err := net.FileConn(os.Stdin); err == nil {
+			return conn
+		}
+	}
+	// Serving HTTP needs a net.Conn with working deadlines, which net.Pipe provides
+	conn, peer := net.Pipe()
+	go func() {
+		io.Copy(peer, os.Stdin)
+		peer.Close()
+	}()
+	go func() {
+		io.Copy(os.Stdout, peer)
+		os.Stdout.Close()
+	}()
+	return conn
+}
+
+// singleConnListener is a net.Listener that accepts exactly one, already established,
+// connection. After that, Accept blocks until the connection or the listener is closed.
+type singleConnListener struct {
+	conn      net.Conn
+	accepted  bool
+	lock      sync.Mutex
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// newSingleConnListener creates a listener that accepts conn
+func newSingleConnListener(conn net.Conn) *singleConnListener {
+	return &singleConnListener{conn: conn, done: make(chan struct{})}
+}
+
+// Accept returns the connection on the first call, then waits for it to be closed
+func (l *singleConnListener) Accept() (net.Conn, error) {
+	l.lock.Lock()
+	accepted := l.accepted
+	l.accepted = true
+	l.lock.Unlock()
+	if !accepted {
+		return &singleConnListenerConn{Conn: l.conn, listener: l}, nil
+	}
+	<-l.done
+	return nil, errListenerClosed
+}
+
+// Close closes the listener; the accepted connection is unaffected
+func (l *singleConnListener) Close() error {
+	l.closeOnce.Do(func() { close(l.done) })
+	return nil
+}
+
+// Addr returns the local address of the connection
+func (l *singleConnListener) Addr() net.Addr {
+	return l.conn.LocalAddr()
+}
+
+// singleConnListenerConn closes its singleConnListener when it is closed, including after
+// being hijacked for a websocket
+type singleConnListenerConn struct {
+	net.Conn
+	listener *singleConnListener
+}
+
+func (c *singleConnListenerConn) Close() error {
+	err := c.Conn.Close()
+	c.listener.Close()
+	return err
+}
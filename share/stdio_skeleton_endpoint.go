@@ -70,7 +70,7 @@ func (ep *StdioSkeletonEndpoint) DialAndServe(
 ) (int64, int64, error) {
 	calledServiceConn, err := ep.Dial(ctx, extraData)
 	if err != nil {
-		callerConn.Close()
+		closeOnDialFailure(callerConn, err)
 		return 0, 0, err
 	}
 	return BasicBridgeChannels(ctx, ep.Logger, callerConn, calledServiceConn)
@@ -0,0 +1,200 @@
+package chshare
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/http2"
+)
+
+// The HTTP/2 stream transport carries a client session over a single HTTP/2 POST request
+// whose request and response bodies stream in both directions at once, like a gRPC
+// bidirectional stream. It works through ingresses that only speak HTTP/2 to their backends,
+// which cannot pass on a websocket upgrade. The request has a StreamProtocolHeader holding
+// ProtocolVersion; the server answers with 200 before it reads any of the body. Over http://
+// URLs, the client speaks cleartext HTTP/2 (h2c) with prior knowledge.
+const StreamProtocolHeader = "X-Chisel-Stream"
+
+// streamDialTimeout is how long the client waits for the server to answer a stream request
+const streamDialTimeout = 45 * time.Second
+
+// IsStreamRequest returns true if r is an HTTP/2 stream transport request, of any protocol
+// version
+func IsStreamRequest(r *http.Request) bool {
+	return r.Method == http.MethodPost && r.Header.Get(StreamProtocolHeader) != ""
+}
+
+// streamAddr is the net.Addr of either end of an HTTP/2 stream transport
+type streamAddr string
+
+func (a streamAddr) Network() string { return "http2-stream" }
+func (a streamAddr) String() string  { return string(a) }
+
+// streamConn is the net.Conn common to both ends of an HTTP/2 stream transport
+type streamConn struct {
+	body   io.ReadCloser
+	local  net.Addr
+	remote net.Addr
+
+	// writeLock serializes writes, and protects closed, so that nothing is written once
+	// the transport is closed
+	writeLock sync.Mutex
+	closed    bool
+	write     func(p []byte) (int, error)
+
+	// onClose, if not nil, is called when the transport is closed, before waiting for a
+	// write in progress, which it must unblock
+	onClose   func()
+	closeOnce sync.Once
+}
+
+func (c *streamConn) Read(p []byte) (int, error) { return c.body.Read(p) }
+
+func (c *streamConn) Write(p []byte) (int, error) {
+	c.writeLock.Lock()
+	defer c.writeLock.Unlock()
+	if c.closed {
+		return 0, io.ErrClosedPipe
+	}
+	return c.write(p)
+}
+
+// Close closes both directions of the transport
+func (c *streamConn) Close() error {
+	c.closeOnce.Do(func() {
+		c.body.Close()
+		if c.onClose != nil {
+			c.onClose()
+		}
+		c.writeLock.Lock()
+		c.closed = true
+		c.writeLock.Unlock()
+	})
+	return nil
+}
+
+func (c *streamConn) LocalAddr() net.Addr                { return c.local }
+func (c *streamConn) RemoteAddr() net.Addr               { return c.remote }
+func (c *streamConn) SetDeadline(t time.Time) error      { return nil }
+func (c *streamConn) SetReadDeadline(t time.Time) error  { return nil }
+func (c *streamConn) SetWriteDeadline(t time.Time) error { return nil }
+
+// handleStreamRequest runs a client session over an HTTP/2 stream transport request, and
+// returns once the session has ended
+func (s *Server) handleStreamRequest(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	protocol := r.Header.Get(StreamProtocolHeader)
+	if protocol != ProtocolVersion {
+		s.ILogf("HTTP/2 stream client using unsupported protocol '%s', expected '%s'",
+			protocol, ProtocolVersion)
+		s.rejectUpgrade(w, http.StatusBadRequest, &UpgradeRejection{
+			Reason:  UpgradeRejectedProtocol,
+			Message: fmt.Sprintf("Unsupported protocol '%s'", protocol),
+		})
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if r.ProtoMajor < 2 || !ok {
+		//an HTTP/1.x request body cannot be read once the response has started
+		s.DLogf("Refusing HTTP/2 stream transport request made with %s", r.Proto)
+		s.rejectUpgrade(w, http.StatusHTTPVersionNotSupported, &UpgradeRejection{
+			Reason: UpgradeRejectedHTTP2Required,
+			Message: fmt.Sprintf("The HTTP/2 stream transport reached the server over %s; "+
+				"the server needs --http2, and every hop must speak HTTP/2", r.Proto),
+		})
+		return
+	}
+	if status, rejection := s.checkUpgrade(r); rejection != nil {
+		s.rejectUpgrade(w, status, rejection)
+		return
+	}
+	s.DLogf("Opening HTTP/2 stream transport from %s", r.RemoteAddr)
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+	conn := &streamConn{
+		body:   r.Body,
+		local:  streamAddr(r.Host),
+		remote: streamAddr(r.RemoteAddr),
+		write: func(p []byte) (int, error) {
+			n, err := w.Write(p)
+			if err == nil {
+				flusher.Flush()
+			}
+			return n, err
+		},
+	}
+	//the response may not be written once the handler returns, so it waits for the session
+	s.handleTransport(ctx, conn, r.TLS)
+}
+
+// DialStreamTransport opens an HTTP/2 stream transport to a chisel server at an http or
+// https URL. header is added to the request, tlsConfig, if not nil, configures https
+// connections, and clock measures the wait for the server to answer.
+func DialStreamTransport(clock Clock, serverURL string, header http.Header, tlsConfig *tls.Config) (net.Conn, error) {
+	u, err := url.Parse(serverURL)
+	if err != nil {
+		return nil, err
+	}
+	httpTransport := &http2.Transport{TLSClientConfig: tlsConfig}
+	if u.Scheme == "http" {
+		httpTransport.AllowHTTP = true
+		httpTransport.DialTLS = func(network, addr string, cfg *tls.Config) (net.Conn, error) {
+			return net.DialTimeout(network, addr, streamDialTimeout)
+		}
+	}
+	bodyReader, bodyWriter := io.Pipe()
+	req, err := http.NewRequest(http.MethodPost, serverURL, bodyReader)
+	if err != nil {
+		return nil, err
+	}
+	for name, values := range header {
+		if strings.EqualFold(name, "Host") {
+			req.Host = values[0]
+			continue
+		}
+		req.Header[name] = values
+	}
+	req.Header.Set(StreamProtocolHeader, ProtocolVersion)
+	req.Header.Set("Content-Type", "application/octet-stream")
+	//the request lasts as long as the transport, so only the wait for a response times out
+	ctx, cancel := context.WithCancel(context.Background())
+	timer := clock.AfterFunc(streamDialTimeout, cancel)
+	resp, err := httpTransport.RoundTrip(req.WithContext(ctx))
+	if !timer.Stop() && err == nil {
+		resp.Body.Close()
+		err = fmt.Errorf("Timed out waiting for the server to answer")
+	}
+	if err != nil {
+		cancel()
+		bodyWriter.Close()
+		return nil, fmt.Errorf("HTTP/2 stream request failed (the server needs --http2, and every "+
+			"proxy on the way must speak HTTP/2): %s", err)
+	}
+	if resp.StatusCode/100 != 2 {
+		statusErr := newHTTPStatusError(resp)
+		resp.Body.Close()
+		cancel()
+		bodyWriter.Close()
+		return nil, fmt.Errorf("HTTP/2 stream request failed: %w", statusErr)
+	}
+	return &streamConn{
+		body:   resp.Body,
+		local:  streamAddr("client"),
+		remote: streamAddr(u.Host),
+		write:  bodyWriter.Write,
+		onClose: func() {
+			bodyWriter.Close()
+			cancel()
+			httpTransport.CloseIdleConnections()
+		},
+	}, nil
+}
@@ -0,0 +1,106 @@
+package chshare
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"time"
+)
+
+// stubTLSHandshakeTimeout is how long a local application has to complete the TLS handshake
+// with a stub listener that requires TLS
+const stubTLSHandshakeTimeout = 10 * time.Second
+
+// stubTLSOptions are the descriptor options that together make a stub listener require TLS
+// with client certificates
+var stubTLSOptions = []string{"tls-cert", "tls-key", "tls-client-ca"}
+
+// validateStubTLSFileOption validates the value of the "tls-cert", "tls-key" and
+// "tls-client-ca" descriptor options, which are file paths
+func validateStubTLSFileOption(value string) error {
+	if value == "" {
+		return fmt.Errorf("Empty TLS file path")
+	}
+	return nil
+}
+
+// HasStubTLSOptions returns true if any of the stub TLS options are set on an endpoint
+func HasStubTLSOptions(ced *ChannelEndpointDescriptor) bool {
+	for _, name := range stubTLSOptions {
+		if ced.Option(name) != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// checkStubTLSOptions verifies that an endpoint has either all or none of the stub TLS options
+func checkStubTLSOptions(ced *ChannelEndpointDescriptor) error {
+	if !HasStubTLSOptions(ced) {
+		return nil
+	}
+	for _, name := range stubTLSOptions {
+		if ced.Option(name) == "" {
+			return fmt.Errorf("Stub TLS requires all of the tls-cert, tls-key and tls-client-ca options; %s is missing", name)
+		}
+	}
+	return nil
+}
+
+// EndpointStubTLSConfig returns the TLS configuration of a stub endpoint that requires local
+// applications to connect with TLS and present a client certificate issued by one of the
+// CAs in its "tls-client-ca" file, or nil if it does not require TLS
+func EndpointStubTLSConfig(ced *ChannelEndpointDescriptor) (*tls.Config, error) {
+	if ced.Role != ChannelEndpointRoleStub || !HasStubTLSOptions(ced) {
+		return nil, nil
+	}
+	if err := checkStubTLSOptions(ced); err != nil {
+		return nil, err
+	}
+	cert, err := tls.LoadX509KeyPair(ced.Option("tls-cert"), ced.Option("tls-key"))
+	if err != nil {
+		return nil, fmt.Errorf("Unable to load stub TLS certificate: %s", err)
+	}
+	pem, err := ioutil.ReadFile(ced.Option("tls-client-ca"))
+	if err != nil {
+		return nil, fmt.Errorf("Unable to read stub TLS client CA file: %s", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("No certificates found in stub TLS client CA file %s", ced.Option("tls-client-ca"))
+	}
+	return &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		Certificates: []tls.Certificate{cert},
+		ClientCAs:    pool,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+	}, nil
+}
+
+// HandshakeConn is a ChannelConn that must complete a handshake with the Caller, such as a
+// TLS handshake, before it is used
+type HandshakeConn interface {
+	// Handshake completes the handshake, returning the name the Caller authenticated as, if
+	// any
+	Handshake() (string, error)
+}
+
+// Handshake completes the TLS handshake of a connection accepted by a stub listener that
+// requires TLS, within stubTLSHandshakeTimeout, and returns the common name of the
+// Caller's verified client certificate. It does nothing for other connections. Part of the
+// HandshakeConn interface.
+func (c *SocketConn) Handshake() (string, error) {
+	tlsConn, ok := c.netConn.(*tls.Conn)
+	if !ok {
+		return "", nil
+	}
+	tlsConn.SetDeadline(time.Now().Add(stubTLSHandshakeTimeout))
+	err := tlsConn.Handshake()
+	tlsConn.SetDeadline(time.Time{})
+	if err != nil {
+		return "", err
+	}
+	state := tlsConn.ConnectionState()
+	return VerifiedClientCertName(&state), nil
+}
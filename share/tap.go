@@ -0,0 +1,186 @@
+package chshare
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ChannelTapInfo describes a channel whose traffic is offered to a ChannelTap
+type ChannelTapInfo struct {
+	// ID uniquely identifies the channel within this process
+	ID int64 `json:"id"`
+
+	// User is the authenticated user of the session carrying the channel on the server, or
+	// the user the client authenticates as; empty if authentication is not in use
+	User string `json:"user,omitempty"`
+
+	// Endpoint is the local endpoint of the channel, which is a stub for channels accepted
+	// locally and a skeleton for channels requested by the remote proxy
+	Endpoint *ChannelEndpointDescriptor `json:"endpoint"`
+
+	// Started is the time the channel was opened
+	Started time.Time `json:"started"`
+
+	// Record, if not "", is the "record" option value that the channel's user requires, in
+	// place of its endpoint's own option
+	Record string `json:"record,omitempty"`
+}
+
+// ChannelTap is called as each channel is opened, and may return writers that receive a copy
+// of the channel's traffic in each direction: from the Caller to the Called Service, and from
+// the Called Service to the Caller. Either writer may be nil to skip that direction, so that a
+// tap can select the channels and directions it is interested in. The writers are closed when
+// the channel ends, if they implement io.Closer.
+//
+// The writers are called synchronously with the channel's traffic, so a slow writer slows the
+// channel. If a writer returns an error, that direction is no longer copied to it, but the
+// channel itself is unaffected. For end-to-end encrypted channels, the tap sees plaintext only
+// on the proxies that terminate the encryption.
+type ChannelTap func(info *ChannelTapInfo) (callerToService io.Writer, serviceToCaller io.Writer)
+
+// TapChannelConn offers a channel to a ChannelTap, and returns a ChannelConn that copies
+// the traffic the tap asks for. conn is the connection to the remote proxy for a channel
+// with the given local endpoint, whose ID and user are those of the RequestMetadata of ctx,
+// and whose start time is read from clock. If tap is nil, or it does not want any of the
+// traffic, conn is returned unchanged.
+func TapChannelConn(ctx context.Context, logger Logger, clock Clock, tap ChannelTap, ced *ChannelEndpointDescriptor, conn ChannelConn) ChannelConn {
+	if tap == nil {
+		return conn
+	}
+	info := &ChannelTapInfo{
+		Endpoint: ced,
+		Started:  clock.Now(),
+	}
+	if md := RequestMetadataFromContext(ctx); md != nil {
+		info.ID = md.ChannelID
+		info.User = md.User
+	}
+	if info.ID == 0 {
+		info.ID = atomic.AddInt64(&lastChannelID, 1)
+	}
+	callerToService, serviceToCaller := tap(info)
+	if callerToService == nil && serviceToCaller == nil {
+		return conn
+	}
+	c := &tapConn{ChannelConn: conn, logger: logger}
+	// On the stub side, data written to the remote proxy comes from the Caller; on the
+	// skeleton side, it comes from the Called Service
+	if ced.Role == ChannelEndpointRoleStub {
+		c.written, c.read = callerToService, serviceToCaller
+	} else {
+		c.written, c.read = serviceToCaller, callerToService
+	}
+	return c
+}
+
+// tapConn is a ChannelConn that copies the data read from and written to another ChannelConn
+type tapConn struct {
+	ChannelConn
+	logger    Logger
+	lock      sync.Mutex
+	read      io.Writer
+	written   io.Writer
+	closeOnce sync.Once
+}
+
+// tee copies data to *w, and stops copying to it after an error. The caller must hold the lock.
+func (c *tapConn) tee(w *io.Writer, data []byte) {
+	if *w == nil || len(data) == 0 {
+		return
+	}
+	if _, err := (*w).Write(data); err != nil {
+		c.logger.DLogf("Channel tap write failed, no longer tapping: %s", err)
+		closeTapWriter(*w)
+		*w = nil
+	}
+}
+
+func (c *tapConn) Read(p []byte) (int, error) {
+	n, err := c.ChannelConn.Read(p)
+	c.lock.Lock()
+	c.tee(&c.read, p[:n])
+	c.lock.Unlock()
+	return n, err
+}
+
+func (c *tapConn) Write(p []byte) (int, error) {
+	n, err := c.ChannelConn.Write(p)
+	c.lock.Lock()
+	c.tee(&c.written, p[:n])
+	c.lock.Unlock()
+	return n, err
+}
+
+// Close closes the underlying ChannelConn, then the tap's writers
+func (c *tapConn) Close() error {
+	err := c.ChannelConn.Close()
+	c.closeOnce.Do(func() {
+		c.lock.Lock()
+		closeTapWriter(c.read)
+		closeTapWriter(c.written)
+		c.read, c.written = nil, nil
+		c.lock.Unlock()
+	})
+	return err
+}
+
+func (c *tapConn) String() string {
+	return fmt.Sprintf("tap:%s", c.ChannelConn)
+}
+
+// closeTapWriter closes a tap writer if it is an io.Closer
+func closeTapWriter(w io.Writer) {
+	if closer, ok := w.(io.Closer); ok {
+		closer.Close()
+	}
+}
+
+// tapFileNameUnsafeChars matches characters that are replaced in capture file names
+var tapFileNameUnsafeChars = regexp.MustCompile(`[^a-zA-Z0-9._-]+`)
+
+// NewDirChannelTap returns a ChannelTap that captures the traffic of every channel to files
+// in a directory. Each channel has a "<name>.json" file describing it, and "<name>.c2s" and
+// "<name>.s2c" files holding the raw bytes sent from the Caller to the Called Service and
+// back, where <name> is built from the channel's start time, ID and user.
+func NewDirChannelTap(logger Logger, dir string) (ChannelTap, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("Unable to create channel capture directory: %s", err)
+	}
+	tap := func(info *ChannelTapInfo) (io.Writer, io.Writer) {
+		name := fmt.Sprintf("%s-%d", info.Started.UTC().Format("20060102T150405.000Z"), info.ID)
+		if info.User != "" {
+			name += "-" + tapFileNameUnsafeChars.ReplaceAllString(info.User, "_")
+		}
+		base := filepath.Join(dir, name)
+		meta, err := json.MarshalIndent(info, "", "  ")
+		if err == nil {
+			err = ioutil.WriteFile(base+".json", meta, 0600)
+		}
+		if err != nil {
+			logger.ILogf("Unable to write channel capture metadata %s.json: %s", base, err)
+			return nil, nil
+		}
+		c2s, err := os.OpenFile(base+".c2s", os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+		if err != nil {
+			logger.ILogf("Unable to create channel capture file: %s", err)
+			return nil, nil
+		}
+		s2c, err := os.OpenFile(base+".s2c", os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+		if err != nil {
+			c2s.Close()
+			logger.ILogf("Unable to create channel capture file: %s", err)
+			return nil, nil
+		}
+		return c2s, s2c
+	}
+	return tap, nil
+}
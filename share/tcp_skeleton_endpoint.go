@@ -9,14 +9,45 @@ import (
 type TCPSkeletonEndpoint struct {
 	// Implements LocalSkeletonChannelEndpoint
 	BasicEndpoint
+	clock    Clock
+	sockOpts *SocketOptions
+	pin      *addressPin
+	source   *DialSource
+	proxy    *DialProxy
 }
 
-// NewTCPSkeletonEndpoint creates a new TCPSkeletonEndpoint
-func NewTCPSkeletonEndpoint(logger Logger, ced *ChannelEndpointDescriptor) (*TCPSkeletonEndpoint, error) {
+// NewTCPSkeletonEndpoint creates a new TCPSkeletonEndpoint. Its connections come from the
+// descriptor's "source" option, if it has one, or else from defaultSource, if not nil. If
+// proxy is not nil, they are made through it. clock measures the age of pinned addresses.
+func NewTCPSkeletonEndpoint(
+	logger Logger,
+	stats *StatsRegistry,
+	clock Clock,
+	ced *ChannelEndpointDescriptor,
+	defaultSource *DialSource,
+	proxy *DialProxy,
+) (*TCPSkeletonEndpoint, error) {
+	sockOpts, err := EndpointSocketOptions(ced)
+	if err != nil {
+		return nil, err
+	}
+	pin, err := endpointAddressPin(stats, ced)
+	if err != nil {
+		return nil, err
+	}
+	source, err := endpointDialSource(ced, defaultSource)
+	if err != nil {
+		return nil, err
+	}
 	ep := &TCPSkeletonEndpoint{
 		BasicEndpoint: BasicEndpoint{
 			ced: ced,
 		},
+		clock:    clock,
+		sockOpts: sockOpts,
+		pin:      pin,
+		source:   source,
+		proxy:    proxy,
 	}
 	ep.InitBasicEndpoint(logger, ep, "TCPSkeletonEndpoint: %s", ced)
 	return ep, nil
@@ -38,9 +69,37 @@ func (ep *TCPSkeletonEndpoint) Dial(ctx context.Context, extraData []byte) (Chan
 		return nil, err
 	}
 
-	// TODO: make sure IPV6 works
-	var d net.Dialer
-	netConn, err := d.DialContext(ctx, "tcp", ep.ced.Path)
+	addrs := []string{ep.ced.Path}
+	if ep.pin != nil {
+		pinned, err := ep.pin.Addrs(ctx, ep.Logger, ep.clock)
+		if err != nil {
+			return nil, ep.Errorf("%s", err)
+		}
+		addrs = pinned
+	}
+
+	network := ep.sockOpts.Network("tcp")
+	dialer := ep.sockOpts.Dialer()
+	if ep.source != nil {
+		laddr, err := ep.source.LocalAddr(network)
+		if err != nil {
+			return nil, ep.Errorf("%s", err)
+		}
+		dialer.LocalAddr = laddr
+	}
+
+	var netConn net.Conn
+	var err error
+	for _, addr := range addrs {
+		if ep.proxy != nil {
+			netConn, err = ep.proxy.DialContext(ctx, dialer, addr)
+		} else {
+			netConn, err = dialer.DialContext(ctx, network, addr)
+		}
+		if err == nil {
+			break
+		}
+	}
 	if err != nil {
 		return nil, ep.Errorf("DialContext failed: %s", err)
 	}
@@ -78,7 +137,7 @@ func (ep *TCPSkeletonEndpoint) DialAndServe(
 ) (int64, int64, error) {
 	calledServiceConn, err := ep.Dial(ctx, extraData)
 	if err != nil {
-		callerConn.Close()
+		closeOnDialFailure(callerConn, err)
 		return 0, 0, err
 	}
 	return BasicBridgeChannels(ctx, ep.Logger, callerConn, calledServiceConn)
@@ -2,8 +2,10 @@ package chshare
 
 import (
 	"context"
+	"crypto/tls"
 	"fmt"
 	"net"
+	"time"
 )
 
 // TCPStubEndpoint implements a local TCP stub
@@ -12,14 +14,29 @@ type TCPStubEndpoint struct {
 	BasicEndpoint
 	listenErr error
 	listener  net.Listener
+	sockOpts  *SocketOptions
+
+	// tlsConfig, if not nil, makes accepted connections TLS connections that must present a
+	// client certificate
+	tlsConfig *tls.Config
 }
 
 // NewTCPStubEndpoint creates a new TCPStubEndpoint
 func NewTCPStubEndpoint(logger Logger, ced *ChannelEndpointDescriptor) (*TCPStubEndpoint, error) {
+	sockOpts, err := EndpointSocketOptions(ced)
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig, err := EndpointStubTLSConfig(ced)
+	if err != nil {
+		return nil, err
+	}
 	ep := &TCPStubEndpoint{
 		BasicEndpoint: BasicEndpoint{
 			ced: ced,
 		},
+		sockOpts:  sockOpts,
+		tlsConfig: tlsConfig,
 	}
 	ep.InitBasicEndpoint(logger, ep, "TCPStubEndpoint: %s", ced)
 	return ep, nil
@@ -45,6 +62,19 @@ func (ep *TCPStubEndpoint) HandleOnceShutdown(completionErr error) error {
 	return completionErr
 }
 
+// defaultListenNetwork returns the network a TCP stub listens on without a family option:
+// IPv6 for an IPv6 bind address, and otherwise IPv4, so that e.g. 0.0.0.0 does not also
+// listen on IPv6
+func defaultListenNetwork(path string) string {
+	host, _, err := net.SplitHostPort(path)
+	if err == nil {
+		if ip := net.ParseIP(host); ip != nil && ip.To4() == nil {
+			return "tcp6"
+		}
+	}
+	return "tcp4"
+}
+
 func (ep *TCPStubEndpoint) getListener() (net.Listener, error) {
 	var listener net.Listener
 	var err error
@@ -54,8 +84,7 @@ func (ep *TCPStubEndpoint) getListener() (net.Listener, error) {
 		if ep.IsStartedShutdown() {
 			err = fmt.Errorf("%s: Endpoint is closed", ep.Logger.Prefix())
 		} else if ep.listener == nil && ep.listenErr == nil {
-			// TODO: support IPV6
-			listener, err = net.Listen("tcp4", ep.ced.Path)
+			listener, err = ep.sockOpts.ListenConfig().Listen(context.Background(), ep.sockOpts.Network(defaultListenNetwork(ep.ced.Path)), ep.ced.Path)
 			if err != nil {
 				err = fmt.Errorf("%s: TCP listen failed for path '%s': %s", ep.Logger.Prefix(), ep.ced.Path, err)
 			} else {
@@ -81,6 +110,59 @@ func (ep *TCPStubEndpoint) StartListening() error {
 	return err
 }
 
+// BoundAddr returns the address the endpoint is listening on, with any bind hostname
+// resolved, or "" if it is not listening. Part of the BoundAddrEndpoint interface.
+func (ep *TCPStubEndpoint) BoundAddr() string {
+	ep.Lock.Lock()
+	defer ep.Lock.Unlock()
+	if ep.listener == nil {
+		return ""
+	}
+	return ep.listener.Addr().String()
+}
+
+// deadlineListener is a net.Listener whose Accept calls can be interrupted with a deadline
+type deadlineListener interface {
+	SetDeadline(t time.Time) error
+}
+
+// DetachListener stops the endpoint accepting connections, and returns its listener, still
+// open. An Accept call in progress on the listener returns an error. Part of the
+// ListenerHandoffEndpoint interface.
+func (ep *TCPStubEndpoint) DetachListener() (net.Listener, error) {
+	ep.Lock.Lock()
+	listener := ep.listener
+	if listener == nil {
+		ep.Lock.Unlock()
+		return nil, fmt.Errorf("%s: Endpoint is not listening", ep.Logger.Prefix())
+	}
+	ep.listener = nil
+	ep.listenErr = fmt.Errorf("%s: Listener handed off", ep.Logger.Prefix())
+	ep.Lock.Unlock()
+	if dl, ok := listener.(deadlineListener); ok {
+		dl.SetDeadline(time.Now())
+	}
+	return listener, nil
+}
+
+// AdoptListener makes the endpoint accept connections on a listener detached from another
+// endpoint, rather than listening itself. Part of the ListenerHandoffEndpoint interface.
+func (ep *TCPStubEndpoint) AdoptListener(listener net.Listener) error {
+	ep.Lock.Lock()
+	defer ep.Lock.Unlock()
+	if ep.IsStartedShutdown() {
+		return fmt.Errorf("%s: Endpoint is closed", ep.Logger.Prefix())
+	}
+	if ep.listener != nil || ep.listenErr != nil {
+		return fmt.Errorf("%s: Endpoint is already listening", ep.Logger.Prefix())
+	}
+	if dl, ok := listener.(deadlineListener); ok {
+		dl.SetDeadline(time.Time{})
+	}
+	ep.listener = listener
+	return nil
+}
+
 // Accept listens for and accepts a single connection from a Caller network client as specified in the
 // endpoint configuration. This call does not return until a new connection is available or a
 // error occurs. There is no way to cancel an Accept() request other than closing the endpoint. Part of
@@ -93,7 +175,12 @@ func (ep *TCPStubEndpoint) Accept(ctx context.Context) (ChannelConn, error) {
 
 	netConn, err := listener.Accept()
 	if err != nil {
-		return nil, fmt.Errorf("%s: Accept failed: %s", ep.Logger.Prefix(), err)
+		return nil, fmt.Errorf("%s: Accept failed: %w", ep.Logger.Prefix(), err)
+	}
+	if ep.tlsConfig != nil {
+		// The handshake is left to the connection's user (see HandshakeConn), so that a slow
+		// Caller does not hold up accepting others
+		netConn = tls.Server(netConn, ep.tlsConfig)
 	}
 
 	conn, err := NewSocketConn(ep.Logger, netConn)
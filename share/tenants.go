@@ -0,0 +1,44 @@
+package chshare
+
+import (
+	"crypto/tls"
+	"strings"
+)
+
+// loadTenants loads the users of each tenant, given the auth file of each by the TLS server
+// name that its clients ask for with SNI
+func (s *Server) loadTenants(tenants map[string]string) error {
+	s.tenants = make(map[string]*UserIndex)
+	for serverName, authFile := range tenants {
+		users := NewUserIndex(s.Logger.Fork("tenant %s", serverName))
+		users.OnChange(func() { s.usersChanged(users) })
+		if err := users.LoadUsers(authFile); err != nil {
+			return s.Errorf("Unable to load users of tenant %s: %s", serverName, err)
+		}
+		s.tenants[strings.ToLower(serverName)] = users
+	}
+	return nil
+}
+
+// tenantFor returns the tenant that a client connection is for, named by the TLS server name
+// the client asked for, and the users it authenticates against. Connections without a
+// tenant's server name are for the server's own users, with tenant "".
+func (s *Server) tenantFor(state *tls.ConnectionState) (string, *UserIndex) {
+	if state != nil {
+		serverName := strings.ToLower(state.ServerName)
+		if users, ok := s.tenants[serverName]; ok {
+			return serverName, users
+		}
+	}
+	return "", s.users
+}
+
+// tenantUserName returns the name that identifies a user of a tenant across the server, e.g.
+// in reconnection tokens and authentication lockouts, so that users of different tenants
+// with the same name are kept apart
+func tenantUserName(tenant string, name string) string {
+	if tenant == "" {
+		return name
+	}
+	return tenant + "\x00" + name
+}
@@ -0,0 +1,301 @@
+package chshare
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ocsp"
+)
+
+// ocspMinRefresh and ocspMaxRefresh bound the interval between fetches of a fresh OCSP
+// response, which is otherwise half of the remaining validity of the current response
+const (
+	ocspMinRefresh = time.Minute
+	ocspMaxRefresh = 12 * time.Hour
+)
+
+// ocspRetryDelay is the delay before retrying a failed OCSP fetch
+const ocspRetryDelay = 5 * time.Minute
+
+// tlsVersions maps the accepted --tls-min-version values to TLS versions
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// ParseTLSVersion converts a TLS version number such as "1.2" to its crypto/tls constant
+func ParseTLSVersion(s string) (uint16, error) {
+	v, ok := tlsVersions[s]
+	if !ok {
+		return 0, fmt.Errorf("Unknown TLS version '%s'; must be 1.0, 1.1, 1.2 or 1.3", s)
+	}
+	return v, nil
+}
+
+// ParseTLSCipherSuites converts a comma-separated list of cipher suite names, as defined
+// by IANA (e.g. TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256), to crypto/tls cipher suite IDs.
+// TLS 1.3 cipher suites are always enabled and cannot be listed.
+func ParseTLSCipherSuites(s string) ([]uint16, error) {
+	byName := make(map[string]*tls.CipherSuite)
+	for _, suite := range append(tls.CipherSuites(), tls.InsecureCipherSuites()...) {
+		byName[suite.Name] = suite
+	}
+	var ids []uint16
+	for _, name := range strings.Split(s, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		suite, ok := byName[name]
+		if !ok {
+			return nil, fmt.Errorf("Unknown TLS cipher suite '%s'", name)
+		}
+		if len(suite.SupportedVersions) == 1 && suite.SupportedVersions[0] == tls.VersionTLS13 {
+			return nil, fmt.Errorf("TLS 1.3 cipher suite '%s' cannot be configured; TLS 1.3 suites are always enabled", name)
+		}
+		ids = append(ids, suite.ID)
+	}
+	if len(ids) == 0 {
+		return nil, fmt.Errorf("Empty TLS cipher suite list")
+	}
+	return ids, nil
+}
+
+// TLSServerConfig holds the settings of the server's native TLS listener
+type TLSServerConfig struct {
+	// CertFile is a PEM file holding the server's certificate, followed by any intermediate
+	// certificates. The first certificate after the server's must be its issuer if OCSP
+	// stapling is enabled.
+	CertFile string
+
+	// KeyFile is a PEM file holding the certificate's private key
+	KeyFile string
+
+	// MinVersion is the lowest TLS version accepted, e.g. "1.3"; defaults to "1.2"
+	MinVersion string
+
+	// CipherSuites is a comma-separated list of the TLS 1.0-1.2 cipher suites accepted, or
+	// "" for Go's defaults
+	CipherSuites string
+
+	// OCSPStapling enables stapling of OCSP responses fetched from the certificate's issuer
+	OCSPStapling bool
+
+	// ClientCAFile, if not "", is a PEM file holding the CA certificates that issue client
+	// certificates. Clients may then present a certificate, which is verified against them.
+	ClientCAFile string
+}
+
+// NewTLSConfig creates the crypto/tls configuration for the server's TLS listener. If OCSP
+// stapling is enabled, a response is fetched before NewTLSConfig returns, and refreshed in
+// the background, as scheduled by clock, until done is closed.
+func NewTLSConfig(logger Logger, clock Clock, c *TLSServerConfig, done <-chan struct{}) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to load TLS certificate: %s", err)
+	}
+	config := &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		Certificates: []tls.Certificate{cert},
+		// WebSocket upgrades need HTTP/1.1; --http2 offers h2 as well
+		NextProtos: []string{"http/1.1"},
+	}
+	if c.MinVersion != "" {
+		if config.MinVersion, err = ParseTLSVersion(c.MinVersion); err != nil {
+			return nil, err
+		}
+	}
+	if c.CipherSuites != "" {
+		if config.MinVersion == tls.VersionTLS13 {
+			return nil, fmt.Errorf("TLS cipher suites cannot be configured with a minimum TLS version of 1.3")
+		}
+		if config.CipherSuites, err = ParseTLSCipherSuites(c.CipherSuites); err != nil {
+			return nil, err
+		}
+	}
+	if c.ClientCAFile != "" {
+		pem, err := ioutil.ReadFile(c.ClientCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to read TLS client CA file: %s", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("No certificates found in TLS client CA file %s", c.ClientCAFile)
+		}
+		config.ClientCAs = pool
+		config.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+	if c.OCSPStapling {
+		stapler, err := newOCSPStapler(logger, clock, &cert)
+		if err != nil {
+			return nil, err
+		}
+		err = stapler.refresh()
+		if err != nil {
+			logger.ILogf("Unable to fetch initial OCSP response; will retry: %s", err)
+		}
+		go stapler.refreshLoop(done, err)
+		config.Certificates = nil
+		config.GetCertificate = stapler.getCertificate
+	}
+	return config, nil
+}
+
+// ocspStapler keeps a fresh OCSP response for a certificate, and staples it to handshakes
+type ocspStapler struct {
+	logger    Logger
+	clock     Clock
+	leaf      *x509.Certificate
+	issuer    *x509.Certificate
+	responder string
+
+	lock sync.Mutex
+
+	// cert is the certificate to present, with the current OCSP response stapled
+	cert *tls.Certificate
+
+	// nextUpdate is when the current OCSP response expires, or zero if there is none
+	nextUpdate time.Time
+}
+
+// newOCSPStapler creates an ocspStapler for a certificate whose chain includes its issuer
+func newOCSPStapler(logger Logger, clock Clock, cert *tls.Certificate) (*ocspStapler, error) {
+	if len(cert.Certificate) < 2 {
+		return nil, fmt.Errorf("OCSP stapling requires the TLS certificate file to include the issuer's certificate")
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return nil, fmt.Errorf("Unable to parse TLS certificate: %s", err)
+	}
+	issuer, err := x509.ParseCertificate(cert.Certificate[1])
+	if err != nil {
+		return nil, fmt.Errorf("Unable to parse TLS issuer certificate: %s", err)
+	}
+	if len(leaf.OCSPServer) == 0 {
+		return nil, fmt.Errorf("OCSP stapling requires a TLS certificate that names an OCSP responder")
+	}
+	return &ocspStapler{
+		logger:    logger.Fork("ocsp"),
+		clock:     clock,
+		leaf:      leaf,
+		issuer:    issuer,
+		responder: leaf.OCSPServer[0],
+		cert:      cert,
+	}, nil
+}
+
+// getCertificate is the tls.Config GetCertificate callback
+func (o *ocspStapler) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	return o.cert, nil
+}
+
+// refresh fetches a fresh OCSP response and staples it to the certificate
+func (o *ocspStapler) refresh() error {
+	req, err := ocsp.CreateRequest(o.leaf, o.issuer, nil)
+	if err != nil {
+		return err
+	}
+	client := &http.Client{Timeout: 30 * time.Second}
+	httpResp, err := client.Post(o.responder, "application/ocsp-request", bytes.NewReader(req))
+	if err != nil {
+		return err
+	}
+	defer httpResp.Body.Close()
+	if httpResp.StatusCode != http.StatusOK {
+		return fmt.Errorf("OCSP responder %s returned %s", o.responder, httpResp.Status)
+	}
+	raw, err := ioutil.ReadAll(httpResp.Body)
+	if err != nil {
+		return err
+	}
+	resp, err := ocsp.ParseResponseForCert(raw, o.leaf, o.issuer)
+	if err != nil {
+		return fmt.Errorf("Invalid OCSP response: %s", err)
+	}
+	if resp.Status != ocsp.Good {
+		return fmt.Errorf("OCSP responder reports certificate status %d (not good); not stapling", resp.Status)
+	}
+	o.lock.Lock()
+	stapled := *o.cert
+	stapled.OCSPStaple = raw
+	o.cert = &stapled
+	o.nextUpdate = resp.NextUpdate
+	o.lock.Unlock()
+	o.logger.DLogf("Stapling OCSP response valid until %s", resp.NextUpdate)
+	return nil
+}
+
+// refreshLoop refreshes the OCSP response halfway through its validity, or after
+// ocspRetryDelay if the last refresh failed with lastErr, until done is closed. An expired
+// response is no longer stapled.
+func (o *ocspStapler) refreshLoop(done <-chan struct{}, lastErr error) {
+	for {
+		delay := ocspRetryDelay
+		if lastErr == nil {
+			delay = ocspMaxRefresh
+			o.lock.Lock()
+			nextUpdate := o.nextUpdate
+			o.lock.Unlock()
+			if !nextUpdate.IsZero() {
+				delay = nextUpdate.Sub(o.clock.Now()) / 2
+			}
+			if delay < ocspMinRefresh {
+				delay = ocspMinRefresh
+			}
+			if delay > ocspMaxRefresh {
+				delay = ocspMaxRefresh
+			}
+		}
+		select {
+		case <-done:
+			return
+		case <-o.clock.After(delay):
+		}
+		lastErr = o.refresh()
+		if lastErr != nil {
+			o.logger.ILogf("Unable to refresh OCSP response: %s", lastErr)
+			o.lock.Lock()
+			if !o.nextUpdate.IsZero() && o.clock.Now().After(o.nextUpdate) {
+				unstapled := *o.cert
+				unstapled.OCSPStaple = nil
+				o.cert = &unstapled
+				o.nextUpdate = time.Time{}
+			}
+			o.lock.Unlock()
+		}
+	}
+}
+
+// VerifiedClientCertName returns the common name of the verified client certificate of a
+// TLS connection, or "" if the client did not present one
+func VerifiedClientCertName(state *tls.ConnectionState) string {
+	if state == nil || len(state.VerifiedChains) == 0 || len(state.VerifiedChains[0]) == 0 {
+		return ""
+	}
+	return state.VerifiedChains[0][0].Subject.CommonName
+}
+
+// LoadClientCertificate loads a client certificate and its private key from PEM files, and
+// returns it along with its common name
+func LoadClientCertificate(certFile, keyFile string) (*tls.Certificate, string, error) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, "", fmt.Errorf("Unable to load TLS client certificate: %s", err)
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return nil, "", fmt.Errorf("Unable to parse TLS client certificate: %s", err)
+	}
+	return &cert, leaf.Subject.CommonName, nil
+}
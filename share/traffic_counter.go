@@ -0,0 +1,134 @@
+package chshare
+
+import "sync"
+
+// TrafficTotals are the traffic statistics of a channel descriptor
+type TrafficTotals struct {
+	// Active is the number of open connections
+	Active int
+
+	// Conns is the total number of connections opened
+	Conns int64
+
+	// BytesToCalled and BytesToCaller are the bytes sent to and from the Called Service,
+	// including the traffic of open connections so far
+	BytesToCalled int64
+	BytesToCaller int64
+}
+
+// descriptorTraffic holds the traffic statistics of one channel descriptor
+type descriptorTraffic struct {
+	// conns is the total number of connections opened for the descriptor
+	conns int64
+
+	// toCalled and toCaller are the bytes sent to and from the Called Service by connections
+	// that have ended
+	toCalled int64
+	toCaller int64
+
+	// active holds the connections to the remote proxy of the descriptor's open connections.
+	// The value is true if the connection carries the caller's data, i.e. the local endpoint
+	// is the skeleton.
+	active map[ChannelConn]bool
+
+	// reported is the totals as of the last call to trafficCounter.deltas
+	reported TrafficTotals
+}
+
+// trafficCounter counts the connections and bytes of channels, by channel descriptor string
+type trafficCounter struct {
+	// lock protects descriptors
+	lock        sync.Mutex
+	descriptors map[string]*descriptorTraffic
+}
+
+func newTrafficCounter() *trafficCounter {
+	return &trafficCounter{descriptors: make(map[string]*descriptorTraffic)}
+}
+
+// connBytes returns the bytes sent to and from the Called Service so far over conn, a
+// connection to the remote proxy
+func connBytes(conn ChannelConn, fromCaller bool) (toCalled int64, toCaller int64) {
+	if fromCaller {
+		return conn.GetNumBytesRead(), conn.GetNumBytesWritten()
+	}
+	return conn.GetNumBytesWritten(), conn.GetNumBytesRead()
+}
+
+// track counts an open channel's connection to the remote proxy towards the statistics of a
+// descriptor, and returns a function to be called when the channel ends
+func (t *trafficCounter) track(key string, fromCaller bool, conn ChannelConn) func() {
+	t.lock.Lock()
+	d, ok := t.descriptors[key]
+	if !ok {
+		d = &descriptorTraffic{active: make(map[ChannelConn]bool)}
+		t.descriptors[key] = d
+	}
+	d.conns++
+	d.active[conn] = fromCaller
+	t.lock.Unlock()
+	return func() {
+		t.lock.Lock()
+		defer t.lock.Unlock()
+		toCalled, toCaller := connBytes(conn, fromCaller)
+		d.toCalled += toCalled
+		d.toCaller += toCaller
+		delete(d.active, conn)
+	}
+}
+
+// forget drops the statistics of a descriptor
+func (t *trafficCounter) forget(key string) {
+	t.lock.Lock()
+	delete(t.descriptors, key)
+	t.lock.Unlock()
+}
+
+// totalsLocked returns the current totals of a descriptor. t.lock must be held.
+func (d *descriptorTraffic) totalsLocked() TrafficTotals {
+	result := TrafficTotals{
+		Active:        len(d.active),
+		Conns:         d.conns,
+		BytesToCalled: d.toCalled,
+		BytesToCaller: d.toCaller,
+	}
+	for conn, fromCaller := range d.active {
+		called, caller := connBytes(conn, fromCaller)
+		result.BytesToCalled += called
+		result.BytesToCaller += caller
+	}
+	return result
+}
+
+// totals returns the current totals of a descriptor, which are zero if it has had no
+// connections
+func (t *trafficCounter) totals(key string) TrafficTotals {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if d, ok := t.descriptors[key]; ok {
+		return d.totalsLocked()
+	}
+	return TrafficTotals{}
+}
+
+// deltas returns the connections and bytes of each descriptor since the previous call, for
+// the descriptors that have had any. Active is the current number of open connections.
+func (t *trafficCounter) deltas() map[string]TrafficTotals {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	result := make(map[string]TrafficTotals)
+	for key, d := range t.descriptors {
+		now := d.totalsLocked()
+		delta := TrafficTotals{
+			Active:        now.Active,
+			Conns:         now.Conns - d.reported.Conns,
+			BytesToCalled: now.BytesToCalled - d.reported.BytesToCalled,
+			BytesToCaller: now.BytesToCaller - d.reported.BytesToCaller,
+		}
+		d.reported = now
+		if delta.Conns != 0 || delta.BytesToCalled != 0 || delta.BytesToCaller != 0 {
+			result[key] = delta
+		}
+	}
+	return result
+}
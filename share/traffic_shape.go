@@ -0,0 +1,391 @@
+package chshare
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// shapeSegmentSize is the size of the packets whose loss the "loss" option simulates
+const shapeSegmentSize = 1400
+
+// shapeMinRetransmitTimeout is the shortest time for which a simulated lost packet stalls its
+// direction of a shaped channel
+const shapeMinRetransmitTimeout = 200 * time.Millisecond
+
+// shapeQueueBytes is the number of bytes that may be in flight in each direction of a shaped
+// channel before its writer waits
+const shapeQueueBytes = 256 * 1024
+
+// shapeCloseGrace is how long after the data written to a shaped channel is due to arrive that
+// closing the channel waits for it to be delivered
+const shapeCloseGrace = time.Second
+
+// TrafficShape is the simulated network conditions of an endpoint's channels, set with the
+// "delay", "jitter", "rate" and "loss" descriptor options, so that applications can be tested
+// against a slow or lossy link using only chisel
+type TrafficShape struct {
+	// Delay is the latency added to data in each direction, and Jitter the most by which
+	// it varies either way
+	Delay  time.Duration
+	Jitter time.Duration
+
+	// Rate is the bandwidth of each direction, in bytes per second, or 0 for no limit
+	Rate int64
+
+	// Loss is the fraction of packets lost. Since a channel is a reliable stream, a lost
+	// packet delays its direction by a retransmission timeout, as it would a TCP connection.
+	Loss float64
+}
+
+// validateDelayOption validates the value of the "delay" and "jitter" descriptor options
+func validateDelayOption(value string) error {
+	_, err := parseDelayOption(value)
+	return err
+}
+
+// parseDelayOption parses the value of the "delay" or "jitter" descriptor option
+func parseDelayOption(value string) (time.Duration, error) {
+	d, err := time.ParseDuration(value)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("Invalid delay '%s'; must be a duration, e.g. '50ms'", value)
+	}
+	return d, nil
+}
+
+// validateRateOption validates the value of the "rate" descriptor option
+func validateRateOption(value string) error {
+	_, err := parseRateOption(value)
+	return err
+}
+
+// parseRateOption parses the value of the "rate" descriptor option, in bytes per second
+func parseRateOption(value string) (int64, error) {
+	n, err := ParseByteCount(value)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("Invalid rate '%s'; must be a positive number of bytes per second, e.g. '500K'", value)
+	}
+	return n, nil
+}
+
+// validateLossOption validates the value of the "loss" descriptor option
+func validateLossOption(value string) error {
+	_, err := parseLossOption(value)
+	return err
+}
+
+// parseLossOption parses the value of the "loss" descriptor option, a percentage with an
+// optional "%" suffix, and returns it as a fraction
+func parseLossOption(value string) (float64, error) {
+	p, err := strconv.ParseFloat(strings.TrimSuffix(value, "%"), 64)
+	if err != nil || p < 0 || p >= 100 {
+		return 0, fmt.Errorf("Invalid loss '%s'; must be a percentage below 100, e.g. '1%%'", value)
+	}
+	return p / 100, nil
+}
+
+// EndpointTrafficShape returns the simulated network conditions of an endpoint's channels, or
+// nil if it has none
+func EndpointTrafficShape(ced *ChannelEndpointDescriptor) *TrafficShape {
+	shape := &TrafficShape{}
+	shape.Delay, _ = parseDelayOption(ced.Option("delay"))
+	shape.Jitter, _ = parseDelayOption(ced.Option("jitter"))
+	shape.Rate, _ = parseRateOption(ced.Option("rate"))
+	shape.Loss, _ = parseLossOption(ced.Option("loss"))
+	if *shape == (TrafficShape{}) {
+		return nil
+	}
+	return shape
+}
+
+// ShapeChannelConn returns a ChannelConn that subjects the data read from and written to conn,
+// a connection to the remote proxy, to the simulated network conditions of the endpoint ced,
+// as measured by clock. If the endpoint has none, conn is returned unchanged.
+func ShapeChannelConn(clock Clock, ced *ChannelEndpointDescriptor, conn ChannelConn) ChannelConn {
+	shape := EndpointTrafficShape(ced)
+	if shape == nil {
+		return conn
+	}
+	c := &shapedConn{
+		ChannelConn: conn,
+		in:          newShapeLine(shape, clock),
+		out:         newShapeLine(shape, clock),
+		writeDone:   make(chan struct{}),
+	}
+	go c.readLoop()
+	go c.writeLoop()
+	return c
+}
+
+// shapedChunk is data in one direction of a shaped channel, and the time at which it arrives
+type shapedChunk struct {
+	data []byte
+
+	// err, if not nil, ends the direction after data
+	err error
+
+	at time.Time
+}
+
+// shapeLine delays the data in one direction of a shaped channel, in order
+type shapeLine struct {
+	shape *TrafficShape
+	clock Clock
+
+	// lock protects the remaining fields
+	lock   sync.Mutex
+	rand   *rand.Rand
+	chunks []shapedChunk
+	queued int
+	closed bool
+
+	// sent is when the last chunk finishes being sent at the rate, and lastAt when it
+	// arrives
+	sent   time.Time
+	lastAt time.Time
+
+	// changed is closed, and replaced, whenever a chunk is added or removed, or the line is
+	// closed
+	changed chan struct{}
+}
+
+func newShapeLine(shape *TrafficShape, clock Clock) *shapeLine {
+	return &shapeLine{
+		shape:   shape,
+		clock:   clock,
+		rand:    rand.New(rand.NewSource(clock.Now().UnixNano())),
+		changed: make(chan struct{}),
+	}
+}
+
+// notifyLocked wakes the goroutines waiting for the line to change. The lock must be held.
+func (l *shapeLine) notifyLocked() {
+	close(l.changed)
+	l.changed = make(chan struct{})
+}
+
+// arrivalLocked returns when a chunk of n bytes sent now arrives. The lock must be held.
+func (l *shapeLine) arrivalLocked(n int) time.Time {
+	now := l.clock.Now()
+	if l.sent.Before(now) {
+		l.sent = now
+	}
+	if l.shape.Rate > 0 {
+		l.sent = l.sent.Add(time.Duration(int64(n) * int64(time.Second) / l.shape.Rate))
+	}
+	delay := l.shape.Delay
+	if l.shape.Jitter > 0 {
+		delay += time.Duration(l.rand.Int63n(int64(2*l.shape.Jitter)+1)) - l.shape.Jitter
+	}
+	if l.shape.Loss > 0 && n > 0 {
+		segments := (n + shapeSegmentSize - 1) / shapeSegmentSize
+		if l.rand.Float64() < 1-math.Pow(1-l.shape.Loss, float64(segments)) {
+			delay += shapeMinRetransmitTimeout + 2*l.shape.Delay
+		}
+	}
+	at := l.sent.Add(delay)
+	if at.Before(l.lastAt) {
+		at = l.lastAt
+	}
+	l.lastAt = at
+	return at
+}
+
+// push adds data, and err if not nil, to the line, waiting while too many bytes are in
+// flight. Returns false if the line is closed.
+func (l *shapeLine) push(data []byte, err error) bool {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	for l.queued >= shapeQueueBytes && !l.closed {
+		changed := l.changed
+		l.lock.Unlock()
+		<-changed
+		l.lock.Lock()
+	}
+	if l.closed {
+		return false
+	}
+	l.chunks = append(l.chunks, shapedChunk{data: data, err: err, at: l.arrivalLocked(len(data))})
+	l.queued += len(data)
+	l.notifyLocked()
+	return true
+}
+
+// pop removes and returns the first chunk once it arrives, or returns false if the line is
+// closed
+func (l *shapeLine) pop() (shapedChunk, bool) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	for {
+		if l.closed {
+			return shapedChunk{}, false
+		}
+		changed := l.changed
+		var wait <-chan time.Time
+		if len(l.chunks) > 0 {
+			chunk := l.chunks[0]
+			d := chunk.at.Sub(l.clock.Now())
+			if d <= 0 {
+				l.chunks = l.chunks[1:]
+				l.queued -= len(chunk.data)
+				l.notifyLocked()
+				return chunk, true
+			}
+			wait = l.clock.After(d)
+		}
+		l.lock.Unlock()
+		select {
+		case <-wait:
+		case <-changed:
+		}
+		l.lock.Lock()
+	}
+}
+
+// untilLast returns how long it will be until the last chunk added arrives
+func (l *shapeLine) untilLast() time.Duration {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return l.lastAt.Sub(l.clock.Now())
+}
+
+// close discards the data in the line, and wakes its waiters
+func (l *shapeLine) close() {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if !l.closed {
+		l.closed = true
+		l.chunks = nil
+		l.notifyLocked()
+	}
+}
+
+// shapedConn is a ChannelConn whose data passes through a shapeLine in each direction
+type shapedConn struct {
+	ChannelConn
+	in  *shapeLine
+	out *shapeLine
+
+	// pending is the rest of the chunk being read, and readErr the error that follows it.
+	// They are only accessed by Read.
+	pending []byte
+	readErr error
+
+	// writeErrLock protects writeErr, the error with which writing to the remote proxy
+	// failed, and writeClosed, which is true once CloseWrite has been called
+	writeErrLock sync.Mutex
+	writeErr     error
+	writeClosed  bool
+
+	// writeDone is closed when writeLoop has finished
+	writeDone chan struct{}
+}
+
+// readLoop moves the data read from the remote proxy into the incoming line
+func (c *shapedConn) readLoop() {
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := c.ChannelConn.Read(buf)
+		if n > 0 && !c.in.push(append([]byte(nil), buf[:n]...), nil) {
+			return
+		}
+		if err != nil {
+			c.in.push(nil, err)
+			return
+		}
+	}
+}
+
+// writeLoop writes the data that arrives from the outgoing line to the remote proxy
+func (c *shapedConn) writeLoop() {
+	defer close(c.writeDone)
+	for {
+		chunk, ok := c.out.pop()
+		if !ok {
+			return
+		}
+		if chunk.err != nil {
+			c.ChannelConn.CloseWrite()
+			return
+		}
+		if _, err := c.ChannelConn.Write(chunk.data); err != nil {
+			c.writeErrLock.Lock()
+			c.writeErr = err
+			c.writeErrLock.Unlock()
+			c.out.close()
+			return
+		}
+	}
+}
+
+func (c *shapedConn) Read(p []byte) (int, error) {
+	for len(c.pending) == 0 {
+		if c.readErr != nil {
+			return 0, c.readErr
+		}
+		chunk, ok := c.in.pop()
+		if !ok {
+			return 0, io.EOF
+		}
+		c.pending, c.readErr = chunk.data, chunk.err
+	}
+	n := copy(p, c.pending)
+	c.pending = c.pending[n:]
+	return n, nil
+}
+
+func (c *shapedConn) Write(p []byte) (int, error) {
+	c.writeErrLock.Lock()
+	err := c.writeErr
+	if err == nil && c.writeClosed {
+		err = fmt.Errorf("Write after CloseWrite")
+	}
+	c.writeErrLock.Unlock()
+	if err != nil {
+		return 0, err
+	}
+	if !c.out.push(append([]byte(nil), p...), nil) {
+		c.writeErrLock.Lock()
+		err = c.writeErr
+		c.writeErrLock.Unlock()
+		if err == nil {
+			err = io.ErrClosedPipe
+		}
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// CloseWrite closes the remote proxy's write side once the data written so far has arrived
+func (c *shapedConn) CloseWrite() error {
+	c.writeErrLock.Lock()
+	closed := c.writeClosed
+	c.writeClosed = true
+	c.writeErrLock.Unlock()
+	if !closed {
+		c.out.push(nil, io.EOF)
+	}
+	return nil
+}
+
+// Close closes the connection to the remote proxy. If its write side was closed, the data
+// written before that is delivered first, unless the remote proxy does not take it in time.
+func (c *shapedConn) Close() error {
+	c.writeErrLock.Lock()
+	drain := c.writeClosed
+	c.writeErrLock.Unlock()
+	if drain {
+		select {
+		case <-c.writeDone:
+		case <-c.out.clock.After(c.out.untilLast() + shapeCloseGrace):
+		}
+	}
+	c.out.close()
+	c.in.close()
+	return c.ChannelConn.Close()
+}
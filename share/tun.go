@@ -0,0 +1,175 @@
+package chshare
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"regexp"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// TUN endpoints relay IP packets between TUN devices at each end of a channel, as a
+// lightweight VPN. Each packet is carried in a frame, as UDP endpoints carry datagrams (see
+// udpFrameHeaderSize). The devices are configured with the channel's options:
+//
+//     mtu=<bytes>              The MTU of both devices
+//     tun-addr=<ipv4/prefix>   The address and network of the stub's device
+//     tun-peer-addr=<...>      The address and network of the skeleton's device
+//
+// Devices without an address are brought up unconfigured, for routing to be set up by other
+// means.
+
+// tunDeviceNameRegexp matches a valid TUN device name
+var tunDeviceNameRegexp = regexp.MustCompile(`^[a-zA-Z0-9_.-]{1,15}$`)
+
+// tunMinMTU and tunMaxMTU bound the mtu option; the maximum is the largest packet a frame can hold
+const (
+	tunMinMTU = 68
+	tunMaxMTU = udpMaxDatagram
+)
+
+// ValidateTUNDeviceName returns an error if name is not a valid TUN device name
+func ValidateTUNDeviceName(name string) error {
+	if !tunDeviceNameRegexp.MatchString(name) || name == "." || name == ".." {
+		return fmt.Errorf("Invalid TUN device name '%s'; must be 1 to 15 letters, digits, '_', '.' or '-'", name)
+	}
+	return nil
+}
+
+// validateMTUOption validates the value of the "mtu" descriptor option
+func validateMTUOption(value string) error {
+	mtu, err := strconv.Atoi(value)
+	if err != nil || mtu < tunMinMTU || mtu > tunMaxMTU {
+		return fmt.Errorf("Invalid MTU '%s'; must be between %d and %d", value, tunMinMTU, tunMaxMTU)
+	}
+	return nil
+}
+
+// validateTUNAddrOption validates the value of the "tun-addr" and "tun-peer-addr" descriptor
+// options
+func validateTUNAddrOption(value string) error {
+	_, err := parseTUNAddrOption(value)
+	return err
+}
+
+// parseTUNAddrOption parses an IPv4 address and prefix length, e.g. 10.9.0.1/24
+func parseTUNAddrOption(value string) (*net.IPNet, error) {
+	ip, ipNet, err := net.ParseCIDR(value)
+	if err != nil || ip.To4() == nil {
+		return nil, fmt.Errorf("Invalid TUN address '%s'; expected an IPv4 address and prefix length, e.g. 10.9.0.1/24", value)
+	}
+	return &net.IPNet{IP: ip.To4(), Mask: ipNet.Mask}, nil
+}
+
+// checkTUNOptions returns an error if a channel has TUN options for an end that is not a TUN
+// endpoint
+func checkTUNOptions(d *ChannelDescriptor) error {
+	if d.Stub.Option("tun-addr") != "" && d.Stub.Type != ChannelEndpointTypeTUN {
+		return fmt.Errorf("%s: The tun-addr option requires a TUN stub endpoint", d.String())
+	}
+	if d.Skeleton.Option("tun-peer-addr") != "" && d.Skeleton.Type != ChannelEndpointTypeTUN {
+		return fmt.Errorf("%s: The tun-peer-addr option requires a TUN skeleton endpoint", d.String())
+	}
+	if d.Stub.Option("mtu") != "" && d.Stub.Type != ChannelEndpointTypeTUN && d.Skeleton.Type != ChannelEndpointTypeTUN {
+		return fmt.Errorf("%s: The mtu option requires a TUN endpoint", d.String())
+	}
+	return nil
+}
+
+// tunDeviceConfig is the configuration of a TUN endpoint's device
+type tunDeviceConfig struct {
+	// mtu is the device's MTU, or 0 to leave the system default
+	mtu int
+
+	// addr is the device's address and network, or nil to leave it unconfigured
+	addr *net.IPNet
+}
+
+// endpointTUNDeviceConfig returns the device configuration of a TUN endpoint from its options
+func endpointTUNDeviceConfig(ced *ChannelEndpointDescriptor) (*tunDeviceConfig, error) {
+	config := &tunDeviceConfig{}
+	if value := ced.Option("mtu"); value != "" {
+		if err := validateMTUOption(value); err != nil {
+			return nil, err
+		}
+		config.mtu, _ = strconv.Atoi(value)
+	}
+	addrOption := "tun-addr"
+	if ced.Role == ChannelEndpointRoleSkeleton {
+		addrOption = "tun-peer-addr"
+	}
+	if value := ced.Option(addrOption); value != "" {
+		addr, err := parseTUNAddrOption(value)
+		if err != nil {
+			return nil, err
+		}
+		config.addr = addr
+	}
+	return config, nil
+}
+
+// tunAddr is the net.Addr of a TUN device
+type tunAddr string
+
+func (a tunAddr) Network() string { return "tun" }
+func (a tunAddr) String() string  { return string(a) }
+
+// tunConn is a datagram net.Conn over an open TUN device, each Read and Write of which carries
+// one IP packet. Closing it calls release, if not nil, instead of closing the device, so that
+// the device can outlive the channel. A Read in progress is woken by a past deadline, and
+// Close waits for it to return, so that it cannot take a packet meant for the next channel.
+type tunConn struct {
+	dev     *os.File
+	name    string
+	release func()
+
+	// readLock is held by Read, and protects closed
+	readLock  sync.Mutex
+	closed    bool
+	closeOnce sync.Once
+	closeErr  error
+}
+
+// newTUNConn creates a tunConn over an open device
+func newTUNConn(dev *os.File, name string, release func()) *tunConn {
+	return &tunConn{dev: dev, name: name, release: release}
+}
+
+func (c *tunConn) Read(p []byte) (int, error) {
+	c.readLock.Lock()
+	defer c.readLock.Unlock()
+	if c.closed {
+		return 0, io.EOF
+	}
+	return c.dev.Read(p)
+}
+
+func (c *tunConn) Write(p []byte) (int, error) {
+	return c.dev.Write(p)
+}
+
+// Close closes the device, or releases it to be used by another conn
+func (c *tunConn) Close() error {
+	c.closeOnce.Do(func() {
+		if c.release == nil {
+			c.closeErr = c.dev.Close()
+			return
+		}
+		c.dev.SetReadDeadline(time.Unix(1, 0))
+		c.readLock.Lock()
+		c.closed = true
+		c.readLock.Unlock()
+		c.dev.SetReadDeadline(time.Time{})
+		c.release()
+	})
+	return c.closeErr
+}
+
+func (c *tunConn) LocalAddr() net.Addr                { return tunAddr(c.name) }
+func (c *tunConn) RemoteAddr() net.Addr               { return tunAddr(c.name) }
+func (c *tunConn) SetDeadline(t time.Time) error      { return c.dev.SetDeadline(t) }
+func (c *tunConn) SetReadDeadline(t time.Time) error  { return c.dev.SetReadDeadline(t) }
+func (c *tunConn) SetWriteDeadline(t time.Time) error { return c.dev.SetWriteDeadline(t) }
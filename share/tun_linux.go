@@ -0,0 +1,120 @@
+//+build linux
+
+package chshare
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// Linux TUN and network interface ioctls and flags
+const (
+	tunSetIff      = 0x400454ca
+	iffTun         = 0x0001
+	iffNoPi        = 0x1000
+	siocGIfFlags   = 0x8913
+	siocSIfFlags   = 0x8914
+	siocSIfAddr    = 0x8916
+	siocSIfNetmask = 0x891c
+	siocSIfMTU     = 0x8922
+	ifReqSize      = 40
+)
+
+// nativeEndian is the byte order of the host, in which ifreq values are written
+var nativeEndian = func() binary.ByteOrder {
+	x := uint16(1)
+	if *(*byte)(unsafe.Pointer(&x)) == 1 {
+		return binary.LittleEndian
+	}
+	return binary.BigEndian
+}()
+
+// ifReq is a struct ifreq: the interface name followed by a union of request values
+type ifReq [ifReqSize]byte
+
+func newIfReq(name string) *ifReq {
+	var req ifReq
+	copy(req[:syscall.IFNAMSIZ-1], name)
+	return &req
+}
+
+// setSockaddr sets the request value to an IPv4 struct sockaddr_in
+func (req *ifReq) setSockaddr(ip []byte) {
+	nativeEndian.PutUint16(req[syscall.IFNAMSIZ:], syscall.AF_INET)
+	copy(req[syscall.IFNAMSIZ+4:], ip)
+}
+
+func ioctl(fd int, request uintptr, req *ifReq) error {
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, uintptr(fd), request, uintptr(unsafe.Pointer(req)))
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// openTUNDevice opens the TUN device with the given name, creating it if it does not exist,
+// applies config, and brings it up. The device is opened non-blocking, so that reads can be
+// interrupted with deadlines.
+func openTUNDevice(name string, config *tunDeviceConfig) (*os.File, error) {
+	fd, err := syscall.Open("/dev/net/tun", syscall.O_RDWR|syscall.O_CLOEXEC, 0)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to open /dev/net/tun: %s", err)
+	}
+	req := newIfReq(name)
+	nativeEndian.PutUint16(req[syscall.IFNAMSIZ:], iffTun|iffNoPi)
+	if err := ioctl(fd, tunSetIff, req); err != nil {
+		syscall.Close(fd)
+		return nil, fmt.Errorf("Unable to attach to TUN device %s: %s", name, err)
+	}
+	if err := configureTUNDevice(name, config); err != nil {
+		syscall.Close(fd)
+		return nil, fmt.Errorf("Unable to configure TUN device %s: %s", name, err)
+	}
+	if err := syscall.SetNonblock(fd, true); err != nil {
+		syscall.Close(fd)
+		return nil, err
+	}
+	return os.NewFile(uintptr(fd), name), nil
+}
+
+// configureTUNDevice sets a device's MTU and address, and brings it up
+func configureTUNDevice(name string, config *tunDeviceConfig) error {
+	sock, err := syscall.Socket(syscall.AF_INET, syscall.SOCK_DGRAM|syscall.SOCK_CLOEXEC, 0)
+	if err != nil {
+		return err
+	}
+	defer syscall.Close(sock)
+
+	if config.mtu != 0 {
+		req := newIfReq(name)
+		nativeEndian.PutUint32(req[syscall.IFNAMSIZ:], uint32(config.mtu))
+		if err := ioctl(sock, siocSIfMTU, req); err != nil {
+			return fmt.Errorf("Setting MTU %d failed: %s", config.mtu, err)
+		}
+	}
+	if config.addr != nil {
+		req := newIfReq(name)
+		req.setSockaddr(config.addr.IP.To4())
+		if err := ioctl(sock, siocSIfAddr, req); err != nil {
+			return fmt.Errorf("Setting address %s failed: %s", config.addr, err)
+		}
+		req = newIfReq(name)
+		req.setSockaddr(config.addr.Mask)
+		if err := ioctl(sock, siocSIfNetmask, req); err != nil {
+			return fmt.Errorf("Setting netmask of %s failed: %s", config.addr, err)
+		}
+	}
+	req := newIfReq(name)
+	if err := ioctl(sock, siocGIfFlags, req); err != nil {
+		return err
+	}
+	flags := nativeEndian.Uint16(req[syscall.IFNAMSIZ:])
+	nativeEndian.PutUint16(req[syscall.IFNAMSIZ:], flags|syscall.IFF_UP|syscall.IFF_RUNNING)
+	if err := ioctl(sock, siocSIfFlags, req); err != nil {
+		return fmt.Errorf("Bringing the device up failed: %s", err)
+	}
+	return nil
+}
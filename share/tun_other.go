@@ -0,0 +1,13 @@
+//+build !linux
+
+package chshare
+
+import (
+	"fmt"
+	"os"
+)
+
+// openTUNDevice is not implemented on this platform
+func openTUNDevice(name string, config *tunDeviceConfig) (*os.File, error) {
+	return nil, fmt.Errorf("TUN endpoints are not supported on this platform")
+}
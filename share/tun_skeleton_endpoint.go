@@ -0,0 +1,87 @@
+package chshare
+
+import (
+	"context"
+)
+
+// TUNSkeletonEndpoint implements a local TUN skeleton. Each channel opens the device, creating
+// it if it does not exist, and closes it when the channel ends; a device that the skeleton
+// created then goes away. Only one channel at a time can use a device.
+type TUNSkeletonEndpoint struct {
+	// Implements LocalSkeletonChannelEndpoint
+	BasicEndpoint
+	config *tunDeviceConfig
+}
+
+// NewTUNSkeletonEndpoint creates a new TUNSkeletonEndpoint
+func NewTUNSkeletonEndpoint(logger Logger, ced *ChannelEndpointDescriptor) (*TUNSkeletonEndpoint, error) {
+	config, err := endpointTUNDeviceConfig(ced)
+	if err != nil {
+		return nil, err
+	}
+	ep := &TUNSkeletonEndpoint{
+		BasicEndpoint: BasicEndpoint{
+			ced: ced,
+		},
+		config: config,
+	}
+	ep.InitBasicEndpoint(logger, ep, "TUNSkeletonEndpoint: %s", ced)
+	return ep, nil
+}
+
+// HandleOnceShutdown will be called exactly once, in its own goroutine. It should take completionError
+// as an advisory completion value, actually shut down, then return the real completion value.
+func (ep *TUNSkeletonEndpoint) HandleOnceShutdown(completionErr error) error {
+	return completionErr
+}
+
+// Dial opens the TUN device, and returns a connection that carries its packets. Part of the
+// DialerChannelEndpoint interface
+func (ep *TUNSkeletonEndpoint) Dial(ctx context.Context, extraData []byte) (ChannelConn, error) {
+	ep.DLogf("Attaching to TUN device %s", ep.ced.Path)
+
+	if ep.IsStartedShutdown() {
+		err := ep.Errorf("Endpoint is closed: %s", ep.String())
+		return nil, err
+	}
+
+	dev, err := openTUNDevice(ep.ced.Path, ep.config)
+	if err != nil {
+		return nil, ep.Errorf("%s", err)
+	}
+
+	conn, err := NewSocketConn(ep.Logger, newUDPFramedConn(newTUNConn(dev, ep.ced.Path, nil)))
+	if err != nil {
+		dev.Close()
+		return nil, ep.Errorf("Unable to create SocketConn: %s", err)
+	}
+
+	ep.AddShutdownChild(conn)
+
+	ep.ILogf("Attached to TUN device %s", ep.ced.Path)
+	return conn, nil
+}
+
+// DialAndServe initiates a new connection to a Called Service as specified in the
+// endpoint configuration, then services the connection using an already established
+// callerConn as the proxied Caller's end of the session. This call does not return until
+// the bridged session completes or an error occurs. The context may be used to cancel
+// connection or servicing of the active session.
+// Ownership of callerConn is transferred to this function, and it will be closed before
+// this function returns, regardless of whether an error occurs.
+// The return value is a tuple consisting of:
+//        Number of bytes sent from callerConn to the dialed calledServiceConn
+//        Number of bytes sent from the dialed calledServiceConn callerConn
+//        An error, if one occured during dial or copy in either direction
+func (ep *TUNSkeletonEndpoint) DialAndServe(
+	ctx context.Context,
+	callerConn ChannelConn,
+	extraData []byte,
+) (int64, int64, error) {
+	calledServiceConn, err := ep.Dial(ctx, extraData)
+	if err != nil {
+		closeOnDialFailure(callerConn, err)
+		return 0, 0, err
+	}
+	return BasicBridgeChannels(ctx, ep.Logger, callerConn, calledServiceConn)
+}
@@ -0,0 +1,140 @@
+package chshare
+
+import (
+	"context"
+	"fmt"
+	"os"
+)
+
+// TUNStubEndpoint implements a local TUN stub. It opens its device once, and the device's
+// packets are carried by one channel at a time: once a channel ends, e.g. because the
+// connection to the remote proxy was lost, the next Accept returns the device again, so that
+// the tunnel is re-established without the device going down.
+type TUNStubEndpoint struct {
+	// Implements LocalStubChannelEndpoint
+	BasicEndpoint
+	config  *tunDeviceConfig
+	dev     *os.File
+	openErr error
+
+	// free holds a value while no channel is using the device
+	free chan struct{}
+}
+
+// NewTUNStubEndpoint creates a new TUNStubEndpoint
+func NewTUNStubEndpoint(logger Logger, ced *ChannelEndpointDescriptor) (*TUNStubEndpoint, error) {
+	config, err := endpointTUNDeviceConfig(ced)
+	if err != nil {
+		return nil, err
+	}
+	ep := &TUNStubEndpoint{
+		BasicEndpoint: BasicEndpoint{
+			ced: ced,
+		},
+		config: config,
+		free:   make(chan struct{}, 1),
+	}
+	ep.free <- struct{}{}
+	ep.InitBasicEndpoint(logger, ep, "TUNStubEndpoint: %s", ced)
+	return ep, nil
+}
+
+// HandleOnceShutdown will be called exactly once, in its own goroutine. It should take completionError
+// as an advisory completion value, actually shut down, then return the real completion value.
+func (ep *TUNStubEndpoint) HandleOnceShutdown(completionErr error) error {
+	ep.Lock.Lock()
+	dev := ep.dev
+	ep.dev = nil
+	ep.Lock.Unlock()
+
+	var err error
+	if dev != nil {
+		err = dev.Close()
+	}
+
+	if completionErr == nil {
+		completionErr = err
+	}
+	return completionErr
+}
+
+func (ep *TUNStubEndpoint) getDevice() (*os.File, error) {
+	var dev *os.File
+	var err error
+
+	ep.Lock.Lock()
+	{
+		if ep.IsStartedShutdown() {
+			err = fmt.Errorf("%s: Endpoint is closed", ep.Logger.Prefix())
+		} else if ep.dev == nil && ep.openErr == nil {
+			dev, err = openTUNDevice(ep.ced.Path, ep.config)
+			if err != nil {
+				err = fmt.Errorf("%s: %s", ep.Logger.Prefix(), err)
+			} else {
+				ep.dev = dev
+				ep.ILogf("Attached to TUN device %s", ep.ced.Path)
+			}
+			ep.openErr = err
+		} else {
+			dev = ep.dev
+			err = ep.openErr
+		}
+	}
+	ep.Lock.Unlock()
+
+	return dev, err
+}
+
+// StartListening begins responding to Caller network clients in anticipation of Accept() calls. It
+// is implicitly called by the first call to Accept() if not already called. It is only necessary to call
+// this method if you need to begin accepting Callers before you make the first Accept call. Part of
+// AcceptorChannelEndpoint interface.
+func (ep *TUNStubEndpoint) StartListening() error {
+	_, err := ep.getDevice()
+	return err
+}
+
+// Accept waits until no channel is using the device, and returns a connection that carries
+// its packets. There is no way to cancel an Accept() request other than closing the endpoint. Part of
+// the AcceptorChannelEndpoint interface.
+func (ep *TUNStubEndpoint) Accept(ctx context.Context) (ChannelConn, error) {
+	dev, err := ep.getDevice()
+	if err != nil {
+		return nil, err
+	}
+
+	select {
+	case <-ep.free:
+	case <-ep.ShutdownStartedChan():
+		return nil, fmt.Errorf("%s: Endpoint is closed", ep.Logger.Prefix())
+	}
+
+	release := func() { ep.free <- struct{}{} }
+	conn, err := NewSocketConn(ep.Logger, newUDPFramedConn(newTUNConn(dev, ep.ced.Path, release)))
+	if err != nil {
+		release()
+		return nil, fmt.Errorf("%s: Unable to create SocketConn: %s", ep.Logger.Prefix(), err)
+	}
+	ep.AddShutdownChild(conn)
+	return conn, nil
+}
+
+// AcceptAndServe listens for and accepts a single connection from a Caller network client as specified in the
+// endpoint configuration, then services the connection using an already established
+// calledServiceConn as the proxied Called Service's end of the session. This call does not return until
+// the bridged session completes or an error occurs. There is no way to cancel the Accept() portion
+// of the request other than closing the endpoint through other means. After the connection has been
+// accepted, the context may be used to cancel servicing of the active session.
+// Ownership of calledServiceConn is transferred to this function, and it will be closed before this function returns.
+// The return value is a tuple consisting of:
+//        Number of bytes sent from the accepted callerConn to calledServiceConn
+//        Number of bytes sent from calledServiceConn to the accelpted callerConn
+//        An error, if one occured during accept or copy in either direction
+func (ep *TUNStubEndpoint) AcceptAndServe(ctx context.Context, calledServiceConn ChannelConn) (int64, int64, error) {
+	callerConn, err := ep.Accept(ctx)
+	if err != nil {
+		calledServiceConn.Close()
+		return 0, 0, err
+	}
+	return BasicBridgeChannels(ctx, ep.Logger, callerConn, calledServiceConn)
+}
@@ -0,0 +1,91 @@
+package chshare
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"sync/atomic"
+)
+
+// UDP endpoints carry datagrams over a channel's byte stream in frames: each datagram is sent
+// as its length, a 2-byte big-endian number, followed by its bytes. A channel between a UDP
+// endpoint and a TCP endpoint speaks the framing to the TCP side, which is how e.g. DNS is
+// carried over TCP.
+const udpFrameHeaderSize = 2
+
+// udpMaxDatagram is the largest datagram a frame can hold
+const udpMaxDatagram = 65535
+
+// udpFramedConn presents a net.Conn whose Read and Write each carry one whole datagram, such
+// as a connected *net.UDPConn, as a stream of frames
+type udpFramedConn struct {
+	net.Conn
+
+	// readBuf holds the frame being read, and pending the part of it not yet returned
+	readBuf []byte
+	pending []byte
+
+	// writeBuf holds the bytes written of a frame that is not yet complete
+	writeBuf []byte
+
+	closed int32
+}
+
+// newUDPFramedConn creates a udpFramedConn over a datagram conn
+func newUDPFramedConn(conn net.Conn) *udpFramedConn {
+	return &udpFramedConn{
+		Conn:    conn,
+		readBuf: make([]byte, udpFrameHeaderSize+udpMaxDatagram),
+	}
+}
+
+// Read returns the frame of the next datagram, or the rest of a frame that did not fit in p
+func (c *udpFramedConn) Read(p []byte) (int, error) {
+	if len(c.pending) == 0 {
+		n, err := c.Conn.Read(c.readBuf[udpFrameHeaderSize:])
+		if err != nil {
+			if atomic.LoadInt32(&c.closed) != 0 {
+				return 0, io.EOF
+			}
+			return 0, err
+		}
+		binary.BigEndian.PutUint16(c.readBuf, uint16(n))
+		c.pending = c.readBuf[:udpFrameHeaderSize+n]
+	}
+	n := copy(p, c.pending)
+	c.pending = c.pending[n:]
+	return n, nil
+}
+
+// Write sends each frame as a datagram once all of its bytes have been written
+func (c *udpFramedConn) Write(p []byte) (int, error) {
+	c.writeBuf = append(c.writeBuf, p...)
+	sent := 0
+	for len(c.writeBuf)-sent >= udpFrameHeaderSize {
+		end := sent + udpFrameHeaderSize + int(binary.BigEndian.Uint16(c.writeBuf[sent:]))
+		if len(c.writeBuf) < end {
+			break
+		}
+		if _, err := c.Conn.Write(c.writeBuf[sent+udpFrameHeaderSize : end]); err != nil {
+			return 0, err
+		}
+		sent = end
+	}
+	c.writeBuf = c.writeBuf[:copy(c.writeBuf, c.writeBuf[sent:])]
+	return len(p), nil
+}
+
+// CloseWrite closes the conn. A datagram flow cannot be half closed, and once the other side
+// of the channel has nothing more to send, no more replies are expected. Part of the
+// WriteHalfCloser interface.
+func (c *udpFramedConn) CloseWrite() error {
+	return c.Close()
+}
+
+// Close closes the conn, after which Read returns io.EOF
+func (c *udpFramedConn) Close() error {
+	if !atomic.CompareAndSwapInt32(&c.closed, 0, 1) {
+		return nil
+	}
+	return c.Conn.Close()
+}
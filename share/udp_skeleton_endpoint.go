@@ -0,0 +1,86 @@
+package chshare
+
+import (
+	"context"
+)
+
+// UDPSkeletonEndpoint implements a local UDP skeleton. Each channel gets its own socket,
+// connected to the Called Service, so that its replies return on the same channel.
+// Datagrams are sent directly, never from a dial source or through a dial proxy.
+type UDPSkeletonEndpoint struct {
+	// Implements LocalSkeletonChannelEndpoint
+	BasicEndpoint
+	sockOpts *SocketOptions
+}
+
+// NewUDPSkeletonEndpoint creates a new UDPSkeletonEndpoint
+func NewUDPSkeletonEndpoint(logger Logger, ced *ChannelEndpointDescriptor) (*UDPSkeletonEndpoint, error) {
+	sockOpts, err := EndpointSocketOptions(ced)
+	if err != nil {
+		return nil, err
+	}
+	ep := &UDPSkeletonEndpoint{
+		BasicEndpoint: BasicEndpoint{
+			ced: ced,
+		},
+		sockOpts: sockOpts,
+	}
+	ep.InitBasicEndpoint(logger, ep, "UDPSkeletonEndpoint: %s", ced)
+	return ep, nil
+}
+
+// HandleOnceShutdown will be called exactly once, in its own goroutine. It should take completionError
+// as an advisory completion value, actually shut down, then return the real completion value.
+func (ep *UDPSkeletonEndpoint) HandleOnceShutdown(completionErr error) error {
+	return completionErr
+}
+
+// Dial initiates a new connection to a Called Service. Part of the
+// DialerChannelEndpoint interface
+func (ep *UDPSkeletonEndpoint) Dial(ctx context.Context, extraData []byte) (ChannelConn, error) {
+	ep.DLogf("Dialing local UDP service at %s", ep.ced.Path)
+
+	if ep.IsStartedShutdown() {
+		err := ep.Errorf("Endpoint is closed: %s", ep.String())
+		return nil, err
+	}
+
+	netConn, err := ep.sockOpts.Dialer().DialContext(ctx, ep.sockOpts.UDPNetwork("udp"), ep.ced.Path)
+	if err != nil {
+		return nil, ep.Errorf("DialContext failed: %s", err)
+	}
+
+	conn, err := NewSocketConn(ep.Logger, newUDPFramedConn(netConn))
+	if err != nil {
+		return nil, ep.Errorf("Unable to create SocketConn: %s", err)
+	}
+
+	ep.AddShutdownChild(conn)
+
+	ep.DLogf("Connected to local UDP service %s", ep.String())
+	return conn, nil
+}
+
+// DialAndServe initiates a new connection to a Called Service as specified in the
+// endpoint configuration, then services the connection using an already established
+// callerConn as the proxied Caller's end of the session. This call does not return until
+// the bridged session completes or an error occurs. The context may be used to cancel
+// connection or servicing of the active session.
+// Ownership of callerConn is transferred to this function, and it will be closed before
+// this function returns, regardless of whether an error occurs.
+// The return value is a tuple consisting of:
+//        Number of bytes sent from callerConn to the dialed calledServiceConn
+//        Number of bytes sent from the dialed calledServiceConn callerConn
+//        An error, if one occured during dial or copy in either direction
+func (ep *UDPSkeletonEndpoint) DialAndServe(
+	ctx context.Context,
+	callerConn ChannelConn,
+	extraData []byte,
+) (int64, int64, error) {
+	calledServiceConn, err := ep.Dial(ctx, extraData)
+	if err != nil {
+		closeOnDialFailure(callerConn, err)
+		return 0, 0, err
+	}
+	return BasicBridgeChannels(ctx, ep.Logger, callerConn, calledServiceConn)
+}
@@ -0,0 +1,279 @@
+package chshare
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// udpPeerQueueLength is the number of datagrams from a peer of a UDP stub that may wait to be
+// sent on its channel; more are dropped, as a congested network would
+const udpPeerQueueLength = 64
+
+// udpAcceptBacklog is the number of new peers of a UDP stub that may wait to be accepted;
+// datagrams from further new peers are dropped
+const udpAcceptBacklog = 64
+
+// UDPStubEndpoint implements a local UDP stub. It listens on a single socket, and each peer
+// address that sends to it is accepted as a separate Caller, with its own channel. Replies on
+// the channel are sent back to the peer from the listening socket. Since a peer never closes
+// its flow, its channel is closed once it has been idle for the endpoint's idle timeout.
+type UDPStubEndpoint struct {
+	// Implements LocalStubChannelEndpoint
+	BasicEndpoint
+	sockOpts  *SocketOptions
+	listenErr error
+	conn      *net.UDPConn
+
+	// peers holds the peers with an open channel, or waiting to be accepted, by address
+	peers    map[string]*udpPeerConn
+	newPeers chan *udpPeerConn
+
+	// readDone is closed, after readErr is set, when the listening socket can no longer be read
+	readDone chan struct{}
+	readErr  error
+}
+
+// NewUDPStubEndpoint creates a new UDPStubEndpoint
+func NewUDPStubEndpoint(logger Logger, ced *ChannelEndpointDescriptor) (*UDPStubEndpoint, error) {
+	sockOpts, err := EndpointSocketOptions(ced)
+	if err != nil {
+		return nil, err
+	}
+	ep := &UDPStubEndpoint{
+		BasicEndpoint: BasicEndpoint{
+			ced: ced,
+		},
+		sockOpts: sockOpts,
+		peers:    make(map[string]*udpPeerConn),
+		newPeers: make(chan *udpPeerConn, udpAcceptBacklog),
+		readDone: make(chan struct{}),
+	}
+	ep.InitBasicEndpoint(logger, ep, "UDPStubEndpoint: %s", ced)
+	return ep, nil
+}
+
+// HandleOnceShutdown will be called exactly once, in its own goroutine. It should take completionError
+// as an advisory completion value, actually shut down, then return the real completion value.
+func (ep *UDPStubEndpoint) HandleOnceShutdown(completionErr error) error {
+	ep.Lock.Lock()
+	conn := ep.conn
+	ep.conn = nil
+	peers := make([]*udpPeerConn, 0, len(ep.peers))
+	for _, peer := range ep.peers {
+		peers = append(peers, peer)
+	}
+	ep.Lock.Unlock()
+
+	// Accepted peers are also closed as shutdown children, but those still waiting are not
+	for _, peer := range peers {
+		peer.Close()
+	}
+
+	var err error
+	if conn != nil {
+		err = conn.Close()
+	}
+
+	if completionErr == nil {
+		completionErr = err
+	}
+	return completionErr
+}
+
+func (ep *UDPStubEndpoint) getListener() (*net.UDPConn, error) {
+	var conn *net.UDPConn
+	var err error
+
+	ep.Lock.Lock()
+	{
+		if ep.IsStartedShutdown() {
+			err = fmt.Errorf("%s: Endpoint is closed", ep.Logger.Prefix())
+		} else if ep.conn == nil && ep.listenErr == nil {
+			network := "udp4"
+			if defaultListenNetwork(ep.ced.Path) == "tcp6" {
+				network = "udp6"
+			}
+			var packetConn net.PacketConn
+			packetConn, err = ep.sockOpts.ListenConfig().ListenPacket(context.Background(), ep.sockOpts.UDPNetwork(network), ep.ced.Path)
+			if err != nil {
+				err = fmt.Errorf("%s: UDP listen failed for path '%s': %s", ep.Logger.Prefix(), ep.ced.Path, err)
+			} else {
+				conn = packetConn.(*net.UDPConn)
+				ep.conn = conn
+				go ep.readLoop(conn)
+			}
+			ep.listenErr = err
+		} else {
+			conn = ep.conn
+			err = ep.listenErr
+		}
+	}
+	ep.Lock.Unlock()
+
+	return conn, err
+}
+
+// readLoop reads datagrams from the listening socket and queues them for their peers, until
+// the socket is closed or fails
+func (ep *UDPStubEndpoint) readLoop(conn *net.UDPConn) {
+	buf := make([]byte, udpMaxDatagram)
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			ep.readErr = err
+			close(ep.readDone)
+			return
+		}
+		ep.deliver(conn, addr, buf[:n])
+	}
+}
+
+// deliver queues a datagram for the peer that sent it, first offering a new peer to Accept
+func (ep *UDPStubEndpoint) deliver(conn *net.UDPConn, addr net.Addr, datagram []byte) {
+	key := addr.String()
+	ep.Lock.Lock()
+	peer, ok := ep.peers[key]
+	if !ok {
+		peer = &udpPeerConn{
+			ep:        ep,
+			conn:      conn,
+			addr:      addr,
+			datagrams: make(chan []byte, udpPeerQueueLength),
+			closed:    make(chan struct{}),
+		}
+		select {
+		case ep.newPeers <- peer:
+			ep.peers[key] = peer
+		default:
+			ep.Lock.Unlock()
+			ep.DLogf("Dropping datagram from new peer %s: too many peers waiting to be accepted", key)
+			return
+		}
+	}
+	ep.Lock.Unlock()
+
+	select {
+	case peer.datagrams <- append([]byte(nil), datagram...):
+	default:
+		ep.DLogf("Dropping datagram from %s: too many waiting to be sent", key)
+	}
+}
+
+// removePeer forgets a closed peer, so that its next datagram starts a new channel
+func (ep *UDPStubEndpoint) removePeer(peer *udpPeerConn) {
+	key := peer.addr.String()
+	ep.Lock.Lock()
+	if ep.peers[key] == peer {
+		delete(ep.peers, key)
+	}
+	ep.Lock.Unlock()
+}
+
+// StartListening begins responding to Caller network clients in anticipation of Accept() calls. It
+// is implicitly called by the first call to Accept() if not already called. It is only necessary to call
+// this method if you need to begin accepting Callers before you make the first Accept call. Part of
+// AcceptorChannelEndpoint interface.
+func (ep *UDPStubEndpoint) StartListening() error {
+	_, err := ep.getListener()
+	return err
+}
+
+// BoundAddr returns the address the endpoint is listening on, with any bind hostname
+// resolved, or "" if it is not listening. Part of the BoundAddrEndpoint interface.
+func (ep *UDPStubEndpoint) BoundAddr() string {
+	ep.Lock.Lock()
+	defer ep.Lock.Unlock()
+	if ep.conn == nil {
+		return ""
+	}
+	return ep.conn.LocalAddr().String()
+}
+
+// Accept waits for a datagram from a new peer, and returns a connection that carries the
+// datagrams from and to that peer. This call does not return until a new peer is available or a
+// error occurs. There is no way to cancel an Accept() request other than closing the endpoint. Part of
+// the AcceptorChannelEndpoint interface.
+func (ep *UDPStubEndpoint) Accept(ctx context.Context) (ChannelConn, error) {
+	_, err := ep.getListener()
+	if err != nil {
+		return nil, err
+	}
+
+	var peer *udpPeerConn
+	select {
+	case peer = <-ep.newPeers:
+	case <-ep.readDone:
+		return nil, fmt.Errorf("%s: Accept failed: %w", ep.Logger.Prefix(), ep.readErr)
+	}
+
+	conn, err := NewSocketConn(ep.Logger, newUDPFramedConn(peer))
+	if err != nil {
+		peer.Close()
+		return nil, fmt.Errorf("%s: Unable to create SocketConn: %s", ep.Logger.Prefix(), err)
+	}
+	ep.AddShutdownChild(conn)
+	return conn, nil
+}
+
+// AcceptAndServe listens for and accepts a single connection from a Caller network client as specified in the
+// endpoint configuration, then services the connection using an already established
+// calledServiceConn as the proxied Called Service's end of the session. This call does not return until
+// the bridged session completes or an error occurs. There is no way to cancel the Accept() portion
+// of the request other than closing the endpoint through other means. After the connection has been
+// accepted, the context may be used to cancel servicing of the active session.
+// Ownership of calledServiceConn is transferred to this function, and it will be closed before this function returns.
+// The return value is a tuple consisting of:
+//        Number of bytes sent from the accepted callerConn to calledServiceConn
+//        Number of bytes sent from calledServiceConn to the accelpted callerConn
+//        An error, if one occured during accept or copy in either direction
+func (ep *UDPStubEndpoint) AcceptAndServe(ctx context.Context, calledServiceConn ChannelConn) (int64, int64, error) {
+	callerConn, err := ep.Accept(ctx)
+	if err != nil {
+		calledServiceConn.Close()
+		return 0, 0, err
+	}
+	return BasicBridgeChannels(ctx, ep.Logger, callerConn, calledServiceConn)
+}
+
+// udpPeerConn is the datagram conn of one peer of a UDP stub: Read returns the next datagram
+// the peer sent, and Write sends a datagram to the peer
+type udpPeerConn struct {
+	ep        *UDPStubEndpoint
+	conn      *net.UDPConn
+	addr      net.Addr
+	datagrams chan []byte
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+func (c *udpPeerConn) Read(p []byte) (int, error) {
+	select {
+	case datagram := <-c.datagrams:
+		return copy(p, datagram), nil
+	case <-c.closed:
+		return 0, io.EOF
+	}
+}
+
+func (c *udpPeerConn) Write(p []byte) (int, error) {
+	return c.conn.WriteTo(p, c.addr)
+}
+
+// Close stops the peer's Read, and forgets the peer; the listening socket stays open
+func (c *udpPeerConn) Close() error {
+	c.closeOnce.Do(func() {
+		close(c.closed)
+		c.ep.removePeer(c)
+	})
+	return nil
+}
+
+func (c *udpPeerConn) LocalAddr() net.Addr                { return c.conn.LocalAddr() }
+func (c *udpPeerConn) RemoteAddr() net.Addr               { return c.addr }
+func (c *udpPeerConn) SetDeadline(t time.Time) error      { return nil }
+func (c *udpPeerConn) SetReadDeadline(t time.Time) error  { return nil }
+func (c *udpPeerConn) SetWriteDeadline(t time.Time) error { return nil }
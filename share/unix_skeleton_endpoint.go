@@ -10,14 +10,17 @@ import (
 type UnixSkeletonEndpoint struct {
 	// Implements LocalSkeletonChannelEndpoint
 	BasicEndpoint
+	dirs *UnixSocketDirs
 }
 
-// NewUnixSkeletonEndpoint creates a new UnixSkeletonEndpoint
-func NewUnixSkeletonEndpoint(logger Logger, ced *ChannelEndpointDescriptor) (*UnixSkeletonEndpoint, error) {
+// NewUnixSkeletonEndpoint creates a new UnixSkeletonEndpoint. If dirs is not nil, it may
+// only connect to sockets within them.
+func NewUnixSkeletonEndpoint(logger Logger, ced *ChannelEndpointDescriptor, dirs *UnixSocketDirs) (*UnixSkeletonEndpoint, error) {
 	ep := &UnixSkeletonEndpoint{
 		BasicEndpoint: BasicEndpoint{
 			ced: ced,
 		},
+		dirs: dirs,
 	}
 	ep.InitBasicEndpoint(logger, ep, "UnixSkeletonEndpoint: %s", ced)
 	return ep, nil
@@ -38,9 +41,12 @@ func (ep *UnixSkeletonEndpoint) Dial(ctx context.Context, extraData []byte) (Cha
 		return nil, err
 	}
 
-	// TODO: make sure IPV6 works
+	path, err := ep.dirs.Check(ep.ced.Path)
+	if err != nil {
+		return nil, ep.Errorf("%s", err)
+	}
 	var d net.Dialer
-	netConn, err := d.DialContext(ctx, "unix", ep.ced.Path)
+	netConn, err := d.DialContext(ctx, "unix", path)
 	if err != nil {
 		return nil, fmt.Errorf("%s: DialContext failed: %s", ep.Logger.Prefix(), err)
 	}
@@ -75,7 +81,7 @@ func (ep *UnixSkeletonEndpoint) DialAndServe(
 ) (int64, int64, error) {
 	calledServiceConn, err := ep.Dial(ctx, extraData)
 	if err != nil {
-		callerConn.Close()
+		closeOnDialFailure(callerConn, err)
 		return 0, 0, err
 	}
 	return BasicBridgeChannels(ctx, ep.Logger, callerConn, calledServiceConn)
@@ -0,0 +1,69 @@
+package chshare
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// UnixSocketDirs restricts the unix domain sockets that unix skeleton endpoints may connect
+// to to those within a set of directories, so that a peer cannot reach sensitive sockets
+// such as /run/docker.sock unless their directory is granted. Socket paths are resolved,
+// following symbolic links, before they are checked, and the resolved path is the one
+// connected to, so that a link cannot lead out of the directories.
+type UnixSocketDirs struct {
+	dirs []string
+}
+
+// ParseUnixSocketDirs parses a comma-separated list of directories
+func ParseUnixSocketDirs(s string) (*UnixSocketDirs, error) {
+	d := &UnixSocketDirs{}
+	for _, dir := range strings.Split(s, ",") {
+		dir = strings.TrimSpace(dir)
+		if dir == "" {
+			continue
+		}
+		resolved, err := resolveSocketPath(dir)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid unix socket directory '%s': %s", dir, err)
+		}
+		d.dirs = append(d.dirs, resolved)
+	}
+	if len(d.dirs) == 0 {
+		return nil, fmt.Errorf("Empty unix socket directory list")
+	}
+	return d, nil
+}
+
+func (d *UnixSocketDirs) String() string {
+	return strings.Join(d.dirs, ",")
+}
+
+// Check returns the resolved path of the unix domain socket at path, or an error if it is
+// not within one of the directories. A nil UnixSocketDirs allows any path, unresolved.
+func (d *UnixSocketDirs) Check(path string) (string, error) {
+	if d == nil {
+		return path, nil
+	}
+	resolved, err := resolveSocketPath(path)
+	if err != nil {
+		return "", fmt.Errorf("Unable to resolve unix socket path '%s': %s", path, err)
+	}
+	for _, dir := range d.dirs {
+		if rel, err := filepath.Rel(dir, resolved); err == nil && rel != ".." &&
+			!strings.HasPrefix(rel, ".."+string(os.PathSeparator)) {
+			return resolved, nil
+		}
+	}
+	return "", fmt.Errorf("Unix socket '%s' is not within the allowed directories", path)
+}
+
+// resolveSocketPath returns the absolute path of path with symbolic links followed
+func resolveSocketPath(path string) (string, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+	return filepath.EvalSymlinks(abs)
+}
@@ -91,7 +91,7 @@ func (ep *UnixStubEndpoint) Accept(ctx context.Context) (ChannelConn, error) {
 
 	netConn, err := listener.Accept()
 	if err != nil {
-		return nil, fmt.Errorf("%s: Accept failed: %s", ep.Logger.Prefix(), err)
+		return nil, fmt.Errorf("%s: Accept failed: %w", ep.Logger.Prefix(), err)
 	}
 
 	conn, err := NewSocketConn(ep.Logger, netConn)
@@ -0,0 +1,132 @@
+package chshare
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// UpgradeRejectionReason is the machine-readable reason for which the server refused a
+// client's connection request
+type UpgradeRejectionReason string
+
+const (
+	// UpgradeRejectedProtocol is the client using a protocol version the server does not
+	// support
+	UpgradeRejectedProtocol UpgradeRejectionReason = "protocol_unsupported"
+
+	// UpgradeRejectedDraining is the server not accepting new sessions while it drains
+	UpgradeRejectedDraining UpgradeRejectionReason = "draining"
+
+	// UpgradeRejectedLockedOut is the client's address being locked out after too many
+	// failed logins
+	UpgradeRejectedLockedOut UpgradeRejectionReason = "address_locked_out"
+
+	// UpgradeRejectedUpgradeLost is a websocket request reaching the server without its
+	// Upgrade header, as when a hop such as an HTTP/2-only ingress cannot pass it on. The
+	// client may still connect with another transport.
+	UpgradeRejectedUpgradeLost UpgradeRejectionReason = "upgrade_lost"
+
+	// UpgradeRejectedHTTP2Required is an HTTP/2 stream transport request reaching the server
+	// over HTTP/1.x, on which it cannot stream in both directions
+	UpgradeRejectedHTTP2Required UpgradeRejectionReason = "http2_required"
+)
+
+// drainingRetryAfter is how long a client refused by a draining server is told to wait
+// before retrying
+const drainingRetryAfter = 10 * time.Second
+
+// maxUpgradeRejectionSize is the size above which a rejection body is not parsed
+const maxUpgradeRejectionSize = 1024
+
+// UpgradeRejection is the JSON body of a response refusing a client's connection request,
+// so that the client can report why, and wait as long as the server suggests before
+// retrying
+type UpgradeRejection struct {
+	Reason  UpgradeRejectionReason `json:"reason"`
+	Message string                 `json:"message"`
+
+	// RetryAfter is the number of seconds to wait before retrying, or 0 for no hint
+	RetryAfter int `json:"retry_after,omitempty"`
+
+	// Protocol is the protocol version the server supports
+	Protocol string `json:"protocol,omitempty"`
+}
+
+func (r *UpgradeRejection) String() string {
+	return fmt.Sprintf("%s (%s)", r.Message, r.Reason)
+}
+
+// rejectUpgrade refuses a client's connection request with status and a JSON rejection
+// body, and a Retry-After header if the rejection has a hint
+func (s *Server) rejectUpgrade(w http.ResponseWriter, status int, rejection *UpgradeRejection) {
+	rejection.Protocol = ProtocolVersion
+	body, _ := json.Marshal(rejection)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if rejection.RetryAfter > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(rejection.RetryAfter))
+	}
+	w.WriteHeader(status)
+	w.Write(append(body, '\n'))
+}
+
+// checkUpgrade returns the rejection of a client's connection request from a locked out
+// address or while the server is draining, with its status, or nil if it may proceed
+func (s *Server) checkUpgrade(r *http.Request) (int, *UpgradeRejection) {
+	ip := r.RemoteAddr
+	if host, _, err := net.SplitHostPort(ip); err == nil {
+		ip = host
+	}
+	if d := s.authLimiter.IPLockout(ip); d > 0 {
+		s.DLogf("Refusing client connection from locked out address %s", ip)
+		return http.StatusTooManyRequests, &UpgradeRejection{
+			Reason:     UpgradeRejectedLockedOut,
+			Message:    "Too many failed logins from this address",
+			RetryAfter: retryAfterSeconds(d),
+		}
+	}
+	if s.IsDraining() {
+		s.DLogf("Refusing client connection while draining")
+		return http.StatusServiceUnavailable, &UpgradeRejection{
+			Reason:     UpgradeRejectedDraining,
+			Message:    "Server is draining",
+			RetryAfter: retryAfterSeconds(drainingRetryAfter),
+		}
+	}
+	return 0, nil
+}
+
+// retryAfterSeconds returns d in whole seconds, rounded up
+func retryAfterSeconds(d time.Duration) int {
+	return int((d + time.Second - 1) / time.Second)
+}
+
+// newHTTPStatusError returns the error for a connection request refused with resp, with
+// the server's rejection if the body is one, and its Retry-After hint
+func newHTTPStatusError(resp *http.Response) *HTTPStatusError {
+	err := &HTTPStatusError{StatusCode: resp.StatusCode, Status: resp.Status}
+	if secs, convErr := strconv.Atoi(resp.Header.Get("Retry-After")); convErr == nil && secs > 0 {
+		err.RetryAfter = time.Duration(secs) * time.Second
+	}
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if mediaType != "application/json" || resp.Body == nil {
+		return err
+	}
+	body, readErr := ioutil.ReadAll(io.LimitReader(resp.Body, maxUpgradeRejectionSize))
+	rejection := &UpgradeRejection{}
+	if readErr != nil || json.Unmarshal(body, rejection) != nil || rejection.Reason == "" {
+		return err
+	}
+	err.Rejection = rejection
+	if rejection.RetryAfter > 0 {
+		err.RetryAfter = time.Duration(rejection.RetryAfter) * time.Second
+	}
+	return err
+}
@@ -0,0 +1,131 @@
+package chshare
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// UpgradeTokenHeader is the HTTP header in which a client presents an upgrade token with each
+// request that opens a transport to the server
+const UpgradeTokenHeader = "X-Chisel-Upgrade-Token"
+
+// DefaultUpgradeTokenSkew is the default difference allowed between the clocks of a client
+// and the server when an upgrade token is checked
+const DefaultUpgradeTokenSkew = 2 * time.Minute
+
+// upgradeTokenPrefix versions the upgrade token format
+const upgradeTokenPrefix = "chup1."
+
+// upgradeTokenPruneSize is the number of remembered nonces above which those that have left
+// the skew window are forgotten
+const upgradeTokenPruneSize = 1024
+
+// UpgradeTokens issues and verifies the one-time tokens that a client and server sharing an
+// upgrade key use to authenticate the requests that open transports, before the SSH
+// handshake. A token holds the time it was issued and a random nonce, and is signed with
+// HMAC-SHA256. The server only accepts a token issued within the skew window of its own
+// clock, and only once, so that a captured request, unlike one carrying a static header such
+// as --require-header's, cannot be replayed to open another transport.
+type UpgradeTokens struct {
+	key   []byte
+	skew  time.Duration
+	clock Clock
+
+	// lock protects seen
+	lock sync.Mutex
+
+	// seen holds the nonces of the tokens accepted, with the time at which each token leaves
+	// the skew window and need no longer be remembered
+	seen map[string]time.Time
+}
+
+// NewUpgradeTokens creates an UpgradeTokens that signs tokens with a key derived from
+// secret, and accepts tokens issued within skew of the time read from clock
+func NewUpgradeTokens(secret string, skew time.Duration, clock Clock) *UpgradeTokens {
+	h := sha256.New()
+	h.Write([]byte("chisel upgrade token\x00"))
+	h.Write([]byte(secret))
+	return &UpgradeTokens{key: h.Sum(nil), skew: skew, clock: clock, seen: make(map[string]time.Time)}
+}
+
+func (u *UpgradeTokens) sign(body string) []byte {
+	mac := hmac.New(sha256.New, u.key)
+	mac.Write([]byte(body))
+	return mac.Sum(nil)
+}
+
+// Issue returns a new token
+func (u *UpgradeTokens) Issue() (string, error) {
+	var nonce [16]byte
+	if _, err := rand.Read(nonce[:]); err != nil {
+		return "", fmt.Errorf("Unable to generate upgrade token: %s", err)
+	}
+	body := upgradeTokenPrefix +
+		strconv.FormatInt(u.clock.Now().Unix(), 10) + "." +
+		base64.RawURLEncoding.EncodeToString(nonce[:])
+	return body + "." + base64.RawURLEncoding.EncodeToString(u.sign(body)), nil
+}
+
+// Verify checks a token's signature and time, and that it has not been presented before
+func (u *UpgradeTokens) Verify(token string) error {
+	i := strings.LastIndex(token, ".")
+	if !strings.HasPrefix(token, upgradeTokenPrefix) || i < 0 {
+		return fmt.Errorf("Malformed upgrade token")
+	}
+	body := token[:i]
+	mac, err := base64.RawURLEncoding.DecodeString(token[i+1:])
+	if err != nil || !hmac.Equal(mac, u.sign(body)) {
+		return fmt.Errorf("Invalid upgrade token signature")
+	}
+	parts := strings.Split(strings.TrimPrefix(body, upgradeTokenPrefix), ".")
+	if len(parts) != 2 {
+		return fmt.Errorf("Malformed upgrade token")
+	}
+	issued, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return fmt.Errorf("Malformed upgrade token time: %s", err)
+	}
+	issuedAt := time.Unix(issued, 0)
+	now := u.clock.Now()
+	if skew := now.Sub(issuedAt); skew > u.skew || skew < -u.skew {
+		return fmt.Errorf("Upgrade token issued at %s, outside %s of the server's time", issuedAt, u.skew)
+	}
+
+	u.lock.Lock()
+	defer u.lock.Unlock()
+	nonce := parts[1]
+	if _, ok := u.seen[nonce]; ok {
+		return fmt.Errorf("Upgrade token has already been used")
+	}
+	if len(u.seen) >= upgradeTokenPruneSize {
+		for n, forgetAt := range u.seen {
+			if now.After(forgetAt) {
+				delete(u.seen, n)
+			}
+		}
+	}
+	u.seen[nonce] = issuedAt.Add(u.skew)
+	return nil
+}
+
+// upgradeTokenOk returns true if a request that opens a transport carries an upgrade token
+// that has not been used before, or if the server has no upgrade key. Requests without one
+// are answered as if the server were not chisel, before any authentication.
+func (s *Server) upgradeTokenOk(r *http.Request) bool {
+	if s.upgradeTokens == nil {
+		return true
+	}
+	if err := s.upgradeTokens.Verify(r.Header.Get(UpgradeTokenHeader)); err != nil {
+		s.DLogf("Refusing client connection from %s: %s", r.RemoteAddr, err)
+		return false
+	}
+	return true
+}
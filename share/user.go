@@ -1,6 +1,8 @@
 package chshare
 
 import (
+	"crypto/sha256"
+	"crypto/subtle"
 	"regexp"
 	"strings"
 )
@@ -24,6 +26,74 @@ type User struct {
 	Name  string
 	Pass  string
 	Addrs []*regexp.Regexp
+
+	// MaxSessions is the number of sessions the user may have at once, or 0 for no limit
+	MaxSessions int
+
+	// Kick is true if a new session over the MaxSessions limit evicts the user's oldest
+	// session, rather than being refused
+	Kick bool
+
+	// CertAuth is true if the user may authenticate with a verified TLS client certificate
+	// whose common name is the user's name. Such users cannot log in with a password.
+	CertAuth bool
+
+	// Labels are arbitrary names and values describing the user, for channel policies
+	Labels map[string]string
+
+	// MaxChannelBytes is the number of bytes each of the user's channels may transfer, or 0
+	// for no limit
+	MaxChannelBytes int64
+
+	// Observe is true if the user may observe other users' channels read-only, if the server
+	// allows observation
+	Observe bool
+
+	// TokenGeneration is the generation of the user's reconnection tokens. Tokens issued for
+	// another generation are not accepted, so raising it revokes the user's tokens.
+	TokenGeneration int
+
+	// Pending is true if the user was provisioned automatically from a client certificate
+	// and has not yet been approved by an administrator
+	Pending bool
+
+	// Devices, if not empty, are the fingerprints of the only device keys with which the
+	// user's clients may connect (see LoadDeviceKey)
+	Devices []string
+
+	// MQTTTopics, if not nil, are the only topic prefixes that the user's channels to MQTT
+	// skeleton endpoints may use
+	MQTTTopics []string
+
+	// Record, if not "", is the "record" option value ("true", "cast" or "raw") with which
+	// the server records all of the user's channels, whatever their endpoints' own option
+	Record string
+}
+
+// HasDevice returns true if the user's sessions may use the device key with the given
+// fingerprint, or no device key if fingerprint is ""
+func (u *User) HasDevice(fingerprint string) bool {
+	if len(u.Devices) == 0 {
+		return true
+	}
+	for _, device := range u.Devices {
+		if strings.EqualFold(device, fingerprint) {
+			return true
+		}
+	}
+	return false
+}
+
+// dummyUser is checked against when authenticating an unknown username, so that unknown
+// and known usernames take the same time to reject
+var dummyUser = &User{Pass: "\x00chisel-no-such-user"}
+
+// CheckPassword returns true if password matches the user's password. The comparison
+// takes the same time regardless of where, or whether, the passwords differ.
+func (u *User) CheckPassword(password []byte) bool {
+	want := sha256.Sum256([]byte(u.Pass))
+	got := sha256.Sum256(password)
+	return subtle.ConstantTimeCompare(want[:], got[:]) == 1
 }
 
 // HasAccess returns True if a given address matches the allowed address patterns
@@ -7,6 +7,7 @@ import (
 	"io/ioutil"
 	"path/filepath"
 	"regexp"
+	"sort"
 	"sync"
 
 	"github.com/fsnotify/fsnotify"
@@ -59,11 +60,51 @@ func (u *Users) AddUser(user *User) {
 	u.Set(user.Name, user)
 }
 
+// All returns all users in the list, sorted by name
+func (u *Users) All() []*User {
+	u.RLock()
+	result := make([]*User, 0, len(u.inner))
+	for _, user := range u.inner {
+		result = append(result, user)
+	}
+	u.RUnlock()
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Name < result[j].Name
+	})
+	return result
+}
+
+// ParseUserAddrs compiles a list of address regular expressions for a user. An empty
+// string or "*" allows access to any address.
+func ParseUserAddrs(addrs []string) ([]*regexp.Regexp, error) {
+	result := make([]*regexp.Regexp, 0, len(addrs))
+	for _, r := range addrs {
+		if r == "" || r == "*" {
+			result = append(result, UserAllowAll)
+		} else {
+			re, err := regexp.Compile(r)
+			if err != nil {
+				return nil, errors.New("Invalid address regex")
+			}
+			result = append(result, re)
+		}
+	}
+	return result, nil
+}
+
 // UserIndex is a reloadable user source
 type UserIndex struct {
 	Logger
 	*Users
 	configFile string
+
+	// fileUsers holds the names of the users last loaded from configFile, so that users
+	// removed from the file are deleted when it is reloaded. It is protected by fileUsersLock.
+	fileUsersLock sync.Mutex
+	fileUsers     map[string]struct{}
+
+	// onChange, if not nil, is called after the users are changed
+	onChange func()
 }
 
 // NewUserIndex creates a source for users
@@ -74,6 +115,19 @@ func NewUserIndex(logger Logger) *UserIndex {
 	}
 }
 
+// OnChange sets a function to be called after the users are changed, by a reload of the
+// configuration file or a call to Changed
+func (u *UserIndex) OnChange(f func()) {
+	u.onChange = f
+}
+
+// Changed reports a change to the users, e.g. by the admin API, to the OnChange function
+func (u *UserIndex) Changed() {
+	if u.onChange != nil {
+		u.onChange()
+	}
+}
+
 // LoadUsers is responsible for loading users from a file
 func (u *UserIndex) LoadUsers(configFile string) error {
 	u.configFile = configFile
@@ -109,6 +163,7 @@ func (u *UserIndex) addWatchEvents() error {
 				u.ILogf("Failed to reload the users configuration: %s", err)
 			} else {
 				u.DLogf("Users configuration successfully reloaded from: %s", u.configFile)
+				u.Changed()
 			}
 		}
 	}()
@@ -124,29 +179,100 @@ func (u *UserIndex) loadUserIndex() error {
 	if err != nil {
 		return fmt.Errorf("Failed to read auth file: %s, error: %s", u.configFile, err)
 	}
-	var raw map[string][]string
+	var raw map[string]json.RawMessage
 	if err := json.Unmarshal(b, &raw); err != nil {
 		return errors.New("Invalid JSON: " + err.Error())
 	}
-	for auth, remotes := range raw {
+	users := make([]*User, 0, len(raw))
+	for auth, value := range raw {
 		user := &User{}
 		user.Name, user.Pass = ParseAuth(auth)
 		if user.Name == "" {
 			return errors.New("Invalid user:pass string")
 		}
-		for _, r := range remotes {
-			if r == "" || r == "*" {
-				user.Addrs = append(user.Addrs, UserAllowAll)
-			} else {
-				re, err := regexp.Compile(r)
-				if err != nil {
-					return errors.New("Invalid address regex")
-				}
-				user.Addrs = append(user.Addrs, re)
-			}
-
+		config, err := parseUserFileEntry(value)
+		if err != nil {
+			return fmt.Errorf("Invalid configuration for user \"%s\": %s", user.Name, err)
+		}
+		addrs, err := ParseUserAddrs(config.Addrs)
+		if err != nil {
+			return err
 		}
+		user.Addrs = addrs
+		user.MaxSessions = config.MaxSessions
+		user.Kick = config.Kick
+		user.CertAuth = config.CertAuth
+		user.Labels = config.Labels
+		user.MaxChannelBytes = int64(config.MaxChannelBytes)
+		user.Observe = config.Observe
+		user.Devices = config.Devices
+		user.MQTTTopics = config.MQTTTopics
+		user.Record = config.Record
+		user.TokenGeneration = config.TokenGeneration
+		users = append(users, user)
+	}
+	fileUsers := make(map[string]struct{}, len(users))
+	for _, user := range users {
 		u.Users.AddUser(user)
+		fileUsers[user.Name] = struct{}{}
+	}
+	u.fileUsersLock.Lock()
+	defer u.fileUsersLock.Unlock()
+	for name := range u.fileUsers {
+		if _, ok := fileUsers[name]; !ok {
+			u.Users.Del(name)
+		}
 	}
+	u.fileUsers = fileUsers
 	return nil
 }
+
+// IsFileUser returns true if a user was loaded from the configuration file, and so is
+// replaced by the file's version of the user whenever the file is reloaded
+func (u *UserIndex) IsFileUser(name string) bool {
+	u.fileUsersLock.Lock()
+	defer u.fileUsersLock.Unlock()
+	_, ok := u.fileUsers[name]
+	return ok
+}
+
+// userFileEntry is the configuration of a user in an auth file, in its object form
+type userFileEntry struct {
+	Addrs       []string          `json:"addrs"`
+	MaxSessions int               `json:"max_sessions"`
+	Kick        bool              `json:"kick"`
+	CertAuth    bool              `json:"cert"`
+	Labels      map[string]string `json:"labels"`
+	Observe     bool              `json:"observe"`
+	Devices     []string          `json:"devices"`
+	MQTTTopics  []string          `json:"mqtt_topics"`
+	Record      string            `json:"record"`
+
+	TokenGeneration int `json:"token_generation"`
+
+	MaxChannelBytes ByteCount `json:"max_channel_bytes"`
+}
+
+// parseUserFileEntry parses the value of a user in an auth file, which is either a list of
+// address regular expressions or a userFileEntry object
+func parseUserFileEntry(value json.RawMessage) (*userFileEntry, error) {
+	config := &userFileEntry{}
+	if err := json.Unmarshal(value, &config.Addrs); err == nil {
+		return config, nil
+	}
+	if err := json.Unmarshal(value, config); err != nil {
+		return nil, errors.New("expected a list of address regexes or an object")
+	}
+	if config.MaxSessions < 0 {
+		return nil, errors.New("max_sessions cannot be negative")
+	}
+	if config.Record == "false" {
+		config.Record = ""
+	}
+	if config.Record != "" {
+		if err := validateRecordOption(config.Record); err != nil {
+			return nil, err
+		}
+	}
+	return config, nil
+}
@@ -1,5 +1,11 @@
 package chshare
 
+import (
+	"runtime"
+	"runtime/debug"
+	"sort"
+)
+
 //ProtocolVersion of chisel. When backwards
 //incompatible changes are made, this will
 //be incremented to signify a protocol
@@ -8,3 +14,94 @@ const ProtocolVersion = "xevo-chisel-v1"
 
 // BuildVersion is the build version for this release
 var BuildVersion = "1.0.0-src"
+
+// BuildCommit is the git commit the release was built from, set with -ldflags like
+// BuildVersion. If it is not set, the commit recorded by the Go toolchain is used, if any.
+var BuildCommit = ""
+
+// Names of optional features, as reported in BuildInformation.Features
+const (
+	// FeatureSocks is the SOCKS5 proxy (socks remotes, and the server's --socks5)
+	FeatureSocks = "socks"
+
+	// FeatureLoop is the loop endpoints that connect one client's remote to another's
+	FeatureLoop = "loop"
+
+	// FeatureUDP is UDP remotes
+	FeatureUDP = "udp"
+
+	// FeatureTLS is TLS for the server's listener and client certificates
+	FeatureTLS = "tls"
+)
+
+// compiledFeatures holds whether each optional feature is compiled into this build
+var compiledFeatures = map[string]bool{
+	FeatureSocks: true,
+	FeatureLoop:  true,
+	FeatureUDP:   false,
+	FeatureTLS:   true,
+}
+
+// BuildInformation describes a build of chisel
+type BuildInformation struct {
+	// Version is BuildVersion
+	Version string
+
+	// Commit is the git commit the build was made from, or "" if it is not known
+	Commit string
+
+	// ProtocolVersion is the version of the protocol spoken between client and server,
+	// which must match
+	ProtocolVersion string
+
+	// GoVersion is the version of Go the build was made with
+	GoVersion string
+
+	// Features holds whether each optional feature (FeatureSocks etc.) is compiled in
+	Features map[string]bool
+}
+
+// BuildInfo returns a description of this build of chisel, for embedders and the admin API to
+// report its capabilities
+func BuildInfo() *BuildInformation {
+	info := &BuildInformation{
+		Version:         BuildVersion,
+		Commit:          BuildCommit,
+		ProtocolVersion: ProtocolVersion,
+		GoVersion:       runtime.Version(),
+		Features:        make(map[string]bool, len(compiledFeatures)),
+	}
+	if info.Commit == "" {
+		info.Commit = vcsRevision()
+	}
+	for name, enabled := range compiledFeatures {
+		info.Features[name] = enabled
+	}
+	return info
+}
+
+// EnabledFeatures returns the names of the features compiled in, sorted
+func (b *BuildInformation) EnabledFeatures() []string {
+	var names []string
+	for name, enabled := range b.Features {
+		if enabled {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// vcsRevision returns the VCS revision the Go toolchain recorded in the binary, or ""
+func vcsRevision() string {
+	bi, ok := debug.ReadBuildInfo()
+	if !ok {
+		return ""
+	}
+	for _, setting := range bi.Settings {
+		if setting.Key == "vcs.revision" {
+			return setting.Value
+		}
+	}
+	return ""
+}
@@ -0,0 +1,74 @@
+package chshare
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// VSOCK endpoints connect to and listen on AF_VSOCK sockets, through which virtual machines
+// and their host talk without a network, e.g. Firecracker or cloud-hypervisor guests. An
+// endpoint's path is a context ID (CID) and a port:
+//
+//     vsock:<cid>:<port>
+//
+// The host is CID 2, and each guest has its own CID. A stub may listen with the CID "any",
+// to accept connections to all of the local machine's CIDs.
+
+// vsockCIDAny and vsockPortAny are the wildcard context ID and port
+const (
+	vsockCIDAny  = 0xffffffff
+	vsockPortAny = 0xffffffff
+)
+
+// VSOCKAddr is the address of a VSOCK socket
+type VSOCKAddr struct {
+	CID  uint32
+	Port uint32
+}
+
+// Network returns the address's network name. Part of the net.Addr interface
+func (a *VSOCKAddr) Network() string { return "vsock" }
+
+// String returns the address in the form <cid>:<port>. Part of the net.Addr interface
+func (a *VSOCKAddr) String() string {
+	cid := strconv.FormatUint(uint64(a.CID), 10)
+	if a.CID == vsockCIDAny {
+		cid = "any"
+	}
+	return cid + ":" + strconv.FormatUint(uint64(a.Port), 10)
+}
+
+// ParseVSOCKAddr parses a VSOCK endpoint path of the form <cid>:<port>
+func ParseVSOCKAddr(path string) (*VSOCKAddr, error) {
+	parts := strings.Split(path, ":")
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("Invalid VSOCK address '%s'; expected <cid>:<port>", path)
+	}
+	addr := &VSOCKAddr{CID: vsockCIDAny}
+	if parts[0] != "any" {
+		cid, err := strconv.ParseUint(parts[0], 10, 32)
+		if err != nil || cid == vsockCIDAny {
+			return nil, fmt.Errorf("Invalid VSOCK context ID '%s'; expected a number or 'any'", parts[0])
+		}
+		addr.CID = uint32(cid)
+	}
+	port, err := strconv.ParseUint(parts[1], 10, 32)
+	if err != nil || port == vsockPortAny {
+		return nil, fmt.Errorf("Invalid VSOCK port '%s'; expected a number below %d", parts[1], uint32(vsockPortAny))
+	}
+	addr.Port = uint32(port)
+	return addr, nil
+}
+
+// validateVSOCKEndpoint validates the path of a VSOCK endpoint of the given role
+func validateVSOCKEndpoint(role ChannelEndpointRole, path string) error {
+	addr, err := ParseVSOCKAddr(path)
+	if err != nil {
+		return err
+	}
+	if role == ChannelEndpointRoleSkeleton && addr.CID == vsockCIDAny {
+		return fmt.Errorf("A VSOCK skeleton endpoint requires a context ID to connect to, not 'any'")
+	}
+	return nil
+}
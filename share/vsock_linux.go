@@ -0,0 +1,229 @@
+//+build linux,!386
+
+package chshare
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// afVSOCK is AF_VSOCK, which the syscall package does not define on every architecture
+const afVSOCK = 40
+
+// rawSockaddrVM is a struct sockaddr_vm
+type rawSockaddrVM struct {
+	family    uint16
+	reserved1 uint16
+	port      uint32
+	cid       uint32
+	zero      [4]uint8
+}
+
+func newRawSockaddrVM(addr *VSOCKAddr) *rawSockaddrVM {
+	return &rawSockaddrVM{family: afVSOCK, port: addr.Port, cid: addr.CID}
+}
+
+// sockaddrCall makes a socket syscall that takes a struct sockaddr and its length, i.e.
+// connect or bind
+func sockaddrCall(trap uintptr, fd int, sa *rawSockaddrVM) error {
+	_, _, errno := syscall.Syscall(trap, uintptr(fd), uintptr(unsafe.Pointer(sa)), unsafe.Sizeof(*sa))
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// vsockSocketAddr returns the address of a VSOCK socket, or of its peer, as returned by the
+// getsockname or getpeername syscall
+func vsockSocketAddr(trap uintptr, fd int) *VSOCKAddr {
+	var sa rawSockaddrVM
+	n := uint32(unsafe.Sizeof(sa))
+	_, _, errno := syscall.Syscall(trap, uintptr(fd), uintptr(unsafe.Pointer(&sa)), uintptr(unsafe.Pointer(&n)))
+	if errno != 0 {
+		return &VSOCKAddr{CID: vsockCIDAny, Port: vsockPortAny}
+	}
+	return &VSOCKAddr{CID: sa.cid, Port: sa.port}
+}
+
+func newVSOCKSocket() (int, error) {
+	return syscall.Socket(afVSOCK, syscall.SOCK_STREAM|syscall.SOCK_NONBLOCK|syscall.SOCK_CLOEXEC, 0)
+}
+
+// dialVSOCK connects to a VSOCK address. The context may be used to cancel the connection.
+func dialVSOCK(ctx context.Context, addr *VSOCKAddr) (net.Conn, error) {
+	fd, err := newVSOCKSocket()
+	if err != nil {
+		return nil, fmt.Errorf("Unable to create VSOCK socket: %s", err)
+	}
+	err = sockaddrCall(syscall.SYS_CONNECT, fd, newRawSockaddrVM(addr))
+	if err != nil && err != syscall.EINPROGRESS {
+		syscall.Close(fd)
+		return nil, fmt.Errorf("Unable to connect to VSOCK %s: %s", addr, err)
+	}
+	f := os.NewFile(uintptr(fd), "vsock:"+addr.String())
+	if err == syscall.EINPROGRESS {
+		if err := waitVSOCKConnect(ctx, f); err != nil {
+			f.Close()
+			return nil, fmt.Errorf("Unable to connect to VSOCK %s: %s", addr, err)
+		}
+	}
+	return newVSOCKConn(f, fd), nil
+}
+
+// waitVSOCKConnect waits for a non-blocking connect to complete, and returns its result
+func waitVSOCKConnect(ctx context.Context, f *os.File) error {
+	rc, err := f.SyscallConn()
+	if err != nil {
+		return err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		f.SetWriteDeadline(deadline)
+	}
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			f.SetWriteDeadline(time.Unix(1, 0))
+		case <-done:
+		}
+	}()
+
+	waited := false
+	var connectErr error
+	err = rc.Write(func(fd uintptr) bool {
+		if !waited {
+			// wait until the socket is writable before checking the result
+			waited = true
+			return false
+		}
+		soErr, err := syscall.GetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_ERROR)
+		if err != nil {
+			connectErr = err
+		} else if soErr != 0 {
+			connectErr = syscall.Errno(soErr)
+		}
+		return true
+	})
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if err != nil {
+		return err
+	}
+	if connectErr != nil {
+		return connectErr
+	}
+	return f.SetWriteDeadline(time.Time{})
+}
+
+// listenVSOCK listens on a VSOCK address
+func listenVSOCK(addr *VSOCKAddr) (net.Listener, error) {
+	fd, err := newVSOCKSocket()
+	if err != nil {
+		return nil, fmt.Errorf("Unable to create VSOCK socket: %s", err)
+	}
+	if err := sockaddrCall(syscall.SYS_BIND, fd, newRawSockaddrVM(addr)); err != nil {
+		syscall.Close(fd)
+		return nil, fmt.Errorf("Unable to bind to VSOCK %s: %s", addr, err)
+	}
+	if err := syscall.Listen(fd, syscall.SOMAXCONN); err != nil {
+		syscall.Close(fd)
+		return nil, fmt.Errorf("Unable to listen on VSOCK %s: %s", addr, err)
+	}
+	return &vsockListener{
+		f:    os.NewFile(uintptr(fd), "vsock:"+addr.String()),
+		addr: vsockSocketAddr(syscall.SYS_GETSOCKNAME, fd),
+	}, nil
+}
+
+// vsockListener is a net.Listener for VSOCK connections
+type vsockListener struct {
+	f    *os.File
+	addr *VSOCKAddr
+}
+
+// Accept waits for and returns the next connection. Part of the net.Listener interface
+func (l *vsockListener) Accept() (net.Conn, error) {
+	rc, err := l.f.SyscallConn()
+	if err != nil {
+		return nil, err
+	}
+	nfd := -1
+	var acceptErr error
+	err = rc.Read(func(fd uintptr) bool {
+		r, _, errno := syscall.Syscall6(syscall.SYS_ACCEPT4, fd, 0, 0, syscall.SOCK_NONBLOCK|syscall.SOCK_CLOEXEC, 0, 0)
+		if errno == syscall.EAGAIN || errno == syscall.EINTR {
+			return false
+		}
+		if errno != 0 {
+			acceptErr = errno
+		} else {
+			nfd = int(r)
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	if acceptErr != nil {
+		return nil, acceptErr
+	}
+	return newVSOCKConn(os.NewFile(uintptr(nfd), "vsock"), nfd), nil
+}
+
+// Close stops listening. Part of the net.Listener interface
+func (l *vsockListener) Close() error {
+	return l.f.Close()
+}
+
+// Addr returns the listener's address. Part of the net.Listener interface
+func (l *vsockListener) Addr() net.Addr {
+	return l.addr
+}
+
+// vsockConn is a net.Conn over a connected VSOCK socket
+type vsockConn struct {
+	f      *os.File
+	local  *VSOCKAddr
+	remote *VSOCKAddr
+}
+
+func newVSOCKConn(f *os.File, fd int) *vsockConn {
+	return &vsockConn{
+		f:      f,
+		local:  vsockSocketAddr(syscall.SYS_GETSOCKNAME, fd),
+		remote: vsockSocketAddr(syscall.SYS_GETPEERNAME, fd),
+	}
+}
+
+func (c *vsockConn) Read(p []byte) (int, error)  { return c.f.Read(p) }
+func (c *vsockConn) Write(p []byte) (int, error) { return c.f.Write(p) }
+func (c *vsockConn) Close() error                { return c.f.Close() }
+
+// CloseWrite shuts down the writing side of the connection
+func (c *vsockConn) CloseWrite() error {
+	rc, err := c.f.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var shutdownErr error
+	err = rc.Control(func(fd uintptr) {
+		shutdownErr = syscall.Shutdown(int(fd), syscall.SHUT_WR)
+	})
+	if err != nil {
+		return err
+	}
+	return shutdownErr
+}
+
+func (c *vsockConn) LocalAddr() net.Addr                { return c.local }
+func (c *vsockConn) RemoteAddr() net.Addr               { return c.remote }
+func (c *vsockConn) SetDeadline(t time.Time) error      { return c.f.SetDeadline(t) }
+func (c *vsockConn) SetReadDeadline(t time.Time) error  { return c.f.SetReadDeadline(t) }
+func (c *vsockConn) SetWriteDeadline(t time.Time) error { return c.f.SetWriteDeadline(t) }
@@ -0,0 +1,19 @@
+//+build !linux linux,386
+
+package chshare
+
+import (
+	"context"
+	"fmt"
+	"net"
+)
+
+// dialVSOCK is not implemented on this platform
+func dialVSOCK(ctx context.Context, addr *VSOCKAddr) (net.Conn, error) {
+	return nil, fmt.Errorf("VSOCK endpoints are not supported on this platform")
+}
+
+// listenVSOCK is not implemented on this platform
+func listenVSOCK(addr *VSOCKAddr) (net.Listener, error) {
+	return nil, fmt.Errorf("VSOCK endpoints are not supported on this platform")
+}
@@ -0,0 +1,328 @@
+// Command datapath benchmarks the channel data path within a single process: connections
+// accepted by a client stub listener are carried over an SSH channel to a server, whose
+// skeleton dials a local target, and the data is copied in each direction. It reports the
+// throughput of bulk transfers in each direction, and the rate at which channels are opened,
+// along with the CPU time and heap allocations they take, so that changes to the hot path can
+// be compared on the same hardware.
+//
+//    go run ./test/datapath [--size 256M] [--write 32K] [--conns 1] [--opens 1000]
+//        [--cpuprofile <file>] [--memprofile <file>]
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"os"
+	"runtime"
+	"runtime/pprof"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	chshare "github.com/XevoInc/chisel/share"
+)
+
+// The first byte a benchmark connection sends tells the target what to do with it
+const (
+	// opUpload discards what is sent until end of stream, then replies with its length
+	opUpload = 'u'
+
+	// opDownload reads a length, then sends that many bytes
+	opDownload = 'd'
+
+	// opEcho sends back what is sent until end of stream
+	opEcho = 'e'
+)
+
+// sample is the resources used by the process at a point in time
+type sample struct {
+	at      time.Time
+	cpu     time.Duration
+	mallocs uint64
+	bytes   uint64
+}
+
+func takeSample() sample {
+	runtime.GC()
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	var ru syscall.Rusage
+	syscall.Getrusage(syscall.RUSAGE_SELF, &ru)
+	return sample{
+		at:      time.Now(),
+		cpu:     time.Duration(ru.Utime.Nano() + ru.Stime.Nano()),
+		mallocs: m.Mallocs,
+		bytes:   m.TotalAlloc,
+	}
+}
+
+// report prints the rate of units of work done since start, and the resources used per unit
+func report(name string, start sample, units float64, unit string) {
+	end := takeSample()
+	elapsed := end.at.Sub(start.at)
+	fmt.Printf("%-9s %.0f %s in %s: %.1f %s/s, %.3f ms CPU/%s, %.1f allocs/%s, %.1f KB allocated/%s\n",
+		name, units, unit, elapsed.Round(time.Millisecond), units/elapsed.Seconds(), unit,
+		float64((end.cpu-start.cpu).Microseconds())/1000/units, unit,
+		float64(end.mallocs-start.mallocs)/units, unit,
+		float64(end.bytes-start.bytes)/1024/units, unit)
+}
+
+// serveTarget serves benchmark connections on l until it is closed
+func serveTarget(l net.Listener) {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			defer conn.Close()
+			op := make([]byte, 1)
+			if _, err := io.ReadFull(conn, op); err != nil {
+				return
+			}
+			switch op[0] {
+			case opUpload:
+				n, _ := io.Copy(ioutil.Discard, conn)
+				binary.Write(conn, binary.BigEndian, n)
+			case opDownload:
+				var n int64
+				if err := binary.Read(conn, binary.BigEndian, &n); err != nil {
+					return
+				}
+				buf := make([]byte, 32*1024)
+				for n > 0 {
+					chunk := buf
+					if int64(len(chunk)) > n {
+						chunk = chunk[:n]
+					}
+					if _, err := conn.Write(chunk); err != nil {
+						return
+					}
+					n -= int64(len(chunk))
+				}
+			case opEcho:
+				io.Copy(conn, conn)
+			}
+		}()
+	}
+}
+
+// upload sends size bytes through the stub in writes of up to len(buf) bytes
+func upload(stubAddr string, size int64, buf []byte) error {
+	conn, err := net.Dial("tcp", stubAddr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte{opUpload}); err != nil {
+		return err
+	}
+	for sent := int64(0); sent < size; {
+		chunk := buf
+		if int64(len(chunk)) > size-sent {
+			chunk = chunk[:size-sent]
+		}
+		n, err := conn.Write(chunk)
+		if err != nil {
+			return err
+		}
+		sent += int64(n)
+	}
+	conn.(*net.TCPConn).CloseWrite()
+	var received int64
+	if err := binary.Read(conn, binary.BigEndian, &received); err != nil {
+		return err
+	}
+	if received != size {
+		return fmt.Errorf("Target received %d bytes, expected %d", received, size)
+	}
+	return nil
+}
+
+// download receives size bytes through the stub in reads of up to len(buf) bytes
+func download(stubAddr string, size int64, buf []byte) error {
+	conn, err := net.Dial("tcp", stubAddr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte{opDownload}); err != nil {
+		return err
+	}
+	if err := binary.Write(conn, binary.BigEndian, size); err != nil {
+		return err
+	}
+	var received int64
+	for received < size {
+		n, err := conn.Read(buf)
+		received += int64(n)
+		if err != nil {
+			return fmt.Errorf("Received %d bytes, expected %d: %s", received, size, err)
+		}
+	}
+	return nil
+}
+
+// open opens a channel through the stub, and makes one round trip on it
+func open(stubAddr string) error {
+	conn, err := net.Dial("tcp", stubAddr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte{opEcho, 'x'}); err != nil {
+		return err
+	}
+	reply := make([]byte, 1)
+	_, err = io.ReadFull(conn, reply)
+	return err
+}
+
+// parallel runs f on conns connections at once
+func parallel(conns int, f func() error) error {
+	errs := make(chan error, conns)
+	var wg sync.WaitGroup
+	for i := 0; i < conns; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- f()
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// freePort returns a local TCP port that is not in use
+func freePort() string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer l.Close()
+	return strconv.Itoa(l.Addr().(*net.TCPAddr).Port)
+}
+
+func main() {
+	sizeFlag := flag.String("size", "256M", "bytes to transfer in each direction")
+	writeFlag := flag.String("write", "32K", "size of each write and read by the benchmark")
+	conns := flag.Int("conns", 1, "number of connections that share each transfer")
+	opens := flag.Int("opens", 1000, "number of channels to open one after another")
+	cpuProfile := flag.String("cpuprofile", "", "write a CPU profile of the benchmarks to this file")
+	memProfile := flag.String("memprofile", "", "write an allocation profile of the benchmarks to this file")
+	verbose := flag.Bool("v", false, "enable chisel's debug logging")
+	flag.Parse()
+	size, err := chshare.ParseByteCount(*sizeFlag)
+	if err != nil {
+		log.Fatal(err)
+	}
+	writeSize, err := chshare.ParseByteCount(*writeFlag)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	target, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer target.Close()
+	go serveTarget(target)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	serverPort := freePort()
+	server, err := chshare.NewServer(&chshare.ProxyServerConfig{
+		KeySeed: "datapath",
+		Debug:   *verbose,
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+	go func() {
+		if err := server.Run(ctx, "127.0.0.1", serverPort); err != nil {
+			log.Printf("Server exited: %s", err)
+		}
+	}()
+	stubAddr := "127.0.0.1:" + freePort()
+	client, err := chshare.NewClient(&chshare.Config{
+		Server:     "http://127.0.0.1:" + serverPort,
+		ChdStrings: []string{stubAddr + ":" + target.Addr().String()},
+		Debug:      *verbose,
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+	go func() {
+		if err := client.Run(ctx); err != nil {
+			log.Printf("Client exited: %s", err)
+		}
+	}()
+	for deadline := time.Now().Add(10 * time.Second); open(stubAddr) != nil; {
+		if time.Now().After(deadline) {
+			log.Fatal("Timed out waiting for the client to connect")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	if *cpuProfile != "" {
+		f, err := os.Create(*cpuProfile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer f.Close()
+		pprof.StartCPUProfile(f)
+		defer pprof.StopCPUProfile()
+	}
+	if *memProfile != "" {
+		runtime.MemProfileRate = 4096
+		defer func() {
+			f, err := os.Create(*memProfile)
+			if err != nil {
+				log.Fatal(err)
+			}
+			defer f.Close()
+			pprof.Lookup("allocs").WriteTo(f, 0)
+		}()
+	}
+
+	perConn := size / int64(*conns)
+	megabytes := float64(perConn*int64(*conns)) / 1e6
+	start := takeSample()
+	err = parallel(*conns, func() error {
+		return upload(stubAddr, perConn, make([]byte, writeSize))
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+	report("upload", start, megabytes, "MB")
+
+	start = takeSample()
+	err = parallel(*conns, func() error {
+		return download(stubAddr, perConn, make([]byte, writeSize))
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+	report("download", start, megabytes, "MB")
+
+	start = takeSample()
+	for i := 0; i < *opens; i++ {
+		if err := open(stubAddr); err != nil {
+			log.Fatal(err)
+		}
+	}
+	report("opens", start, float64(*opens), "open")
+}